@@ -152,31 +152,702 @@ func (x *StopRequest) GetForce() bool {
 	return false
 }
 
+type PruneRequest struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Rotate bool `protobuf:"varint,1,opt,name=rotate,proto3" json:"rotate,omitempty"` // Rotate the logs even if they are not over their maximum size
+}
+
+func (x *PruneRequest) Reset() {
+	*x = PruneRequest{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_adsys_proto_msgTypes[3]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *PruneRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*PruneRequest) ProtoMessage() {}
+
+func (x *PruneRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_adsys_proto_msgTypes[3]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use PruneRequest.ProtoReflect.Descriptor instead.
+func (*PruneRequest) Descriptor() ([]byte, []int) {
+	return file_adsys_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *PruneRequest) GetRotate() bool {
+	if x != nil {
+		return x.Rotate
+	}
+	return false
+}
+
 type StringResponse struct {
 	state         protoimpl.MessageState
 	sizeCache     protoimpl.SizeCache
 	unknownFields protoimpl.UnknownFields
 
-	Msg string `protobuf:"bytes,1,opt,name=msg,proto3" json:"msg,omitempty"`
+	Msg string `protobuf:"bytes,1,opt,name=msg,proto3" json:"msg,omitempty"`
+}
+
+func (x *StringResponse) Reset() {
+	*x = StringResponse{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_adsys_proto_msgTypes[4]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *StringResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*StringResponse) ProtoMessage() {}
+
+func (x *StringResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_adsys_proto_msgTypes[4]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use StringResponse.ProtoReflect.Descriptor instead.
+func (*StringResponse) Descriptor() ([]byte, []int) {
+	return file_adsys_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *StringResponse) GetMsg() string {
+	if x != nil {
+		return x.Msg
+	}
+	return ""
+}
+
+type UpdatePolicyRequest struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	IsComputer        bool   `protobuf:"varint,1,opt,name=isComputer,proto3" json:"isComputer,omitempty"`
+	All               bool   `protobuf:"varint,2,opt,name=all,proto3" json:"all,omitempty"` // Update policies of the machine and all the users
+	Target            string `protobuf:"bytes,3,opt,name=target,proto3" json:"target,omitempty"`
+	Krb5Cc            string `protobuf:"bytes,4,opt,name=krb5cc,proto3" json:"krb5cc,omitempty"`
+	Purge             bool   `protobuf:"varint,5,opt,name=purge,proto3" json:"purge,omitempty"`
+	IfOlderThan       int64  `protobuf:"varint,6,opt,name=ifOlderThan,proto3" json:"ifOlderThan,omitempty"`             // Only update if the policies were applied more than ifOlderThan seconds ago
+	FallbackToMachine bool   `protobuf:"varint,7,opt,name=fallbackToMachine,proto3" json:"fallbackToMachine,omitempty"` // Use the machine credentials for a user without a valid ticket, like one not logged in
+	AtLogin           bool   `protobuf:"varint,8,opt,name=atLogin,proto3" json:"atLogin,omitempty"`                     // Let the session start with the cached policies if the update exceeds the login timeout
+	Domain            string `protobuf:"bytes,9,opt,name=domain,proto3" json:"domain,omitempty"`                        // Purge the policies and the cache of all the users of this domain
+}
+
+func (x *UpdatePolicyRequest) Reset() {
+	*x = UpdatePolicyRequest{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_adsys_proto_msgTypes[5]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *UpdatePolicyRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*UpdatePolicyRequest) ProtoMessage() {}
+
+func (x *UpdatePolicyRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_adsys_proto_msgTypes[5]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use UpdatePolicyRequest.ProtoReflect.Descriptor instead.
+func (*UpdatePolicyRequest) Descriptor() ([]byte, []int) {
+	return file_adsys_proto_rawDescGZIP(), []int{5}
+}
+
+func (x *UpdatePolicyRequest) GetIsComputer() bool {
+	if x != nil {
+		return x.IsComputer
+	}
+	return false
+}
+
+func (x *UpdatePolicyRequest) GetAll() bool {
+	if x != nil {
+		return x.All
+	}
+	return false
+}
+
+func (x *UpdatePolicyRequest) GetTarget() string {
+	if x != nil {
+		return x.Target
+	}
+	return ""
+}
+
+func (x *UpdatePolicyRequest) GetKrb5Cc() string {
+	if x != nil {
+		return x.Krb5Cc
+	}
+	return ""
+}
+
+func (x *UpdatePolicyRequest) GetPurge() bool {
+	if x != nil {
+		return x.Purge
+	}
+	return false
+}
+
+func (x *UpdatePolicyRequest) GetIfOlderThan() int64 {
+	if x != nil {
+		return x.IfOlderThan
+	}
+	return 0
+}
+
+func (x *UpdatePolicyRequest) GetFallbackToMachine() bool {
+	if x != nil {
+		return x.FallbackToMachine
+	}
+	return false
+}
+
+func (x *UpdatePolicyRequest) GetAtLogin() bool {
+	if x != nil {
+		return x.AtLogin
+	}
+	return false
+}
+
+func (x *UpdatePolicyRequest) GetDomain() string {
+	if x != nil {
+		return x.Domain
+	}
+	return ""
+}
+
+type DumpPoliciesRequest struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Target         string `protobuf:"bytes,1,opt,name=target,proto3" json:"target,omitempty"`
+	IsComputer     bool   `protobuf:"varint,2,opt,name=isComputer,proto3" json:"isComputer,omitempty"`
+	Details        bool   `protobuf:"varint,3,opt,name=details,proto3" json:"details,omitempty"`                // Show rules in addition to GPO
+	All            bool   `protobuf:"varint,4,opt,name=all,proto3" json:"all,omitempty"`                        // Show overridden rules
+	Format         string `protobuf:"bytes,5,opt,name=format,proto3" json:"format,omitempty"`                   // Output format: text (default), json or yaml. Structured formats always contain all rules
+	CompareTarget  string `protobuf:"bytes,6,opt,name=compareTarget,proto3" json:"compareTarget,omitempty"`     // Show the differences with the policies of this other target instead
+	CompareExport  []byte `protobuf:"bytes,7,opt,name=compareExport,proto3" json:"compareExport,omitempty"`     // Show the differences with this json or yaml export of policies instead
+	Manager        string `protobuf:"bytes,8,opt,name=manager,proto3" json:"manager,omitempty"`                 // Only show the rules of this policy manager
+	Gpo            string `protobuf:"bytes,9,opt,name=gpo,proto3" json:"gpo,omitempty"`                         // Only show the GPO with this name or ID
+	OnlyOverridden bool   `protobuf:"varint,10,opt,name=onlyOverridden,proto3" json:"onlyOverridden,omitempty"` // Only show the overridden rules
+}
+
+func (x *DumpPoliciesRequest) Reset() {
+	*x = DumpPoliciesRequest{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_adsys_proto_msgTypes[6]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *DumpPoliciesRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*DumpPoliciesRequest) ProtoMessage() {}
+
+func (x *DumpPoliciesRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_adsys_proto_msgTypes[6]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use DumpPoliciesRequest.ProtoReflect.Descriptor instead.
+func (*DumpPoliciesRequest) Descriptor() ([]byte, []int) {
+	return file_adsys_proto_rawDescGZIP(), []int{6}
+}
+
+func (x *DumpPoliciesRequest) GetTarget() string {
+	if x != nil {
+		return x.Target
+	}
+	return ""
+}
+
+func (x *DumpPoliciesRequest) GetIsComputer() bool {
+	if x != nil {
+		return x.IsComputer
+	}
+	return false
+}
+
+func (x *DumpPoliciesRequest) GetDetails() bool {
+	if x != nil {
+		return x.Details
+	}
+	return false
+}
+
+func (x *DumpPoliciesRequest) GetAll() bool {
+	if x != nil {
+		return x.All
+	}
+	return false
+}
+
+func (x *DumpPoliciesRequest) GetFormat() string {
+	if x != nil {
+		return x.Format
+	}
+	return ""
+}
+
+func (x *DumpPoliciesRequest) GetCompareTarget() string {
+	if x != nil {
+		return x.CompareTarget
+	}
+	return ""
+}
+
+func (x *DumpPoliciesRequest) GetCompareExport() []byte {
+	if x != nil {
+		return x.CompareExport
+	}
+	return nil
+}
+
+func (x *DumpPoliciesRequest) GetManager() string {
+	if x != nil {
+		return x.Manager
+	}
+	return ""
+}
+
+func (x *DumpPoliciesRequest) GetGpo() string {
+	if x != nil {
+		return x.Gpo
+	}
+	return ""
+}
+
+func (x *DumpPoliciesRequest) GetOnlyOverridden() bool {
+	if x != nil {
+		return x.OnlyOverridden
+	}
+	return false
+}
+
+type DumpPolicyDefinitionsRequest struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Format   string `protobuf:"bytes,1,opt,name=format,proto3" json:"format,omitempty"`
+	DistroID string `protobuf:"bytes,2,opt,name=distroID,proto3" json:"distroID,omitempty"` // Force another distro than the built-in one
+}
+
+func (x *DumpPolicyDefinitionsRequest) Reset() {
+	*x = DumpPolicyDefinitionsRequest{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_adsys_proto_msgTypes[7]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *DumpPolicyDefinitionsRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*DumpPolicyDefinitionsRequest) ProtoMessage() {}
+
+func (x *DumpPolicyDefinitionsRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_adsys_proto_msgTypes[7]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use DumpPolicyDefinitionsRequest.ProtoReflect.Descriptor instead.
+func (*DumpPolicyDefinitionsRequest) Descriptor() ([]byte, []int) {
+	return file_adsys_proto_rawDescGZIP(), []int{7}
+}
+
+func (x *DumpPolicyDefinitionsRequest) GetFormat() string {
+	if x != nil {
+		return x.Format
+	}
+	return ""
+}
+
+func (x *DumpPolicyDefinitionsRequest) GetDistroID() string {
+	if x != nil {
+		return x.DistroID
+	}
+	return ""
+}
+
+type DumpPolicyDefinitionsResponse struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Admx string `protobuf:"bytes,1,opt,name=admx,proto3" json:"admx,omitempty"`
+	Adml string `protobuf:"bytes,2,opt,name=adml,proto3" json:"adml,omitempty"`
+}
+
+func (x *DumpPolicyDefinitionsResponse) Reset() {
+	*x = DumpPolicyDefinitionsResponse{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_adsys_proto_msgTypes[8]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *DumpPolicyDefinitionsResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*DumpPolicyDefinitionsResponse) ProtoMessage() {}
+
+func (x *DumpPolicyDefinitionsResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_adsys_proto_msgTypes[8]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use DumpPolicyDefinitionsResponse.ProtoReflect.Descriptor instead.
+func (*DumpPolicyDefinitionsResponse) Descriptor() ([]byte, []int) {
+	return file_adsys_proto_rawDescGZIP(), []int{8}
+}
+
+func (x *DumpPolicyDefinitionsResponse) GetAdmx() string {
+	if x != nil {
+		return x.Admx
+	}
+	return ""
+}
+
+func (x *DumpPolicyDefinitionsResponse) GetAdml() string {
+	if x != nil {
+		return x.Adml
+	}
+	return ""
+}
+
+type ListPolicyKeysRequest struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	DistroID string `protobuf:"bytes,1,opt,name=distroID,proto3" json:"distroID,omitempty"` // Force another distro than the built-in one
+	Manager  string `protobuf:"bytes,2,opt,name=manager,proto3" json:"manager,omitempty"`   // Only list keys consumed by this policy manager
+}
+
+func (x *ListPolicyKeysRequest) Reset() {
+	*x = ListPolicyKeysRequest{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_adsys_proto_msgTypes[9]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *ListPolicyKeysRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListPolicyKeysRequest) ProtoMessage() {}
+
+func (x *ListPolicyKeysRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_adsys_proto_msgTypes[9]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListPolicyKeysRequest.ProtoReflect.Descriptor instead.
+func (*ListPolicyKeysRequest) Descriptor() ([]byte, []int) {
+	return file_adsys_proto_rawDescGZIP(), []int{9}
+}
+
+func (x *ListPolicyKeysRequest) GetDistroID() string {
+	if x != nil {
+		return x.DistroID
+	}
+	return ""
+}
+
+func (x *ListPolicyKeysRequest) GetManager() string {
+	if x != nil {
+		return x.Manager
+	}
+	return ""
+}
+
+type SearchPoliciesRequest struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Query      string `protobuf:"bytes,1,opt,name=query,proto3" json:"query,omitempty"`
+	Target     string `protobuf:"bytes,2,opt,name=target,proto3" json:"target,omitempty"`
+	IsComputer bool   `protobuf:"varint,3,opt,name=isComputer,proto3" json:"isComputer,omitempty"`
+}
+
+func (x *SearchPoliciesRequest) Reset() {
+	*x = SearchPoliciesRequest{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_adsys_proto_msgTypes[10]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *SearchPoliciesRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SearchPoliciesRequest) ProtoMessage() {}
+
+func (x *SearchPoliciesRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_adsys_proto_msgTypes[10]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SearchPoliciesRequest.ProtoReflect.Descriptor instead.
+func (*SearchPoliciesRequest) Descriptor() ([]byte, []int) {
+	return file_adsys_proto_rawDescGZIP(), []int{10}
+}
+
+func (x *SearchPoliciesRequest) GetQuery() string {
+	if x != nil {
+		return x.Query
+	}
+	return ""
+}
+
+func (x *SearchPoliciesRequest) GetTarget() string {
+	if x != nil {
+		return x.Target
+	}
+	return ""
+}
+
+func (x *SearchPoliciesRequest) GetIsComputer() bool {
+	if x != nil {
+		return x.IsComputer
+	}
+	return false
+}
+
+type FreezePolicyRequest struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Duration int64 `protobuf:"varint,1,opt,name=duration,proto3" json:"duration,omitempty"` // Freeze policy updates for this number of seconds, 0 until unfrozen
+	Unfreeze bool  `protobuf:"varint,2,opt,name=unfreeze,proto3" json:"unfreeze,omitempty"` // Resume policy updates
+}
+
+func (x *FreezePolicyRequest) Reset() {
+	*x = FreezePolicyRequest{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_adsys_proto_msgTypes[11]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *FreezePolicyRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*FreezePolicyRequest) ProtoMessage() {}
+
+func (x *FreezePolicyRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_adsys_proto_msgTypes[11]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use FreezePolicyRequest.ProtoReflect.Descriptor instead.
+func (*FreezePolicyRequest) Descriptor() ([]byte, []int) {
+	return file_adsys_proto_rawDescGZIP(), []int{11}
+}
+
+func (x *FreezePolicyRequest) GetDuration() int64 {
+	if x != nil {
+		return x.Duration
+	}
+	return 0
+}
+
+func (x *FreezePolicyRequest) GetUnfreeze() bool {
+	if x != nil {
+		return x.Unfreeze
+	}
+	return false
+}
+
+type GetLastApplyStatusRequest struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Target     string `protobuf:"bytes,1,opt,name=target,proto3" json:"target,omitempty"`
+	IsComputer bool   `protobuf:"varint,2,opt,name=isComputer,proto3" json:"isComputer,omitempty"`
+	Format     string `protobuf:"bytes,3,opt,name=format,proto3" json:"format,omitempty"` // Output format: text (default), json or yaml
+}
+
+func (x *GetLastApplyStatusRequest) Reset() {
+	*x = GetLastApplyStatusRequest{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_adsys_proto_msgTypes[12]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *GetLastApplyStatusRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetLastApplyStatusRequest) ProtoMessage() {}
+
+func (x *GetLastApplyStatusRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_adsys_proto_msgTypes[12]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetLastApplyStatusRequest.ProtoReflect.Descriptor instead.
+func (*GetLastApplyStatusRequest) Descriptor() ([]byte, []int) {
+	return file_adsys_proto_rawDescGZIP(), []int{12}
+}
+
+func (x *GetLastApplyStatusRequest) GetTarget() string {
+	if x != nil {
+		return x.Target
+	}
+	return ""
+}
+
+func (x *GetLastApplyStatusRequest) GetIsComputer() bool {
+	if x != nil {
+		return x.IsComputer
+	}
+	return false
+}
+
+func (x *GetLastApplyStatusRequest) GetFormat() string {
+	if x != nil {
+		return x.Format
+	}
+	return ""
+}
+
+type WhoHasRequest struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Key   string `protobuf:"bytes,1,opt,name=key,proto3" json:"key,omitempty"`
+	Value string `protobuf:"bytes,2,opt,name=value,proto3" json:"value,omitempty"` // Only list users receiving this value
 }
 
-func (x *StringResponse) Reset() {
-	*x = StringResponse{}
+func (x *WhoHasRequest) Reset() {
+	*x = WhoHasRequest{}
 	if protoimpl.UnsafeEnabled {
-		mi := &file_adsys_proto_msgTypes[3]
+		mi := &file_adsys_proto_msgTypes[13]
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		ms.StoreMessageInfo(mi)
 	}
 }
 
-func (x *StringResponse) String() string {
+func (x *WhoHasRequest) String() string {
 	return protoimpl.X.MessageStringOf(x)
 }
 
-func (*StringResponse) ProtoMessage() {}
+func (*WhoHasRequest) ProtoMessage() {}
 
-func (x *StringResponse) ProtoReflect() protoreflect.Message {
-	mi := &file_adsys_proto_msgTypes[3]
+func (x *WhoHasRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_adsys_proto_msgTypes[13]
 	if protoimpl.UnsafeEnabled && x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -187,47 +858,52 @@ func (x *StringResponse) ProtoReflect() protoreflect.Message {
 	return mi.MessageOf(x)
 }
 
-// Deprecated: Use StringResponse.ProtoReflect.Descriptor instead.
-func (*StringResponse) Descriptor() ([]byte, []int) {
-	return file_adsys_proto_rawDescGZIP(), []int{3}
+// Deprecated: Use WhoHasRequest.ProtoReflect.Descriptor instead.
+func (*WhoHasRequest) Descriptor() ([]byte, []int) {
+	return file_adsys_proto_rawDescGZIP(), []int{13}
 }
 
-func (x *StringResponse) GetMsg() string {
+func (x *WhoHasRequest) GetKey() string {
 	if x != nil {
-		return x.Msg
+		return x.Key
 	}
 	return ""
 }
 
-type UpdatePolicyRequest struct {
+func (x *WhoHasRequest) GetValue() string {
+	if x != nil {
+		return x.Value
+	}
+	return ""
+}
+
+type OwnsRequest struct {
 	state         protoimpl.MessageState
 	sizeCache     protoimpl.SizeCache
 	unknownFields protoimpl.UnknownFields
 
-	IsComputer bool   `protobuf:"varint,1,opt,name=isComputer,proto3" json:"isComputer,omitempty"`
-	All        bool   `protobuf:"varint,2,opt,name=all,proto3" json:"all,omitempty"` // Update policies of the machine and all the users
-	Target     string `protobuf:"bytes,3,opt,name=target,proto3" json:"target,omitempty"`
-	Krb5Cc     string `protobuf:"bytes,4,opt,name=krb5cc,proto3" json:"krb5cc,omitempty"`
-	Purge      bool   `protobuf:"varint,5,opt,name=purge,proto3" json:"purge,omitempty"`
+	Path    string `protobuf:"bytes,1,opt,name=path,proto3" json:"path,omitempty"`       // Only report this file
+	Manager string `protobuf:"bytes,2,opt,name=manager,proto3" json:"manager,omitempty"` // Only list the files of this policy manager
+	Format  string `protobuf:"bytes,3,opt,name=format,proto3" json:"format,omitempty"`   // Output format: text (default), json or yaml. Structured formats list the files by policy manager
 }
 
-func (x *UpdatePolicyRequest) Reset() {
-	*x = UpdatePolicyRequest{}
+func (x *OwnsRequest) Reset() {
+	*x = OwnsRequest{}
 	if protoimpl.UnsafeEnabled {
-		mi := &file_adsys_proto_msgTypes[4]
+		mi := &file_adsys_proto_msgTypes[14]
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		ms.StoreMessageInfo(mi)
 	}
 }
 
-func (x *UpdatePolicyRequest) String() string {
+func (x *OwnsRequest) String() string {
 	return protoimpl.X.MessageStringOf(x)
 }
 
-func (*UpdatePolicyRequest) ProtoMessage() {}
+func (*OwnsRequest) ProtoMessage() {}
 
-func (x *UpdatePolicyRequest) ProtoReflect() protoreflect.Message {
-	mi := &file_adsys_proto_msgTypes[4]
+func (x *OwnsRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_adsys_proto_msgTypes[14]
 	if protoimpl.UnsafeEnabled && x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -238,74 +914,62 @@ func (x *UpdatePolicyRequest) ProtoReflect() protoreflect.Message {
 	return mi.MessageOf(x)
 }
 
-// Deprecated: Use UpdatePolicyRequest.ProtoReflect.Descriptor instead.
-func (*UpdatePolicyRequest) Descriptor() ([]byte, []int) {
-	return file_adsys_proto_rawDescGZIP(), []int{4}
-}
-
-func (x *UpdatePolicyRequest) GetIsComputer() bool {
-	if x != nil {
-		return x.IsComputer
-	}
-	return false
-}
-
-func (x *UpdatePolicyRequest) GetAll() bool {
-	if x != nil {
-		return x.All
-	}
-	return false
+// Deprecated: Use OwnsRequest.ProtoReflect.Descriptor instead.
+func (*OwnsRequest) Descriptor() ([]byte, []int) {
+	return file_adsys_proto_rawDescGZIP(), []int{14}
 }
 
-func (x *UpdatePolicyRequest) GetTarget() string {
+func (x *OwnsRequest) GetPath() string {
 	if x != nil {
-		return x.Target
+		return x.Path
 	}
 	return ""
 }
 
-func (x *UpdatePolicyRequest) GetKrb5Cc() string {
+func (x *OwnsRequest) GetManager() string {
 	if x != nil {
-		return x.Krb5Cc
+		return x.Manager
 	}
 	return ""
 }
 
-func (x *UpdatePolicyRequest) GetPurge() bool {
+func (x *OwnsRequest) GetFormat() string {
 	if x != nil {
-		return x.Purge
+		return x.Format
 	}
-	return false
+	return ""
 }
 
-type DumpPoliciesRequest struct {
+type SimulatePolicyRequest struct {
 	state         protoimpl.MessageState
 	sizeCache     protoimpl.SizeCache
 	unknownFields protoimpl.UnknownFields
 
 	Target     string `protobuf:"bytes,1,opt,name=target,proto3" json:"target,omitempty"`
 	IsComputer bool   `protobuf:"varint,2,opt,name=isComputer,proto3" json:"isComputer,omitempty"`
-	Details    bool   `protobuf:"varint,3,opt,name=details,proto3" json:"details,omitempty"` // Show rules in addition to GPO
-	All        bool   `protobuf:"varint,4,opt,name=all,proto3" json:"all,omitempty"`         // Show overridden rules
+	GpoID      string `protobuf:"bytes,3,opt,name=gpoID,proto3" json:"gpoID,omitempty"`
+	GpoName    string `protobuf:"bytes,4,opt,name=gpoName,proto3" json:"gpoName,omitempty"`
+	Policy     []byte `protobuf:"bytes,5,opt,name=policy,proto3" json:"policy,omitempty"` // Registry.pol content of the GPO for the target class
+	All        bool   `protobuf:"varint,6,opt,name=all,proto3" json:"all,omitempty"`      // Show overridden rules
 }
 
-func (x *DumpPoliciesRequest) Reset() {
-	*x = DumpPoliciesRequest{}
+func (x *SimulatePolicyRequest) Reset() {
+	*x = SimulatePolicyRequest{}
 	if protoimpl.UnsafeEnabled {
-		mi := &file_adsys_proto_msgTypes[5]
+		mi := &file_adsys_proto_msgTypes[15]
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		ms.StoreMessageInfo(mi)
 	}
 }
 
-func (x *DumpPoliciesRequest) String() string {
+func (x *SimulatePolicyRequest) String() string {
 	return protoimpl.X.MessageStringOf(x)
 }
 
-func (*DumpPoliciesRequest) ProtoMessage() {}
+func (*SimulatePolicyRequest) ProtoMessage() {}
 
-func (x *DumpPoliciesRequest) ProtoReflect() protoreflect.Message {
-	mi := &file_adsys_proto_msgTypes[5]
+func (x *SimulatePolicyRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_adsys_proto_msgTypes[15]
 	if protoimpl.UnsafeEnabled && x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -316,65 +980,78 @@ func (x *DumpPoliciesRequest) ProtoReflect() protoreflect.Message {
 	return mi.MessageOf(x)
 }
 
-// Deprecated: Use DumpPoliciesRequest.ProtoReflect.Descriptor instead.
-func (*DumpPoliciesRequest) Descriptor() ([]byte, []int) {
-	return file_adsys_proto_rawDescGZIP(), []int{5}
+// Deprecated: Use SimulatePolicyRequest.ProtoReflect.Descriptor instead.
+func (*SimulatePolicyRequest) Descriptor() ([]byte, []int) {
+	return file_adsys_proto_rawDescGZIP(), []int{15}
 }
 
-func (x *DumpPoliciesRequest) GetTarget() string {
+func (x *SimulatePolicyRequest) GetTarget() string {
 	if x != nil {
 		return x.Target
 	}
 	return ""
 }
 
-func (x *DumpPoliciesRequest) GetIsComputer() bool {
+func (x *SimulatePolicyRequest) GetIsComputer() bool {
 	if x != nil {
 		return x.IsComputer
 	}
 	return false
 }
 
-func (x *DumpPoliciesRequest) GetDetails() bool {
+func (x *SimulatePolicyRequest) GetGpoID() string {
 	if x != nil {
-		return x.Details
+		return x.GpoID
 	}
-	return false
+	return ""
 }
 
-func (x *DumpPoliciesRequest) GetAll() bool {
+func (x *SimulatePolicyRequest) GetGpoName() string {
+	if x != nil {
+		return x.GpoName
+	}
+	return ""
+}
+
+func (x *SimulatePolicyRequest) GetPolicy() []byte {
+	if x != nil {
+		return x.Policy
+	}
+	return nil
+}
+
+func (x *SimulatePolicyRequest) GetAll() bool {
 	if x != nil {
 		return x.All
 	}
 	return false
 }
 
-type DumpPolicyDefinitionsRequest struct {
+type DeferLogoutRequest struct {
 	state         protoimpl.MessageState
 	sizeCache     protoimpl.SizeCache
 	unknownFields protoimpl.UnknownFields
 
-	Format   string `protobuf:"bytes,1,opt,name=format,proto3" json:"format,omitempty"`
-	DistroID string `protobuf:"bytes,2,opt,name=distroID,proto3" json:"distroID,omitempty"` // Force another distro than the built-in one
+	Target string `protobuf:"bytes,1,opt,name=target,proto3" json:"target,omitempty"`
 }
 
-func (x *DumpPolicyDefinitionsRequest) Reset() {
-	*x = DumpPolicyDefinitionsRequest{}
+func (x *DeferLogoutRequest) Reset() {
+	*x = DeferLogoutRequest{}
 	if protoimpl.UnsafeEnabled {
-		mi := &file_adsys_proto_msgTypes[6]
+		mi := &file_adsys_proto_msgTypes[16]
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		ms.StoreMessageInfo(mi)
 	}
 }
 
-func (x *DumpPolicyDefinitionsRequest) String() string {
+func (x *DeferLogoutRequest) String() string {
 	return protoimpl.X.MessageStringOf(x)
 }
 
-func (*DumpPolicyDefinitionsRequest) ProtoMessage() {}
+func (*DeferLogoutRequest) ProtoMessage() {}
 
-func (x *DumpPolicyDefinitionsRequest) ProtoReflect() protoreflect.Message {
-	mi := &file_adsys_proto_msgTypes[6]
+func (x *DeferLogoutRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_adsys_proto_msgTypes[16]
 	if protoimpl.UnsafeEnabled && x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -385,51 +1062,43 @@ func (x *DumpPolicyDefinitionsRequest) ProtoReflect() protoreflect.Message {
 	return mi.MessageOf(x)
 }
 
-// Deprecated: Use DumpPolicyDefinitionsRequest.ProtoReflect.Descriptor instead.
-func (*DumpPolicyDefinitionsRequest) Descriptor() ([]byte, []int) {
-	return file_adsys_proto_rawDescGZIP(), []int{6}
-}
-
-func (x *DumpPolicyDefinitionsRequest) GetFormat() string {
-	if x != nil {
-		return x.Format
-	}
-	return ""
+// Deprecated: Use DeferLogoutRequest.ProtoReflect.Descriptor instead.
+func (*DeferLogoutRequest) Descriptor() ([]byte, []int) {
+	return file_adsys_proto_rawDescGZIP(), []int{16}
 }
 
-func (x *DumpPolicyDefinitionsRequest) GetDistroID() string {
+func (x *DeferLogoutRequest) GetTarget() string {
 	if x != nil {
-		return x.DistroID
+		return x.Target
 	}
 	return ""
 }
 
-type DumpPolicyDefinitionsResponse struct {
+type LimitScriptRequest struct {
 	state         protoimpl.MessageState
 	sizeCache     protoimpl.SizeCache
 	unknownFields protoimpl.UnknownFields
 
-	Admx string `protobuf:"bytes,1,opt,name=admx,proto3" json:"admx,omitempty"`
-	Adml string `protobuf:"bytes,2,opt,name=adml,proto3" json:"adml,omitempty"`
+	Pid uint32 `protobuf:"varint,1,opt,name=pid,proto3" json:"pid,omitempty"` // Script process started by the caller
 }
 
-func (x *DumpPolicyDefinitionsResponse) Reset() {
-	*x = DumpPolicyDefinitionsResponse{}
+func (x *LimitScriptRequest) Reset() {
+	*x = LimitScriptRequest{}
 	if protoimpl.UnsafeEnabled {
-		mi := &file_adsys_proto_msgTypes[7]
+		mi := &file_adsys_proto_msgTypes[17]
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		ms.StoreMessageInfo(mi)
 	}
 }
 
-func (x *DumpPolicyDefinitionsResponse) String() string {
+func (x *LimitScriptRequest) String() string {
 	return protoimpl.X.MessageStringOf(x)
 }
 
-func (*DumpPolicyDefinitionsResponse) ProtoMessage() {}
+func (*LimitScriptRequest) ProtoMessage() {}
 
-func (x *DumpPolicyDefinitionsResponse) ProtoReflect() protoreflect.Message {
-	mi := &file_adsys_proto_msgTypes[7]
+func (x *LimitScriptRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_adsys_proto_msgTypes[17]
 	if protoimpl.UnsafeEnabled && x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -440,23 +1109,16 @@ func (x *DumpPolicyDefinitionsResponse) ProtoReflect() protoreflect.Message {
 	return mi.MessageOf(x)
 }
 
-// Deprecated: Use DumpPolicyDefinitionsResponse.ProtoReflect.Descriptor instead.
-func (*DumpPolicyDefinitionsResponse) Descriptor() ([]byte, []int) {
-	return file_adsys_proto_rawDescGZIP(), []int{7}
-}
-
-func (x *DumpPolicyDefinitionsResponse) GetAdmx() string {
-	if x != nil {
-		return x.Admx
-	}
-	return ""
+// Deprecated: Use LimitScriptRequest.ProtoReflect.Descriptor instead.
+func (*LimitScriptRequest) Descriptor() ([]byte, []int) {
+	return file_adsys_proto_rawDescGZIP(), []int{17}
 }
 
-func (x *DumpPolicyDefinitionsResponse) GetAdml() string {
+func (x *LimitScriptRequest) GetPid() uint32 {
 	if x != nil {
-		return x.Adml
+		return x.Pid
 	}
-	return ""
+	return 0
 }
 
 type GetDocRequest struct {
@@ -470,7 +1132,7 @@ type GetDocRequest struct {
 func (x *GetDocRequest) Reset() {
 	*x = GetDocRequest{}
 	if protoimpl.UnsafeEnabled {
-		mi := &file_adsys_proto_msgTypes[8]
+		mi := &file_adsys_proto_msgTypes[18]
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		ms.StoreMessageInfo(mi)
 	}
@@ -483,7 +1145,7 @@ func (x *GetDocRequest) String() string {
 func (*GetDocRequest) ProtoMessage() {}
 
 func (x *GetDocRequest) ProtoReflect() protoreflect.Message {
-	mi := &file_adsys_proto_msgTypes[8]
+	mi := &file_adsys_proto_msgTypes[18]
 	if protoimpl.UnsafeEnabled && x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -496,7 +1158,7 @@ func (x *GetDocRequest) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use GetDocRequest.ProtoReflect.Descriptor instead.
 func (*GetDocRequest) Descriptor() ([]byte, []int) {
-	return file_adsys_proto_rawDescGZIP(), []int{8}
+	return file_adsys_proto_rawDescGZIP(), []int{18}
 }
 
 func (x *GetDocRequest) GetChapter() string {
@@ -517,7 +1179,7 @@ type ListDocRequest struct {
 func (x *ListDocRequest) Reset() {
 	*x = ListDocRequest{}
 	if protoimpl.UnsafeEnabled {
-		mi := &file_adsys_proto_msgTypes[9]
+		mi := &file_adsys_proto_msgTypes[19]
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		ms.StoreMessageInfo(mi)
 	}
@@ -530,7 +1192,7 @@ func (x *ListDocRequest) String() string {
 func (*ListDocRequest) ProtoMessage() {}
 
 func (x *ListDocRequest) ProtoReflect() protoreflect.Message {
-	mi := &file_adsys_proto_msgTypes[9]
+	mi := &file_adsys_proto_msgTypes[19]
 	if protoimpl.UnsafeEnabled && x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -543,7 +1205,7 @@ func (x *ListDocRequest) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use ListDocRequest.ProtoReflect.Descriptor instead.
 func (*ListDocRequest) Descriptor() ([]byte, []int) {
-	return file_adsys_proto_rawDescGZIP(), []int{9}
+	return file_adsys_proto_rawDescGZIP(), []int{19}
 }
 
 func (x *ListDocRequest) GetRaw() bool {
@@ -562,76 +1224,189 @@ var file_adsys_proto_rawDesc = []byte{
 	0x74, 0x69, 0x76, 0x65, 0x18, 0x01, 0x20, 0x01, 0x28, 0x08, 0x52, 0x06, 0x61, 0x63, 0x74, 0x69,
 	0x76, 0x65, 0x22, 0x23, 0x0a, 0x0b, 0x53, 0x74, 0x6f, 0x70, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73,
 	0x74, 0x12, 0x14, 0x0a, 0x05, 0x66, 0x6f, 0x72, 0x63, 0x65, 0x18, 0x01, 0x20, 0x01, 0x28, 0x08,
-	0x52, 0x05, 0x66, 0x6f, 0x72, 0x63, 0x65, 0x22, 0x22, 0x0a, 0x0e, 0x53, 0x74, 0x72, 0x69, 0x6e,
-	0x67, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x12, 0x10, 0x0a, 0x03, 0x6d, 0x73, 0x67,
-	0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x03, 0x6d, 0x73, 0x67, 0x22, 0x8d, 0x01, 0x0a, 0x13,
-	0x55, 0x70, 0x64, 0x61, 0x74, 0x65, 0x50, 0x6f, 0x6c, 0x69, 0x63, 0x79, 0x52, 0x65, 0x71, 0x75,
-	0x65, 0x73, 0x74, 0x12, 0x1e, 0x0a, 0x0a, 0x69, 0x73, 0x43, 0x6f, 0x6d, 0x70, 0x75, 0x74, 0x65,
-	0x72, 0x18, 0x01, 0x20, 0x01, 0x28, 0x08, 0x52, 0x0a, 0x69, 0x73, 0x43, 0x6f, 0x6d, 0x70, 0x75,
-	0x74, 0x65, 0x72, 0x12, 0x10, 0x0a, 0x03, 0x61, 0x6c, 0x6c, 0x18, 0x02, 0x20, 0x01, 0x28, 0x08,
-	0x52, 0x03, 0x61, 0x6c, 0x6c, 0x12, 0x16, 0x0a, 0x06, 0x74, 0x61, 0x72, 0x67, 0x65, 0x74, 0x18,
-	0x03, 0x20, 0x01, 0x28, 0x09, 0x52, 0x06, 0x74, 0x61, 0x72, 0x67, 0x65, 0x74, 0x12, 0x16, 0x0a,
-	0x06, 0x6b, 0x72, 0x62, 0x35, 0x63, 0x63, 0x18, 0x04, 0x20, 0x01, 0x28, 0x09, 0x52, 0x06, 0x6b,
-	0x72, 0x62, 0x35, 0x63, 0x63, 0x12, 0x14, 0x0a, 0x05, 0x70, 0x75, 0x72, 0x67, 0x65, 0x18, 0x05,
-	0x20, 0x01, 0x28, 0x08, 0x52, 0x05, 0x70, 0x75, 0x72, 0x67, 0x65, 0x22, 0x79, 0x0a, 0x13, 0x44,
-	0x75, 0x6d, 0x70, 0x50, 0x6f, 0x6c, 0x69, 0x63, 0x69, 0x65, 0x73, 0x52, 0x65, 0x71, 0x75, 0x65,
-	0x73, 0x74, 0x12, 0x16, 0x0a, 0x06, 0x74, 0x61, 0x72, 0x67, 0x65, 0x74, 0x18, 0x01, 0x20, 0x01,
-	0x28, 0x09, 0x52, 0x06, 0x74, 0x61, 0x72, 0x67, 0x65, 0x74, 0x12, 0x1e, 0x0a, 0x0a, 0x69, 0x73,
-	0x43, 0x6f, 0x6d, 0x70, 0x75, 0x74, 0x65, 0x72, 0x18, 0x02, 0x20, 0x01, 0x28, 0x08, 0x52, 0x0a,
-	0x69, 0x73, 0x43, 0x6f, 0x6d, 0x70, 0x75, 0x74, 0x65, 0x72, 0x12, 0x18, 0x0a, 0x07, 0x64, 0x65,
-	0x74, 0x61, 0x69, 0x6c, 0x73, 0x18, 0x03, 0x20, 0x01, 0x28, 0x08, 0x52, 0x07, 0x64, 0x65, 0x74,
-	0x61, 0x69, 0x6c, 0x73, 0x12, 0x10, 0x0a, 0x03, 0x61, 0x6c, 0x6c, 0x18, 0x04, 0x20, 0x01, 0x28,
-	0x08, 0x52, 0x03, 0x61, 0x6c, 0x6c, 0x22, 0x52, 0x0a, 0x1c, 0x44, 0x75, 0x6d, 0x70, 0x50, 0x6f,
-	0x6c, 0x69, 0x63, 0x79, 0x44, 0x65, 0x66, 0x69, 0x6e, 0x69, 0x74, 0x69, 0x6f, 0x6e, 0x73, 0x52,
-	0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x12, 0x16, 0x0a, 0x06, 0x66, 0x6f, 0x72, 0x6d, 0x61, 0x74,
-	0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x06, 0x66, 0x6f, 0x72, 0x6d, 0x61, 0x74, 0x12, 0x1a,
-	0x0a, 0x08, 0x64, 0x69, 0x73, 0x74, 0x72, 0x6f, 0x49, 0x44, 0x18, 0x02, 0x20, 0x01, 0x28, 0x09,
-	0x52, 0x08, 0x64, 0x69, 0x73, 0x74, 0x72, 0x6f, 0x49, 0x44, 0x22, 0x47, 0x0a, 0x1d, 0x44, 0x75,
-	0x6d, 0x70, 0x50, 0x6f, 0x6c, 0x69, 0x63, 0x79, 0x44, 0x65, 0x66, 0x69, 0x6e, 0x69, 0x74, 0x69,
-	0x6f, 0x6e, 0x73, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x12, 0x12, 0x0a, 0x04, 0x61,
-	0x64, 0x6d, 0x78, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x04, 0x61, 0x64, 0x6d, 0x78, 0x12,
-	0x12, 0x0a, 0x04, 0x61, 0x64, 0x6d, 0x6c, 0x18, 0x02, 0x20, 0x01, 0x28, 0x09, 0x52, 0x04, 0x61,
-	0x64, 0x6d, 0x6c, 0x22, 0x29, 0x0a, 0x0d, 0x47, 0x65, 0x74, 0x44, 0x6f, 0x63, 0x52, 0x65, 0x71,
-	0x75, 0x65, 0x73, 0x74, 0x12, 0x18, 0x0a, 0x07, 0x63, 0x68, 0x61, 0x70, 0x74, 0x65, 0x72, 0x18,
-	0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x07, 0x63, 0x68, 0x61, 0x70, 0x74, 0x65, 0x72, 0x22, 0x22,
-	0x0a, 0x0e, 0x4c, 0x69, 0x73, 0x74, 0x44, 0x6f, 0x63, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74,
-	0x12, 0x10, 0x0a, 0x03, 0x72, 0x61, 0x77, 0x18, 0x01, 0x20, 0x01, 0x28, 0x08, 0x52, 0x03, 0x72,
-	0x61, 0x77, 0x32, 0x96, 0x04, 0x0a, 0x07, 0x73, 0x65, 0x72, 0x76, 0x69, 0x63, 0x65, 0x12, 0x20,
-	0x0a, 0x03, 0x43, 0x61, 0x74, 0x12, 0x06, 0x2e, 0x45, 0x6d, 0x70, 0x74, 0x79, 0x1a, 0x0f, 0x2e,
-	0x53, 0x74, 0x72, 0x69, 0x6e, 0x67, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x30, 0x01,
-	0x12, 0x24, 0x0a, 0x07, 0x56, 0x65, 0x72, 0x73, 0x69, 0x6f, 0x6e, 0x12, 0x06, 0x2e, 0x45, 0x6d,
-	0x70, 0x74, 0x79, 0x1a, 0x0f, 0x2e, 0x53, 0x74, 0x72, 0x69, 0x6e, 0x67, 0x52, 0x65, 0x73, 0x70,
-	0x6f, 0x6e, 0x73, 0x65, 0x30, 0x01, 0x12, 0x23, 0x0a, 0x06, 0x53, 0x74, 0x61, 0x74, 0x75, 0x73,
+	0x52, 0x05, 0x66, 0x6f, 0x72, 0x63, 0x65, 0x22, 0x26, 0x0a, 0x0c, 0x50, 0x72, 0x75, 0x6e, 0x65,
+	0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x12, 0x16, 0x0a, 0x06, 0x72, 0x6f, 0x74, 0x61, 0x74,
+	0x65, 0x18, 0x01, 0x20, 0x01, 0x28, 0x08, 0x52, 0x06, 0x72, 0x6f, 0x74, 0x61, 0x74, 0x65, 0x22,
+	0x22, 0x0a, 0x0e, 0x53, 0x74, 0x72, 0x69, 0x6e, 0x67, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73,
+	0x65, 0x12, 0x10, 0x0a, 0x03, 0x6d, 0x73, 0x67, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x03,
+	0x6d, 0x73, 0x67, 0x22, 0x8f, 0x02, 0x0a, 0x13, 0x55, 0x70, 0x64, 0x61, 0x74, 0x65, 0x50, 0x6f,
+	0x6c, 0x69, 0x63, 0x79, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x12, 0x1e, 0x0a, 0x0a, 0x69,
+	0x73, 0x43, 0x6f, 0x6d, 0x70, 0x75, 0x74, 0x65, 0x72, 0x18, 0x01, 0x20, 0x01, 0x28, 0x08, 0x52,
+	0x0a, 0x69, 0x73, 0x43, 0x6f, 0x6d, 0x70, 0x75, 0x74, 0x65, 0x72, 0x12, 0x10, 0x0a, 0x03, 0x61,
+	0x6c, 0x6c, 0x18, 0x02, 0x20, 0x01, 0x28, 0x08, 0x52, 0x03, 0x61, 0x6c, 0x6c, 0x12, 0x16, 0x0a,
+	0x06, 0x74, 0x61, 0x72, 0x67, 0x65, 0x74, 0x18, 0x03, 0x20, 0x01, 0x28, 0x09, 0x52, 0x06, 0x74,
+	0x61, 0x72, 0x67, 0x65, 0x74, 0x12, 0x16, 0x0a, 0x06, 0x6b, 0x72, 0x62, 0x35, 0x63, 0x63, 0x18,
+	0x04, 0x20, 0x01, 0x28, 0x09, 0x52, 0x06, 0x6b, 0x72, 0x62, 0x35, 0x63, 0x63, 0x12, 0x14, 0x0a,
+	0x05, 0x70, 0x75, 0x72, 0x67, 0x65, 0x18, 0x05, 0x20, 0x01, 0x28, 0x08, 0x52, 0x05, 0x70, 0x75,
+	0x72, 0x67, 0x65, 0x12, 0x20, 0x0a, 0x0b, 0x69, 0x66, 0x4f, 0x6c, 0x64, 0x65, 0x72, 0x54, 0x68,
+	0x61, 0x6e, 0x18, 0x06, 0x20, 0x01, 0x28, 0x03, 0x52, 0x0b, 0x69, 0x66, 0x4f, 0x6c, 0x64, 0x65,
+	0x72, 0x54, 0x68, 0x61, 0x6e, 0x12, 0x2c, 0x0a, 0x11, 0x66, 0x61, 0x6c, 0x6c, 0x62, 0x61, 0x63,
+	0x6b, 0x54, 0x6f, 0x4d, 0x61, 0x63, 0x68, 0x69, 0x6e, 0x65, 0x18, 0x07, 0x20, 0x01, 0x28, 0x08,
+	0x52, 0x11, 0x66, 0x61, 0x6c, 0x6c, 0x62, 0x61, 0x63, 0x6b, 0x54, 0x6f, 0x4d, 0x61, 0x63, 0x68,
+	0x69, 0x6e, 0x65, 0x12, 0x18, 0x0a, 0x07, 0x61, 0x74, 0x4c, 0x6f, 0x67, 0x69, 0x6e, 0x18, 0x08,
+	0x20, 0x01, 0x28, 0x08, 0x52, 0x07, 0x61, 0x74, 0x4c, 0x6f, 0x67, 0x69, 0x6e, 0x12, 0x16, 0x0a,
+	0x06, 0x64, 0x6f, 0x6d, 0x61, 0x69, 0x6e, 0x18, 0x09, 0x20, 0x01, 0x28, 0x09, 0x52, 0x06, 0x64,
+	0x6f, 0x6d, 0x61, 0x69, 0x6e, 0x22, 0xb1, 0x02, 0x0a, 0x13, 0x44, 0x75, 0x6d, 0x70, 0x50, 0x6f,
+	0x6c, 0x69, 0x63, 0x69, 0x65, 0x73, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x12, 0x16, 0x0a,
+	0x06, 0x74, 0x61, 0x72, 0x67, 0x65, 0x74, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x06, 0x74,
+	0x61, 0x72, 0x67, 0x65, 0x74, 0x12, 0x1e, 0x0a, 0x0a, 0x69, 0x73, 0x43, 0x6f, 0x6d, 0x70, 0x75,
+	0x74, 0x65, 0x72, 0x18, 0x02, 0x20, 0x01, 0x28, 0x08, 0x52, 0x0a, 0x69, 0x73, 0x43, 0x6f, 0x6d,
+	0x70, 0x75, 0x74, 0x65, 0x72, 0x12, 0x18, 0x0a, 0x07, 0x64, 0x65, 0x74, 0x61, 0x69, 0x6c, 0x73,
+	0x18, 0x03, 0x20, 0x01, 0x28, 0x08, 0x52, 0x07, 0x64, 0x65, 0x74, 0x61, 0x69, 0x6c, 0x73, 0x12,
+	0x10, 0x0a, 0x03, 0x61, 0x6c, 0x6c, 0x18, 0x04, 0x20, 0x01, 0x28, 0x08, 0x52, 0x03, 0x61, 0x6c,
+	0x6c, 0x12, 0x16, 0x0a, 0x06, 0x66, 0x6f, 0x72, 0x6d, 0x61, 0x74, 0x18, 0x05, 0x20, 0x01, 0x28,
+	0x09, 0x52, 0x06, 0x66, 0x6f, 0x72, 0x6d, 0x61, 0x74, 0x12, 0x24, 0x0a, 0x0d, 0x63, 0x6f, 0x6d,
+	0x70, 0x61, 0x72, 0x65, 0x54, 0x61, 0x72, 0x67, 0x65, 0x74, 0x18, 0x06, 0x20, 0x01, 0x28, 0x09,
+	0x52, 0x0d, 0x63, 0x6f, 0x6d, 0x70, 0x61, 0x72, 0x65, 0x54, 0x61, 0x72, 0x67, 0x65, 0x74, 0x12,
+	0x24, 0x0a, 0x0d, 0x63, 0x6f, 0x6d, 0x70, 0x61, 0x72, 0x65, 0x45, 0x78, 0x70, 0x6f, 0x72, 0x74,
+	0x18, 0x07, 0x20, 0x01, 0x28, 0x0c, 0x52, 0x0d, 0x63, 0x6f, 0x6d, 0x70, 0x61, 0x72, 0x65, 0x45,
+	0x78, 0x70, 0x6f, 0x72, 0x74, 0x12, 0x18, 0x0a, 0x07, 0x6d, 0x61, 0x6e, 0x61, 0x67, 0x65, 0x72,
+	0x18, 0x08, 0x20, 0x01, 0x28, 0x09, 0x52, 0x07, 0x6d, 0x61, 0x6e, 0x61, 0x67, 0x65, 0x72, 0x12,
+	0x10, 0x0a, 0x03, 0x67, 0x70, 0x6f, 0x18, 0x09, 0x20, 0x01, 0x28, 0x09, 0x52, 0x03, 0x67, 0x70,
+	0x6f, 0x12, 0x26, 0x0a, 0x0e, 0x6f, 0x6e, 0x6c, 0x79, 0x4f, 0x76, 0x65, 0x72, 0x72, 0x69, 0x64,
+	0x64, 0x65, 0x6e, 0x18, 0x0a, 0x20, 0x01, 0x28, 0x08, 0x52, 0x0e, 0x6f, 0x6e, 0x6c, 0x79, 0x4f,
+	0x76, 0x65, 0x72, 0x72, 0x69, 0x64, 0x64, 0x65, 0x6e, 0x22, 0x52, 0x0a, 0x1c, 0x44, 0x75, 0x6d,
+	0x70, 0x50, 0x6f, 0x6c, 0x69, 0x63, 0x79, 0x44, 0x65, 0x66, 0x69, 0x6e, 0x69, 0x74, 0x69, 0x6f,
+	0x6e, 0x73, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x12, 0x16, 0x0a, 0x06, 0x66, 0x6f, 0x72,
+	0x6d, 0x61, 0x74, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x06, 0x66, 0x6f, 0x72, 0x6d, 0x61,
+	0x74, 0x12, 0x1a, 0x0a, 0x08, 0x64, 0x69, 0x73, 0x74, 0x72, 0x6f, 0x49, 0x44, 0x18, 0x02, 0x20,
+	0x01, 0x28, 0x09, 0x52, 0x08, 0x64, 0x69, 0x73, 0x74, 0x72, 0x6f, 0x49, 0x44, 0x22, 0x47, 0x0a,
+	0x1d, 0x44, 0x75, 0x6d, 0x70, 0x50, 0x6f, 0x6c, 0x69, 0x63, 0x79, 0x44, 0x65, 0x66, 0x69, 0x6e,
+	0x69, 0x74, 0x69, 0x6f, 0x6e, 0x73, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x12, 0x12,
+	0x0a, 0x04, 0x61, 0x64, 0x6d, 0x78, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x04, 0x61, 0x64,
+	0x6d, 0x78, 0x12, 0x12, 0x0a, 0x04, 0x61, 0x64, 0x6d, 0x6c, 0x18, 0x02, 0x20, 0x01, 0x28, 0x09,
+	0x52, 0x04, 0x61, 0x64, 0x6d, 0x6c, 0x22, 0x4d, 0x0a, 0x15, 0x4c, 0x69, 0x73, 0x74, 0x50, 0x6f,
+	0x6c, 0x69, 0x63, 0x79, 0x4b, 0x65, 0x79, 0x73, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x12,
+	0x1a, 0x0a, 0x08, 0x64, 0x69, 0x73, 0x74, 0x72, 0x6f, 0x49, 0x44, 0x18, 0x01, 0x20, 0x01, 0x28,
+	0x09, 0x52, 0x08, 0x64, 0x69, 0x73, 0x74, 0x72, 0x6f, 0x49, 0x44, 0x12, 0x18, 0x0a, 0x07, 0x6d,
+	0x61, 0x6e, 0x61, 0x67, 0x65, 0x72, 0x18, 0x02, 0x20, 0x01, 0x28, 0x09, 0x52, 0x07, 0x6d, 0x61,
+	0x6e, 0x61, 0x67, 0x65, 0x72, 0x22, 0x65, 0x0a, 0x15, 0x53, 0x65, 0x61, 0x72, 0x63, 0x68, 0x50,
+	0x6f, 0x6c, 0x69, 0x63, 0x69, 0x65, 0x73, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x12, 0x14,
+	0x0a, 0x05, 0x71, 0x75, 0x65, 0x72, 0x79, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x05, 0x71,
+	0x75, 0x65, 0x72, 0x79, 0x12, 0x16, 0x0a, 0x06, 0x74, 0x61, 0x72, 0x67, 0x65, 0x74, 0x18, 0x02,
+	0x20, 0x01, 0x28, 0x09, 0x52, 0x06, 0x74, 0x61, 0x72, 0x67, 0x65, 0x74, 0x12, 0x1e, 0x0a, 0x0a,
+	0x69, 0x73, 0x43, 0x6f, 0x6d, 0x70, 0x75, 0x74, 0x65, 0x72, 0x18, 0x03, 0x20, 0x01, 0x28, 0x08,
+	0x52, 0x0a, 0x69, 0x73, 0x43, 0x6f, 0x6d, 0x70, 0x75, 0x74, 0x65, 0x72, 0x22, 0x4d, 0x0a, 0x13,
+	0x46, 0x72, 0x65, 0x65, 0x7a, 0x65, 0x50, 0x6f, 0x6c, 0x69, 0x63, 0x79, 0x52, 0x65, 0x71, 0x75,
+	0x65, 0x73, 0x74, 0x12, 0x1a, 0x0a, 0x08, 0x64, 0x75, 0x72, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x18,
+	0x01, 0x20, 0x01, 0x28, 0x03, 0x52, 0x08, 0x64, 0x75, 0x72, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x12,
+	0x1a, 0x0a, 0x08, 0x75, 0x6e, 0x66, 0x72, 0x65, 0x65, 0x7a, 0x65, 0x18, 0x02, 0x20, 0x01, 0x28,
+	0x08, 0x52, 0x08, 0x75, 0x6e, 0x66, 0x72, 0x65, 0x65, 0x7a, 0x65, 0x22, 0x6b, 0x0a, 0x19, 0x47,
+	0x65, 0x74, 0x4c, 0x61, 0x73, 0x74, 0x41, 0x70, 0x70, 0x6c, 0x79, 0x53, 0x74, 0x61, 0x74, 0x75,
+	0x73, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x12, 0x16, 0x0a, 0x06, 0x74, 0x61, 0x72, 0x67,
+	0x65, 0x74, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x06, 0x74, 0x61, 0x72, 0x67, 0x65, 0x74,
+	0x12, 0x1e, 0x0a, 0x0a, 0x69, 0x73, 0x43, 0x6f, 0x6d, 0x70, 0x75, 0x74, 0x65, 0x72, 0x18, 0x02,
+	0x20, 0x01, 0x28, 0x08, 0x52, 0x0a, 0x69, 0x73, 0x43, 0x6f, 0x6d, 0x70, 0x75, 0x74, 0x65, 0x72,
+	0x12, 0x16, 0x0a, 0x06, 0x66, 0x6f, 0x72, 0x6d, 0x61, 0x74, 0x18, 0x03, 0x20, 0x01, 0x28, 0x09,
+	0x52, 0x06, 0x66, 0x6f, 0x72, 0x6d, 0x61, 0x74, 0x22, 0x37, 0x0a, 0x0d, 0x57, 0x68, 0x6f, 0x48,
+	0x61, 0x73, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x12, 0x10, 0x0a, 0x03, 0x6b, 0x65, 0x79,
+	0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x03, 0x6b, 0x65, 0x79, 0x12, 0x14, 0x0a, 0x05, 0x76,
+	0x61, 0x6c, 0x75, 0x65, 0x18, 0x02, 0x20, 0x01, 0x28, 0x09, 0x52, 0x05, 0x76, 0x61, 0x6c, 0x75,
+	0x65, 0x22, 0x53, 0x0a, 0x0b, 0x4f, 0x77, 0x6e, 0x73, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74,
+	0x12, 0x12, 0x0a, 0x04, 0x70, 0x61, 0x74, 0x68, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x04,
+	0x70, 0x61, 0x74, 0x68, 0x12, 0x18, 0x0a, 0x07, 0x6d, 0x61, 0x6e, 0x61, 0x67, 0x65, 0x72, 0x18,
+	0x02, 0x20, 0x01, 0x28, 0x09, 0x52, 0x07, 0x6d, 0x61, 0x6e, 0x61, 0x67, 0x65, 0x72, 0x12, 0x16,
+	0x0a, 0x06, 0x66, 0x6f, 0x72, 0x6d, 0x61, 0x74, 0x18, 0x03, 0x20, 0x01, 0x28, 0x09, 0x52, 0x06,
+	0x66, 0x6f, 0x72, 0x6d, 0x61, 0x74, 0x22, 0xa9, 0x01, 0x0a, 0x15, 0x53, 0x69, 0x6d, 0x75, 0x6c,
+	0x61, 0x74, 0x65, 0x50, 0x6f, 0x6c, 0x69, 0x63, 0x79, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74,
+	0x12, 0x16, 0x0a, 0x06, 0x74, 0x61, 0x72, 0x67, 0x65, 0x74, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09,
+	0x52, 0x06, 0x74, 0x61, 0x72, 0x67, 0x65, 0x74, 0x12, 0x1e, 0x0a, 0x0a, 0x69, 0x73, 0x43, 0x6f,
+	0x6d, 0x70, 0x75, 0x74, 0x65, 0x72, 0x18, 0x02, 0x20, 0x01, 0x28, 0x08, 0x52, 0x0a, 0x69, 0x73,
+	0x43, 0x6f, 0x6d, 0x70, 0x75, 0x74, 0x65, 0x72, 0x12, 0x14, 0x0a, 0x05, 0x67, 0x70, 0x6f, 0x49,
+	0x44, 0x18, 0x03, 0x20, 0x01, 0x28, 0x09, 0x52, 0x05, 0x67, 0x70, 0x6f, 0x49, 0x44, 0x12, 0x18,
+	0x0a, 0x07, 0x67, 0x70, 0x6f, 0x4e, 0x61, 0x6d, 0x65, 0x18, 0x04, 0x20, 0x01, 0x28, 0x09, 0x52,
+	0x07, 0x67, 0x70, 0x6f, 0x4e, 0x61, 0x6d, 0x65, 0x12, 0x16, 0x0a, 0x06, 0x70, 0x6f, 0x6c, 0x69,
+	0x63, 0x79, 0x18, 0x05, 0x20, 0x01, 0x28, 0x0c, 0x52, 0x06, 0x70, 0x6f, 0x6c, 0x69, 0x63, 0x79,
+	0x12, 0x10, 0x0a, 0x03, 0x61, 0x6c, 0x6c, 0x18, 0x06, 0x20, 0x01, 0x28, 0x08, 0x52, 0x03, 0x61,
+	0x6c, 0x6c, 0x22, 0x2c, 0x0a, 0x12, 0x44, 0x65, 0x66, 0x65, 0x72, 0x4c, 0x6f, 0x67, 0x6f, 0x75,
+	0x74, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x12, 0x16, 0x0a, 0x06, 0x74, 0x61, 0x72, 0x67,
+	0x65, 0x74, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x06, 0x74, 0x61, 0x72, 0x67, 0x65, 0x74,
+	0x22, 0x26, 0x0a, 0x12, 0x4c, 0x69, 0x6d, 0x69, 0x74, 0x53, 0x63, 0x72, 0x69, 0x70, 0x74, 0x52,
+	0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x12, 0x10, 0x0a, 0x03, 0x70, 0x69, 0x64, 0x18, 0x01, 0x20,
+	0x01, 0x28, 0x0d, 0x52, 0x03, 0x70, 0x69, 0x64, 0x22, 0x29, 0x0a, 0x0d, 0x47, 0x65, 0x74, 0x44,
+	0x6f, 0x63, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x12, 0x18, 0x0a, 0x07, 0x63, 0x68, 0x61,
+	0x70, 0x74, 0x65, 0x72, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x07, 0x63, 0x68, 0x61, 0x70,
+	0x74, 0x65, 0x72, 0x22, 0x22, 0x0a, 0x0e, 0x4c, 0x69, 0x73, 0x74, 0x44, 0x6f, 0x63, 0x52, 0x65,
+	0x71, 0x75, 0x65, 0x73, 0x74, 0x12, 0x10, 0x0a, 0x03, 0x72, 0x61, 0x77, 0x18, 0x01, 0x20, 0x01,
+	0x28, 0x08, 0x52, 0x03, 0x72, 0x61, 0x77, 0x32, 0xc2, 0x09, 0x0a, 0x07, 0x73, 0x65, 0x72, 0x76,
+	0x69, 0x63, 0x65, 0x12, 0x20, 0x0a, 0x03, 0x43, 0x61, 0x74, 0x12, 0x06, 0x2e, 0x45, 0x6d, 0x70,
+	0x74, 0x79, 0x1a, 0x0f, 0x2e, 0x53, 0x74, 0x72, 0x69, 0x6e, 0x67, 0x52, 0x65, 0x73, 0x70, 0x6f,
+	0x6e, 0x73, 0x65, 0x30, 0x01, 0x12, 0x24, 0x0a, 0x07, 0x56, 0x65, 0x72, 0x73, 0x69, 0x6f, 0x6e,
 	0x12, 0x06, 0x2e, 0x45, 0x6d, 0x70, 0x74, 0x79, 0x1a, 0x0f, 0x2e, 0x53, 0x74, 0x72, 0x69, 0x6e,
-	0x67, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x30, 0x01, 0x12, 0x1e, 0x0a, 0x04, 0x53,
-	0x74, 0x6f, 0x70, 0x12, 0x0c, 0x2e, 0x53, 0x74, 0x6f, 0x70, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73,
-	0x74, 0x1a, 0x06, 0x2e, 0x45, 0x6d, 0x70, 0x74, 0x79, 0x30, 0x01, 0x12, 0x2e, 0x0a, 0x0c, 0x55,
-	0x70, 0x64, 0x61, 0x74, 0x65, 0x50, 0x6f, 0x6c, 0x69, 0x63, 0x79, 0x12, 0x14, 0x2e, 0x55, 0x70,
-	0x64, 0x61, 0x74, 0x65, 0x50, 0x6f, 0x6c, 0x69, 0x63, 0x79, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73,
-	0x74, 0x1a, 0x06, 0x2e, 0x45, 0x6d, 0x70, 0x74, 0x79, 0x30, 0x01, 0x12, 0x37, 0x0a, 0x0c, 0x44,
-	0x75, 0x6d, 0x70, 0x50, 0x6f, 0x6c, 0x69, 0x63, 0x69, 0x65, 0x73, 0x12, 0x14, 0x2e, 0x44, 0x75,
-	0x6d, 0x70, 0x50, 0x6f, 0x6c, 0x69, 0x63, 0x69, 0x65, 0x73, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73,
+	0x67, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x30, 0x01, 0x12, 0x23, 0x0a, 0x06, 0x53,
+	0x74, 0x61, 0x74, 0x75, 0x73, 0x12, 0x06, 0x2e, 0x45, 0x6d, 0x70, 0x74, 0x79, 0x1a, 0x0f, 0x2e,
+	0x53, 0x74, 0x72, 0x69, 0x6e, 0x67, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x30, 0x01,
+	0x12, 0x1e, 0x0a, 0x04, 0x53, 0x74, 0x6f, 0x70, 0x12, 0x0c, 0x2e, 0x53, 0x74, 0x6f, 0x70, 0x52,
+	0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x1a, 0x06, 0x2e, 0x45, 0x6d, 0x70, 0x74, 0x79, 0x30, 0x01,
+	0x12, 0x2e, 0x0a, 0x0c, 0x55, 0x70, 0x64, 0x61, 0x74, 0x65, 0x50, 0x6f, 0x6c, 0x69, 0x63, 0x79,
+	0x12, 0x14, 0x2e, 0x55, 0x70, 0x64, 0x61, 0x74, 0x65, 0x50, 0x6f, 0x6c, 0x69, 0x63, 0x79, 0x52,
+	0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x1a, 0x06, 0x2e, 0x45, 0x6d, 0x70, 0x74, 0x79, 0x30, 0x01,
+	0x12, 0x3d, 0x0a, 0x12, 0x55, 0x70, 0x64, 0x61, 0x74, 0x65, 0x50, 0x6f, 0x6c, 0x69, 0x63, 0x79,
+	0x44, 0x72, 0x79, 0x52, 0x75, 0x6e, 0x12, 0x14, 0x2e, 0x55, 0x70, 0x64, 0x61, 0x74, 0x65, 0x50,
+	0x6f, 0x6c, 0x69, 0x63, 0x79, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x1a, 0x0f, 0x2e, 0x53,
+	0x74, 0x72, 0x69, 0x6e, 0x67, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x30, 0x01, 0x12,
+	0x37, 0x0a, 0x0c, 0x44, 0x75, 0x6d, 0x70, 0x50, 0x6f, 0x6c, 0x69, 0x63, 0x69, 0x65, 0x73, 0x12,
+	0x14, 0x2e, 0x44, 0x75, 0x6d, 0x70, 0x50, 0x6f, 0x6c, 0x69, 0x63, 0x69, 0x65, 0x73, 0x52, 0x65,
+	0x71, 0x75, 0x65, 0x73, 0x74, 0x1a, 0x0f, 0x2e, 0x53, 0x74, 0x72, 0x69, 0x6e, 0x67, 0x52, 0x65,
+	0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x30, 0x01, 0x12, 0x5a, 0x0a, 0x17, 0x44, 0x75, 0x6d, 0x70,
+	0x50, 0x6f, 0x6c, 0x69, 0x63, 0x69, 0x65, 0x73, 0x44, 0x65, 0x66, 0x69, 0x6e, 0x69, 0x74, 0x69,
+	0x6f, 0x6e, 0x73, 0x12, 0x1d, 0x2e, 0x44, 0x75, 0x6d, 0x70, 0x50, 0x6f, 0x6c, 0x69, 0x63, 0x79,
+	0x44, 0x65, 0x66, 0x69, 0x6e, 0x69, 0x74, 0x69, 0x6f, 0x6e, 0x73, 0x52, 0x65, 0x71, 0x75, 0x65,
+	0x73, 0x74, 0x1a, 0x1e, 0x2e, 0x44, 0x75, 0x6d, 0x70, 0x50, 0x6f, 0x6c, 0x69, 0x63, 0x79, 0x44,
+	0x65, 0x66, 0x69, 0x6e, 0x69, 0x74, 0x69, 0x6f, 0x6e, 0x73, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e,
+	0x73, 0x65, 0x30, 0x01, 0x12, 0x2b, 0x0a, 0x06, 0x47, 0x65, 0x74, 0x44, 0x6f, 0x63, 0x12, 0x0e,
+	0x2e, 0x47, 0x65, 0x74, 0x44, 0x6f, 0x63, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x1a, 0x0f,
+	0x2e, 0x53, 0x74, 0x72, 0x69, 0x6e, 0x67, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x30,
+	0x01, 0x12, 0x2d, 0x0a, 0x07, 0x4c, 0x69, 0x73, 0x74, 0x44, 0x6f, 0x63, 0x12, 0x0f, 0x2e, 0x4c,
+	0x69, 0x73, 0x74, 0x44, 0x6f, 0x63, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x1a, 0x0f, 0x2e,
+	0x53, 0x74, 0x72, 0x69, 0x6e, 0x67, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x30, 0x01,
+	0x12, 0x31, 0x0a, 0x09, 0x4c, 0x69, 0x73, 0x74, 0x55, 0x73, 0x65, 0x72, 0x73, 0x12, 0x11, 0x2e,
+	0x4c, 0x69, 0x73, 0x74, 0x55, 0x73, 0x65, 0x72, 0x73, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74,
+	0x1a, 0x0f, 0x2e, 0x53, 0x74, 0x72, 0x69, 0x6e, 0x67, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73,
+	0x65, 0x30, 0x01, 0x12, 0x2a, 0x0a, 0x0d, 0x47, 0x50, 0x4f, 0x4c, 0x69, 0x73, 0x74, 0x53, 0x63,
+	0x72, 0x69, 0x70, 0x74, 0x12, 0x06, 0x2e, 0x45, 0x6d, 0x70, 0x74, 0x79, 0x1a, 0x0f, 0x2e, 0x53,
+	0x74, 0x72, 0x69, 0x6e, 0x67, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x30, 0x01, 0x12,
+	0x3b, 0x0a, 0x0e, 0x4c, 0x69, 0x73, 0x74, 0x50, 0x6f, 0x6c, 0x69, 0x63, 0x79, 0x4b, 0x65, 0x79,
+	0x73, 0x12, 0x16, 0x2e, 0x4c, 0x69, 0x73, 0x74, 0x50, 0x6f, 0x6c, 0x69, 0x63, 0x79, 0x4b, 0x65,
+	0x79, 0x73, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x1a, 0x0f, 0x2e, 0x53, 0x74, 0x72, 0x69,
+	0x6e, 0x67, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x30, 0x01, 0x12, 0x3b, 0x0a, 0x0e,
+	0x53, 0x65, 0x61, 0x72, 0x63, 0x68, 0x50, 0x6f, 0x6c, 0x69, 0x63, 0x69, 0x65, 0x73, 0x12, 0x16,
+	0x2e, 0x53, 0x65, 0x61, 0x72, 0x63, 0x68, 0x50, 0x6f, 0x6c, 0x69, 0x63, 0x69, 0x65, 0x73, 0x52,
+	0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x1a, 0x0f, 0x2e, 0x53, 0x74, 0x72, 0x69, 0x6e, 0x67, 0x52,
+	0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x30, 0x01, 0x12, 0x2e, 0x0a, 0x0c, 0x46, 0x72, 0x65,
+	0x65, 0x7a, 0x65, 0x50, 0x6f, 0x6c, 0x69, 0x63, 0x79, 0x12, 0x14, 0x2e, 0x46, 0x72, 0x65, 0x65,
+	0x7a, 0x65, 0x50, 0x6f, 0x6c, 0x69, 0x63, 0x79, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x1a,
+	0x06, 0x2e, 0x45, 0x6d, 0x70, 0x74, 0x79, 0x30, 0x01, 0x12, 0x43, 0x0a, 0x12, 0x47, 0x65, 0x74,
+	0x4c, 0x61, 0x73, 0x74, 0x41, 0x70, 0x70, 0x6c, 0x79, 0x53, 0x74, 0x61, 0x74, 0x75, 0x73, 0x12,
+	0x1a, 0x2e, 0x47, 0x65, 0x74, 0x4c, 0x61, 0x73, 0x74, 0x41, 0x70, 0x70, 0x6c, 0x79, 0x53, 0x74,
+	0x61, 0x74, 0x75, 0x73, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x1a, 0x0f, 0x2e, 0x53, 0x74,
+	0x72, 0x69, 0x6e, 0x67, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x30, 0x01, 0x12, 0x2b,
+	0x0a, 0x06, 0x57, 0x68, 0x6f, 0x48, 0x61, 0x73, 0x12, 0x0e, 0x2e, 0x57, 0x68, 0x6f, 0x48, 0x61,
+	0x73, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x1a, 0x0f, 0x2e, 0x53, 0x74, 0x72, 0x69, 0x6e,
+	0x67, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x30, 0x01, 0x12, 0x27, 0x0a, 0x04, 0x4f,
+	0x77, 0x6e, 0x73, 0x12, 0x0c, 0x2e, 0x4f, 0x77, 0x6e, 0x73, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73,
 	0x74, 0x1a, 0x0f, 0x2e, 0x53, 0x74, 0x72, 0x69, 0x6e, 0x67, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e,
-	0x73, 0x65, 0x30, 0x01, 0x12, 0x5a, 0x0a, 0x17, 0x44, 0x75, 0x6d, 0x70, 0x50, 0x6f, 0x6c, 0x69,
-	0x63, 0x69, 0x65, 0x73, 0x44, 0x65, 0x66, 0x69, 0x6e, 0x69, 0x74, 0x69, 0x6f, 0x6e, 0x73, 0x12,
-	0x1d, 0x2e, 0x44, 0x75, 0x6d, 0x70, 0x50, 0x6f, 0x6c, 0x69, 0x63, 0x79, 0x44, 0x65, 0x66, 0x69,
-	0x6e, 0x69, 0x74, 0x69, 0x6f, 0x6e, 0x73, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x1a, 0x1e,
-	0x2e, 0x44, 0x75, 0x6d, 0x70, 0x50, 0x6f, 0x6c, 0x69, 0x63, 0x79, 0x44, 0x65, 0x66, 0x69, 0x6e,
-	0x69, 0x74, 0x69, 0x6f, 0x6e, 0x73, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x30, 0x01,
-	0x12, 0x2b, 0x0a, 0x06, 0x47, 0x65, 0x74, 0x44, 0x6f, 0x63, 0x12, 0x0e, 0x2e, 0x47, 0x65, 0x74,
-	0x44, 0x6f, 0x63, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x1a, 0x0f, 0x2e, 0x53, 0x74, 0x72,
-	0x69, 0x6e, 0x67, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x30, 0x01, 0x12, 0x2d, 0x0a,
-	0x07, 0x4c, 0x69, 0x73, 0x74, 0x44, 0x6f, 0x63, 0x12, 0x0f, 0x2e, 0x4c, 0x69, 0x73, 0x74, 0x44,
-	0x6f, 0x63, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x1a, 0x0f, 0x2e, 0x53, 0x74, 0x72, 0x69,
-	0x6e, 0x67, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x30, 0x01, 0x12, 0x31, 0x0a, 0x09,
-	0x4c, 0x69, 0x73, 0x74, 0x55, 0x73, 0x65, 0x72, 0x73, 0x12, 0x11, 0x2e, 0x4c, 0x69, 0x73, 0x74,
-	0x55, 0x73, 0x65, 0x72, 0x73, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x1a, 0x0f, 0x2e, 0x53,
+	0x73, 0x65, 0x30, 0x01, 0x12, 0x2c, 0x0a, 0x0f, 0x52, 0x65, 0x61, 0x70, 0x70, 0x6c, 0x79, 0x4d,
+	0x6f, 0x64, 0x69, 0x66, 0x69, 0x65, 0x64, 0x12, 0x06, 0x2e, 0x45, 0x6d, 0x70, 0x74, 0x79, 0x1a,
+	0x0f, 0x2e, 0x53, 0x74, 0x72, 0x69, 0x6e, 0x67, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65,
+	0x30, 0x01, 0x12, 0x2b, 0x0a, 0x0e, 0x52, 0x65, 0x61, 0x70, 0x70, 0x6c, 0x79, 0x45, 0x78, 0x70,
+	0x69, 0x72, 0x65, 0x64, 0x12, 0x06, 0x2e, 0x45, 0x6d, 0x70, 0x74, 0x79, 0x1a, 0x0f, 0x2e, 0x53,
 	0x74, 0x72, 0x69, 0x6e, 0x67, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x30, 0x01, 0x12,
-	0x2a, 0x0a, 0x0d, 0x47, 0x50, 0x4f, 0x4c, 0x69, 0x73, 0x74, 0x53, 0x63, 0x72, 0x69, 0x70, 0x74,
-	0x12, 0x06, 0x2e, 0x45, 0x6d, 0x70, 0x74, 0x79, 0x1a, 0x0f, 0x2e, 0x53, 0x74, 0x72, 0x69, 0x6e,
-	0x67, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x30, 0x01, 0x42, 0x19, 0x5a, 0x17, 0x67,
-	0x69, 0x74, 0x68, 0x75, 0x62, 0x2e, 0x63, 0x6f, 0x6d, 0x2f, 0x75, 0x62, 0x75, 0x6e, 0x74, 0x75,
-	0x2f, 0x61, 0x64, 0x73, 0x79, 0x73, 0x62, 0x06, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x33,
+	0x3b, 0x0a, 0x0e, 0x53, 0x69, 0x6d, 0x75, 0x6c, 0x61, 0x74, 0x65, 0x50, 0x6f, 0x6c, 0x69, 0x63,
+	0x79, 0x12, 0x16, 0x2e, 0x53, 0x69, 0x6d, 0x75, 0x6c, 0x61, 0x74, 0x65, 0x50, 0x6f, 0x6c, 0x69,
+	0x63, 0x79, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x1a, 0x0f, 0x2e, 0x53, 0x74, 0x72, 0x69,
+	0x6e, 0x67, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x30, 0x01, 0x12, 0x29, 0x0a, 0x05,
+	0x50, 0x72, 0x75, 0x6e, 0x65, 0x12, 0x0d, 0x2e, 0x50, 0x72, 0x75, 0x6e, 0x65, 0x52, 0x65, 0x71,
+	0x75, 0x65, 0x73, 0x74, 0x1a, 0x0f, 0x2e, 0x53, 0x74, 0x72, 0x69, 0x6e, 0x67, 0x52, 0x65, 0x73,
+	0x70, 0x6f, 0x6e, 0x73, 0x65, 0x30, 0x01, 0x12, 0x35, 0x0a, 0x0b, 0x44, 0x65, 0x66, 0x65, 0x72,
+	0x4c, 0x6f, 0x67, 0x6f, 0x75, 0x74, 0x12, 0x13, 0x2e, 0x44, 0x65, 0x66, 0x65, 0x72, 0x4c, 0x6f,
+	0x67, 0x6f, 0x75, 0x74, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x1a, 0x0f, 0x2e, 0x53, 0x74,
+	0x72, 0x69, 0x6e, 0x67, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x30, 0x01, 0x12, 0x2c,
+	0x0a, 0x0b, 0x4c, 0x69, 0x6d, 0x69, 0x74, 0x53, 0x63, 0x72, 0x69, 0x70, 0x74, 0x12, 0x13, 0x2e,
+	0x4c, 0x69, 0x6d, 0x69, 0x74, 0x53, 0x63, 0x72, 0x69, 0x70, 0x74, 0x52, 0x65, 0x71, 0x75, 0x65,
+	0x73, 0x74, 0x1a, 0x06, 0x2e, 0x45, 0x6d, 0x70, 0x74, 0x79, 0x30, 0x01, 0x42, 0x19, 0x5a, 0x17,
+	0x67, 0x69, 0x74, 0x68, 0x75, 0x62, 0x2e, 0x63, 0x6f, 0x6d, 0x2f, 0x75, 0x62, 0x75, 0x6e, 0x74,
+	0x75, 0x2f, 0x61, 0x64, 0x73, 0x79, 0x73, 0x62, 0x06, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x33,
 }
 
 var (
@@ -646,44 +1421,80 @@ func file_adsys_proto_rawDescGZIP() []byte {
 	return file_adsys_proto_rawDescData
 }
 
-var file_adsys_proto_msgTypes = make([]protoimpl.MessageInfo, 10)
+var file_adsys_proto_msgTypes = make([]protoimpl.MessageInfo, 20)
 var file_adsys_proto_goTypes = []interface{}{
 	(*Empty)(nil),                         // 0: Empty
 	(*ListUsersRequest)(nil),              // 1: ListUsersRequest
 	(*StopRequest)(nil),                   // 2: StopRequest
-	(*StringResponse)(nil),                // 3: StringResponse
-	(*UpdatePolicyRequest)(nil),           // 4: UpdatePolicyRequest
-	(*DumpPoliciesRequest)(nil),           // 5: DumpPoliciesRequest
-	(*DumpPolicyDefinitionsRequest)(nil),  // 6: DumpPolicyDefinitionsRequest
-	(*DumpPolicyDefinitionsResponse)(nil), // 7: DumpPolicyDefinitionsResponse
-	(*GetDocRequest)(nil),                 // 8: GetDocRequest
-	(*ListDocRequest)(nil),                // 9: ListDocRequest
+	(*PruneRequest)(nil),                  // 3: PruneRequest
+	(*StringResponse)(nil),                // 4: StringResponse
+	(*UpdatePolicyRequest)(nil),           // 5: UpdatePolicyRequest
+	(*DumpPoliciesRequest)(nil),           // 6: DumpPoliciesRequest
+	(*DumpPolicyDefinitionsRequest)(nil),  // 7: DumpPolicyDefinitionsRequest
+	(*DumpPolicyDefinitionsResponse)(nil), // 8: DumpPolicyDefinitionsResponse
+	(*ListPolicyKeysRequest)(nil),         // 9: ListPolicyKeysRequest
+	(*SearchPoliciesRequest)(nil),         // 10: SearchPoliciesRequest
+	(*FreezePolicyRequest)(nil),           // 11: FreezePolicyRequest
+	(*GetLastApplyStatusRequest)(nil),     // 12: GetLastApplyStatusRequest
+	(*WhoHasRequest)(nil),                 // 13: WhoHasRequest
+	(*OwnsRequest)(nil),                   // 14: OwnsRequest
+	(*SimulatePolicyRequest)(nil),         // 15: SimulatePolicyRequest
+	(*DeferLogoutRequest)(nil),            // 16: DeferLogoutRequest
+	(*LimitScriptRequest)(nil),            // 17: LimitScriptRequest
+	(*GetDocRequest)(nil),                 // 18: GetDocRequest
+	(*ListDocRequest)(nil),                // 19: ListDocRequest
 }
 var file_adsys_proto_depIdxs = []int32{
 	0,  // 0: service.Cat:input_type -> Empty
 	0,  // 1: service.Version:input_type -> Empty
 	0,  // 2: service.Status:input_type -> Empty
 	2,  // 3: service.Stop:input_type -> StopRequest
-	4,  // 4: service.UpdatePolicy:input_type -> UpdatePolicyRequest
-	5,  // 5: service.DumpPolicies:input_type -> DumpPoliciesRequest
-	6,  // 6: service.DumpPoliciesDefinitions:input_type -> DumpPolicyDefinitionsRequest
-	8,  // 7: service.GetDoc:input_type -> GetDocRequest
-	9,  // 8: service.ListDoc:input_type -> ListDocRequest
-	1,  // 9: service.ListUsers:input_type -> ListUsersRequest
-	0,  // 10: service.GPOListScript:input_type -> Empty
-	3,  // 11: service.Cat:output_type -> StringResponse
-	3,  // 12: service.Version:output_type -> StringResponse
-	3,  // 13: service.Status:output_type -> StringResponse
-	0,  // 14: service.Stop:output_type -> Empty
-	0,  // 15: service.UpdatePolicy:output_type -> Empty
-	3,  // 16: service.DumpPolicies:output_type -> StringResponse
-	7,  // 17: service.DumpPoliciesDefinitions:output_type -> DumpPolicyDefinitionsResponse
-	3,  // 18: service.GetDoc:output_type -> StringResponse
-	3,  // 19: service.ListDoc:output_type -> StringResponse
-	3,  // 20: service.ListUsers:output_type -> StringResponse
-	3,  // 21: service.GPOListScript:output_type -> StringResponse
-	11, // [11:22] is the sub-list for method output_type
-	0,  // [0:11] is the sub-list for method input_type
+	5,  // 4: service.UpdatePolicy:input_type -> UpdatePolicyRequest
+	5,  // 5: service.UpdatePolicyDryRun:input_type -> UpdatePolicyRequest
+	6,  // 6: service.DumpPolicies:input_type -> DumpPoliciesRequest
+	7,  // 7: service.DumpPoliciesDefinitions:input_type -> DumpPolicyDefinitionsRequest
+	18, // 8: service.GetDoc:input_type -> GetDocRequest
+	19, // 9: service.ListDoc:input_type -> ListDocRequest
+	1,  // 10: service.ListUsers:input_type -> ListUsersRequest
+	0,  // 11: service.GPOListScript:input_type -> Empty
+	9,  // 12: service.ListPolicyKeys:input_type -> ListPolicyKeysRequest
+	10, // 13: service.SearchPolicies:input_type -> SearchPoliciesRequest
+	11, // 14: service.FreezePolicy:input_type -> FreezePolicyRequest
+	12, // 15: service.GetLastApplyStatus:input_type -> GetLastApplyStatusRequest
+	13, // 16: service.WhoHas:input_type -> WhoHasRequest
+	14, // 17: service.Owns:input_type -> OwnsRequest
+	0,  // 18: service.ReapplyModified:input_type -> Empty
+	0,  // 19: service.ReapplyExpired:input_type -> Empty
+	15, // 20: service.SimulatePolicy:input_type -> SimulatePolicyRequest
+	3,  // 21: service.Prune:input_type -> PruneRequest
+	16, // 22: service.DeferLogout:input_type -> DeferLogoutRequest
+	17, // 23: service.LimitScript:input_type -> LimitScriptRequest
+	4,  // 24: service.Cat:output_type -> StringResponse
+	4,  // 25: service.Version:output_type -> StringResponse
+	4,  // 26: service.Status:output_type -> StringResponse
+	0,  // 27: service.Stop:output_type -> Empty
+	0,  // 28: service.UpdatePolicy:output_type -> Empty
+	4,  // 29: service.UpdatePolicyDryRun:output_type -> StringResponse
+	4,  // 30: service.DumpPolicies:output_type -> StringResponse
+	8,  // 31: service.DumpPoliciesDefinitions:output_type -> DumpPolicyDefinitionsResponse
+	4,  // 32: service.GetDoc:output_type -> StringResponse
+	4,  // 33: service.ListDoc:output_type -> StringResponse
+	4,  // 34: service.ListUsers:output_type -> StringResponse
+	4,  // 35: service.GPOListScript:output_type -> StringResponse
+	4,  // 36: service.ListPolicyKeys:output_type -> StringResponse
+	4,  // 37: service.SearchPolicies:output_type -> StringResponse
+	0,  // 38: service.FreezePolicy:output_type -> Empty
+	4,  // 39: service.GetLastApplyStatus:output_type -> StringResponse
+	4,  // 40: service.WhoHas:output_type -> StringResponse
+	4,  // 41: service.Owns:output_type -> StringResponse
+	4,  // 42: service.ReapplyModified:output_type -> StringResponse
+	4,  // 43: service.ReapplyExpired:output_type -> StringResponse
+	4,  // 44: service.SimulatePolicy:output_type -> StringResponse
+	4,  // 45: service.Prune:output_type -> StringResponse
+	4,  // 46: service.DeferLogout:output_type -> StringResponse
+	0,  // 47: service.LimitScript:output_type -> Empty
+	24, // [24:48] is the sub-list for method output_type
+	0,  // [0:24] is the sub-list for method input_type
 	0,  // [0:0] is the sub-list for extension type_name
 	0,  // [0:0] is the sub-list for extension extendee
 	0,  // [0:0] is the sub-list for field type_name
@@ -732,7 +1543,7 @@ func file_adsys_proto_init() {
 			}
 		}
 		file_adsys_proto_msgTypes[3].Exporter = func(v interface{}, i int) interface{} {
-			switch v := v.(*StringResponse); i {
+			switch v := v.(*PruneRequest); i {
 			case 0:
 				return &v.state
 			case 1:
@@ -744,7 +1555,7 @@ func file_adsys_proto_init() {
 			}
 		}
 		file_adsys_proto_msgTypes[4].Exporter = func(v interface{}, i int) interface{} {
-			switch v := v.(*UpdatePolicyRequest); i {
+			switch v := v.(*StringResponse); i {
 			case 0:
 				return &v.state
 			case 1:
@@ -756,7 +1567,7 @@ func file_adsys_proto_init() {
 			}
 		}
 		file_adsys_proto_msgTypes[5].Exporter = func(v interface{}, i int) interface{} {
-			switch v := v.(*DumpPoliciesRequest); i {
+			switch v := v.(*UpdatePolicyRequest); i {
 			case 0:
 				return &v.state
 			case 1:
@@ -768,7 +1579,7 @@ func file_adsys_proto_init() {
 			}
 		}
 		file_adsys_proto_msgTypes[6].Exporter = func(v interface{}, i int) interface{} {
-			switch v := v.(*DumpPolicyDefinitionsRequest); i {
+			switch v := v.(*DumpPoliciesRequest); i {
 			case 0:
 				return &v.state
 			case 1:
@@ -780,7 +1591,7 @@ func file_adsys_proto_init() {
 			}
 		}
 		file_adsys_proto_msgTypes[7].Exporter = func(v interface{}, i int) interface{} {
-			switch v := v.(*DumpPolicyDefinitionsResponse); i {
+			switch v := v.(*DumpPolicyDefinitionsRequest); i {
 			case 0:
 				return &v.state
 			case 1:
@@ -792,7 +1603,7 @@ func file_adsys_proto_init() {
 			}
 		}
 		file_adsys_proto_msgTypes[8].Exporter = func(v interface{}, i int) interface{} {
-			switch v := v.(*GetDocRequest); i {
+			switch v := v.(*DumpPolicyDefinitionsResponse); i {
 			case 0:
 				return &v.state
 			case 1:
@@ -804,6 +1615,126 @@ func file_adsys_proto_init() {
 			}
 		}
 		file_adsys_proto_msgTypes[9].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*ListPolicyKeysRequest); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_adsys_proto_msgTypes[10].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*SearchPoliciesRequest); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_adsys_proto_msgTypes[11].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*FreezePolicyRequest); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_adsys_proto_msgTypes[12].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*GetLastApplyStatusRequest); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_adsys_proto_msgTypes[13].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*WhoHasRequest); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_adsys_proto_msgTypes[14].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*OwnsRequest); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_adsys_proto_msgTypes[15].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*SimulatePolicyRequest); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_adsys_proto_msgTypes[16].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*DeferLogoutRequest); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_adsys_proto_msgTypes[17].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*LimitScriptRequest); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_adsys_proto_msgTypes[18].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*GetDocRequest); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_adsys_proto_msgTypes[19].Exporter = func(v interface{}, i int) interface{} {
 			switch v := v.(*ListDocRequest); i {
 			case 0:
 				return &v.state
@@ -822,7 +1753,7 @@ func file_adsys_proto_init() {
 			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
 			RawDescriptor: file_adsys_proto_rawDesc,
 			NumEnums:      0,
-			NumMessages:   10,
+			NumMessages:   20,
 			NumExtensions: 0,
 			NumServices:   1,
 		},
@@ -24,12 +24,25 @@ const (
 	Service_Status_FullMethodName                  = "/service/Status"
 	Service_Stop_FullMethodName                    = "/service/Stop"
 	Service_UpdatePolicy_FullMethodName            = "/service/UpdatePolicy"
+	Service_UpdatePolicyDryRun_FullMethodName      = "/service/UpdatePolicyDryRun"
 	Service_DumpPolicies_FullMethodName            = "/service/DumpPolicies"
 	Service_DumpPoliciesDefinitions_FullMethodName = "/service/DumpPoliciesDefinitions"
 	Service_GetDoc_FullMethodName                  = "/service/GetDoc"
 	Service_ListDoc_FullMethodName                 = "/service/ListDoc"
 	Service_ListUsers_FullMethodName               = "/service/ListUsers"
 	Service_GPOListScript_FullMethodName           = "/service/GPOListScript"
+	Service_ListPolicyKeys_FullMethodName          = "/service/ListPolicyKeys"
+	Service_SearchPolicies_FullMethodName          = "/service/SearchPolicies"
+	Service_FreezePolicy_FullMethodName            = "/service/FreezePolicy"
+	Service_GetLastApplyStatus_FullMethodName      = "/service/GetLastApplyStatus"
+	Service_WhoHas_FullMethodName                  = "/service/WhoHas"
+	Service_Owns_FullMethodName                    = "/service/Owns"
+	Service_ReapplyModified_FullMethodName         = "/service/ReapplyModified"
+	Service_ReapplyExpired_FullMethodName          = "/service/ReapplyExpired"
+	Service_SimulatePolicy_FullMethodName          = "/service/SimulatePolicy"
+	Service_Prune_FullMethodName                   = "/service/Prune"
+	Service_DeferLogout_FullMethodName             = "/service/DeferLogout"
+	Service_LimitScript_FullMethodName             = "/service/LimitScript"
 )
 
 // ServiceClient is the client API for Service service.
@@ -41,12 +54,25 @@ type ServiceClient interface {
 	Status(ctx context.Context, in *Empty, opts ...grpc.CallOption) (Service_StatusClient, error)
 	Stop(ctx context.Context, in *StopRequest, opts ...grpc.CallOption) (Service_StopClient, error)
 	UpdatePolicy(ctx context.Context, in *UpdatePolicyRequest, opts ...grpc.CallOption) (Service_UpdatePolicyClient, error)
+	UpdatePolicyDryRun(ctx context.Context, in *UpdatePolicyRequest, opts ...grpc.CallOption) (Service_UpdatePolicyDryRunClient, error)
 	DumpPolicies(ctx context.Context, in *DumpPoliciesRequest, opts ...grpc.CallOption) (Service_DumpPoliciesClient, error)
 	DumpPoliciesDefinitions(ctx context.Context, in *DumpPolicyDefinitionsRequest, opts ...grpc.CallOption) (Service_DumpPoliciesDefinitionsClient, error)
 	GetDoc(ctx context.Context, in *GetDocRequest, opts ...grpc.CallOption) (Service_GetDocClient, error)
 	ListDoc(ctx context.Context, in *ListDocRequest, opts ...grpc.CallOption) (Service_ListDocClient, error)
 	ListUsers(ctx context.Context, in *ListUsersRequest, opts ...grpc.CallOption) (Service_ListUsersClient, error)
 	GPOListScript(ctx context.Context, in *Empty, opts ...grpc.CallOption) (Service_GPOListScriptClient, error)
+	ListPolicyKeys(ctx context.Context, in *ListPolicyKeysRequest, opts ...grpc.CallOption) (Service_ListPolicyKeysClient, error)
+	SearchPolicies(ctx context.Context, in *SearchPoliciesRequest, opts ...grpc.CallOption) (Service_SearchPoliciesClient, error)
+	FreezePolicy(ctx context.Context, in *FreezePolicyRequest, opts ...grpc.CallOption) (Service_FreezePolicyClient, error)
+	GetLastApplyStatus(ctx context.Context, in *GetLastApplyStatusRequest, opts ...grpc.CallOption) (Service_GetLastApplyStatusClient, error)
+	WhoHas(ctx context.Context, in *WhoHasRequest, opts ...grpc.CallOption) (Service_WhoHasClient, error)
+	Owns(ctx context.Context, in *OwnsRequest, opts ...grpc.CallOption) (Service_OwnsClient, error)
+	ReapplyModified(ctx context.Context, in *Empty, opts ...grpc.CallOption) (Service_ReapplyModifiedClient, error)
+	ReapplyExpired(ctx context.Context, in *Empty, opts ...grpc.CallOption) (Service_ReapplyExpiredClient, error)
+	SimulatePolicy(ctx context.Context, in *SimulatePolicyRequest, opts ...grpc.CallOption) (Service_SimulatePolicyClient, error)
+	Prune(ctx context.Context, in *PruneRequest, opts ...grpc.CallOption) (Service_PruneClient, error)
+	DeferLogout(ctx context.Context, in *DeferLogoutRequest, opts ...grpc.CallOption) (Service_DeferLogoutClient, error)
+	LimitScript(ctx context.Context, in *LimitScriptRequest, opts ...grpc.CallOption) (Service_LimitScriptClient, error)
 }
 
 type serviceClient struct {
@@ -217,8 +243,40 @@ func (x *serviceUpdatePolicyClient) Recv() (*Empty, error) {
 	return m, nil
 }
 
+func (c *serviceClient) UpdatePolicyDryRun(ctx context.Context, in *UpdatePolicyRequest, opts ...grpc.CallOption) (Service_UpdatePolicyDryRunClient, error) {
+	stream, err := c.cc.NewStream(ctx, &Service_ServiceDesc.Streams[5], Service_UpdatePolicyDryRun_FullMethodName, opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &serviceUpdatePolicyDryRunClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type Service_UpdatePolicyDryRunClient interface {
+	Recv() (*StringResponse, error)
+	grpc.ClientStream
+}
+
+type serviceUpdatePolicyDryRunClient struct {
+	grpc.ClientStream
+}
+
+func (x *serviceUpdatePolicyDryRunClient) Recv() (*StringResponse, error) {
+	m := new(StringResponse)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
 func (c *serviceClient) DumpPolicies(ctx context.Context, in *DumpPoliciesRequest, opts ...grpc.CallOption) (Service_DumpPoliciesClient, error) {
-	stream, err := c.cc.NewStream(ctx, &Service_ServiceDesc.Streams[5], Service_DumpPolicies_FullMethodName, opts...)
+	stream, err := c.cc.NewStream(ctx, &Service_ServiceDesc.Streams[6], Service_DumpPolicies_FullMethodName, opts...)
 	if err != nil {
 		return nil, err
 	}
@@ -250,7 +308,7 @@ func (x *serviceDumpPoliciesClient) Recv() (*StringResponse, error) {
 }
 
 func (c *serviceClient) DumpPoliciesDefinitions(ctx context.Context, in *DumpPolicyDefinitionsRequest, opts ...grpc.CallOption) (Service_DumpPoliciesDefinitionsClient, error) {
-	stream, err := c.cc.NewStream(ctx, &Service_ServiceDesc.Streams[6], Service_DumpPoliciesDefinitions_FullMethodName, opts...)
+	stream, err := c.cc.NewStream(ctx, &Service_ServiceDesc.Streams[7], Service_DumpPoliciesDefinitions_FullMethodName, opts...)
 	if err != nil {
 		return nil, err
 	}
@@ -282,7 +340,7 @@ func (x *serviceDumpPoliciesDefinitionsClient) Recv() (*DumpPolicyDefinitionsRes
 }
 
 func (c *serviceClient) GetDoc(ctx context.Context, in *GetDocRequest, opts ...grpc.CallOption) (Service_GetDocClient, error) {
-	stream, err := c.cc.NewStream(ctx, &Service_ServiceDesc.Streams[7], Service_GetDoc_FullMethodName, opts...)
+	stream, err := c.cc.NewStream(ctx, &Service_ServiceDesc.Streams[8], Service_GetDoc_FullMethodName, opts...)
 	if err != nil {
 		return nil, err
 	}
@@ -314,7 +372,7 @@ func (x *serviceGetDocClient) Recv() (*StringResponse, error) {
 }
 
 func (c *serviceClient) ListDoc(ctx context.Context, in *ListDocRequest, opts ...grpc.CallOption) (Service_ListDocClient, error) {
-	stream, err := c.cc.NewStream(ctx, &Service_ServiceDesc.Streams[8], Service_ListDoc_FullMethodName, opts...)
+	stream, err := c.cc.NewStream(ctx, &Service_ServiceDesc.Streams[9], Service_ListDoc_FullMethodName, opts...)
 	if err != nil {
 		return nil, err
 	}
@@ -346,7 +404,7 @@ func (x *serviceListDocClient) Recv() (*StringResponse, error) {
 }
 
 func (c *serviceClient) ListUsers(ctx context.Context, in *ListUsersRequest, opts ...grpc.CallOption) (Service_ListUsersClient, error) {
-	stream, err := c.cc.NewStream(ctx, &Service_ServiceDesc.Streams[9], Service_ListUsers_FullMethodName, opts...)
+	stream, err := c.cc.NewStream(ctx, &Service_ServiceDesc.Streams[10], Service_ListUsers_FullMethodName, opts...)
 	if err != nil {
 		return nil, err
 	}
@@ -378,7 +436,7 @@ func (x *serviceListUsersClient) Recv() (*StringResponse, error) {
 }
 
 func (c *serviceClient) GPOListScript(ctx context.Context, in *Empty, opts ...grpc.CallOption) (Service_GPOListScriptClient, error) {
-	stream, err := c.cc.NewStream(ctx, &Service_ServiceDesc.Streams[10], Service_GPOListScript_FullMethodName, opts...)
+	stream, err := c.cc.NewStream(ctx, &Service_ServiceDesc.Streams[11], Service_GPOListScript_FullMethodName, opts...)
 	if err != nil {
 		return nil, err
 	}
@@ -397,12 +455,396 @@ type Service_GPOListScriptClient interface {
 	grpc.ClientStream
 }
 
-type serviceGPOListScriptClient struct {
+type serviceGPOListScriptClient struct {
+	grpc.ClientStream
+}
+
+func (x *serviceGPOListScriptClient) Recv() (*StringResponse, error) {
+	m := new(StringResponse)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *serviceClient) ListPolicyKeys(ctx context.Context, in *ListPolicyKeysRequest, opts ...grpc.CallOption) (Service_ListPolicyKeysClient, error) {
+	stream, err := c.cc.NewStream(ctx, &Service_ServiceDesc.Streams[12], Service_ListPolicyKeys_FullMethodName, opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &serviceListPolicyKeysClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type Service_ListPolicyKeysClient interface {
+	Recv() (*StringResponse, error)
+	grpc.ClientStream
+}
+
+type serviceListPolicyKeysClient struct {
+	grpc.ClientStream
+}
+
+func (x *serviceListPolicyKeysClient) Recv() (*StringResponse, error) {
+	m := new(StringResponse)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *serviceClient) SearchPolicies(ctx context.Context, in *SearchPoliciesRequest, opts ...grpc.CallOption) (Service_SearchPoliciesClient, error) {
+	stream, err := c.cc.NewStream(ctx, &Service_ServiceDesc.Streams[13], Service_SearchPolicies_FullMethodName, opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &serviceSearchPoliciesClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type Service_SearchPoliciesClient interface {
+	Recv() (*StringResponse, error)
+	grpc.ClientStream
+}
+
+type serviceSearchPoliciesClient struct {
+	grpc.ClientStream
+}
+
+func (x *serviceSearchPoliciesClient) Recv() (*StringResponse, error) {
+	m := new(StringResponse)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *serviceClient) FreezePolicy(ctx context.Context, in *FreezePolicyRequest, opts ...grpc.CallOption) (Service_FreezePolicyClient, error) {
+	stream, err := c.cc.NewStream(ctx, &Service_ServiceDesc.Streams[14], Service_FreezePolicy_FullMethodName, opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &serviceFreezePolicyClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type Service_FreezePolicyClient interface {
+	Recv() (*Empty, error)
+	grpc.ClientStream
+}
+
+type serviceFreezePolicyClient struct {
+	grpc.ClientStream
+}
+
+func (x *serviceFreezePolicyClient) Recv() (*Empty, error) {
+	m := new(Empty)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *serviceClient) GetLastApplyStatus(ctx context.Context, in *GetLastApplyStatusRequest, opts ...grpc.CallOption) (Service_GetLastApplyStatusClient, error) {
+	stream, err := c.cc.NewStream(ctx, &Service_ServiceDesc.Streams[15], Service_GetLastApplyStatus_FullMethodName, opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &serviceGetLastApplyStatusClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type Service_GetLastApplyStatusClient interface {
+	Recv() (*StringResponse, error)
+	grpc.ClientStream
+}
+
+type serviceGetLastApplyStatusClient struct {
+	grpc.ClientStream
+}
+
+func (x *serviceGetLastApplyStatusClient) Recv() (*StringResponse, error) {
+	m := new(StringResponse)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *serviceClient) WhoHas(ctx context.Context, in *WhoHasRequest, opts ...grpc.CallOption) (Service_WhoHasClient, error) {
+	stream, err := c.cc.NewStream(ctx, &Service_ServiceDesc.Streams[16], Service_WhoHas_FullMethodName, opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &serviceWhoHasClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type Service_WhoHasClient interface {
+	Recv() (*StringResponse, error)
+	grpc.ClientStream
+}
+
+type serviceWhoHasClient struct {
+	grpc.ClientStream
+}
+
+func (x *serviceWhoHasClient) Recv() (*StringResponse, error) {
+	m := new(StringResponse)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *serviceClient) Owns(ctx context.Context, in *OwnsRequest, opts ...grpc.CallOption) (Service_OwnsClient, error) {
+	stream, err := c.cc.NewStream(ctx, &Service_ServiceDesc.Streams[17], Service_Owns_FullMethodName, opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &serviceOwnsClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type Service_OwnsClient interface {
+	Recv() (*StringResponse, error)
+	grpc.ClientStream
+}
+
+type serviceOwnsClient struct {
+	grpc.ClientStream
+}
+
+func (x *serviceOwnsClient) Recv() (*StringResponse, error) {
+	m := new(StringResponse)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *serviceClient) ReapplyModified(ctx context.Context, in *Empty, opts ...grpc.CallOption) (Service_ReapplyModifiedClient, error) {
+	stream, err := c.cc.NewStream(ctx, &Service_ServiceDesc.Streams[18], Service_ReapplyModified_FullMethodName, opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &serviceReapplyModifiedClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type Service_ReapplyModifiedClient interface {
+	Recv() (*StringResponse, error)
+	grpc.ClientStream
+}
+
+type serviceReapplyModifiedClient struct {
+	grpc.ClientStream
+}
+
+func (x *serviceReapplyModifiedClient) Recv() (*StringResponse, error) {
+	m := new(StringResponse)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *serviceClient) ReapplyExpired(ctx context.Context, in *Empty, opts ...grpc.CallOption) (Service_ReapplyExpiredClient, error) {
+	stream, err := c.cc.NewStream(ctx, &Service_ServiceDesc.Streams[19], Service_ReapplyExpired_FullMethodName, opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &serviceReapplyExpiredClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type Service_ReapplyExpiredClient interface {
+	Recv() (*StringResponse, error)
+	grpc.ClientStream
+}
+
+type serviceReapplyExpiredClient struct {
+	grpc.ClientStream
+}
+
+func (x *serviceReapplyExpiredClient) Recv() (*StringResponse, error) {
+	m := new(StringResponse)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *serviceClient) SimulatePolicy(ctx context.Context, in *SimulatePolicyRequest, opts ...grpc.CallOption) (Service_SimulatePolicyClient, error) {
+	stream, err := c.cc.NewStream(ctx, &Service_ServiceDesc.Streams[20], Service_SimulatePolicy_FullMethodName, opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &serviceSimulatePolicyClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type Service_SimulatePolicyClient interface {
+	Recv() (*StringResponse, error)
+	grpc.ClientStream
+}
+
+type serviceSimulatePolicyClient struct {
+	grpc.ClientStream
+}
+
+func (x *serviceSimulatePolicyClient) Recv() (*StringResponse, error) {
+	m := new(StringResponse)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *serviceClient) Prune(ctx context.Context, in *PruneRequest, opts ...grpc.CallOption) (Service_PruneClient, error) {
+	stream, err := c.cc.NewStream(ctx, &Service_ServiceDesc.Streams[21], Service_Prune_FullMethodName, opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &servicePruneClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type Service_PruneClient interface {
+	Recv() (*StringResponse, error)
+	grpc.ClientStream
+}
+
+type servicePruneClient struct {
+	grpc.ClientStream
+}
+
+func (x *servicePruneClient) Recv() (*StringResponse, error) {
+	m := new(StringResponse)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *serviceClient) DeferLogout(ctx context.Context, in *DeferLogoutRequest, opts ...grpc.CallOption) (Service_DeferLogoutClient, error) {
+	stream, err := c.cc.NewStream(ctx, &Service_ServiceDesc.Streams[22], Service_DeferLogout_FullMethodName, opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &serviceDeferLogoutClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type Service_DeferLogoutClient interface {
+	Recv() (*StringResponse, error)
+	grpc.ClientStream
+}
+
+type serviceDeferLogoutClient struct {
+	grpc.ClientStream
+}
+
+func (x *serviceDeferLogoutClient) Recv() (*StringResponse, error) {
+	m := new(StringResponse)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *serviceClient) LimitScript(ctx context.Context, in *LimitScriptRequest, opts ...grpc.CallOption) (Service_LimitScriptClient, error) {
+	stream, err := c.cc.NewStream(ctx, &Service_ServiceDesc.Streams[23], Service_LimitScript_FullMethodName, opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &serviceLimitScriptClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type Service_LimitScriptClient interface {
+	Recv() (*Empty, error)
+	grpc.ClientStream
+}
+
+type serviceLimitScriptClient struct {
 	grpc.ClientStream
 }
 
-func (x *serviceGPOListScriptClient) Recv() (*StringResponse, error) {
-	m := new(StringResponse)
+func (x *serviceLimitScriptClient) Recv() (*Empty, error) {
+	m := new(Empty)
 	if err := x.ClientStream.RecvMsg(m); err != nil {
 		return nil, err
 	}
@@ -418,12 +860,25 @@ type ServiceServer interface {
 	Status(*Empty, Service_StatusServer) error
 	Stop(*StopRequest, Service_StopServer) error
 	UpdatePolicy(*UpdatePolicyRequest, Service_UpdatePolicyServer) error
+	UpdatePolicyDryRun(*UpdatePolicyRequest, Service_UpdatePolicyDryRunServer) error
 	DumpPolicies(*DumpPoliciesRequest, Service_DumpPoliciesServer) error
 	DumpPoliciesDefinitions(*DumpPolicyDefinitionsRequest, Service_DumpPoliciesDefinitionsServer) error
 	GetDoc(*GetDocRequest, Service_GetDocServer) error
 	ListDoc(*ListDocRequest, Service_ListDocServer) error
 	ListUsers(*ListUsersRequest, Service_ListUsersServer) error
 	GPOListScript(*Empty, Service_GPOListScriptServer) error
+	ListPolicyKeys(*ListPolicyKeysRequest, Service_ListPolicyKeysServer) error
+	SearchPolicies(*SearchPoliciesRequest, Service_SearchPoliciesServer) error
+	FreezePolicy(*FreezePolicyRequest, Service_FreezePolicyServer) error
+	GetLastApplyStatus(*GetLastApplyStatusRequest, Service_GetLastApplyStatusServer) error
+	WhoHas(*WhoHasRequest, Service_WhoHasServer) error
+	Owns(*OwnsRequest, Service_OwnsServer) error
+	ReapplyModified(*Empty, Service_ReapplyModifiedServer) error
+	ReapplyExpired(*Empty, Service_ReapplyExpiredServer) error
+	SimulatePolicy(*SimulatePolicyRequest, Service_SimulatePolicyServer) error
+	Prune(*PruneRequest, Service_PruneServer) error
+	DeferLogout(*DeferLogoutRequest, Service_DeferLogoutServer) error
+	LimitScript(*LimitScriptRequest, Service_LimitScriptServer) error
 	mustEmbedUnimplementedServiceServer()
 }
 
@@ -446,6 +901,9 @@ func (UnimplementedServiceServer) Stop(*StopRequest, Service_StopServer) error {
 func (UnimplementedServiceServer) UpdatePolicy(*UpdatePolicyRequest, Service_UpdatePolicyServer) error {
 	return status.Errorf(codes.Unimplemented, "method UpdatePolicy not implemented")
 }
+func (UnimplementedServiceServer) UpdatePolicyDryRun(*UpdatePolicyRequest, Service_UpdatePolicyDryRunServer) error {
+	return status.Errorf(codes.Unimplemented, "method UpdatePolicyDryRun not implemented")
+}
 func (UnimplementedServiceServer) DumpPolicies(*DumpPoliciesRequest, Service_DumpPoliciesServer) error {
 	return status.Errorf(codes.Unimplemented, "method DumpPolicies not implemented")
 }
@@ -464,6 +922,42 @@ func (UnimplementedServiceServer) ListUsers(*ListUsersRequest, Service_ListUsers
 func (UnimplementedServiceServer) GPOListScript(*Empty, Service_GPOListScriptServer) error {
 	return status.Errorf(codes.Unimplemented, "method GPOListScript not implemented")
 }
+func (UnimplementedServiceServer) ListPolicyKeys(*ListPolicyKeysRequest, Service_ListPolicyKeysServer) error {
+	return status.Errorf(codes.Unimplemented, "method ListPolicyKeys not implemented")
+}
+func (UnimplementedServiceServer) SearchPolicies(*SearchPoliciesRequest, Service_SearchPoliciesServer) error {
+	return status.Errorf(codes.Unimplemented, "method SearchPolicies not implemented")
+}
+func (UnimplementedServiceServer) FreezePolicy(*FreezePolicyRequest, Service_FreezePolicyServer) error {
+	return status.Errorf(codes.Unimplemented, "method FreezePolicy not implemented")
+}
+func (UnimplementedServiceServer) GetLastApplyStatus(*GetLastApplyStatusRequest, Service_GetLastApplyStatusServer) error {
+	return status.Errorf(codes.Unimplemented, "method GetLastApplyStatus not implemented")
+}
+func (UnimplementedServiceServer) WhoHas(*WhoHasRequest, Service_WhoHasServer) error {
+	return status.Errorf(codes.Unimplemented, "method WhoHas not implemented")
+}
+func (UnimplementedServiceServer) Owns(*OwnsRequest, Service_OwnsServer) error {
+	return status.Errorf(codes.Unimplemented, "method Owns not implemented")
+}
+func (UnimplementedServiceServer) ReapplyModified(*Empty, Service_ReapplyModifiedServer) error {
+	return status.Errorf(codes.Unimplemented, "method ReapplyModified not implemented")
+}
+func (UnimplementedServiceServer) ReapplyExpired(*Empty, Service_ReapplyExpiredServer) error {
+	return status.Errorf(codes.Unimplemented, "method ReapplyExpired not implemented")
+}
+func (UnimplementedServiceServer) SimulatePolicy(*SimulatePolicyRequest, Service_SimulatePolicyServer) error {
+	return status.Errorf(codes.Unimplemented, "method SimulatePolicy not implemented")
+}
+func (UnimplementedServiceServer) Prune(*PruneRequest, Service_PruneServer) error {
+	return status.Errorf(codes.Unimplemented, "method Prune not implemented")
+}
+func (UnimplementedServiceServer) DeferLogout(*DeferLogoutRequest, Service_DeferLogoutServer) error {
+	return status.Errorf(codes.Unimplemented, "method DeferLogout not implemented")
+}
+func (UnimplementedServiceServer) LimitScript(*LimitScriptRequest, Service_LimitScriptServer) error {
+	return status.Errorf(codes.Unimplemented, "method LimitScript not implemented")
+}
 func (UnimplementedServiceServer) mustEmbedUnimplementedServiceServer() {}
 
 // UnsafeServiceServer may be embedded to opt out of forward compatibility for this service.
@@ -582,6 +1076,27 @@ func (x *serviceUpdatePolicyServer) Send(m *Empty) error {
 	return x.ServerStream.SendMsg(m)
 }
 
+func _Service_UpdatePolicyDryRun_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(UpdatePolicyRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(ServiceServer).UpdatePolicyDryRun(m, &serviceUpdatePolicyDryRunServer{stream})
+}
+
+type Service_UpdatePolicyDryRunServer interface {
+	Send(*StringResponse) error
+	grpc.ServerStream
+}
+
+type serviceUpdatePolicyDryRunServer struct {
+	grpc.ServerStream
+}
+
+func (x *serviceUpdatePolicyDryRunServer) Send(m *StringResponse) error {
+	return x.ServerStream.SendMsg(m)
+}
+
 func _Service_DumpPolicies_Handler(srv interface{}, stream grpc.ServerStream) error {
 	m := new(DumpPoliciesRequest)
 	if err := stream.RecvMsg(m); err != nil {
@@ -708,6 +1223,258 @@ func (x *serviceGPOListScriptServer) Send(m *StringResponse) error {
 	return x.ServerStream.SendMsg(m)
 }
 
+func _Service_ListPolicyKeys_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(ListPolicyKeysRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(ServiceServer).ListPolicyKeys(m, &serviceListPolicyKeysServer{stream})
+}
+
+type Service_ListPolicyKeysServer interface {
+	Send(*StringResponse) error
+	grpc.ServerStream
+}
+
+type serviceListPolicyKeysServer struct {
+	grpc.ServerStream
+}
+
+func (x *serviceListPolicyKeysServer) Send(m *StringResponse) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _Service_SearchPolicies_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(SearchPoliciesRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(ServiceServer).SearchPolicies(m, &serviceSearchPoliciesServer{stream})
+}
+
+type Service_SearchPoliciesServer interface {
+	Send(*StringResponse) error
+	grpc.ServerStream
+}
+
+type serviceSearchPoliciesServer struct {
+	grpc.ServerStream
+}
+
+func (x *serviceSearchPoliciesServer) Send(m *StringResponse) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _Service_FreezePolicy_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(FreezePolicyRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(ServiceServer).FreezePolicy(m, &serviceFreezePolicyServer{stream})
+}
+
+type Service_FreezePolicyServer interface {
+	Send(*Empty) error
+	grpc.ServerStream
+}
+
+type serviceFreezePolicyServer struct {
+	grpc.ServerStream
+}
+
+func (x *serviceFreezePolicyServer) Send(m *Empty) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _Service_GetLastApplyStatus_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(GetLastApplyStatusRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(ServiceServer).GetLastApplyStatus(m, &serviceGetLastApplyStatusServer{stream})
+}
+
+type Service_GetLastApplyStatusServer interface {
+	Send(*StringResponse) error
+	grpc.ServerStream
+}
+
+type serviceGetLastApplyStatusServer struct {
+	grpc.ServerStream
+}
+
+func (x *serviceGetLastApplyStatusServer) Send(m *StringResponse) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _Service_WhoHas_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(WhoHasRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(ServiceServer).WhoHas(m, &serviceWhoHasServer{stream})
+}
+
+type Service_WhoHasServer interface {
+	Send(*StringResponse) error
+	grpc.ServerStream
+}
+
+type serviceWhoHasServer struct {
+	grpc.ServerStream
+}
+
+func (x *serviceWhoHasServer) Send(m *StringResponse) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _Service_Owns_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(OwnsRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(ServiceServer).Owns(m, &serviceOwnsServer{stream})
+}
+
+type Service_OwnsServer interface {
+	Send(*StringResponse) error
+	grpc.ServerStream
+}
+
+type serviceOwnsServer struct {
+	grpc.ServerStream
+}
+
+func (x *serviceOwnsServer) Send(m *StringResponse) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _Service_ReapplyModified_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(Empty)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(ServiceServer).ReapplyModified(m, &serviceReapplyModifiedServer{stream})
+}
+
+type Service_ReapplyModifiedServer interface {
+	Send(*StringResponse) error
+	grpc.ServerStream
+}
+
+type serviceReapplyModifiedServer struct {
+	grpc.ServerStream
+}
+
+func (x *serviceReapplyModifiedServer) Send(m *StringResponse) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _Service_ReapplyExpired_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(Empty)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(ServiceServer).ReapplyExpired(m, &serviceReapplyExpiredServer{stream})
+}
+
+type Service_ReapplyExpiredServer interface {
+	Send(*StringResponse) error
+	grpc.ServerStream
+}
+
+type serviceReapplyExpiredServer struct {
+	grpc.ServerStream
+}
+
+func (x *serviceReapplyExpiredServer) Send(m *StringResponse) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _Service_SimulatePolicy_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(SimulatePolicyRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(ServiceServer).SimulatePolicy(m, &serviceSimulatePolicyServer{stream})
+}
+
+type Service_SimulatePolicyServer interface {
+	Send(*StringResponse) error
+	grpc.ServerStream
+}
+
+type serviceSimulatePolicyServer struct {
+	grpc.ServerStream
+}
+
+func (x *serviceSimulatePolicyServer) Send(m *StringResponse) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _Service_Prune_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(PruneRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(ServiceServer).Prune(m, &servicePruneServer{stream})
+}
+
+type Service_PruneServer interface {
+	Send(*StringResponse) error
+	grpc.ServerStream
+}
+
+type servicePruneServer struct {
+	grpc.ServerStream
+}
+
+func (x *servicePruneServer) Send(m *StringResponse) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _Service_DeferLogout_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(DeferLogoutRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(ServiceServer).DeferLogout(m, &serviceDeferLogoutServer{stream})
+}
+
+type Service_DeferLogoutServer interface {
+	Send(*StringResponse) error
+	grpc.ServerStream
+}
+
+type serviceDeferLogoutServer struct {
+	grpc.ServerStream
+}
+
+func (x *serviceDeferLogoutServer) Send(m *StringResponse) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _Service_LimitScript_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(LimitScriptRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(ServiceServer).LimitScript(m, &serviceLimitScriptServer{stream})
+}
+
+type Service_LimitScriptServer interface {
+	Send(*Empty) error
+	grpc.ServerStream
+}
+
+type serviceLimitScriptServer struct {
+	grpc.ServerStream
+}
+
+func (x *serviceLimitScriptServer) Send(m *Empty) error {
+	return x.ServerStream.SendMsg(m)
+}
+
 // Service_ServiceDesc is the grpc.ServiceDesc for Service service.
 // It's only intended for direct use with grpc.RegisterService,
 // and not to be introspected or modified (even as a copy)
@@ -741,6 +1508,11 @@ var Service_ServiceDesc = grpc.ServiceDesc{
 			Handler:       _Service_UpdatePolicy_Handler,
 			ServerStreams: true,
 		},
+		{
+			StreamName:    "UpdatePolicyDryRun",
+			Handler:       _Service_UpdatePolicyDryRun_Handler,
+			ServerStreams: true,
+		},
 		{
 			StreamName:    "DumpPolicies",
 			Handler:       _Service_DumpPolicies_Handler,
@@ -771,6 +1543,66 @@ var Service_ServiceDesc = grpc.ServiceDesc{
 			Handler:       _Service_GPOListScript_Handler,
 			ServerStreams: true,
 		},
+		{
+			StreamName:    "ListPolicyKeys",
+			Handler:       _Service_ListPolicyKeys_Handler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "SearchPolicies",
+			Handler:       _Service_SearchPolicies_Handler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "FreezePolicy",
+			Handler:       _Service_FreezePolicy_Handler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "GetLastApplyStatus",
+			Handler:       _Service_GetLastApplyStatus_Handler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "WhoHas",
+			Handler:       _Service_WhoHas_Handler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "Owns",
+			Handler:       _Service_Owns_Handler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "ReapplyModified",
+			Handler:       _Service_ReapplyModified_Handler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "ReapplyExpired",
+			Handler:       _Service_ReapplyExpired_Handler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "SimulatePolicy",
+			Handler:       _Service_SimulatePolicy_Handler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "Prune",
+			Handler:       _Service_Prune_Handler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "DeferLogout",
+			Handler:       _Service_DeferLogout_Handler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "LimitScript",
+			Handler:       _Service_LimitScript_Handler,
+			ServerStreams: true,
+		},
 	},
 	Metadata: "adsys.proto",
 }
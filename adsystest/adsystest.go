@@ -0,0 +1,91 @@
+// Package adsystest is the test harness of adsys policy managers, for downstream distributors and plugin authors
+// to test their managers the same way adsys tests its own.
+//
+// It provides:
+//   - a local system bus, with a mock of the Ubuntu Pro subscription service, so that tests never reach the real
+//     system services;
+//   - a fake root tree, laid out as the directories adsys writes policies to;
+//   - golden files and directories comparisons, which are updated when running the tests with -update.
+//
+// A typical test package starts the bus in its TestMain:
+//
+//	func TestMain(m *testing.M) {
+//		adsystest.InstallUpdateFlag()
+//		flag.Parse()
+//
+//		defer adsystest.StartLocalSystemBus()()
+//
+//		// The connection exporting the mock is kept open while tests are running.
+//		conn, err := dbus.SystemBusPrivate()
+//		if err != nil {
+//			log.Fatalf("Setup: can't get a private system bus: %v", err)
+//		}
+//		defer conn.Close()
+//		if err := conn.Auth(nil); err != nil {
+//			log.Fatalf("Setup: can't auth on private system bus: %v", err)
+//		}
+//		if err := conn.Hello(); err != nil {
+//			log.Fatalf("Setup: can't send hello message on private system bus: %v", err)
+//		}
+//		if err := adsystest.ExportSubscriptionService(conn); err != nil {
+//			log.Fatalf("Setup: %v", err)
+//		}
+//
+//		m.Run()
+//	}
+//
+// Each test then builds its own fake root tree and compares it with its golden directory:
+//
+//	root := adsystest.NewFakeRoot(t)
+//	// Apply policies in root directories.
+//	adsystest.CompareTreesWithFiltering(t, root.Dir, adsystest.GoldenPath(t), adsystest.Update())
+//
+// This package is not embedded in the final binary.
+package adsystest
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+// FakeRoot is a temporary root tree, with the system directories policy managers write to.
+type FakeRoot struct {
+	// Dir is the root directory of the tree.
+	Dir string
+
+	CacheDir      string
+	RunDir        string
+	DconfDir      string
+	PolicyKitDir  string
+	SudoersDir    string
+	ApparmorDir   string
+	SystemUnitDir string
+	NetplanDir    string
+	JournaldDir   string
+	SSSDConf      string
+	HooksDir      string
+	PluginsDir    string
+}
+
+// NewFakeRoot returns a fake root tree in a temporary directory, removed on test shutdown.
+// Only the root directory is created: policy managers create the ones they need.
+func NewFakeRoot(t *testing.T) FakeRoot {
+	t.Helper()
+
+	dir := t.TempDir()
+	return FakeRoot{
+		Dir:           dir,
+		CacheDir:      filepath.Join(dir, "var", "cache", "adsys"),
+		RunDir:        filepath.Join(dir, "run", "adsys"),
+		DconfDir:      filepath.Join(dir, "etc", "dconf"),
+		PolicyKitDir:  filepath.Join(dir, "etc", "polkit-1"),
+		SudoersDir:    filepath.Join(dir, "etc", "sudoers.d"),
+		ApparmorDir:   filepath.Join(dir, "etc", "apparmor.d", "adsys"),
+		SystemUnitDir: filepath.Join(dir, "etc", "systemd", "system"),
+		NetplanDir:    filepath.Join(dir, "etc", "netplan"),
+		JournaldDir:   filepath.Join(dir, "etc", "systemd", "journald.conf.d"),
+		SSSDConf:      filepath.Join(dir, "etc", "sssd", "sssd.conf"),
+		HooksDir:      filepath.Join(dir, "etc", "adsys", "hooks.d"),
+		PluginsDir:    filepath.Join(dir, "usr", "lib", "adsys", "plugins"),
+	}
+}
@@ -0,0 +1,187 @@
+package adsystest
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/godbus/dbus/v5"
+	"github.com/godbus/dbus/v5/introspect"
+	"github.com/godbus/dbus/v5/prop"
+	"github.com/stretchr/testify/require"
+	"github.com/ubuntu/adsys/internal/consts"
+)
+
+var (
+	sdbus sync.Once
+
+	sdbusMU                sync.Mutex
+	nbRunningTestsSdbus    uint
+	stopDbus               context.CancelFunc
+	dbusCmd                *exec.Cmd
+	config                 string
+	savedDbusSystemAddress string
+)
+
+// StartLocalSystemBus allows to start and set environment variable to a local bus, preventing polluting system ones.
+func StartLocalSystemBus() func() {
+	sdbusMU.Lock()
+	defer sdbusMU.Unlock()
+	nbRunningTestsSdbus++
+
+	sdbus.Do(func() {
+		dir, err := os.MkdirTemp("", "adsys-tests-dbus")
+		if err != nil {
+			log.Fatalf("Setup: can’t create dbus system directory: %v", err)
+		}
+
+		savedDbusSystemAddress = os.Getenv("DBUS_SYSTEM_BUS_ADDRESS")
+		config = filepath.Join(dir, "dbus.config")
+		err = os.WriteFile(config, []byte(`<!DOCTYPE busconfig PUBLIC "-//freedesktop//DTD D-Bus Bus Configuration 1.0//EN"
+ "http://www.freedesktop.org/standards/dbus/1.0/busconfig.dtd">
+<busconfig>
+  <type>system</type>
+  <keep_umask/>
+  <listen>unix:tmpdir=/tmp</listen>
+  <standard_system_servicedirs />
+  <policy context="default">
+    <allow send_destination="*" eavesdrop="true"/>
+    <allow eavesdrop="true"/>
+    <allow own="*"/>
+  </policy>
+</busconfig>`), 0600)
+		if err != nil {
+			log.Fatalf("Setup: can’t create dbus configuration: %v", err)
+		}
+		var ctx context.Context
+		ctx, stopDbus = context.WithCancel(context.Background())
+		// #nosec G204 - this is only for tests, we are in control of the config
+		dbusCmd = exec.CommandContext(ctx, "dbus-daemon", "--print-address=1", "--config-file="+config)
+		dbusStdout, err := dbusCmd.StdoutPipe()
+		if err != nil {
+			_ = os.RemoveAll(dir)
+			log.Fatalf("couldn't get stdout of dbus-daemon: %v", err)
+		}
+		if err := dbusCmd.Start(); err != nil {
+			_ = os.RemoveAll(dir)
+			log.Fatalf("couldn't start dbus-daemon: %v", err)
+		}
+		dbusAddr := make([]byte, 256)
+		n, err := dbusStdout.Read(dbusAddr)
+		if err != nil {
+			_ = os.RemoveAll(dir)
+			log.Fatalf("couldn't get dbus address: %v", err)
+		}
+		dbusAddr = dbusAddr[:n]
+		if err := os.Setenv("DBUS_SYSTEM_BUS_ADDRESS", string(dbusAddr)); err != nil {
+			_ = os.RemoveAll(dir)
+			log.Fatalf("couldn't set DBUS_SYSTEM_BUS_ADDRESS: %v", err)
+		}
+	})
+
+	return func() {
+		sdbusMU.Lock()
+		defer sdbusMU.Unlock()
+		nbRunningTestsSdbus--
+
+		if nbRunningTestsSdbus != 0 {
+			return
+		}
+
+		stopDbus()
+		// dbus command is killed
+		_ = dbusCmd.Wait()
+
+		if err := os.RemoveAll(filepath.Dir(config)); err != nil {
+			log.Fatalf("couldn't remove dbus configuration directory: %v", err)
+		}
+
+		if err := os.Setenv("DBUS_SYSTEM_BUS_ADDRESS", savedDbusSystemAddress); err != nil {
+			log.Fatalf("couldn't restore DBUS_SYSTEM_BUS_ADDRESS: %v", err)
+		}
+
+		// Restore dbus system launcher
+		sdbus = sync.Once{}
+	}
+}
+
+// NewDbusConn returns a system dbus connection which automatically close on test shutdown.
+func NewDbusConn(t *testing.T) *dbus.Conn {
+	t.Helper()
+
+	bus, err := dbus.SystemBusPrivate()
+	require.NoError(t, err, "Setup: can’t get a private system bus")
+
+	t.Cleanup(func() {
+		err = bus.Close()
+		require.NoError(t, err, "Teardown: can’t close system dbus connection")
+	})
+	err = bus.Auth(nil)
+	require.NoError(t, err, "Setup: can’t auth on private system bus")
+	err = bus.Hello()
+	require.NoError(t, err, "Setup: can’t send hello message on private system bus")
+
+	return bus
+}
+
+// ExportSubscriptionService exports on conn a mock of the Ubuntu Pro subscription service, owning its well-known
+// name. The subscription is attached until changed with SetSubscriptionAttached.
+func ExportSubscriptionService(conn *dbus.Conn) error {
+	intro := fmt.Sprintf(`
+	<node>
+		<interface name="%s">
+			<property name='Attached' type='b' access="readwrite"/>
+		</interface>%s%s</node>`, consts.SubscriptionDbusInterface, introspect.IntrospectDataString, prop.IntrospectDataString)
+	ua := struct{}{}
+	if err := conn.Export(ua, consts.SubscriptionDbusObjectPath, consts.SubscriptionDbusInterface); err != nil {
+		return fmt.Errorf("could not export subscription object: %w", err)
+	}
+
+	propsSpec := map[string]map[string]*prop.Prop{
+		consts.SubscriptionDbusInterface: {
+			"Attached": {
+				Value:    true,
+				Writable: true,
+				Emit:     prop.EmitTrue,
+				Callback: func(c *prop.Change) *dbus.Error { return nil },
+			},
+		},
+	}
+	if _, err := prop.Export(conn, consts.SubscriptionDbusObjectPath, propsSpec); err != nil {
+		return fmt.Errorf("could not export property for subscription object: %w", err)
+	}
+
+	if err := conn.Export(introspect.Introspectable(intro), consts.SubscriptionDbusObjectPath,
+		"org.freedesktop.DBus.Introspectable"); err != nil {
+		return fmt.Errorf("could not export introspectable subscription object: %w", err)
+	}
+
+	reply, err := conn.RequestName(consts.SubscriptionDbusRegisteredName, dbus.NameFlagDoNotQueue)
+	if err != nil {
+		return fmt.Errorf("failed to acquire subscription name on local system bus: %w", err)
+	}
+	if reply != dbus.RequestNameReplyPrimaryOwner {
+		return fmt.Errorf("failed to acquire subscription name on local system bus: name is already taken")
+	}
+	return nil
+}
+
+// SetSubscriptionAttached changes the subscription status served by the mock exported with ExportSubscriptionService.
+// The subscription is detached again on test shutdown.
+func SetSubscriptionAttached(t *testing.T, bus *dbus.Conn, attached bool) {
+	t.Helper()
+
+	subscriptionDbus := bus.Object(consts.SubscriptionDbusRegisteredName, dbus.ObjectPath(consts.SubscriptionDbusObjectPath))
+	err := subscriptionDbus.SetProperty(consts.SubscriptionDbusInterface+".Attached", attached)
+	require.NoError(t, err, "Setup: can not set subscription status to %v", attached)
+
+	t.Cleanup(func() {
+		err := subscriptionDbus.SetProperty(consts.SubscriptionDbusInterface+".Attached", false)
+		require.NoError(t, err, "Teardown: can not restore subscription status")
+	})
+}
@@ -0,0 +1,825 @@
+# Generated by the gRPC Python protocol compiler plugin. DO NOT EDIT!
+"""Client and server classes corresponding to protobuf-defined services."""
+import grpc
+
+import adsys_pb2 as adsys__pb2
+
+
+class serviceStub(object):
+    """Missing associated documentation comment in .proto file."""
+
+    def __init__(self, channel):
+        """Constructor.
+
+        Args:
+            channel: A grpc.Channel.
+        """
+        self.Cat = channel.unary_stream(
+                '/service/Cat',
+                request_serializer=adsys__pb2.Empty.SerializeToString,
+                response_deserializer=adsys__pb2.StringResponse.FromString,
+                )
+        self.Version = channel.unary_stream(
+                '/service/Version',
+                request_serializer=adsys__pb2.Empty.SerializeToString,
+                response_deserializer=adsys__pb2.StringResponse.FromString,
+                )
+        self.Status = channel.unary_stream(
+                '/service/Status',
+                request_serializer=adsys__pb2.Empty.SerializeToString,
+                response_deserializer=adsys__pb2.StringResponse.FromString,
+                )
+        self.Stop = channel.unary_stream(
+                '/service/Stop',
+                request_serializer=adsys__pb2.StopRequest.SerializeToString,
+                response_deserializer=adsys__pb2.Empty.FromString,
+                )
+        self.UpdatePolicy = channel.unary_stream(
+                '/service/UpdatePolicy',
+                request_serializer=adsys__pb2.UpdatePolicyRequest.SerializeToString,
+                response_deserializer=adsys__pb2.Empty.FromString,
+                )
+        self.UpdatePolicyDryRun = channel.unary_stream(
+                '/service/UpdatePolicyDryRun',
+                request_serializer=adsys__pb2.UpdatePolicyRequest.SerializeToString,
+                response_deserializer=adsys__pb2.StringResponse.FromString,
+                )
+        self.DumpPolicies = channel.unary_stream(
+                '/service/DumpPolicies',
+                request_serializer=adsys__pb2.DumpPoliciesRequest.SerializeToString,
+                response_deserializer=adsys__pb2.StringResponse.FromString,
+                )
+        self.DumpPoliciesDefinitions = channel.unary_stream(
+                '/service/DumpPoliciesDefinitions',
+                request_serializer=adsys__pb2.DumpPolicyDefinitionsRequest.SerializeToString,
+                response_deserializer=adsys__pb2.DumpPolicyDefinitionsResponse.FromString,
+                )
+        self.GetDoc = channel.unary_stream(
+                '/service/GetDoc',
+                request_serializer=adsys__pb2.GetDocRequest.SerializeToString,
+                response_deserializer=adsys__pb2.StringResponse.FromString,
+                )
+        self.ListDoc = channel.unary_stream(
+                '/service/ListDoc',
+                request_serializer=adsys__pb2.ListDocRequest.SerializeToString,
+                response_deserializer=adsys__pb2.StringResponse.FromString,
+                )
+        self.ListUsers = channel.unary_stream(
+                '/service/ListUsers',
+                request_serializer=adsys__pb2.ListUsersRequest.SerializeToString,
+                response_deserializer=adsys__pb2.StringResponse.FromString,
+                )
+        self.GPOListScript = channel.unary_stream(
+                '/service/GPOListScript',
+                request_serializer=adsys__pb2.Empty.SerializeToString,
+                response_deserializer=adsys__pb2.StringResponse.FromString,
+                )
+        self.ListPolicyKeys = channel.unary_stream(
+                '/service/ListPolicyKeys',
+                request_serializer=adsys__pb2.ListPolicyKeysRequest.SerializeToString,
+                response_deserializer=adsys__pb2.StringResponse.FromString,
+                )
+        self.SearchPolicies = channel.unary_stream(
+                '/service/SearchPolicies',
+                request_serializer=adsys__pb2.SearchPoliciesRequest.SerializeToString,
+                response_deserializer=adsys__pb2.StringResponse.FromString,
+                )
+        self.FreezePolicy = channel.unary_stream(
+                '/service/FreezePolicy',
+                request_serializer=adsys__pb2.FreezePolicyRequest.SerializeToString,
+                response_deserializer=adsys__pb2.Empty.FromString,
+                )
+        self.GetLastApplyStatus = channel.unary_stream(
+                '/service/GetLastApplyStatus',
+                request_serializer=adsys__pb2.GetLastApplyStatusRequest.SerializeToString,
+                response_deserializer=adsys__pb2.StringResponse.FromString,
+                )
+        self.WhoHas = channel.unary_stream(
+                '/service/WhoHas',
+                request_serializer=adsys__pb2.WhoHasRequest.SerializeToString,
+                response_deserializer=adsys__pb2.StringResponse.FromString,
+                )
+        self.Owns = channel.unary_stream(
+                '/service/Owns',
+                request_serializer=adsys__pb2.OwnsRequest.SerializeToString,
+                response_deserializer=adsys__pb2.StringResponse.FromString,
+                )
+        self.ReapplyModified = channel.unary_stream(
+                '/service/ReapplyModified',
+                request_serializer=adsys__pb2.Empty.SerializeToString,
+                response_deserializer=adsys__pb2.StringResponse.FromString,
+                )
+        self.ReapplyExpired = channel.unary_stream(
+                '/service/ReapplyExpired',
+                request_serializer=adsys__pb2.Empty.SerializeToString,
+                response_deserializer=adsys__pb2.StringResponse.FromString,
+                )
+        self.SimulatePolicy = channel.unary_stream(
+                '/service/SimulatePolicy',
+                request_serializer=adsys__pb2.SimulatePolicyRequest.SerializeToString,
+                response_deserializer=adsys__pb2.StringResponse.FromString,
+                )
+        self.Prune = channel.unary_stream(
+                '/service/Prune',
+                request_serializer=adsys__pb2.PruneRequest.SerializeToString,
+                response_deserializer=adsys__pb2.StringResponse.FromString,
+                )
+        self.DeferLogout = channel.unary_stream(
+                '/service/DeferLogout',
+                request_serializer=adsys__pb2.DeferLogoutRequest.SerializeToString,
+                response_deserializer=adsys__pb2.StringResponse.FromString,
+                )
+        self.LimitScript = channel.unary_stream(
+                '/service/LimitScript',
+                request_serializer=adsys__pb2.LimitScriptRequest.SerializeToString,
+                response_deserializer=adsys__pb2.Empty.FromString,
+                )
+
+
+class serviceServicer(object):
+    """Missing associated documentation comment in .proto file."""
+
+    def Cat(self, request, context):
+        """Missing associated documentation comment in .proto file."""
+        context.set_code(grpc.StatusCode.UNIMPLEMENTED)
+        context.set_details('Method not implemented!')
+        raise NotImplementedError('Method not implemented!')
+
+    def Version(self, request, context):
+        """Missing associated documentation comment in .proto file."""
+        context.set_code(grpc.StatusCode.UNIMPLEMENTED)
+        context.set_details('Method not implemented!')
+        raise NotImplementedError('Method not implemented!')
+
+    def Status(self, request, context):
+        """Missing associated documentation comment in .proto file."""
+        context.set_code(grpc.StatusCode.UNIMPLEMENTED)
+        context.set_details('Method not implemented!')
+        raise NotImplementedError('Method not implemented!')
+
+    def Stop(self, request, context):
+        """Missing associated documentation comment in .proto file."""
+        context.set_code(grpc.StatusCode.UNIMPLEMENTED)
+        context.set_details('Method not implemented!')
+        raise NotImplementedError('Method not implemented!')
+
+    def UpdatePolicy(self, request, context):
+        """Missing associated documentation comment in .proto file."""
+        context.set_code(grpc.StatusCode.UNIMPLEMENTED)
+        context.set_details('Method not implemented!')
+        raise NotImplementedError('Method not implemented!')
+
+    def UpdatePolicyDryRun(self, request, context):
+        """Missing associated documentation comment in .proto file."""
+        context.set_code(grpc.StatusCode.UNIMPLEMENTED)
+        context.set_details('Method not implemented!')
+        raise NotImplementedError('Method not implemented!')
+
+    def DumpPolicies(self, request, context):
+        """Missing associated documentation comment in .proto file."""
+        context.set_code(grpc.StatusCode.UNIMPLEMENTED)
+        context.set_details('Method not implemented!')
+        raise NotImplementedError('Method not implemented!')
+
+    def DumpPoliciesDefinitions(self, request, context):
+        """Missing associated documentation comment in .proto file."""
+        context.set_code(grpc.StatusCode.UNIMPLEMENTED)
+        context.set_details('Method not implemented!')
+        raise NotImplementedError('Method not implemented!')
+
+    def GetDoc(self, request, context):
+        """Missing associated documentation comment in .proto file."""
+        context.set_code(grpc.StatusCode.UNIMPLEMENTED)
+        context.set_details('Method not implemented!')
+        raise NotImplementedError('Method not implemented!')
+
+    def ListDoc(self, request, context):
+        """Missing associated documentation comment in .proto file."""
+        context.set_code(grpc.StatusCode.UNIMPLEMENTED)
+        context.set_details('Method not implemented!')
+        raise NotImplementedError('Method not implemented!')
+
+    def ListUsers(self, request, context):
+        """Missing associated documentation comment in .proto file."""
+        context.set_code(grpc.StatusCode.UNIMPLEMENTED)
+        context.set_details('Method not implemented!')
+        raise NotImplementedError('Method not implemented!')
+
+    def GPOListScript(self, request, context):
+        """Missing associated documentation comment in .proto file."""
+        context.set_code(grpc.StatusCode.UNIMPLEMENTED)
+        context.set_details('Method not implemented!')
+        raise NotImplementedError('Method not implemented!')
+
+    def ListPolicyKeys(self, request, context):
+        """Missing associated documentation comment in .proto file."""
+        context.set_code(grpc.StatusCode.UNIMPLEMENTED)
+        context.set_details('Method not implemented!')
+        raise NotImplementedError('Method not implemented!')
+
+    def SearchPolicies(self, request, context):
+        """Missing associated documentation comment in .proto file."""
+        context.set_code(grpc.StatusCode.UNIMPLEMENTED)
+        context.set_details('Method not implemented!')
+        raise NotImplementedError('Method not implemented!')
+
+    def FreezePolicy(self, request, context):
+        """Missing associated documentation comment in .proto file."""
+        context.set_code(grpc.StatusCode.UNIMPLEMENTED)
+        context.set_details('Method not implemented!')
+        raise NotImplementedError('Method not implemented!')
+
+    def GetLastApplyStatus(self, request, context):
+        """Missing associated documentation comment in .proto file."""
+        context.set_code(grpc.StatusCode.UNIMPLEMENTED)
+        context.set_details('Method not implemented!')
+        raise NotImplementedError('Method not implemented!')
+
+    def WhoHas(self, request, context):
+        """Missing associated documentation comment in .proto file."""
+        context.set_code(grpc.StatusCode.UNIMPLEMENTED)
+        context.set_details('Method not implemented!')
+        raise NotImplementedError('Method not implemented!')
+
+    def Owns(self, request, context):
+        """Missing associated documentation comment in .proto file."""
+        context.set_code(grpc.StatusCode.UNIMPLEMENTED)
+        context.set_details('Method not implemented!')
+        raise NotImplementedError('Method not implemented!')
+
+    def ReapplyModified(self, request, context):
+        """Missing associated documentation comment in .proto file."""
+        context.set_code(grpc.StatusCode.UNIMPLEMENTED)
+        context.set_details('Method not implemented!')
+        raise NotImplementedError('Method not implemented!')
+
+    def ReapplyExpired(self, request, context):
+        """Missing associated documentation comment in .proto file."""
+        context.set_code(grpc.StatusCode.UNIMPLEMENTED)
+        context.set_details('Method not implemented!')
+        raise NotImplementedError('Method not implemented!')
+
+    def SimulatePolicy(self, request, context):
+        """Missing associated documentation comment in .proto file."""
+        context.set_code(grpc.StatusCode.UNIMPLEMENTED)
+        context.set_details('Method not implemented!')
+        raise NotImplementedError('Method not implemented!')
+
+    def Prune(self, request, context):
+        """Missing associated documentation comment in .proto file."""
+        context.set_code(grpc.StatusCode.UNIMPLEMENTED)
+        context.set_details('Method not implemented!')
+        raise NotImplementedError('Method not implemented!')
+
+    def DeferLogout(self, request, context):
+        """Missing associated documentation comment in .proto file."""
+        context.set_code(grpc.StatusCode.UNIMPLEMENTED)
+        context.set_details('Method not implemented!')
+        raise NotImplementedError('Method not implemented!')
+
+    def LimitScript(self, request, context):
+        """Missing associated documentation comment in .proto file."""
+        context.set_code(grpc.StatusCode.UNIMPLEMENTED)
+        context.set_details('Method not implemented!')
+        raise NotImplementedError('Method not implemented!')
+
+
+def add_serviceServicer_to_server(servicer, server):
+    rpc_method_handlers = {
+            'Cat': grpc.unary_stream_rpc_method_handler(
+                    servicer.Cat,
+                    request_deserializer=adsys__pb2.Empty.FromString,
+                    response_serializer=adsys__pb2.StringResponse.SerializeToString,
+            ),
+            'Version': grpc.unary_stream_rpc_method_handler(
+                    servicer.Version,
+                    request_deserializer=adsys__pb2.Empty.FromString,
+                    response_serializer=adsys__pb2.StringResponse.SerializeToString,
+            ),
+            'Status': grpc.unary_stream_rpc_method_handler(
+                    servicer.Status,
+                    request_deserializer=adsys__pb2.Empty.FromString,
+                    response_serializer=adsys__pb2.StringResponse.SerializeToString,
+            ),
+            'Stop': grpc.unary_stream_rpc_method_handler(
+                    servicer.Stop,
+                    request_deserializer=adsys__pb2.StopRequest.FromString,
+                    response_serializer=adsys__pb2.Empty.SerializeToString,
+            ),
+            'UpdatePolicy': grpc.unary_stream_rpc_method_handler(
+                    servicer.UpdatePolicy,
+                    request_deserializer=adsys__pb2.UpdatePolicyRequest.FromString,
+                    response_serializer=adsys__pb2.Empty.SerializeToString,
+            ),
+            'UpdatePolicyDryRun': grpc.unary_stream_rpc_method_handler(
+                    servicer.UpdatePolicyDryRun,
+                    request_deserializer=adsys__pb2.UpdatePolicyRequest.FromString,
+                    response_serializer=adsys__pb2.StringResponse.SerializeToString,
+            ),
+            'DumpPolicies': grpc.unary_stream_rpc_method_handler(
+                    servicer.DumpPolicies,
+                    request_deserializer=adsys__pb2.DumpPoliciesRequest.FromString,
+                    response_serializer=adsys__pb2.StringResponse.SerializeToString,
+            ),
+            'DumpPoliciesDefinitions': grpc.unary_stream_rpc_method_handler(
+                    servicer.DumpPoliciesDefinitions,
+                    request_deserializer=adsys__pb2.DumpPolicyDefinitionsRequest.FromString,
+                    response_serializer=adsys__pb2.DumpPolicyDefinitionsResponse.SerializeToString,
+            ),
+            'GetDoc': grpc.unary_stream_rpc_method_handler(
+                    servicer.GetDoc,
+                    request_deserializer=adsys__pb2.GetDocRequest.FromString,
+                    response_serializer=adsys__pb2.StringResponse.SerializeToString,
+            ),
+            'ListDoc': grpc.unary_stream_rpc_method_handler(
+                    servicer.ListDoc,
+                    request_deserializer=adsys__pb2.ListDocRequest.FromString,
+                    response_serializer=adsys__pb2.StringResponse.SerializeToString,
+            ),
+            'ListUsers': grpc.unary_stream_rpc_method_handler(
+                    servicer.ListUsers,
+                    request_deserializer=adsys__pb2.ListUsersRequest.FromString,
+                    response_serializer=adsys__pb2.StringResponse.SerializeToString,
+            ),
+            'GPOListScript': grpc.unary_stream_rpc_method_handler(
+                    servicer.GPOListScript,
+                    request_deserializer=adsys__pb2.Empty.FromString,
+                    response_serializer=adsys__pb2.StringResponse.SerializeToString,
+            ),
+            'ListPolicyKeys': grpc.unary_stream_rpc_method_handler(
+                    servicer.ListPolicyKeys,
+                    request_deserializer=adsys__pb2.ListPolicyKeysRequest.FromString,
+                    response_serializer=adsys__pb2.StringResponse.SerializeToString,
+            ),
+            'SearchPolicies': grpc.unary_stream_rpc_method_handler(
+                    servicer.SearchPolicies,
+                    request_deserializer=adsys__pb2.SearchPoliciesRequest.FromString,
+                    response_serializer=adsys__pb2.StringResponse.SerializeToString,
+            ),
+            'FreezePolicy': grpc.unary_stream_rpc_method_handler(
+                    servicer.FreezePolicy,
+                    request_deserializer=adsys__pb2.FreezePolicyRequest.FromString,
+                    response_serializer=adsys__pb2.Empty.SerializeToString,
+            ),
+            'GetLastApplyStatus': grpc.unary_stream_rpc_method_handler(
+                    servicer.GetLastApplyStatus,
+                    request_deserializer=adsys__pb2.GetLastApplyStatusRequest.FromString,
+                    response_serializer=adsys__pb2.StringResponse.SerializeToString,
+            ),
+            'WhoHas': grpc.unary_stream_rpc_method_handler(
+                    servicer.WhoHas,
+                    request_deserializer=adsys__pb2.WhoHasRequest.FromString,
+                    response_serializer=adsys__pb2.StringResponse.SerializeToString,
+            ),
+            'Owns': grpc.unary_stream_rpc_method_handler(
+                    servicer.Owns,
+                    request_deserializer=adsys__pb2.OwnsRequest.FromString,
+                    response_serializer=adsys__pb2.StringResponse.SerializeToString,
+            ),
+            'ReapplyModified': grpc.unary_stream_rpc_method_handler(
+                    servicer.ReapplyModified,
+                    request_deserializer=adsys__pb2.Empty.FromString,
+                    response_serializer=adsys__pb2.StringResponse.SerializeToString,
+            ),
+            'ReapplyExpired': grpc.unary_stream_rpc_method_handler(
+                    servicer.ReapplyExpired,
+                    request_deserializer=adsys__pb2.Empty.FromString,
+                    response_serializer=adsys__pb2.StringResponse.SerializeToString,
+            ),
+            'SimulatePolicy': grpc.unary_stream_rpc_method_handler(
+                    servicer.SimulatePolicy,
+                    request_deserializer=adsys__pb2.SimulatePolicyRequest.FromString,
+                    response_serializer=adsys__pb2.StringResponse.SerializeToString,
+            ),
+            'Prune': grpc.unary_stream_rpc_method_handler(
+                    servicer.Prune,
+                    request_deserializer=adsys__pb2.PruneRequest.FromString,
+                    response_serializer=adsys__pb2.StringResponse.SerializeToString,
+            ),
+            'DeferLogout': grpc.unary_stream_rpc_method_handler(
+                    servicer.DeferLogout,
+                    request_deserializer=adsys__pb2.DeferLogoutRequest.FromString,
+                    response_serializer=adsys__pb2.StringResponse.SerializeToString,
+            ),
+            'LimitScript': grpc.unary_stream_rpc_method_handler(
+                    servicer.LimitScript,
+                    request_deserializer=adsys__pb2.LimitScriptRequest.FromString,
+                    response_serializer=adsys__pb2.Empty.SerializeToString,
+            ),
+    }
+    generic_handler = grpc.method_handlers_generic_handler(
+            'service', rpc_method_handlers)
+    server.add_generic_rpc_handlers((generic_handler,))
+
+
+ # This class is part of an EXPERIMENTAL API.
+class service(object):
+    """Missing associated documentation comment in .proto file."""
+
+    @staticmethod
+    def Cat(request,
+            target,
+            options=(),
+            channel_credentials=None,
+            call_credentials=None,
+            insecure=False,
+            compression=None,
+            wait_for_ready=None,
+            timeout=None,
+            metadata=None):
+        return grpc.experimental.unary_stream(request, target, '/service/Cat',
+            adsys__pb2.Empty.SerializeToString,
+            adsys__pb2.StringResponse.FromString,
+            options, channel_credentials,
+            insecure, call_credentials, compression, wait_for_ready, timeout, metadata)
+
+    @staticmethod
+    def Version(request,
+            target,
+            options=(),
+            channel_credentials=None,
+            call_credentials=None,
+            insecure=False,
+            compression=None,
+            wait_for_ready=None,
+            timeout=None,
+            metadata=None):
+        return grpc.experimental.unary_stream(request, target, '/service/Version',
+            adsys__pb2.Empty.SerializeToString,
+            adsys__pb2.StringResponse.FromString,
+            options, channel_credentials,
+            insecure, call_credentials, compression, wait_for_ready, timeout, metadata)
+
+    @staticmethod
+    def Status(request,
+            target,
+            options=(),
+            channel_credentials=None,
+            call_credentials=None,
+            insecure=False,
+            compression=None,
+            wait_for_ready=None,
+            timeout=None,
+            metadata=None):
+        return grpc.experimental.unary_stream(request, target, '/service/Status',
+            adsys__pb2.Empty.SerializeToString,
+            adsys__pb2.StringResponse.FromString,
+            options, channel_credentials,
+            insecure, call_credentials, compression, wait_for_ready, timeout, metadata)
+
+    @staticmethod
+    def Stop(request,
+            target,
+            options=(),
+            channel_credentials=None,
+            call_credentials=None,
+            insecure=False,
+            compression=None,
+            wait_for_ready=None,
+            timeout=None,
+            metadata=None):
+        return grpc.experimental.unary_stream(request, target, '/service/Stop',
+            adsys__pb2.StopRequest.SerializeToString,
+            adsys__pb2.Empty.FromString,
+            options, channel_credentials,
+            insecure, call_credentials, compression, wait_for_ready, timeout, metadata)
+
+    @staticmethod
+    def UpdatePolicy(request,
+            target,
+            options=(),
+            channel_credentials=None,
+            call_credentials=None,
+            insecure=False,
+            compression=None,
+            wait_for_ready=None,
+            timeout=None,
+            metadata=None):
+        return grpc.experimental.unary_stream(request, target, '/service/UpdatePolicy',
+            adsys__pb2.UpdatePolicyRequest.SerializeToString,
+            adsys__pb2.Empty.FromString,
+            options, channel_credentials,
+            insecure, call_credentials, compression, wait_for_ready, timeout, metadata)
+
+    @staticmethod
+    def UpdatePolicyDryRun(request,
+            target,
+            options=(),
+            channel_credentials=None,
+            call_credentials=None,
+            insecure=False,
+            compression=None,
+            wait_for_ready=None,
+            timeout=None,
+            metadata=None):
+        return grpc.experimental.unary_stream(request, target, '/service/UpdatePolicyDryRun',
+            adsys__pb2.UpdatePolicyRequest.SerializeToString,
+            adsys__pb2.StringResponse.FromString,
+            options, channel_credentials,
+            insecure, call_credentials, compression, wait_for_ready, timeout, metadata)
+
+    @staticmethod
+    def DumpPolicies(request,
+            target,
+            options=(),
+            channel_credentials=None,
+            call_credentials=None,
+            insecure=False,
+            compression=None,
+            wait_for_ready=None,
+            timeout=None,
+            metadata=None):
+        return grpc.experimental.unary_stream(request, target, '/service/DumpPolicies',
+            adsys__pb2.DumpPoliciesRequest.SerializeToString,
+            adsys__pb2.StringResponse.FromString,
+            options, channel_credentials,
+            insecure, call_credentials, compression, wait_for_ready, timeout, metadata)
+
+    @staticmethod
+    def DumpPoliciesDefinitions(request,
+            target,
+            options=(),
+            channel_credentials=None,
+            call_credentials=None,
+            insecure=False,
+            compression=None,
+            wait_for_ready=None,
+            timeout=None,
+            metadata=None):
+        return grpc.experimental.unary_stream(request, target, '/service/DumpPoliciesDefinitions',
+            adsys__pb2.DumpPolicyDefinitionsRequest.SerializeToString,
+            adsys__pb2.DumpPolicyDefinitionsResponse.FromString,
+            options, channel_credentials,
+            insecure, call_credentials, compression, wait_for_ready, timeout, metadata)
+
+    @staticmethod
+    def GetDoc(request,
+            target,
+            options=(),
+            channel_credentials=None,
+            call_credentials=None,
+            insecure=False,
+            compression=None,
+            wait_for_ready=None,
+            timeout=None,
+            metadata=None):
+        return grpc.experimental.unary_stream(request, target, '/service/GetDoc',
+            adsys__pb2.GetDocRequest.SerializeToString,
+            adsys__pb2.StringResponse.FromString,
+            options, channel_credentials,
+            insecure, call_credentials, compression, wait_for_ready, timeout, metadata)
+
+    @staticmethod
+    def ListDoc(request,
+            target,
+            options=(),
+            channel_credentials=None,
+            call_credentials=None,
+            insecure=False,
+            compression=None,
+            wait_for_ready=None,
+            timeout=None,
+            metadata=None):
+        return grpc.experimental.unary_stream(request, target, '/service/ListDoc',
+            adsys__pb2.ListDocRequest.SerializeToString,
+            adsys__pb2.StringResponse.FromString,
+            options, channel_credentials,
+            insecure, call_credentials, compression, wait_for_ready, timeout, metadata)
+
+    @staticmethod
+    def ListUsers(request,
+            target,
+            options=(),
+            channel_credentials=None,
+            call_credentials=None,
+            insecure=False,
+            compression=None,
+            wait_for_ready=None,
+            timeout=None,
+            metadata=None):
+        return grpc.experimental.unary_stream(request, target, '/service/ListUsers',
+            adsys__pb2.ListUsersRequest.SerializeToString,
+            adsys__pb2.StringResponse.FromString,
+            options, channel_credentials,
+            insecure, call_credentials, compression, wait_for_ready, timeout, metadata)
+
+    @staticmethod
+    def GPOListScript(request,
+            target,
+            options=(),
+            channel_credentials=None,
+            call_credentials=None,
+            insecure=False,
+            compression=None,
+            wait_for_ready=None,
+            timeout=None,
+            metadata=None):
+        return grpc.experimental.unary_stream(request, target, '/service/GPOListScript',
+            adsys__pb2.Empty.SerializeToString,
+            adsys__pb2.StringResponse.FromString,
+            options, channel_credentials,
+            insecure, call_credentials, compression, wait_for_ready, timeout, metadata)
+
+    @staticmethod
+    def ListPolicyKeys(request,
+            target,
+            options=(),
+            channel_credentials=None,
+            call_credentials=None,
+            insecure=False,
+            compression=None,
+            wait_for_ready=None,
+            timeout=None,
+            metadata=None):
+        return grpc.experimental.unary_stream(request, target, '/service/ListPolicyKeys',
+            adsys__pb2.ListPolicyKeysRequest.SerializeToString,
+            adsys__pb2.StringResponse.FromString,
+            options, channel_credentials,
+            insecure, call_credentials, compression, wait_for_ready, timeout, metadata)
+
+    @staticmethod
+    def SearchPolicies(request,
+            target,
+            options=(),
+            channel_credentials=None,
+            call_credentials=None,
+            insecure=False,
+            compression=None,
+            wait_for_ready=None,
+            timeout=None,
+            metadata=None):
+        return grpc.experimental.unary_stream(request, target, '/service/SearchPolicies',
+            adsys__pb2.SearchPoliciesRequest.SerializeToString,
+            adsys__pb2.StringResponse.FromString,
+            options, channel_credentials,
+            insecure, call_credentials, compression, wait_for_ready, timeout, metadata)
+
+    @staticmethod
+    def FreezePolicy(request,
+            target,
+            options=(),
+            channel_credentials=None,
+            call_credentials=None,
+            insecure=False,
+            compression=None,
+            wait_for_ready=None,
+            timeout=None,
+            metadata=None):
+        return grpc.experimental.unary_stream(request, target, '/service/FreezePolicy',
+            adsys__pb2.FreezePolicyRequest.SerializeToString,
+            adsys__pb2.Empty.FromString,
+            options, channel_credentials,
+            insecure, call_credentials, compression, wait_for_ready, timeout, metadata)
+
+    @staticmethod
+    def GetLastApplyStatus(request,
+            target,
+            options=(),
+            channel_credentials=None,
+            call_credentials=None,
+            insecure=False,
+            compression=None,
+            wait_for_ready=None,
+            timeout=None,
+            metadata=None):
+        return grpc.experimental.unary_stream(request, target, '/service/GetLastApplyStatus',
+            adsys__pb2.GetLastApplyStatusRequest.SerializeToString,
+            adsys__pb2.StringResponse.FromString,
+            options, channel_credentials,
+            insecure, call_credentials, compression, wait_for_ready, timeout, metadata)
+
+    @staticmethod
+    def WhoHas(request,
+            target,
+            options=(),
+            channel_credentials=None,
+            call_credentials=None,
+            insecure=False,
+            compression=None,
+            wait_for_ready=None,
+            timeout=None,
+            metadata=None):
+        return grpc.experimental.unary_stream(request, target, '/service/WhoHas',
+            adsys__pb2.WhoHasRequest.SerializeToString,
+            adsys__pb2.StringResponse.FromString,
+            options, channel_credentials,
+            insecure, call_credentials, compression, wait_for_ready, timeout, metadata)
+
+    @staticmethod
+    def Owns(request,
+            target,
+            options=(),
+            channel_credentials=None,
+            call_credentials=None,
+            insecure=False,
+            compression=None,
+            wait_for_ready=None,
+            timeout=None,
+            metadata=None):
+        return grpc.experimental.unary_stream(request, target, '/service/Owns',
+            adsys__pb2.OwnsRequest.SerializeToString,
+            adsys__pb2.StringResponse.FromString,
+            options, channel_credentials,
+            insecure, call_credentials, compression, wait_for_ready, timeout, metadata)
+
+    @staticmethod
+    def ReapplyModified(request,
+            target,
+            options=(),
+            channel_credentials=None,
+            call_credentials=None,
+            insecure=False,
+            compression=None,
+            wait_for_ready=None,
+            timeout=None,
+            metadata=None):
+        return grpc.experimental.unary_stream(request, target, '/service/ReapplyModified',
+            adsys__pb2.Empty.SerializeToString,
+            adsys__pb2.StringResponse.FromString,
+            options, channel_credentials,
+            insecure, call_credentials, compression, wait_for_ready, timeout, metadata)
+
+    @staticmethod
+    def ReapplyExpired(request,
+            target,
+            options=(),
+            channel_credentials=None,
+            call_credentials=None,
+            insecure=False,
+            compression=None,
+            wait_for_ready=None,
+            timeout=None,
+            metadata=None):
+        return grpc.experimental.unary_stream(request, target, '/service/ReapplyExpired',
+            adsys__pb2.Empty.SerializeToString,
+            adsys__pb2.StringResponse.FromString,
+            options, channel_credentials,
+            insecure, call_credentials, compression, wait_for_ready, timeout, metadata)
+
+    @staticmethod
+    def SimulatePolicy(request,
+            target,
+            options=(),
+            channel_credentials=None,
+            call_credentials=None,
+            insecure=False,
+            compression=None,
+            wait_for_ready=None,
+            timeout=None,
+            metadata=None):
+        return grpc.experimental.unary_stream(request, target, '/service/SimulatePolicy',
+            adsys__pb2.SimulatePolicyRequest.SerializeToString,
+            adsys__pb2.StringResponse.FromString,
+            options, channel_credentials,
+            insecure, call_credentials, compression, wait_for_ready, timeout, metadata)
+
+    @staticmethod
+    def Prune(request,
+            target,
+            options=(),
+            channel_credentials=None,
+            call_credentials=None,
+            insecure=False,
+            compression=None,
+            wait_for_ready=None,
+            timeout=None,
+            metadata=None):
+        return grpc.experimental.unary_stream(request, target, '/service/Prune',
+            adsys__pb2.PruneRequest.SerializeToString,
+            adsys__pb2.StringResponse.FromString,
+            options, channel_credentials,
+            insecure, call_credentials, compression, wait_for_ready, timeout, metadata)
+
+    @staticmethod
+    def DeferLogout(request,
+            target,
+            options=(),
+            channel_credentials=None,
+            call_credentials=None,
+            insecure=False,
+            compression=None,
+            wait_for_ready=None,
+            timeout=None,
+            metadata=None):
+        return grpc.experimental.unary_stream(request, target, '/service/DeferLogout',
+            adsys__pb2.DeferLogoutRequest.SerializeToString,
+            adsys__pb2.StringResponse.FromString,
+            options, channel_credentials,
+            insecure, call_credentials, compression, wait_for_ready, timeout, metadata)
+
+    @staticmethod
+    def LimitScript(request,
+            target,
+            options=(),
+            channel_credentials=None,
+            call_credentials=None,
+            insecure=False,
+            compression=None,
+            wait_for_ready=None,
+            timeout=None,
+            metadata=None):
+        return grpc.experimental.unary_stream(request, target, '/service/LimitScript',
+            adsys__pb2.LimitScriptRequest.SerializeToString,
+            adsys__pb2.Empty.FromString,
+            options, channel_credentials,
+            insecure, call_credentials, compression, wait_for_ready, timeout, metadata)
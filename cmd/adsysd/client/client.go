@@ -13,6 +13,7 @@ import (
 	"github.com/ubuntu/adsys/internal/consts"
 	"github.com/ubuntu/adsys/internal/grpc/grpcerror"
 	"github.com/ubuntu/adsys/internal/i18n"
+	"github.com/ubuntu/adsys/internal/snap"
 	"github.com/ubuntu/decorate"
 )
 
@@ -88,7 +89,12 @@ func New() *App {
 
 	cmdhandler.InstallVerboseFlag(&a.rootCmd, a.viper)
 	cmdhandler.InstallConfigFlag(&a.rootCmd, true)
-	cmdhandler.InstallSocketFlag(&a.rootCmd, a.viper, consts.DefaultSocket)
+	defaultSocket := consts.DefaultSocket
+	// The client shipped in the snap talks to the confined daemon.
+	if snap.Running() {
+		defaultSocket = snap.DefaultPaths().Socket
+	}
+	cmdhandler.InstallSocketFlag(&a.rootCmd, a.viper, defaultSocket)
 
 	a.rootCmd.PersistentFlags().IntP("timeout", "t", consts.DefaultClientTimeout, i18n.G("time in seconds before cancelling the client request when the server gives no result. 0 for no timeout."))
 	decorate.LogOnError(a.viper.BindPFlag("client_timeout", a.rootCmd.PersistentFlags().Lookup("timeout")))
@@ -97,6 +103,7 @@ func New() *App {
 	a.installDoc()
 	a.installPolicy()
 	a.installService()
+	a.installSupportBundle()
 	a.installVersion()
 
 	return &a
@@ -5,18 +5,25 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"net"
 	"os"
 	"os/user"
+	"path/filepath"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/fatih/color"
 	"github.com/spf13/cobra"
 	"github.com/ubuntu/adsys"
+	"github.com/ubuntu/adsys/internal/ad"
+	"github.com/ubuntu/adsys/internal/ad/gpobackup"
 	"github.com/ubuntu/adsys/internal/adsysservice"
 	"github.com/ubuntu/adsys/internal/cmdhandler"
 	"github.com/ubuntu/adsys/internal/consts"
 	log "github.com/ubuntu/adsys/internal/grpc/logstreamer"
 	"github.com/ubuntu/adsys/internal/i18n"
+	"github.com/ubuntu/adsys/internal/refreshtrigger"
 )
 
 func (a *App) installPolicy() {
@@ -43,7 +50,30 @@ func (a *App) installPolicy() {
 	distro = mainCmd.Flags().StringP("distro", "", consts.DistroID, i18n.G("distro for which to retrieve policy definition."))
 	policyCmd.AddCommand(mainCmd)
 
-	var details, all, nocolor, isMachine *bool
+	var keysDistro *string
+	keysCmd := &cobra.Command{
+		Use:   "keys [MANAGER]",
+		Short: i18n.G("List policy keys supported by adsys with the manager consuming them"),
+		Args:  cmdhandler.ZeroOrNArgs(1),
+		ValidArgsFunction: func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+			if len(args) != 0 {
+				return nil, cobra.ShellCompDirectiveNoFileComp
+			}
+			return policyKeysManagers(a.ctx, *keysDistro), cobra.ShellCompDirectiveNoFileComp
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			var manager string
+			if len(args) > 0 {
+				manager = args[0]
+			}
+			return a.listPolicyKeys(manager, *keysDistro)
+		},
+	}
+	keysDistro = keysCmd.Flags().StringP("distro", "", consts.DistroID, i18n.G("distro for which to list policy keys."))
+	policyCmd.AddCommand(keysCmd)
+
+	var details, all, nocolor, isMachine, onlyOverridden *bool
+	var format, compareTarget, compareExport, filterManager, filterGPO *string
 	appliedCmd := &cobra.Command{
 		Use:   "applied [USER_NAME]",
 		Short: i18n.G("Print last applied GPOs for current or given user/machine"),
@@ -60,16 +90,151 @@ func (a *App) installPolicy() {
 			if len(args) > 0 {
 				target = args[0]
 			}
-			return a.dumpPolicies(target, *details, *all, *nocolor, *isMachine)
+			return a.dumpPolicies(target, *format, *details, *all, *nocolor, *isMachine, *compareTarget, *compareExport,
+				*filterManager, *filterGPO, *onlyOverridden)
 		},
 	}
 	details = appliedCmd.Flags().BoolP("details", "", false, i18n.G("show applied rules in addition to GPOs."))
 	all = appliedCmd.Flags().BoolP("all", "a", false, i18n.G("show overridden rules in each GPOs."))
 	nocolor = appliedCmd.Flags().BoolP("no-color", "", false, i18n.G("don't display colorized version."))
 	isMachine = appliedCmd.Flags().BoolP("machine", "m", false, i18n.G("show applied rules to the machine."))
+	format = appliedCmd.Flags().StringP("format", "", "text", i18n.G("output format: text, json or yaml. json and yaml always list all rules, including the overridden ones."))
+	compareTarget = appliedCmd.Flags().StringP("compare", "", "", i18n.G("show the differences with the policies applied to this other user or machine."))
+	compareExport = appliedCmd.Flags().StringP("compare-export", "", "", i18n.G("show the differences with this json or yaml export of policies, for instance from another machine."))
+	filterManager = appliedCmd.Flags().StringP("manager", "", "", i18n.G("only show the rules of this policy manager. Implies --details."))
+	filterGPO = appliedCmd.Flags().StringP("gpo", "", "", i18n.G("only show the GPO with this name or ID."))
+	onlyOverridden = appliedCmd.Flags().BoolP("overridden", "", false, i18n.G("only show the overridden rules. Implies --details and --all."))
 	policyCmd.AddCommand(appliedCmd)
 	cmdhandler.RegisterAlias(appliedCmd, &a.rootCmd)
 
+	var searchMachine *bool
+	searchCmd := &cobra.Command{
+		Use:   "search QUERY [USER_NAME]",
+		Short: i18n.G("Search applied policy entries whose key or value contains QUERY for current or given user/machine"),
+		Args:  cobra.RangeArgs(1, 2),
+		ValidArgsFunction: func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+			if len(args) != 1 {
+				return nil, cobra.ShellCompDirectiveNoFileComp
+			}
+
+			return a.users(true), cobra.ShellCompDirectiveNoFileComp
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			var target string
+			if len(args) > 1 {
+				target = args[1]
+			}
+			return a.searchPolicies(args[0], target, *searchMachine)
+		},
+	}
+	searchMachine = searchCmd.Flags().BoolP("machine", "m", false, i18n.G("only search rules applied to the machine."))
+	policyCmd.AddCommand(searchCmd)
+
+	var simulateBackup *string
+	var simulateAll, simulateNocolor, simulateMachine *bool
+	simulateCmd := &cobra.Command{
+		Use:   "simulate [USER_NAME]",
+		Short: i18n.G("Print the policies which would apply to current or given user/machine if a GPO backup was linked with the highest precedence"),
+		Args:  cmdhandler.ZeroOrNArgs(1),
+		ValidArgsFunction: func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+			if len(args) != 0 {
+				return nil, cobra.ShellCompDirectiveNoFileComp
+			}
+
+			return a.users(true), cobra.ShellCompDirectiveNoFileComp
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			var target string
+			if len(args) > 0 {
+				target = args[0]
+			}
+			return a.simulatePolicies(*simulateBackup, target, *simulateAll, *simulateNocolor, *simulateMachine)
+		},
+	}
+	simulateBackup = simulateCmd.Flags().StringP("gpo-backup", "", "", i18n.G("directory of the GPO backup made with the Group Policy Management Console."))
+	simulateAll = simulateCmd.Flags().BoolP("all", "a", false, i18n.G("show overridden rules in each GPOs."))
+	simulateNocolor = simulateCmd.Flags().BoolP("no-color", "", false, i18n.G("don't display colorized version."))
+	simulateMachine = simulateCmd.Flags().BoolP("machine", "m", false, i18n.G("simulate the machine policies of the GPO backup."))
+	// The flag exists: marking it can't fail.
+	_ = simulateCmd.MarkFlagRequired("gpo-backup")
+	_ = simulateCmd.MarkFlagDirname("gpo-backup")
+	policyCmd.AddCommand(simulateCmd)
+
+	var statusMachine *bool
+	var statusFormat *string
+	statusCmd := &cobra.Command{
+		Use:   "status [USER_NAME]",
+		Short: i18n.G("Print the status of each policy manager during the last policy apply for current or given user/machine"),
+		Args:  cmdhandler.ZeroOrNArgs(1),
+		ValidArgsFunction: func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+			if len(args) != 0 {
+				return nil, cobra.ShellCompDirectiveNoFileComp
+			}
+
+			return a.users(true), cobra.ShellCompDirectiveNoFileComp
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			var target string
+			if len(args) > 0 {
+				target = args[0]
+			}
+			return a.lastApplyStatus(target, *statusMachine, *statusFormat)
+		},
+	}
+	statusMachine = statusCmd.Flags().BoolP("machine", "m", false, i18n.G("only show the status of the last policy apply to the machine."))
+	statusFormat = statusCmd.Flags().StringP("format", "", "text", i18n.G("output format: text, json or yaml."))
+	policyCmd.AddCommand(statusCmd)
+
+	whoHasCmd := &cobra.Command{
+		Use:   "who-has KEY [VALUE]",
+		Short: i18n.G("List the users receiving the policy entry KEY, optionally set to VALUE, in their applied policies"),
+		Args:  cobra.RangeArgs(1, 2),
+		ValidArgsFunction: func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+			return nil, cobra.ShellCompDirectiveNoFileComp
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			var value string
+			if len(args) > 1 {
+				value = args[1]
+			}
+			return a.whoHas(args[0], value)
+		},
+	}
+	policyCmd.AddCommand(whoHasCmd)
+
+	var ownsPath, ownsManager, ownsFormat *string
+	ownsCmd := &cobra.Command{
+		Use:   "owns",
+		Short: i18n.G("List the files managed by adsys, with their policy manager, GPOs and expected hash"),
+		Args:  cobra.NoArgs,
+		ValidArgsFunction: func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+			return nil, cobra.ShellCompDirectiveNoFileComp
+		},
+		RunE: func(cmd *cobra.Command, args []string) error { return a.owns(*ownsPath, *ownsManager, *ownsFormat) },
+	}
+	ownsPath = ownsCmd.Flags().StringP("path", "p", "", i18n.G("only report whether this file is managed by adsys and where it comes from."))
+	ownsManager = ownsCmd.Flags().StringP("manager", "m", "", i18n.G("only list the files of this policy manager."))
+	ownsFormat = ownsCmd.Flags().StringP("format", "", "text", i18n.G("output format: text, json or yaml. json and yaml list the files by policy manager, for instance to exclude them from other configuration management tools."))
+	policyCmd.AddCommand(ownsCmd)
+
+	reapplyCmd := &cobra.Command{
+		Use:               "reapply-modified",
+		Short:             i18n.G("Apply again the policies whose managed files were modified or removed since their last apply"),
+		Args:              cobra.NoArgs,
+		ValidArgsFunction: cmdhandler.NoValidArgs,
+		RunE:              func(cmd *cobra.Command, args []string) error { return a.reapplyModified() },
+	}
+	policyCmd.AddCommand(reapplyCmd)
+
+	reapplyExpiredCmd := &cobra.Command{
+		Use:               "reapply-expired",
+		Short:             i18n.G("Apply again the policies whose entries expired since their last apply"),
+		Args:              cobra.NoArgs,
+		ValidArgsFunction: cmdhandler.NoValidArgs,
+		RunE:              func(cmd *cobra.Command, args []string) error { return a.reapplyExpired() },
+	}
+	policyCmd.AddCommand(reapplyExpiredCmd)
+
 	debugCmd := &cobra.Command{
 		Use:    "debug",
 		Short:  i18n.G("Debug various policy infos"),
@@ -87,14 +252,16 @@ func (a *App) installPolicy() {
 	}
 	debugCmd.AddCommand(gpoListCmd)
 
-	var updateMachine, updateAll *bool
+	var updateMachine, updateAll, updateDryRun, updateAtLogin *bool
+	var updateIfOlderThan *int
+	var updateTarget *string
 	updateCmd := &cobra.Command{
 		Use:   "update [USER_NAME KERBEROS_TICKET_PATH]",
 		Short: i18n.G("Updates/Create a policy for current user or given user with its kerberos ticket"),
 		Args:  cmdhandler.ZeroOrNArgs(2),
 		ValidArgsFunction: func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
-			// All and machine options don’t take arguments
-			if *updateAll || *updateMachine {
+			// All, machine and target options don’t take arguments
+			if *updateAll || *updateMachine || *updateTarget != "" {
 				return nil, cobra.ShellCompDirectiveNoFileComp
 			}
 			switch len(args) {
@@ -114,22 +281,38 @@ func (a *App) installPolicy() {
 			if len(args) > 0 {
 				user, krb5cc = args[0], args[1]
 			}
-			return a.update(*updateMachine, *updateAll, user, krb5cc)
+			// A target user may not be logged in: its ticket is found by the daemon.
+			var fallbackToMachine bool
+			if *updateTarget != "" {
+				if user != "" {
+					return errors.New(i18n.G("--target cannot be used with USER_NAME and KERBEROS_TICKET_PATH"))
+				}
+				user, fallbackToMachine = *updateTarget, true
+			}
+			return a.update(*updateMachine, *updateAll, user, krb5cc, *updateIfOlderThan, *updateDryRun, fallbackToMachine, *updateAtLogin)
 		},
 	}
 	updateMachine = updateCmd.Flags().BoolP("machine", "m", false, i18n.G("machine updates the policy of the computer."))
 	updateAll = updateCmd.Flags().BoolP("all", "a", false, i18n.G("all updates the policy of the computer and all the logged in users. -m or USER_NAME/TICKET cannot be used with this option."))
+	updateIfOlderThan = updateCmd.Flags().IntP("if-older-than", "", 0, i18n.G("only update if the policies were applied more than this number of seconds ago. 0 always updates. It cannot be used with --all."))
+	updateDryRun = updateCmd.Flags().BoolP("dry-run", "", false, i18n.G("only print the policy entries that the update would add, remove or change, without modifying the system."))
+	updateAtLogin = updateCmd.Flags().BoolP("at-login", "", false, i18n.G("start the session with the cached policies if the user update exceeds the login timeout of the service, and complete it in the background."))
+	updateTarget = updateCmd.Flags().StringP("target", "", "", i18n.G("update the policy of this user, even if not logged in, with its last ticket or the machine credentials."))
+	_ = updateCmd.RegisterFlagCompletionFunc("target", func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+		return a.users(false), cobra.ShellCompDirectiveNoFileComp
+	})
 	policyCmd.AddCommand(updateCmd)
 	cmdhandler.RegisterAlias(updateCmd, &a.rootCmd)
 
 	var purgeMachine, purgeAll *bool
+	var purgeDomain *string
 	purgeCmd := &cobra.Command{
 		Use:   "purge [USER_NAME]",
 		Short: i18n.G("Purges policies for the current user or a specified one"),
 		Args:  cmdhandler.ZeroOrNArgs(1),
 		ValidArgsFunction: func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
-			// All and machine options don’t take arguments
-			if *purgeAll || *purgeMachine || len(args) != 0 {
+			// All, machine and domain options don’t take arguments
+			if *purgeAll || *purgeMachine || *purgeDomain != "" || len(args) != 0 {
 				return nil, cobra.ShellCompDirectiveNoFileComp
 			}
 
@@ -141,14 +324,75 @@ func (a *App) installPolicy() {
 			if len(args) > 0 {
 				user = args[0]
 			}
-			return a.purge(*purgeMachine, *purgeAll, user)
+			return a.purge(*purgeMachine, *purgeAll, *purgeDomain, user)
 		},
 	}
 	purgeMachine = purgeCmd.Flags().BoolP("machine", "m", false, i18n.G("machine purges the policy of the computer."))
 	purgeAll = purgeCmd.Flags().BoolP("all", "a", false, i18n.G("all purges the policy of the computer and all the logged in users. -m or USER_NAME cannot be used with this option."))
-	purgeCmd.MarkFlagsMutuallyExclusive("machine", "all")
+	purgeDomain = purgeCmd.Flags().StringP("domain", "d", "", i18n.G("domain purges the policies and the cache of all the users of this domain. -m, -a or USER_NAME cannot be used with this option."))
+	purgeCmd.MarkFlagsMutuallyExclusive("machine", "all", "domain")
 	policyCmd.AddCommand(purgeCmd)
 
+	var remoteKey *string
+	var remotePort *int
+	var remoteMaxDelay *time.Duration
+	remoteRefreshCmd := &cobra.Command{
+		Use:   "remote-refresh HOST...",
+		Short: i18n.G("Ask remote clients to refresh the policies of their machine and all their users now"),
+		Args:  cobra.MinimumNArgs(1),
+		ValidArgsFunction: func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+			return nil, cobra.ShellCompDirectiveNoFileComp
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return remoteRefresh(args, *remoteKey, *remotePort, *remoteMaxDelay)
+		},
+	}
+	remoteKey = remoteRefreshCmd.Flags().StringP("key", "", consts.DefaultRefreshTriggerKey, i18n.G("file containing the key shared with the clients."))
+	remotePort = remoteRefreshCmd.Flags().IntP("port", "p", consts.DefaultRefreshTriggerPort, i18n.G("UDP port the clients receive refresh messages on."))
+	remoteMaxDelay = remoteRefreshCmd.Flags().DurationP("max-delay", "", 0, i18n.G("maximum random delay before each client refreshes, like 10m, to spread the load on the domain controller."))
+	policyCmd.AddCommand(remoteRefreshCmd)
+
+	var freezeDuration *time.Duration
+	freezeCmd := &cobra.Command{
+		Use:               "freeze",
+		Short:             i18n.G("Suspend policy refresh and apply on this machine, keeping the last applied policies"),
+		Args:              cobra.NoArgs,
+		ValidArgsFunction: cmdhandler.NoValidArgs,
+		RunE:              func(cmd *cobra.Command, args []string) error { return a.freeze(*freezeDuration, false) },
+	}
+	freezeDuration = freezeCmd.Flags().DurationP("duration", "d", 0, i18n.G("automatically resume policy updates after this duration, like 2h30m. 0 freezes until policy unfreeze is called."))
+	policyCmd.AddCommand(freezeCmd)
+
+	unfreezeCmd := &cobra.Command{
+		Use:               "unfreeze",
+		Short:             i18n.G("Resume policy refresh and apply on this machine"),
+		Args:              cobra.NoArgs,
+		ValidArgsFunction: cmdhandler.NoValidArgs,
+		RunE:              func(cmd *cobra.Command, args []string) error { return a.freeze(0, true) },
+	}
+	policyCmd.AddCommand(unfreezeCmd)
+
+	deferCmd := &cobra.Command{
+		Use:   "defer [USER_NAME]",
+		Short: i18n.G("Postpone the closing of the sessions of current or given user opened before their policies changed"),
+		Args:  cmdhandler.ZeroOrNArgs(1),
+		ValidArgsFunction: func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+			if len(args) != 0 {
+				return nil, cobra.ShellCompDirectiveNoFileComp
+			}
+
+			return a.users(true), cobra.ShellCompDirectiveNoFileComp
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			var target string
+			if len(args) > 0 {
+				target = args[0]
+			}
+			return a.deferLogout(target)
+		},
+	}
+	policyCmd.AddCommand(deferCmd)
+
 	a.rootCmd.AddCommand(policyCmd)
 }
 
@@ -195,11 +439,79 @@ func (a App) getPolicyDefinitions(format, distroID string) (err error) {
 	return nil
 }
 
-func (a *App) dumpPolicies(target string, showDetails, showOverridden, nocolor, isMachine bool) error {
+// policyKeysManagers returns the policy managers consuming the policy keys of distroID, from the policy definitions
+// embedded at build time, so that the completion follows the definitions the daemon lists.
+func policyKeysManagers(ctx context.Context, distroID string) (managers []string) {
+	keys, err := ad.GetPolicyKeys(ctx, distroID)
+	if err != nil {
+		return nil
+	}
+	// Keys are sorted by manager.
+	for _, k := range keys {
+		if len(managers) == 0 || managers[len(managers)-1] != k.Manager {
+			managers = append(managers, k.Manager)
+		}
+	}
+	return managers
+}
+
+// listPolicyKeys prints the policy keys supported by the daemon, optionally filtered by manager.
+func (a App) listPolicyKeys(manager, distroID string) error {
+	client, err := adsysservice.NewClient(a.config.Socket, a.getTimeout())
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	stream, err := client.ListPolicyKeys(a.ctx, &adsys.ListPolicyKeysRequest{
+		DistroID: distroID,
+		Manager:  manager,
+	})
+	if err != nil {
+		return err
+	}
+
+	keys, err := singleMsg(stream)
+	if err != nil {
+		return err
+	}
+	fmt.Print(keys)
+
+	return nil
+}
+
+func (a *App) dumpPolicies(target, format string, showDetails, showOverridden, nocolor, isMachine bool, compareTarget, compareExport string,
+	filterManager, filterGPO string, onlyOverridden bool) error {
 	// incompatible options
 	if showOverridden && !showDetails {
 		showDetails = true
 	}
+	switch format {
+	case "text", "json", "yaml":
+	default:
+		return fmt.Errorf(i18n.G("unsupported format %q: must be text, json or yaml"), format)
+	}
+	if compareTarget != "" && compareExport != "" {
+		return errors.New(i18n.G("--compare and --compare-export can't be used together"))
+	}
+	if (compareTarget != "" || compareExport != "") && format != "text" {
+		return errors.New(i18n.G("comparisons are only available in text format"))
+	}
+	if (compareTarget != "" || compareExport != "") && (filterManager != "" || filterGPO != "" || onlyOverridden) {
+		return errors.New(i18n.G("comparisons can't be filtered"))
+	}
+
+	// The export is read by the client: the daemon may not have access to it.
+	var export []byte
+	if compareExport != "" {
+		var err error
+		if export, err = os.ReadFile(compareExport); err != nil {
+			return fmt.Errorf(i18n.G("can't read policies export: %w"), err)
+		}
+		if len(export) == 0 {
+			return fmt.Errorf(i18n.G("policies export %s is empty"), compareExport)
+		}
+	}
 
 	client, err := adsysservice.NewClient(a.config.Socket, a.getTimeout())
 	if err != nil {
@@ -225,9 +537,128 @@ func (a *App) dumpPolicies(target string, showDetails, showOverridden, nocolor,
 	}
 
 	stream, err := client.DumpPolicies(a.ctx, &adsys.DumpPoliciesRequest{
+		Target:         target,
+		IsComputer:     isMachine,
+		Details:        showDetails,
+		All:            showOverridden,
+		Format:         format,
+		CompareTarget:  compareTarget,
+		CompareExport:  export,
+		Manager:        filterManager,
+		Gpo:            filterGPO,
+		OnlyOverridden: onlyOverridden,
+	})
+	if err != nil {
+		return err
+	}
+
+	policies, err := singleMsg(stream)
+	if err != nil {
+		return err
+	}
+
+	// Structured output is meant to be parsed, and comparisons are not colorized: print them as is.
+	if format != "text" || compareTarget != "" || export != nil {
+		fmt.Print(policies)
+		return nil
+	}
+
+	if nocolor {
+		color.NoColor = true
+	}
+	policies, err = colorizePolicies(policies)
+	if err != nil {
+		return err
+	}
+	fmt.Print(policies)
+
+	return nil
+}
+
+// searchPolicies prints the applied policy entries of target, or of the machine, matching query.
+func (a *App) searchPolicies(query, target string, isMachine bool) error {
+	client, err := adsysservice.NewClient(a.config.Socket, a.getTimeout())
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	// Search for current user
+	if target == "" {
+		if isMachine {
+			hostname, err := os.Hostname()
+			if err != nil {
+				return fmt.Errorf("failed to retrieve client hostname: %w", err)
+			}
+			target = hostname
+		} else {
+			u, err := user.Current()
+			if err != nil {
+				return fmt.Errorf("failed to retrieve current user: %w", err)
+			}
+			target = u.Username
+		}
+	}
+
+	stream, err := client.SearchPolicies(a.ctx, &adsys.SearchPoliciesRequest{
+		Query:      query,
+		Target:     target,
+		IsComputer: isMachine,
+	})
+	if err != nil {
+		return err
+	}
+
+	entries, err := singleMsg(stream)
+	if err != nil {
+		return err
+	}
+	fmt.Print(entries)
+
+	return nil
+}
+
+// simulatePolicies prints the policies which would apply to target, or to the machine, if the GPO backed up in
+// backupDir was linked with the highest precedence.
+func (a *App) simulatePolicies(backupDir, target string, showOverridden, nocolor, isMachine bool) error {
+	backup, err := gpobackup.Read(backupDir)
+	if err != nil {
+		return err
+	}
+	policy := backup.User
+	if isMachine {
+		policy = backup.Machine
+	}
+
+	client, err := adsysservice.NewClient(a.config.Socket, a.getTimeout())
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	// Simulate for current user
+	if target == "" {
+		if isMachine {
+			hostname, err := os.Hostname()
+			if err != nil {
+				return fmt.Errorf("failed to retrieve client hostname: %w", err)
+			}
+			target = hostname
+		} else {
+			u, err := user.Current()
+			if err != nil {
+				return fmt.Errorf("failed to retrieve current user: %w", err)
+			}
+			target = u.Username
+		}
+	}
+
+	stream, err := client.SimulatePolicy(a.ctx, &adsys.SimulatePolicyRequest{
 		Target:     target,
 		IsComputer: isMachine,
-		Details:    showDetails,
+		GpoID:      backup.ID,
+		GpoName:    backup.Name,
+		Policy:     policy,
 		All:        showOverridden,
 	})
 	if err != nil {
@@ -251,6 +682,236 @@ func (a *App) dumpPolicies(target string, showDetails, showOverridden, nocolor,
 	return nil
 }
 
+// remoteRefresh sends to each host a message, signed with the key stored in keyPath, asking it to refresh its
+// policies within maxDelay.
+func remoteRefresh(hosts []string, keyPath string, port int, maxDelay time.Duration) error {
+	if maxDelay < 0 || maxDelay > refreshtrigger.MaxDelay {
+		return fmt.Errorf(i18n.G("maximum delay must be between 0 and %s"), refreshtrigger.MaxDelay)
+	}
+	key, err := refreshtrigger.LoadKey(keyPath)
+	if err != nil {
+		return err
+	}
+
+	var failed []string
+	for _, host := range hosts {
+		if err := sendRefreshMessage(host, port, refreshtrigger.Message(key, time.Now(), maxDelay)); err != nil {
+			log.Warningf(context.Background(), i18n.G("Can't send refresh message to %s: %v"), host, err)
+			failed = append(failed, host)
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf(i18n.G("refresh message not sent to: %s"), strings.Join(failed, ", "))
+	}
+	return nil
+}
+
+// sendRefreshMessage sends msg to host on the UDP port.
+func sendRefreshMessage(host string, port int, msg []byte) error {
+	conn, err := net.Dial("udp", net.JoinHostPort(host, strconv.Itoa(port)))
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	_, err = conn.Write(msg)
+	return err
+}
+
+// deferLogout postpones the closing of the sessions of target, or of the current user, and prints the new deadline.
+func (a *App) deferLogout(target string) error {
+	client, err := adsysservice.NewClient(a.config.Socket, a.getTimeout())
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	if target == "" {
+		u, err := user.Current()
+		if err != nil {
+			return fmt.Errorf("failed to retrieve current user: %w", err)
+		}
+		target = u.Username
+	}
+
+	stream, err := client.DeferLogout(a.ctx, &adsys.DeferLogoutRequest{
+		Target: target,
+	})
+	if err != nil {
+		return err
+	}
+
+	msg, err := singleMsg(stream)
+	if err != nil {
+		return err
+	}
+	fmt.Print(msg)
+
+	return nil
+}
+
+// lastApplyStatus prints the status of each policy manager during the last policy apply of target, or of the machine.
+func (a *App) lastApplyStatus(target string, isMachine bool, format string) error {
+	switch format {
+	case "text", "json", "yaml":
+	default:
+		return fmt.Errorf(i18n.G("unsupported format %q: must be text, json or yaml"), format)
+	}
+
+	client, err := adsysservice.NewClient(a.config.Socket, a.getTimeout())
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	// Status for current user
+	if target == "" {
+		if isMachine {
+			hostname, err := os.Hostname()
+			if err != nil {
+				return fmt.Errorf("failed to retrieve client hostname: %w", err)
+			}
+			target = hostname
+		} else {
+			u, err := user.Current()
+			if err != nil {
+				return fmt.Errorf("failed to retrieve current user: %w", err)
+			}
+			target = u.Username
+		}
+	}
+
+	stream, err := client.GetLastApplyStatus(a.ctx, &adsys.GetLastApplyStatusRequest{
+		Target:     target,
+		IsComputer: isMachine,
+		Format:     format,
+	})
+	if err != nil {
+		return err
+	}
+
+	status, err := singleMsg(stream)
+	if err != nil {
+		return err
+	}
+	fmt.Print(status)
+
+	return nil
+}
+
+// whoHas prints the users receiving the policy entry key, set to value if not empty.
+func (a *App) whoHas(key, value string) error {
+	client, err := adsysservice.NewClient(a.config.Socket, a.getTimeout())
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	stream, err := client.WhoHas(a.ctx, &adsys.WhoHasRequest{
+		Key:   key,
+		Value: value,
+	})
+	if err != nil {
+		return err
+	}
+
+	users, err := singleMsg(stream)
+	if err != nil {
+		return err
+	}
+	fmt.Print(users)
+
+	return nil
+}
+
+// owns prints the files managed by adsys, or whether path is one of them.
+func (a *App) owns(path, manager, format string) error {
+	switch format {
+	case "text", "json", "yaml":
+	default:
+		return fmt.Errorf(i18n.G("unsupported format %q: must be text, json or yaml"), format)
+	}
+	if path != "" && format != "text" {
+		return errors.New(i18n.G("a single file can only be reported in text format"))
+	}
+
+	// The daemon doesn't run in the client working directory.
+	if path != "" {
+		p, err := filepath.Abs(path)
+		if err != nil {
+			return err
+		}
+		path = p
+	}
+
+	client, err := adsysservice.NewClient(a.config.Socket, a.getTimeout())
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	stream, err := client.Owns(a.ctx, &adsys.OwnsRequest{
+		Path:    path,
+		Manager: manager,
+		Format:  format,
+	})
+	if err != nil {
+		return err
+	}
+
+	files, err := singleMsg(stream)
+	if err != nil {
+		return err
+	}
+	fmt.Print(files)
+
+	return nil
+}
+
+// reapplyModified prints the policy managers applied again as their managed files were modified.
+func (a *App) reapplyModified() error {
+	client, err := adsysservice.NewClient(a.config.Socket, a.getTimeout())
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	stream, err := client.ReapplyModified(a.ctx, &adsys.Empty{})
+	if err != nil {
+		return err
+	}
+
+	msg, err := singleMsg(stream)
+	if err != nil {
+		return err
+	}
+	fmt.Print(msg)
+
+	return nil
+}
+
+// reapplyExpired prints the objects whose policies were applied again as their entries expired.
+func (a *App) reapplyExpired() error {
+	client, err := adsysservice.NewClient(a.config.Socket, a.getTimeout())
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	stream, err := client.ReapplyExpired(a.ctx, &adsys.Empty{})
+	if err != nil {
+		return err
+	}
+
+	msg, err := singleMsg(stream)
+	if err != nil {
+		return err
+	}
+	fmt.Print(msg)
+
+	return nil
+}
+
 func (a *App) dumpGPOListScript() error {
 	client, err := adsysservice.NewClient(a.config.Socket, a.getTimeout())
 	if err != nil {
@@ -323,6 +984,10 @@ func colorizePolicies(policies string) (string, error) {
 			currentPoliciesType = strings.TrimSuffix(e, ":")
 			out.Println(fmt.Sprintf("    - %s", bold.Sprint(e)))
 
+		} else if e := strings.TrimPrefix(l, "*="); e != l {
+			// GPO statistics
+			out.Println(fmt.Sprintf("    %s", strings.TrimSpace(e)))
+
 		} else if e := strings.TrimPrefix(l, "*"); e != l {
 			// GPO
 			e = strings.TrimSpace(e)
@@ -359,14 +1024,26 @@ func (s *stringsBuilderWithError) Println(l string) {
 	_, s.err = s.Builder.WriteString(l)
 }
 
-func (a *App) update(isComputer, updateAll bool, target, krb5cc string) error {
+func (a *App) update(isComputer, updateAll bool, target, krb5cc string, ifOlderThan int, dryRun, fallbackToMachine, atLogin bool) error {
 	// incompatible options
 	if updateAll && (isComputer || target != "" || krb5cc != "") {
 		return errors.New(i18n.G("machine or user arguments cannot be used with update all"))
 	}
+	if updateAll && ifOlderThan != 0 {
+		return errors.New(i18n.G("--if-older-than cannot be used with update all"))
+	}
+	if ifOlderThan < 0 {
+		return errors.New(i18n.G("--if-older-than must be a positive number of seconds"))
+	}
 	if isComputer && (target != "" || krb5cc != "") {
 		return errors.New(i18n.G("user arguments cannot be used with machine update"))
 	}
+	if dryRun && ifOlderThan != 0 {
+		return errors.New(i18n.G("--if-older-than cannot be used with --dry-run"))
+	}
+	if atLogin && (isComputer || updateAll || dryRun) {
+		return errors.New(i18n.G("--at-login can only be used with a user update"))
+	}
 
 	client, err := adsysservice.NewClient(a.config.Socket, a.getTimeout())
 	if err != nil {
@@ -394,11 +1071,29 @@ func (a *App) update(isComputer, updateAll bool, target, krb5cc string) error {
 		krb5cc = strings.TrimPrefix(os.Getenv("KRB5CCNAME"), "FILE:")
 	}
 
-	stream, err := client.UpdatePolicy(a.ctx, &adsys.UpdatePolicyRequest{
-		IsComputer: isComputer,
-		All:        updateAll,
-		Target:     target,
-		Krb5Cc:     krb5cc})
+	req := &adsys.UpdatePolicyRequest{
+		IsComputer:        isComputer,
+		All:               updateAll,
+		Target:            target,
+		Krb5Cc:            krb5cc,
+		IfOlderThan:       int64(ifOlderThan),
+		FallbackToMachine: fallbackToMachine,
+		AtLogin:           atLogin}
+
+	if dryRun {
+		stream, err := client.UpdatePolicyDryRun(a.ctx, req)
+		if err != nil {
+			return err
+		}
+		changes, err := singleMsg(stream)
+		if err != nil {
+			return err
+		}
+		fmt.Print(changes)
+		return nil
+	}
+
+	stream, err := client.UpdatePolicy(a.ctx, req)
 	if err != nil {
 		return err
 	}
@@ -410,11 +1105,44 @@ func (a *App) update(isComputer, updateAll bool, target, krb5cc string) error {
 	return nil
 }
 
-func (a *App) purge(isComputer, purgeAll bool, target string) error {
+// freeze suspends policy updates on the machine for duration, or resumes them.
+func (a *App) freeze(duration time.Duration, unfreeze bool) error {
+	if duration < 0 {
+		return errors.New(i18n.G("--duration must be positive"))
+	}
+	if duration > 0 && duration < time.Second {
+		return errors.New(i18n.G("--duration must be at least one second"))
+	}
+
+	client, err := adsysservice.NewClient(a.config.Socket, a.getTimeout())
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	stream, err := client.FreezePolicy(a.ctx, &adsys.FreezePolicyRequest{
+		Duration: int64(duration / time.Second),
+		Unfreeze: unfreeze,
+	})
+	if err != nil {
+		return err
+	}
+
+	if _, err := stream.Recv(); err != nil && !errors.Is(err, io.EOF) {
+		return err
+	}
+
+	return nil
+}
+
+func (a *App) purge(isComputer, purgeAll bool, domain, target string) error {
 	// incompatible options
 	if purgeAll && target != "" {
 		return errors.New(i18n.G("machine or user arguments cannot be used with update all"))
 	}
+	if domain != "" && target != "" {
+		return errors.New(i18n.G("user arguments cannot be used with domain purge"))
+	}
 	if isComputer && target != "" {
 		return errors.New(i18n.G("user arguments cannot be used with machine update"))
 	}
@@ -436,7 +1164,7 @@ func (a *App) purge(isComputer, purgeAll bool, target string) error {
 	}
 
 	// Purge current user
-	if target == "" && !purgeAll {
+	if target == "" && !purgeAll && domain == "" {
 		u, err := user.Current()
 		if err != nil {
 			return fmt.Errorf("failed to retrieve current user: %w", err)
@@ -449,6 +1177,7 @@ func (a *App) purge(isComputer, purgeAll bool, target string) error {
 		All:        purgeAll,
 		Target:     target,
 		Purge:      true,
+		Domain:     domain,
 	})
 	if err != nil {
 		return err
@@ -1,8 +1,10 @@
 package client
 
 import (
+	"context"
 	"flag"
 	"os"
+	"strings"
 	"testing"
 
 	"github.com/fatih/color"
@@ -13,6 +15,7 @@ import (
 func TestColorizePolicies(t *testing.T) {
 	policies := `Policies from machine configuration:
 * GPOName1 ({GPOId1})
+*= entries: 4, managers: dconf, scripts, downloaded: 1020 bytes, last change: 2023-07-11T12:00:00Z
 ** dconf:
 *** path/to/key1: ValueOfKey1
 *** path/to/key2: ValueOfKey2
@@ -53,6 +56,30 @@ Policies from user configuration:
 	require.Equal(t, want, got, "colorizePolicies returned expected formatted output")
 }
 
+func TestPolicyKeysManagers(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		distroID string
+	}{
+		"Managers of the policy keys": {distroID: "Ubuntu"},
+
+		// Error cases
+		"No manager on unknown distro": {distroID: "does_not_exist"},
+	}
+	for name, tc := range tests {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			got := strings.Join(policyKeysManagers(context.Background(), tc.distroID), "\n")
+
+			want := testutils.LoadWithUpdateFromGolden(t, got)
+			require.Equal(t, want, got, "policyKeysManagers returned unexpected managers")
+		})
+	}
+}
+
 func TestMain(m *testing.M) {
 	testutils.InstallUpdateFlag()
 	flag.Parse()
@@ -51,6 +51,17 @@ func (a *App) installService() {
 	}
 	stopForce = cmd.Flags().BoolP("force", "f", false, i18n.G("force will shut it down immediately and drop existing connections."))
 	mainCmd.AddCommand(cmd)
+
+	var pruneRotate *bool
+	cmd = &cobra.Command{
+		Use:               "prune",
+		Short:             i18n.G("Rotate the service logs over their maximum size and remove the expired ones"),
+		Args:              cobra.NoArgs,
+		ValidArgsFunction: cmdhandler.NoValidArgs,
+		RunE:              func(cmd *cobra.Command, args []string) error { return a.servicePrune(*pruneRotate) },
+	}
+	pruneRotate = cmd.Flags().BoolP("rotate", "", false, i18n.G("rotate the logs even if they are not over their maximum size."))
+	mainCmd.AddCommand(cmd)
 }
 
 func (a *App) serviceCat() error {
@@ -128,3 +139,25 @@ func (a *App) serviceStop(force bool) error {
 
 	return nil
 }
+
+// servicePrune rotates and prunes the service logs, and prints what was freed.
+func (a *App) servicePrune(rotate bool) error {
+	client, err := adsysservice.NewClient(a.config.Socket, a.getTimeout())
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	stream, err := client.Prune(a.ctx, &adsys.PruneRequest{Rotate: rotate})
+	if err != nil {
+		return err
+	}
+
+	summary, err := singleMsg(stream)
+	if err != nil {
+		return err
+	}
+	fmt.Println(summary)
+
+	return nil
+}
@@ -0,0 +1,306 @@
+package client
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"os/user"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/ubuntu/adsys"
+	"github.com/ubuntu/adsys/internal/adsysservice"
+	"github.com/ubuntu/adsys/internal/cmdhandler"
+	"github.com/ubuntu/adsys/internal/consts"
+	"github.com/ubuntu/adsys/internal/i18n"
+	"github.com/ubuntu/adsys/internal/snap"
+	"github.com/ubuntu/decorate"
+)
+
+func (a *App) installSupportBundle() {
+	var since *string
+	cmd := &cobra.Command{
+		Use:               "support-bundle [ARCHIVE]",
+		Short:             i18n.G("Collect logs, status and applied policies in a redacted archive to attach to bug reports"),
+		Args:              cmdhandler.ZeroOrNArgs(1),
+		ValidArgsFunction: cmdhandler.NoValidArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			var dest string
+			if len(args) > 0 {
+				dest = args[0]
+			}
+			return a.supportBundle(dest, *since)
+		},
+	}
+	since = cmd.Flags().StringP("since", "", "-3d", i18n.G("collect service logs newer than this date, in journalctl format."))
+	a.rootCmd.AddCommand(cmd)
+}
+
+// bundleFile is a file of the support bundle, with the function collecting its content.
+type bundleFile struct {
+	name    string
+	collect func() (string, error)
+}
+
+// supportBundle writes a compressed tarball to dest with the daemon and client information useful to debug an issue.
+// Failing to collect some information does not prevent the creation of the bundle: the errors are listed in it.
+func (a *App) supportBundle(dest, since string) (err error) {
+	defer decorate.OnError(&err, i18n.G("can't create support bundle"))
+
+	hostname, err := os.Hostname()
+	if err != nil {
+		return fmt.Errorf("failed to retrieve client hostname: %w", err)
+	}
+	if dest == "" {
+		dest = fmt.Sprintf("adsys-support-%s-%s.tar.gz", hostname, time.Now().Format("20060102-150405"))
+	}
+
+	client, err := adsysservice.NewClient(a.config.Socket, a.getTimeout())
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	files := []bundleFile{
+		{name: "version", collect: func() (string, error) {
+			msg := fmt.Sprintf("%s\t%s\n", CmdName, consts.Version)
+			stream, err := client.Version(a.ctx, &adsys.Empty{})
+			if err != nil {
+				return msg, err
+			}
+			version, err := singleMsg(stream)
+			if err != nil {
+				return msg, err
+			}
+			return msg + fmt.Sprintf("adsysd\t%s\n", version), nil
+		}},
+		{name: "status", collect: func() (string, error) {
+			stream, err := client.Status(a.ctx, &adsys.Empty{})
+			if err != nil {
+				return "", err
+			}
+			return singleMsg(stream)
+		}},
+		{name: "cached-objects", collect: func() (string, error) {
+			stream, err := client.ListUsers(a.ctx, &adsys.ListUsersRequest{})
+			if err != nil {
+				return "", err
+			}
+			return singleMsg(stream)
+		}},
+		{name: "applied-machine", collect: func() (string, error) {
+			stream, err := client.DumpPolicies(a.ctx, &adsys.DumpPoliciesRequest{Target: hostname, IsComputer: true, Details: true, All: true})
+			if err != nil {
+				return "", err
+			}
+			return singleMsg(stream)
+		}},
+		{name: "applied-user", collect: func() (string, error) {
+			u, err := user.Current()
+			if err != nil {
+				return "", fmt.Errorf("failed to retrieve current user: %w", err)
+			}
+			// Root has no policy applied: the user ones are not relevant then.
+			if u.Uid == "0" {
+				return "", nil
+			}
+			stream, err := client.DumpPolicies(a.ctx, &adsys.DumpPoliciesRequest{Target: u.Username, Details: true, All: true})
+			if err != nil {
+				return "", err
+			}
+			return singleMsg(stream)
+		}},
+		{name: "last-apply-status.json", collect: func() (string, error) {
+			u, err := user.Current()
+			if err != nil {
+				return "", fmt.Errorf("failed to retrieve current user: %w", err)
+			}
+			// The status of the current user comes with the machine one.
+			req := &adsys.GetLastApplyStatusRequest{Target: u.Username, Format: "json"}
+			if u.Uid == "0" {
+				req = &adsys.GetLastApplyStatusRequest{Target: hostname, IsComputer: true, Format: "json"}
+			}
+			stream, err := client.GetLastApplyStatus(a.ctx, req)
+			if err != nil {
+				return "", err
+			}
+			return singleMsg(stream)
+		}},
+		{name: "cache-metadata", collect: func() (string, error) {
+			cacheDir := a.viper.GetString("cache_dir")
+			if cacheDir == "" {
+				cacheDir = consts.DefaultCacheDir
+				if snap.Running() {
+					cacheDir = snap.DefaultPaths().CacheDir
+				}
+			}
+			return cacheMetadata(cacheDir)
+		}},
+		{name: "adsysd.log", collect: func() (string, error) {
+			// #nosec G204: since is only passed as an argument to journalctl
+			out, err := exec.CommandContext(a.ctx, "journalctl", "--no-pager", "-o", "short-iso", "--since", since, "-u", "adsysd.service").Output()
+			if err != nil {
+				return string(out), fmt.Errorf("journalctl failed: %w", err)
+			}
+			return string(out), nil
+		}},
+		{name: "adsys.yaml", collect: func() (string, error) {
+			p := a.viper.ConfigFileUsed()
+			if p == "" {
+				return "", nil
+			}
+			d, err := os.ReadFile(p)
+			return string(d), err
+		}},
+	}
+
+	// The bundle can contain sensitive information: only allow the current user to read it, and never overwrite a file.
+	// #nosec G304: dest is provided by the user running the client
+	f, err := os.OpenFile(dest, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	var complete bool
+	defer func() {
+		if err := f.Close(); err != nil {
+			complete = false
+		}
+		// Don’t leave a truncated bundle behind.
+		if !complete {
+			_ = os.Remove(dest)
+		}
+	}()
+	gz := gzip.NewWriter(f)
+	tw := tar.NewWriter(gz)
+
+	dir := strings.TrimSuffix(filepath.Base(dest), ".tar.gz")
+	var collectErrs strings.Builder
+	for _, bf := range files {
+		content, err := bf.collect()
+		if err != nil {
+			fmt.Fprintf(&collectErrs, "%s: %v\n", bf.name, err)
+		}
+		if content == "" {
+			continue
+		}
+		if err := writeBundleFile(tw, filepath.Join(dir, bf.name), redact(content)); err != nil {
+			return err
+		}
+	}
+	if collectErrs.Len() > 0 {
+		if err := writeBundleFile(tw, filepath.Join(dir, "errors"), redact(collectErrs.String())); err != nil {
+			return err
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	if err := gz.Close(); err != nil {
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		return err
+	}
+	complete = true
+
+	fmt.Printf(i18n.G("Support bundle written to %s.\nPlease review its content before sharing it.\n"), dest)
+	if collectErrs.Len() > 0 {
+		return errors.New(i18n.G("some information couldn't be collected, see the errors file in the bundle"))
+	}
+	return nil
+}
+
+// cacheMetadata lists the path, relative to dir, size and modification time of the files in the cache directory dir,
+// without their content.
+// The files which can't be read are listed as far as possible, with the first error returned.
+func cacheMetadata(dir string) (string, error) {
+	var out strings.Builder
+	w := tabwriter.NewWriter(&out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "PATH\tSIZE\tMODIFIED")
+
+	var firstErr error
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			// Skip the unreadable directory, but keep listing the rest of the cache.
+			if d != nil && d.IsDir() {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			return nil
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		// The size of directories depends on the file system: only list their content.
+		size := "-"
+		if d.IsDir() {
+			rel += "/"
+		} else {
+			size = fmt.Sprint(info.Size())
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\n", rel, size, info.ModTime().UTC().Format(time.RFC3339))
+		return nil
+	})
+	if err != nil {
+		return out.String(), err
+	}
+	if err := w.Flush(); err != nil {
+		return out.String(), err
+	}
+	return out.String(), firstErr
+}
+
+func writeBundleFile(tw *tar.Writer, name, content string) error {
+	if err := tw.WriteHeader(&tar.Header{
+		Name:    name,
+		Mode:    0600,
+		Size:    int64(len(content)),
+		ModTime: time.Now(),
+	}); err != nil {
+		return err
+	}
+	_, err := tw.Write([]byte(content))
+	return err
+}
+
+// redactions are the secret patterns replaced in the support bundle, with their replacement.
+var redactions = []struct {
+	re   *regexp.Regexp
+	repl string
+}{
+	// Private keys, spanning multiple lines.
+	{regexp.MustCompile(`(?s)-----BEGIN ([A-Z ]*PRIVATE KEY)-----.*?-----END ([A-Z ]*PRIVATE KEY)-----`), "-----BEGIN $1-----<redacted>-----END $2-----"},
+	// Credentials in URLs, like in proxy settings.
+	{regexp.MustCompile(`(://[^/\s:@]+):[^/\s@]+@`), "$1:<redacted>@"},
+	// Authorization headers.
+	{regexp.MustCompile(`(?i)(authorization:\s*\w+\s+)\S+`), "$1<redacted>"},
+	// Key/value pairs with a secret name, like password=value or "token": "value".
+	{regexp.MustCompile(`(?i)((?:password|passwd|secret|token|credentials?)["']?\s*[:=]\s*)("[^"]*"|'[^']*'|\S+)`), "$1<redacted>"},
+}
+
+// redact replaces the secrets found in s.
+func redact(s string) string {
+	for _, r := range redactions {
+		s = r.re.ReplaceAllString(s, r.repl)
+	}
+	return s
+}
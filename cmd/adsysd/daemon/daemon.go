@@ -4,11 +4,15 @@ package daemon
 import (
 	"context"
 	"fmt"
+	"path/filepath"
+	"reflect"
 	"runtime"
 	"time"
 
+	"github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
+	"github.com/ubuntu/adsys/internal/ad"
 	"github.com/ubuntu/adsys/internal/ad/backends/sss"
 	"github.com/ubuntu/adsys/internal/ad/backends/winbind"
 	"github.com/ubuntu/adsys/internal/adsysservice"
@@ -18,12 +22,19 @@ import (
 	"github.com/ubuntu/adsys/internal/daemon"
 	log "github.com/ubuntu/adsys/internal/grpc/logstreamer"
 	"github.com/ubuntu/adsys/internal/i18n"
+	"github.com/ubuntu/adsys/internal/logrotate"
+	"github.com/ubuntu/adsys/internal/snap"
+	"github.com/ubuntu/adsys/internal/virtenv"
 	"github.com/ubuntu/decorate"
+	"golang.org/x/exp/slices"
 )
 
 // CmdName is the binary name for the daemon.
 const CmdName = "adsysd"
 
+// logRepeatsBaseName is the file in the cache directory keeping the failures collapsed in the journal across runs.
+const logRepeatsBaseName = "log-repeats"
+
 // App encapsulate commands and options of the daemon, which can be controlled by env variables and config files.
 type App struct {
 	rootCmd cobra.Command
@@ -38,22 +49,56 @@ type App struct {
 type daemonConfig struct {
 	Verbose int
 
-	Socket   string
-	CacheDir string `mapstructure:"cache_dir"`
-	RunDir   string `mapstructure:"run_dir"`
+	Socket            string
+	StatusSocket      string `mapstructure:"status_socket"`
+	StatusSocketGroup string `mapstructure:"status_socket_group"`
+	CacheDir          string `mapstructure:"cache_dir"`
+	RunDir            string `mapstructure:"run_dir"`
 
 	DconfDir      string `mapstructure:"dconf_dir"`
+	DconfShards   bool   `mapstructure:"dconf_user_shards"`
+	Notifications bool   `mapstructure:"user_notifications"`
+	Unhandled     bool   `mapstructure:"unhandled_entries"`
+	LastKnownGood int    `mapstructure:"last_known_good_after"`
 	SudoersDir    string `mapstructure:"sudoers_dir"`
 	PolicyKitDir  string `mapstructure:"policykit_dir"`
 	ApparmorDir   string `mapstructure:"apparmor_dir"`
 	ApparmorFsDir string `mapstructure:"apparmorfs_dir"`
 	SystemUnitDir string `mapstructure:"systemunit_dir"`
-
-	AdBackend     string         `mapstructure:"ad_backend"`
-	SSSdConfig    sss.Config     `mapstructure:"sssd"`
-	WinbindConfig winbind.Config `mapstructure:"winbind"`
-
-	ServiceTimeout int `mapstructure:"service_timeout"`
+	MountsDir     string `mapstructure:"mounts_dir"`
+	PluginsDir    string `mapstructure:"plugins_dir"`
+	TransformsDir string `mapstructure:"transforms_dir"`
+	HooksDir      string `mapstructure:"hooks_dir"`
+	MetricsFile   string `mapstructure:"metrics_file"`
+	AuditLog      string `mapstructure:"audit_log"`
+
+	AdBackend     string           `mapstructure:"ad_backend"`
+	SSSdConfig    sss.Config       `mapstructure:"sssd"`
+	WinbindConfig winbind.Config   `mapstructure:"winbind"`
+	Limits        ad.Limits        `mapstructure:"limits"`
+	Offline       ad.OfflinePolicy `mapstructure:"offline"`
+	Backoff       ad.Backoff       `mapstructure:"backoff"`
+
+	LogRetention logrotate.Retention `mapstructure:"log_retention"`
+
+	ServiceTimeout  int `mapstructure:"service_timeout"`
+	GPOLinkCacheTTL int `mapstructure:"gpo_link_cache_ttl"`
+	GPORolloutDelay int `mapstructure:"gpo_rollout_delay"`
+	LoginTimeout    int `mapstructure:"login_timeout"`
+
+	PolicyReadyTimeout int `mapstructure:"policy_ready_timeout"`
+
+	GPORolloutRing string `mapstructure:"gpo_rollout_ring"`
+	Virtualization string `mapstructure:"virtualization"`
+
+	LogRepeatInterval int `mapstructure:"log_repeat_interval"`
+
+	IgnoredGPOs      []string          `mapstructure:"ignored_gpos"`
+	LocalUsers       map[string]string `mapstructure:"local_users"`
+	DomainCacheQuota int64             `mapstructure:"domain_cache_quota"`
+	Precedence       map[string]string `mapstructure:"precedence"`
+	VaultServers     []string          `mapstructure:"vault_servers"`
+	EscrowAttributes []string          `mapstructure:"escrow_attributes"`
 }
 
 // New registers commands and return a new App.
@@ -81,7 +126,7 @@ func New() *App {
 				// Config reload
 
 				// No change in config file: skip.
-				if a.config == newConfig {
+				if reflect.DeepEqual(a.config, newConfig) {
 					return nil
 				}
 
@@ -93,6 +138,7 @@ func New() *App {
 				if oldVerbose != a.config.Verbose {
 					config.SetVerboseMode(a.config.Verbose)
 				}
+				log.SetRepeatInterval(time.Duration(a.config.LogRepeatInterval) * time.Second)
 				if oldSocket != a.config.Socket {
 					if err := a.changeServerSocket(a.config.Socket); err != nil {
 						log.Error(context.Background(), err)
@@ -105,23 +151,25 @@ func New() *App {
 			})
 			// Set configured verbose status for the daemon.
 			config.SetVerboseMode(a.config.Verbose)
+			log.SetRepeatInterval(time.Duration(a.config.LogRepeatInterval) * time.Second)
 			return err
 		},
 
 		RunE: func(cmd *cobra.Command, args []string) error {
-			adsys, err := adsysservice.New(context.Background(),
-				adsysservice.WithCacheDir(a.config.CacheDir),
-				adsysservice.WithRunDir(a.config.RunDir),
-				adsysservice.WithDconfDir(a.config.DconfDir),
-				adsysservice.WithSudoersDir(a.config.SudoersDir),
-				adsysservice.WithPolicyKitDir(a.config.PolicyKitDir),
-				adsysservice.WithApparmorDir(a.config.ApparmorDir),
-				adsysservice.WithApparmorFsDir(a.config.ApparmorFsDir),
-				adsysservice.WithSystemUnitDir(a.config.SystemUnitDir),
-				adsysservice.WithADBackend(a.config.AdBackend),
-				adsysservice.WithSSSConfig(a.config.SSSdConfig),
-				adsysservice.WithWinbindConfig(a.config.WinbindConfig),
-			)
+			// Wait for commands applying policies on their own, like provision, to end.
+			unlock, err := lockRunDir(a.config.RunDir, true)
+			if err != nil {
+				close(a.ready)
+				return err
+			}
+			defer unlock()
+
+			// The daemon exits when idle: keep collapsing the failures repeated across its runs.
+			if err := log.PersistRepeats(logrus.StandardLogger(), filepath.Join(a.config.CacheDir, logRepeatsBaseName)); err != nil {
+				log.Warningf(context.Background(), i18n.G("Can't restore the failures collapsed in the journal: %v"), err)
+			}
+
+			adsys, err := a.newService(context.Background())
 			if err != nil {
 				close(a.ready)
 				return err
@@ -130,7 +178,8 @@ func New() *App {
 			timeout := time.Duration(a.config.ServiceTimeout) * time.Second
 			d, err := daemon.New(adsys.RegisterGRPCServer, a.config.Socket,
 				daemon.WithTimeout(timeout),
-				daemon.WithServerQuit(adsys.Quit))
+				daemon.WithServerQuit(adsys.Quit),
+				daemon.WithStatusSocket(a.config.StatusSocket, a.config.StatusSocketGroup, adsys.RegisterStatusGRPCServer))
 			if err != nil {
 				close(a.ready)
 				return err
@@ -146,15 +195,29 @@ func New() *App {
 
 	cmdhandler.InstallVerboseFlag(&a.rootCmd, a.viper)
 	cmdhandler.InstallConfigFlag(&a.rootCmd, true)
-	cmdhandler.InstallSocketFlag(&a.rootCmd, a.viper, consts.DefaultSocket)
+	defaultSocket, defaultCacheDir, defaultRunDir := consts.DefaultSocket, consts.DefaultCacheDir, consts.DefaultRunDir
+	// A strictly confined snap can only write in its own areas.
+	if snap.Running() {
+		paths := snap.DefaultPaths()
+		defaultSocket, defaultCacheDir, defaultRunDir = paths.Socket, paths.CacheDir, paths.RunDir
+		a.viper.SetDefault("audit_log", paths.AuditLog)
+		a.viper.SetDefault("plugins_dir", paths.PluginsDir)
+		a.viper.SetDefault("transforms_dir", paths.TransformsDir)
+		a.viper.SetDefault("hooks_dir", paths.HooksDir)
+	}
+	cmdhandler.InstallSocketFlag(&a.rootCmd, a.viper, defaultSocket)
+	a.viper.SetDefault("log_retention.compress", true)
+	a.viper.SetDefault("log_repeat_interval", consts.DefaultLogRepeatInterval)
 
-	a.rootCmd.PersistentFlags().StringP("cache-dir", "", consts.DefaultCacheDir, i18n.G("directory where ADsys caches GPOs downloads and policies."))
+	a.rootCmd.PersistentFlags().StringP("cache-dir", "", defaultCacheDir, i18n.G("directory where ADsys caches GPOs downloads and policies."))
 	decorate.LogOnError(a.viper.BindPFlag("cache_dir", a.rootCmd.PersistentFlags().Lookup("cache-dir")))
-	a.rootCmd.PersistentFlags().StringP("run-dir", "", consts.DefaultRunDir, i18n.G("directory where ADsys stores transient information erased on reboot."))
+	a.rootCmd.PersistentFlags().StringP("run-dir", "", defaultRunDir, i18n.G("directory where ADsys stores transient information erased on reboot."))
 	decorate.LogOnError(a.viper.BindPFlag("run_dir", a.rootCmd.PersistentFlags().Lookup("run-dir")))
 
 	a.rootCmd.PersistentFlags().IntP("timeout", "t", consts.DefaultServiceTimeout, i18n.G("time in seconds without activity before the service exists. 0 for no timeout."))
 	decorate.LogOnError(a.viper.BindPFlag("service_timeout", a.rootCmd.PersistentFlags().Lookup("timeout")))
+	a.rootCmd.PersistentFlags().IntP("gpo-link-cache-ttl", "", consts.DefaultGPOLinkCacheTTL, i18n.G("time in seconds the GPO links of AD containers are cached between requests. 0 disables the cache."))
+	decorate.LogOnError(a.viper.BindPFlag("gpo_link_cache_ttl", a.rootCmd.PersistentFlags().Lookup("gpo-link-cache-ttl")))
 
 	a.rootCmd.PersistentFlags().StringP("ad-backend", "", "sssd", i18n.G("Active Directory authentication backend"))
 	decorate.LogOnError(a.viper.BindPFlag("ad_backend", a.rootCmd.PersistentFlags().Lookup("ad-backend")))
@@ -167,9 +230,83 @@ func New() *App {
 	a.installVersion()
 	a.installRunScripts()
 	a.installMount()
+	a.installNotify()
+	a.installWaitReady()
+	a.installDumpCache()
+	a.installRefreshTrigger()
+	a.installProvision()
 	return &a
 }
 
+// newService returns the adsys service configured by the daemon configuration.
+func (a *App) newService(ctx context.Context) (*adsysservice.Service, error) {
+	return adsysservice.New(ctx,
+		adsysservice.WithCacheDir(a.config.CacheDir),
+		adsysservice.WithRunDir(a.config.RunDir),
+		adsysservice.WithDconfDir(a.config.DconfDir),
+		adsysservice.WithDconfUserShards(a.config.DconfShards),
+		adsysservice.WithUserNotifications(a.config.Notifications),
+		adsysservice.WithUnhandledEntries(a.config.Unhandled),
+		adsysservice.WithLastKnownGoodAfter(a.config.LastKnownGood),
+		adsysservice.WithDisabledPolicyManagers(disabledPolicyManagers(a.config.AdBackend, a.config.Virtualization)),
+		adsysservice.WithSudoersDir(a.config.SudoersDir),
+		adsysservice.WithPolicyKitDir(a.config.PolicyKitDir),
+		adsysservice.WithApparmorDir(a.config.ApparmorDir),
+		adsysservice.WithApparmorFsDir(a.config.ApparmorFsDir),
+		adsysservice.WithSystemUnitDir(a.config.SystemUnitDir),
+		adsysservice.WithMountsDir(a.config.MountsDir),
+		adsysservice.WithPluginsDir(a.config.PluginsDir),
+		adsysservice.WithTransformsDir(a.config.TransformsDir),
+		adsysservice.WithHooksDir(a.config.HooksDir),
+		adsysservice.WithMetricsFile(a.config.MetricsFile),
+		adsysservice.WithAuditLog(a.config.AuditLog),
+		adsysservice.WithLogRetention(a.config.LogRetention),
+		adsysservice.WithGPOLinkCacheTTL(time.Duration(a.config.GPOLinkCacheTTL)*time.Second),
+		adsysservice.WithGPORolloutDelay(time.Duration(a.config.GPORolloutDelay)*time.Second),
+		adsysservice.WithGPORolloutRing(a.config.GPORolloutRing),
+		adsysservice.WithLoginTimeout(time.Duration(a.config.LoginTimeout)*time.Second),
+		adsysservice.WithADBackend(a.config.AdBackend),
+		adsysservice.WithSSSConfig(a.config.SSSdConfig),
+		adsysservice.WithWinbindConfig(a.config.WinbindConfig),
+		adsysservice.WithLimits(a.config.Limits),
+		adsysservice.WithOfflinePolicy(a.config.Offline),
+		adsysservice.WithBackoff(a.config.Backoff),
+		adsysservice.WithIgnoredGPOs(a.config.IgnoredGPOs),
+		adsysservice.WithLocalUsers(a.config.LocalUsers),
+		adsysservice.WithDomainCacheQuota(a.config.DomainCacheQuota),
+		adsysservice.WithPrecedence(a.config.Precedence),
+		adsysservice.WithVaultServers(a.config.VaultServers),
+		adsysservice.WithEscrowAttributes(a.config.EscrowAttributes),
+	)
+}
+
+// disabledPolicyManagers returns the policy managers which are not supported in the environment the daemon runs in,
+// or with the adBackend it uses. The environment is detected unless virtualization overrides it.
+func disabledPolicyManagers(adBackend, virtualization string) []string {
+	var disabled []string
+	if snap.Running() {
+		disabled = append(disabled, snap.UnsupportedPolicyManagers...)
+	}
+	// SSSD is only configured when it is the backend adsys uses.
+	if adBackend != "sssd" && !slices.Contains(disabled, "sssd") {
+		disabled = append(disabled, "sssd")
+	}
+	k, err := virtenv.FromSetting(virtualization)
+	if err != nil {
+		log.Warningf(context.Background(), i18n.G("Invalid virtualization setting, detecting the environment: %v"), err)
+		k = virtenv.Detect()
+	}
+	if k != virtenv.None {
+		log.Infof(context.Background(), "Running on %s: skipping unsupported policy managers", k)
+		for _, m := range virtenv.UnsupportedPolicyManagers(k) {
+			if !slices.Contains(disabled, m) {
+				disabled = append(disabled, m)
+			}
+		}
+	}
+	return disabled
+}
+
 // changeServerSocket change the socket on server.
 func (a *App) changeServerSocket(socket string) error {
 	if a.daemon == nil {
@@ -0,0 +1,56 @@
+package daemon
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/spf13/cobra"
+	"github.com/ubuntu/adsys/internal/i18n"
+	"github.com/ubuntu/adsys/internal/policies"
+	"github.com/ubuntu/decorate"
+	"gopkg.in/yaml.v3"
+)
+
+func (a *App) installDumpCache() {
+	var format *string
+	cmd := &cobra.Command{
+		Use:    "dumpcache OBJECT_NAME",
+		Short:  i18n.G("Dumps the cached policies of a computer or a user for debugging"),
+		Args:   cobra.ExactArgs(1),
+		Hidden: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return dumpCache(policies.ObjectCachePath(a.config.CacheDir, policies.PoliciesCacheBaseName, args[0]), *format)
+		},
+	}
+	format = cmd.Flags().StringP("format", "", "json", i18n.G("output format of the cached policies: json or yaml."))
+	a.rootCmd.AddCommand(cmd)
+}
+
+// dumpCache prints the policies cached in p, decoded in the given format.
+func dumpCache(p, format string) (err error) {
+	defer decorate.OnError(&err, i18n.G("can't dump cached policies"))
+
+	pols, err := policies.NewFromCache(context.Background(), p)
+	if err != nil {
+		return err
+	}
+	defer pols.Close()
+
+	var d []byte
+	switch format {
+	case "json":
+		d, err = json.MarshalIndent(pols, "", "  ")
+		d = append(d, '\n')
+	case "yaml":
+		d, err = yaml.Marshal(pols)
+	default:
+		return fmt.Errorf(i18n.G("unknown format %q, expected json or yaml"), format)
+	}
+	if err != nil {
+		return err
+	}
+
+	fmt.Print(string(d))
+	return nil
+}
@@ -0,0 +1,44 @@
+package daemon
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"syscall"
+
+	"github.com/ubuntu/adsys/internal/i18n"
+	"github.com/ubuntu/decorate"
+)
+
+// lockFileName is the file of the run directory locked while the daemon runs.
+const lockFileName = "adsysd.lock"
+
+// lockRunDir locks runDir so that only one service applies policies with it. The daemon waits for the lock, while
+// commands applying policies on their own, like provision, fail if the daemon holds it.
+// The lock is released by calling unlock.
+func lockRunDir(runDir string, wait bool) (unlock func(), err error) {
+	defer decorate.OnError(&err, i18n.G("can't lock run directory"))
+
+	// #nosec G301 - the run directory is readable by users, like when the service creates it
+	if err := os.MkdirAll(runDir, 0755); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(filepath.Join(runDir, lockFileName), os.O_RDWR|os.O_CREATE|syscall.O_NOFOLLOW, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	how := syscall.LOCK_EX
+	if !wait {
+		how |= syscall.LOCK_NB
+	}
+	if err := syscall.Flock(int(f.Fd()), how); err != nil {
+		f.Close()
+		if errors.Is(err, syscall.EWOULDBLOCK) {
+			return nil, errors.New(i18n.G("adsysd is running: stop adsysd.service and adsysd.socket first"))
+		}
+		return nil, err
+	}
+
+	return func() { f.Close() }, nil
+}
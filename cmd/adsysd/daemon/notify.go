@@ -0,0 +1,39 @@
+package daemon
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+
+	"github.com/godbus/dbus/v5"
+	"github.com/spf13/cobra"
+	"github.com/ubuntu/adsys/internal/i18n"
+	"github.com/ubuntu/adsys/internal/policies/notification"
+)
+
+func (a *App) installNotify() {
+	cmd := &cobra.Command{
+		Use:    "notify NOTIFICATION_FILE",
+		Short:  i18n.G("Show the new restrictions listed in the specified file to the current user"),
+		Args:   cobra.ExactArgs(1),
+		Hidden: true,
+		RunE:   func(cmd *cobra.Command, args []string) error { return runNotify(args[0]) },
+	}
+	a.rootCmd.AddCommand(cmd)
+}
+
+func runNotify(path string) error {
+	runtimeDir := os.Getenv("XDG_RUNTIME_DIR")
+	if runtimeDir == "" {
+		return errors.New(i18n.G("XDG_RUNTIME_DIR is not set: not running in a user session"))
+	}
+
+	bus, err := dbus.ConnectSessionBus()
+	if err != nil {
+		return err
+	}
+	defer bus.Close()
+
+	return notification.Show(context.Background(), path, filepath.Join(runtimeDir, "adsys-notified"), notification.DesktopNotify(bus))
+}
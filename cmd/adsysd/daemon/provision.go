@@ -0,0 +1,85 @@
+package daemon
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/ubuntu/adsys/internal/i18n"
+	"github.com/ubuntu/decorate"
+)
+
+func (a *App) installProvision() {
+	var usersFile *string
+	cmd := &cobra.Command{
+		Use:   "provision",
+		Short: i18n.G("Download and apply the policies of the machine and of the listed users, when building an image"),
+		Args:  cobra.NoArgs,
+		RunE:  func(cmd *cobra.Command, args []string) error { return a.provision(*usersFile) },
+	}
+	usersFile = cmd.Flags().StringP("users", "u", "", i18n.G("file listing the users to provision, one per line. Empty lines and lines starting with # are ignored."))
+	a.rootCmd.AddCommand(cmd)
+}
+
+// provision applies the policies of the machine and of the users listed in usersFile, with the service configured
+// like the daemon, but without serving requests. It fails if the daemon is running.
+func (a *App) provision(usersFile string) (err error) {
+	var users []string
+	if usersFile != "" {
+		if users, err = readUsersList(usersFile); err != nil {
+			return err
+		}
+	}
+
+	// Don't apply policies concurrently with the daemon, as both use the same cache and run directories.
+	unlock, err := lockRunDir(a.config.RunDir, false)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	ctx := context.Background()
+	adsys, err := a.newService(ctx)
+	if err != nil {
+		return err
+	}
+	defer adsys.Quit(ctx)
+
+	msg, err := adsys.Provision(ctx, users)
+	if err != nil {
+		return err
+	}
+	fmt.Print(msg)
+	return nil
+}
+
+// readUsersList returns the users listed in p, one per line. Empty lines and comments starting with # are ignored.
+func readUsersList(p string) (users []string, err error) {
+	defer decorate.OnError(&err, i18n.G("can't read users list"))
+
+	f, err := os.Open(p)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		user := strings.TrimSpace(scanner.Text())
+		if user == "" || strings.HasPrefix(user, "#") {
+			continue
+		}
+		users = append(users, user)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(users) == 0 {
+		return nil, errors.New(i18n.G("no user listed"))
+	}
+	return users, nil
+}
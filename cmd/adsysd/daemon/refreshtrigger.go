@@ -0,0 +1,77 @@
+package daemon
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"time"
+
+	"github.com/coreos/go-systemd/v22/activation"
+	"github.com/godbus/dbus/v5"
+	"github.com/spf13/cobra"
+	"github.com/ubuntu/adsys/internal/consts"
+	"github.com/ubuntu/adsys/internal/i18n"
+	"github.com/ubuntu/adsys/internal/refreshtrigger"
+	"github.com/ubuntu/adsys/internal/systemd"
+)
+
+// refreshTriggerIdleTimeout is the time without refresh message after which the handler exits, until systemd
+// activates it again.
+const refreshTriggerIdleTimeout = 30 * time.Second
+
+func (a *App) installRefreshTrigger() {
+	var key *string
+	cmd := &cobra.Command{
+		Use:    "refresh-trigger",
+		Short:  i18n.G("Refresh the policies of the machine and all users on signed messages received on the activated socket"),
+		Args:   cobra.NoArgs,
+		Hidden: true,
+		RunE:   func(cmd *cobra.Command, args []string) error { return a.refreshTrigger(*key) },
+	}
+	key = cmd.Flags().StringP("key", "", consts.DefaultRefreshTriggerKey, i18n.G("file containing the key shared with the senders of refresh messages."))
+	a.rootCmd.AddCommand(cmd)
+}
+
+// refreshTrigger starts the policy refresh service for each valid message received on the UDP socket passed by
+// systemd.
+func (a App) refreshTrigger(keyPath string) error {
+	conns, err := activation.PacketConns()
+	if err != nil {
+		return err
+	}
+	if len(conns) != 1 {
+		return errors.New(i18n.G("refresh-trigger must be activated by systemd with exactly one datagram socket"))
+	}
+	defer conns[0].Close()
+
+	key, err := refreshtrigger.LoadKey(keyPath)
+	if err != nil {
+		return err
+	}
+
+	bus, err := dbus.SystemBusPrivate()
+	if err != nil {
+		return err
+	}
+	defer bus.Close()
+	if err = bus.Auth(nil); err != nil {
+		return err
+	}
+	if err = bus.Hello(); err != nil {
+		return err
+	}
+	systemdCaller, err := systemd.New(bus)
+	if err != nil {
+		return err
+	}
+
+	cacheDir := a.config.CacheDir
+	if cacheDir == "" {
+		cacheDir = consts.DefaultCacheDir
+	}
+
+	return refreshtrigger.Serve(context.Background(), conns[0], key, filepath.Join(cacheDir, "refresh-trigger"), refreshTriggerIdleTimeout,
+		func(ctx context.Context) error {
+			return systemdCaller.StartUnit(ctx, consts.AdsysGPORefreshServiceName)
+		})
+}
@@ -2,10 +2,16 @@ package daemon
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"io"
+	"time"
 
 	systemd "github.com/coreos/go-systemd/v22/daemon"
 	"github.com/spf13/cobra"
+	"github.com/ubuntu/adsys"
+	"github.com/ubuntu/adsys/internal/adsysservice"
+	"github.com/ubuntu/adsys/internal/consts"
 	log "github.com/ubuntu/adsys/internal/grpc/logstreamer"
 	"github.com/ubuntu/adsys/internal/i18n"
 	"github.com/ubuntu/adsys/internal/policies/scripts"
@@ -14,19 +20,21 @@ import (
 func (a *App) installRunScripts() {
 	var allowOrderMissing *bool
 	cmd := &cobra.Command{
-		Use:    "runscripts ORDER_FILE",
-		Short:  i18n.G("Runs scripts in the given subdirectory"),
-		Args:   cobra.ExactArgs(1),
+		Use:    "runscripts ORDER_FILE...",
+		Short:  i18n.G("Runs scripts listed in the given order files, one after the other"),
+		Args:   cobra.MinimumNArgs(1),
 		Hidden: true,
-		RunE:   func(cmd *cobra.Command, args []string) error { return runScripts(args[0], *allowOrderMissing) },
+		RunE:   func(cmd *cobra.Command, args []string) error { return a.runScripts(args, *allowOrderMissing) },
 	}
 	allowOrderMissing = cmd.Flags().BoolP("allow-order-missing", "", false, i18n.G("allow ORDER_FILE to be missing once the scripts are ready."))
 	a.rootCmd.AddCommand(cmd)
 }
 
-func runScripts(orderFile string, allowOrderMissing bool) error {
-	if err := scripts.RunScripts(context.Background(), orderFile, allowOrderMissing); err != nil {
-		return err
+func (a *App) runScripts(orderFiles []string, allowOrderMissing bool) error {
+	for _, orderFile := range orderFiles {
+		if err := scripts.RunScripts(context.Background(), orderFile, allowOrderMissing, scripts.WithRuntimeLimiter(a.limitScript)); err != nil {
+			return err
+		}
 	}
 
 	// TODO: mock this for tests
@@ -38,3 +46,21 @@ func runScripts(orderFile string, allowOrderMissing bool) error {
 
 	return nil
 }
+
+// limitScript requests the daemon to confine the script process pid to the runtime allowed for the user scripts.
+func (a *App) limitScript(pid int) error {
+	client, err := adsysservice.NewClient(a.config.Socket, consts.DefaultClientTimeout*time.Second)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	stream, err := client.LimitScript(context.Background(), &adsys.LimitScriptRequest{Pid: uint32(pid)})
+	if err != nil {
+		return err
+	}
+	if _, err := stream.Recv(); err != nil && !errors.Is(err, io.EOF) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,86 @@
+package daemon
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/ubuntu/adsys/internal/consts"
+	log "github.com/ubuntu/adsys/internal/grpc/logstreamer"
+	"github.com/ubuntu/adsys/internal/i18n"
+	"github.com/ubuntu/decorate"
+)
+
+func (a *App) installWaitReady() {
+	cmd := &cobra.Command{
+		Use:    "waitready",
+		Short:  i18n.G("Waits for the machine policies to be applied since boot"),
+		Args:   cobra.NoArgs,
+		Hidden: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return waitReady(filepath.Join(a.config.RunDir, consts.PolicyReadyFlagName), time.Duration(a.config.PolicyReadyTimeout)*time.Second)
+		},
+	}
+	cmd.Flags().IntP("timeout", "", consts.DefaultPolicyReadyTimeout, i18n.G("maximum time in seconds to wait for the machine policies."))
+	decorate.LogOnError(a.viper.BindPFlag("policy_ready_timeout", cmd.Flags().Lookup("timeout")))
+	a.rootCmd.AddCommand(cmd)
+
+	a.rootCmd.AddCommand(&cobra.Command{
+		Use:    "adconfigured",
+		Short:  i18n.G("Exits with an error if the configured Active Directory backend is not set up on this machine"),
+		Args:   cobra.NoArgs,
+		Hidden: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return adConfigured(a.config.AdBackend, a.config.SSSdConfig.Conf)
+		},
+	})
+}
+
+// adConfigured returns an error if the AD backend is not set up, so that the boot units can skip applying and waiting
+// for the machine policies.
+// The sssd backend, selected by default, is set up once its configuration file sssdConf exists. The winbind backend
+// has no configuration file of its own: selecting it in the configuration is enough.
+func adConfigured(backend, sssdConf string) error {
+	if backend == "winbind" {
+		return nil
+	}
+	if sssdConf == "" {
+		sssdConf = consts.DefaultSSSConf
+	}
+	if _, err := os.Stat(sssdConf); err != nil {
+		return fmt.Errorf(i18n.G("sssd backend is not configured: %v"), err)
+	}
+	return nil
+}
+
+// waitReady blocks until the policy ready flag exists.
+// Reaching timeout is not an error: the display manager is still started, without the machine policies.
+func waitReady(flag string, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		_, err := os.Stat(flag)
+		if err == nil {
+			log.Debug(context.Background(), i18n.G("Machine policies are applied"))
+			return nil
+		}
+		if !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			log.Warningf(context.Background(), i18n.G("Machine policies are still not applied after %s, not waiting anymore"), timeout)
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
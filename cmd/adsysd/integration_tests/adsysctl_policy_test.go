@@ -3,6 +3,7 @@ package adsys_test
 import (
 	"bufio"
 	"bytes"
+	"encoding/json"
 	"fmt"
 	"os"
 	"os/exec"
@@ -10,6 +11,7 @@ import (
 	"path/filepath"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/fatih/color"
 	"github.com/stretchr/testify/require"
@@ -76,6 +78,53 @@ func TestPolicyAdmx(t *testing.T) {
 	}
 }
 
+func TestPolicyKeys(t *testing.T) {
+	tests := map[string]struct {
+		manager          string
+		distroOption     string
+		systemAnswer     string
+		daemonNotStarted bool
+
+		wantErr bool
+	}{
+		"List all policy keys":                  {systemAnswer: "polkit_yes"},
+		"List policy keys for a manager":        {manager: "proxy", systemAnswer: "polkit_yes"},
+		"Policy keys listing is always allowed": {manager: "proxy", systemAnswer: "polkit_no"},
+
+		// Error cases
+		"Error on unknown manager":       {manager: "doesnotexist", systemAnswer: "polkit_yes", wantErr: true},
+		"Error on non stored distro":     {distroOption: "Tartanpion", systemAnswer: "polkit_yes", wantErr: true},
+		"Error on daemon not responding": {daemonNotStarted: true, wantErr: true},
+	}
+	for name, tc := range tests {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			dbusAnswer(t, tc.systemAnswer)
+
+			conf := createConf(t)
+			if !tc.daemonNotStarted {
+				defer runDaemon(t, conf)()
+			}
+			args := []string{"policy", "keys"}
+			if tc.manager != "" {
+				args = append(args, tc.manager)
+			}
+			if tc.distroOption != "" {
+				args = append(args, "--distro", tc.distroOption)
+			}
+			got, err := runClient(t, conf, args...)
+			if tc.wantErr {
+				require.Error(t, err, "client should exit with an error")
+				return
+			}
+			require.NoError(t, err, "client should exit with no error")
+
+			want := testutils.LoadWithUpdateFromGolden(t, got)
+			require.Equal(t, want, got, "ListPolicyKeys returned expected output")
+		})
+	}
+}
+
 func TestPolicyApplied(t *testing.T) {
 	currentUser := "adsystestuser@example.com"
 
@@ -106,6 +155,11 @@ func TestPolicyApplied(t *testing.T) {
 		"Current user gpos no color":                     {args: []string{"--no-color"}},
 		"Detailed policy with overrides (all), no color": {args: []string{"--no-color", "--all"}},
 
+		// Structured formats
+		"Current user applied gpos in json": {args: []string{"--format", "json"}},
+		"Current user applied gpos in yaml": {args: []string{"--format", "yaml"}},
+		"Machine only applied gpos in json": {args: []string{"--machine", "--format", "json"}},
+
 		// User options
 		`Current user with domain\username`:           {args: []string{`example.com\adsystestuser`}},
 		`Current user with default domain completion`: {args: []string{`adsystestuser`}},
@@ -117,6 +171,7 @@ func TestPolicyApplied(t *testing.T) {
 		"Error on unexisting user":                                  {args: []string{"doesnotexists@example.com"}, wantErr: true},
 		"Error on user name without domain and no default domain":   {args: []string{"doesnotexists"}, wantErr: true},
 		"Error on applied denied":                                   {systemAnswer: "polkit_no", wantErr: true},
+		"Error on unsupported format":                               {args: []string{"--format", "xml"}, wantErr: true},
 		"Error on daemon not responding":                            {daemonNotStarted: true, wantErr: true},
 	}
 	for name, tc := range tests {
@@ -171,6 +226,10 @@ func TestPolicyApplied(t *testing.T) {
 			}
 			require.NoError(t, err, "client should exit with no error")
 
+			// Structured formats list the machine name, which differs between test hosts.
+			got = strings.ReplaceAll(got, fmt.Sprintf(`"object": %q`, hostname), `"object": "HOST"`)
+			got = strings.ReplaceAll(got, fmt.Sprintf("object: %s\n", hostname), "object: HOST\n")
+
 			// Compare golden files
 			want := testutils.LoadWithUpdateFromGolden(t, got)
 			require.Equal(t, want, got, "DumpPolicies returned expected output")
@@ -178,6 +237,181 @@ func TestPolicyApplied(t *testing.T) {
 	}
 }
 
+func TestPolicySearch(t *testing.T) {
+	currentUser := "adsystestuser@example.com"
+
+	// We setup and rerun in a subprocess because the test users must exist on the machine for the authorizer.
+	if setupSubprocessForTest(t, currentUser, "userintegrationtest@example.com") {
+		return
+	}
+
+	tests := map[string]struct {
+		args              []string
+		systemAnswer      string
+		daemonNotStarted  bool
+		userGPORules      string
+		noMachineGPORules bool
+
+		wantErr bool
+	}{
+		"Search current user entries by key":       {args: []string{"favorite"}},
+		"Search current user entries by value":     {args: []string{"canonical.png"}},
+		"Search is case insensitive":               {args: []string{"PICTURE"}},
+		"Search other user entries":                {args: []string{"picture", "userintegrationtest@example.com"}, userGPORules: "userintegrationtest@example.com"},
+		"Search overridden entries are not listed": {args: []string{"common-key-user"}},
+		"Search without match":                     {args: []string{"doesnotmatch"}},
+
+		// Error cases
+		"Error on missing query":               {args: []string{}, wantErr: true},
+		"Error on machine cache not available": {args: []string{"picture"}, noMachineGPORules: true, wantErr: true},
+		"Error on user cache not available":    {args: []string{"picture"}, userGPORules: "-", wantErr: true},
+		"Error on unexisting user":             {args: []string{"picture", "doesnotexists@example.com"}, wantErr: true},
+		"Error on search denied":               {args: []string{"picture"}, systemAnswer: "polkit_no", wantErr: true},
+		"Error on daemon not responding":       {args: []string{"picture"}, daemonNotStarted: true, wantErr: true},
+	}
+	for name, tc := range tests {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			if tc.systemAnswer == "" {
+				tc.systemAnswer = "polkit_yes"
+			}
+			dbusAnswer(t, tc.systemAnswer)
+
+			hostname, err := os.Hostname()
+			require.NoError(t, err, "Setup: failed to get current hostname")
+
+			dir := t.TempDir()
+			dstDir := filepath.Join(dir, "cache", "policies")
+			err = os.MkdirAll(dstDir, 0700)
+			require.NoError(t, err, "setup failed: couldn't create policies directory: %v", err)
+			if !tc.noMachineGPORules {
+				require.NoError(t,
+					shutil.CopyTree(
+						filepath.Join(testutils.TestFamilyPath(t), "policies", "machine"),
+						filepath.Join(dstDir, hostname),
+						&shutil.CopyTreeOptions{Symlinks: true, CopyFunction: shutil.Copy}),
+					"Setup: failed to copy machine policies cache")
+			}
+			if tc.userGPORules != "-" {
+				if tc.userGPORules == "" {
+					tc.userGPORules = currentUser
+				}
+				require.NoError(t,
+					shutil.CopyTree(
+						filepath.Join(testutils.TestFamilyPath(t), "policies", "user"),
+						filepath.Join(dstDir, tc.userGPORules),
+						&shutil.CopyTreeOptions{Symlinks: true, CopyFunction: shutil.Copy}),
+					"Setup: failed to copy user policies cache")
+			}
+			conf := createConf(t, confWithAdsysDir(dir))
+
+			if !tc.daemonNotStarted {
+				defer runDaemon(t, conf)()
+			}
+
+			args := append([]string{"policy", "search"}, tc.args...)
+			got, err := runClient(t, conf, args...)
+			if tc.wantErr {
+				require.Error(t, err, "client should exit with an error")
+				return
+			}
+			require.NoError(t, err, "client should exit with no error")
+
+			// Compare golden files
+			want := testutils.LoadWithUpdateFromGolden(t, got)
+			require.Equal(t, want, got, "SearchPolicies returned expected output")
+		})
+	}
+}
+
+func TestPolicyStatus(t *testing.T) {
+	currentUser := "adsystestuser@example.com"
+
+	// We setup and rerun in a subprocess because the test users must exist on the machine for the authorizer.
+	if setupSubprocessForTest(t, currentUser, "userintegrationtest@example.com") {
+		return
+	}
+
+	applyTime := time.Date(2023, time.June, 1, 10, 0, 0, 0, time.UTC)
+
+	tests := map[string]struct {
+		args             []string
+		systemAnswer     string
+		daemonNotStarted bool
+		userStatus       string
+		machineStatus    string
+
+		wantErr bool
+	}{
+		"Current user status":                     {},
+		"Current user status with failed manager": {userStatus: "failed"},
+		"Other user status":                       {args: []string{"userintegrationtest@example.com"}, userStatus: "userintegrationtest@example.com"},
+		"Machine only status":                     {args: []string{"-m"}, machineStatus: "failed"},
+
+		// Error cases
+		"Error on machine status not available": {machineStatus: "-", wantErr: true},
+		"Error on user status not available":    {userStatus: "-", wantErr: true},
+		"Error on unexisting user":              {args: []string{"doesnotexists@example.com"}, wantErr: true},
+		"Error on status denied":                {systemAnswer: "polkit_no", wantErr: true},
+		"Error on daemon not responding":        {daemonNotStarted: true, wantErr: true},
+	}
+	for name, tc := range tests {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			if tc.systemAnswer == "" {
+				tc.systemAnswer = "polkit_yes"
+			}
+			dbusAnswer(t, tc.systemAnswer)
+
+			hostname, err := os.Hostname()
+			require.NoError(t, err, "Setup: failed to get current hostname")
+
+			dir := t.TempDir()
+			dstDir := filepath.Join(dir, "cache", "status")
+			err = os.MkdirAll(dstDir, 0700)
+			require.NoError(t, err, "setup failed: couldn't create status directory: %v", err)
+
+			userObject := currentUser
+			if tc.userStatus == "userintegrationtest@example.com" {
+				userObject, tc.userStatus = tc.userStatus, ""
+			}
+			for object, status := range map[string]string{hostname: tc.machineStatus, userObject: tc.userStatus} {
+				if status == "-" {
+					continue
+				}
+				if status == "" {
+					status = "succeeded"
+				}
+				dst := filepath.Join(dstDir, object)
+				require.NoError(t,
+					shutil.CopyFile(filepath.Join(testutils.TestFamilyPath(t), "status", status), dst, false),
+					"Setup: failed to copy policy apply status")
+				require.NoError(t, os.Chtimes(dst, applyTime, applyTime), "Setup: failed to set policy apply status time")
+			}
+			conf := createConf(t, confWithAdsysDir(dir))
+
+			if !tc.daemonNotStarted {
+				defer runDaemon(t, conf)()
+			}
+
+			args := append([]string{"policy", "status"}, tc.args...)
+			got, err := runClient(t, conf, args...)
+			if tc.wantErr {
+				require.Error(t, err, "client should exit with an error")
+				return
+			}
+			require.NoError(t, err, "client should exit with no error")
+
+			got = strings.ReplaceAll(got, applyTime.Local().Format(time.RFC3339), "APPLY_TIME")
+			got = strings.ReplaceAll(got, fmt.Sprintf("Last policy apply for %s on", hostname), "Last policy apply for HOST on")
+
+			// Compare golden files
+			want := testutils.LoadWithUpdateFromGolden(t, got)
+			require.Equal(t, want, got, "GetLastApplyStatus returned expected output")
+		})
+	}
+}
+
 func TestPolicyUpdate(t *testing.T) {
 	currentUser := "adsystestuser@example.com"
 
@@ -239,6 +473,17 @@ func TestPolicyUpdate(t *testing.T) {
 					machine:      true,
 				},
 			}},
+		"Other user not logged in, first time with machine credentials": {
+			args:       []string{"--target", "userintegrationtest@example.com"},
+			initState:  "localhost-uptodate",
+			krb5ccname: "-",
+			krb5ccNamesState: []krb5ccNamesWithState{
+				{
+					src:          "ccache_EXAMPLE.COM",
+					adsysSymlink: hostname,
+					machine:      true,
+				},
+			}},
 		"Machine, first time": {
 			args:       []string{"-m"},
 			addPaths:   []string{"apparmorfs/profiles"},
@@ -326,6 +571,25 @@ func TestPolicyUpdate(t *testing.T) {
 					machine:      true,
 				},
 			}},
+		"Machine, recently updated policies are kept with if-older-than": {args: []string{"-m", "--if-older-than", "315360000"},
+			initState:  "old-data",
+			krb5ccname: "-",
+			krb5ccNamesState: []krb5ccNamesWithState{
+				{
+					src:          "ccache_EXAMPLE.COM",
+					adsysSymlink: hostname,
+					machine:      true,
+				},
+			}},
+		"Machine, first time with if-older-than": {args: []string{"-m", "--if-older-than", "3600"},
+			addPaths:   []string{"apparmorfs/profiles"},
+			krb5ccname: "-",
+			krb5ccNamesState: []krb5ccNamesWithState{
+				{
+					src:     "ccache_EXAMPLE.COM",
+					machine: true,
+				},
+			}},
 		"Refresh all connected": {args: []string{"--all"},
 			initState:  "old-data",
 			krb5ccname: "-",
@@ -629,6 +893,13 @@ func TestPolicyUpdate(t *testing.T) {
 		"Error on Polkit denying updating self":                       {systemAnswer: "polkit_no", initState: "localhost-uptodate", wantErr: true},
 		"Error on Polkit denying updating other":                      {systemAnswer: "polkit_no", args: []string{"userintegrationtest@example.com", "FIXME"}, initState: "localhost-uptodate", wantErr: true},
 		"Error on Polkit denying updating machine":                    {systemAnswer: "polkit_no", args: []string{"-m"}, wantErr: true},
+		"Error on if-older-than with update all":                      {args: []string{"--all", "--if-older-than", "10"}, initState: "localhost-uptodate", wantErr: true},
+		"Error on negative if-older-than":                             {args: []string{"-m", "--if-older-than", "-1"}, initState: "localhost-uptodate", wantErr: true},
+		"Error on if-older-than with dry-run":                         {args: []string{"-m", "--dry-run", "--if-older-than", "10"}, initState: "localhost-uptodate", wantErr: true},
+		"Error on at-login with machine update":                       {args: []string{"-m", "--at-login"}, initState: "localhost-uptodate", wantErr: true},
+		"Error on at-login with update all":                           {args: []string{"--all", "--at-login"}, initState: "localhost-uptodate", wantErr: true},
+		"Error on purging domain with user argument":                  {purge: true, args: []string{"--domain", "example.com", "userintegrationtest@example.com"}, initState: "localhost-uptodate", wantErr: true},
+		"Error on purging domain with machine":                        {purge: true, args: []string{"--domain", "example.com", "-m"}, initState: "localhost-uptodate", wantErr: true},
 		"Error on dynamic AD returning nothing": {
 			initState: "localhost-uptodate",
 			sssdConf:  "sssd.conf-online_no_active_server",
@@ -886,6 +1157,33 @@ func TestPolicyUpdate(t *testing.T) {
 			},
 			wantErr: true,
 		},
+		"Error on target and specific user requested": {
+			args:       []string{"--target", "userintegrationtest@example.com", "userintegrationtest@example.com", "userintegrationtest@example.com.krb5"},
+			initState:  "localhost-uptodate",
+			krb5ccname: "-",
+			krb5ccNamesState: []krb5ccNamesWithState{
+				{src: "userintegrationtest@example.com.krb5"},
+				{
+					src:          "ccache_EXAMPLE.COM",
+					adsysSymlink: hostname,
+					machine:      true,
+				},
+			},
+			wantErr: true,
+		},
+		"Error on target and computer requested": {
+			args:       []string{"-m", "--target", "userintegrationtest@example.com"},
+			initState:  "localhost-uptodate",
+			krb5ccname: "-",
+			krb5ccNamesState: []krb5ccNamesWithState{
+				{
+					src:          "ccache_EXAMPLE.COM",
+					adsysSymlink: hostname,
+					machine:      true,
+				},
+			},
+			wantErr: true,
+		},
 		"Error on computer requested directly (argument is user)": {
 			args:       []string{"-m", hostname, "ccache_EXAMPLE.COM"},
 			initState:  "localhost-uptodate",
@@ -935,6 +1233,12 @@ func TestPolicyUpdate(t *testing.T) {
 				dst := strings.ReplaceAll(src, "HOST", hostname)
 				require.NoError(t, os.Rename(src, dst), "Setup: can't renamed HOST directory to current hostname")
 
+				src = filepath.Join(adsysDir, "cache", "groups", "HOST")
+				dst = strings.ReplaceAll(src, "HOST", hostname)
+				if _, err := os.Stat(src); err == nil {
+					require.NoError(t, os.Rename(src, dst), "Setup: can't rename HOST group membership to current hostname")
+				}
+
 				src = filepath.Join(adsysDir, "run", "users", "CURRENT_UID")
 				dst = strings.ReplaceAll(src, "CURRENT_UID", currentUID)
 				if _, err := os.Stat(src); err == nil {
@@ -1088,6 +1392,80 @@ func TestPolicyUpdate(t *testing.T) {
 	}
 }
 
+func TestPolicyFreeze(t *testing.T) {
+	tests := map[string]struct {
+		args             []string
+		initFrozen       bool
+		systemAnswer     string
+		daemonNotStarted bool
+
+		wantFrozen   bool
+		wantDuration time.Duration
+		wantErr      bool
+	}{
+		"Freeze until unfrozen":          {args: []string{"freeze"}, wantFrozen: true},
+		"Freeze for a duration":          {args: []string{"freeze", "--duration", "2h"}, wantFrozen: true, wantDuration: 2 * time.Hour},
+		"Freeze again replaces duration": {args: []string{"freeze", "-d", "30m"}, initFrozen: true, wantFrozen: true, wantDuration: 30 * time.Minute},
+		"Unfreeze":                       {args: []string{"unfreeze"}, initFrozen: true},
+		"Unfreeze when not frozen":       {args: []string{"unfreeze"}},
+		"Update is skipped while frozen": {args: []string{"update", "-m"}, initFrozen: true, wantFrozen: true},
+
+		// Error cases
+		"Error on negative duration":     {args: []string{"freeze", "--duration", "-1h"}, wantErr: true},
+		"Error on sub second duration":   {args: []string{"freeze", "--duration", "10ms"}, wantErr: true},
+		"Error on freeze denied":         {args: []string{"freeze"}, systemAnswer: "polkit_no", wantErr: true},
+		"Error on unfreeze denied":       {args: []string{"unfreeze"}, initFrozen: true, systemAnswer: "polkit_no", wantFrozen: true, wantErr: true},
+		"Error on daemon not responding": {args: []string{"freeze"}, daemonNotStarted: true, wantErr: true},
+	}
+	for name, tc := range tests {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			if tc.systemAnswer == "" {
+				tc.systemAnswer = "polkit_yes"
+			}
+			dbusAnswer(t, tc.systemAnswer)
+
+			dir := t.TempDir()
+			freezePath := filepath.Join(dir, "cache", "policies.frozen")
+			if tc.initFrozen {
+				testutils.WriteFile(t, freezePath, []byte("{}"), 0600)
+			}
+			conf := createConf(t, confWithAdsysDir(dir))
+
+			if !tc.daemonNotStarted {
+				defer runDaemon(t, conf)()
+			}
+
+			start := time.Now()
+			_, err := runClient(t, conf, append([]string{"policy"}, tc.args...)...)
+			if tc.wantErr {
+				require.Error(t, err, "client should exit with an error")
+			} else {
+				require.NoError(t, err, "client should exit with no error")
+			}
+
+			d, err := os.ReadFile(freezePath)
+			if !tc.wantFrozen {
+				require.ErrorIs(t, err, os.ErrNotExist, "Policy updates should not be frozen")
+				return
+			}
+			require.NoError(t, err, "Policy updates should be frozen")
+
+			var state struct{ Until time.Time }
+			require.NoError(t, json.Unmarshal(d, &state), "Freeze state should be valid")
+			if tc.wantDuration == 0 {
+				require.True(t, state.Until.IsZero(), "Freeze should last until unfrozen")
+			} else {
+				require.WithinRange(t, state.Until, start.Add(tc.wantDuration), time.Now().Add(tc.wantDuration), "Freeze should end after the requested duration")
+			}
+
+			hostname, err := os.Hostname()
+			require.NoError(t, err, "Setup: failed to get current hostname")
+			require.NoDirExists(t, filepath.Join(dir, "cache", "policies", hostname), "No policy should be applied while frozen")
+		})
+	}
+}
+
 func TestPolicyDebugGPOListScript(t *testing.T) {
 	gpolistSrc, err := os.ReadFile(filepath.Join(rootProjectDir, "internal/ad/adsys-gpolist"))
 	require.NoError(t, err, "Setup: failed to load source of adsys-gpolist")
@@ -61,6 +61,58 @@ func TestServiceStop(t *testing.T) {
 	}
 }
 
+func TestServicePrune(t *testing.T) {
+	tests := map[string]struct {
+		daemonAnswer     string
+		daemonNotStarted bool
+		rotate           bool
+
+		wantRotated bool
+		wantErr     bool
+	}{
+		"Prune logs":                          {daemonAnswer: "polkit_yes"},
+		"Rotate logs even under maximum size": {daemonAnswer: "polkit_yes", rotate: true, wantRotated: true},
+
+		// Error cases
+		"Error on prune denied":          {daemonAnswer: "polkit_no", wantErr: true},
+		"Error on daemon not responding": {daemonNotStarted: true, wantErr: true},
+	}
+	for name, tc := range tests {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			dbusAnswer(t, tc.daemonAnswer)
+
+			conf := createConf(t)
+			if !tc.daemonNotStarted {
+				defer runDaemon(t, conf)()
+				// Audit a first request, so that the audit log is not empty.
+				_, err := runClient(t, conf, "version")
+				require.NoError(t, err, "Setup: version should exit with no error")
+			}
+
+			args := []string{"service", "prune"}
+			if tc.rotate {
+				args = append(args, "--rotate")
+			}
+			out, err := runClient(t, conf, args...)
+			if tc.wantErr {
+				require.Error(t, err, "client should exit with an error")
+				return
+			}
+			require.NoError(t, err, "client should exit with no error")
+			require.Contains(t, out, "Freed", "Prune should print the freed disk space")
+
+			rotated, err := filepath.Glob(filepath.Join(filepath.Dir(conf), "audit.log.*.gz"))
+			require.NoError(t, err, "Teardown: can't list rotated logs")
+			if tc.wantRotated {
+				require.Len(t, rotated, 1, "Audit log should have been rotated and compressed")
+				return
+			}
+			require.Empty(t, rotated, "Audit log under its maximum size should not have been rotated")
+		})
+	}
+}
+
 func TestServiceStopWaitForHangingClient(t *testing.T) {
 	dbusAnswer(t, "polkit_yes")
 
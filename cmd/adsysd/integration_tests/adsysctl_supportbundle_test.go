@@ -0,0 +1,76 @@
+package adsys_test
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSupportBundle(t *testing.T) {
+	tests := map[string]struct {
+		daemonNotStarted bool
+		existingArchive  bool
+
+		wantFiles     []string
+		wantNoArchive bool
+	}{
+		"Bundle service information": {wantFiles: []string{"version", "status", "cache-metadata", "errors"}},
+
+		// Error cases
+		"Error on daemon not responding still creates a bundle": {daemonNotStarted: true, wantFiles: []string{"version", "errors"}},
+		"Error on existing archive":                             {existingArchive: true, daemonNotStarted: true, wantNoArchive: true},
+	}
+	for name, tc := range tests {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			dbusAnswer(t, "polkit_yes")
+
+			conf := createConf(t)
+			if !tc.daemonNotStarted {
+				defer runDaemon(t, conf)()
+			}
+
+			dest := filepath.Join(t.TempDir(), "bundle.tar.gz")
+			if tc.existingArchive {
+				require.NoError(t, os.WriteFile(dest, []byte("existing"), 0600), "Setup: can't create existing archive")
+			}
+
+			_, err := runClient(t, conf, "support-bundle", dest)
+			if tc.wantNoArchive {
+				require.Error(t, err, "client should exit with an error")
+				d, err := os.ReadFile(dest)
+				require.NoError(t, err, "Existing archive should still be there")
+				require.Equal(t, "existing", string(d), "Existing archive should not be overwritten")
+				return
+			}
+			// Some information, like the service logs or the applied policies, are not available in tests.
+			require.Error(t, err, "client should report information which couldn't be collected")
+
+			got := make(map[string]bool)
+			f, err := os.Open(dest)
+			require.NoError(t, err, "Support bundle should be created")
+			defer f.Close()
+			gz, err := gzip.NewReader(f)
+			require.NoError(t, err, "Support bundle should be gzip compressed")
+			tr := tar.NewReader(gz)
+			for {
+				h, err := tr.Next()
+				if errors.Is(err, io.EOF) {
+					break
+				}
+				require.NoError(t, err, "Support bundle should be a valid tarball")
+				require.Equal(t, "bundle", filepath.Dir(h.Name), "Files should be in a single directory")
+				got[filepath.Base(h.Name)] = true
+			}
+			for _, f := range tc.wantFiles {
+				require.True(t, got[f], "Support bundle should contain %s", f)
+			}
+		})
+	}
+}
@@ -0,0 +1,75 @@
+package adsys_test
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/ubuntu/adsys/cmd/adsysd/daemon"
+	"github.com/ubuntu/adsys/internal/testutils"
+)
+
+func TestAdsysdDumpCache(t *testing.T) {
+	tests := map[string]struct {
+		objectName string
+		format     string
+
+		wantErr bool
+	}{
+		"Dump cached policies as json": {},
+		"Dump cached policies as yaml": {format: "yaml"},
+
+		// Error cases
+		"Error on object without cached policies": {objectName: "doesnotexist", wantErr: true},
+		"Error on unknown format":                 {format: "xml", wantErr: true},
+	}
+
+	for name, tc := range tests {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			if tc.objectName == "" {
+				tc.objectName = "HOST"
+			}
+
+			cacheDir := t.TempDir()
+			require.NoError(t, os.MkdirAll(filepath.Join(cacheDir, "policies", "HOST"), 0700), "Setup: can't create policies cache")
+			testutils.Copy(t, filepath.Join("testdata", "TestPolicyUpdate", "states", "localhost-uptodate", "cache", "policies", "HOST", "policies"),
+				filepath.Join(cacheDir, "policies", "HOST", "policies"))
+
+			d := daemon.New()
+			args := []string{"--cache-dir", cacheDir, "dumpcache", tc.objectName}
+			if tc.format != "" {
+				args = append(args, "--format", tc.format)
+			}
+			changeAppArgs(t, d, "", args...)
+
+			// capture stdout
+			r, w, err := os.Pipe()
+			require.NoError(t, err, "Setup: pipe shouldn’t fail")
+			orig := os.Stdout
+			os.Stdout = w
+
+			err = d.Run()
+
+			// restore and collect
+			os.Stdout = orig
+			w.Close()
+			var out bytes.Buffer
+			_, errCopy := io.Copy(&out, r)
+			require.NoError(t, errCopy, "Couldn’t copy stdout to buffer")
+
+			if tc.wantErr {
+				require.Error(t, err, "daemon should exit with an error")
+				return
+			}
+			require.NoError(t, err, "daemon should exit with no error")
+
+			got := out.String()
+			want := testutils.LoadWithUpdateFromGolden(t, got)
+			require.Equal(t, want, got, "dumpcache should print the cached policies")
+		})
+	}
+}
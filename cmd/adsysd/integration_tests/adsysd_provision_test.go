@@ -0,0 +1,65 @@
+package adsys_test
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/ubuntu/adsys/cmd/adsysd/daemon"
+)
+
+func TestAdsysdProvision(t *testing.T) {
+	tests := map[string]struct {
+		usersList     string
+		noFile        bool
+		daemonRunning bool
+
+		wantErr         bool
+		wantErrContains string
+	}{
+		// Error cases
+		"Error on missing users list":             {noFile: true, wantErr: true},
+		"Error on users list without users":       {usersList: "\n", wantErr: true},
+		"Error on users list with only comments":  {usersList: "# pool users\n\n  # none yet\n", wantErr: true},
+		"Error on users list which is not a file": {usersList: "-", wantErr: true},
+		"Error when the daemon is running":        {usersList: "alice@example.com\n", daemonRunning: true, wantErr: true, wantErrContains: "adsysd is running"},
+	}
+
+	for name, tc := range tests {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			usersFile := filepath.Join(t.TempDir(), "users.txt")
+			switch {
+			case tc.usersList == "-":
+				require.NoError(t, os.Mkdir(usersFile, 0700), "Setup: can't create users list directory")
+			case !tc.noFile:
+				require.NoError(t, os.WriteFile(usersFile, []byte(tc.usersList), 0600), "Setup: can't write users list")
+			}
+
+			runDir := t.TempDir()
+			if tc.daemonRunning {
+				f, err := os.Create(filepath.Join(runDir, "adsysd.lock"))
+				require.NoError(t, err, "Setup: can't create daemon lock file")
+				defer f.Close()
+				require.NoError(t, syscall.Flock(int(f.Fd()), syscall.LOCK_EX), "Setup: can't lock daemon lock file")
+			}
+
+			d := daemon.New()
+			changeAppArgs(t, d, "", "--cache-dir", t.TempDir(), "--run-dir", runDir, "provision", "--users", usersFile)
+
+			err := d.Run()
+			if tc.wantErr {
+				require.Error(t, err, "daemon should exit with an error")
+				if tc.wantErrContains != "" {
+					require.ErrorContains(t, err, tc.wantErrContains, "daemon should exit with the expected error")
+				}
+				return
+			}
+			require.NoError(t, err, "daemon should exit with no error")
+		})
+	}
+}
@@ -0,0 +1,123 @@
+package adsys_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/ubuntu/adsys/cmd/adsysd/daemon"
+	"github.com/ubuntu/adsys/internal/consts"
+)
+
+func TestAdsysdWaitReady(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		flagExists   bool
+		flagDelay    time.Duration
+		flagIsNotDir bool
+		confTimeout  bool
+
+		wantMinDuration time.Duration
+		wantErr         bool
+	}{
+		"Returns immediately when machine policies are applied":     {flagExists: true},
+		"Returns once machine policies are applied":                 {flagDelay: 500 * time.Millisecond, wantMinDuration: 500 * time.Millisecond},
+		"Returns after timeout if machine policies are not applied": {wantMinDuration: time.Second},
+		"Returns after timeout from configuration":                  {confTimeout: true, wantMinDuration: time.Second},
+
+		// Error cases
+		"Error on run directory not being a directory": {flagIsNotDir: true, wantErr: true},
+	}
+
+	for name, tc := range tests {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			d := daemon.New()
+
+			runDir := t.TempDir()
+			flag := filepath.Join(runDir, consts.PolicyReadyFlagName)
+			if tc.flagExists {
+				require.NoError(t, os.WriteFile(flag, nil, 0600), "Setup: can't create policy ready flag")
+			}
+			if tc.flagDelay != 0 {
+				go func() {
+					time.Sleep(tc.flagDelay)
+					_ = os.WriteFile(flag, nil, 0600)
+				}()
+			}
+			if tc.flagIsNotDir {
+				runDir = filepath.Join(runDir, "file")
+				require.NoError(t, os.WriteFile(runDir, nil, 0600), "Setup: can't create file as run directory")
+			}
+
+			args := []string{"--run-dir", runDir, "waitready", "--timeout", "1"}
+			var conf string
+			if tc.confTimeout {
+				conf = filepath.Join(t.TempDir(), "adsys.yaml")
+				require.NoError(t, os.WriteFile(conf, []byte("policy_ready_timeout: 1\n"), 0600), "Setup: can't create configuration")
+				args = args[:3]
+			}
+			changeAppArgs(t, d, conf, args...)
+
+			start := time.Now()
+			err := d.Run()
+			if tc.wantErr {
+				require.Error(t, err, "daemon should exit with an error")
+				return
+			}
+			require.NoError(t, err, "daemon should exit with no error")
+			require.GreaterOrEqual(t, time.Since(start), tc.wantMinDuration, "daemon should have waited for the policy ready flag")
+			require.Less(t, time.Since(start), 3*time.Second, "daemon should not wait after the timeout")
+		})
+	}
+}
+
+func TestAdsysdADConfigured(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		backend    string
+		noSSSdConf bool
+
+		wantErr bool
+	}{
+		"Sssd backend is configured":                      {},
+		"Winbind backend is configured without sssd.conf": {backend: "winbind", noSSSdConf: true},
+		"Unknown backend is sssd":                         {backend: "unknown"},
+
+		// Error cases
+		"Error on sssd backend without sssd.conf":    {noSSSdConf: true, wantErr: true},
+		"Error on unknown backend without sssd.conf": {backend: "unknown", noSSSdConf: true, wantErr: true},
+	}
+
+	for name, tc := range tests {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			d := daemon.New()
+
+			sssdConf := filepath.Join(t.TempDir(), "sssd.conf")
+			if !tc.noSSSdConf {
+				require.NoError(t, os.WriteFile(sssdConf, nil, 0600), "Setup: can't create sssd.conf")
+			}
+			args := []string{"--sssd.config", sssdConf, "adconfigured"}
+			if tc.backend != "" {
+				args = append([]string{"--ad-backend", tc.backend}, args...)
+			}
+			changeAppArgs(t, d, "", args...)
+
+			err := d.Run()
+			if tc.wantErr {
+				require.Error(t, err, "daemon should exit with an error")
+				return
+			}
+			require.NoError(t, err, "daemon should exit with no error")
+		})
+	}
+}
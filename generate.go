@@ -2,3 +2,4 @@
 package adsys
 
 //go:generate sh -c "if go run internal/generators/can_modify_repo.go 2>/dev/null; then PATH=\"$PATH:`go env GOPATH`/bin\" protoc --proto_path=. --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative adsys.proto; fi"
+//go:generate sh -c "if go run internal/generators/can_modify_repo.go 2>/dev/null && python3 -c 'import grpc_tools' 2>/dev/null; then mkdir -p clients/python && python3 -m grpc_tools.protoc --proto_path=. --python_out=clients/python --grpc_python_out=clients/python adsys.proto; fi"
@@ -16,20 +16,25 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/ubuntu/adsys/internal/ad/backends"
 	adcommon "github.com/ubuntu/adsys/internal/ad/common"
 	"github.com/ubuntu/adsys/internal/ad/registry"
 	"github.com/ubuntu/adsys/internal/consts"
+	"github.com/ubuntu/adsys/internal/diskspace"
 	log "github.com/ubuntu/adsys/internal/grpc/logstreamer"
 	"github.com/ubuntu/adsys/internal/i18n"
 	"github.com/ubuntu/adsys/internal/policies"
 	"github.com/ubuntu/adsys/internal/policies/entry"
 	"github.com/ubuntu/adsys/internal/smbsafe"
 	"github.com/ubuntu/decorate"
+	"golang.org/x/exp/slices"
 	"golang.org/x/sync/errgroup"
 )
 
@@ -43,6 +48,12 @@ const (
 	ComputerObject ObjectClass = "computer"
 )
 
+// gpoListConnectionFailed is the exit code of adsys-gpolist when the domain controller is unreachable.
+const gpoListConnectionFailed = 2
+
+// groupsCacheBaseName is the cache directory where the group membership of each object is recorded.
+const groupsCacheBaseName = "groups"
+
 type gpo downloadable
 
 type downloadable struct {
@@ -62,7 +73,9 @@ type AD struct {
 	configBackend backends.Backend
 
 	versionID        string
+	arch             string
 	sysvolCacheDir   string
+	cacheDir         string
 	policiesCacheDir string
 	krb5CacheDir     string
 
@@ -72,15 +85,100 @@ type AD struct {
 
 	withoutKerberos bool
 	gpoListCmd      []string
+	gpoLinkCache    string
+	gpoLinkCacheTTL time.Duration
+	gpoRolloutDelay time.Duration
+	gpoRolloutRing  string
+	// rolloutStatePath records when remote GPO versions were first seen.
+	rolloutStatePath string
+
+	maxConcurrentDownloads int
+	maxFileSize            int64
+	maxPolSize             int64
+	downloadLimiter        *rateLimiter
+	downloadBudget         int64
+	stats                  downloadStats
+
+	offlinePolicy OfflinePolicy
+
+	backoff          Backoff
+	backoffStatePath string
+	backoffMu        sync.Mutex
+
+	// configuredIgnoredGPOs are the normalized IDs of the GPOs to ignore set in the configuration.
+	configuredIgnoredGPOs []string
+	// ignoredGPOsStatePath records the GPOs to ignore delivered by the machine policies.
+	ignoredGPOsStatePath string
+	ignoredGPOsMu        sync.Mutex
+
+	// configuredLocalUsers maps the local users and groups set in the configuration to their account in the directory.
+	configuredLocalUsers map[string]string
+	// localUsersStatePath records the local users mapping delivered by the machine policies.
+	localUsersStatePath string
+	// localUsersListPath lists the mapped local users and groups for the PAM module.
+	localUsersListPath string
+	localUsersMu       sync.Mutex
+	// passwdFile and groupFile are the local accounts database of the mapped local users.
+	passwdFile string
+	groupFile  string
+}
+
+// downloadStats are the counters of downloaded files since the service started.
+type downloadStats struct {
+	files    atomic.Int64
+	bytes    atomic.Int64
+	rejected atomic.Int64
+}
+
+// Limits are the resource limits when downloading and parsing GPOs. Zero values use the defaults.
+type Limits struct {
+	// MaxConcurrentDownloads is the maximum number of GPOs and assets downloaded at the same time.
+	MaxConcurrentDownloads int `mapstructure:"max_concurrent_downloads"`
+	// MaxFileSize is the maximum size in MiB of a file downloaded from the SYSVOL share, including assets.
+	MaxFileSize int64 `mapstructure:"max_file_size"`
+	// MaxPolSize is the maximum size in MiB of a Registry.pol file to parse.
+	MaxPolSize int64 `mapstructure:"max_pol_size"`
+	// MaxDownloadRate is the maximum rate in KiB/s of all downloads from the SYSVOL share. 0 is unlimited.
+	MaxDownloadRate int64 `mapstructure:"max_download_rate"`
+	// DownloadBudget is the size in MiB after which no new GPO or assets download is started during a refresh.
+	// 0 is unlimited.
+	DownloadBudget int64 `mapstructure:"download_budget"`
+}
+
+// OfflinePolicy is how the policies cached by a previous online update are used when Active Directory is unreachable.
+type OfflinePolicy struct {
+	// MaxCacheAge is the time in seconds after which cached policies are stale. 0 never considers them stale.
+	MaxCacheAge int `mapstructure:"max_cache_age"`
+	// RefuseStale fails instead of warning when the cached policies of a user are stale, which refuses the login.
+	// The stale policies of the machine are always applied, to not prevent the machine from booting.
+	RefuseStale bool `mapstructure:"refuse_stale"`
 }
 
 type options struct {
 	versionID string
+	arch      string
 	runDir    string
 	cacheDir  string
 
 	withoutKerberos bool
 	gpoListCmd      []string
+	gpoLinkCacheTTL time.Duration
+	gpoRolloutDelay time.Duration
+	gpoRolloutRing  string
+
+	maxConcurrentDownloads int
+	maxFileSize            int64
+	maxPolSize             int64
+	maxDownloadRate        int64
+	downloadBudget         int64
+
+	offlinePolicy OfflinePolicy
+	backoff       Backoff
+	ignoredGPOs   []string
+	localUsers    map[string]string
+
+	passwdFile string
+	groupFile  string
 }
 
 // Option reprents an optional function to change AD behavior.
@@ -102,6 +200,113 @@ func WithRunDir(runDir string) Option {
 	}
 }
 
+// WithGPOLinkCacheTTL specifies how long the GPO links of containers are cached between requests.
+// A zero value disables the cache.
+func WithGPOLinkCacheTTL(ttl time.Duration) Option {
+	return func(o *options) error {
+		o.gpoLinkCacheTTL = ttl
+		return nil
+	}
+}
+
+// WithGPORolloutDelay specifies how long a new GPO version must have been seen on the Active Directory before
+// being downloaded and applied. A zero value applies new versions immediately.
+func WithGPORolloutDelay(delay time.Duration) Option {
+	return func(o *options) error {
+		if delay < 0 {
+			return fmt.Errorf(i18n.G("invalid negative GPO rollout delay: %s"), delay)
+		}
+		o.gpoRolloutDelay = delay
+		return nil
+	}
+}
+
+// WithGPORolloutRing specifies the rollout ring of the machine: only the GPO versions tagged for this ring are
+// downloaded and applied. An empty ring applies all versions.
+func WithGPORolloutRing(ring string) Option {
+	return func(o *options) error {
+		o.gpoRolloutRing = ring
+		return nil
+	}
+}
+
+// WithLimits specifies the resource limits when downloading and parsing GPOs.
+func WithLimits(limits Limits) Option {
+	return func(o *options) error {
+		if limits.MaxConcurrentDownloads < 0 || limits.MaxFileSize < 0 || limits.MaxPolSize < 0 ||
+			limits.MaxDownloadRate < 0 || limits.DownloadBudget < 0 {
+			return fmt.Errorf(i18n.G("invalid negative limits: %+v"), limits)
+		}
+		if limits.MaxConcurrentDownloads > 0 {
+			o.maxConcurrentDownloads = limits.MaxConcurrentDownloads
+		}
+		if limits.MaxFileSize > 0 {
+			o.maxFileSize = limits.MaxFileSize << 20
+		}
+		if limits.MaxPolSize > 0 {
+			o.maxPolSize = limits.MaxPolSize << 20
+		}
+		o.maxDownloadRate = limits.MaxDownloadRate << 10
+		o.downloadBudget = limits.DownloadBudget << 20
+		return nil
+	}
+}
+
+// WithOfflinePolicy specifies how cached policies are used when Active Directory is unreachable.
+func WithOfflinePolicy(p OfflinePolicy) Option {
+	return func(o *options) error {
+		if p.MaxCacheAge < 0 {
+			return fmt.Errorf(i18n.G("invalid negative maximum cache age: %d"), p.MaxCacheAge)
+		}
+		o.offlinePolicy = p
+		return nil
+	}
+}
+
+// WithBackoff specifies how contacting the domain controller is throttled after connection failures.
+func WithBackoff(b Backoff) Option {
+	return func(o *options) error {
+		if b.Initial < 0 || b.Max < 0 {
+			return fmt.Errorf(i18n.G("invalid negative backoff: %+v"), b)
+		}
+		if b.Max == 0 {
+			b.Max = consts.DefaultMaxBackoff
+		}
+		if b.Initial > b.Max {
+			return fmt.Errorf(i18n.G("initial backoff %d is greater than maximum backoff %d"), b.Initial, b.Max)
+		}
+		o.backoff = b
+		return nil
+	}
+}
+
+// WithIgnoredGPOs specifies the IDs of the GPOs which are never downloaded nor applied on the client, for instance
+// when a GPO breaks the clients and can't be unlinked quickly. Braces and case don't matter.
+func WithIgnoredGPOs(ids []string) Option {
+	return func(o *options) error {
+		for _, id := range ids {
+			o.ignoredGPOs = append(o.ignoredGPOs, parseGPOIDs(id)...)
+		}
+		return nil
+	}
+}
+
+// WithLocalUsers specifies the local users, and local groups prefixed with %, which receive the user policies of an
+// account in the directory, like user@domain, as they are not in the directory themselves.
+func WithLocalUsers(mapping map[string]string) Option {
+	return func(o *options) error {
+		o.localUsers = make(map[string]string)
+		for name, account := range mapping {
+			name, account, err := parseLocalUser(name, account)
+			if err != nil {
+				return err
+			}
+			o.localUsers[name] = account
+		}
+		return nil
+	}
+}
+
 // AdsysGpoListCode is the embedded script which request
 // Samba to get our GPO list for the given object.
 //
@@ -123,6 +328,16 @@ func New(ctx context.Context, configBackend backends.Backend, hostname string, o
 		cacheDir:   consts.DefaultCacheDir,
 		gpoListCmd: []string{"python3", "-c", AdsysGpoListCode},
 		versionID:  versionID,
+		arch:       adcommon.GetArchitecture(),
+
+		gpoLinkCacheTTL: consts.DefaultGPOLinkCacheTTL * time.Second,
+
+		maxConcurrentDownloads: consts.DefaultMaxConcurrentDownloads,
+		maxFileSize:            consts.DefaultMaxDownloadFileSize,
+		maxPolSize:             consts.DefaultMaxPolSize,
+
+		passwdFile: "/etc/passwd",
+		groupFile:  "/etc/group",
 	}
 	// applied options
 	for _, o := range opts {
@@ -135,7 +350,7 @@ func New(ctx context.Context, configBackend backends.Backend, hostname string, o
 	if err := os.MkdirAll(filepath.Join(krb5CacheDir, "tracking"), 0700); err != nil {
 		return nil, err
 	}
-	sysvolCacheDir := filepath.Join(args.cacheDir, "sysvol")
+	sysvolCacheDir := filepath.Join(args.cacheDir, policies.SysvolCacheBaseName)
 	// Create Policies subdirectory under sysvol
 	if err := os.MkdirAll(filepath.Join(sysvolCacheDir, "Policies"), 0700); err != nil {
 		return nil, err
@@ -144,6 +359,12 @@ func New(ctx context.Context, configBackend backends.Backend, hostname string, o
 	if err := os.MkdirAll(policiesCacheDir, 0700); err != nil {
 		return nil, err
 	}
+	if err := os.MkdirAll(filepath.Join(args.cacheDir, groupsCacheBaseName), 0700); err != nil {
+		return nil, err
+	}
+	if err := policies.MigrateToDomainLayout(ctx, args.cacheDir, groupsCacheBaseName); err != nil {
+		return nil, err
+	}
 
 	domain := configBackend.Domain()
 	serverURL, err := configBackend.ServerURL(ctx)
@@ -152,17 +373,78 @@ func New(ctx context.Context, configBackend backends.Backend, hostname string, o
 	}
 	log.Debugf(ctx, "Backend is SSSD. AD domain: %q, server from configuration: %q", domain, serverURL)
 
-	return &AD{
+	ad = &AD{
 		hostname:         hostname,
 		configBackend:    configBackend,
 		versionID:        args.versionID,
+		arch:             args.arch,
 		sysvolCacheDir:   sysvolCacheDir,
+		cacheDir:         args.cacheDir,
 		policiesCacheDir: policiesCacheDir,
 		krb5CacheDir:     krb5CacheDir,
 
-		downloadables: make(map[string]*downloadable),
-		gpoListCmd:    args.gpoListCmd,
-	}, nil
+		downloadables:    make(map[string]*downloadable),
+		gpoListCmd:       args.gpoListCmd,
+		gpoLinkCache:     filepath.Join(args.runDir, "gpolinks"),
+		gpoLinkCacheTTL:  args.gpoLinkCacheTTL,
+		gpoRolloutDelay:  args.gpoRolloutDelay,
+		gpoRolloutRing:   args.gpoRolloutRing,
+		rolloutStatePath: filepath.Join(args.cacheDir, "gporollout"),
+
+		maxConcurrentDownloads: args.maxConcurrentDownloads,
+		maxFileSize:            args.maxFileSize,
+		maxPolSize:             args.maxPolSize,
+		downloadLimiter:        newRateLimiter(args.maxDownloadRate),
+		downloadBudget:         args.downloadBudget,
+
+		offlinePolicy: args.offlinePolicy,
+
+		backoff:          args.backoff,
+		backoffStatePath: filepath.Join(args.runDir, "dcbackoff"),
+
+		configuredIgnoredGPOs: args.ignoredGPOs,
+		ignoredGPOsStatePath:  filepath.Join(args.cacheDir, "ignoredgpos"),
+
+		configuredLocalUsers: args.localUsers,
+		localUsersStatePath:  filepath.Join(args.cacheDir, "localusers"),
+		localUsersListPath:   filepath.Join(args.runDir, "local-users"),
+		passwdFile:           args.passwdFile,
+		groupFile:            args.groupFile,
+	}
+
+	// The PAM module needs the mapped local users before any machine policy is applied.
+	ad.localUsersMu.Lock()
+	defer ad.localUsersMu.Unlock()
+	if err := ad.writeLocalUsersList(ctx); err != nil {
+		return nil, err
+	}
+
+	return ad, nil
+}
+
+type getPoliciesOptions struct {
+	machineCredentialsFallback bool
+	dryRun                     bool
+}
+
+// GetPoliciesOption reprents an optional function to change how policies are retrieved.
+type GetPoliciesOption func(*getPoliciesOptions)
+
+// WithMachineCredentialsFallback authenticates to AD with the machine ticket when a user has no ticket, or when the
+// ticket it logged in with is gone, like for a user who is not logged in.
+func WithMachineCredentialsFallback() GetPoliciesOption {
+	return func(o *getPoliciesOptions) {
+		o.machineCredentialsFallback = true
+	}
+}
+
+// WithDryRun retrieves the policies without recording anything about the object nor the domain controller: the group
+// membership, the GPOs to ignore and the local users delivered by the machine policies, the connection failures and
+// the rollout state are left untouched. Only the cache of the downloaded GPOs and assets is refreshed.
+func WithDryRun() GetPoliciesOption {
+	return func(o *getPoliciesOptions) {
+		o.dryRun = true
+	}
 }
 
 // GetPolicies returns all policy entries, stacked in order of priority.GetPolicies
@@ -172,40 +454,37 @@ func New(ctx context.Context, configBackend backends.Backend, hostname string, o
 // ticket <krb5CCDir>/<objectName>.
 // The GPOs are returned from the highest priority in the hierarchy, with enforcement in reverse order
 // to the lowest priority.
-func (ad *AD) GetPolicies(ctx context.Context, objectName string, objectClass ObjectClass, userKrb5CCName string) (pols policies.Policies, err error) {
+// Mapped local users get the policies of their account in the directory, authenticated with the machine ticket.
+func (ad *AD) GetPolicies(ctx context.Context, objectName string, objectClass ObjectClass, userKrb5CCName string, opts ...GetPoliciesOption) (pols policies.Policies, err error) {
 	defer decorate.OnError(&err, i18n.G("can't get policies for %q"), objectName)
 
+	var getOpts getPoliciesOptions
+	for _, o := range opts {
+		o(&getOpts)
+	}
+
 	log.Debugf(ctx, "GetPolicies for %q, type %q", objectName, objectClass)
 
-	if objectClass == UserObject && !strings.Contains(objectName, "@") {
-		return pols, fmt.Errorf(i18n.G("user name %q should be of the form %s@DOMAIN"), objectName, objectName)
+	account, local, err := ad.directoryAccount(ctx, objectName, objectClass)
+	if err != nil {
+		return pols, err
 	}
 
 	if objectClass == ComputerObject && objectName != ad.hostname {
 		return pols, fmt.Errorf(i18n.G("requested a type computer of %q which isn't current host %q"), objectName, ad.hostname)
 	}
 
-	krb5CCPath := filepath.Join(ad.krb5CacheDir, objectName)
-	krb5CCSymlink := filepath.Join(ad.krb5CacheDir, "tracking", objectName)
-	// Create a ccache symlink on first fetch for future calls (on refresh for instance)
-	if userKrb5CCName != "" || objectClass == ComputerObject {
-		src := userKrb5CCName
-		// there is no env var for machine: get sss ccache
-		if objectClass == ComputerObject {
-			src, err = ad.configBackend.HostKrb5CCName()
-			if err != nil {
-				return pols, err
-			}
-		}
-
-		// Create a symlink to the ccache file
-		if err := ad.ensureKrb5CCSymlink(src, krb5CCSymlink); err != nil {
-			return pols, err
-		}
+	// Local users have no ticket: the account whose policies they receive is read with the machine one.
+	ticketName, ticketClass := objectName, objectClass
+	if local {
+		ticketName, ticketClass = ad.hostname, ComputerObject
 	}
-
-	// Ensure we have an up-to-date copy of the ccache file
-	if err := ad.ensureKrb5CCCopy(krb5CCSymlink, krb5CCPath); err != nil {
+	krb5CCPath, err := ad.prepareKrb5CC(ticketName, ticketClass, userKrb5CCName)
+	if err != nil && getOpts.machineCredentialsFallback && objectClass == UserObject && userKrb5CCName == "" {
+		log.Infof(ctx, i18n.G("No valid ticket for %q, using the machine credentials: %v"), objectName, err)
+		krb5CCPath, err = ad.prepareKrb5CC(ad.hostname, ComputerObject, "")
+	}
+	if err != nil {
 		return pols, err
 	}
 
@@ -216,24 +495,47 @@ func (ad *AD) GetPolicies(ctx context.Context, objectName string, objectClass Ob
 
 	// If sssd returns that we are offline, returns the cache list of GPOs if present
 	if !online {
-		var cachedPolicies policies.Policies
-		if cachedPolicies, err = policies.NewFromCache(ctx, filepath.Join(ad.policiesCacheDir, objectName)); err != nil {
-			return cachedPolicies, fmt.Errorf(i18n.G("machine is offline and policies cache is unavailable: %v"), err)
-		}
+		return ad.cachedPolicies(ctx, objectName, objectClass)
+	}
 
-		log.Infof(ctx, "Can't reach AD: machine is offline and %q policies are applied using previous online update", objectName)
-		return cachedPolicies, nil
+	// Don’t contact a domain controller which recently failed, unless there are no cached policies to apply.
+	if state, ok := ad.backingOff(ctx); ok {
+		if pols, err := ad.cachedPolicies(ctx, objectName, objectClass); err == nil {
+			log.Infof(ctx, "Not contacting the domain controller before %s after %d connection failures", state.Until.Format(time.RFC3339), state.Failures)
+			return pols, nil
+		}
 	}
 
 	// We need an AD LDAP url to connect to
 	adServerURL, err := ad.configBackend.ServerURL(ctx)
-	if err != nil {
+	if errors.Is(err, backends.ErrNoActiveServer) {
+		log.Infof(ctx, "No active Active Directory server: %v", err)
+		return ad.cachedPolicies(ctx, objectName, objectClass)
+	} else if err != nil {
 		return policies.Policies{}, fmt.Errorf(i18n.G("can't get current Server URL: %w"), err)
 	}
 
 	// Otherwise, try fetching the GPO list from LDAP
 	args := append([]string{}, ad.gpoListCmd...) // Copy gpoListCmd to prevent data race
-	scriptArgs := []string{"--objectclass", string(objectClass), adServerURL, objectName}
+	// Record the group membership the GPO list is resolved from, to detect later changes.
+	groupsOutput := policies.ObjectCachePath(ad.cacheDir, groupsCacheBaseName, objectName)
+	if getOpts.dryRun {
+		tmpDir, err := os.MkdirTemp("", "adsys-groups-*")
+		if err != nil {
+			return policies.Policies{}, err
+		}
+		defer decorate.LogFuncOnErrorContext(ctx, func() error { return os.RemoveAll(tmpDir) })
+		groupsOutput = filepath.Join(tmpDir, "groups")
+	}
+	if err := os.MkdirAll(filepath.Dir(groupsOutput), 0700); err != nil {
+		return policies.Policies{}, err
+	}
+	scriptArgs := []string{"--objectclass", string(objectClass), "--groups-output", groupsOutput}
+	// Share the GPO links of the containers between close requests, like many users login in the same OU.
+	if ad.gpoLinkCacheTTL > 0 {
+		scriptArgs = append(scriptArgs, "--link-cache", ad.gpoLinkCache, "--link-cache-ttl", strconv.Itoa(int(ad.gpoLinkCacheTTL.Seconds())))
+	}
+	scriptArgs = append(scriptArgs, adServerURL, account)
 	cmdArgs := append(args, scriptArgs...)
 	cmdCtx, cancel := context.WithTimeout(ctx, time.Second*10)
 	defer cancel()
@@ -248,9 +550,23 @@ func (ad *AD) GetPolicies(ctx context.Context, objectName string, objectClass Ob
 	smbsafe.WaitExec()
 	err = cmd.Run()
 	smbsafe.DoneExec()
-	if err != nil {
+	// The backend can report being online while the domain controller is unreachable.
+	if err != nil && cmd.ProcessState.ExitCode() == gpoListConnectionFailed {
+		log.Infof(ctx, "Can't connect to Active Directory server %q: %s", adServerURL, stderr.String())
+		if !getOpts.dryRun {
+			ad.recordConnectionFailure(ctx)
+		}
+		return ad.cachedPolicies(ctx, objectName, objectClass)
+	} else if err != nil {
+		// An overloaded domain controller doesn't answer in time.
+		if errors.Is(cmdCtx.Err(), context.DeadlineExceeded) && !getOpts.dryRun {
+			ad.recordConnectionFailure(ctx)
+		}
 		return pols, fmt.Errorf(i18n.G("failed to retrieve the list of GPO (exited with %d): %v\n%s"), cmd.ProcessState.ExitCode(), err, stderr.String())
 	}
+	if !getOpts.dryRun {
+		ad.resetBackoff(ctx)
+	}
 
 	downloadables := make(map[string]string)
 	var orderedGPOs []gpo
@@ -277,10 +593,17 @@ func (ad *AD) GetPolicies(ctx context.Context, objectName string, objectClass Ob
 	if err := scanner.Err(); err != nil {
 		return pols, err
 	}
+	ignored := ad.ignoredGPOs(ctx)
+	orderedGPOs = skipIgnoredGPOs(ctx, objectName, orderedGPOs, downloadables, ignored)
+
+	// Fail before downloading anything rather than leaving a partially refreshed sysvol cache.
+	if err := diskspace.Check(ctx, consts.MinFreeDiskSpace, ad.sysvolCacheDir); err != nil {
+		return pols, err
+	}
 
 	ad.Lock()
 	defer ad.Unlock()
-	assetsWereRefresh, err := ad.fetch(ctx, krb5CCPath, downloadables)
+	assetsWereRefresh, err := ad.fetch(ctx, krb5CCPath, downloadables, getOpts.dryRun)
 	if err != nil {
 		return pols, err
 	}
@@ -333,12 +656,264 @@ func (ad *AD) GetPolicies(ctx context.Context, objectName string, objectClass Ob
 	if err := errg.Wait(); err != nil {
 		return pols, fmt.Errorf("one or more error while parsing downloaded elements: %w", err)
 	}
+	gposRules = ad.withPendingGPOsFromCache(ctx, objectName, gposRules)
+
+	// The GPOs to ignore delivered by the machine policies take effect immediately, and are kept for the users.
+	// The local users mapping is read before the policies configuring adsys are taken out.
+	localUsers := deliveredLocalUsers(ctx, gposRules)
+	delivered := takeDeliveredIgnoredGPOs(gposRules)
+	if objectClass == ComputerObject && !getOpts.dryRun {
+		if err := ad.saveDeliveredIgnoredGPOs(delivered); err != nil {
+			log.Warning(ctx, err)
+		}
+		if err := ad.saveDeliveredLocalUsers(ctx, localUsers); err != nil {
+			log.Warning(ctx, err)
+		}
+		for _, id := range delivered {
+			ignored[id] = struct{}{}
+		}
+	}
+
+	pols, err = policies.New(ctx, gposRules, assetsDbPath)
+	if err != nil {
+		return pols, err
+	}
+	return withoutIgnoredGPOs(ctx, objectName, pols, ignored), nil
+}
+
+// withPendingGPOsFromCache replaces in gpos the GPOs which were never downloaded, as they are held back by the
+// rollout or by the download budget, by their rules cached for objectName by its previous update. Pending GPOs
+// without cached rules are removed: they take effect once downloaded.
+func (ad *AD) withPendingGPOsFromCache(ctx context.Context, objectName string, gpos []policies.GPO) []policies.GPO {
+	var cached *policies.Policies
+	var r []policies.GPO
+	for _, g := range gpos {
+		if _, err := os.Stat(filepath.Join(ad.sysvolCacheDir, "Policies", g.ID)); err == nil {
+			r = append(r, g)
+			continue
+		}
+
+		if cached == nil {
+			cached = &policies.Policies{}
+			p, err := policies.NewFromCache(ctx, policies.ObjectCachePath(ad.cacheDir, policies.PoliciesCacheBaseName, objectName))
+			if err != nil && !errors.Is(err, fs.ErrNotExist) {
+				log.Warningf(ctx, i18n.G("Can't load cached policies of %q to keep the GPOs not downloaded yet: %v"), objectName, err)
+			} else if err == nil {
+				defer decorate.LogFuncOnErrorContext(ctx, p.Close)
+				cached = &p
+			}
+		}
+
+		i := slices.IndexFunc(cached.GPOs, func(c policies.GPO) bool { return c.ID == g.ID })
+		if i < 0 {
+			log.Infof(ctx, i18n.G("GPO %q is not downloaded yet and is not applied to %q"), g.Name, objectName)
+			continue
+		}
+		log.Infof(ctx, i18n.G("GPO %q is not downloaded yet, keeping its previous rules for %q"), g.Name, objectName)
+		r = append(r, cached.GPOs[i])
+	}
+	return r
+}
+
+// cachedPolicies returns the policies of objectName cached by its previous online update, when Active Directory
+// is unreachable.
+// If they are older than the maximum cache age, a warning is logged, or an error is returned for users when
+// stale policies are refused.
+func (ad *AD) cachedPolicies(ctx context.Context, objectName string, objectClass ObjectClass) (pols policies.Policies, err error) {
+	cacheDir := policies.ObjectCachePath(ad.cacheDir, policies.PoliciesCacheBaseName, objectName)
+	if pols, err = policies.NewFromCache(ctx, cacheDir); err != nil {
+		return pols, fmt.Errorf(i18n.G("machine is offline and policies cache is unavailable: %v"), err)
+	}
+
+	if maxAge := time.Duration(ad.offlinePolicy.MaxCacheAge) * time.Second; maxAge > 0 {
+		info, err := os.Stat(cacheDir)
+		if err != nil {
+			return policies.Policies{}, err
+		}
+		if age := time.Since(info.ModTime()); age > maxAge {
+			age = age.Truncate(time.Second)
+			if ad.offlinePolicy.RefuseStale && objectClass == UserObject {
+				return policies.Policies{}, fmt.Errorf(i18n.G("machine is offline and cached policies of %q are too old: last online update was %s ago, more than %s"), objectName, age, maxAge)
+			}
+			log.Warningf(ctx, i18n.G("Cached policies of %q are stale: last online update was %s ago, more than %s"), objectName, age, maxAge)
+		}
+	}
+
+	log.Infof(ctx, "Can't reach AD: machine is offline and %q policies are applied using previous online update", objectName)
+	return withoutIgnoredGPOs(ctx, objectName, pols, ad.ignoredGPOs(ctx)), nil
+}
+
+// GroupMembershipChanged returns true if the groups of objectName, used to filter its GPOs, changed since its
+// policies were last retrieved with GetPolicies.
+// If no group membership was recorded for objectName, it is considered as changed.
+// A machine which is offline can't detect any change and always returns false.
+func (ad *AD) GroupMembershipChanged(ctx context.Context, objectName string, objectClass ObjectClass) (changed bool, err error) {
+	defer decorate.OnError(&err, i18n.G("can't check group membership of %q"), objectName)
+
+	log.Debugf(ctx, "Check group membership changes for %q, type %q", objectName, objectClass)
+
+	account, local, err := ad.directoryAccount(ctx, objectName, objectClass)
+	if err != nil {
+		return false, err
+	}
+	ticketName, ticketClass := objectName, objectClass
+	if local {
+		ticketName, ticketClass = ad.hostname, ComputerObject
+	}
+	krb5CCPath, err := ad.prepareKrb5CC(ticketName, ticketClass, "")
+	if err != nil {
+		return false, err
+	}
+
+	online, err := ad.configBackend.IsOnline()
+	if err != nil {
+		return false, err
+	}
+	if !online {
+		log.Debugf(ctx, "Machine is offline: can't check group membership of %q", objectName)
+		return false, nil
+	}
+	if _, ok := ad.backingOff(ctx); ok {
+		log.Debugf(ctx, "Not contacting the domain controller after connection failures: can't check group membership of %q", objectName)
+		return false, nil
+	}
+
+	adServerURL, err := ad.configBackend.ServerURL(ctx)
+	if err != nil {
+		return false, fmt.Errorf(i18n.G("can't get current Server URL: %w"), err)
+	}
+
+	args := append([]string{}, ad.gpoListCmd...) // Copy gpoListCmd to prevent data race
+	scriptArgs := []string{"--objectclass", string(objectClass), "--groups", adServerURL, account}
+	cmdArgs := append(args, scriptArgs...)
+	cmdCtx, cancel := context.WithTimeout(ctx, time.Second*10)
+	defer cancel()
+	// #nosec G204 - cmdArgs is under our control (python embedded script or mock for tests)
+	cmd := exec.CommandContext(cmdCtx, cmdArgs[0], cmdArgs[1:]...)
+	cmd.Env = append(os.Environ(), fmt.Sprintf("KRB5CCNAME=%s", krb5CCPath))
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	smbsafe.WaitExec()
+	err = cmd.Run()
+	smbsafe.DoneExec()
+	if err != nil {
+		return false, fmt.Errorf(i18n.G("failed to retrieve the group membership (exited with %d): %v\n%s"), cmd.ProcessState.ExitCode(), err, stderr.String())
+	}
+
+	previous, err := os.ReadFile(policies.ObjectCachePath(ad.cacheDir, groupsCacheBaseName, objectName))
+	if errors.Is(err, fs.ErrNotExist) {
+		log.Debugf(ctx, "No group membership recorded for %q", objectName)
+		return true, nil
+	} else if err != nil {
+		return false, err
+	}
+
+	return !bytes.Equal(previous, stdout.Bytes()), nil
+}
+
+// SetComputerAttribute writes value to attribute of the computer object of the machine, authenticated with the
+// machine ticket. It is used to escrow the values generated on the machine, like the password of a local break-glass
+// account: the computer account must be allowed to write this attribute.
+// The value is passed to the script on its standard input, so that it never appears in the process list.
+func (ad *AD) SetComputerAttribute(ctx context.Context, attribute, value string) (err error) {
+	defer decorate.OnError(&err, i18n.G("can't set attribute %q of computer %q"), attribute, ad.hostname)
+
+	log.Debugf(ctx, "Set attribute %q of computer %q", attribute, ad.hostname)
+
+	_, err = ad.computerAttributeCmd(ctx, []string{"--set-attribute", attribute}, value)
+	return err
+}
+
+// ComputerAttribute returns the value of attribute of the computer object of the machine, or an empty string if it is
+// not set, authenticated with the machine ticket. It is used to read back the escrowed values the domain
+// administrators can change, like the expiration time of the password of the local administrator.
+func (ad *AD) ComputerAttribute(ctx context.Context, attribute string) (value string, err error) {
+	defer decorate.OnError(&err, i18n.G("can't get attribute %q of computer %q"), attribute, ad.hostname)
+
+	log.Debugf(ctx, "Get attribute %q of computer %q", attribute, ad.hostname)
+
+	return ad.computerAttributeCmd(ctx, []string{"--get-attribute", attribute}, "")
+}
+
+// computerAttributeCmd runs the GPO list script with attributeArgs on the computer object of the machine, passing stdin
+// on its standard input. It returns the standard output of the script.
+func (ad *AD) computerAttributeCmd(ctx context.Context, attributeArgs []string, stdin string) (string, error) {
+	krb5CCPath, err := ad.prepareKrb5CC(ad.hostname, ComputerObject, "")
+	if err != nil {
+		return "", err
+	}
+
+	online, err := ad.configBackend.IsOnline()
+	if err != nil {
+		return "", err
+	}
+	if !online {
+		return "", errors.New(i18n.G("machine is offline"))
+	}
+
+	adServerURL, err := ad.configBackend.ServerURL(ctx)
+	if err != nil {
+		return "", fmt.Errorf(i18n.G("can't get current Server URL: %w"), err)
+	}
+
+	args := append([]string{}, ad.gpoListCmd...) // Copy gpoListCmd to prevent data race
+	scriptArgs := append([]string{"--objectclass", string(ComputerObject)}, attributeArgs...)
+	scriptArgs = append(scriptArgs, adServerURL, ad.hostname)
+	cmdArgs := append(args, scriptArgs...)
+	cmdCtx, cancel := context.WithTimeout(ctx, time.Second*10)
+	defer cancel()
+	// #nosec G204 - cmdArgs is under our control (python embedded script or mock for tests)
+	cmd := exec.CommandContext(cmdCtx, cmdArgs[0], cmdArgs[1:]...)
+	cmd.Env = append(os.Environ(), fmt.Sprintf("KRB5CCNAME=%s", krb5CCPath))
+	cmd.Stdin = strings.NewReader(stdin)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	smbsafe.WaitExec()
+	err = cmd.Run()
+	smbsafe.DoneExec()
+	if err != nil {
+		return "", fmt.Errorf(i18n.G("failed to access the attribute (exited with %d): %v\n%s"), cmd.ProcessState.ExitCode(), err, stderr.String())
+	}
+	return stdout.String(), nil
+}
+
+// prepareKrb5CC returns the path to an up-to-date copy of the ticket of objectName.
+// It records userKrb5CCName, or the machine ticket for a computer, as the ticket to track for future calls.
+func (ad *AD) prepareKrb5CC(objectName string, objectClass ObjectClass, userKrb5CCName string) (krb5CCPath string, err error) {
+	krb5CCPath = filepath.Join(ad.krb5CacheDir, objectName)
+	krb5CCSymlink := filepath.Join(ad.krb5CacheDir, "tracking", objectName)
+	// Create a ccache symlink on first fetch for future calls (on refresh for instance)
+	if userKrb5CCName != "" || objectClass == ComputerObject {
+		src := userKrb5CCName
+		// there is no env var for machine: get sss ccache
+		if objectClass == ComputerObject {
+			src, err = ad.configBackend.HostKrb5CCName()
+			if err != nil {
+				return "", err
+			}
+		}
+
+		// Create a symlink to the ccache file
+		if err := ad.ensureKrb5CCSymlink(src, krb5CCSymlink); err != nil {
+			return "", err
+		}
+	}
 
-	return policies.New(ctx, gposRules, assetsDbPath)
+	// Ensure we have an up-to-date copy of the ccache file
+	if err := ad.ensureKrb5CCCopy(krb5CCSymlink, krb5CCPath); err != nil {
+		return "", err
+	}
+
+	return krb5CCPath, nil
 }
 
 // ListUsers returns the list of users on the system based on their cached policy information.
 // If active is true, the list of users is retrieved from the cached Kerberos ticket information.
+// Mapped local users, who have no ticket, are listed once they have cached policies.
 func (ad *AD) ListUsers(ctx context.Context, active bool) (users []string, err error) {
 	defer decorate.OnError(&err, i18n.G("can't list users from cache"))
 
@@ -347,11 +922,29 @@ func (ad *AD) ListUsers(ctx context.Context, active bool) (users []string, err e
 	ad.Lock()
 	defer ad.Unlock()
 
-	cacheDir := ad.policiesCacheDir
-	if active {
-		cacheDir = filepath.Join(ad.krb5CacheDir, "tracking")
+	localUsers, err := ad.cachedLocalUsers(ctx)
+	if err != nil {
+		return users, fmt.Errorf(i18n.G("failed to read cache directory: %v"), err)
 	}
 
+	if !active {
+		// Users are cached in the directory of their domain.
+		if _, err := os.Stat(ad.policiesCacheDir); err != nil {
+			return users, fmt.Errorf(i18n.G("failed to read cache directory: %v"), err)
+		}
+		objects, err := policies.CachedObjects(ad.cacheDir, policies.PoliciesCacheBaseName)
+		if err != nil {
+			return users, fmt.Errorf(i18n.G("failed to read cache directory: %v"), err)
+		}
+		for _, objectName := range objects {
+			if strings.Contains(objectName, "@") {
+				users = append(users, objectName)
+			}
+		}
+		return append(users, localUsers...), nil
+	}
+
+	cacheDir := filepath.Join(ad.krb5CacheDir, "tracking")
 	entries, err := os.ReadDir(cacheDir)
 	if err != nil {
 		return users, fmt.Errorf(i18n.G("failed to read cache directory: %v"), err)
@@ -363,14 +956,12 @@ func (ad *AD) ListUsers(ctx context.Context, active bool) (users []string, err e
 		}
 
 		// Silently skip over dangling symlinks
-		if active {
-			if _, err := os.Stat(filepath.Join(cacheDir, entry.Name())); err != nil && errors.Is(err, fs.ErrNotExist) {
-				continue
-			}
+		if _, err := os.Stat(filepath.Join(cacheDir, entry.Name())); err != nil && errors.Is(err, fs.ErrNotExist) {
+			continue
 		}
 		users = append(users, entry.Name())
 	}
-	return users, nil
+	return append(users, localUsers...), nil
 }
 
 // ensureKrb5CCSymlink manages user ccname ticket symlinks.
@@ -461,6 +1052,20 @@ func safeCopyFile(src, dst string, mode os.FileMode) error {
 	return os.Rename(dst+".new", dst)
 }
 
+// valueVariant is the kind of value of a key. More specific variants override the less specific ones.
+type valueVariant int
+
+const (
+	// variantAll is the default value of the key, for all releases and architectures.
+	variantAll valueVariant = iota
+	// variantArch is the value for the current architecture, on all releases.
+	variantArch
+	// variantRelease is the enabled override for the current release.
+	variantRelease
+	// variantReleaseArch is the value for the current release and architecture.
+	variantReleaseArch
+)
+
 func (ad *AD) parseGPOs(ctx context.Context, gpos []gpo, objectClass ObjectClass) (r []policies.GPO, err error) {
 	keyFilterPrefix := fmt.Sprintf("%s/%s/", adcommon.KeyPrefix, consts.DistroID)
 
@@ -487,6 +1092,10 @@ func (ad *AD) parseGPOs(ctx context.Context, gpos []gpo, objectClass ObjectClass
 				classes = []string{"Machine", "MACHINE"}
 			}
 
+			if err := ad.parsePreferences(ctx, &gpoWithRules, classes, keyFilterPrefix); err != nil {
+				return err
+			}
+
 			var err error
 			var f *os.File
 			for _, class := range classes {
@@ -512,58 +1121,24 @@ func (ad *AD) parseGPOs(ctx context.Context, gpos []gpo, objectClass ObjectClass
 			}
 			defer decorate.LogFuncOnErrorContext(ctx, f.Close)
 
+			// Don't load an oversized policy file in memory
+			fi, err := f.Stat()
+			if err != nil {
+				return err
+			}
+			if fi.Size() > ad.maxPolSize {
+				ad.stats.rejected.Add(1)
+				return fmt.Errorf(i18n.G("%s is larger than the maximum policy size of %d bytes"), f.Name(), ad.maxPolSize)
+			}
+
 			// Decode and apply policies in gpo order. First win
 			pols, err := registry.DecodePolicy(f)
 			if err != nil {
 				return fmt.Errorf(i18n.G("%s: %v"), f.Name(), err)
 			}
 
-			// filter keys to be overridden
-			var currentKey string
-			var overrideEnabled bool
-			for _, pol := range pols {
-				// Only consider supported policies for this distro
-				if !strings.HasPrefix(pol.Key, keyFilterPrefix) {
-					continue
-				}
-				if pol.Err != nil {
-					return fmt.Errorf(i18n.G("%s: %v"), f.Name(), pol.Err)
-				}
-				pol.Key = strings.TrimPrefix(pol.Key, keyFilterPrefix)
-
-				// Some keys can be overridden
-				releaseID := filepath.Base(pol.Key)
-				keyType := strings.Split(pol.Key, "/")[0]
-				pol.Key = filepath.Dir(strings.TrimPrefix(pol.Key, keyType+"/"))
-
-				if releaseID == "all" {
-					currentKey = pol.Key
-					overrideEnabled = false
-					gpoWithRules.Rules[keyType] = append(gpoWithRules.Rules[keyType], pol)
-					continue
-				}
-
-				// This is not an "all" key and the key name don’t match
-				// This shouldn’t happen with our admx, but just to stay safe…
-				if currentKey != pol.Key {
-					continue
-				}
-
-				if strings.HasPrefix(releaseID, "Override"+ad.versionID) && pol.Value == "true" {
-					overrideEnabled = true
-					continue
-				}
-				// Check we have a matching override
-				if !overrideEnabled || releaseID != ad.versionID {
-					continue
-				}
-
-				// Matching enabled override
-				// Replace value with the override content
-				iLast := len(gpoWithRules.Rules[keyType]) - 1
-				p := gpoWithRules.Rules[keyType][iLast]
-				p.Value = pol.Value
-				gpoWithRules.Rules[keyType][iLast] = p
+			if err := ad.addRegistryRules(&gpoWithRules, pols); err != nil {
+				return fmt.Errorf(i18n.G("%s: %v"), f.Name(), err)
 			}
 			return nil
 		}(); err != nil {
@@ -574,6 +1149,233 @@ func (ad *AD) parseGPOs(ctx context.Context, gpos []gpo, objectClass ObjectClass
 	return r, nil
 }
 
+// ParseRegistryPolicy returns the GPO id, named name, with the rules of the Registry.pol content data, as if it
+// was downloaded from Active Directory. This allows to preview a GPO which is not linked yet.
+func (ad *AD) ParseRegistryPolicy(ctx context.Context, id, name string, data []byte) (g policies.GPO, err error) {
+	defer decorate.OnError(&err, i18n.G("can't parse policy of GPO %q"), name)
+
+	log.Debugf(ctx, "Parsing Registry.pol of GPO %q", name)
+
+	if int64(len(data)) > ad.maxPolSize {
+		return g, fmt.Errorf(i18n.G("policy is larger than the maximum policy size of %d bytes"), ad.maxPolSize)
+	}
+
+	pols, err := registry.DecodePolicy(bytes.NewReader(data))
+	if err != nil {
+		return g, err
+	}
+
+	g = policies.GPO{
+		ID:    id,
+		Name:  name,
+		Rules: make(map[string][]entry.Entry),
+	}
+	if err := ad.addRegistryRules(&g, pols); err != nil {
+		return policies.GPO{}, err
+	}
+	return g, nil
+}
+
+// reportOnlyValueName is the registry value flagging a key as report-only, or the whole GPO when it is set directly
+// under the distro key.
+const reportOnlyValueName = "reportonly"
+
+// addRegistryRules adds the entries of the decoded Registry.pol pols supported on this distro to the rules of g.
+// Release and architecture variants of a key replace its default value. Keys with a hostnames condition not matching
+// this machine are not added. Subnets and expires conditions are attached to the entries, as they are evaluated on each
+// apply.
+// Keys, or the whole GPO, can be flagged report-only, so that their entries are reported but not applied.
+func (ad *AD) addRegistryRules(g *policies.GPO, pols []entry.Entry) error {
+	keyFilterPrefix := fmt.Sprintf("%s/%s/", adcommon.KeyPrefix, consts.DistroID)
+
+	// Conditions can be defined before or after the key value: handle them once all values are known.
+	excludedKeys := make(map[string]bool)
+	subnets := make(map[string]string)
+	expires := make(map[string]string)
+	reportOnlyKeys := make(map[string]bool)
+	var reportOnlyGPO bool
+
+	// filter keys to be overridden
+	var currentKey string
+	var overrideEnabled bool
+	var currentVariant valueVariant
+	for _, pol := range pols {
+		// Only consider supported policies for this distro
+		if !strings.HasPrefix(pol.Key, keyFilterPrefix) {
+			continue
+		}
+		if pol.Err != nil {
+			return pol.Err
+		}
+		pol.Key = strings.TrimPrefix(pol.Key, keyFilterPrefix)
+
+		if pol.Key == reportOnlyValueName {
+			reportOnlyGPO = !pol.Disabled && pol.Value == "true"
+			continue
+		}
+
+		// Some keys can be overridden
+		releaseID := filepath.Base(pol.Key)
+		keyType := strings.Split(pol.Key, "/")[0]
+		pol.Key = filepath.Dir(strings.TrimPrefix(pol.Key, keyType+"/"))
+
+		if releaseID == "hostnames" {
+			if pol.Disabled {
+				continue
+			}
+			match, err := adcommon.MatchHostname(ad.hostname, pol.Value)
+			if err != nil {
+				return fmt.Errorf(i18n.G("%s: %v"), pol.Key, err)
+			}
+			if !match {
+				excludedKeys[keyType+"/"+pol.Key] = true
+			}
+			continue
+		}
+		if releaseID == "subnets" {
+			if pol.Disabled {
+				continue
+			}
+			if _, _, err := entry.ParseSubnets(pol.Value); err != nil {
+				return fmt.Errorf(i18n.G("%s: %v"), pol.Key, err)
+			}
+			subnets[keyType+"/"+pol.Key] = pol.Value
+			continue
+		}
+		if releaseID == "expires" {
+			if pol.Disabled {
+				continue
+			}
+			if _, err := entry.ParseExpiry(pol.Value); err != nil {
+				return fmt.Errorf(i18n.G("%s: %v"), pol.Key, err)
+			}
+			expires[keyType+"/"+pol.Key] = strings.TrimSpace(pol.Value)
+			continue
+		}
+
+		if releaseID == reportOnlyValueName {
+			if !pol.Disabled && pol.Value == "true" {
+				reportOnlyKeys[keyType+"/"+pol.Key] = true
+			}
+			continue
+		}
+
+		if releaseID == "all" {
+			currentKey = pol.Key
+			overrideEnabled = false
+			currentVariant = variantAll
+			g.Rules[keyType] = append(g.Rules[keyType], pol)
+			continue
+		}
+
+		// This is not an "all" key and the key name don’t match
+		// This shouldn’t happen with our admx, but just to stay safe…
+		if currentKey != pol.Key {
+			continue
+		}
+
+		var variant valueVariant
+		if release, arch, found := strings.Cut(releaseID, "@"); found {
+			// Architecture variants, like all@arm64 or 22.04@arm64, don’t need to be enabled.
+			if arch != ad.arch || (release != "all" && release != ad.versionID) {
+				continue
+			}
+			variant = variantArch
+			if release != "all" {
+				variant = variantReleaseArch
+			}
+		} else {
+			if strings.HasPrefix(releaseID, "Override"+ad.versionID) && pol.Value == "true" {
+				overrideEnabled = true
+				continue
+			}
+			// Check we have a matching override
+			if !overrideEnabled || releaseID != ad.versionID {
+				continue
+			}
+			variant = variantRelease
+		}
+
+		// The most specific variant wins, whatever the order they are defined in.
+		if variant < currentVariant {
+			continue
+		}
+		currentVariant = variant
+
+		// Matching enabled override
+		// Replace value with the override content
+		iLast := len(g.Rules[keyType]) - 1
+		p := g.Rules[keyType][iLast]
+		p.Value = pol.Value
+		g.Rules[keyType][iLast] = p
+	}
+
+	if len(excludedKeys) == 0 && len(subnets) == 0 && len(expires) == 0 && len(reportOnlyKeys) == 0 && !reportOnlyGPO {
+		return nil
+	}
+	for keyType, rules := range g.Rules {
+		var kept []entry.Entry
+		for _, r := range rules {
+			if excludedKeys[keyType+"/"+r.Key] {
+				continue
+			}
+			r.Subnets = subnets[keyType+"/"+r.Key]
+			r.Expires = expires[keyType+"/"+r.Key]
+			r.ReportOnly = reportOnlyGPO || reportOnlyKeys[keyType+"/"+r.Key]
+			kept = append(kept, r)
+		}
+		if len(kept) == 0 {
+			delete(g.Rules, keyType)
+			continue
+		}
+		g.Rules[keyType] = kept
+	}
+	return nil
+}
+
+// parsePreferences adds the dconf preferences defined in the GPO Registry.xml preference file to the GPO rules.
+// Each key can only be defined once per GPO: the last item wins, as it is the last one applied by Windows.
+func (ad *AD) parsePreferences(ctx context.Context, g *policies.GPO, classes []string, keyFilterPrefix string) (err error) {
+	var f *os.File
+	for _, class := range classes {
+		f, err = os.Open(filepath.Join(ad.sysvolCacheDir, "Policies", g.ID, class, "Preferences", "Registry", "Registry.xml"))
+		if err == nil {
+			break
+		}
+	}
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	defer decorate.LogFuncOnErrorContext(ctx, f.Close)
+
+	log.Debugf(ctx, "Parsing preferences of GPO %q", g.Name)
+
+	prefs, err := registry.DecodePreferences(f)
+	if err != nil {
+		return fmt.Errorf(i18n.G("%s: %v"), f.Name(), err)
+	}
+
+	dconfPrefix := keyFilterPrefix + "dconf/"
+	seen := make(map[string]int)
+	for _, pref := range prefs {
+		// Only dconf preferences are supported
+		if !strings.HasPrefix(pref.Key, dconfPrefix) {
+			continue
+		}
+		pref.Key = strings.TrimPrefix(pref.Key, dconfPrefix)
+		if i, exists := seen[pref.Key]; exists {
+			g.Rules["dconf-preferences"][i] = pref
+			continue
+		}
+		seen[pref.Key] = len(g.Rules["dconf-preferences"])
+		g.Rules["dconf-preferences"] = append(g.Rules["dconf-preferences"], pref)
+	}
+
+	return nil
+}
+
 // GetInfo returns all information from the selected backend: static and dynamic part.
 func (ad *AD) GetInfo(ctx context.Context) (msg string) {
 	// static part
@@ -593,13 +1395,34 @@ func (ad *AD) GetInfo(ctx context.Context) (msg string) {
 		server = "Unknown"
 	}
 
-	return fmt.Sprintf(i18n.G("%s\n%sDomain: %s\nServer URL: %s"), config, online, domain, server)
+	if state, ok := ad.backingOff(ctx); ok {
+		online += fmt.Sprintf(i18n.G("**Backing off** after %d connection failures, not contacting the domain controller before %s\n"),
+			state.Failures, state.Until.Format(time.RFC3339))
+	}
+
+	if ignored := ad.ignoredGPOs(ctx); len(ignored) > 0 {
+		var ids []string
+		for id := range ignored {
+			ids = append(ids, "{"+id+"}")
+		}
+		sort.Strings(ids)
+		online += fmt.Sprintf(i18n.G("**Ignoring GPOs** %s: their policies are not applied on this client\n"), strings.Join(ids, ", "))
+	}
+
+	if localUsers := ad.localUsers(ctx); len(localUsers) > 0 {
+		online += fmt.Sprintf(i18n.G("Local users receiving the user policies of an account: %s\n"), strings.ReplaceAll(formatLocalUsers(localUsers), "\n", ", "))
+	}
+
+	downloads := fmt.Sprintf(i18n.G("Downloaded files: %d (%d bytes), %d rejected by the limits"),
+		ad.stats.files.Load(), ad.stats.bytes.Load(), ad.stats.rejected.Load())
+
+	return fmt.Sprintf(i18n.G("%s\n%sDomain: %s\nServer URL: %s\n%s"), config, online, domain, server, downloads)
 }
 
 // NormalizeTargetName transforms the specified target to values adsys knows.
 // User: transforms and lowercases User or DOMAIN\User to user@domain.
 // Computer: strips the FQDN part, if it exists, and lowercases it.
-// If no domain is provided, we rely on having a default domain policy.
+// If no domain is provided, we rely on having a default domain policy, unless this is a mapped local user.
 func (ad *AD) NormalizeTargetName(ctx context.Context, target string, objectClass ObjectClass) (string, error) {
 	log.Debugf(ctx, "NormalizeTargetName for %q, type %q", target, objectClass)
 
@@ -633,6 +1456,12 @@ func (ad *AD) NormalizeTargetName(ctx context.Context, target string, objectClas
 	default:
 		return "", fmt.Errorf(i18n.G(`only one \ is permitted in domain\username. Got: %s`), target)
 	}
+	// Only names without domain can be mapped local users, which are not in the directory and keep their name.
+	if domainSuffix == "" {
+		if _, ok := ad.localUserAccount(ctx, baseUser); ok {
+			return baseUser, nil
+		}
+	}
 	if domainSuffix == "" && ad.configBackend.DefaultDomainSuffix() == "" {
 		return "", fmt.Errorf(i18n.G(`no domain provided for user %q and no default domain in sssd.conf`), target)
 	}
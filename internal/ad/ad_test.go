@@ -4,6 +4,7 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"io"
 	"io/fs"
 	"os"
 	"path/filepath"
@@ -11,6 +12,7 @@ import (
 	"strings"
 	"sync"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -33,6 +35,8 @@ func TestNew(t *testing.T) {
 		cacheDirRO            bool
 		runDirRO              bool
 		backendServerURLError error
+		limits                ad.Limits
+		rolloutDelay          time.Duration
 
 		wantErr bool
 	}{
@@ -43,6 +47,8 @@ func TestNew(t *testing.T) {
 		"failed to create Sysvol cache directory":   {cacheDirRO: true, wantErr: true},
 		"failed to create Policies cache directory": {sysvolCacheDirExists: true, cacheDirRO: true, wantErr: true},
 		"error on backend ServerURL random failure": {backendServerURLError: errors.New("Some failure on ServerURL"), wantErr: true},
+		"error on negative limits":                  {limits: ad.Limits{MaxFileSize: -1}, wantErr: true},
+		"error on negative rollout delay":           {rolloutDelay: -time.Second, wantErr: true},
 	}
 	for name, tc := range tests {
 		tc := tc
@@ -63,7 +69,9 @@ func TestNew(t *testing.T) {
 
 			adc, err := ad.New(context.Background(), mock.Backend{ErrServerURL: tc.backendServerURLError}, hostname,
 				ad.WithRunDir(runDir),
-				ad.WithCacheDir(cacheDir))
+				ad.WithCacheDir(cacheDir),
+				ad.WithLimits(tc.limits),
+				ad.WithGPORolloutDelay(tc.rolloutDelay))
 			if tc.wantErr {
 				require.NotNil(t, err, "AD creation should have failed")
 				return
@@ -112,12 +120,21 @@ func TestGetPolicies(t *testing.T) {
 		objectClass        ad.ObjectClass
 		userKrb5CCBaseName string
 
-		backend     mock.Backend
-		versionID   string
-		gpoListArgs []string
-
-		turnKrb5CCCacheRO bool
-		existing          map[string]string
+		backend         mock.Backend
+		versionID       string
+		arch            string
+		machineHostname string
+		gpoListArgs     []string
+		maxPolSize      int64
+		ignoredGPOs     []string
+		localUsers      map[string]string
+		rolloutDelay    time.Duration
+
+		turnKrb5CCCacheRO          bool
+		existing                   map[string]string
+		cachedGPOs                 []policies.GPO
+		machineCredentialsFallback bool
+		dryRun                     bool
 
 		want             policies.Policies
 		wantAssetsEquals string
@@ -127,6 +144,11 @@ func TestGetPolicies(t *testing.T) {
 			gpoListArgs: []string{"gpoonly.com", "bob:standard"},
 			want:        policies.Policies{GPOs: []policies.GPO{standardUserGPO("standard")}},
 		},
+		"Dry run doesn't record the group membership": {
+			gpoListArgs: []string{"gpoonly.com", "bob:standard"},
+			dryRun:      true,
+			want:        policies.Policies{GPOs: []policies.GPO{standardUserGPO("standard")}},
+		},
 		"Standard policy, computer object": {
 			objectName:  hostname,
 			objectClass: ad.ComputerObject,
@@ -154,6 +176,32 @@ func TestGetPolicies(t *testing.T) {
 			want:        policies.Policies{GPOs: []policies.GPO{{ID: "machine-only", Name: "machine-only-name", Rules: make(map[string][]entry.Entry)}}},
 		},
 
+		// Rollout cases
+		"Held back policy keeps its previous rules": {
+			gpoListArgs:  []string{"gpoonly.com", "bob:standard"},
+			rolloutDelay: time.Hour,
+			cachedGPOs:   []policies.GPO{{ID: "standard", Name: "standard-name", Rules: map[string][]entry.Entry{"dconf": {{Key: "A", Value: "previousA"}}}}},
+			want: policies.Policies{GPOs: []policies.GPO{
+				{ID: "standard", Name: "standard-name", Rules: map[string][]entry.Entry{"dconf": {{Key: "A", Value: "previousA"}}}}},
+			},
+		},
+		"Held back policy without previous rules is not applied": {
+			gpoListArgs:  []string{"gpoonly.com", "bob:standard"},
+			rolloutDelay: time.Hour,
+			want:         policies.Policies{},
+		},
+		"Downloaded policy is applied while another one is held back": {
+			gpoListArgs:  []string{"gpoonly.com", "bob:standard::bob:one-value"},
+			rolloutDelay: time.Hour,
+			existing:     map[string]string{"Policies/one-value": "testdata/AD/SYSVOL/gpoonly.com/Policies/one-value"},
+			want: policies.Policies{GPOs: []policies.GPO{
+				{ID: "one-value", Name: "one-value-name", Rules: map[string][]entry.Entry{
+					"dconf": {
+						{Key: "C", Value: "oneValueC"},
+					}}}},
+			},
+		},
+
 		// Assets cases
 		"Standard policy with assets, downloads assets": {
 			objectName:  hostname,
@@ -259,6 +307,140 @@ func TestGetPolicies(t *testing.T) {
 			},
 		},
 
+		// Multi architectures cases
+		"Matching release and architecture variant wins": {
+			versionID:   "21.04",
+			arch:        "arm64",
+			gpoListArgs: []string{"gpoonly.com", "bob:multiple-architectures"},
+			want: policies.Policies{GPOs: []policies.GPO{{ID: "multiple-architectures", Name: "multiple-architectures-name", Rules: map[string][]entry.Entry{
+				"dconf": {
+					{Key: "A", Value: "21.04Arm64Value"},
+					{Key: "B", Value: "B21.04Arm64Value"},
+				}}}},
+			},
+		},
+		"Enabled release override wins over architecture variant": {
+			versionID:   "21.04",
+			arch:        "amd64",
+			gpoListArgs: []string{"gpoonly.com", "bob:multiple-architectures"},
+			want: policies.Policies{GPOs: []policies.GPO{{ID: "multiple-architectures", Name: "multiple-architectures-name", Rules: map[string][]entry.Entry{
+				"dconf": {
+					{Key: "A", Value: "21.04Value"},
+					{Key: "B", Value: "BAllValue"},
+				}}}},
+			},
+		},
+		"Architecture variant for all releases": {
+			versionID:   "20.04",
+			arch:        "arm64",
+			gpoListArgs: []string{"gpoonly.com", "bob:multiple-architectures"},
+			want: policies.Policies{GPOs: []policies.GPO{{ID: "multiple-architectures", Name: "multiple-architectures-name", Rules: map[string][]entry.Entry{
+				"dconf": {
+					{Key: "A", Value: "AllArm64Value"},
+					{Key: "B", Value: "BAllArm64Value"},
+				}}}},
+			},
+		},
+		"Release and architecture variant does not need an enabled override": {
+			versionID:   "22.04",
+			arch:        "arm64",
+			gpoListArgs: []string{"gpoonly.com", "bob:multiple-architectures"},
+			want: policies.Policies{GPOs: []policies.GPO{{ID: "multiple-architectures", Name: "multiple-architectures-name", Rules: map[string][]entry.Entry{
+				"dconf": {
+					{Key: "A", Value: "22.04Arm64Value"},
+					{Key: "B", Value: "BAllArm64Value"},
+				}}}},
+			},
+		},
+		"No variant for this architecture, takes default value": {
+			versionID:   "20.04",
+			arch:        "riscv64",
+			gpoListArgs: []string{"gpoonly.com", "bob:multiple-architectures"},
+			want: policies.Policies{GPOs: []policies.GPO{{ID: "multiple-architectures", Name: "multiple-architectures-name", Rules: map[string][]entry.Entry{
+				"dconf": {
+					{Key: "A", Value: "AllValue"},
+					{Key: "B", Value: "BAllValue"},
+				}}}},
+			},
+		},
+
+		// Hostnames conditions cases
+		"Keys with hostnames condition matching the machine are applied": {
+			machineHostname: "lab-01",
+			gpoListArgs:     []string{"gpoonly.com", "bob:hostname-conditions"},
+			want: policies.Policies{GPOs: []policies.GPO{{ID: "hostname-conditions", Name: "hostname-conditions-name", Rules: map[string][]entry.Entry{
+				"dconf": {
+					{Key: "A", Value: "AValue"},
+					{Key: "B", Value: "BValue"},
+					{Key: "C", Value: "CValue"},
+				}}}},
+			},
+		},
+		"Keys with hostnames condition not including the machine are skipped": {
+			machineHostname: "OFFICE-01",
+			gpoListArgs:     []string{"gpoonly.com", "bob:hostname-conditions"},
+			want: policies.Policies{GPOs: []policies.GPO{{ID: "hostname-conditions", Name: "hostname-conditions-name", Rules: map[string][]entry.Entry{
+				"dconf": {
+					{Key: "B", Value: "BValue"},
+					{Key: "C", Value: "CValue"},
+				}}}},
+			},
+		},
+		"Keys with hostnames condition excluding the machine are skipped": {
+			machineHostname: "SRV-01",
+			gpoListArgs:     []string{"gpoonly.com", "bob:hostname-conditions"},
+			want: policies.Policies{GPOs: []policies.GPO{{ID: "hostname-conditions", Name: "hostname-conditions-name", Rules: map[string][]entry.Entry{
+				"dconf": {
+					{Key: "C", Value: "CValue"},
+				}}}},
+			},
+		},
+
+		// Subnets conditions cases
+		"Keys with subnets condition are kept with their condition": {
+			gpoListArgs: []string{"gpoonly.com", "bob:subnet-conditions"},
+			want: policies.Policies{GPOs: []policies.GPO{{ID: "subnet-conditions", Name: "subnet-conditions-name", Rules: map[string][]entry.Entry{
+				"dconf": {
+					{Key: "A", Value: "AValue", Subnets: "10.1.0.0/16, 2001:db8::/32"},
+					{Key: "B", Value: "BValue", Subnets: "!10.1.0.0/16"},
+					{Key: "C", Value: "CValue"},
+				}}}},
+			},
+		},
+
+		// Expires conditions cases
+		"Keys with expires condition are kept with their condition": {
+			gpoListArgs: []string{"gpoonly.com", "bob:expiry-conditions"},
+			want: policies.Policies{GPOs: []policies.GPO{{ID: "expiry-conditions", Name: "expiry-conditions-name", Rules: map[string][]entry.Entry{
+				"dconf": {
+					{Key: "A", Value: "AValue", Expires: "2024-06-20T12:00:00+02:00"},
+					{Key: "B", Value: "BValue", Expires: "2100-01-01 12:00"},
+					{Key: "C", Value: "CValue"},
+				}}}},
+			},
+		},
+
+		// Report-only cases
+		"Keys flagged report-only are kept as report-only": {
+			gpoListArgs: []string{"gpoonly.com", "bob:report-only-keys"},
+			want: policies.Policies{GPOs: []policies.GPO{{ID: "report-only-keys", Name: "report-only-keys-name", Rules: map[string][]entry.Entry{
+				"dconf": {
+					{Key: "A", Value: "AValue", ReportOnly: true},
+					{Key: "B", Value: "BValue"},
+					{Key: "C", Value: "CValue"},
+				}}}},
+			},
+		},
+		"GPO flagged report-only has all its keys report-only": {
+			gpoListArgs: []string{"gpoonly.com", "bob:report-only-gpo"},
+			want: policies.Policies{GPOs: []policies.GPO{{ID: "report-only-gpo", Name: "report-only-gpo-name", Rules: map[string][]entry.Entry{
+				"dconf": {
+					{Key: "A", Value: "AValue", ReportOnly: true},
+					{Key: "B", Value: "BValue", ReportOnly: true},
+				}}}},
+			},
+		},
+
 		// No override option for this release
 
 		// Multi domain cases
@@ -305,6 +487,16 @@ func TestGetPolicies(t *testing.T) {
 					}}}},
 			},
 		},
+		"Ignored GPOs are skipped": {
+			gpoListArgs: []string{"gpoonly.com", "bob:one-value::bob:standard"},
+			ignoredGPOs: []string{"{ONE-VALUE}"},
+			want:        policies.Policies{GPOs: []policies.GPO{standardUserGPO("standard")}},
+		},
+		"Ignored GPOs match without braces nor case": {
+			gpoListArgs: []string{"gpoonly.com", "bob:one-value::bob:standard"},
+			ignoredGPOs: []string{"other, one-value"},
+			want:        policies.Policies{GPOs: []policies.GPO{standardUserGPO("standard")}},
+		},
 		"Two policies, with reversed overrides": {
 			gpoListArgs: []string{"gpoonly.com", "bob:standard::bob:one-value"},
 			want: policies.Policies{GPOs: []policies.GPO{
@@ -446,6 +638,83 @@ func TestGetPolicies(t *testing.T) {
 			userKrb5CCBaseName: "-",
 			wantErr:            true,
 		},
+		"User without ticket falls back to machine credentials": {
+			gpoListArgs:                []string{"gpoonly.com", "bob:standard"},
+			userKrb5CCBaseName:         "-",
+			machineCredentialsFallback: true,
+			want:                       policies.Policies{GPOs: []policies.GPO{standardUserGPO("standard")}},
+		},
+		"Error on user without ticket nor machine ticket to fall back to": {
+			backend: mock.Backend{
+				Dom:                "gpoonly.com",
+				HostKrb5CCNamePath: "dont-exist",
+				Online:             true,
+			},
+			gpoListArgs:                []string{"gpoonly.com", "bob:standard"},
+			userKrb5CCBaseName:         "-",
+			machineCredentialsFallback: true,
+			wantErr:                    true,
+		},
+		"Mapped local user gets the policies of its account with the machine credentials": {
+			objectName:         "alice",
+			localUsers:         map[string]string{"alice": "bob@gpoonly.com"},
+			gpoListArgs:        []string{"gpoonly.com", "bob:standard::alice:one-value"},
+			userKrb5CCBaseName: "-",
+			want:               policies.Policies{GPOs: []policies.GPO{standardUserGPO("standard")}},
+		},
+		"Local user mapped by one of its groups": {
+			objectName:         "alice",
+			localUsers:         map[string]string{"%other": "other@gpoonly.com", "%students": "bob@gpoonly.com"},
+			gpoListArgs:        []string{"gpoonly.com", "bob:standard::other:one-value"},
+			userKrb5CCBaseName: "-",
+			want:               policies.Policies{GPOs: []policies.GPO{standardUserGPO("standard")}},
+		},
+		"Local user mapping takes precedence over its groups": {
+			objectName:         "alice",
+			localUsers:         map[string]string{"alice": "bob@gpoonly.com", "%students": "other@gpoonly.com"},
+			gpoListArgs:        []string{"gpoonly.com", "bob:standard::other:one-value"},
+			userKrb5CCBaseName: "-",
+			want:               policies.Policies{GPOs: []policies.GPO{standardUserGPO("standard")}},
+		},
+		"Local user mapped by its primary group": {
+			objectName:         "carol",
+			localUsers:         map[string]string{"%other": "bob@gpoonly.com", "%students": "other@gpoonly.com"},
+			gpoListArgs:        []string{"gpoonly.com", "bob:standard::other:one-value"},
+			userKrb5CCBaseName: "-",
+			want:               policies.Policies{GPOs: []policies.GPO{standardUserGPO("standard")}},
+		},
+		"Error on local user without mapping": {
+			objectName:         "carol",
+			localUsers:         map[string]string{"%students": "bob@gpoonly.com"},
+			gpoListArgs:        []string{"gpoonly.com", "bob:standard"},
+			userKrb5CCBaseName: "-",
+			wantErr:            true,
+		},
+		"Error on mapped user not in the local accounts": {
+			objectName:         "dave",
+			localUsers:         map[string]string{"dave": "bob@gpoonly.com"},
+			gpoListArgs:        []string{"gpoonly.com", "bob:standard"},
+			userKrb5CCBaseName: "-",
+			wantErr:            true,
+		},
+		"Error on mapped local user without machine ticket": {
+			objectName: "alice",
+			backend: mock.Backend{
+				Dom:                "gpoonly.com",
+				HostKrb5CCNamePath: "dont-exist",
+				Online:             true,
+			},
+			localUsers:         map[string]string{"alice": "bob@gpoonly.com"},
+			gpoListArgs:        []string{"gpoonly.com", "bob:standard"},
+			userKrb5CCBaseName: "-",
+			wantErr:            true,
+		},
+		"Error on unexisting given ticket even with machine credentials fallback": {
+			gpoListArgs:                []string{"gpoonly.com", "bob:standard"},
+			userKrb5CCBaseName:         "dont-exist",
+			machineCredentialsFallback: true,
+			wantErr:                    true,
+		},
 		"Unexisting CC original file for user": {
 			gpoListArgs:        []string{"gpoonly.com", "bob:standard"},
 			userKrb5CCBaseName: "dont-exist",
@@ -511,6 +780,23 @@ func TestGetPolicies(t *testing.T) {
 			turnKrb5CCCacheRO: true,
 			wantErr:           true,
 		},
+		"Error on policy file larger than the maximum policy size": {
+			gpoListArgs: []string{"gpoonly.com", "bob:standard"},
+			maxPolSize:  1,
+			wantErr:     true,
+		},
+		"Error on invalid hostnames condition": {
+			gpoListArgs: []string{"gpoonly.com", "bob:hostname-invalid-condition"},
+			wantErr:     true,
+		},
+		"Error on invalid subnets condition": {
+			gpoListArgs: []string{"gpoonly.com", "bob:subnet-invalid-condition"},
+			wantErr:     true,
+		},
+		"Error on invalid expires condition": {
+			gpoListArgs: []string{"gpoonly.com", "bob:expiry-invalid-condition"},
+			wantErr:     true,
+		},
 		"Unsupported type for unfiltered entry": {
 			gpoListArgs: []string{"gpoonly.com", "bob:bad-entry-type"},
 			wantErr:     true,
@@ -565,11 +851,32 @@ func TestGetPolicies(t *testing.T) {
 				}
 			}
 
+			if tc.arch == "" {
+				tc.arch = "amd64"
+			}
+
 			cachedir, rundir := t.TempDir(), t.TempDir()
-			adc, err := ad.New(context.Background(), tc.backend, hostname,
-				ad.WithCacheDir(cachedir), ad.WithRunDir(rundir), ad.WithoutKerberos(),
+			opts := []ad.Option{ad.WithCacheDir(cachedir), ad.WithRunDir(rundir), ad.WithoutKerberos(),
 				ad.WithGPOListCmd(mockGPOListCmd(t, tc.gpoListArgs...)),
-				ad.WithVersionID(tc.versionID))
+				ad.WithVersionID(tc.versionID), ad.WithArch(tc.arch)}
+			if tc.maxPolSize != 0 {
+				opts = append(opts, ad.WithMaxPolSize(tc.maxPolSize))
+			}
+			if tc.ignoredGPOs != nil {
+				opts = append(opts, ad.WithIgnoredGPOs(tc.ignoredGPOs))
+			}
+			if tc.rolloutDelay != 0 {
+				opts = append(opts, ad.WithGPORolloutDelay(tc.rolloutDelay))
+			}
+			if tc.localUsers != nil {
+				opts = append(opts, ad.WithLocalUsers(tc.localUsers),
+					ad.WithLocalAccountFiles(filepath.Join("testdata", "localaccounts", "passwd"), filepath.Join("testdata", "localaccounts", "group")))
+			}
+			machineHostname := hostname
+			if tc.machineHostname != "" {
+				machineHostname = tc.machineHostname
+			}
+			adc, err := ad.New(context.Background(), tc.backend, machineHostname, opts...)
 			require.NoError(t, err, "Setup: cannot create ad object")
 
 			if tc.turnKrb5CCCacheRO {
@@ -580,13 +887,28 @@ func TestGetPolicies(t *testing.T) {
 			for n, src := range tc.existing {
 				testutils.Copy(t, src, filepath.Join(adc.SysvolCacheDir(), n))
 			}
+			if tc.cachedGPOs != nil {
+				cached, err := policies.New(context.Background(), tc.cachedGPOs, "")
+				require.NoError(t, err, "Setup: can't create cached policies")
+				require.NoError(t, cached.Save(policies.ObjectCachePath(cachedir, policies.PoliciesCacheBaseName, tc.objectName)), "Setup: can't save cached policies")
+			}
 
-			entries, err := adc.GetPolicies(context.Background(), tc.objectName, tc.objectClass, krb5CCName)
+			var getOpts []ad.GetPoliciesOption
+			if tc.machineCredentialsFallback {
+				getOpts = append(getOpts, ad.WithMachineCredentialsFallback())
+			}
+			if tc.dryRun {
+				getOpts = append(getOpts, ad.WithDryRun())
+			}
+			entries, err := adc.GetPolicies(context.Background(), tc.objectName, tc.objectClass, krb5CCName, getOpts...)
 			if tc.wantErr {
 				require.Error(t, err, "GetPolicies should have errored out")
 				return
 			}
 			require.NoError(t, err, "GetPolicies should return no error")
+			if tc.dryRun {
+				require.NoFileExists(t, policies.ObjectCachePath(cachedir, "groups", tc.objectName), "GetPolicies should not record the group membership in dry run")
+			}
 
 			// Compare GPOs
 			require.Equal(t, tc.want.GPOs, entries.GPOs, "GetPolicies returns expected GPO entries in correct order")
@@ -616,9 +938,14 @@ func TestGetPoliciesOffline(t *testing.T) {
 		domainToCache string
 		backend       mock.Backend
 		gpoListArgs   []string
-
-		wantAssets bool
-		wantErr    bool
+		cacheAge      time.Duration
+		offlinePolicy ad.OfflinePolicy
+		backoff       ad.Backoff
+		backingOff    bool
+
+		wantAssets          bool
+		wantBackoffFailures int
+		wantErr             bool
 	}{
 		"Offline, get from cache, gpo only": {
 			domainToCache: "gpoonly.com",
@@ -655,14 +982,62 @@ func TestGetPoliciesOffline(t *testing.T) {
 			wantAssets:  true,
 		},
 
-		"Error on SSSD reports online, but we are actually offline when fetching gpo list, even with a cache": {
+		"SSSD reports online, but we are actually offline when fetching gpo list, get from cache": {
 			domainToCache: "assetsandgpo.com",
 			backend: mock.Backend{
 				Dom:    "assetsandgpo.com",
 				Online: true,
 			},
 			gpoListArgs: []string{"-Exit2-"},
-			wantErr:     true,
+			wantAssets:  true,
+		},
+		"Connection failure starts backing off, get from cache": {
+			domainToCache: "assetsandgpo.com",
+			backend: mock.Backend{
+				Dom:    "assetsandgpo.com",
+				Online: true,
+			},
+			gpoListArgs:         []string{"-Exit2-"},
+			backoff:             ad.Backoff{Initial: 60},
+			wantAssets:          true,
+			wantBackoffFailures: 1,
+		},
+		"Backing off, ensure we fetch from cache and not fetch GPO list": {
+			domainToCache: "gpoonly.com",
+			backend: mock.Backend{
+				Dom:    "gpoonly.com",
+				Online: true,
+			},
+			gpoListArgs:         []string{"-Exit1-"}, // this should not be used
+			backoff:             ad.Backoff{Initial: 60},
+			backingOff:          true,
+			wantBackoffFailures: 1,
+		},
+		"No active server, get from cache": {
+			domainToCache: "gpoonly.com",
+			backend: mock.Backend{
+				Dom:          "gpoonly.com",
+				Online:       true,
+				ErrServerURL: backends.ErrNoActiveServer,
+			},
+		},
+		"Cache younger than max cache age is applied": {
+			domainToCache: "gpoonly.com",
+			backend: mock.Backend{
+				Dom:    "gpoonly.com",
+				Online: false,
+			},
+			cacheAge:      time.Hour,
+			offlinePolicy: ad.OfflinePolicy{MaxCacheAge: 2 * 3600, RefuseStale: true},
+		},
+		"Stale cache is applied with a warning": {
+			domainToCache: "gpoonly.com",
+			backend: mock.Backend{
+				Dom:    "gpoonly.com",
+				Online: false,
+			},
+			cacheAge:      3 * time.Hour,
+			offlinePolicy: ad.OfflinePolicy{MaxCacheAge: 2 * 3600},
 		},
 		"Error offline with no cache": {
 			domainToCache: "",
@@ -672,6 +1047,37 @@ func TestGetPoliciesOffline(t *testing.T) {
 			},
 			wantErr: true,
 		},
+		"Error when refusing stale cache": {
+			domainToCache: "gpoonly.com",
+			backend: mock.Backend{
+				Dom:    "gpoonly.com",
+				Online: false,
+			},
+			cacheAge:      3 * time.Hour,
+			offlinePolicy: ad.OfflinePolicy{MaxCacheAge: 2 * 3600, RefuseStale: true},
+			wantErr:       true,
+		},
+		"Error when backing off without cache and the domain controller is still unreachable": {
+			domainToCache: "",
+			backend: mock.Backend{
+				Dom:    "gpoonly.com",
+				Online: true,
+			},
+			gpoListArgs:         []string{"-Exit2-"},
+			backoff:             ad.Backoff{Initial: 60},
+			backingOff:          true,
+			wantBackoffFailures: 1,
+			wantErr:             true,
+		},
+		"Error when fetching gpo list fails for another reason than being offline, even with a cache": {
+			domainToCache: "gpoonly.com",
+			backend: mock.Backend{
+				Dom:    "gpoonly.com",
+				Online: true,
+			},
+			gpoListArgs: []string{"-Exit1-"},
+			wantErr:     true,
+		},
 	}
 	for name, tc := range tests {
 		tc := tc
@@ -685,9 +1091,17 @@ func TestGetPoliciesOffline(t *testing.T) {
 			cachedir, rundir := t.TempDir(), t.TempDir()
 			adc, err := ad.New(context.Background(), tc.backend, hostname,
 				ad.WithCacheDir(cachedir), ad.WithRunDir(rundir), ad.WithoutKerberos(),
-				ad.WithGPOListCmd(mockGPOListCmd(t, tc.gpoListArgs...)))
+				ad.WithGPOListCmd(mockGPOListCmd(t, tc.gpoListArgs...)),
+				ad.WithOfflinePolicy(tc.offlinePolicy),
+				ad.WithBackoff(tc.backoff))
 			require.NoError(t, err, "Setup: cannot create ad object")
 
+			if tc.backingOff {
+				state := fmt.Sprintf(`{"failures":1,"until":%q}`, time.Now().Add(time.Hour).Format(time.RFC3339))
+				err = os.WriteFile(filepath.Join(rundir, "dcbackoff"), []byte(state), 0600)
+				require.NoError(t, err, "Setup: cannot write backoff state")
+			}
+
 			objectName := fmt.Sprintf("useroffline@%s", strings.ToUpper(tc.backend.Dom))
 			objectClass := ad.UserObject
 			krb5CCName := setKrb5CC(t, objectName)
@@ -713,11 +1127,26 @@ func TestGetPoliciesOffline(t *testing.T) {
 				require.NoError(t, err, "Setup: caching with getPolicies failed")
 
 				// Save it and copy to finale destination
-				err = initialPolicies.Save(filepath.Join(adc.PoliciesCacheDir(), objectName))
+				err = initialPolicies.Save(policies.ObjectCachePath(adc.CacheDir(), policies.PoliciesCacheBaseName, objectName))
 				require.NoError(t, err, "Setup: cannot create policy cache file for finale user")
+
+				if tc.cacheAge != 0 {
+					lastUpdate := time.Now().Add(-tc.cacheAge)
+					err = os.Chtimes(policies.ObjectCachePath(adc.CacheDir(), policies.PoliciesCacheBaseName, objectName), lastUpdate, lastUpdate)
+					require.NoError(t, err, "Setup: cannot set policy cache age")
+				}
 			}
 
 			entries, err := adc.GetPolicies(context.Background(), objectName, objectClass, krb5CCName)
+
+			if tc.wantBackoffFailures == 0 {
+				require.NoFileExists(t, filepath.Join(rundir, "dcbackoff"), "GetPolicies should not back off")
+			} else {
+				d, err := os.ReadFile(filepath.Join(rundir, "dcbackoff"))
+				require.NoError(t, err, "GetPolicies should have recorded the connection failure")
+				require.Contains(t, string(d), fmt.Sprintf(`"failures":%d`, tc.wantBackoffFailures), "GetPolicies should have recorded the consecutive connection failures")
+			}
+
 			if tc.wantErr {
 				require.NotNil(t, err, "GetPolicies should have errored out")
 				return
@@ -1058,6 +1487,7 @@ func TestListUsers(t *testing.T) {
 		policyCachesToCreate []string
 		noCCacheDir          bool
 		noPoliciesCacheDir   bool
+		localUsers           map[string]string
 
 		active bool
 
@@ -1118,6 +1548,18 @@ func TestListUsers(t *testing.T) {
 			policyCachesToCreate: []string{"myMachine"},
 			want:                 nil,
 		},
+		"Mapped local users with cached policies are listed": {
+			active:               true,
+			ccCachesToCreate:     []string{"bob@GPOONLY.COM"},
+			policyCachesToCreate: []string{"alice", "carol"},
+			localUsers:           map[string]string{"alice": "bob@gpoonly.com"},
+			want:                 []string{"bob@GPOONLY.COM", "alice"},
+		},
+		"Mapped local users with cached policies are listed, from policy cache": {
+			policyCachesToCreate: []string{"bob@GPOONLY.COM", "alice", "carol"},
+			localUsers:           map[string]string{"alice": "bob@gpoonly.com"},
+			want:                 []string{"bob@GPOONLY.COM", "alice"},
+		},
 		"Ticket cache ignored if policy cache is requested": {
 			policyCachesToCreate: []string{"bob@GPOONLY.COM"},
 			ccCachesToCreate:     []string{"sponge@OTHERDOMAIN.BIZ", "myMachine"},
@@ -1161,12 +1603,13 @@ func TestListUsers(t *testing.T) {
 			}
 
 			adc, err := ad.New(context.Background(), mock.Backend{Dom: "gpoonly.com", ServURL: "ldap://myserver.gpoonly.com"}, hostname,
-				ad.WithCacheDir(cachedir), ad.WithRunDir(rundir))
+				ad.WithCacheDir(cachedir), ad.WithRunDir(rundir), ad.WithLocalUsers(tc.localUsers),
+				ad.WithLocalAccountFiles(filepath.Join("testdata", "localaccounts", "passwd"), filepath.Join("testdata", "localaccounts", "group")))
 			require.NoError(t, err, "Setup: New should return no error")
 
 			// populate cachedir with policies
 			for _, dir := range tc.policyCachesToCreate {
-				require.NoError(t, os.Mkdir(filepath.Join(adc.PoliciesCacheDir(), dir), 0700), "Setup: can't create policy cache dir")
+				require.NoError(t, os.MkdirAll(policies.ObjectCachePath(cachedir, policies.PoliciesCacheBaseName, dir), 0700), "Setup: can't create policy cache dir")
 			}
 
 			if tc.noCCacheDir {
@@ -1240,6 +1683,7 @@ func TestNormalizeTargetName(t *testing.T) {
 		target              string
 		objectClass         ad.ObjectClass
 		defaultDomainSuffix string
+		localUsers          map[string]string
 
 		want    string
 		wantErr bool
@@ -1249,6 +1693,24 @@ func TestNormalizeTargetName(t *testing.T) {
 		`One valid user with domain\user`:         {target: `gpoonly.com\user`, want: "user@gpoonly.com"},
 		"One user without explicit domain suffix": {target: "user", defaultDomainSuffix: "gpoonly.com", want: "user@gpoonly.com"},
 
+		// Local user cases
+		"Mapped local user keeps its name": {target: "Alice", defaultDomainSuffix: "gpoonly.com",
+			localUsers: map[string]string{"alice": "user@gpoonly.com"}, want: "alice"},
+		"Mapped local user without default domain suffix": {target: "alice",
+			localUsers: map[string]string{"alice": "user@gpoonly.com"}, want: "alice"},
+		"Local user mapped by one of its groups": {target: "alice",
+			localUsers: map[string]string{"%students": "user@gpoonly.com"}, want: "alice"},
+		"Local user mapped by its primary group": {target: "carol",
+			localUsers: map[string]string{"%other": "user@gpoonly.com"}, want: "carol"},
+		"Unmapped user gets the default domain suffix": {target: "bob", defaultDomainSuffix: "gpoonly.com",
+			localUsers: map[string]string{"alice": "user@gpoonly.com"}, want: "bob@gpoonly.com"},
+		"Unmapped local user gets the default domain suffix": {target: "carol", defaultDomainSuffix: "gpoonly.com",
+			localUsers: map[string]string{"%students": "user@gpoonly.com"}, want: "carol@gpoonly.com"},
+		"Mapped user not in the local accounts gets the default domain suffix": {target: "dave", defaultDomainSuffix: "gpoonly.com",
+			localUsers: map[string]string{"dave": "user@gpoonly.com"}, want: "dave@gpoonly.com"},
+		"User with domain is never a local user": {target: `gpoonly.com\alice`,
+			localUsers: map[string]string{"alice": "user@gpoonly.com"}, want: "alice@gpoonly.com"},
+
 		// User match computer names
 		"User name matching computer, setting as user": {target: hostname, objectClass: ad.UserObject, defaultDomainSuffix: "gpoonly.com",
 			want: hostname + "@gpoonly.com"},
@@ -1263,6 +1725,8 @@ func TestNormalizeTargetName(t *testing.T) {
 		// Error cases
 		`Error on multiple \ in name`:                        {target: `gpoonly.com\user\something`, wantErr: true},
 		`Error on no default domain suffix and no fqdn user`: {target: `user`, wantErr: true},
+		`Error on mapped user not in the local accounts and no default domain suffix`: {target: `dave`,
+			localUsers: map[string]string{"dave": "user@gpoonly.com"}, wantErr: true},
 	}
 
 	for name, tc := range tests {
@@ -1273,7 +1737,8 @@ func TestNormalizeTargetName(t *testing.T) {
 			adc, err := ad.New(context.Background(),
 				mock.Backend{Dom: tc.defaultDomainSuffix, ServURL: "ldap://myserver.gpoonly.com"}, // Dom is the default domain suffix in the mock
 				hostname,
-				ad.WithCacheDir(t.TempDir()), ad.WithRunDir(t.TempDir()))
+				ad.WithCacheDir(t.TempDir()), ad.WithRunDir(t.TempDir()), ad.WithLocalUsers(tc.localUsers),
+				ad.WithLocalAccountFiles(filepath.Join("testdata", "localaccounts", "passwd"), filepath.Join("testdata", "localaccounts", "group")))
 			require.NoError(t, err, "Setup: New should return no error")
 
 			got, err := adc.NormalizeTargetName(context.Background(), tc.target, tc.objectClass)
@@ -1288,6 +1753,200 @@ func TestNormalizeTargetName(t *testing.T) {
 	}
 }
 
+func TestGroupMembershipChanged(t *testing.T) {
+	t.Parallel()
+
+	hostname, err := os.Hostname()
+	require.NoError(t, err, "Setup: failed to get hostname")
+
+	tests := map[string]struct {
+		objectName         string
+		objectClass        ad.ObjectClass
+		recordedMembership string
+		offline            bool
+		gpoListArgs        []string
+		noTicket           bool
+
+		want    bool
+		wantErr bool
+	}{
+		"Same membership as recorded is not a change":           {recordedMembership: "AU\nbob-group\n"},
+		"Same membership as recorded for machine":               {objectName: hostname, objectClass: ad.ComputerObject, recordedMembership: fmt.Sprintf("AU\n%s-group\n", hostname)},
+		"Different membership than recorded is a change":        {recordedMembership: "AU\nbob-group\nother-group\n", want: true},
+		"No recorded membership is a change":                    {want: true},
+		"Offline machine can't detect any change":               {offline: true},
+		"GetPolicies records group membership for later checks": {recordedMembership: "GetPolicies"},
+
+		// Error cases
+		"Error on no ticket to track":     {noTicket: true, wantErr: true},
+		"Error on group membership query": {gpoListArgs: []string{"-Exit2-"}, wantErr: true},
+	}
+	for name, tc := range tests {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			if tc.objectName == "" {
+				tc.objectName = "bob@example.com"
+			}
+			if tc.objectClass == "" {
+				tc.objectClass = ad.UserObject
+			}
+			if tc.gpoListArgs == nil {
+				tc.gpoListArgs = []string{"example.com", "bob:standard"}
+			}
+
+			backend := mock.Backend{
+				Dom:                "example.com",
+				ServURL:            "ldap://myserver.example.com",
+				Online:             !tc.offline,
+				HostKrb5CCNamePath: filepath.Join(t.TempDir(), "host_ccache"),
+			}
+			testutils.CreatePath(t, backend.HostKrb5CCNamePath)
+
+			cachedir, rundir := t.TempDir(), t.TempDir()
+			adc, err := ad.New(context.Background(), backend, hostname,
+				ad.WithCacheDir(cachedir), ad.WithRunDir(rundir), ad.WithoutKerberos(),
+				ad.WithGPOListCmd(mockGPOListCmd(t, tc.gpoListArgs...)))
+			require.NoError(t, err, "Setup: cannot create ad object")
+
+			// Track the user ticket, as done on login
+			if tc.objectClass == ad.UserObject && !tc.noTicket {
+				err := os.Symlink(setKrb5CC(t, "bob"), filepath.Join(adc.Krb5CacheDir(), "tracking", tc.objectName))
+				require.NoError(t, err, "Setup: could not track user ticket")
+			}
+
+			switch tc.recordedMembership {
+			case "":
+			case "GetPolicies":
+				// GPOs are not downloadable: only the group membership is expected to be recorded
+				_, _ = adc.GetPolicies(context.Background(), tc.objectName, tc.objectClass, "")
+			default:
+				p := policies.ObjectCachePath(cachedir, "groups", tc.objectName)
+				require.NoError(t, os.MkdirAll(filepath.Dir(p), 0700), "Setup: could not create group membership directory")
+				err := os.WriteFile(p, []byte(tc.recordedMembership), 0600)
+				require.NoError(t, err, "Setup: could not record group membership")
+			}
+
+			got, err := adc.GroupMembershipChanged(context.Background(), tc.objectName, tc.objectClass)
+			if tc.wantErr {
+				require.Error(t, err, "GroupMembershipChanged should have errored out")
+				return
+			}
+			require.NoError(t, err, "GroupMembershipChanged should return no error")
+			require.Equal(t, tc.want, got, "GroupMembershipChanged returns expected change status")
+		})
+	}
+}
+
+func TestSetComputerAttribute(t *testing.T) {
+	t.Parallel()
+
+	hostname, err := os.Hostname()
+	require.NoError(t, err, "Setup: failed to get hostname")
+
+	tests := map[string]struct {
+		offline     bool
+		gpoListArgs []string
+
+		wantErr bool
+	}{
+		"Set attribute of the computer": {},
+
+		// Error cases
+		"Error on offline machine":    {offline: true, wantErr: true},
+		"Error on attribute not set":  {gpoListArgs: []string{"-Exit1-"}, wantErr: true},
+		"Error on unreachable server": {gpoListArgs: []string{"-Exit2-"}, wantErr: true},
+	}
+	for name, tc := range tests {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			output := filepath.Join(t.TempDir(), "attributes")
+			if tc.gpoListArgs == nil {
+				tc.gpoListArgs = []string{"example.com", output}
+			}
+
+			backend := mock.Backend{
+				Dom:                "example.com",
+				ServURL:            "ldap://myserver.example.com",
+				Online:             !tc.offline,
+				HostKrb5CCNamePath: filepath.Join(t.TempDir(), "host_ccache"),
+			}
+			testutils.CreatePath(t, backend.HostKrb5CCNamePath)
+
+			adc, err := ad.New(context.Background(), backend, hostname,
+				ad.WithCacheDir(t.TempDir()), ad.WithRunDir(t.TempDir()), ad.WithoutKerberos(),
+				ad.WithGPOListCmd(mockGPOListCmd(t, tc.gpoListArgs...)))
+			require.NoError(t, err, "Setup: cannot create ad object")
+
+			err = adc.SetComputerAttribute(context.Background(), "ms-Mcs-AdmPwd", "generated-secret")
+			if tc.wantErr {
+				require.Error(t, err, "SetComputerAttribute should have errored out")
+				return
+			}
+			require.NoError(t, err, "SetComputerAttribute should return no error")
+
+			got, err := os.ReadFile(output)
+			require.NoError(t, err, "Attribute should have been written")
+			require.Equal(t, fmt.Sprintf("%s: ms-Mcs-AdmPwd=generated-secret\n", hostname), string(got), "Attribute should be set to the value passed on stdin")
+		})
+	}
+}
+
+func TestComputerAttribute(t *testing.T) {
+	t.Parallel()
+
+	hostname, err := os.Hostname()
+	require.NoError(t, err, "Setup: failed to get hostname")
+
+	tests := map[string]struct {
+		offline     bool
+		gpoListArgs []string
+
+		wantErr bool
+	}{
+		"Get attribute of the computer": {},
+
+		// Error cases
+		"Error on offline machine":        {offline: true, wantErr: true},
+		"Error on attribute not readable": {gpoListArgs: []string{"-Exit1-"}, wantErr: true},
+		"Error on unreachable server":     {gpoListArgs: []string{"-Exit2-"}, wantErr: true},
+	}
+	for name, tc := range tests {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			if tc.gpoListArgs == nil {
+				tc.gpoListArgs = []string{"example.com", ""}
+			}
+
+			backend := mock.Backend{
+				Dom:                "example.com",
+				ServURL:            "ldap://myserver.example.com",
+				Online:             !tc.offline,
+				HostKrb5CCNamePath: filepath.Join(t.TempDir(), "host_ccache"),
+			}
+			testutils.CreatePath(t, backend.HostKrb5CCNamePath)
+
+			adc, err := ad.New(context.Background(), backend, hostname,
+				ad.WithCacheDir(t.TempDir()), ad.WithRunDir(t.TempDir()), ad.WithoutKerberos(),
+				ad.WithGPOListCmd(mockGPOListCmd(t, tc.gpoListArgs...)))
+			require.NoError(t, err, "Setup: cannot create ad object")
+
+			got, err := adc.ComputerAttribute(context.Background(), "ms-Mcs-AdmPwdExpirationTime")
+			if tc.wantErr {
+				require.Error(t, err, "ComputerAttribute should have errored out")
+				return
+			}
+			require.NoError(t, err, "ComputerAttribute should return no error")
+			require.Equal(t, fmt.Sprintf("ms-Mcs-AdmPwdExpirationTime of %s", hostname), got, "ComputerAttribute should return the value printed by the script")
+		})
+	}
+}
+
 func TestMockGPOList(_ *testing.T) {
 	if os.Getenv("GO_WANT_HELPER_PROCESS") != "1" {
 		return
@@ -1319,6 +1978,10 @@ func TestMockGPOList(_ *testing.T) {
 		fmt.Fprint(os.Stderr, "Error during gpo list requested with exit 2")
 		os.Exit(2)
 	}
+	if args[0] == "-Exit1-" {
+		fmt.Fprint(os.Stderr, "Error during gpo list requested with exit 1")
+		os.Exit(1)
+	}
 
 	// Get Domain
 	domain := args[0]
@@ -1327,6 +1990,36 @@ func TestMockGPOList(_ *testing.T) {
 	objectName := args[len(args)-1]
 	objectName = strings.Split(objectName, "@")[0]
 
+	// Group membership of the object, used to filter its GPOs
+	groups := fmt.Sprintf("AU\n%s-group\n", objectName)
+	for i, arg := range args {
+		switch arg {
+		case "--set-attribute":
+			// Arg 1 is the file where the attribute and the value read on stdin are written.
+			value, err := io.ReadAll(os.Stdin)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Can't read attribute value: %v", err)
+				os.Exit(1)
+			}
+			if err := os.WriteFile(args[1], []byte(fmt.Sprintf("%s: %s=%s\n", objectName, args[i+1], value)), 0600); err != nil {
+				fmt.Fprintf(os.Stderr, "Can't write attribute: %v", err)
+				os.Exit(1)
+			}
+			return
+		case "--get-attribute":
+			fmt.Fprintf(os.Stdout, "%s of %s", args[i+1], objectName)
+			return
+		case "--groups":
+			fmt.Fprint(os.Stdout, groups)
+			return
+		case "--groups-output":
+			if err := os.WriteFile(args[i+1], []byte(groups), 0600); err != nil {
+				fmt.Fprintf(os.Stderr, "Can't write group membership: %v", err)
+				os.Exit(1)
+			}
+		}
+	}
+
 	var gpos []string
 
 	// Arg 0 is the list of GPOs to return, in the form: "user1:GPO1::user2:GPO2::user1:GPO3"
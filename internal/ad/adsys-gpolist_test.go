@@ -1,10 +1,13 @@
 package ad_test
 
 import (
+	"encoding/json"
 	"fmt"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"sort"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -30,6 +33,12 @@ func TestAdsysGPOList(t *testing.T) {
 		accountName     string
 		objectClass     string
 		krb5ccNameState string
+		listGroups      bool
+		groupsOutput    bool
+		linkCache       string
+		linkCacheTTL    int
+		setAttribute    string
+		getAttribute    string
 
 		wantErr        bool
 		wantReturnCode int
@@ -100,6 +109,62 @@ func TestAdsysGPOList(t *testing.T) {
 			accountName: "RnDUserDep8@GPOONLY.COM",
 		},
 
+		// Group membership cases
+		"List group membership only": {
+			accountName: "UserAtRoot@GPOONLY.COM",
+			listGroups:  true,
+		},
+		"Write group membership to a file": {
+			accountName:  "UserAtRoot@GPOONLY.COM",
+			groupsOutput: true,
+		},
+
+		// Attribute cases
+		"Set attribute of the computer": {
+			accountName:  "hostname1",
+			objectClass:  "computer",
+			setAttribute: "ms-Mcs-AdmPwd",
+		},
+		"Get attribute of the computer": {
+			accountName:  "hostname1",
+			objectClass:  "computer",
+			getAttribute: "ms-Mcs-AdmPwdExpirationTime",
+		},
+		"Get unset attribute of the computer is empty": {
+			accountName:  "hostname1",
+			objectClass:  "computer",
+			getAttribute: "ms-Mcs-AdmPwd",
+		},
+
+		// Link cache cases
+		"Write GPO links to the link cache": {
+			accountName:  "RnDUser@GPOONLY.COM",
+			linkCacheTTL: 60,
+		},
+		"Use valid GPO links from the link cache": {
+			accountName:  "RnDUser@GPOONLY.COM",
+			linkCache:    "cached",
+			linkCacheTTL: 1 << 40,
+		},
+		"Refresh expired GPO links of the link cache": {
+			accountName:  "RnDUser@GPOONLY.COM",
+			linkCache:    "cached",
+			linkCacheTTL: 60,
+		},
+		"Corrupted link cache is ignored": {
+			accountName:  "RnDUser@GPOONLY.COM",
+			linkCache:    "corrupted",
+			linkCacheTTL: 60,
+		},
+		"Link cache is not used with a zero TTL": {
+			accountName: "RnDUser@GPOONLY.COM",
+			linkCache:   "cached",
+		},
+		"Unreadable GPOs are not written to the link cache": {
+			accountName:  "RnDUserDep4@GPOONLY.COM",
+			linkCacheTTL: 60,
+		},
+
 		"No gPOptions fallbacks to 0": {
 			accountName: "UserNogPOptions@GPOONLY.COM",
 		},
@@ -171,6 +236,21 @@ func TestAdsysGPOList(t *testing.T) {
 			wantErr:        true,
 		},
 
+		"Error on attribute not writable": {
+			accountName:    "hostname1",
+			objectClass:    "computer",
+			setAttribute:   "readOnlyAttribute",
+			wantReturnCode: 4,
+			wantErr:        true,
+		},
+		"Error on attribute not readable": {
+			accountName:    "hostname1",
+			objectClass:    "computer",
+			getAttribute:   "unreadableAttribute",
+			wantReturnCode: 4,
+			wantErr:        true,
+		},
+
 		"Error on KRB5CCNAME unset": {
 			accountName:     "UserAtRoot@GPOONLY.COM",
 			krb5ccNameState: "unset",
@@ -226,7 +306,31 @@ func TestAdsysGPOList(t *testing.T) {
 			}
 
 			// #nosec G204: we control the command line name and only change it for tests
-			cmd := exec.Command(adsysGPOListcmd, "--objectclass", tc.objectClass, tc.url, tc.accountName)
+			args := []string{"--objectclass", tc.objectClass}
+			if tc.listGroups {
+				args = append(args, "--groups")
+			}
+			groupsOutput := filepath.Join(t.TempDir(), "groups")
+			if tc.groupsOutput {
+				args = append(args, "--groups-output", groupsOutput)
+			}
+			linkCache := filepath.Join(t.TempDir(), "gpolinks")
+			withLinkCache := tc.linkCache != "" || tc.linkCacheTTL != 0
+			if tc.linkCache != "" {
+				testutils.Copy(t, filepath.Join("testdata", "TestAdsysGPOList", "link-cache", tc.linkCache), linkCache)
+			}
+			if withLinkCache {
+				args = append(args, "--link-cache", linkCache, "--link-cache-ttl", fmt.Sprint(tc.linkCacheTTL))
+			}
+			if tc.setAttribute != "" {
+				args = append(args, "--set-attribute", tc.setAttribute)
+			}
+			if tc.getAttribute != "" {
+				args = append(args, "--get-attribute", tc.getAttribute)
+			}
+			args = append(args, tc.url, tc.accountName)
+			cmd := exec.Command(adsysGPOListcmd, args...)
+			cmd.Stdin = strings.NewReader("generated-secret")
 			got, err := cmd.CombinedOutput()
 			if tc.wantErr {
 				require.Error(t, err, "adsys-gpostlist should have failed but didn’t")
@@ -235,8 +339,38 @@ func TestAdsysGPOList(t *testing.T) {
 			require.NoErrorf(t, err, "adsys-gpostlist should exit successfully: %v", string(got))
 			assert.Equal(t, tc.wantReturnCode, cmd.ProcessState.ExitCode(), "adsys-gpostlist returns expected exit code")
 
+			if tc.groupsOutput {
+				groups, err := os.ReadFile(groupsOutput)
+				require.NoError(t, err, "adsys-gpolist should have written group membership")
+				got = append(got, groups...)
+			}
+
+			if withLinkCache {
+				got = append(got, []byte(linkCacheKeys(t, linkCache))...)
+			}
+
 			want := testutils.LoadWithUpdateFromGolden(t, string(got))
 			require.Equal(t, want, string(got), "adsys-gpolist expected output")
 		})
 	}
 }
+
+// linkCacheKeys returns the sorted list of entries in the link cache, without their timestamp.
+func linkCacheKeys(t *testing.T, path string) string {
+	t.Helper()
+
+	d, err := os.ReadFile(path)
+	require.NoError(t, err, "Setup: can't read link cache")
+
+	var entries map[string]json.RawMessage
+	if err := json.Unmarshal(d, &entries); err != nil {
+		return "Link cache is not valid json\n"
+	}
+	keys := make([]string, 0, len(entries))
+	for k := range entries {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	return fmt.Sprintf("Link cache entries:\n%s\n", strings.Join(keys, "\n"))
+}
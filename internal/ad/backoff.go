@@ -0,0 +1,135 @@
+package ad
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"math/rand"
+	"os"
+	"time"
+
+	log "github.com/ubuntu/adsys/internal/grpc/logstreamer"
+	"github.com/ubuntu/adsys/internal/i18n"
+	"github.com/ubuntu/decorate"
+)
+
+// Backoff is how contacting the domain controller is throttled after connection failures, so that a fleet of
+// clients doesn't overload it with retries while it recovers.
+type Backoff struct {
+	// Initial is the time in seconds the domain controller is not contacted after a first connection failure.
+	// It doubles on each consecutive failure. 0 disables the backoff.
+	Initial int `mapstructure:"initial"`
+	// Max is the maximum time in seconds the domain controller is not contacted after consecutive failures.
+	Max int `mapstructure:"max"`
+}
+
+// backoffState records the consecutive connection failures to the domain controller.
+type backoffState struct {
+	Failures int       `json:"failures"`
+	Until    time.Time `json:"until"`
+}
+
+// backingOff returns the recorded backoff state if the domain controller should not be contacted yet.
+func (ad *AD) backingOff(ctx context.Context) (state backoffState, ok bool) {
+	if ad.backoff.Initial == 0 {
+		return backoffState{}, false
+	}
+
+	ad.backoffMu.Lock()
+	defer ad.backoffMu.Unlock()
+
+	state = ad.loadBackoffState(ctx)
+	return state, time.Now().Before(state.Until)
+}
+
+// recordConnectionFailure extends the time the domain controller is not contacted after a new consecutive
+// connection failure.
+// The delay doubles on each failure, and is randomized between its half and its full value so that the clients
+// failing at the same time don't retry all at once.
+// Failures while already backing off, like the ones of objects refreshed at the same time or without cached
+// policies, don't extend the delay: only one failure is counted per retry.
+func (ad *AD) recordConnectionFailure(ctx context.Context) {
+	if ad.backoff.Initial == 0 {
+		return
+	}
+
+	ad.backoffMu.Lock()
+	defer ad.backoffMu.Unlock()
+
+	state := ad.loadBackoffState(ctx)
+	if time.Now().Before(state.Until) {
+		return
+	}
+	state.Failures++
+	delay := backoffDelay(ad.backoff, state.Failures)
+	// #nosec G404 - the jitter only spreads retries across the fleet.
+	delay = delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+	state.Until = time.Now().Add(delay)
+
+	log.Warningf(ctx, i18n.G("Can't connect to the domain controller (%d consecutive failures): not contacting it before %s"),
+		state.Failures, state.Until.Format(time.RFC3339))
+	if err := state.save(ad.backoffStatePath); err != nil {
+		log.Warning(ctx, err)
+	}
+}
+
+// resetBackoff forgets the connection failures to the domain controller, once it was reached.
+func (ad *AD) resetBackoff(ctx context.Context) {
+	if ad.backoff.Initial == 0 {
+		return
+	}
+
+	ad.backoffMu.Lock()
+	defer ad.backoffMu.Unlock()
+
+	if err := os.Remove(ad.backoffStatePath); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		log.Warningf(ctx, i18n.G("Can't reset domain controller backoff: %v"), err)
+	}
+}
+
+// backoffDelay returns the maximum time the domain controller is not contacted after failures consecutive
+// connection failures.
+func backoffDelay(b Backoff, failures int) time.Duration {
+	delay := time.Duration(b.Initial) * time.Second
+	maxDelay := time.Duration(b.Max) * time.Second
+	for i := 1; i < failures && delay < maxDelay; i++ {
+		delay *= 2
+	}
+	if delay > maxDelay {
+		delay = maxDelay
+	}
+	return delay
+}
+
+// loadBackoffState returns the recorded backoff state. A missing or invalid state is considered as no failure.
+func (ad *AD) loadBackoffState(ctx context.Context) (state backoffState) {
+	d, err := os.ReadFile(ad.backoffStatePath)
+	if errors.Is(err, fs.ErrNotExist) {
+		return state
+	} else if err != nil {
+		log.Warningf(ctx, i18n.G("Can't read domain controller backoff state, contacting it: %v"), err)
+		return state
+	}
+	if err := json.Unmarshal(d, &state); err != nil {
+		log.Warningf(ctx, i18n.G("Invalid domain controller backoff state, contacting it: %v"), err)
+		return backoffState{}
+	}
+
+	return state
+}
+
+// save atomically writes the backoff state to p.
+func (s backoffState) save(p string) (err error) {
+	defer decorate.OnError(&err, i18n.G("can't save domain controller backoff state"))
+
+	d, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+
+	if err := os.WriteFile(p+".new", d, 0600); err != nil {
+		return err
+	}
+	return os.Rename(p+".new", p)
+}
@@ -5,7 +5,10 @@ import (
 	"bufio"
 	"fmt"
 	"os"
+	"path"
 	"path/filepath"
+	"regexp"
+	"runtime"
 	"strings"
 
 	"github.com/ubuntu/adsys/internal/i18n"
@@ -44,3 +47,64 @@ func GetVersionID(root string) (versionID string, err error) {
 
 	return versionID, nil
 }
+
+// debianArchitectures maps Go architecture names to their Debian counterpart, when they differ.
+var debianArchitectures = map[string]string{
+	"386":      "i386",
+	"arm":      "armhf",
+	"ppc64le":  "ppc64el",
+	"mips64le": "mips64el",
+}
+
+// GetArchitecture returns the Debian name of the running architecture, like amd64 or arm64.
+func GetArchitecture() string {
+	if arch, ok := debianArchitectures[runtime.GOARCH]; ok {
+		return arch
+	}
+	return runtime.GOARCH
+}
+
+// MatchHostname returns if hostname satisfies the hostnames condition of a policy key.
+// patterns are separated by commas or new lines. They are globs, like LAB-*, or regular expressions matching the
+// whole hostname when prefixed with "regex:". A pattern prefixed with "!" excludes the matching hostnames.
+// The hostname must match one of the including patterns, if any, and none of the excluding ones. Matching is
+// case-insensitive, as hostnames are.
+func MatchHostname(hostname, patterns string) (match bool, err error) {
+	defer decorate.OnError(&err, i18n.G("invalid hostnames condition %q"), patterns)
+
+	hostname = strings.ToLower(hostname)
+	var hasIncludes, included bool
+	for _, p := range strings.FieldsFunc(patterns, func(r rune) bool { return r == ',' || r == '\n' }) {
+		p = strings.TrimSpace(p)
+		exclude := strings.HasPrefix(p, "!")
+		p = strings.TrimSpace(strings.TrimPrefix(p, "!"))
+		if p == "" {
+			continue
+		}
+
+		var m bool
+		if re, ok := strings.CutPrefix(p, "regex:"); ok {
+			r, err := regexp.Compile("(?i)^(?:" + re + ")$")
+			if err != nil {
+				return false, err
+			}
+			m = r.MatchString(hostname)
+		} else {
+			m, err = path.Match(strings.ToLower(p), hostname)
+			if err != nil {
+				return false, fmt.Errorf(i18n.G("%s: %v"), p, err)
+			}
+		}
+
+		if exclude {
+			if m {
+				return false, nil
+			}
+			continue
+		}
+		hasIncludes = true
+		included = included || m
+	}
+
+	return included || !hasIncludes, nil
+}
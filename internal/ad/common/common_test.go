@@ -2,6 +2,7 @@ package adcommon_test
 
 import (
 	"path/filepath"
+	"runtime"
 	"testing"
 
 	"github.com/stretchr/testify/require"
@@ -45,3 +46,60 @@ func TestGetVersionID(t *testing.T) {
 		})
 	}
 }
+
+func TestGetArchitecture(t *testing.T) {
+	t.Parallel()
+
+	arch := adcommon.GetArchitecture()
+
+	want := map[string]string{"386": "i386", "arm": "armhf", "ppc64le": "ppc64el", "mips64le": "mips64el"}[runtime.GOARCH]
+	if want == "" {
+		want = runtime.GOARCH
+	}
+	require.Equal(t, want, arch, "GetArchitecture should return the Debian architecture name")
+}
+
+func TestMatchHostname(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		hostname string
+		patterns string
+
+		want    bool
+		wantErr bool
+	}{
+		"Empty condition matches all":              {hostname: "lab-01", patterns: "", want: true},
+		"Matching glob":                            {hostname: "LAB-01", patterns: "LAB-*", want: true},
+		"Non matching glob":                        {hostname: "OFFICE-01", patterns: "LAB-*", want: false},
+		"Glob is case insensitive":                 {hostname: "lab-01", patterns: "LAB-*", want: true},
+		"One of multiple comma separated patterns": {hostname: "KIOSK-2", patterns: "LAB-*, KIOSK-?", want: true},
+		"One of multiple line separated patterns":  {hostname: "KIOSK-2", patterns: "LAB-*\nKIOSK-?\n", want: true},
+		"Matching regex":                           {hostname: "LAB-042", patterns: "regex:LAB-[0-9]+", want: true},
+		"Regex matches the whole hostname":         {hostname: "MYLAB-042", patterns: "regex:LAB-[0-9]+", want: false},
+		"Regex is case insensitive":                {hostname: "lab-042", patterns: "regex:LAB-[0-9]+", want: true},
+		"Only exclusions match other hostnames":    {hostname: "LAB-01", patterns: "!SRV-*", want: true},
+		"Exclusion wins over inclusion":            {hostname: "LAB-SRV", patterns: "LAB-*, !*-SRV", want: false},
+		"Excluded by regex":                        {hostname: "SRV-01", patterns: "!regex:SRV-.*", want: false},
+		"Blank patterns are ignored":               {hostname: "LAB-01", patterns: " , LAB-*,, ", want: true},
+		"Only blank patterns match all hostnames":  {hostname: "LAB-01", patterns: " , ! ", want: true},
+
+		"Error on invalid glob":  {hostname: "LAB-01", patterns: "LAB-[", wantErr: true},
+		"Error on invalid regex": {hostname: "LAB-01", patterns: "regex:LAB-(", wantErr: true},
+	}
+
+	for name, tc := range tests {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			got, err := adcommon.MatchHostname(tc.hostname, tc.patterns)
+			if tc.wantErr {
+				require.Error(t, err, "MatchHostname should return an error but didn't")
+				return
+			}
+			require.NoError(t, err, "MatchHostname should not return an error")
+			require.Equal(t, tc.want, got, "MatchHostname returned an unexpected match")
+		})
+	}
+}
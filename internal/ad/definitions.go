@@ -2,8 +2,13 @@ package ad
 
 import (
 	"context"
+	"encoding/json"
+	"encoding/xml"
 	"fmt"
+	"sort"
+	"strings"
 
+	adcommon "github.com/ubuntu/adsys/internal/ad/common"
 	log "github.com/ubuntu/adsys/internal/grpc/logstreamer"
 	"github.com/ubuntu/adsys/internal/i18n"
 	policydefinitions "github.com/ubuntu/adsys/policies"
@@ -28,3 +33,102 @@ func GetPolicyDefinitions(ctx context.Context, format, distroID string) (admx st
 
 	return string(admxData), string(admlData), nil
 }
+
+// PolicyKey is a policy key understood by adsys, as defined in the policy definitions.
+type PolicyKey struct {
+	// Key is the key, relative to its policy type.
+	Key string
+	// Manager is the policy type, handled by the policy manager of the same name.
+	Manager string
+	// Class is the object class the key applies to: User or Machine.
+	Class string
+	// Type is the kind of value of the key, followed by its metadata type if any.
+	Type string
+	// Releases are the releases where this key is supported.
+	Releases []string
+}
+
+// admxPolicies is the subset of the admx policy definitions describing the keys.
+type admxPolicies struct {
+	Policies []struct {
+		Class        string `xml:"class,attr"`
+		Key          string `xml:"key,attr"`
+		EnabledValue string `xml:"enabledValue>string"`
+		Elements     struct {
+			Elements []struct {
+				XMLName   xml.Name
+				ValueName string `xml:"valueName,attr"`
+			} `xml:",any"`
+		} `xml:"elements"`
+	} `xml:"policies>policy"`
+}
+
+// GetPolicyKeys returns all the policy keys supported by adsys for distroID, sorted by manager and key.
+func GetPolicyKeys(ctx context.Context, distroID string) (keys []PolicyKey, err error) {
+	defer decorate.OnError(&err, i18n.G("can't get policy keys"))
+
+	log.Debugf(ctx, "GetPolicyKeys for %q", distroID)
+
+	// The all format contains the definitions for all supported releases.
+	admx, _, err := GetPolicyDefinitions(ctx, "all", distroID)
+	if err != nil {
+		return nil, err
+	}
+
+	var defs admxPolicies
+	if err := xml.Unmarshal([]byte(admx), &defs); err != nil {
+		return nil, err
+	}
+
+	keyPrefix := strings.ReplaceAll(fmt.Sprintf("%s/%s/", adcommon.KeyPrefix, distroID), "/", `\`)
+	for _, p := range defs.Policies {
+		manager, key, _ := strings.Cut(strings.TrimPrefix(p.Key, keyPrefix), `\`)
+		k := PolicyKey{
+			Key:      strings.ReplaceAll(key, `\`, "/"),
+			Manager:  manager,
+			Class:    p.Class,
+			Type:     "toggle",
+			Releases: []string{},
+		}
+
+		// Policies without any element are only enabled or disabled.
+		for _, e := range p.Elements.Elements {
+			if e.ValueName == "all" {
+				k.Type = e.XMLName.Local
+				break
+			}
+		}
+
+		// Metadata per release are stored as the enabled value.
+		var metas map[string]struct {
+			Meta string `json:"meta"`
+		}
+		if err := json.Unmarshal([]byte(p.EnabledValue), &metas); err != nil {
+			return nil, fmt.Errorf(i18n.G("invalid metadata for %s: %v"), p.Key, err)
+		}
+		if m := metas["all"].Meta; m != "" {
+			k.Type = fmt.Sprintf("%s (%s)", k.Type, m)
+		}
+		for release := range metas {
+			if release == "all" || release == "DISABLED" {
+				continue
+			}
+			k.Releases = append(k.Releases, release)
+		}
+		sort.Strings(k.Releases)
+
+		keys = append(keys, k)
+	}
+
+	sort.SliceStable(keys, func(i, j int) bool {
+		if keys[i].Manager != keys[j].Manager {
+			return keys[i].Manager < keys[j].Manager
+		}
+		if keys[i].Key != keys[j].Key {
+			return keys[i].Key < keys[j].Key
+		}
+		return keys[i].Class < keys[j].Class
+	})
+
+	return keys, nil
+}
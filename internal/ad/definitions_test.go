@@ -7,6 +7,7 @@ import (
 
 	"github.com/stretchr/testify/require"
 	"github.com/ubuntu/adsys/internal/ad"
+	"github.com/ubuntu/adsys/internal/testutils"
 )
 
 func TestGetPolicyDefinitions(t *testing.T) {
@@ -64,3 +65,39 @@ func TestGetPolicyDefinitions(t *testing.T) {
 		})
 	}
 }
+
+func TestGetPolicyKeys(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		distroID string
+
+		wantErr bool
+	}{
+		"List policy keys": {},
+
+		"Error on policy definitions not existing for this distro": {distroID: "NotExist", wantErr: true},
+	}
+
+	for name, tc := range tests {
+		tc := tc
+		name := name
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			if tc.distroID == "" {
+				tc.distroID = "Ubuntu"
+			}
+
+			keys, err := ad.GetPolicyKeys(context.Background(), tc.distroID)
+			if tc.wantErr {
+				require.Error(t, err, "GetPolicyKeys returned no error when expecting one")
+				return
+			}
+			require.NoError(t, err, "GetPolicyKeys returned an error when expecting none")
+
+			want := testutils.LoadWithUpdateFromGoldenYAML(t, keys)
+			require.Equal(t, want, keys, "GetPolicyKeys returned unexpected keys")
+		})
+	}
+}
@@ -42,6 +42,7 @@ import (
 	"path/filepath"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/mvo5/libsmbclient-go"
 	log "github.com/ubuntu/adsys/internal/grpc/logstreamer"
@@ -57,11 +58,12 @@ fetch downloads a list of gpos from a url for a given kerberosTicket and stores
 In addition, assetsURL is always refreshed if not empty.
 Each gpo entry must be a gpo, with a name, url of the form: smb://<server>/SYSVOL/<AD domain>/<GPO_ID> and mutex.
 If krb5Ticket is empty, no authentication is done on samba.
+With dryRun, the rollout state is not saved.
 This should not be called concurrently.
 
 It returns if the assets were refreshed or not.
 */
-func (ad *AD) fetch(ctx context.Context, krb5Ticket string, downloadables map[string]string) (assetsWereRefreshed bool, err error) {
+func (ad *AD) fetch(ctx context.Context, krb5Ticket string, downloadables map[string]string, dryRun bool) (assetsWereRefreshed bool, err error) {
 	defer decorate.OnError(&err, i18n.G("can't download all gpos and assets"))
 
 	// protect env variable and map creation
@@ -88,7 +90,27 @@ func (ad *AD) fetch(ctx context.Context, krb5Ticket string, downloadables map[st
 		client.SetUseKerberos()
 	}
 
+	// Remote versions of the GPOs and assets are only downloaded once they are older than the rollout delay.
+	var rollout rolloutState
+	var rolloutMu sync.Mutex
+	if ad.gpoRolloutDelay > 0 {
+		rollout = ad.loadRolloutState(ctx)
+		defer func() {
+			// A dry run doesn't start the rollout delay of the new versions.
+			if dryRun {
+				return
+			}
+			if err := rollout.save(ad.rolloutStatePath); err != nil {
+				log.Warning(ctx, err)
+			}
+		}()
+	}
+
+	// Downloads are serialized by fetchMu: the bytes downloaded by this refresh are the ones counted from now.
+	downloadedBefore := ad.stats.bytes.Load()
+
 	var errg errgroup.Group
+	errg.SetLimit(ad.maxConcurrentDownloads)
 	for name, url := range downloadables {
 		g, ok := ad.downloadables[name]
 		if !ok {
@@ -117,7 +139,7 @@ func (ad *AD) fetch(ctx context.Context, krb5Ticket string, downloadables map[st
 			}
 
 			// Look at GPO version and compare with the one on AD to decide if we redownload or not
-			shouldDownload, err := needsDownload(ctx, client, g, dest)
+			shouldDownload, remote, err := needsDownload(ctx, client, g, dest)
 			if err != nil {
 				if g.isAssets && errors.Is(err, errNoGPTINI) {
 					log.Info(ctx, "No assets directory with GPT.INI file found on AD, skipping assets download")
@@ -143,6 +165,38 @@ func (ad *AD) fetch(ctx context.Context, krb5Ticket string, downloadables map[st
 				return nil
 			}
 
+			if ad.gpoRolloutRing != "" && !taggedFor(remote.rings, ad.gpoRolloutRing) {
+				log.Infof(ctx, i18n.G("%q version %d is held back until it is tagged for the %q rollout ring"), g.name, remote.version, ad.gpoRolloutRing)
+				return nil
+			}
+			if rollout != nil {
+				rolloutMu.Lock()
+				availableAt := rollout.availableAt(filepath.Base(g.url), remote.version, ad.gpoRolloutDelay)
+				rolloutMu.Unlock()
+				if time.Now().Before(availableAt) {
+					log.Infof(ctx, i18n.G("%q version %d is held back by the rollout delay until %s"), g.name, remote.version, availableAt.Format(time.RFC3339))
+					return nil
+				}
+			}
+
+			// The download budget is checked before starting each download: a started download is always
+			// completed, so that GPOs and assets are consistent.
+			if ad.downloadBudget > 0 && ad.stats.bytes.Load()-downloadedBefore >= ad.downloadBudget {
+				log.Warningf(ctx, i18n.G("Download budget of %d bytes reached for this refresh, %q is downloaded on next refresh"), ad.downloadBudget, g.name)
+				return nil
+			}
+
+			if rollout != nil {
+				defer func() {
+					if err != nil {
+						return
+					}
+					rolloutMu.Lock()
+					delete(rollout, filepath.Base(g.url))
+					rolloutMu.Unlock()
+				}()
+			}
+
 			log.Infof(ctx, "Downloading %q", g.name)
 			g.mu.Lock()
 			defer g.mu.Unlock()
@@ -151,7 +205,7 @@ func (ad *AD) fetch(ctx context.Context, krb5Ticket string, downloadables map[st
 				assetsWereRefreshed = true
 			}
 
-			return downloadDir(ctx, client, g.url, dest)
+			return ad.downloadDir(ctx, client, g.url, dest)
 		})
 	}
 
@@ -164,20 +218,28 @@ func (ad *AD) fetch(ctx context.Context, krb5Ticket string, downloadables map[st
 
 var errNoGPTINI = errors.New("no GPT.INI file")
 
+// gptVersion is the version of a GPO or of the assets read from their GPT.INI file, with the rollout rings it is
+// tagged for.
+type gptVersion struct {
+	version int
+	rings   []string
+}
+
 // needsDownload returns if the downloadable should be refreshed.
 // This is done by comparing GPT.INI Version= content.
-func needsDownload(ctx context.Context, client *libsmbclient.Client, g *downloadable, localPath string) (updateNeeded bool, err error) {
+// It returns the remote version too.
+func needsDownload(ctx context.Context, client *libsmbclient.Client, g *downloadable, localPath string) (updateNeeded bool, remote gptVersion, err error) {
 	defer decorate.OnError(&err, i18n.G("can't check if %s needs refreshing"), g.name)
 
 	g.mu.RLock()
 	defer g.mu.RUnlock()
 
-	var localVersion, remoteVersion int
+	var localVersion int
 	if gptIniPath, err := findLocalGPTIni(localPath); err == nil {
 		if f, err := os.Open(filepath.Clean(gptIniPath)); err == nil {
 			defer decorate.LogFuncOnErrorContext(ctx, f.Close)
 
-			if localVersion, err = getGPOVersion(ctx, f, g.name); err != nil {
+			if localVersion, _, err = getGPOVersion(ctx, f, g.name); err != nil {
 				log.Warningf(ctx, "Invalid local GPT.INI for %s: %v\nDownloading it again…", g.name, err)
 			}
 		}
@@ -186,52 +248,56 @@ func needsDownload(ctx context.Context, client *libsmbclient.Client, g *download
 	f, err := client.Open(fmt.Sprintf("%s/GPT.INI", g.url), 0, 0)
 	if err != nil {
 		// nolint:errorlint // We cannot have multiple error wrapping directives in a single call
-		return false, fmt.Errorf("%w: %v", errNoGPTINI, err)
+		return false, gptVersion{}, fmt.Errorf("%w: %v", errNoGPTINI, err)
 	}
 	defer f.Close()
 	// Read() is on *libsmbclient.File, not libsmbclient.File
 	pf := &f
-	if remoteVersion, err = getGPOVersion(ctx, pf, g.name); err != nil {
-		return false, err
+	if remote.version, remote.rings, err = getGPOVersion(ctx, pf, g.name); err != nil {
+		return false, gptVersion{}, err
 	}
 
-	log.Debugf(ctx, "Local version for %q: %d, remote version: %d", g.name, localVersion, remoteVersion)
-	if localVersion >= remoteVersion {
-		return false, nil
+	log.Debugf(ctx, "Local version for %q: %d, remote version: %d", g.name, localVersion, remote.version)
+	if localVersion >= remote.version {
+		return false, remote, nil
 	}
 
-	return true, nil
+	return true, remote, nil
 }
 
-func getGPOVersion(ctx context.Context, r io.Reader, downloadableName string) (version int, err error) {
+// getGPOVersion returns the version of a GPT.INI file, and the rollout rings this version is tagged for.
+func getGPOVersion(ctx context.Context, r io.Reader, downloadableName string) (version int, rings []string, err error) {
 	defer decorate.OnError(&err, i18n.G("invalid remote GPT.INI"))
 
 	buf, err := io.ReadAll(r)
 	if err != nil {
-		return 0, err
+		return 0, nil, err
 	}
 
 	cfg, err := ini.Load(buf)
 	if err != nil {
-		return 0, err
+		return 0, nil, err
+	}
+	if cfg.Section("General").HasKey(rolloutRingsKey) {
+		rings = cfg.Section("General").Key(rolloutRingsKey).Strings(",")
 	}
 
 	// If the file exists but doesn't contain a Version key, we log a message and return 0
 	// This is the case for some Default Domain Policy GPOs
 	if !cfg.Section("General").HasKey("Version") {
 		log.Infof(ctx, i18n.G("No version key found in GPT.INI for %s, assuming 0"), downloadableName)
-		return 0, nil
+		return 0, rings, nil
 	}
 
 	version, err = cfg.Section("General").Key("Version").Int()
 	if err != nil {
-		return 0, err
+		return 0, nil, err
 	}
-	return version, nil
+	return version, rings, nil
 }
 
 // downloadDir will dl in a temporary directory and only commit it if fully downloaded without any errors.
-func downloadDir(ctx context.Context, client *libsmbclient.Client, url, dest string) (err error) {
+func (ad *AD) downloadDir(ctx context.Context, client *libsmbclient.Client, url, dest string) (err error) {
 	defer decorate.OnError(&err, i18n.G("download %q failed"), url)
 
 	smbsafe.WaitSmb()
@@ -258,7 +324,7 @@ func downloadDir(ctx context.Context, client *libsmbclient.Client, url, dest str
 			log.Info(ctx, i18n.G("Could not clean up temporary directory:"), err)
 		}
 	}()
-	if err := downloadRecursive(ctx, client, url, tmpdest); err != nil {
+	if err := ad.downloadRecursive(ctx, client, url, tmpdest); err != nil {
 		return err
 	}
 	// Remove previous download content
@@ -272,7 +338,7 @@ func downloadDir(ctx context.Context, client *libsmbclient.Client, url, dest str
 	return nil
 }
 
-func downloadRecursive(ctx context.Context, client *libsmbclient.Client, url, dest string) error {
+func (ad *AD) downloadRecursive(ctx context.Context, client *libsmbclient.Client, url, dest string) error {
 	d, err := client.Opendir(url)
 	if err != nil {
 		return err
@@ -306,23 +372,11 @@ func downloadRecursive(ctx context.Context, client *libsmbclient.Client, url, de
 		switch dirent.Type {
 		case libsmbclient.SmbcFile:
 			log.Debugf(ctx, i18n.G("Downloading %s"), entityURL)
-			f, err := client.Open(entityURL, 0, 0)
-			if err != nil {
-				return err
-			}
-			defer f.Close()
-			// Read() is on *libsmbclient.File, not libsmbclient.File
-			pf := &f
-			data, err := io.ReadAll(pf)
-			if err != nil {
-				return err
-			}
-
-			if err := os.WriteFile(entityDest, data, 0600); err != nil {
+			if err := ad.downloadFile(ctx, client, entityURL, entityDest); err != nil {
 				return err
 			}
 		case libsmbclient.SmbcDir:
-			err := downloadRecursive(ctx, client, entityURL, entityDest)
+			err := ad.downloadRecursive(ctx, client, entityURL, entityDest)
 			if err != nil {
 				return err
 			}
@@ -333,6 +387,41 @@ func downloadRecursive(ctx context.Context, client *libsmbclient.Client, url, de
 	return nil
 }
 
+// downloadFile streams the file at url to dest, without holding it in memory, throttled to the maximum download rate.
+// It fails if the file is larger than the maximum file size.
+func (ad *AD) downloadFile(ctx context.Context, client *libsmbclient.Client, url, dest string) (err error) {
+	f, err := client.Open(url, 0, 0)
+	if err != nil {
+		return err
+	}
+	// Close the file right away rather than at the end of the directory walk, to not exhaust file descriptors.
+	defer f.Close()
+
+	// #nosec G304 - dest is in our sysvol cache directory
+	out, err := os.OpenFile(dest, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+
+	// Read() is on *libsmbclient.File, not libsmbclient.File
+	pf := &f
+	n, err := io.Copy(out, ad.downloadLimiter.reader(ctx, io.LimitReader(pf, ad.maxFileSize+1)))
+	if errClose := out.Close(); err == nil {
+		err = errClose
+	}
+	if err != nil {
+		return err
+	}
+	if n > ad.maxFileSize {
+		ad.stats.rejected.Add(1)
+		return fmt.Errorf(i18n.G("%s is larger than the maximum file size of %d bytes"), url, ad.maxFileSize)
+	}
+
+	ad.stats.files.Add(1)
+	ad.stats.bytes.Add(n)
+	return nil
+}
+
 // findLocalGPTIni will look for a GPT.INI file in the given path (non-recursive).
 // To account for case differences in the filename/extension, try the canonical
 // name first (all uppercase), then walk the directory and check each entry.
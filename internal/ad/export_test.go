@@ -3,6 +3,9 @@ package ad
 var (
 	WithoutKerberos = withoutKerberos
 	WithGPOListCmd  = withGPOListCmd
+	WithMaxPolSize  = withMaxPolSize
+
+	WithLocalAccountFiles = withLocalAccountFiles
 )
 
 func (ad *AD) SysvolCacheDir() string {
@@ -14,3 +17,6 @@ func (ad *AD) PoliciesCacheDir() string {
 func (ad *AD) Krb5CacheDir() string {
 	return ad.krb5CacheDir
 }
+func (ad *AD) CacheDir() string {
+	return ad.cacheDir
+}
@@ -0,0 +1,142 @@
+// Package gpobackup reads the GPOs backed up with the Group Policy Management Console.
+//
+// A GPMC backup is a directory named after the backup ID, containing the backup information, like the GPO ID and
+// display name, in bkupInfo.xml, and the policy files of the GPO under DomainSysvol/GPO. Several backups can be
+// stored in the same parent directory.
+package gpobackup
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/ubuntu/adsys/internal/i18n"
+	"github.com/ubuntu/decorate"
+)
+
+// infoFile is the file describing the backed up GPO.
+const infoFile = "bkupInfo.xml"
+
+// Backup is a GPO backed up with the Group Policy Management Console.
+type Backup struct {
+	// ID is the ID of the backed up GPO.
+	ID string
+	// Name is the display name of the backed up GPO.
+	Name string
+	// Machine is the content of the machine Registry.pol file, or nil if the GPO has no machine policy.
+	Machine []byte
+	// User is the content of the user Registry.pol file, or nil if the GPO has no user policy.
+	User []byte
+}
+
+// backupInfo is the content of bkupInfo.xml.
+type backupInfo struct {
+	GPOGuid        string `xml:"GPOGuid"`
+	GPODisplayName string `xml:"GPODisplayName"`
+}
+
+// Read returns the GPO backed up in dir. dir can be the backup directory itself, or a directory containing only
+// one backup.
+func Read(dir string) (b Backup, err error) {
+	defer decorate.OnError(&err, i18n.G("can't read GPO backup in %q"), dir)
+
+	dir, err = backupDir(dir)
+	if err != nil {
+		return b, err
+	}
+
+	// #nosec G304 - the backup is read with the permissions of the caller.
+	d, err := os.ReadFile(filepath.Join(dir, infoFile))
+	if err != nil {
+		return b, err
+	}
+	var info backupInfo
+	if err := xml.Unmarshal(d, &info); err != nil {
+		return b, fmt.Errorf(i18n.G("invalid %s: %v"), infoFile, err)
+	}
+	if info.GPOGuid == "" {
+		return b, fmt.Errorf(i18n.G("%s doesn't contain the GPO ID"), infoFile)
+	}
+	b.ID = strings.TrimSpace(info.GPOGuid)
+	b.Name = strings.TrimSpace(info.GPODisplayName)
+	if b.Name == "" {
+		b.Name = b.ID
+	}
+
+	gpoDir := filepath.Join(dir, "DomainSysvol", "GPO")
+	if b.Machine, err = readPolicy(gpoDir, "Machine"); err != nil {
+		return Backup{}, err
+	}
+	if b.User, err = readPolicy(gpoDir, "User"); err != nil {
+		return Backup{}, err
+	}
+
+	return b, nil
+}
+
+// backupDir returns dir if it is a backup directory, or the only backup directory it contains.
+func backupDir(dir string) (string, error) {
+	if _, err := os.Stat(filepath.Join(dir, infoFile)); err == nil {
+		return dir, nil
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return "", err
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return "", err
+	}
+	var backups []string
+	for _, e := range entries {
+		if !e.IsDir() {
+			continue
+		}
+		if _, err := os.Stat(filepath.Join(dir, e.Name(), infoFile)); err == nil {
+			backups = append(backups, e.Name())
+		}
+	}
+
+	switch len(backups) {
+	case 0:
+		return "", errors.New(i18n.G("no GPO backup found"))
+	case 1:
+		return filepath.Join(dir, backups[0]), nil
+	default:
+		return "", fmt.Errorf(i18n.G("several GPO backups found, select one of: %s"), strings.Join(backups, ", "))
+	}
+}
+
+// readPolicy returns the content of the Registry.pol file of class in gpoDir, or nil if there is none.
+// GPMC doesn't preserve the case of the policy files and directories.
+func readPolicy(gpoDir, class string) ([]byte, error) {
+	classDir, err := findFold(gpoDir, class)
+	if err != nil || classDir == "" {
+		return nil, err
+	}
+	p, err := findFold(classDir, "Registry.pol")
+	if err != nil || p == "" {
+		return nil, err
+	}
+	// #nosec G304 - the backup is read with the permissions of the caller.
+	return os.ReadFile(p)
+}
+
+// findFold returns the path of the file name in dir, compared case insensitively, or an empty path if there is none.
+func findFold(dir, name string) (string, error) {
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return "", nil
+	} else if err != nil {
+		return "", err
+	}
+	for _, e := range entries {
+		if strings.EqualFold(e.Name(), name) {
+			return filepath.Join(dir, e.Name()), nil
+		}
+	}
+	return "", nil
+}
@@ -0,0 +1,63 @@
+package gpobackup_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/ubuntu/adsys/internal/ad/gpobackup"
+)
+
+func TestRead(t *testing.T) {
+	t.Parallel()
+
+	standardPolicies := filepath.Join("..", "testdata", "AD", "SYSVOL", "gpoonly.com", "Policies", "standard")
+
+	tests := map[string]struct {
+		dir string
+
+		wantName    string
+		wantMachine bool
+		wantUser    bool
+		wantErr     bool
+	}{
+		"Read backup directory":                    {dir: "backup-dir", wantName: "Kiosk lockdown", wantMachine: true, wantUser: true},
+		"Read only backup of directory":            {dir: "parent-of-one-backup", wantName: "Kiosk lockdown", wantMachine: true, wantUser: true},
+		"Policy files and directories ignore case": {dir: "uppercase-machine-only", wantName: "Kiosk lockdown", wantMachine: true},
+		"GPO without display name is named by ID":  {dir: "no-display-name", wantName: "{31B2F340-016D-11D2-945F-00C04FB984F9}"},
+
+		"Error on directory without backup":       {dir: "does-not-exist", wantErr: true},
+		"Error on directory with several backups": {dir: "several-backups", wantErr: true},
+		"Error on invalid backup information":     {dir: "invalid-info", wantErr: true},
+		"Error on backup without GPO ID":          {dir: "no-gpo-id", wantErr: true},
+	}
+	for name, tc := range tests {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			got, err := gpobackup.Read(filepath.Join("testdata", tc.dir))
+			if tc.wantErr {
+				require.Error(t, err, "Read should return an error but didn't")
+				return
+			}
+			require.NoError(t, err, "Read should not return an error")
+
+			require.Equal(t, "{31B2F340-016D-11D2-945F-00C04FB984F9}", got.ID, "Read should return the GPO ID")
+			require.Equal(t, tc.wantName, got.Name, "Read should return the GPO name")
+
+			var wantMachine, wantUser []byte
+			if tc.wantMachine {
+				wantMachine, err = os.ReadFile(filepath.Join(standardPolicies, "Machine", "Registry.pol"))
+				require.NoError(t, err, "Setup: can't read machine policy")
+			}
+			if tc.wantUser {
+				wantUser, err = os.ReadFile(filepath.Join(standardPolicies, "User", "Registry.pol"))
+				require.NoError(t, err, "Setup: can't read user policy")
+			}
+			require.Equal(t, wantMachine, got.Machine, "Read should return the machine policy")
+			require.Equal(t, wantUser, got.User, "Read should return the user policy")
+		})
+	}
+}
@@ -0,0 +1,147 @@
+package ad
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	log "github.com/ubuntu/adsys/internal/grpc/logstreamer"
+	"github.com/ubuntu/adsys/internal/i18n"
+	"github.com/ubuntu/adsys/internal/policies"
+	"github.com/ubuntu/decorate"
+)
+
+const (
+	// adsysRulesKey is the type of the policy keys configuring adsys itself. They are consumed when parsing the GPOs
+	// and are never passed to the policy managers.
+	adsysRulesKey = "adsys"
+	// ignoredGPOsKey is the machine policy key listing the GPOs to ignore on the client.
+	ignoredGPOsKey = "ignored-gpos"
+)
+
+// normalizeGPOID returns id without braces and in upper case, so that GPO IDs match however they are written.
+func normalizeGPOID(id string) string {
+	return strings.ToUpper(strings.Trim(strings.TrimSpace(id), "{}"))
+}
+
+// parseGPOIDs returns the normalized GPO IDs of a list separated by new lines, commas or spaces.
+func parseGPOIDs(v string) (ids []string) {
+	for _, id := range strings.FieldsFunc(v, func(r rune) bool {
+		return r == ',' || r == ' ' || r == '\t' || r == '\n' || r == '\r'
+	}) {
+		if id = normalizeGPOID(id); id != "" {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// ignoredGPOs returns the normalized IDs of the GPOs to ignore: the configured ones, and the ones delivered by the
+// last machine policies.
+func (ad *AD) ignoredGPOs(ctx context.Context) map[string]struct{} {
+	ignored := make(map[string]struct{})
+	for _, id := range ad.configuredIgnoredGPOs {
+		ignored[id] = struct{}{}
+	}
+
+	ad.ignoredGPOsMu.Lock()
+	defer ad.ignoredGPOsMu.Unlock()
+
+	d, err := os.ReadFile(ad.ignoredGPOsStatePath)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		log.Warningf(ctx, i18n.G("Can't read the GPOs to ignore delivered by the machine policies: %v"), err)
+	}
+	for _, id := range parseGPOIDs(string(d)) {
+		ignored[id] = struct{}{}
+	}
+
+	return ignored
+}
+
+// skipIgnoredGPOs returns gpos without the ones to ignore, and removes them from downloadables so that they are not
+// downloaded. Each ignored GPO is reported, as its policies are not enforced on the client anymore.
+func skipIgnoredGPOs(ctx context.Context, objectName string, gpos []gpo, downloadables map[string]string, ignored map[string]struct{}) []gpo {
+	if len(ignored) == 0 {
+		return gpos
+	}
+
+	var kept []gpo
+	for _, g := range gpos {
+		id := filepath.Base(g.url)
+		if _, ok := ignored[normalizeGPOID(id)]; !ok {
+			kept = append(kept, g)
+			continue
+		}
+		log.Warningf(ctx, i18n.G("Ignoring GPO %q (%s) for %s: it is in the list of GPOs to ignore on this client"), g.name, id, objectName)
+		delete(downloadables, g.name)
+	}
+	return kept
+}
+
+// takeDeliveredIgnoredGPOs removes the policies configuring adsys from gpos, and returns the GPOs to ignore that
+// they deliver. The lists of every GPO are merged.
+func takeDeliveredIgnoredGPOs(gpos []policies.GPO) (ids []string) {
+	seen := make(map[string]struct{})
+	for _, g := range gpos {
+		for _, e := range g.Rules[adsysRulesKey] {
+			if e.Key != ignoredGPOsKey || e.Disabled {
+				continue
+			}
+			for _, id := range parseGPOIDs(e.Value) {
+				if _, ok := seen[id]; ok {
+					continue
+				}
+				seen[id] = struct{}{}
+				ids = append(ids, id)
+			}
+		}
+		delete(g.Rules, adsysRulesKey)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// saveDeliveredIgnoredGPOs records the GPOs to ignore delivered by the machine policies, so that they are ignored
+// for the users too, and after a restart. The record is removed once no GPO is delivered.
+func (ad *AD) saveDeliveredIgnoredGPOs(ids []string) (err error) {
+	defer decorate.OnError(&err, i18n.G("can't save the GPOs to ignore delivered by the machine policies"))
+
+	ad.ignoredGPOsMu.Lock()
+	defer ad.ignoredGPOsMu.Unlock()
+
+	p := ad.ignoredGPOsStatePath
+	if len(ids) == 0 {
+		if err := os.Remove(p); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+		return nil
+	}
+
+	if err := os.WriteFile(p+".new", []byte(strings.Join(ids, "\n")+"\n"), 0600); err != nil {
+		return err
+	}
+	return os.Rename(p+".new", p)
+}
+
+// withoutIgnoredGPOs returns pols without the GPOs to ignore, which were delivered or configured after pols were
+// retrieved.
+func withoutIgnoredGPOs(ctx context.Context, objectName string, pols policies.Policies, ignored map[string]struct{}) policies.Policies {
+	if len(ignored) == 0 {
+		return pols
+	}
+
+	var kept []policies.GPO
+	for _, g := range pols.GPOs {
+		if _, ok := ignored[normalizeGPOID(g.ID)]; ok {
+			log.Warningf(ctx, i18n.G("Ignoring GPO %q (%s) for %s: it is in the list of GPOs to ignore on this client"), g.Name, g.ID, objectName)
+			continue
+		}
+		kept = append(kept, g)
+	}
+	pols.GPOs = kept
+	return pols
+}
@@ -10,6 +10,7 @@ import (
 	"strings"
 	"sync"
 	"testing"
+	"time"
 
 	"github.com/sirupsen/logrus"
 	"github.com/stretchr/testify/assert"
@@ -32,6 +33,10 @@ func TestFetch(t *testing.T) {
 		concurrentGposDownload []string
 		existing               map[string]string
 		makeReadOnlyOnSource   []string
+		maxFileSize            int64
+		rolloutDelay           time.Duration
+		rolloutRing            string
+		rolloutState           map[string]seenVersion
 
 		want                map[string]string
 		wantAssetsRefreshed bool
@@ -256,6 +261,78 @@ func TestFetch(t *testing.T) {
 			gpos:    []string{"missing_gpt_ini", "gpo2"},
 			want:    map[string]string{"Policies/gpo2": "Policies/gpo2"},
 			wantErr: true},
+		"Error on file larger than the maximum file size": {
+			gpos: []string{"gpo1"}, maxFileSize: 1, want: nil, wantErr: true},
+		"Error on file larger than the maximum file size keeps existing gpo": {
+			gpos:        []string{"gpo1"},
+			existing:    map[string]string{"Policies/gpo1": "Policies/old_version"},
+			maxFileSize: 1,
+			want:        map[string]string{"Policies/gpo1": "Policies/old_version"},
+			wantErr:     true},
+
+		// Rollout delay
+		"New gpo is held back during the rollout delay": {
+			gpos: []string{"gpo1"}, rolloutDelay: time.Hour, want: nil},
+		"Gpo refresh is held back during the rollout delay": {
+			gpos:         []string{"gpo1"},
+			existing:     map[string]string{"Policies/gpo1": "Policies/old_version"},
+			rolloutDelay: time.Hour,
+			want:         map[string]string{"Policies/gpo1": "Policies/old_version"},
+		},
+		"Gpo is refreshed once its version is older than the rollout delay": {
+			gpos:         []string{"gpo1"},
+			existing:     map[string]string{"Policies/gpo1": "Policies/old_version"},
+			rolloutDelay: time.Hour,
+			rolloutState: map[string]seenVersion{"gpo1": {Version: 1000, FirstSeen: time.Now().Add(-2 * time.Hour)}},
+			want:         map[string]string{"Policies/gpo1": "Policies/gpo1"},
+		},
+		"Newer version restarts the rollout delay": {
+			gpos:         []string{"gpo1"},
+			existing:     map[string]string{"Policies/gpo1": "Policies/old_version"},
+			rolloutDelay: time.Hour,
+			rolloutState: map[string]seenVersion{"gpo1": {Version: 500, FirstSeen: time.Now().Add(-2 * time.Hour)}},
+			want:         map[string]string{"Policies/gpo1": "Policies/old_version"},
+		},
+		"Assets are held back during the rollout delay": {
+			adDomain:     "assetsonly.com",
+			assetsURL:    "Distro",
+			rolloutDelay: time.Hour,
+			want:         nil,
+		},
+		"Assets are refreshed once their version is older than the rollout delay": {
+			adDomain:            "assetsonly.com",
+			assetsURL:           "Distro",
+			rolloutDelay:        time.Hour,
+			rolloutState:        map[string]seenVersion{"Distro": {Version: 100, FirstSeen: time.Now().Add(-2 * time.Hour)}},
+			want:                map[string]string{"assets": "Distro"},
+			wantAssetsRefreshed: true,
+		},
+
+		// Rollout ring
+		"Gpo not tagged for the rollout ring is held back": {
+			gpos:        []string{"gpo1"},
+			existing:    map[string]string{"Policies/gpo1": "Policies/old_version"},
+			rolloutRing: "stable",
+			want:        map[string]string{"Policies/gpo1": "Policies/old_version"},
+		},
+		"Gpo tagged for the rollout ring is downloaded": {
+			gpos:        []string{"gpo_stable"},
+			rolloutRing: "stable",
+			want:        map[string]string{"Policies/gpo_stable": "Policies/gpo_stable"},
+		},
+		"Gpo tagged for the rollout ring is held back during the rollout delay": {
+			gpos:         []string{"gpo_stable"},
+			rolloutRing:  "canary",
+			rolloutDelay: time.Hour,
+			want:         nil,
+		},
+		"Assets not tagged for the rollout ring are held back": {
+			adDomain:    "assetsonly.com",
+			assetsURL:   "Distro",
+			rolloutRing: "stable",
+			want:        nil,
+		},
+
 		/*
 			This is to cover the error case on os.Removall() to clean up the directory. However
 			Marking the assets/ directory or any subelement read only doesn’t help.
@@ -290,9 +367,18 @@ func TestFetch(t *testing.T) {
 				tc.adDomain = "fakegpo.com"
 			}
 
+			opts := []Option{WithCacheDir(dest), WithRunDir(rundir), withoutKerberos()}
+			if tc.maxFileSize != 0 {
+				opts = append(opts, withMaxFileSize(tc.maxFileSize))
+			}
+			if tc.rolloutDelay != 0 {
+				opts = append(opts, WithGPORolloutDelay(tc.rolloutDelay))
+			}
+			if tc.rolloutRing != "" {
+				opts = append(opts, WithGPORolloutRing(tc.rolloutRing))
+			}
 			adc, err := New(context.Background(),
-				mock.Backend{}, hostname,
-				WithCacheDir(dest), WithRunDir(rundir), withoutKerberos())
+				mock.Backend{}, hostname, opts...)
 
 			require.NoError(t, err, "Setup: cannot create ad object")
 
@@ -306,6 +392,10 @@ func TestFetch(t *testing.T) {
 					"Setup: can't copy initial downloadable directory")
 			}
 
+			if tc.rolloutState != nil {
+				require.NoError(t, rolloutState(tc.rolloutState).save(adc.rolloutStatePath), "Setup: can't save initial rollout state")
+			}
+
 			for _, p := range tc.makeReadOnlyOnSource {
 				testutils.MakeReadOnly(t, filepath.Join(adc.sysvolCacheDir, p))
 			}
@@ -323,7 +413,7 @@ func TestFetch(t *testing.T) {
 
 			var assetsRefreshed bool
 			if tc.concurrentGposDownload == nil {
-				assetsRefreshed, err = adc.fetch(context.Background(), "", downloadables)
+				assetsRefreshed, err = adc.fetch(context.Background(), "", downloadables, false)
 				if tc.wantErr {
 					require.NotNil(t, err, "fetch should return an error but didn't")
 				} else {
@@ -342,7 +432,7 @@ func TestFetch(t *testing.T) {
 				var assetsRefreshed1, assetsRefreshed2 bool
 				go func() {
 					defer wg.Done()
-					assetsRefreshed1, err = adc.fetch(context.Background(), "", downloadables)
+					assetsRefreshed1, err = adc.fetch(context.Background(), "", downloadables, false)
 					if tc.wantErr {
 						require.NotNil(t, err, "fetch should return an error but didn't")
 					} else {
@@ -352,7 +442,7 @@ func TestFetch(t *testing.T) {
 				go func() {
 					defer wg.Done()
 					var err2 error
-					assetsRefreshed2, err2 = adc.fetch(context.Background(), "", concurrentGpos)
+					assetsRefreshed2, err2 = adc.fetch(context.Background(), "", concurrentGpos, false)
 					if tc.wantErr {
 						require.NotNil(t, err2, "fetch should return an error but didn't")
 					} else {
@@ -438,7 +528,7 @@ func TestFetchWithUnreadableFile(t *testing.T) {
 					"Setup: can't copy initial gpo directory")
 			}
 
-			assetsRefreshed, err := adc.fetch(context.Background(), "", downloadables)
+			assetsRefreshed, err := adc.fetch(context.Background(), "", downloadables, false)
 			require.NotNil(t, err, "fetch should return an error but didn't")
 
 			if !tc.withExistingGPO {
@@ -485,7 +575,7 @@ func TestFetchTweakSysvolCacheDir(t *testing.T) {
 				testutils.MakeReadOnly(t, filepath.Join(adc.sysvolCacheDir, "Policies"))
 			}
 
-			assetsRefreshed, err := adc.fetch(context.Background(), "", map[string]string{"gpo1-name": fmt.Sprintf("smb://localhost:%d/SYSVOL/fakegpo.com/Policies/gpo1", SmbPort)})
+			assetsRefreshed, err := adc.fetch(context.Background(), "", map[string]string{"gpo1-name": fmt.Sprintf("smb://localhost:%d/SYSVOL/fakegpo.com/Policies/gpo1", SmbPort)}, false)
 
 			require.NotNil(t, err, "fetch should return an error but didn't")
 			assert.NoDirExists(t, filepath.Join(adc.sysvolCacheDir, "Policies", "gpo1"), "gpo1 shouldn't be downloaded")
@@ -494,6 +584,36 @@ func TestFetchTweakSysvolCacheDir(t *testing.T) {
 	}
 }
 
+func TestFetchDownloadBudget(t *testing.T) {
+	t.Parallel() // libsmbclient overrides SIGCHILD, but we have one global lock
+
+	hostname, err := os.Hostname()
+	require.NoError(t, err, "Setup: failed to get hostname for tests.")
+
+	dest, rundir := t.TempDir(), t.TempDir()
+	// Downloading one GPO at a time with a budget of 1 byte only allows one download per refresh.
+	adc, err := New(context.Background(), mock.Backend{}, hostname,
+		WithCacheDir(dest), WithRunDir(rundir), withoutKerberos(),
+		WithLimits(Limits{MaxConcurrentDownloads: 1}), withDownloadBudget(1))
+	require.NoError(t, err, "Setup: cannot create ad object")
+
+	downloadables := make(map[string]string)
+	for _, n := range []string{"gpo1", "gpo2"} {
+		downloadables[n+"-name"] = fmt.Sprintf("smb://localhost:%d/SYSVOL/fakegpo.com/Policies/%s", SmbPort, n)
+	}
+
+	_, err = adc.fetch(context.Background(), "", downloadables, false)
+	require.NoError(t, err, "fetch returned an error but shouldn't")
+	downloaded, err := os.ReadDir(filepath.Join(adc.sysvolCacheDir, "Policies"))
+	require.NoError(t, err, "Can't list downloaded GPOs")
+	require.Len(t, downloaded, 1, "Only one GPO should be downloaded once the budget is reached")
+
+	_, err = adc.fetch(context.Background(), "", downloadables, false)
+	require.NoError(t, err, "fetch returned an error but shouldn't")
+	require.DirExists(t, filepath.Join(adc.sysvolCacheDir, "Policies", "gpo1"), "gpo1 should be downloaded by the next refresh")
+	require.DirExists(t, filepath.Join(adc.sysvolCacheDir, "Policies", "gpo2"), "gpo2 should be downloaded by the next refresh")
+}
+
 func TestFetchOneGPOWhileParsingItConcurrently(t *testing.T) {
 	t.Parallel() // libsmbclient overrides SIGCHILD, but we have one global lock
 
@@ -531,7 +651,7 @@ func TestFetchOneGPOWhileParsingItConcurrently(t *testing.T) {
 	go func() {
 		defer wg.Done()
 
-		assetsRefreshed, err := adc.fetch(context.Background(), "", gpos)
+		assetsRefreshed, err := adc.fetch(context.Background(), "", gpos, false)
 		require.NoError(t, err, "fetch returned an error but shouldn't")
 		assert.False(t, assetsRefreshed, "we haven't refreshed assets")
 	}()
@@ -561,7 +681,7 @@ func TestParseGPOConcurrent(t *testing.T) {
 		"standard-name": fmt.Sprintf("smb://localhost:%d/SYSVOL/gpoonly.com/Policies/standard", SmbPort),
 	}
 	orderedGPOs := []gpo{{name: "standard-name", url: gpos["standard-name"]}}
-	assetsRefreshed, err := adc.fetch(context.Background(), "", gpos)
+	assetsRefreshed, err := adc.fetch(context.Background(), "", gpos, false)
 	require.NoError(t, err, "Setup: couldn’t do initial GPO fetch as returned an error but shouldn't")
 	assert.False(t, assetsRefreshed, "we haven't refreshed assets")
 
@@ -579,6 +699,71 @@ func TestParseGPOConcurrent(t *testing.T) {
 	wg.Wait()
 }
 
+func TestBackoffDelay(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		backoff  Backoff
+		failures int
+
+		want time.Duration
+	}{
+		"First failure waits initial delay":       {backoff: Backoff{Initial: 60, Max: 3600}, failures: 1, want: time.Minute},
+		"Delay doubles on each failure":           {backoff: Backoff{Initial: 60, Max: 3600}, failures: 3, want: 4 * time.Minute},
+		"Delay is limited to maximum":             {backoff: Backoff{Initial: 60, Max: 3600}, failures: 7, want: time.Hour},
+		"Delay stays at maximum on many failures": {backoff: Backoff{Initial: 60, Max: 3600}, failures: 1000, want: time.Hour},
+		"Initial delay equal to maximum":          {backoff: Backoff{Initial: 3600, Max: 3600}, failures: 2, want: time.Hour},
+	}
+	for name, tc := range tests {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			got := backoffDelay(tc.backoff, tc.failures)
+			require.Equal(t, tc.want, got, "backoffDelay should return expected delay")
+		})
+	}
+}
+
+func TestRateLimiter(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		rate  int64
+		reads []int
+
+		wantMin time.Duration
+	}{
+		"No limit does not wait":                 {reads: []int{1 << 20}},
+		"Reads are paid off at the maximum rate": {rate: 1000, reads: []int{100, 100}, wantMin: 200 * time.Millisecond},
+		"Empty reads do not wait":                {rate: 1000, reads: []int{0, 0}},
+	}
+	for name, tc := range tests {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			l := newRateLimiter(tc.rate)
+			start := time.Now()
+			for _, n := range tc.reads {
+				require.NoError(t, l.wait(context.Background(), n), "wait should not return an error")
+			}
+			elapsed := time.Since(start)
+			require.GreaterOrEqual(t, elapsed, tc.wantMin, "Reads should have been throttled")
+			require.Less(t, elapsed, tc.wantMin+time.Second, "Reads should not have been throttled more than needed")
+		})
+	}
+}
+
+func TestRateLimiterCancel(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err := newRateLimiter(1).wait(ctx, 1<<20)
+	require.ErrorIs(t, err, context.Canceled, "wait should return when the context is canceled")
+}
+
 const SmbPort = 1445
 
 func TestMain(m *testing.M) {
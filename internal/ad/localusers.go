@@ -0,0 +1,307 @@
+package ad
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"sort"
+	"strings"
+
+	log "github.com/ubuntu/adsys/internal/grpc/logstreamer"
+	"github.com/ubuntu/adsys/internal/i18n"
+	"github.com/ubuntu/adsys/internal/policies"
+	"github.com/ubuntu/decorate"
+	"golang.org/x/exp/slices"
+)
+
+// localUsersKey is the machine policy key mapping local users and groups to the account in the directory whose user
+// policies they receive.
+const localUsersKey = "local-users"
+
+// parseLocalUser returns the normalized local user, or local group prefixed with %, and directory account of a
+// mapping.
+func parseLocalUser(name, account string) (string, string, error) {
+	name, account = strings.ToLower(strings.TrimSpace(name)), strings.ToLower(strings.TrimSpace(account))
+	if strings.TrimPrefix(name, "%") == "" || strings.ContainsAny(name, `@\`) {
+		return "", "", fmt.Errorf(i18n.G("invalid local user or group %q"), name)
+	}
+	if u, domain, _ := strings.Cut(account, "@"); u == "" || domain == "" {
+		return "", "", fmt.Errorf(i18n.G("account of %q should be of the form USER@DOMAIN, got %q"), name, account)
+	}
+	return name, account, nil
+}
+
+// parseLocalUsers returns the mappings of a list of NAME=ACCOUNT separated by new lines. The first mapping of a local
+// user or group wins. Invalid mappings are skipped and returned as errors.
+func parseLocalUsers(v string) (mapping map[string]string, err error) {
+	mapping = make(map[string]string)
+	var errs []error
+	for _, line := range strings.Split(v, "\n") {
+		if line = strings.TrimSpace(line); line == "" {
+			continue
+		}
+		name, account, found := strings.Cut(line, "=")
+		if !found {
+			errs = append(errs, fmt.Errorf(i18n.G("invalid local user mapping %q: should be of the form NAME=ACCOUNT"), line))
+			continue
+		}
+		name, account, err := parseLocalUser(name, account)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if _, ok := mapping[name]; ok {
+			continue
+		}
+		mapping[name] = account
+	}
+	return mapping, errors.Join(errs...)
+}
+
+// formatLocalUsers returns mapping as a sorted list of NAME=ACCOUNT separated by new lines.
+func formatLocalUsers(mapping map[string]string) string {
+	var lines []string
+	for name, account := range mapping {
+		lines = append(lines, name+"="+account)
+	}
+	sort.Strings(lines)
+	return strings.Join(lines, "\n")
+}
+
+// localUsers returns the mapping of local users, and local groups prefixed with %, to the account in the directory
+// whose user policies they receive: the configured ones, and the ones delivered by the last machine policies, which
+// take precedence.
+func (ad *AD) localUsers(ctx context.Context) map[string]string {
+	ad.localUsersMu.Lock()
+	defer ad.localUsersMu.Unlock()
+
+	return ad.localUsersLocked(ctx)
+}
+
+// localUsersLocked is localUsers, with localUsersMu held.
+func (ad *AD) localUsersLocked(ctx context.Context) map[string]string {
+	mapping := make(map[string]string)
+	for name, account := range ad.configuredLocalUsers {
+		mapping[name] = account
+	}
+
+	d, err := os.ReadFile(ad.localUsersStatePath)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		log.Warningf(ctx, i18n.G("Can't read the local users delivered by the machine policies: %v"), err)
+	}
+	delivered, err := parseLocalUsers(string(d))
+	if err != nil {
+		log.Warningf(ctx, i18n.G("Invalid local users delivered by the machine policies: %v"), err)
+	}
+	for name, account := range delivered {
+		mapping[name] = account
+	}
+
+	return mapping
+}
+
+// localUserAccount returns the account in the directory whose user policies the local user name receives, by its
+// name or else by the first of its groups in alphabetical order.
+// Only the users of the local accounts database are mapped: names which can't be local users, like the ones of the
+// directory or of the machine, are never mapped.
+func (ad *AD) localUserAccount(ctx context.Context, name string) (account string, ok bool) {
+	if name == "" || name == ad.hostname || strings.HasPrefix(name, "%") || strings.ContainsAny(name, `@\`) {
+		return "", false
+	}
+
+	mapping := ad.localUsers(ctx)
+	if len(mapping) == 0 {
+		return "", false
+	}
+
+	memberOf, err := localUserGroups(ad.passwdFile, ad.groupFile, name)
+	if err != nil {
+		log.Debugf(ctx, "%q is not a local user: %v", name, err)
+		return "", false
+	}
+
+	if account, ok := mapping[name]; ok {
+		return account, true
+	}
+
+	var groups []string
+	for n := range mapping {
+		if strings.HasPrefix(n, "%") {
+			groups = append(groups, n)
+		}
+	}
+	if len(groups) == 0 {
+		return "", false
+	}
+	sort.Strings(groups)
+
+	for _, g := range groups {
+		if slices.Contains(memberOf, strings.TrimPrefix(g, "%")) {
+			return mapping[g], true
+		}
+	}
+	return "", false
+}
+
+// directoryAccount returns the account of objectName in the directory. Mapped local users use the account whose
+// user policies they receive, and local is then true. Users not in the directory and not mapped are an error.
+func (ad *AD) directoryAccount(ctx context.Context, objectName string, objectClass ObjectClass) (account string, local bool, err error) {
+	if objectClass != UserObject || strings.Contains(objectName, "@") {
+		return objectName, false, nil
+	}
+	account, ok := ad.localUserAccount(ctx, objectName)
+	if !ok {
+		return "", false, fmt.Errorf(i18n.G("user name %q should be of the form %s@DOMAIN, or be a local user mapped to an account of the directory"), objectName, objectName)
+	}
+	log.Debugf(ctx, "Local user %q receives the user policies of %q", objectName, account)
+	return account, true, nil
+}
+
+// cachedLocalUsers returns the mapped local users with cached policies.
+func (ad *AD) cachedLocalUsers(ctx context.Context) (users []string, err error) {
+	objects, err := policies.CachedObjects(ad.cacheDir, policies.PoliciesCacheBaseName)
+	if err != nil {
+		return nil, err
+	}
+	for _, objectName := range objects {
+		if _, ok := ad.localUserAccount(ctx, objectName); ok {
+			users = append(users, objectName)
+		}
+	}
+	return users, nil
+}
+
+// deliveredLocalUsers returns the local users mapping delivered by the policies configuring adsys in gpos. The
+// mapping of the GPO with the highest priority wins. Invalid mappings are skipped with a warning.
+func deliveredLocalUsers(ctx context.Context, gpos []policies.GPO) map[string]string {
+	mapping := make(map[string]string)
+	for _, g := range gpos {
+		for _, e := range g.Rules[adsysRulesKey] {
+			if e.Key != localUsersKey || e.Disabled {
+				continue
+			}
+			m, err := parseLocalUsers(e.Value)
+			if err != nil {
+				log.Warningf(ctx, i18n.G("Invalid local users in GPO %q: %v"), g.Name, err)
+			}
+			for name, account := range m {
+				if _, ok := mapping[name]; ok {
+					continue
+				}
+				mapping[name] = account
+			}
+		}
+	}
+	return mapping
+}
+
+// saveDeliveredLocalUsers records the local users mapping delivered by the machine policies, so that the mapped
+// users receive their policies after a restart too. The record is removed once no mapping is delivered.
+func (ad *AD) saveDeliveredLocalUsers(ctx context.Context, mapping map[string]string) (err error) {
+	defer decorate.OnError(&err, i18n.G("can't save the local users delivered by the machine policies"))
+
+	ad.localUsersMu.Lock()
+	defer ad.localUsersMu.Unlock()
+
+	p := ad.localUsersStatePath
+	if len(mapping) == 0 {
+		if err := os.Remove(p); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+	} else {
+		if err := os.WriteFile(p+".new", []byte(formatLocalUsers(mapping)+"\n"), 0600); err != nil {
+			return err
+		}
+		if err := os.Rename(p+".new", p); err != nil {
+			return err
+		}
+	}
+
+	return ad.writeLocalUsersList(ctx)
+}
+
+// writeLocalUsersList writes the mapped local users and groups, one per line, in the run directory. The PAM module
+// reads it to only update the policies of the mapped local users at login. localUsersMu must be held.
+func (ad *AD) writeLocalUsersList(ctx context.Context) error {
+	p := ad.localUsersListPath
+	mapping := ad.localUsersLocked(ctx)
+	if len(mapping) == 0 {
+		if err := os.Remove(p); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+		return nil
+	}
+
+	var names []string
+	for name := range mapping {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	// #nosec G306 - the list is not sensitive, and is read by the PAM module.
+	if err := os.WriteFile(p+".new", []byte(strings.Join(names, "\n")+"\n"), 0644); err != nil {
+		return err
+	}
+	return os.Rename(p+".new", p)
+}
+
+// localUserGroups returns the lowercased names of the groups of the local user name, read from the passwd and group
+// files of the local accounts database: its primary group and the groups listing it as a member.
+// It is an error if name is not a user of the local accounts database. Users of the directory, resolved by NSS, are
+// not in those files.
+func localUserGroups(passwdFile, groupFile, name string) (groups []string, err error) {
+	defer decorate.OnError(&err, i18n.G("can't list the groups of local user %q"), name)
+
+	var gid string
+	var found bool
+	if err := readAccountsFile(passwdFile, func(fields []string) bool {
+		if len(fields) < 4 || strings.ToLower(fields[0]) != name {
+			return false
+		}
+		gid, found = fields[3], true
+		return true
+	}); err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, fmt.Errorf(i18n.G("no user %q in %s"), name, passwdFile)
+	}
+
+	if err := readAccountsFile(groupFile, func(fields []string) bool {
+		if len(fields) < 4 {
+			return false
+		}
+		isMember := fields[2] == gid
+		for _, m := range strings.Split(fields[3], ",") {
+			if strings.ToLower(strings.TrimSpace(m)) == name {
+				isMember = true
+			}
+		}
+		if isMember {
+			groups = append(groups, strings.ToLower(fields[0]))
+		}
+		return false
+	}); err != nil {
+		return nil, err
+	}
+	return groups, nil
+}
+
+// readAccountsFile calls f with the colon separated fields of each entry of the accounts file p, until f returns true.
+// Empty lines and comments are skipped.
+func readAccountsFile(p string, f func(fields []string) (stop bool)) error {
+	d, err := os.ReadFile(p)
+	if err != nil {
+		return err
+	}
+	for _, line := range strings.Split(string(d), "\n") {
+		if line = strings.TrimSpace(line); line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if f(strings.Split(line, ":")) {
+			return nil
+		}
+	}
+	return nil
+}
@@ -14,6 +14,27 @@ func withGPOListCmd(cmd []string) Option {
 	}
 }
 
+func withMaxFileSize(size int64) Option {
+	return func(o *options) error {
+		o.maxFileSize = size
+		return nil
+	}
+}
+
+func withDownloadBudget(size int64) Option {
+	return func(o *options) error {
+		o.downloadBudget = size
+		return nil
+	}
+}
+
+func withMaxPolSize(size int64) Option {
+	return func(o *options) error {
+		o.maxPolSize = size
+		return nil
+	}
+}
+
 // WithVersionID specifies a personalized release id.
 func WithVersionID(versionID string) Option {
 	return func(o *options) error {
@@ -21,3 +42,19 @@ func WithVersionID(versionID string) Option {
 		return nil
 	}
 }
+
+// WithArch specifies a personalized architecture.
+func WithArch(arch string) Option {
+	return func(o *options) error {
+		o.arch = arch
+		return nil
+	}
+}
+
+func withLocalAccountFiles(passwdFile, groupFile string) Option {
+	return func(o *options) error {
+		o.passwdFile = passwdFile
+		o.groupFile = groupFile
+		return nil
+	}
+}
@@ -0,0 +1,78 @@
+package ad
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+)
+
+// rateLimiter throttles the bytes read by all concurrent downloads to a maximum rate. A nil rateLimiter doesn't
+// throttle anything.
+type rateLimiter struct {
+	// rate is the maximum number of bytes read per second.
+	rate int64
+
+	mu sync.Mutex
+	// next is when the bytes already read are paid off at the maximum rate.
+	next time.Time
+}
+
+// newRateLimiter returns a limiter of rate bytes per second, or nil if rate is 0.
+func newRateLimiter(rate int64) *rateLimiter {
+	if rate == 0 {
+		return nil
+	}
+	return &rateLimiter{rate: rate}
+}
+
+// wait records that n bytes were read and blocks until they are paid off at the maximum rate, or ctx is done.
+func (l *rateLimiter) wait(ctx context.Context, n int) error {
+	if l == nil || n <= 0 {
+		return nil
+	}
+
+	l.mu.Lock()
+	now := time.Now()
+	if l.next.Before(now) {
+		l.next = now
+	}
+	l.next = l.next.Add(time.Duration(n) * time.Second / time.Duration(l.rate))
+	delay := l.next.Sub(now)
+	l.mu.Unlock()
+
+	t := time.NewTimer(delay)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// reader returns r throttled by the limiter.
+func (l *rateLimiter) reader(ctx context.Context, r io.Reader) io.Reader {
+	if l == nil {
+		return r
+	}
+	return &throttledReader{ctx: ctx, r: r, l: l}
+}
+
+type throttledReader struct {
+	ctx context.Context
+	r   io.Reader
+	l   *rateLimiter
+}
+
+// Read reads at most a tenth of a second worth of bytes, so that concurrent downloads are interleaved smoothly.
+func (t *throttledReader) Read(p []byte) (n int, err error) {
+	if chunk := int(t.l.rate/10) + 1; len(p) > chunk {
+		p = p[:chunk]
+	}
+	n, err = t.r.Read(p)
+	if errWait := t.l.wait(t.ctx, n); errWait != nil && err == nil {
+		err = errWait
+	}
+	return n, err
+}
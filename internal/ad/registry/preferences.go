@@ -0,0 +1,140 @@
+package registry
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/ubuntu/adsys/internal/i18n"
+	"github.com/ubuntu/adsys/internal/policies/entry"
+	"github.com/ubuntu/decorate"
+)
+
+// preferencesRegistry is the content of a Group Policy Preferences Registry.xml file.
+type preferencesRegistry struct {
+	Items       []preferencesItem       `xml:"Registry"`
+	Collections []preferencesCollection `xml:"Collection"`
+}
+
+type preferencesCollection struct {
+	Disabled    string                  `xml:"disabled,attr"`
+	Items       []preferencesItem       `xml:"Registry"`
+	Collections []preferencesCollection `xml:"Collection"`
+}
+
+type preferencesItem struct {
+	Disabled   string `xml:"disabled,attr"`
+	Properties struct {
+		Action string `xml:"action,attr"`
+		Key    string `xml:"key,attr"`
+		Name   string `xml:"name,attr"`
+		Type   string `xml:"type,attr"`
+		Value  string `xml:"value,attr"`
+		Values struct {
+			Values []string `xml:"Value"`
+		} `xml:"Values"`
+	} `xml:"Properties"`
+	Filters struct {
+		RunOnce *struct{} `xml:"FilterRunOnce"`
+	} `xml:"Filters"`
+}
+
+// DecodePreferences parses a Group Policy Preferences registry stream (Registry.xml) and returns
+// a slice of entries, in the order they should be applied. Disabled items are skipped.
+//
+// The entry action and apply once flag are set from the item attributes. Meta is set to the gsettings
+// signature matching the registry type.
+func DecodePreferences(r io.Reader) (entries []entry.Entry, err error) {
+	defer decorate.OnError(&err, i18n.G("can't parse preferences"))
+
+	var prefs preferencesRegistry
+	d := xml.NewDecoder(r)
+	// Registry.xml files are encoded in utf-8, but may declare a different charset by the editor.
+	d.CharsetReader = func(_ string, input io.Reader) (io.Reader, error) { return input, nil }
+	if err := d.Decode(&prefs); err != nil {
+		return nil, err
+	}
+
+	return decodePreferencesItems(prefs.Items, prefs.Collections)
+}
+
+func decodePreferencesItems(items []preferencesItem, collections []preferencesCollection) (entries []entry.Entry, err error) {
+	for _, item := range items {
+		if item.Disabled == "1" {
+			continue
+		}
+		e, err := decodePreferencesItem(item)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, e)
+	}
+
+	for _, c := range collections {
+		if c.Disabled == "1" {
+			continue
+		}
+		e, err := decodePreferencesItems(c.Items, c.Collections)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, e...)
+	}
+
+	return entries, nil
+}
+
+func decodePreferencesItem(item preferencesItem) (e entry.Entry, err error) {
+	p := item.Properties
+	key := strings.Trim(strings.ReplaceAll(p.Key, `\`, `/`), "/")
+	if p.Name != "" {
+		key = key + "/" + p.Name
+	}
+	defer decorate.OnError(&err, i18n.G("invalid preference item %s"), key)
+
+	e = entry.Entry{
+		Key:       key,
+		ApplyOnce: item.Filters.RunOnce != nil,
+	}
+
+	switch p.Action {
+	case "C":
+		e.Action = entry.ActionCreate
+	case "R":
+		e.Action = entry.ActionReplace
+	case "U", "":
+		e.Action = entry.ActionUpdate
+	case "D":
+		e.Action = entry.ActionDelete
+		return e, nil
+	default:
+		return e, fmt.Errorf(i18n.G("unknown action %q"), p.Action)
+	}
+
+	switch p.Type {
+	case "REG_SZ", "REG_EXPAND_SZ", "":
+		// Strings are kept as strings, even when they look like other GVariant texts, like true or 1.
+		e.Value, e.Meta = p.Value, "s"
+	case "REG_MULTI_SZ":
+		e.Value, e.Meta = strings.Join(p.Values.Values, "\n"), "as"
+	case "REG_DWORD":
+		// DWORD are stored as hexadecimal in preferences.
+		v, err := strconv.ParseUint(p.Value, 16, 32)
+		if err != nil {
+			return e, err
+		}
+		e.Value, e.Meta = strconv.FormatInt(int64(int32(v)), 10), "i"
+	case "REG_QWORD":
+		v, err := strconv.ParseUint(p.Value, 16, 64)
+		if err != nil {
+			return e, err
+		}
+		e.Value, e.Meta = strconv.FormatInt(int64(v), 10), "x"
+	default:
+		return e, fmt.Errorf(i18n.G("%s type is not supported"), p.Type)
+	}
+
+	return e, nil
+}
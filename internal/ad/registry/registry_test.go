@@ -412,6 +412,75 @@ func TestDecodePolicy(t *testing.T) {
 	}
 }
 
+func TestDecodePreferences(t *testing.T) {
+	t.Parallel()
+
+	prefix := "Software/Policies/Ubuntu/dconf/com/ubuntu/category/"
+	tests := map[string]struct {
+		want    []entry.Entry
+		wantErr bool
+	}{
+		"all action types": {
+			want: []entry.Entry{
+				{Key: prefix + "key-create", Value: "created", Meta: "s", Action: entry.ActionCreate},
+				{Key: prefix + "key-replace", Value: "replaced", Meta: "s", Action: entry.ActionReplace},
+				{Key: prefix + "key-update", Value: "updated", Meta: "s", Action: entry.ActionUpdate},
+				{Key: prefix + "key-delete", Action: entry.ActionDelete},
+				{Key: prefix + "key-default", Value: "default action", Meta: "s", Action: entry.ActionUpdate},
+			}},
+		"all value types": {
+			want: []entry.Entry{
+				{Key: prefix + "key-s", Value: "file:///usr/share/backgrounds/ubuntu.png", Meta: "s", Action: entry.ActionUpdate},
+				{Key: prefix + "key-quoted-s", Value: "'quoted'", Meta: "s", Action: entry.ActionUpdate},
+				{Key: prefix + "key-expand-s", Value: "expanded", Meta: "s", Action: entry.ActionUpdate},
+				{Key: prefix + "key-s-bool", Value: "true", Meta: "s", Action: entry.ActionUpdate},
+				{Key: prefix + "key-s-array", Value: "[1, 2]", Meta: "s", Action: entry.ActionUpdate},
+				{Key: prefix + "key-i", Value: "42", Meta: "i", Action: entry.ActionUpdate},
+				{Key: prefix + "key-negative-i", Value: "-1", Meta: "i", Action: entry.ActionUpdate},
+				{Key: prefix + "key-x", Value: "256", Meta: "x", Action: entry.ActionUpdate},
+				{Key: prefix + "key-as", Value: "first\nsecond", Meta: "as", Action: entry.ActionUpdate},
+			}},
+		"apply once and disabled items": {
+			want: []entry.Entry{
+				{Key: prefix + "key-once", Value: "once", Meta: "s", Action: entry.ActionUpdate, ApplyOnce: true},
+			}},
+		"nested collections": {
+			want: []entry.Entry{
+				{Key: prefix + "key-root", Value: "root", Meta: "s", Action: entry.ActionUpdate},
+				{Key: strings.TrimSuffix(prefix, "/"), Value: "no name", Meta: "s", Action: entry.ActionUpdate},
+				{Key: prefix + "key-outer", Value: "outer", Meta: "s", Action: entry.ActionUpdate},
+				{Key: prefix + "key-inner", Value: "inner", Meta: "s", Action: entry.ActionUpdate},
+			}},
+
+		// Error cases
+		"invalid xml":      {wantErr: true},
+		"unknown action":   {wantErr: true},
+		"unsupported type": {wantErr: true},
+		"invalid dword":    {wantErr: true},
+	}
+	for name, tc := range tests {
+		tc := tc
+		name := name
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			p := filepath.Join("testdata", "preferences", strings.ReplaceAll(name, " ", "_")+".xml")
+			f, err := os.Open(p)
+			require.NoError(t, err, "Setup: can't open preferences file")
+			defer f.Close()
+
+			entries, err := registry.DecodePreferences(f)
+			if tc.wantErr {
+				require.Error(t, err, "DecodePreferences should have returned an error but didn't")
+				return
+			}
+			require.NoError(t, err, "DecodePreferences returned an error when expecting none")
+
+			require.Equal(t, tc.want, entries, "expected entries from DecodePreferences don't match")
+		})
+	}
+}
+
 func FuzzDecodePolicy(f *testing.F) {
 	// To seed the corpus, we need to read the example files.
 	policyfiles, err := os.ReadDir("testdata")
@@ -0,0 +1,85 @@
+package ad
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"strings"
+	"time"
+
+	log "github.com/ubuntu/adsys/internal/grpc/logstreamer"
+	"github.com/ubuntu/adsys/internal/i18n"
+	"github.com/ubuntu/decorate"
+)
+
+// rolloutRingsKey is the key of the General section of GPT.INI listing, separated by commas, the rollout rings the
+// version of a GPO or of the assets is tagged for.
+const rolloutRingsKey = "adsysRolloutRings"
+
+// seenVersion is a remote GPO version with the time it was first seen on the Active Directory.
+type seenVersion struct {
+	Version   int       `json:"version"`
+	FirstSeen time.Time `json:"first_seen"`
+}
+
+// rolloutState records, for each GPO ID, the newest remote version which is not downloaded yet.
+type rolloutState map[string]seenVersion
+
+// loadRolloutState returns the recorded rollout state. A missing or invalid state is considered empty, as if
+// all remote versions were seen for the first time.
+func (ad *AD) loadRolloutState(ctx context.Context) rolloutState {
+	state := make(rolloutState)
+
+	d, err := os.ReadFile(ad.rolloutStatePath)
+	if errors.Is(err, fs.ErrNotExist) {
+		return state
+	} else if err != nil {
+		log.Warningf(ctx, i18n.G("Can't read GPO rollout state, restarting rollout delays: %v"), err)
+		return state
+	}
+	if err := json.Unmarshal(d, &state); err != nil {
+		log.Warningf(ctx, i18n.G("Invalid GPO rollout state, restarting rollout delays: %v"), err)
+		return make(rolloutState)
+	}
+
+	return state
+}
+
+// save atomically writes the rollout state to p.
+func (r rolloutState) save(p string) (err error) {
+	defer decorate.OnError(&err, i18n.G("can't save GPO rollout state"))
+
+	d, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+
+	if err := os.WriteFile(p+".new", d, 0600); err != nil {
+		return err
+	}
+	return os.Rename(p+".new", p)
+}
+
+// availableAt returns when version of the GPO id can be downloaded, delay after it was first seen.
+// A new version restarts the delay, so that each version is held back for the same time.
+func (r rolloutState) availableAt(id string, version int, delay time.Duration) time.Time {
+	s, ok := r[id]
+	if !ok || s.Version != version {
+		s = seenVersion{Version: version, FirstSeen: time.Now()}
+		r[id] = s
+	}
+	return s.FirstSeen.Add(delay)
+}
+
+// taggedFor returns if ring is one of the rollout rings a version is tagged for. Rings are compared case
+// insensitively.
+func taggedFor(rings []string, ring string) bool {
+	for _, r := range rings {
+		if strings.EqualFold(strings.TrimSpace(r), ring) {
+			return true
+		}
+	}
+	return false
+}
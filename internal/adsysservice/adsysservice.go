@@ -5,7 +5,9 @@ import (
 	"context"
 	"fmt"
 	"os"
+	"path/filepath"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/godbus/dbus/v5"
@@ -18,20 +20,28 @@ import (
 	"github.com/ubuntu/adsys/internal/authorizer"
 	"github.com/ubuntu/adsys/internal/consts"
 	"github.com/ubuntu/adsys/internal/daemon"
+	"github.com/ubuntu/adsys/internal/grpc/auditlog"
 	"github.com/ubuntu/adsys/internal/grpc/connectionnotify"
 	"github.com/ubuntu/adsys/internal/grpc/interceptorschain"
 	"github.com/ubuntu/adsys/internal/grpc/logconnections"
 	log "github.com/ubuntu/adsys/internal/grpc/logstreamer"
 	"github.com/ubuntu/adsys/internal/i18n"
+	"github.com/ubuntu/adsys/internal/logrotate"
 	"github.com/ubuntu/adsys/internal/policies"
 	"github.com/ubuntu/decorate"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/reflection"
 )
 
+// reflectionMethodsPrefix is the prefix of the methods of the gRPC server reflection services.
+const reflectionMethodsPrefix = "/grpc.reflection."
+
 // Service is used to implement adsys.ServiceServer.
 type Service struct {
 	adsys.UnimplementedServiceServer
-	logger *logrus.Logger
+	logger      *logrus.Logger
+	auditLogger *logrus.Logger
+	auditLog    *logrotate.File
 
 	adc           *ad.AD
 	policyManager *policies.Manager
@@ -41,6 +51,20 @@ type Service struct {
 	state          state
 	initSystemTime *time.Time
 
+	// loginTimeout is how long users logging in wait for their policy update before starting their session with
+	// their cached policies. 0 always waits.
+	loginTimeout  time.Duration
+	offlinePolicy ad.OfflinePolicy
+	// backgroundUpdates tracks the login updates completing after their session started, and the shared refreshes.
+	backgroundUpdates *sync.WaitGroup
+
+	// refreshesMu protects refreshes.
+	refreshesMu *sync.Mutex
+	// refreshes are the policy refreshes in progress, by object, shared by the requests arriving meanwhile.
+	refreshes map[string]*sharedRefresh
+	// refreshesDir records the refreshes in progress, for the next instance of the daemon.
+	refreshesDir string
+
 	bus    *dbus.Conn
 	daemon *daemon.Daemon
 }
@@ -56,23 +80,49 @@ type state struct {
 }
 
 type options struct {
-	cacheDir      string
-	runDir        string
-	dconfDir      string
-	sudoersDir    string
-	policyKitDir  string
-	apparmorDir   string
-	apparmorFsDir string
-	systemUnitDir string
-	adBackend     string
-	sssConfig     sss.Config
-	winbindConfig winbind.Config
-	authorizer    authorizerer
+	cacheDir               string
+	runDir                 string
+	dconfDir               string
+	sudoersDir             string
+	policyKitDir           string
+	apparmorDir            string
+	apparmorFsDir          string
+	systemUnitDir          string
+	mountsDir              string
+	pluginsDir             string
+	transformsDir          string
+	hooksDir               string
+	metricsFile            string
+	dconfShards            bool
+	userNotifications      bool
+	unhandledEntries       bool
+	lastKnownGoodAfter     int
+	disabledPolicyManagers []string
+	gpoLinkTTL             time.Duration
+	rolloutDelay           time.Duration
+	rolloutRing            string
+	loginTimeout           time.Duration
+	limits                 ad.Limits
+	offlinePolicy          ad.OfflinePolicy
+	backoff                ad.Backoff
+	ignoredGPOs            []string
+	localUsers             map[string]string
+	domainCacheQuota       int64
+	precedence             map[string]string
+	vaultServers           []string
+	escrowAttributes       []string
+	auditLogPath           string
+	logRetention           logrotate.Retention
+	adBackend              string
+	sssConfig              sss.Config
+	winbindConfig          winbind.Config
+	authorizer             authorizerer
 }
 type option func(*options) error
 
 type authorizerer interface {
 	IsAllowedFromContext(context.Context, authorizer.Action) error
+	IsSenderAllowed(ctx context.Context, action authorizer.Action, sender string, uid uint32) error
 }
 
 // WithCacheDir specifies a personalized daemon cache directory.
@@ -132,6 +182,202 @@ func WithApparmorFsDir(p string) func(o *options) error {
 	}
 }
 
+// WithPluginsDir specifies a personalized directory for policy manager plugins.
+func WithPluginsDir(p string) func(o *options) error {
+	return func(o *options) error {
+		o.pluginsDir = p
+		return nil
+	}
+}
+
+// WithTransformsDir specifies a personalized directory for entry transformation rules.
+func WithTransformsDir(p string) func(o *options) error {
+	return func(o *options) error {
+		o.transformsDir = p
+		return nil
+	}
+}
+
+// WithHooksDir specifies a personalized directory for executables run after each policy apply.
+func WithHooksDir(p string) func(o *options) error {
+	return func(o *options) error {
+		o.hooksDir = p
+		return nil
+	}
+}
+
+// WithMetricsFile writes the metrics of the last policy apply of each object to p.
+func WithMetricsFile(p string) func(o *options) error {
+	return func(o *options) error {
+		o.metricsFile = p
+		return nil
+	}
+}
+
+// WithDconfUserShards stores dconf user databases in their own directory.
+func WithDconfUserShards(enabled bool) func(o *options) error {
+	return func(o *options) error {
+		o.dconfShards = enabled
+		return nil
+	}
+}
+
+// WithUserNotifications notifies users in their session of the restrictions newly enforced on them.
+func WithUserNotifications(enabled bool) func(o *options) error {
+	return func(o *options) error {
+		o.userNotifications = enabled
+		return nil
+	}
+}
+
+// WithLastKnownGoodAfter re-activates the last policy a policy manager applied successfully once it fails this number
+// of times in a row.
+func WithLastKnownGoodAfter(failures int) func(o *options) error {
+	return func(o *options) error {
+		o.lastKnownGoodAfter = failures
+		return nil
+	}
+}
+
+// WithUnhandledEntries stores the entries handled by no policy manager nor plugin in the run directory.
+func WithUnhandledEntries(enabled bool) func(o *options) error {
+	return func(o *options) error {
+		o.unhandledEntries = enabled
+		return nil
+	}
+}
+
+// WithDisabledPolicyManagers specifies the policy managers which are not supported on this system.
+func WithDisabledPolicyManagers(managers []string) func(o *options) error {
+	return func(o *options) error {
+		o.disabledPolicyManagers = managers
+		return nil
+	}
+}
+
+// WithGPOLinkCacheTTL specifies how long the GPO links of AD containers are cached between requests.
+func WithGPOLinkCacheTTL(ttl time.Duration) func(o *options) error {
+	return func(o *options) error {
+		o.gpoLinkTTL = ttl
+		return nil
+	}
+}
+
+// WithGPORolloutDelay specifies how long new GPO versions are held back before being applied.
+func WithGPORolloutDelay(delay time.Duration) func(o *options) error {
+	return func(o *options) error {
+		o.rolloutDelay = delay
+		return nil
+	}
+}
+
+// WithGPORolloutRing specifies the rollout ring GPO versions must be tagged for to be applied.
+func WithGPORolloutRing(ring string) func(o *options) error {
+	return func(o *options) error {
+		o.rolloutRing = ring
+		return nil
+	}
+}
+
+// WithIgnoredGPOs specifies the IDs of the GPOs which are never downloaded nor applied on this client.
+func WithIgnoredGPOs(ids []string) func(o *options) error {
+	return func(o *options) error {
+		o.ignoredGPOs = ids
+		return nil
+	}
+}
+
+// WithLocalUsers specifies the local users, and local groups prefixed with %, which receive the user policies of an
+// account in the directory.
+func WithLocalUsers(mapping map[string]string) func(o *options) error {
+	return func(o *options) error {
+		o.localUsers = mapping
+		return nil
+	}
+}
+
+// WithDomainCacheQuota specifies the maximum size in MiB of the cache of each domain. 0 is unlimited.
+func WithDomainCacheQuota(quota int64) func(o *options) error {
+	return func(o *options) error {
+		o.domainCacheQuota = quota
+		return nil
+	}
+}
+
+// WithPrecedence specifies the precedence strategy, lsdou or most-restrictive, of the entries of each policy manager.
+func WithPrecedence(precedence map[string]string) func(o *options) error {
+	return func(o *options) error {
+		o.precedence = precedence
+		return nil
+	}
+}
+
+// WithEscrowAttributes specifies the attributes of the computer object the values generated for the policies can be
+// escrowed to.
+func WithEscrowAttributes(attributes []string) func(o *options) error {
+	return func(o *options) error {
+		o.escrowAttributes = attributes
+		return nil
+	}
+}
+
+// WithVaultServers specifies the hosts of the Vault servers policy values can read secrets from.
+func WithVaultServers(hosts []string) func(o *options) error {
+	return func(o *options) error {
+		o.vaultServers = hosts
+		return nil
+	}
+}
+
+// WithLoginTimeout specifies how long users logging in wait for their policy update before their session starts with
+// their cached policies, while the update completes in the background. 0 always waits for the update.
+func WithLoginTimeout(timeout time.Duration) func(o *options) error {
+	return func(o *options) error {
+		o.loginTimeout = timeout
+		return nil
+	}
+}
+
+// WithOfflinePolicy specifies how cached policies are used when Active Directory is unreachable.
+func WithOfflinePolicy(p ad.OfflinePolicy) func(o *options) error {
+	return func(o *options) error {
+		o.offlinePolicy = p
+		return nil
+	}
+}
+
+// WithBackoff specifies how contacting the domain controller is throttled after connection failures.
+func WithBackoff(b ad.Backoff) func(o *options) error {
+	return func(o *options) error {
+		o.backoff = b
+		return nil
+	}
+}
+
+// WithLimits specifies the resource limits when downloading and parsing GPOs.
+func WithLimits(l ad.Limits) func(o *options) error {
+	return func(o *options) error {
+		o.limits = l
+		return nil
+	}
+}
+
+// WithAuditLog specifies a personalized file where administrative requests are audited.
+func WithAuditLog(p string) func(o *options) error {
+	return func(o *options) error {
+		o.auditLogPath = p
+		return nil
+	}
+}
+
+// WithLogRetention specifies how much of the logs written by the service, like the audit log, is kept on disk.
+func WithLogRetention(r logrotate.Retention) func(o *options) error {
+	return func(o *options) error {
+		o.logRetention = r
+		return nil
+	}
+}
+
 // WithSystemUnitDir specifies a personalized directory for the system unit files
 // generated by adsys.
 func WithSystemUnitDir(p string) func(o *options) error {
@@ -141,6 +387,14 @@ func WithSystemUnitDir(p string) func(o *options) error {
 	}
 }
 
+// WithMountsDir specifies a personalized directory under which system shared locations are mounted.
+func WithMountsDir(p string) func(o *options) error {
+	return func(o *options) error {
+		o.mountsDir = p
+		return nil
+	}
+}
+
 // WithADBackend specifies our specific backend to select.
 func WithADBackend(backend string) func(o *options) error {
 	return func(o *options) error {
@@ -172,7 +426,9 @@ func New(ctx context.Context, opts ...option) (s *Service, err error) {
 	defer decorate.OnError(&err, i18n.G("couldn't create adsys service"))
 
 	// defaults
-	args := options{}
+	args := options{
+		gpoLinkTTL: consts.DefaultGPOLinkCacheTTL * time.Second,
+	}
 	// applied options
 	for _, o := range opts {
 		if err := o(&args); err != nil {
@@ -198,6 +454,24 @@ func New(ctx context.Context, opts ...option) (s *Service, err error) {
 		return nil, err
 	}
 
+	auditLogPath := args.auditLogPath
+	if auditLogPath == "" {
+		auditLogPath = consts.DefaultAuditLogPath
+	}
+	auditLog, err := logrotate.Open(ctx, auditLogPath, args.logRetention)
+	if err != nil {
+		return nil, fmt.Errorf(i18n.G("can't open audit log: %v"), err)
+	}
+	// Only close the audit log on error, the service owns it otherwise.
+	defer func() {
+		if err != nil {
+			_ = auditLog.Close()
+		}
+	}()
+	auditLogger := logrus.New()
+	auditLogger.SetOutput(auditLog)
+	auditLogger.SetFormatter(&logrus.JSONFormatter{})
+
 	// Don’t call dbus.SystemBus which caches globally system dbus (issues in tests)
 	bus, err := dbus.SystemBusPrivate()
 	if err != nil {
@@ -212,7 +486,7 @@ func New(ctx context.Context, opts ...option) (s *Service, err error) {
 		return nil, err
 	}
 
-	var adOptions []ad.Option
+	adOptions := []ad.Option{ad.WithGPOLinkCacheTTL(args.gpoLinkTTL), ad.WithGPORolloutDelay(args.rolloutDelay), ad.WithGPORolloutRing(args.rolloutRing), ad.WithLimits(args.limits), ad.WithOfflinePolicy(args.offlinePolicy), ad.WithBackoff(args.backoff), ad.WithIgnoredGPOs(args.ignoredGPOs), ad.WithLocalUsers(args.localUsers)}
 	if args.cacheDir != "" {
 		adOptions = append(adOptions, ad.WithCacheDir(args.cacheDir))
 	}
@@ -282,18 +556,80 @@ func New(ctx context.Context, opts ...option) (s *Service, err error) {
 	if args.systemUnitDir != "" {
 		policyOptions = append(policyOptions, policies.WithSystemUnitDir(args.systemUnitDir))
 	}
+	if args.mountsDir != "" {
+		policyOptions = append(policyOptions, policies.WithMountsDir(args.mountsDir))
+	}
+	if args.pluginsDir != "" {
+		policyOptions = append(policyOptions, policies.WithPluginsDir(args.pluginsDir))
+	}
+	if args.transformsDir != "" {
+		policyOptions = append(policyOptions, policies.WithTransformsDir(args.transformsDir))
+	}
+	if args.hooksDir != "" {
+		policyOptions = append(policyOptions, policies.WithHooksDir(args.hooksDir))
+	}
+	if args.metricsFile != "" {
+		policyOptions = append(policyOptions, policies.WithMetricsFile(args.metricsFile))
+	}
+	if args.dconfShards {
+		policyOptions = append(policyOptions, policies.WithDconfUserShards(true))
+	}
+	if args.userNotifications {
+		policyOptions = append(policyOptions, policies.WithUserNotifications(true))
+	}
+	if args.unhandledEntries {
+		policyOptions = append(policyOptions, policies.WithUnhandledEntries(true))
+	}
+	if args.lastKnownGoodAfter > 0 {
+		policyOptions = append(policyOptions, policies.WithLastKnownGoodAfter(args.lastKnownGoodAfter))
+	}
+	if len(args.disabledPolicyManagers) > 0 {
+		policyOptions = append(policyOptions, policies.WithDisabledManagers(args.disabledPolicyManagers))
+	}
+	if args.domainCacheQuota != 0 {
+		policyOptions = append(policyOptions, policies.WithDomainCacheQuota(args.domainCacheQuota<<20))
+	}
+	if len(args.precedence) > 0 {
+		policyOptions = append(policyOptions, policies.WithPrecedence(args.precedence))
+	}
+	if len(args.vaultServers) > 0 {
+		policyOptions = append(policyOptions, policies.WithVaultServers(args.vaultServers))
+	}
+	if len(args.escrowAttributes) > 0 {
+		policyOptions = append(policyOptions, policies.WithEscrowAttributes(args.escrowAttributes))
+	}
+	// The sssd policy manager configures the SSSD instance adsys gets its configuration from.
+	if args.sssConfig.Conf != "" {
+		policyOptions = append(policyOptions, policies.WithSSSDConf(args.sssConfig.Conf))
+	}
+	// The netplan policy manager reverts network configurations losing the connectivity to the domain controller.
+	policyOptions = append(policyOptions, policies.WithServerURL(adBackend.ServerURL))
+	// Values generated for the machine, like the password of a local break-glass account, are escrowed on its
+	// computer object.
+	policyOptions = append(policyOptions, policies.WithSecretEscrow(adc.SetComputerAttribute))
+	policyOptions = append(policyOptions, policies.WithSecretEscrowReader(adc.ComputerAttribute))
 	m, err := policies.NewManager(bus, hostname, policyOptions...)
 	if err != nil {
 		return nil, err
 	}
+	// Roll back any policy apply interrupted by the daemon being stopped during a refresh.
+	if err := m.ResumeInterruptedApplies(ctx); err != nil {
+		log.Warning(ctx, err)
+	}
+	// Clients can still query the service over gRPC if the update state is not available over D-Bus.
+	if err := exportUpdateState(bus, m, args.authorizer); err != nil {
+		log.Warning(ctx, err)
+	}
 
 	// Init system reference time
 	initSysTime := initSystemTime(bus)
 
-	return &Service{
+	s = &Service{
+		auditLogger:   auditLogger,
+		auditLog:      auditLog,
 		adc:           adc,
 		policyManager: m,
-		authorizer:    args.authorizer,
+		authorizer:    statusSocketAuthorizer{authorizer: args.authorizer},
 		state: state{
 			cacheDir:      args.cacheDir,
 			dconfDir:      args.dconfDir,
@@ -304,30 +640,62 @@ func New(ctx context.Context, opts ...option) (s *Service, err error) {
 			systemUnitDir: args.systemUnitDir,
 		},
 		initSystemTime: initSysTime,
+		loginTimeout:   args.loginTimeout,
+		offlinePolicy:  args.offlinePolicy,
 		bus:            bus,
-	}, nil
+
+		backgroundUpdates: &sync.WaitGroup{},
+		refreshesMu:       &sync.Mutex{},
+		refreshes:         make(map[string]*sharedRefresh),
+		refreshesDir:      filepath.Join(runDir, handoverRefreshesBaseName),
+	}
+	// Complete the refreshes the previous daemon was stopped in the middle of.
+	s.resumeHandedOverRefreshes(ctx)
+
+	return s, nil
 }
 
 // RegisterGRPCServer registers our service with the new interceptor chains.
 // It will notify the daemon of any new connection.
+// The server reflection service is registered too, so that automation can discover the API, like with grpcurl.
 func (s *Service) RegisterGRPCServer(d *daemon.Daemon) *grpc.Server {
 	s.logger = logrus.StandardLogger()
+	interceptors := interceptorschain.StreamServer(
+		log.StreamServerInterceptor(s.logger),
+		connectionnotify.StreamServerInterceptor(d),
+		logconnections.StreamServerInterceptor(),
+		auditlog.StreamServerInterceptor(s.auditLogger),
+	)
+	// Reflection clients don't handle the logs streamed back: the API description is public, and they only keep the
+	// daemon alive while they are served. They are still audited, like any other request.
+	reflectionInterceptors := interceptorschain.StreamServer(
+		connectionnotify.StreamServerInterceptor(d),
+		auditlog.StreamServerInterceptor(s.auditLogger),
+	)
 	srv := grpc.NewServer(grpc.StreamInterceptor(
-		interceptorschain.StreamServer(
-			log.StreamServerInterceptor(s.logger),
-			connectionnotify.StreamServerInterceptor(d),
-			logconnections.StreamServerInterceptor(),
-		)), authorizer.WithUnixPeerCreds())
+		func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+			if strings.HasPrefix(info.FullMethod, reflectionMethodsPrefix) {
+				return reflectionInterceptors(srv, ss, info, handler)
+			}
+			return interceptors(srv, ss, info, handler)
+		}), authorizer.WithUnixPeerCreds())
 	adsys.RegisterServiceServer(srv, s)
+	reflection.Register(srv)
 	s.daemon = d
 	return srv
 }
 
 // Quit cleans every ressources than the service was using.
 func (s *Service) Quit(ctx context.Context) {
+	// Policy applies never stop midway: wait for them to end before releasing their resources.
+	s.backgroundUpdates.Wait()
+	s.policyManager.Wait()
 	if err := s.bus.Close(); err != nil {
 		log.Warningf(ctx, i18n.G("Can't disconnect system dbus: %v"), err)
 	}
+	if err := s.auditLog.Close(); err != nil {
+		log.Warningf(ctx, i18n.G("Can't close audit log: %v"), err)
+	}
 }
 
 // initSystemTime returns systemd generator init system time.
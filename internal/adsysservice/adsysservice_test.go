@@ -3,6 +3,7 @@ package adsysservice_test
 import (
 	"context"
 	"fmt"
+	"io"
 	"log"
 	"os"
 	"path/filepath"
@@ -16,7 +17,11 @@ import (
 	"github.com/ubuntu/adsys/internal/ad/backends/winbind"
 	"github.com/ubuntu/adsys/internal/adsysservice"
 	"github.com/ubuntu/adsys/internal/consts"
+	"github.com/ubuntu/adsys/internal/daemon"
 	"github.com/ubuntu/adsys/internal/testutils"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	reflectionpb "google.golang.org/grpc/reflection/grpc_reflection_v1alpha"
 )
 
 func TestNew(t *testing.T) {
@@ -43,6 +48,7 @@ func TestNew(t *testing.T) {
 		// Error cases
 		"Error on failure to create run directory":       {roDir: "parentrun", wantNewErr: true},
 		"Error on failure to create cache directory":     {roDir: "parentcache", wantNewErr: true},
+		"Error on failure to create audit log":           {roDir: "log", wantNewErr: true},
 		"Error on nonexistent sssd.conf":                 {sssdConf: "does_not_exist", wantNewErr: true},
 		"Error on ad.New prevents adsysservice creation": {roDir: "parentcache/cache", wantNewErr: true},
 	}
@@ -63,6 +69,7 @@ func TestNew(t *testing.T) {
 			policyKitDir := filepath.Join(temp, "polkit-1")
 			apparmorDir := filepath.Join(temp, "apparmor.d", "adsys")
 			apparmorFsDir := filepath.Join(temp, "apparmorfs")
+			auditLog := filepath.Join(temp, "log", "audit.log")
 			if tc.existingAdsysDirs {
 				require.NoError(t, os.MkdirAll(adsysCacheDir, 0700), "Setup: could not create adsys cache directory")
 				require.NoError(t, os.MkdirAll(adsysRunDir, 0700), "Setup: could not create adsys run directory")
@@ -92,6 +99,7 @@ func TestNew(t *testing.T) {
 				adsysservice.WithPolicyKitDir(policyKitDir),
 				adsysservice.WithApparmorDir(apparmorDir),
 				adsysservice.WithApparmorFsDir(apparmorFsDir),
+				adsysservice.WithAuditLog(auditLog),
 				adsysservice.WithSSSConfig(sssdConfig),
 				adsysservice.WithWinbindConfig(winbindConfig),
 			}
@@ -120,6 +128,58 @@ func TestNew(t *testing.T) {
 	}
 }
 
+func TestRegisterGRPCServerReflection(t *testing.T) {
+	t.Parallel()
+
+	temp := t.TempDir()
+	auditLog := filepath.Join(temp, "log", "audit.log")
+	s, err := adsysservice.New(context.Background(),
+		adsysservice.WithCacheDir(filepath.Join(temp, "cache")),
+		adsysservice.WithRunDir(filepath.Join(temp, "run")),
+		adsysservice.WithDconfDir(filepath.Join(temp, "dconf")),
+		adsysservice.WithSudoersDir(filepath.Join(temp, "sudoers.d")),
+		adsysservice.WithPolicyKitDir(filepath.Join(temp, "polkit-1")),
+		adsysservice.WithApparmorDir(filepath.Join(temp, "apparmor.d", "adsys")),
+		adsysservice.WithApparmorFsDir(filepath.Join(temp, "apparmorfs")),
+		adsysservice.WithAuditLog(auditLog),
+		adsysservice.WithSSSConfig(sss.Config{Conf: "testdata/sssd.conf", CacheDir: t.TempDir()}),
+	)
+	require.NoError(t, err, "Setup: New should not return an error")
+	defer s.Quit(context.Background())
+
+	socket := filepath.Join(temp, "adsysd.sock")
+	d, err := daemon.New(s.RegisterGRPCServer, socket)
+	require.NoError(t, err, "Setup: can't create daemon")
+	go func() { _ = d.Listen() }()
+	defer d.Quit(false)
+
+	conn, err := grpc.Dial("unix://"+socket, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err, "Setup: can't connect to the daemon")
+	defer conn.Close()
+
+	// Reflection clients send no log metadata.
+	stream, err := reflectionpb.NewServerReflectionClient(conn).ServerReflectionInfo(context.Background())
+	require.NoError(t, err, "ServerReflectionInfo should return no error")
+	err = stream.Send(&reflectionpb.ServerReflectionRequest{MessageRequest: &reflectionpb.ServerReflectionRequest_ListServices{}})
+	require.NoError(t, err, "Sending reflection request should return no error")
+	resp, err := stream.Recv()
+	require.NoError(t, err, "Reflection response should not be an error")
+
+	var services []string
+	for _, service := range resp.GetListServicesResponse().GetService() {
+		services = append(services, service.GetName())
+	}
+	require.Contains(t, services, "service", "The adsys service should be listed by reflection")
+
+	// The request is audited once the stream ends.
+	require.NoError(t, stream.CloseSend(), "Closing reflection stream should return no error")
+	_, err = stream.Recv()
+	require.ErrorIs(t, err, io.EOF, "Reflection stream should end once closed")
+	audit, err := os.ReadFile(auditLog)
+	require.NoError(t, err, "Audit log should exist")
+	require.Contains(t, string(audit), `"method":"/grpc.reflection.v1alpha.ServerReflection/ServerReflectionInfo"`, "Reflection request should be audited")
+}
+
 func TestMain(m *testing.M) {
 	// export SSSD domain
 	defer testutils.StartLocalSystemBus()()
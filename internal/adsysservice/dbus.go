@@ -0,0 +1,183 @@
+package adsysservice
+
+import (
+	"context"
+	"fmt"
+	"os/user"
+	"strconv"
+	"time"
+
+	"github.com/godbus/dbus/v5"
+	"github.com/godbus/dbus/v5/introspect"
+	"github.com/ubuntu/adsys/internal/adsysservice/actions"
+	"github.com/ubuntu/adsys/internal/authorizer"
+	log "github.com/ubuntu/adsys/internal/grpc/logstreamer"
+	"github.com/ubuntu/adsys/internal/i18n"
+	"github.com/ubuntu/adsys/internal/policies"
+	"github.com/ubuntu/adsys/internal/policies/hooks"
+	"github.com/ubuntu/decorate"
+)
+
+const dbusPropertiesInterface = "org.freedesktop.DBus.Properties"
+
+// updateStateProperties serves the update state of the machine and of each user as read-only D-Bus properties, so
+// that desktop indicators and other system services can display it without using the gRPC API.
+// Properties are computed from the policy cache on each request, and invalidated by the policy manager after each
+// policy apply.
+// Like the policy dumps, the update state of the machine is available to anyone, but the update state of the users is
+// restricted by polkit.
+type updateStateProperties struct {
+	policyManager *policies.Manager
+	authorizer    authorizerer
+	bus           *dbus.Conn
+}
+
+// Get returns the value of property.
+func (p updateStateProperties) Get(sender dbus.Sender, iface, property string) (dbus.Variant, *dbus.Error) {
+	props, err := p.GetAll(sender, iface)
+	if err != nil {
+		return dbus.Variant{}, err
+	}
+	v, ok := props[property]
+	if !ok {
+		return dbus.Variant{}, dbus.MakeFailedError(fmt.Errorf(i18n.G("no property %q on %s"), property, iface))
+	}
+	return v, nil
+}
+
+// GetAll returns the value of every property of iface.
+func (p updateStateProperties) GetAll(sender dbus.Sender, iface string) (map[string]dbus.Variant, *dbus.Error) {
+	if iface != hooks.DbusInterface {
+		return nil, dbus.MakeFailedError(fmt.Errorf(i18n.G("unknown interface %q"), iface))
+	}
+
+	ctx := context.Background()
+	machine, users, err := p.policyManager.UpdateStates(ctx)
+	if err != nil {
+		log.Warning(ctx, err)
+		return nil, dbus.MakeFailedError(err)
+	}
+
+	users, err = p.visibleUsers(ctx, string(sender), users)
+	if err != nil {
+		log.Warning(ctx, err)
+		return nil, dbus.MakeFailedError(err)
+	}
+
+	now := time.Now()
+	usersProp := make(map[string]map[string]dbus.Variant)
+	for u, s := range users {
+		usersProp[u] = updateStateToDbus(s, now)
+	}
+	return map[string]dbus.Variant{
+		hooks.MachineProperty: dbus.MakeVariant(updateStateToDbus(machine, now)),
+		hooks.UsersProperty:   dbus.MakeVariant(usersProp),
+	}, nil
+}
+
+// visibleUsers returns the update state of the users that sender is allowed to see: all of them if it can dump the
+// policies of other users, or only its own one if it can dump its own policies.
+func (p updateStateProperties) visibleUsers(ctx context.Context, sender string, users map[string]policies.UpdateState) (map[string]policies.UpdateState, error) {
+	var uid uint32
+	if err := p.bus.BusObject().Call("org.freedesktop.DBus.GetConnectionUnixUser", 0, sender).Store(&uid); err != nil {
+		return nil, fmt.Errorf(i18n.G("can't get the user of %s: %v"), sender, err)
+	}
+
+	if p.authorizer.IsSenderAllowed(ctx, authorizer.Action{ID: actions.ActionPolicyDump.OtherID}, sender, uid) == nil {
+		return users, nil
+	}
+
+	visible := make(map[string]policies.UpdateState)
+	if err := p.authorizer.IsSenderAllowed(ctx, authorizer.Action{ID: actions.ActionPolicyDump.SelfID}, sender, uid); err != nil {
+		log.Debugf(ctx, "Hiding the update state of all users from %s: %v", sender, err)
+		return visible, nil
+	}
+	for name, s := range users {
+		u, err := user.Lookup(name)
+		if err != nil || u.Uid != strconv.FormatUint(uint64(uid), 10) {
+			continue
+		}
+		visible[name] = s
+	}
+	return visible, nil
+}
+
+// Set always fails: all properties are read-only.
+func (p updateStateProperties) Set(iface, property string, _ dbus.Variant) *dbus.Error {
+	return dbus.MakeFailedError(fmt.Errorf(i18n.G("property %q of %s is read-only"), property, iface))
+}
+
+// updateStateToDbus returns the D-Bus dictionary of s. Times are in seconds since the epoch, and the cache age is the
+// number of seconds since the last successful update. They are 0 if the policies were never applied.
+func updateStateToDbus(s policies.UpdateState, now time.Time) map[string]dbus.Variant {
+	var lastUpdate, lastApply, cacheAge uint64
+	if !s.LastUpdate.IsZero() {
+		lastUpdate = uint64(s.LastUpdate.Unix())
+		cacheAge = uint64(now.Sub(s.LastUpdate).Truncate(time.Second).Seconds())
+	}
+	lastResult := ""
+	if !s.LastApply.IsZero() {
+		lastApply = uint64(s.LastApply.Unix())
+		lastResult = "succeeded"
+		if s.Failed {
+			lastResult = "failed"
+		}
+	}
+
+	return map[string]dbus.Variant{
+		"LastUpdate": dbus.MakeVariant(lastUpdate),
+		"LastApply":  dbus.MakeVariant(lastApply),
+		"LastResult": dbus.MakeVariant(lastResult),
+		"CacheAge":   dbus.MakeVariant(cacheAge),
+	}
+}
+
+// exportUpdateState exports the update state properties on bus and requests the adsys name, for D-Bus clients to
+// reach them.
+func exportUpdateState(bus *dbus.Conn, m *policies.Manager, auth authorizerer) (err error) {
+	defer decorate.OnError(&err, i18n.G("can't export update state on D-Bus"))
+
+	if err := bus.Export(updateStateProperties{policyManager: m, authorizer: auth, bus: bus}, hooks.DbusObjectPath, dbusPropertiesInterface); err != nil {
+		return err
+	}
+
+	node := &introspect.Node{
+		Name: hooks.DbusObjectPath,
+		Interfaces: []introspect.Interface{
+			introspect.IntrospectData,
+			{
+				Name: dbusPropertiesInterface,
+				Methods: []introspect.Method{
+					{Name: "Get", Args: []introspect.Arg{{Name: "interface", Type: "s", Direction: "in"}, {Name: "property", Type: "s", Direction: "in"}, {Name: "value", Type: "v", Direction: "out"}}},
+					{Name: "GetAll", Args: []introspect.Arg{{Name: "interface", Type: "s", Direction: "in"}, {Name: "properties", Type: "a{sv}", Direction: "out"}}},
+					{Name: "Set", Args: []introspect.Arg{{Name: "interface", Type: "s", Direction: "in"}, {Name: "property", Type: "s", Direction: "in"}, {Name: "value", Type: "v", Direction: "in"}}},
+				},
+				Signals: []introspect.Signal{
+					{Name: "PropertiesChanged", Args: []introspect.Arg{{Name: "interface", Type: "s"}, {Name: "changed", Type: "a{sv}"}, {Name: "invalidated", Type: "as"}}},
+				},
+			},
+			{
+				Name: hooks.DbusInterface,
+				Properties: []introspect.Property{
+					{Name: hooks.MachineProperty, Type: "a{sv}", Access: "read"},
+					{Name: hooks.UsersProperty, Type: "a{sa{sv}}", Access: "read"},
+				},
+				Signals: []introspect.Signal{
+					{Name: "PolicyUpdated", Args: []introspect.Arg{{Name: "object", Type: "s"}, {Name: "isComputer", Type: "b"}, {Name: "changedManagers", Type: "as"}}},
+				},
+			},
+		},
+	}
+	if err := bus.Export(introspect.NewIntrospectable(node), hooks.DbusObjectPath, introspect.IntrospectData.Name); err != nil {
+		return err
+	}
+
+	reply, err := bus.RequestName(hooks.DbusInterface, dbus.NameFlagDoNotQueue)
+	if err != nil {
+		return err
+	}
+	if reply != dbus.RequestNameReplyPrimaryOwner {
+		return fmt.Errorf(i18n.G("name %s is already taken"), hooks.DbusInterface)
+	}
+	return nil
+}
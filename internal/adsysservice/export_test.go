@@ -3,6 +3,7 @@ package adsysservice
 import (
 	"context"
 	"strings"
+	"sync"
 )
 
 // Option type exported for tests.
@@ -22,3 +23,28 @@ func (s *Service) SelectedBackend() string {
 
 	return backend
 }
+
+// NewWithRefreshesDir returns a service only able to coalesce refreshes, recorded in refreshesDir.
+func NewWithRefreshesDir(refreshesDir string) *Service {
+	return &Service{
+		backgroundUpdates: &sync.WaitGroup{},
+		refreshesMu:       &sync.Mutex{},
+		refreshes:         make(map[string]*sharedRefresh),
+		refreshesDir:      refreshesDir,
+	}
+}
+
+// CoalescedRefresh exposes coalescedRefresh for tests.
+func (s *Service) CoalescedRefresh(ctx context.Context, target string, isComputer bool, refresh func(context.Context) error) error {
+	return s.coalescedRefresh(ctx, target, isComputer, refresh)
+}
+
+// WaitBackgroundUpdates waits for the background refreshes to complete.
+func (s *Service) WaitBackgroundUpdates() {
+	s.backgroundUpdates.Wait()
+}
+
+// CheckChildProcess returns an error if the process pid, read under root, isn't a child of ppid owned by uid.
+func CheckChildProcess(root string, pid, ppid, uid uint32) error {
+	return checkChildProcess(root, pid, ppid, uid)
+}
@@ -0,0 +1,46 @@
+package adsysservice
+
+import (
+	"errors"
+	"time"
+
+	"github.com/ubuntu/adsys"
+	"github.com/ubuntu/adsys/internal/adsysservice/actions"
+	log "github.com/ubuntu/adsys/internal/grpc/logstreamer"
+	"github.com/ubuntu/adsys/internal/i18n"
+	"github.com/ubuntu/adsys/internal/policies"
+	"github.com/ubuntu/decorate"
+)
+
+// FreezePolicy suspends or resumes policy updates on the machine.
+// While frozen, policies are neither refreshed nor applied, and the last applied ones stay in place.
+func (s *Service) FreezePolicy(r *adsys.FreezePolicyRequest, stream adsys.Service_FreezePolicyServer) (err error) {
+	defer decorate.OnError(&err, i18n.G("error while freezing policy updates"))
+
+	if err := s.authorizer.IsAllowedFromContext(stream.Context(), actions.ActionServiceManage); err != nil {
+		return err
+	}
+
+	if r.GetUnfreeze() {
+		if err := s.policyManager.Unfreeze(); err != nil {
+			return err
+		}
+		log.Infof(stream.Context(), i18n.G("Policy updates resumed"))
+		return nil
+	}
+
+	if r.GetDuration() < 0 {
+		return errors.New(i18n.G("freeze duration must be a positive number of seconds"))
+	}
+
+	var until time.Time
+	if r.GetDuration() > 0 {
+		until = time.Now().Add(time.Duration(r.GetDuration()) * time.Second)
+	}
+	if err := s.policyManager.Freeze(until); err != nil {
+		return err
+	}
+
+	log.Warningf(stream.Context(), i18n.G("Policy updates frozen %s"), policies.DescribeFreeze(until))
+	return nil
+}
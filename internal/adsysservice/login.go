@@ -0,0 +1,78 @@
+package adsysservice
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"github.com/ubuntu/adsys/internal/ad"
+	"github.com/ubuntu/adsys/internal/detachedctx"
+	log "github.com/ubuntu/adsys/internal/grpc/logstreamer"
+	"github.com/ubuntu/adsys/internal/i18n"
+	"github.com/ubuntu/adsys/internal/policies"
+)
+
+// updatePolicyAtLogin updates the policies of the user target logging in within the login timeout.
+// Past it, the session starts with the policies applied on the previous login and the update completes in the
+// background: the user is notified once it is done.
+// Users without cached policies, or with stale ones which are refused, always wait for the update, as they do without
+// login timeout.
+func (s *Service) updatePolicyAtLogin(ctx context.Context, target, krb5cc string, getOpts ...ad.GetPoliciesOption) error {
+	if s.loginTimeout <= 0 {
+		return s.waitPolicyAtLogin(ctx, target, krb5cc, getOpts...)
+	}
+	lastUpdate, err := s.policyManager.LastUpdateFor(ctx, target, false)
+	if err != nil {
+		log.Debugf(ctx, "No cached policies for %q to start the session with: waiting for the update", target)
+		return s.waitPolicyAtLogin(ctx, target, krb5cc, getOpts...)
+	}
+	if maxAge := time.Duration(s.offlinePolicy.MaxCacheAge) * time.Second; s.offlinePolicy.RefuseStale && maxAge > 0 && time.Since(lastUpdate) > maxAge {
+		log.Debugf(ctx, "Cached policies for %q are stale: waiting for the update", target)
+		return s.waitPolicyAtLogin(ctx, target, krb5cc, getOpts...)
+	}
+
+	// The update outlives the request if it exceeds the login timeout: its logs are only local, as the stream of the
+	// request is then closed.
+	bgCtx := detachedctx.New(log.LocalOnly(ctx))
+	var late atomic.Bool
+	done := make(chan error, 1)
+	s.backgroundUpdates.Add(1)
+	go func() {
+		defer s.backgroundUpdates.Done()
+
+		pols, err := s.adc.GetPolicies(bgCtx, target, ad.UserObject, krb5cc, getOpts...)
+		if err == nil {
+			err = s.policyManager.ApplyPolicies(bgCtx, target, false, &pols, policies.AtLogin(), policies.WithCompletionNotification(late.Load))
+		} else {
+			s.policyManager.ReportRetrievalFailure(bgCtx, target, false)
+		}
+		if err != nil && late.Load() {
+			log.Warningf(bgCtx, i18n.G("Background policy update of %q failed, the previously applied policies are kept: %v"), target, err)
+		}
+		done <- err
+	}()
+
+	timer := time.NewTimer(s.loginTimeout)
+	defer timer.Stop()
+	select {
+	case err := <-done:
+		return err
+	case <-timer.C:
+	}
+
+	late.Store(true)
+	log.Warningf(ctx, i18n.G("Policy update of %q takes more than %s: starting the session with the previously applied policies while it completes in the background"), target, s.loginTimeout)
+	return nil
+}
+
+// waitPolicyAtLogin updates the policies of the user target logging in before their session starts.
+func (s *Service) waitPolicyAtLogin(ctx context.Context, target, krb5cc string, getOpts ...ad.GetPoliciesOption) error {
+	return s.coalescedRefresh(ctx, target, false, func(ctx context.Context) error {
+		pols, err := s.adc.GetPolicies(ctx, target, ad.UserObject, krb5cc, getOpts...)
+		if err != nil {
+			s.policyManager.ReportRetrievalFailure(ctx, target, false)
+			return err
+		}
+		return s.policyManager.ApplyPolicies(ctx, target, false, &pols, policies.AtLogin())
+	})
+}
@@ -2,7 +2,13 @@ package adsysservice
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
 
 	"github.com/ubuntu/adsys"
 	"github.com/ubuntu/adsys/internal/ad"
@@ -17,14 +23,45 @@ import (
 
 // UpdatePolicy refreshes or creates a policy for current user or user given as argument.
 // It can purge the policy instead of updating it if requested.
+// With IfOlderThan, a single target is only updated if its policies were not applied recently or if its group
+// membership changed since then.
+// With AtLogin, a user whose update exceeds the login timeout starts their session with their cached policies.
 func (s *Service) UpdatePolicy(r *adsys.UpdatePolicyRequest, stream adsys.Service_UpdatePolicyServer) (err error) {
 	defer decorate.OnError(&err, i18n.G("error while updating policy"))
 
+	return s.updatePolicy(stream.Context(), r, nil)
+}
+
+// UpdatePolicyDryRun returns the policy changes that UpdatePolicy would make for the same request, without
+// modifying the system. The freeze and the IfOlderThan options are ignored to always compute the changes.
+func (s *Service) UpdatePolicyDryRun(r *adsys.UpdatePolicyRequest, stream adsys.Service_UpdatePolicyDryRunServer) (err error) {
+	defer decorate.OnError(&err, i18n.G("error while computing policy changes"))
+
+	var out strings.Builder
+	if err := s.updatePolicy(stream.Context(), r, &out); err != nil {
+		return err
+	}
+
+	if err := stream.Send(&adsys.StringResponse{
+		Msg: out.String(),
+	}); err != nil {
+		log.Warningf(stream.Context(), "couldn't send policy changes to client: %v", err)
+	}
+
+	return nil
+}
+
+// updatePolicy updates the policies requested by r. With dryRun, the changes are written to it instead.
+func (s *Service) updatePolicy(ctx context.Context, r *adsys.UpdatePolicyRequest, dryRun io.Writer) (err error) {
+	if r.GetDomain() != "" {
+		return s.purgeDomain(ctx, r, dryRun)
+	}
+
 	objectClass := ad.UserObject
 	if r.GetIsComputer() || r.GetAll() {
 		objectClass = ad.ComputerObject
 	}
-	target, err := s.adc.NormalizeTargetName(stream.Context(), r.GetTarget(), objectClass)
+	target, err := s.adc.NormalizeTargetName(ctx, r.GetTarget(), objectClass)
 	if err != nil {
 		return err
 	}
@@ -35,50 +72,174 @@ func (s *Service) UpdatePolicy(r *adsys.UpdatePolicyRequest, stream adsys.Servic
 		targetForAuthorizer = "root"
 	}
 
-	if err := s.authorizer.IsAllowedFromContext(context.WithValue(stream.Context(), authorizer.OnUserKey, targetForAuthorizer),
+	if err := s.authorizer.IsAllowedFromContext(context.WithValue(ctx, authorizer.OnUserKey, targetForAuthorizer),
 		actions.ActionPolicyUpdate); err != nil {
 		return err
 	}
 
+	// Purging is still allowed while frozen, to remove a bad policy during an incident.
+	if !r.GetPurge() && dryRun == nil {
+		if until, frozen := s.policyManager.FrozenUntil(ctx); frozen {
+			log.Warningf(ctx, i18n.G("Policy updates are frozen %s: skipping update of %q"), policies.DescribeFreeze(until), target)
+			return nil
+		}
+	}
+
+	if r.GetIfOlderThan() > 0 && !r.GetAll() && !r.GetPurge() && dryRun == nil {
+		maxAge := time.Duration(r.GetIfOlderThan()) * time.Second
+		// Policies never applied have no last update time and are always updated.
+		if t, err := s.policyManager.LastUpdateFor(ctx, target, r.GetIsComputer()); err == nil && time.Since(t) < maxAge {
+			// Recent policies may have been resolved from another group membership: their GPO list is then outdated.
+			changed, err := s.adc.GroupMembershipChanged(ctx, target, objectClass)
+			if err != nil {
+				log.Warningf(ctx, i18n.G("Refreshing policies for %q: %v"), target, err)
+			} else if changed {
+				log.Infof(ctx, i18n.G("Group membership of %q changed since its last update: refreshing policies"), target)
+			} else {
+				log.Infof(ctx, i18n.G("Policies for %q were updated at %s, less than %s ago: skipping update"), target, t.Format(time.RFC3339), maxAge)
+				return nil
+			}
+		}
+	}
+
 	if r.GetIsComputer() || r.GetAll() {
 		hostname := s.adc.Hostname()
 
-		err = s.updatePolicyFor(stream.Context(), true, hostname, ad.ComputerObject, "", r.GetPurge())
+		err = s.updatePolicyFor(ctx, true, hostname, ad.ComputerObject, "", r.GetPurge(), dryRun)
 
 		if r.GetAll() {
-			users, err := s.adc.ListUsers(stream.Context(), !r.GetPurge())
+			users, err := s.adc.ListUsers(ctx, !r.GetPurge())
 			if err != nil {
 				return err
 			}
-			errg := new(errgroup.Group)
-			for _, user := range users {
-				user := user
-				errg.Go(func() (err error) {
-					return s.updatePolicyFor(stream.Context(), false, user, ad.UserObject, "", r.GetPurge())
+			if dryRun != nil {
+				// Changes are written sequentially to keep them grouped by user.
+				sort.Strings(users)
+				for _, user := range users {
+					if err := s.updatePolicyFor(ctx, false, user, ad.UserObject, "", r.GetPurge(), dryRun); err != nil {
+						return fmt.Errorf("one or more error for updating all users: %w", err)
+					}
+				}
+			} else {
+				// Compile dconf user databases only once for all users.
+				err = s.policyManager.BatchUserUpdates(ctx, func(ctx context.Context) error {
+					errg := new(errgroup.Group)
+					for _, user := range users {
+						user := user
+						errg.Go(func() (err error) {
+							return s.updatePolicyFor(ctx, false, user, ad.UserObject, "", r.GetPurge(), nil)
+						})
+					}
+					return errg.Wait()
 				})
-			}
-			if err := errg.Wait(); err != nil {
-				return fmt.Errorf("one or more error for updating all users: %w", err)
+				if err != nil {
+					return fmt.Errorf("one or more error for updating all users: %w", err)
+				}
 			}
 		}
 
 		return err
 	}
 	// Update a single user
-	return s.updatePolicyFor(stream.Context(), r.GetIsComputer(), target, objectClass, r.Krb5Cc, r.GetPurge())
+	var getOpts []ad.GetPoliciesOption
+	if r.GetFallbackToMachine() {
+		getOpts = append(getOpts, ad.WithMachineCredentialsFallback())
+	}
+	if r.GetAtLogin() && !r.GetIsComputer() && !r.GetPurge() && dryRun == nil {
+		return s.updatePolicyAtLogin(ctx, target, r.Krb5Cc, getOpts...)
+	}
+	return s.updatePolicyFor(ctx, r.GetIsComputer(), target, objectClass, r.Krb5Cc, r.GetPurge(), dryRun, getOpts...)
 }
 
-// updatePolicyFor updates the policy for a given object.
-func (s *Service) updatePolicyFor(ctx context.Context, isComputer bool, target string, objectClass ad.ObjectClass, krb5cc string, purge bool) (err error) {
-	var pols policies.Policies
-	if !purge {
-		pols, err = s.adc.GetPolicies(ctx, target, objectClass, krb5cc)
-		if err != nil {
+// purgeDomain purges the policies of all the users of the domain requested by r, then removes the cache of that
+// domain. With dryRun, the changes are written to it instead and the cache is kept.
+func (s *Service) purgeDomain(ctx context.Context, r *adsys.UpdatePolicyRequest, dryRun io.Writer) (err error) {
+	domain := r.GetDomain()
+	if !r.GetPurge() || r.GetIsComputer() || r.GetAll() || r.GetTarget() != "" {
+		return fmt.Errorf(i18n.G("domain %q can only be purged, without any other target"), domain)
+	}
+
+	if err := s.authorizer.IsAllowedFromContext(context.WithValue(ctx, authorizer.OnUserKey, "root"),
+		actions.ActionPolicyUpdate); err != nil {
+		return err
+	}
+
+	cachedUsers, err := s.adc.ListUsers(ctx, false)
+	if err != nil {
+		return err
+	}
+	// Domains are matched case insensitively, and their cache is named after the domain of their users.
+	domains := map[string]struct{}{domain: {}}
+	var users []string
+	for _, user := range cachedUsers {
+		_, userDomain, _ := strings.Cut(user, "@")
+		if !strings.EqualFold(userDomain, domain) {
+			continue
+		}
+		domains[userDomain] = struct{}{}
+		users = append(users, user)
+	}
+	sort.Strings(users)
+	log.Infof(ctx, i18n.G("Purging policies of %d users of domain %s"), len(users), domain)
+
+	if dryRun != nil {
+		for _, user := range users {
+			if err := s.updatePolicyFor(ctx, false, user, ad.UserObject, "", true, dryRun); err != nil {
+				return fmt.Errorf("one or more error for purging users of domain %s: %w", domain, err)
+			}
+		}
+		return nil
+	}
+
+	err = s.policyManager.BatchUserUpdates(ctx, func(ctx context.Context) error {
+		errg := new(errgroup.Group)
+		for _, user := range users {
+			user := user
+			errg.Go(func() (err error) {
+				return s.updatePolicyFor(ctx, false, user, ad.UserObject, "", true, nil)
+			})
+		}
+		return errg.Wait()
+	})
+	if err != nil {
+		return fmt.Errorf("one or more error for purging users of domain %s: %w", domain, err)
+	}
+
+	for d := range domains {
+		if err := s.policyManager.RemoveDomain(ctx, d); err != nil {
 			return err
 		}
 	}
+	return nil
+}
+
+// updatePolicyFor updates the policy for a given object, or purges it without contacting the directory service.
+// With dryRun, the changes are written to it instead.
+// Updates requested while another one of the same object is in progress share its result.
+func (s *Service) updatePolicyFor(ctx context.Context, isComputer bool, target string, objectClass ad.ObjectClass, krb5cc string, purge bool, dryRun io.Writer, getOpts ...ad.GetPoliciesOption) (err error) {
+	if purge && dryRun == nil {
+		return s.policyManager.Purge(ctx, target, isComputer)
+	}
+
+	if dryRun != nil {
+		var pols policies.Policies
+		if !purge {
+			pols, err = s.adc.GetPolicies(ctx, target, objectClass, krb5cc, append(getOpts, ad.WithDryRun())...)
+			if err != nil {
+				return err
+			}
+		}
+		return s.policyManager.ApplyPolicies(ctx, target, isComputer, &pols, policies.WithDryRun(dryRun))
+	}
 
-	return s.policyManager.ApplyPolicies(ctx, target, isComputer, &pols)
+	return s.coalescedRefresh(ctx, target, isComputer, func(ctx context.Context) error {
+		pols, err := s.adc.GetPolicies(ctx, target, objectClass, krb5cc, getOpts...)
+		if err != nil {
+			s.policyManager.ReportRetrievalFailure(ctx, target, isComputer)
+			return err
+		}
+		return s.policyManager.ApplyPolicies(ctx, target, isComputer, &pols)
+	})
 }
 
 // DumpPolicies displays all applied policies for a given user.
@@ -103,7 +264,40 @@ func (s *Service) DumpPolicies(r *adsys.DumpPoliciesRequest, stream adsys.Servic
 		}
 	}
 
-	msg, err := s.policyManager.DumpPolicies(stream.Context(), target, r.GetIsComputer(), r.GetDetails(), r.GetAll())
+	var compareTarget string
+	if r.GetCompareTarget() != "" {
+		compareTarget, err = s.adc.NormalizeTargetName(stream.Context(), r.GetCompareTarget(), objectClass)
+		if err != nil {
+			return err
+		}
+		if compareTarget != s.adc.Hostname() {
+			if err := s.authorizer.IsAllowedFromContext(context.WithValue(stream.Context(), authorizer.OnUserKey, compareTarget),
+				actions.ActionPolicyDump); err != nil {
+				return err
+			}
+		}
+	}
+
+	filter := policies.DumpFilter{
+		Manager:        r.GetManager(),
+		GPO:            r.GetGpo(),
+		OnlyOverridden: r.GetOnlyOverridden(),
+	}
+	if (compareTarget != "" || r.GetCompareExport() != nil) && filter != (policies.DumpFilter{}) {
+		return errors.New(i18n.G("comparisons can't be filtered"))
+	}
+
+	var msg string
+	switch {
+	case compareTarget != "":
+		msg, err = s.policyManager.ComparePolicies(stream.Context(), target, compareTarget, r.GetIsComputer())
+	case r.GetCompareExport() != nil:
+		msg, err = s.policyManager.ComparePoliciesWithExport(stream.Context(), target, r.GetIsComputer(), r.GetCompareExport())
+	case r.GetFormat() == "", r.GetFormat() == "text":
+		msg, err = s.policyManager.DumpPolicies(stream.Context(), target, r.GetIsComputer(), r.GetDetails(), r.GetAll(), filter)
+	default:
+		msg, err = s.policyManager.DumpPoliciesStructured(stream.Context(), target, r.GetIsComputer(), r.GetFormat(), filter)
+	}
 	if err != nil {
 		return err
 	}
@@ -116,6 +310,261 @@ func (s *Service) DumpPolicies(r *adsys.DumpPoliciesRequest, stream adsys.Servic
 	return nil
 }
 
+// SearchPolicies displays the applied policy entries matching the query for a given user or the machine.
+func (s *Service) SearchPolicies(r *adsys.SearchPoliciesRequest, stream adsys.Service_SearchPoliciesServer) (err error) {
+	defer decorate.OnError(&err, i18n.G("error while searching applied policies"))
+
+	objectClass := ad.UserObject
+	if r.GetIsComputer() {
+		objectClass = ad.ComputerObject
+	}
+
+	target, err := s.adc.NormalizeTargetName(stream.Context(), r.GetTarget(), objectClass)
+	if err != nil {
+		return err
+	}
+
+	// hostname policy search is allowed to all users, as for its display
+	if target != s.adc.Hostname() {
+		if err := s.authorizer.IsAllowedFromContext(context.WithValue(stream.Context(), authorizer.OnUserKey, target),
+			actions.ActionPolicyDump); err != nil {
+			return err
+		}
+	}
+
+	msg, err := s.policyManager.SearchPolicies(stream.Context(), target, r.GetIsComputer(), r.GetQuery())
+	if err != nil {
+		return err
+	}
+	if err := stream.Send(&adsys.StringResponse{
+		Msg: msg,
+	}); err != nil {
+		log.Warningf(stream.Context(), "couldn't send matching policies to client: %v", err)
+	}
+
+	return nil
+}
+
+// GetLastApplyStatus displays the status of each policy manager during the last policy apply for a given user or
+// the machine.
+func (s *Service) GetLastApplyStatus(r *adsys.GetLastApplyStatusRequest, stream adsys.Service_GetLastApplyStatusServer) (err error) {
+	defer decorate.OnError(&err, i18n.G("error while getting last policy apply status"))
+
+	objectClass := ad.UserObject
+	if r.GetIsComputer() {
+		objectClass = ad.ComputerObject
+	}
+
+	target, err := s.adc.NormalizeTargetName(stream.Context(), r.GetTarget(), objectClass)
+	if err != nil {
+		return err
+	}
+
+	// hostname policy status is allowed to all users, as for its display
+	if target != s.adc.Hostname() {
+		if err := s.authorizer.IsAllowedFromContext(context.WithValue(stream.Context(), authorizer.OnUserKey, target),
+			actions.ActionPolicyDump); err != nil {
+			return err
+		}
+	}
+
+	var msg string
+	switch r.GetFormat() {
+	case "", "text":
+		msg, err = s.policyManager.LastApplyStatus(stream.Context(), target, r.GetIsComputer())
+	default:
+		msg, err = s.policyManager.LastApplyStatusStructured(stream.Context(), target, r.GetIsComputer(), r.GetFormat())
+	}
+	if err != nil {
+		return err
+	}
+	if err := stream.Send(&adsys.StringResponse{
+		Msg: msg,
+	}); err != nil {
+		log.Warningf(stream.Context(), "couldn't send last policy apply status to client: %v", err)
+	}
+
+	return nil
+}
+
+// DeferLogout postpones the closing of the sessions of a user opened before their policies changed.
+func (s *Service) DeferLogout(r *adsys.DeferLogoutRequest, stream adsys.Service_DeferLogoutServer) (err error) {
+	defer decorate.OnError(&err, i18n.G("error while deferring the closing of sessions"))
+
+	target, err := s.adc.NormalizeTargetName(stream.Context(), r.GetTarget(), ad.UserObject)
+	if err != nil {
+		return err
+	}
+
+	// Users can defer the closing of their own sessions, as they can update their own policies.
+	if err := s.authorizer.IsAllowedFromContext(context.WithValue(stream.Context(), authorizer.OnUserKey, target),
+		actions.ActionPolicyUpdate); err != nil {
+		return err
+	}
+
+	msg, err := s.policyManager.DeferLogout(stream.Context(), target)
+	if err != nil {
+		return err
+	}
+	if err := stream.Send(&adsys.StringResponse{
+		Msg: msg,
+	}); err != nil {
+		log.Warningf(stream.Context(), "couldn't send deferred closing of sessions to client: %v", err)
+	}
+
+	return nil
+}
+
+// WhoHas lists the users receiving a given policy key, and optionally value, in their cached policies.
+func (s *Service) WhoHas(r *adsys.WhoHasRequest, stream adsys.Service_WhoHasServer) (err error) {
+	defer decorate.OnError(&err, i18n.G("error while listing users receiving a policy key"))
+
+	// The policies of all users are displayed.
+	if err := s.authorizer.IsAllowedFromContext(context.WithValue(stream.Context(), authorizer.OnUserKey, "root"),
+		actions.ActionPolicyDump); err != nil {
+		return err
+	}
+
+	msg, err := s.policyManager.WhoHas(stream.Context(), r.GetKey(), r.GetValue())
+	if err != nil {
+		return err
+	}
+	if err := stream.Send(&adsys.StringResponse{
+		Msg: msg,
+	}); err != nil {
+		log.Warningf(stream.Context(), "couldn't send users receiving policy key to client: %v", err)
+	}
+
+	return nil
+}
+
+// Owns lists the files managed by adsys, or reports whether a given file is managed by adsys.
+// The list can be restricted to a policy manager, and be requested in a machine readable format.
+func (s *Service) Owns(r *adsys.OwnsRequest, stream adsys.Service_OwnsServer) (err error) {
+	defer decorate.OnError(&err, i18n.G("error while listing files managed by adsys"))
+
+	// The files of all users are displayed.
+	if err := s.authorizer.IsAllowedFromContext(context.WithValue(stream.Context(), authorizer.OnUserKey, "root"),
+		actions.ActionPolicyDump); err != nil {
+		return err
+	}
+
+	var msg string
+	switch r.GetFormat() {
+	case "", "text":
+		msg, err = s.policyManager.Owns(stream.Context(), r.GetPath(), r.GetManager())
+	default:
+		if r.GetPath() != "" {
+			return errors.New(i18n.G("a single file can only be reported in text format"))
+		}
+		msg, err = s.policyManager.OwnsStructured(stream.Context(), r.GetManager(), r.GetFormat())
+	}
+	if err != nil {
+		return err
+	}
+	if err := stream.Send(&adsys.StringResponse{
+		Msg: msg,
+	}); err != nil {
+		log.Warningf(stream.Context(), "couldn't send files managed by adsys to client: %v", err)
+	}
+
+	return nil
+}
+
+// ReapplyModified applies again, from the cached policies, the policy managers whose files were modified or removed
+// since their last apply, like by a package upgrade.
+func (s *Service) ReapplyModified(_ *adsys.Empty, stream adsys.Service_ReapplyModifiedServer) (err error) {
+	defer decorate.OnError(&err, i18n.G("error while applying again the policies of modified files"))
+
+	// The policies of the machine and of all users can be applied again.
+	if err := s.authorizer.IsAllowedFromContext(context.WithValue(stream.Context(), authorizer.OnUserKey, "root"),
+		actions.ActionPolicyUpdate); err != nil {
+		return err
+	}
+
+	msg, err := s.policyManager.ReapplyModified(stream.Context())
+	if err != nil {
+		return err
+	}
+	if err := stream.Send(&adsys.StringResponse{
+		Msg: msg,
+	}); err != nil {
+		log.Warningf(stream.Context(), "couldn't send policy managers applied again to client: %v", err)
+	}
+
+	return nil
+}
+
+// ReapplyExpired applies again, from the cached policies, the policies of the objects whose entries expired since
+// their last apply, so that the expired entries are reverted.
+func (s *Service) ReapplyExpired(_ *adsys.Empty, stream adsys.Service_ReapplyExpiredServer) (err error) {
+	defer decorate.OnError(&err, i18n.G("error while reverting expired policy entries"))
+
+	// The policies of the machine and of all users can be applied again.
+	if err := s.authorizer.IsAllowedFromContext(context.WithValue(stream.Context(), authorizer.OnUserKey, "root"),
+		actions.ActionPolicyUpdate); err != nil {
+		return err
+	}
+
+	// Users not logged in have their expired entries reverted at their next login.
+	users, err := s.adc.ListUsers(stream.Context(), true)
+	if err != nil {
+		log.Warningf(stream.Context(), i18n.G("Can't list logged in users, only reverting expired entries of the machine: %v"), err)
+	}
+	msg, err := s.policyManager.ReapplyExpired(stream.Context(), users)
+	if err != nil {
+		return err
+	}
+	if err := stream.Send(&adsys.StringResponse{
+		Msg: msg,
+	}); err != nil {
+		log.Warningf(stream.Context(), "couldn't send objects with reverted entries to client: %v", err)
+	}
+
+	return nil
+}
+
+// SimulatePolicy displays the policies which would be applied to a given user or the machine if the GPO of the
+// request was linked with the highest precedence.
+func (s *Service) SimulatePolicy(r *adsys.SimulatePolicyRequest, stream adsys.Service_SimulatePolicyServer) (err error) {
+	defer decorate.OnError(&err, i18n.G("error while simulating policies"))
+
+	objectClass := ad.UserObject
+	if r.GetIsComputer() {
+		objectClass = ad.ComputerObject
+	}
+
+	target, err := s.adc.NormalizeTargetName(stream.Context(), r.GetTarget(), objectClass)
+	if err != nil {
+		return err
+	}
+
+	// hostname policy display is allowed to all users
+	if target != s.adc.Hostname() {
+		if err := s.authorizer.IsAllowedFromContext(context.WithValue(stream.Context(), authorizer.OnUserKey, target),
+			actions.ActionPolicyDump); err != nil {
+			return err
+		}
+	}
+
+	g, err := s.adc.ParseRegistryPolicy(stream.Context(), r.GetGpoID(), r.GetGpoName(), r.GetPolicy())
+	if err != nil {
+		return err
+	}
+
+	msg, err := s.policyManager.SimulatePolicies(stream.Context(), target, g, r.GetAll())
+	if err != nil {
+		return err
+	}
+	if err := stream.Send(&adsys.StringResponse{
+		Msg: msg,
+	}); err != nil {
+		log.Warningf(stream.Context(), "couldn't send simulated policies to client: %v", err)
+	}
+
+	return nil
+}
+
 // DumpPoliciesDefinitions dumps requested policy definitions stored in daemon at build time.
 func (s *Service) DumpPoliciesDefinitions(r *adsys.DumpPolicyDefinitionsRequest, stream adsys.Service_DumpPoliciesDefinitionsServer) (err error) {
 	defer decorate.OnError(&err, i18n.G("error while dumping policy definitions"))
@@ -139,6 +588,51 @@ func (s *Service) DumpPoliciesDefinitions(r *adsys.DumpPolicyDefinitionsRequest,
 	return nil
 }
 
+// ListPolicyKeys lists the policy keys supported by the daemon, with the manager consuming them, as stored in the
+// policy definitions at build time.
+func (s *Service) ListPolicyKeys(r *adsys.ListPolicyKeysRequest, stream adsys.Service_ListPolicyKeysServer) (err error) {
+	defer decorate.OnError(&err, i18n.G("error while listing policy keys"))
+
+	if err := s.authorizer.IsAllowedFromContext(stream.Context(), authorizer.ActionAlwaysAllowed); err != nil {
+		return err
+	}
+
+	keys, err := ad.GetPolicyKeys(stream.Context(), r.GetDistroID())
+	if err != nil {
+		return err
+	}
+
+	var out strings.Builder
+	w := tabwriter.NewWriter(&out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, i18n.G("MANAGER\tKEY\tCLASS\tTYPE\tRELEASES"))
+	var found bool
+	for _, k := range keys {
+		if r.GetManager() != "" && k.Manager != r.GetManager() {
+			continue
+		}
+		found = true
+		releases := strings.Join(k.Releases, ", ")
+		if releases == "" {
+			releases = i18n.G("all")
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", k.Manager, k.Key, k.Class, k.Type, releases)
+	}
+	if !found {
+		return fmt.Errorf(i18n.G("no policy key is handled by manager %q"), r.GetManager())
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	if err := stream.Send(&adsys.StringResponse{
+		Msg: out.String(),
+	}); err != nil {
+		log.Warningf(stream.Context(), "couldn't send policy keys to client: %v", err)
+	}
+
+	return nil
+}
+
 // GPOListScript returns the embedded GPO python list script.
 func (s *Service) GPOListScript(_ *adsys.Empty, stream adsys.Service_GPOListScriptServer) (err error) {
 	defer decorate.OnError(&err, i18n.G("error while getting gpo list script"))
@@ -0,0 +1,76 @@
+package adsysservice
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/ubuntu/adsys/internal/ad"
+	log "github.com/ubuntu/adsys/internal/grpc/logstreamer"
+	"github.com/ubuntu/adsys/internal/i18n"
+	"github.com/ubuntu/adsys/internal/policies"
+	"github.com/ubuntu/decorate"
+)
+
+// Provision downloads and applies the policies of the machine, then of each user of users, for instance when building
+// a golden image. The users policies are downloaded with the machine credentials, as users have no ticket yet: their
+// first login then starts with the provisioned policies while they are refreshed.
+// The policy managers and the state specific to the machine, like the rotation of its local administrator password or
+// the values generated for its policies, are skipped and reset, so that each clone gets its own on its first apply.
+// All users are provisioned even if some fail. It returns the provisioned objects.
+func (s *Service) Provision(ctx context.Context, users []string) (msg string, err error) {
+	defer decorate.OnError(&err, i18n.G("can't provision policies"))
+
+	hostname := s.adc.Hostname()
+	log.Infof(ctx, i18n.G("Provisioning policies of %s and %d users"), hostname, len(users))
+
+	// The policies specific to each machine, like its local administrator password, are left to the clones.
+	pols, err := s.adc.GetPolicies(ctx, hostname, ad.ComputerObject, "")
+	if err != nil {
+		return "", err
+	}
+	if err := s.policyManager.ApplyPolicies(ctx, hostname, true, &pols, policies.WhenProvisioning()); err != nil {
+		return "", err
+	}
+	out := []string{fmt.Sprintf(i18n.G("Provisioned machine policies of %s"), hostname)}
+
+	targets := make([]string, len(users))
+	errs := make([]error, len(users))
+	// Compile dconf user databases only once for all users.
+	_ = s.policyManager.BatchUserUpdates(ctx, func(ctx context.Context) error {
+		var wg sync.WaitGroup
+		for i, user := range users {
+			i, user := i, user
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				targets[i], errs[i] = s.adc.NormalizeTargetName(ctx, user, ad.UserObject)
+				if errs[i] != nil {
+					return
+				}
+				errs[i] = s.updatePolicyFor(ctx, false, targets[i], ad.UserObject, "", false, nil, ad.WithMachineCredentialsFallback())
+			}()
+		}
+		wg.Wait()
+		return nil
+	})
+
+	for i, user := range users {
+		if errs[i] != nil {
+			errs[i] = fmt.Errorf(i18n.G("user %q: %w"), user, errs[i])
+			continue
+		}
+		out = append(out, fmt.Sprintf(i18n.G("Provisioned policies of %s"), targets[i]))
+	}
+	if err := errors.Join(errs...); err != nil {
+		return "", err
+	}
+
+	if err := s.policyManager.ResetMachineIdentity(ctx); err != nil {
+		return "", err
+	}
+
+	return strings.Join(out, "\n") + "\n", nil
+}
@@ -0,0 +1,170 @@
+package adsysservice
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/ubuntu/adsys/internal/ad"
+	"github.com/ubuntu/adsys/internal/detachedctx"
+	log "github.com/ubuntu/adsys/internal/grpc/logstreamer"
+	"github.com/ubuntu/adsys/internal/i18n"
+	"github.com/ubuntu/adsys/internal/policies"
+)
+
+// handoverRefreshesBaseName is the run directory where the policy refreshes in progress are recorded, so that the
+// next instance of the daemon completes the ones this instance could not, like when it is killed while stopping
+// during a package upgrade.
+const handoverRefreshesBaseName = "refreshes"
+
+// sharedRefresh is a policy refresh, whose result is returned to every request waiting for it.
+type sharedRefresh struct {
+	// ctx is the context of the request which created the refresh, which receives its logs.
+	ctx context.Context
+	// refresh is the refresh to run. Requests joining the refresh before it starts replace it with their own, so
+	// that it runs with the latest arguments, like a renewed ticket.
+	refresh func(context.Context) error
+	started bool
+	// next is the follow-up refresh queued by the requests arriving once this one started.
+	next *sharedRefresh
+
+	done chan struct{}
+	err  error
+}
+
+// coalescedRefresh runs refresh for the policies of target, unless a refresh of the same object is pending, like when
+// the boot, a timer and an administrator request it at the same time: the request then waits for the pending refresh
+// and returns its result instead of queueing a redundant one.
+// A refresh which already started fetching the policies may miss the changes which triggered the request: a single
+// follow-up refresh, with the arguments of the latest request, is then queued to run once it completes.
+// A refresh runs until completion, even if the requests waiting for it are canceled. Its logs are only streamed to
+// the request which created it.
+func (s *Service) coalescedRefresh(ctx context.Context, target string, isComputer bool, refresh func(context.Context) error) error {
+	key := fmt.Sprintf("%s/%t", target, isComputer)
+
+	s.refreshesMu.Lock()
+	var r *sharedRefresh
+	current, inProgress := s.refreshes[key]
+	switch {
+	case !inProgress:
+		r = &sharedRefresh{ctx: ctx, refresh: refresh, done: make(chan struct{})}
+		s.refreshes[key] = r
+		s.runRefresh(key, target, isComputer, r)
+	case !current.started:
+		r = current
+		r.refresh = refresh
+		log.Infof(ctx, i18n.G("A policy refresh of %q is about to start: waiting for its result"), target)
+	case current.next != nil:
+		r = current.next
+		r.refresh = refresh
+		log.Infof(ctx, i18n.G("A policy refresh of %q is already queued: waiting for its result"), target)
+	default:
+		r = &sharedRefresh{ctx: ctx, refresh: refresh, done: make(chan struct{})}
+		current.next = r
+		log.Infof(ctx, i18n.G("A policy refresh of %q is already in progress: queueing another one once it completes"), target)
+	}
+	s.refreshesMu.Unlock()
+
+	select {
+	case <-r.done:
+		return r.err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// runRefresh runs the refresh r of key in the background, then its follow-up refresh, if any was queued meanwhile.
+// refreshesMu must be held.
+func (s *Service) runRefresh(key, target string, isComputer bool, r *sharedRefresh) {
+	s.backgroundUpdates.Add(1)
+	go func() {
+		defer s.backgroundUpdates.Done()
+
+		s.refreshesMu.Lock()
+		r.started = true
+		refresh := r.refresh
+		s.refreshesMu.Unlock()
+
+		s.recordRefresh(r.ctx, target, isComputer)
+		err := refresh(detachedctx.New(r.ctx))
+
+		s.refreshesMu.Lock()
+		if r.next != nil {
+			s.refreshes[key] = r.next
+			s.runRefresh(key, target, isComputer, r.next)
+		} else {
+			s.forgetRefresh(r.ctx, target)
+			delete(s.refreshes, key)
+		}
+		s.refreshesMu.Unlock()
+		r.err = err
+		close(r.done)
+	}()
+}
+
+// recordRefresh records that a refresh of target is in progress, for the next instance of the daemon.
+func (s *Service) recordRefresh(ctx context.Context, target string, isComputer bool) {
+	if err := os.MkdirAll(s.refreshesDir, 0700); err != nil {
+		log.Warningf(ctx, i18n.G("Can't record policy refresh of %q in progress: %v"), target, err)
+		return
+	}
+	if err := os.WriteFile(filepath.Join(s.refreshesDir, target), []byte(strconv.FormatBool(isComputer)), 0600); err != nil {
+		log.Warningf(ctx, i18n.G("Can't record policy refresh of %q in progress: %v"), target, err)
+	}
+}
+
+// forgetRefresh removes the record of the refresh of target once it is done.
+func (s *Service) forgetRefresh(ctx context.Context, target string) {
+	if err := os.Remove(filepath.Join(s.refreshesDir, target)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		log.Warningf(ctx, i18n.G("Can't remove record of policy refresh of %q: %v"), target, err)
+	}
+}
+
+// resumeHandedOverRefreshes starts again in the background the refreshes that the previous instance of the daemon
+// did not complete. Users are refreshed with the ticket they logged in with, like when refreshing all of them.
+func (s *Service) resumeHandedOverRefreshes(ctx context.Context) {
+	entries, err := os.ReadDir(s.refreshesDir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return
+	} else if err != nil {
+		log.Warningf(ctx, i18n.G("Can't list policy refreshes handed over by the previous daemon: %v"), err)
+		return
+	}
+
+	for _, e := range entries {
+		target := e.Name()
+		d, err := os.ReadFile(filepath.Join(s.refreshesDir, target))
+		if err != nil {
+			log.Warningf(ctx, i18n.G("Can't read policy refresh of %q handed over by the previous daemon: %v"), target, err)
+			continue
+		}
+		isComputer, err := strconv.ParseBool(string(d))
+		if err != nil {
+			log.Warningf(ctx, i18n.G("Invalid policy refresh of %q handed over by the previous daemon: %v"), target, err)
+			s.forgetRefresh(ctx, target)
+			continue
+		}
+		if until, frozen := s.policyManager.FrozenUntil(ctx); frozen {
+			log.Warningf(ctx, i18n.G("Policy updates are frozen %s: skipping update of %q"), policies.DescribeFreeze(until), target)
+			s.forgetRefresh(ctx, target)
+			continue
+		}
+
+		objectClass := ad.UserObject
+		if isComputer {
+			objectClass = ad.ComputerObject
+		}
+		log.Infof(ctx, i18n.G("Completing policy refresh of %q interrupted by the daemon restart"), target)
+		s.backgroundUpdates.Add(1)
+		go func() {
+			defer s.backgroundUpdates.Done()
+			if err := s.updatePolicyFor(ctx, isComputer, target, objectClass, "", false, nil); err != nil {
+				log.Warningf(ctx, i18n.G("Policy refresh of %q interrupted by the daemon restart failed: %v"), target, err)
+			}
+		}()
+	}
+}
@@ -0,0 +1,61 @@
+package adsysservice_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/ubuntu/adsys/internal/adsysservice"
+)
+
+func TestCoalescedRefresh(t *testing.T) {
+	t.Parallel()
+
+	s := adsysservice.NewWithRefreshesDir(t.TempDir())
+
+	var mu sync.Mutex
+	var ran []string
+	started, release := make(chan struct{}), make(chan struct{})
+	refresh := func(name string, err error) func(context.Context) error {
+		return func(context.Context) error {
+			mu.Lock()
+			ran = append(ran, name)
+			mu.Unlock()
+			if name == "first" {
+				close(started)
+				<-release
+			}
+			return err
+		}
+	}
+
+	errs := make(map[string]chan error)
+	request := func(name string, err error) {
+		ch := make(chan error, 1)
+		errs[name] = ch
+		go func() { ch <- s.CoalescedRefresh(context.Background(), "user@example.com", false, refresh(name, err)) }()
+	}
+
+	request("first", nil)
+	<-started
+	// Requests arriving once the refresh started queue a single follow-up refresh with the latest arguments.
+	request("second", errors.New("second error"))
+	time.Sleep(100 * time.Millisecond)
+	request("third", errors.New("third error"))
+	time.Sleep(100 * time.Millisecond)
+	close(release)
+
+	require.NoError(t, <-errs["first"], "CoalescedRefresh should return the result of the first refresh")
+	require.ErrorContains(t, <-errs["second"], "third error", "CoalescedRefresh should return the result of the follow-up refresh")
+	require.ErrorContains(t, <-errs["third"], "third error", "CoalescedRefresh should return the result of the follow-up refresh")
+	s.WaitBackgroundUpdates()
+	require.Equal(t, []string{"first", "third"}, ran, "CoalescedRefresh should run the first refresh and a single follow-up with the latest arguments")
+
+	// Once all refreshes completed, a new request runs a new refresh.
+	ran = nil
+	require.NoError(t, s.CoalescedRefresh(context.Background(), "user@example.com", false, refresh("fourth", nil)), "CoalescedRefresh should return no error")
+	require.Equal(t, []string{"fourth"}, ran, "CoalescedRefresh should run a new refresh")
+}
@@ -0,0 +1,76 @@
+package adsysservice
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/ubuntu/adsys"
+	log "github.com/ubuntu/adsys/internal/grpc/logstreamer"
+	"github.com/ubuntu/adsys/internal/i18n"
+	"github.com/ubuntu/decorate"
+	"google.golang.org/grpc/peer"
+)
+
+// LimitScript confines a user script process, started by the caller, to the runtime allowed for the user scripts.
+// The limit is enforced by systemd, so that the user can't raise it nor escape it.
+func (s *Service) LimitScript(r *adsys.LimitScriptRequest, stream adsys.Service_LimitScriptServer) (err error) {
+	defer decorate.OnError(&err, i18n.G("error while limiting the runtime of script process %d"), r.GetPid())
+
+	p, ok := peer.FromContext(stream.Context())
+	if !ok {
+		return errors.New(i18n.G("no peer credentials in request"))
+	}
+	creds, ok := p.AuthInfo.(interface {
+		UID() uint32
+		PID() int32
+	})
+	if !ok {
+		return errors.New(i18n.G("request peer credentials are not unix ones"))
+	}
+
+	// Users can only confine the scripts they started, with their own time limit.
+	if err := checkChildProcess("/", r.GetPid(), uint32(creds.PID()), creds.UID()); err != nil {
+		return err
+	}
+
+	log.Debugf(stream.Context(), "Limiting runtime of script process %d of user %d", r.GetPid(), creds.UID())
+	return s.policyManager.LimitUserScript(stream.Context(), creds.UID(), r.GetPid())
+}
+
+// checkChildProcess returns an error if the process pid, read from the proc filesystem under root, isn't a child of
+// the process ppid owned by uid.
+func checkChildProcess(root string, pid, ppid, uid uint32) (err error) {
+	defer decorate.OnError(&err, i18n.G("process %d is not a child of the caller"), pid)
+
+	procDir := filepath.Join(root, "proc", strconv.FormatUint(uint64(pid), 10))
+	info, err := os.Stat(procDir)
+	if err != nil {
+		return err
+	}
+	if stat, ok := info.Sys().(*syscall.Stat_t); !ok || stat.Uid != uid {
+		return fmt.Errorf(i18n.G("process is not owned by user %d"), uid)
+	}
+
+	data, err := os.ReadFile(filepath.Join(procDir, "stat"))
+	if err != nil {
+		return err
+	}
+	// The parent pid is the second field after the process name, which is the only one which can contain ')'.
+	idx := strings.LastIndexByte(string(data), ')')
+	if idx < 0 {
+		return errors.New(i18n.G("parsing error: missing )"))
+	}
+	fields := strings.Fields(string(data[idx+1:]))
+	if len(fields) < 2 {
+		return errors.New(i18n.G("parsing error: less fields than required"))
+	}
+	if fields[1] != strconv.FormatUint(uint64(ppid), 10) {
+		return fmt.Errorf(i18n.G("parent process is %s"), fields[1])
+	}
+	return nil
+}
@@ -0,0 +1,56 @@
+package adsysservice_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/ubuntu/adsys/internal/adsysservice"
+)
+
+func TestCheckChildProcess(t *testing.T) {
+	t.Parallel()
+
+	uid := uint32(os.Getuid())
+
+	tests := map[string]struct {
+		stat string
+		ppid uint32
+		uid  uint32
+
+		wantErr bool
+	}{
+		"Child of the caller":                        {stat: "42 (sh) S 41 42 41 0 -1"},
+		"Child of the caller with ) in process name": {stat: "42 (a) S 1 (b) S 41 42 41 0 -1"},
+
+		// Error cases
+		"Error on process not started by the caller": {stat: "42 (sh) S 1 42 41 0 -1", wantErr: true},
+		"Error on process of another user":           {stat: "42 (sh) S 41 42 41 0 -1", uid: uid + 1, wantErr: true},
+		"Error on missing process":                   {wantErr: true},
+		"Error on invalid stat file":                 {stat: "42 sh S 41", wantErr: true},
+		"Error on truncated stat file":               {stat: "42 (sh) S", wantErr: true},
+	}
+	for name, tc := range tests {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			root := t.TempDir()
+			if tc.stat != "" {
+				require.NoError(t, os.MkdirAll(filepath.Join(root, "proc", "42"), 0700), "Setup: can't create process directory")
+				require.NoError(t, os.WriteFile(filepath.Join(root, "proc", "42", "stat"), []byte(tc.stat), 0600), "Setup: can't write process stat file")
+			}
+			if tc.uid == 0 {
+				tc.uid = uid
+			}
+
+			err := adsysservice.CheckChildProcess(root, 42, 41, tc.uid)
+			if tc.wantErr {
+				require.Error(t, err, "CheckChildProcess should have failed but didn't")
+				return
+			}
+			require.NoError(t, err, "CheckChildProcess should have accepted the child process but didn't")
+		})
+	}
+}
@@ -1,8 +1,10 @@
 package adsysservice
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"path/filepath"
 	"strings"
 	"time"
 
@@ -102,12 +104,15 @@ func (s *Service) Status(_ *adsys.Empty, stream adsys.Service_StatusServer) (err
 	} else {
 		log.Warning(stream.Context(), err)
 	}
+	if until, frozen := s.policyManager.FrozenUntil(stream.Context()); frozen {
+		nextRefresh = fmt.Sprintf(i18n.G("%s (policy updates frozen %s)"), nextRefresh, policies.DescribeFreeze(until))
+	}
 
 	updateFmt := i18n.G("%s, updated on %s")
 	updateMachine := i18n.G("Machine, no gpo applied found")
 	t, err := s.policyManager.LastUpdateFor(stream.Context(), "", true)
 	if err == nil {
-		updateMachine = fmt.Sprintf(updateFmt, i18n.G("Machine"), t.Format(timeLayout))
+		updateMachine = fmt.Sprintf(updateFmt, i18n.G("Machine"), t.Format(timeLayout)) + s.unhandledStatus(stream.Context(), "", true)
 	}
 
 	updateUsers := fmt.Sprint(i18n.G("Can't get connected users"))
@@ -116,7 +121,7 @@ func (s *Service) Status(_ *adsys.Empty, stream adsys.Service_StatusServer) (err
 		updateUsers = fmt.Sprint(i18n.G("Connected users:"))
 		for _, u := range users {
 			if t, err := s.policyManager.LastUpdateFor(stream.Context(), u, false); err == nil {
-				updateUsers = updateUsers + "\n  " + fmt.Sprintf(updateFmt, u, t.Format(timeLayout))
+				updateUsers = updateUsers + "\n  " + fmt.Sprintf(updateFmt, u, t.Format(timeLayout)) + s.unhandledStatus(stream.Context(), u, false)
 			} else {
 				updateUsers = updateUsers + "\n  " + fmt.Sprintf(i18n.G("%s, no gpo applied found"), u)
 			}
@@ -168,6 +173,20 @@ Daemon:
 	return nil
 }
 
+// unhandledStatus returns the number of entries of objectName handled by no policy manager nor plugin, to append to
+// its status line, or an empty string if there is none.
+func (s *Service) unhandledStatus(ctx context.Context, objectName string, isMachine bool) string {
+	n, err := s.policyManager.UnhandledEntriesCount(objectName, isMachine)
+	if err != nil {
+		log.Warning(ctx, err)
+		return ""
+	}
+	if n == 0 {
+		return ""
+	}
+	return fmt.Sprintf(i18n.G(", %d entries not handled (see %s)"), n, filepath.Join(s.state.runDir, policies.UnhandledEntriesFileName))
+}
+
 // Stop requests to stop the service once all connections are done. Force will shut it down immediately and drop
 // existing connections.
 func (s *Service) Stop(r *adsys.StopRequest, stream adsys.Service_StopServer) (err error) {
@@ -181,6 +200,33 @@ func (s *Service) Stop(r *adsys.StopRequest, stream adsys.Service_StopServer) (e
 	return nil
 }
 
+// Prune rotates the logs written by the service over their maximum size, or all of them if requested, then removes
+// the rotated logs older than their maximum age. It returns a summary of the disk space freed.
+func (s *Service) Prune(r *adsys.PruneRequest, stream adsys.Service_PruneServer) (err error) {
+	defer decorate.OnError(&err, i18n.G("error while pruning logs"))
+
+	if err := s.authorizer.IsAllowedFromContext(stream.Context(), actions.ActionServiceManage); err != nil {
+		return err
+	}
+
+	pruned, err := s.auditLog.Prune(stream.Context(), r.GetRotate())
+	if err != nil {
+		return err
+	}
+
+	var msg strings.Builder
+	for _, p := range pruned.Files {
+		fmt.Fprintf(&msg, i18n.G("Removed %s\n"), p)
+	}
+	fmt.Fprintf(&msg, i18n.G("Freed %d KiB"), pruned.Size>>10)
+	if err := stream.Send(&adsys.StringResponse{
+		Msg: msg.String(),
+	}); err != nil {
+		log.Warningf(stream.Context(), "couldn't send prune summary to client: %v", err)
+	}
+	return nil
+}
+
 // ListUsers returns the list of currently active users.
 func (s *Service) ListUsers(r *adsys.ListUsersRequest, stream adsys.Service_ListUsersServer) (err error) {
 	defer decorate.OnError(&err, i18n.G("error while trying to get the list of active users"))
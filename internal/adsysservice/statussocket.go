@@ -0,0 +1,99 @@
+package adsysservice
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/sirupsen/logrus"
+	"github.com/ubuntu/adsys"
+	"github.com/ubuntu/adsys/internal/authorizer"
+	"github.com/ubuntu/adsys/internal/daemon"
+	"github.com/ubuntu/adsys/internal/grpc/auditlog"
+	"github.com/ubuntu/adsys/internal/grpc/connectionnotify"
+	"github.com/ubuntu/adsys/internal/grpc/interceptorschain"
+	"github.com/ubuntu/adsys/internal/grpc/logconnections"
+	log "github.com/ubuntu/adsys/internal/grpc/logstreamer"
+	"github.com/ubuntu/adsys/internal/i18n"
+	"golang.org/x/exp/slices"
+	"google.golang.org/grpc"
+)
+
+// statusSocketMethods are the read-only requests served on the status socket.
+var statusSocketMethods = []string{
+	adsys.Service_Status_FullMethodName,
+	adsys.Service_Version_FullMethodName,
+	adsys.Service_DumpPolicies_FullMethodName,
+	adsys.Service_GetLastApplyStatus_FullMethodName,
+}
+
+type statusSocketKey struct{}
+
+// RegisterStatusGRPCServer registers our service, restricted to read-only requests, for the status socket.
+// Monitoring agents connecting to it are not checked by polkit: access is granted by the socket permissions.
+func (s *Service) RegisterStatusGRPCServer(d *daemon.Daemon) *grpc.Server {
+	srv := grpc.NewServer(grpc.StreamInterceptor(
+		interceptorschain.StreamServer(
+			log.StreamServerInterceptor(logrus.StandardLogger()),
+			connectionnotify.StreamServerInterceptor(d),
+			logconnections.StreamServerInterceptor(),
+			auditlog.StreamServerInterceptor(s.auditLogger),
+			statusSocketStreamServerInterceptor,
+		)), authorizer.WithUnixPeerCreds())
+	adsys.RegisterServiceServer(srv, s)
+	return srv
+}
+
+// statusSocketStreamServerInterceptor rejects any request which is not read-only and marks the others as
+// received on the status socket.
+func statusSocketStreamServerInterceptor(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	if !slices.Contains(statusSocketMethods, info.FullMethod) {
+		return fmt.Errorf(i18n.G("permission denied: %s is not available on the status socket"), info.FullMethod)
+	}
+	return handler(srv, statusServerStream{
+		ServerStream: ss,
+		ctx:          context.WithValue(ss.Context(), statusSocketKey{}, true),
+	})
+}
+
+type statusServerStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (ss statusServerStream) Context() context.Context {
+	return ss.ctx
+}
+
+// RecvMsg restricts the applied policies to their summary: entry values are only available on the main socket.
+func (ss statusServerStream) RecvMsg(m interface{}) error {
+	if err := ss.ServerStream.RecvMsg(m); err != nil {
+		return err
+	}
+	if r, ok := m.(*adsys.DumpPoliciesRequest); ok {
+		r.Details = false
+		r.All = false
+		r.Format = ""
+		r.CompareTarget = ""
+		r.CompareExport = nil
+	}
+	return nil
+}
+
+// statusSocketAuthorizer allows any request received on the status socket, and checks the others with authorizer.
+type statusSocketAuthorizer struct {
+	authorizer authorizerer
+}
+
+// IsAllowedFromContext returns nil if the request was received on the status socket or if authorizer allows it.
+func (a statusSocketAuthorizer) IsAllowedFromContext(ctx context.Context, action authorizer.Action) error {
+	if onStatusSocket, _ := ctx.Value(statusSocketKey{}).(bool); onStatusSocket {
+		auditlog.RecordAuthorization(ctx, "status-socket", nil)
+		return nil
+	}
+	return a.authorizer.IsAllowedFromContext(ctx, action)
+}
+
+// IsSenderAllowed checks D-Bus clients with authorizer, as they don't use the status socket.
+func (a statusSocketAuthorizer) IsSenderAllowed(ctx context.Context, action authorizer.Action, sender string, uid uint32) error {
+	return a.authorizer.IsSenderAllowed(ctx, action, sender, uid)
+}
@@ -15,6 +15,7 @@ import (
 	"strings"
 
 	"github.com/godbus/dbus/v5"
+	"github.com/ubuntu/adsys/internal/grpc/auditlog"
 	log "github.com/ubuntu/adsys/internal/grpc/logstreamer"
 	"github.com/ubuntu/adsys/internal/i18n"
 	"github.com/ubuntu/decorate"
@@ -142,48 +143,80 @@ func (a Authorizer) IsAllowedFromContext(ctx context.Context, action Action) (er
 	return a.isAllowed(ctx, action, pci.pid, pci.uid, actionUID)
 }
 
+// IsSenderAllowed returns nil if the D-Bus client sender, running as uid, is allowed to perform an operation.
+// As the client doesn't wait for an authentication, the user is never asked to authenticate.
+// action can't be an action turning to a "self" or an "other" action.
+func (a Authorizer) IsSenderAllowed(ctx context.Context, action Action, sender string, uid uint32) (err error) {
+	defer decorate.OnError(&err, i18n.G("permission denied"))
+
+	return a.authorize(ctx, action, uid, uid, 0, func() (authSubject, error) {
+		return authSubject{
+			Kind:    "system-bus-name",
+			Details: map[string]dbus.Variant{"name": dbus.MakeVariant(sender)},
+		}, nil
+	})
+}
+
 // isAllowed returns nil if the user is allowed to perform an operation.
 // ActionUID is only used for ActionUserWrite which will be converted to corresponding polkit action
 // (self or others).
-func (a Authorizer) isAllowed(ctx context.Context, action Action, pid int32, uid uint32, actionUID uint32) error {
-	if uid == 0 {
-		log.Debug(ctx, i18n.G("Authorized as being administrator"))
-		return nil
-	} else if action == ActionAlwaysAllowed {
-		log.Debug(ctx, i18n.G("Any user always authorized"))
-		return nil
-	} else if action.SelfID != "" {
+func (a Authorizer) isAllowed(ctx context.Context, action Action, pid int32, uid uint32, actionUID uint32) (err error) {
+	return a.authorize(ctx, action, uid, actionUID, checkAllowInteraction, func() (authSubject, error) {
+		f, err := os.Open(filepath.Join(a.root, fmt.Sprintf("proc/%d/stat", pid)))
+		if err != nil {
+			return authSubject{}, fmt.Errorf(i18n.G("couldn't open stat file for process: %v"), err)
+		}
+		defer decorate.LogFuncOnErrorContext(ctx, f.Close)
+
+		startTime, err := getStartTimeFromReader(f)
+		if err != nil {
+			return authSubject{}, err
+		}
+
+		return authSubject{
+			Kind: "unix-process",
+			Details: map[string]dbus.Variant{
+				"pid":        dbus.MakeVariant(uint32(pid)), // polkit requests an uint32 on dbus
+				"start-time": dbus.MakeVariant(startTime),
+				"uid":        dbus.MakeVariant(uid),
+			},
+		}, nil
+	})
+}
+
+// authorize returns nil if the subject, running as uid, is allowed to perform action, asking polkit with flags.
+// ActionUID converts a user action to the corresponding "self" or "other" polkit action.
+func (a Authorizer) authorize(ctx context.Context, action Action, uid, actionUID uint32, flags polkitCheckFlags, subject func() (authSubject, error)) (err error) {
+	if action.SelfID != "" {
 		action.ID = action.OtherID
 		if actionUID == uid {
 			action.ID = action.SelfID
 		}
 	}
+	defer func() { auditlog.RecordAuthorization(ctx, action.ID, err) }()
 
-	f, err := os.Open(filepath.Join(a.root, fmt.Sprintf("proc/%d/stat", pid)))
-	if err != nil {
-		return fmt.Errorf(i18n.G("couldn't open stat file for process: %v"), err)
+	if uid == 0 {
+		log.Debug(ctx, i18n.G("Authorized as being administrator"))
+		return nil
+	} else if action == ActionAlwaysAllowed {
+		log.Debug(ctx, i18n.G("Any user always authorized"))
+		return nil
 	}
-	defer decorate.LogFuncOnErrorContext(ctx, f.Close)
 
-	startTime, err := getStartTimeFromReader(f)
+	sub, err := subject()
 	if err != nil {
 		return err
 	}
 
-	subject := authSubject{
-		Kind: "unix-process",
-		Details: map[string]dbus.Variant{
-			"pid":        dbus.MakeVariant(uint32(pid)), // polkit requests an uint32 on dbus
-			"start-time": dbus.MakeVariant(startTime),
-			"uid":        dbus.MakeVariant(uid),
-		},
+	dbusFlags := dbus.Flags(0)
+	if flags&checkAllowInteraction != 0 {
+		dbusFlags = dbus.FlagAllowInteractiveAuthorization
 	}
-
 	var result authResult
 	var details map[string]string
 	err = a.authority.Call(
-		"org.freedesktop.PolicyKit1.Authority.CheckAuthorization", dbus.FlagAllowInteractiveAuthorization,
-		subject, action.ID, details, checkAllowInteraction, "").Store(&result)
+		"org.freedesktop.PolicyKit1.Authority.CheckAuthorization", dbusFlags,
+		sub, action.ID, details, flags, "").Store(&result)
 	if err != nil {
 		return fmt.Errorf(i18n.G("call to polkit failed: %v"), err)
 	}
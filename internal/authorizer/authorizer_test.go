@@ -90,6 +90,44 @@ func TestIsAllowedFromContext(t *testing.T) {
 	}
 }
 
+func TestIsSenderAllowed(t *testing.T) {
+	t.Parallel()
+
+	bus := testutils.NewDbusConn(t)
+
+	tests := map[string]struct {
+		uid uint32
+
+		wantAuthorized  bool
+		wantPolkitError bool
+	}{
+		"Root is always authorized": {uid: 0, wantAuthorized: true},
+		"Sender and ACK":            {uid: 1000, wantAuthorized: true},
+		"Sender and NACK":           {uid: 1000, wantAuthorized: false},
+
+		// Unauthorized cases
+		"Unauthorizes when polkit returns an error": {uid: 1000, wantPolkitError: true, wantAuthorized: false},
+	}
+	for name, tc := range tests {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			d := &authorizer.DbusMock{
+				IsAuthorized:    tc.wantAuthorized,
+				WantPolkitError: tc.wantPolkitError}
+			a, err := authorizer.New(bus, authorizer.WithAuthority(d))
+			if err != nil {
+				t.Fatalf("Failed to create authorizer: %v", err)
+			}
+
+			errAllowed := a.IsSenderAllowed(context.Background(), authorizer.Action{ID: "simpleAction"}, ":1.42", tc.uid)
+
+			assert.Equal(t, tc.wantAuthorized, errAllowed == nil, "IsSenderAllowed returned state match expectations")
+		})
+	}
+}
+
 func TestIsAllowedFromContextWithoutPeer(t *testing.T) {
 	t.Parallel()
 	bus := testutils.NewDbusConn(t)
@@ -95,6 +95,18 @@ func TestPeerCredsInfoAuthType(t *testing.T) {
 	}
 	assert.Equal(t, "uid: 11111, pid: 22222", p.AuthType(), "AuthType returns expected uid and pid")
 }
+
+func TestPeerCredsInfoUIDAndPID(t *testing.T) {
+	t.Parallel()
+
+	p := peerCredsInfo{
+		uid: 11111,
+		pid: 22222,
+	}
+	assert.Equal(t, uint32(11111), p.UID(), "UID returns expected uid")
+	assert.Equal(t, int32(22222), p.PID(), "PID returns expected pid")
+}
+
 func TestServerPeerCredsHandshake(t *testing.T) {
 	t.Parallel()
 
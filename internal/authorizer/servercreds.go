@@ -71,3 +71,13 @@ type peerCredsInfo struct {
 func (p peerCredsInfo) AuthType() string {
 	return fmt.Sprintf("uid: %d, pid: %d", p.uid, p.pid)
 }
+
+// UID returns the uid of the caller.
+func (p peerCredsInfo) UID() uint32 {
+	return p.uid
+}
+
+// PID returns the pid of the caller.
+func (p peerCredsInfo) PID() int32 {
+	return p.pid
+}
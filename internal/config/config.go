@@ -15,6 +15,7 @@ import (
 	"github.com/ubuntu/adsys/internal/consts"
 	log "github.com/ubuntu/adsys/internal/grpc/logstreamer"
 	"github.com/ubuntu/adsys/internal/i18n"
+	"github.com/ubuntu/adsys/internal/snap"
 	"github.com/ubuntu/decorate"
 )
 
@@ -60,6 +61,10 @@ func Init(name string, cmd cobra.Command, vip *viper.Viper, configChanged func(r
 		vip.AddConfigPath("./")
 		vip.AddConfigPath("$HOME/")
 		vip.AddConfigPath("/etc/")
+		// A strictly confined snap can't read /etc/: its configuration is in the snap data directory.
+		if snap.Running() {
+			vip.AddConfigPath(snap.DefaultPaths().ConfigDir)
+		}
 		// Add the executable path to the config search path.
 		if binPath, err := os.Executable(); err != nil {
 			log.Warningf(context.Background(), i18n.G("Failed to get current executable path, not adding it as a config dir: %v"), err)
@@ -24,12 +24,41 @@ const (
 	// DefaultRunDir is the default path for adsys run directory.
 	DefaultRunDir = "/run/adsys"
 
+	// PolicyReadyFlagName is the flag file in the run directory signaling that machine policies are applied since boot.
+	PolicyReadyFlagName = "policy-ready"
+
+	// DefaultPolicyReadyTimeout is the default maximum time in seconds to wait for machine policies on boot.
+	DefaultPolicyReadyTimeout = 60
+
 	// DefaultClientTimeout is the maximum default time in seconds between 2 server activities before the client returns and abort the request.
 	DefaultClientTimeout = 30
 
 	// DefaultServiceTimeout is the default time in seconds without any active request before the service exits.
 	DefaultServiceTimeout = 120
 
+	// DefaultMaxConcurrentDownloads is the default maximum number of GPOs and assets downloaded at the same time.
+	DefaultMaxConcurrentDownloads = 4
+
+	// DefaultMaxDownloadFileSize is the default maximum size in bytes of a file downloaded from the SYSVOL share.
+	DefaultMaxDownloadFileSize = 100 << 20
+
+	// DefaultMaxPolSize is the default maximum size in bytes of a Registry.pol file to parse.
+	DefaultMaxPolSize = 16 << 20
+
+	// DefaultGPOLinkCacheTTL is the default time in seconds the GPO links of AD containers are cached between requests.
+	DefaultGPOLinkCacheTTL = 120
+
+	// DefaultMaxBackoff is the default maximum time in seconds the domain controller is not contacted after
+	// consecutive connection failures.
+	DefaultMaxBackoff = 4 * 3600
+
+	// DefaultLogMaxSize is the default size in MiB over which the logs written by adsys are rotated.
+	DefaultLogMaxSize = 10
+	// DefaultLogMaxAge is the default number of days rotated logs are kept.
+	DefaultLogMaxAge = 90
+	// DefaultLogRepeatInterval is the default time in seconds identical failures are logged only once by the daemon.
+	DefaultLogRepeatInterval = 3600
+
 	// DistroID is the distro ID which can be overridden at build time.
 	DistroID = "Ubuntu"
 )
@@ -42,16 +71,44 @@ const (
 	// AdysMachineScriptsServiceName is the machine script systemd service.
 	AdysMachineScriptsServiceName = "adsys-machine-scripts.service"
 
+	// AdsysGPORefreshServiceName is the systemd service refreshing the policies of the machine and all users.
+	AdsysGPORefreshServiceName = "adsys-gpo-refresh.service"
+
 	// DefaultDconfDir is the default dconf directory.
 	DefaultDconfDir = "/etc/dconf"
+	// DefaultGSettingsSchemasDir is the default directory of the installed gsettings schemas.
+	DefaultGSettingsSchemasDir = "/usr/share/glib-2.0/schemas"
 	// DefaultSudoersDir is the default directory for sudoers configuration.
 	DefaultSudoersDir = "/etc/sudoers.d"
 	// DefaultPolicyKitDir is the default directory for policykit configuration and rules.
 	DefaultPolicyKitDir = "/etc/polkit-1"
 	// DefaultApparmorDir is the default directory for apparmor configuration.
 	DefaultApparmorDir = "/etc/apparmor.d/adsys"
+	// DefaultNetplanDir is the default directory for netplan configuration.
+	DefaultNetplanDir = "/etc/netplan"
+	// DefaultJournaldConfDir is the default directory for journald configuration drop-ins.
+	DefaultJournaldConfDir = "/etc/systemd/journald.conf.d"
 	// DefaultSystemUnitDir is the default directory for systemd unit files.
 	DefaultSystemUnitDir = "/etc/systemd/system"
+	// DefaultPluginsDir is the default directory for policy manager plugins.
+	DefaultPluginsDir = "/usr/lib/adsys/plugins"
+	// DefaultHooksDir is the default directory for executables run after each policy apply.
+	DefaultHooksDir = "/etc/adsys/hooks.d"
+	// DefaultTransformsDir is the default directory for site-local entry transformation rules.
+	DefaultTransformsDir = "/etc/adsys/transforms.d"
+	// DefaultSecretsDir is the default directory of the files policy values can reference secrets from.
+	DefaultSecretsDir = "/etc/adsys/secrets"
+	// DefaultVaultTokenFile is the default file containing the token to read secrets from a Vault server.
+	DefaultVaultTokenFile = "/etc/adsys/vault-token"
+	// DefaultAuditLogPath is the default file where administrative requests are audited.
+	DefaultAuditLogPath = "/var/log/adsys/audit.log"
+	// DefaultRefreshTriggerKey is the default file containing the key shared with the senders of refresh messages.
+	DefaultRefreshTriggerKey = "/etc/adsys/refresh-trigger.key"
+	// DefaultRefreshTriggerPort is the default UDP port refresh messages are received on.
+	DefaultRefreshTriggerPort = 4742
+
+	// MinFreeDiskSpace is the disk space, in bytes, which must be left available after downloading or applying policies.
+	MinFreeDiskSpace = 10 * 1024 * 1024
 )
 
 // SSSD related properties.
@@ -84,6 +141,18 @@ const (
 	SystemdDbusServiceInterface = "org.freedesktop.systemd1.Service"
 )
 
+// logind related properties.
+const (
+	// LogindDbusRegisteredName is the well-known name of logind on dbus.
+	LogindDbusRegisteredName = "org.freedesktop.login1"
+	// LogindDbusObjectPath is the logind path for dbus.
+	LogindDbusObjectPath = "/org/freedesktop/login1"
+	// LogindDbusManagerInterface is the interface we are using to access dbus methods.
+	LogindDbusManagerInterface = "org.freedesktop.login1.Manager"
+	// LogindDbusSessionInterface is the interface we are using to access session objects.
+	LogindDbusSessionInterface = "org.freedesktop.login1.Session"
+)
+
 // Ubuntu Advantage related properties.
 const (
 	// SubscriptionDbusRegisteredName is the well-known name of UA on dbus.
@@ -4,10 +4,15 @@ package daemon
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"io/fs"
 	"net"
 	"os"
+	"os/user"
+	"strconv"
 	"sync"
+	"syscall"
 	"time"
 
 	"github.com/coreos/go-systemd/v22/activation"
@@ -18,6 +23,13 @@ import (
 	"google.golang.org/grpc"
 )
 
+const (
+	// statusFDName is the name of the status socket listener in the systemd file descriptor store.
+	statusFDName = "status"
+	// statusSocketUnit is the name of the listener of the status socket activated by systemd.
+	statusSocketUnit = "adsysd-status.socket"
+)
+
 // Daemon is a grpc daemon with systemd activation, configuration changes like dynamic
 // socket listening, idling timeout functionality….
 type Daemon struct {
@@ -34,15 +46,23 @@ type Daemon struct {
 
 	systemdSdNotifier   func(unsetEnvironment bool, state string) (bool, error)
 	useSocketActivation bool
+
+	statusLis    net.Listener
+	statusServer *grpc.Server
 }
 
 type options struct {
 	idlingTimeout time.Duration
 	serverQuit    func(context.Context)
 
+	statusSocket             string
+	statusSocketGroup        string
+	registerStatusGRPCServer GRPCServerRegisterer
+
 	// private member that we export for tests.
-	systemdActivationListener func() ([]net.Listener, error)
+	systemdActivationListener func() (map[string][]net.Listener, error)
 	systemdSdNotifier         func(unsetEnvironment bool, state string) (bool, error)
+	systemdFDStorer           func(name string, c syscall.RawConn) (bool, error)
 }
 
 type option func(*options) error
@@ -66,6 +86,17 @@ func WithServerQuit(f func(context.Context)) func(o *options) error {
 	}
 }
 
+// WithStatusSocket listens on an additional socket, serving the grpc server built by registerGRPCServer.
+// Only root and the members of group, if not empty, can connect to it. An empty socket disables it.
+func WithStatusSocket(socket, group string, registerGRPCServer GRPCServerRegisterer) func(o *options) error {
+	return func(o *options) error {
+		o.statusSocket = socket
+		o.statusSocketGroup = group
+		o.registerStatusGRPCServer = registerGRPCServer
+		return nil
+	}
+}
+
 // New returns an new, initialized daemon server, which handles systemd activation.
 // If systemd activation is used, it will override any socket passed here.
 func New(registerGRPCServer GRPCServerRegisterer, socket string, opts ...option) (d *Daemon, err error) {
@@ -74,8 +105,9 @@ func New(registerGRPCServer GRPCServerRegisterer, socket string, opts ...option)
 	// defaults
 	args := options{
 		serverQuit:                func(context.Context) {},
-		systemdActivationListener: activation.Listeners,
+		systemdActivationListener: activation.ListenersWithNames,
 		systemdSdNotifier:         daemon.SdNotify,
+		systemdFDStorer:           storeFD,
 	}
 	// applied options
 	for _, o := range opts {
@@ -95,10 +127,25 @@ func New(registerGRPCServer GRPCServerRegisterer, socket string, opts ...option)
 	}
 
 	// systemd socket activation or local creation
-	listeners, err := args.systemdActivationListener()
+	namedListeners, err := args.systemdActivationListener()
 	if err != nil {
 		return nil, err
 	}
+	// The status socket can be activated by its own socket unit. Otherwise, the listener of the configured one can
+	// be handed over by a previous instance through the file descriptor store.
+	var activatedStatusLis, handedOverStatusLis net.Listener
+	if l := namedListeners[statusSocketUnit]; len(l) > 0 {
+		activatedStatusLis = l[0]
+		delete(namedListeners, statusSocketUnit)
+	}
+	if l := namedListeners[statusFDName]; len(l) > 0 {
+		handedOverStatusLis = l[0]
+		delete(namedListeners, statusFDName)
+	}
+	var listeners []net.Listener
+	for _, l := range namedListeners {
+		listeners = append(listeners, l...)
+	}
 
 	switch len(listeners) {
 	case 0:
@@ -115,6 +162,24 @@ func New(registerGRPCServer GRPCServerRegisterer, socket string, opts ...option)
 
 	d.grpcserver = d.registerGRPCServer(d)
 
+	switch {
+	case activatedStatusLis != nil && args.registerStatusGRPCServer != nil:
+		// Like the main socket, the socket unit overrides the configured status socket and sets its permissions.
+		log.Debugf(context.Background(), "Using status socket %s from systemd socket activation", activatedStatusLis.Addr().String())
+		if handedOverStatusLis != nil {
+			// Don't remove the socket file if the socket unit now listens on it.
+			removeFile := handedOverStatusLis.Addr().String() != activatedStatusLis.Addr().String()
+			dropHandedOverStatusSocket(handedOverStatusLis, removeFile, args.systemdSdNotifier)
+		}
+		d.statusLis = activatedStatusLis
+		d.statusServer = args.registerStatusGRPCServer(d)
+	case args.statusSocket != "":
+		if d.statusLis, err = listenStatusSocket(args.statusSocket, args.statusSocketGroup, handedOverStatusLis, args.systemdSdNotifier, args.systemdFDStorer); err != nil {
+			return nil, err
+		}
+		d.statusServer = args.registerStatusGRPCServer(d)
+	}
+
 	go d.idler.keepAlive(d)
 
 	return d, nil
@@ -150,6 +215,125 @@ func (d *Daemon) UseSocket(socket string) (err error) {
 	return nil
 }
 
+// listenStatusSocket listens on socket, only accessible to root and the members of group.
+// The listener is kept in the systemd file descriptor store, so that it is handed over to the next instance of the
+// daemon when it restarts, like on package upgrades: status clients connecting meanwhile are queued instead of being
+// refused. handedOver is the listener of the previous instance, if any.
+func listenStatusSocket(socket, group string, handedOver net.Listener, sdNotifier func(bool, string) (bool, error), storeFD func(string, syscall.RawConn) (bool, error)) (lis net.Listener, err error) {
+	defer decorate.OnError(&err, i18n.G("can't listen on status socket %q"), socket)
+
+	if handedOver != nil {
+		if handedOver.Addr().String() == socket {
+			log.Debugf(context.Background(), "Using status socket %s handed over by the previous daemon", socket)
+			if err := setStatusSocketPermissions(socket, group); err != nil {
+				decorate.LogFuncOnError(handedOver.Close)
+				return nil, err
+			}
+			return handedOver, nil
+		}
+
+		// The status socket changed since the previous daemon: replace the stored one.
+		dropHandedOverStatusSocket(handedOver, true, sdNotifier)
+	}
+
+	// A stored listener is not removed when the daemon quits: drop it if it was not handed over, like after a stop.
+	if info, err := os.Lstat(socket); err == nil && info.Mode()&fs.ModeSocket != 0 {
+		if err := os.Remove(socket); err != nil {
+			return nil, err
+		}
+	}
+
+	lis, err = net.Listen("unix", socket)
+	if err != nil {
+		return nil, err
+	}
+	if err := setStatusSocketPermissions(socket, group); err != nil {
+		decorate.LogFuncOnError(lis.Close)
+		return nil, err
+	}
+
+	ul, ok := lis.(*net.UnixListener)
+	if !ok {
+		return lis, nil
+	}
+	c, err := ul.SyscallConn()
+	if err != nil {
+		decorate.LogFuncOnError(lis.Close)
+		return nil, err
+	}
+	if stored, err := storeFD(statusFDName, c); err != nil {
+		log.Warningf(context.Background(), i18n.G("Can't store status socket in systemd file descriptor store: %v"), err)
+	} else if stored {
+		// The next instance of the daemon serves the same socket.
+		ul.SetUnlinkOnClose(false)
+	}
+
+	return lis, nil
+}
+
+// dropHandedOverStatusSocket closes the status socket handed over by the previous daemon and removes its listener
+// from the systemd file descriptor store, as well as its file if removeFile is true.
+func dropHandedOverStatusSocket(handedOver net.Listener, removeFile bool, sdNotifier func(bool, string) (bool, error)) {
+	log.Debugf(context.Background(), "Dropping previous status socket %s", handedOver.Addr().String())
+	decorate.LogFuncOnError(handedOver.Close)
+	if removeFile {
+		if err := os.Remove(handedOver.Addr().String()); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			log.Warningf(context.Background(), i18n.G("Can't remove previous status socket: %v"), err)
+		}
+	}
+	if _, err := sdNotifier(false, fmt.Sprintf("FDSTOREREMOVE=1\nFDNAME=%s", statusFDName)); err != nil {
+		log.Warningf(context.Background(), i18n.G("Can't remove previous status socket from systemd file descriptor store: %v"), err)
+	}
+}
+
+// storeFD stores the file descriptor of c in the systemd file descriptor store of the service, under name.
+// It returns false if the daemon is not run by systemd.
+func storeFD(name string, c syscall.RawConn) (stored bool, err error) {
+	addr := &net.UnixAddr{Name: os.Getenv("NOTIFY_SOCKET"), Net: "unixgram"}
+	if addr.Name == "" {
+		return false, nil
+	}
+
+	conn, err := net.DialUnix(addr.Net, nil, addr)
+	if err != nil {
+		return false, err
+	}
+	defer conn.Close()
+
+	state := []byte(fmt.Sprintf("FDSTORE=1\nFDNAME=%s", name))
+	var writeErr error
+	if err := c.Control(func(fd uintptr) {
+		_, _, writeErr = conn.WriteMsgUnix(state, syscall.UnixRights(int(fd)), nil)
+	}); err != nil {
+		return false, err
+	}
+	if writeErr != nil {
+		return false, writeErr
+	}
+	return true, nil
+}
+
+// setStatusSocketPermissions restricts the access to socket to root and the members of group.
+func setStatusSocketPermissions(socket, group string) error {
+	// Access to the status socket is granted by its permissions rather than by polkit.
+	// #nosec G302
+	if err := os.Chmod(socket, 0660); err != nil {
+		return err
+	}
+	if group == "" {
+		return nil
+	}
+	g, err := user.LookupGroup(group)
+	if err != nil {
+		return err
+	}
+	gid, err := strconv.Atoi(g.Gid)
+	if err != nil {
+		return fmt.Errorf(i18n.G("invalid gid %q for group %q"), g.Gid, group)
+	}
+	return os.Chown(socket, -1, gid)
+}
+
 // Listen serves on its unix socket path.
 // It handles systemd activation notification.
 // When the server stop listening, the socket is removed automatically.
@@ -163,6 +347,15 @@ func (d *Daemon) Listen() (err error) {
 		log.Debug(context.Background(), i18n.G("Ready state sent to systemd"))
 	}
 
+	if d.statusServer != nil {
+		go func() {
+			log.Infof(context.Background(), i18n.G("Serving status on %s"), d.statusLis.Addr().String())
+			if err := d.statusServer.Serve(d.statusLis); err != nil {
+				log.Warningf(context.Background(), i18n.G("Status socket stopped serving: %v"), err)
+			}
+		}()
+	}
+
 	lis := <-d.lis
 	d.socketMu.Lock()
 	d.socketAddr = lis.Addr().String()
@@ -188,6 +381,10 @@ func (d *Daemon) Listen() (err error) {
 		d.socketMu.Unlock()
 		d.grpcserver = d.registerGRPCServer(d)
 	}
+	if d.statusServer != nil {
+		// Status requests are short and read-only: don’t wait for them when quitting.
+		d.statusServer.Stop()
+	}
 	log.Debug(context.Background(), i18n.G("Quitting"))
 	d.serverQuit(context.Background())
 
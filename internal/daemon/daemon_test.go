@@ -3,9 +3,14 @@ package daemon_test
 import (
 	"errors"
 	"flag"
+	"io/fs"
 	"net"
+	"os"
+	"os/user"
 	"path/filepath"
+	"strconv"
 	"sync"
+	"syscall"
 	"testing"
 	"time"
 
@@ -102,6 +107,194 @@ func TestChangeSocket(t *testing.T) {
 	require.Equal(t, d, grpcRegister.daemonsCalled[1], "GRPC registerer has the built in daemon as argument")
 }
 
+func TestStatusSocket(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		group           string
+		useCurrentGroup bool
+
+		wantErr bool
+	}{
+		"Status socket only accessible to root":     {},
+		"Status socket accessible to group members": {useCurrentGroup: true},
+
+		"Error on unknown group": {group: "doesnotexist", wantErr: true},
+	}
+
+	for name, tc := range tests {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			dir := t.TempDir()
+			statusSocket := filepath.Join(dir, "status.sock")
+
+			group := tc.group
+			if tc.useCurrentGroup {
+				g, err := user.LookupGroupId(strconv.Itoa(os.Getgid()))
+				require.NoError(t, err, "Setup: can't get current group")
+				group = g.Name
+			}
+
+			grpcRegister := &grpcServiceRegister{}
+			statusRegister := &grpcServiceRegister{}
+
+			d, err := daemon.New(grpcRegister.registerGRPCServer, filepath.Join(dir, "test.sock"),
+				daemon.WithStatusSocket(statusSocket, group, statusRegister.registerGRPCServer))
+			if tc.wantErr {
+				require.Error(t, err, "New should fail to listen on the status socket")
+				return
+			}
+			require.NoError(t, err, "New should return the daemon handler")
+
+			require.Equal(t, 1, len(statusRegister.daemonsCalled), "Status GRPC registerer has been called once")
+			require.Equal(t, d, statusRegister.daemonsCalled[0], "Status GRPC registerer has the built in daemon as argument")
+
+			info, err := os.Stat(statusSocket)
+			require.NoError(t, err, "Status socket should exist")
+			require.Equal(t, fs.FileMode(0660), info.Mode().Perm(), "Status socket should only be accessible to its owner and group")
+
+			go func() {
+				// make sure Serve() is called. Even std golang grpc has this timeout in tests
+				time.Sleep(time.Millisecond * 10)
+				d.Quit(false)
+			}()
+
+			err = d.Listen()
+			require.NoError(t, err, "Listen should return no error when stopped normally")
+
+			require.NoFileExists(t, statusSocket, "Status socket should be removed once the daemon quits")
+		})
+	}
+}
+
+func TestStatusSocketHandover(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		handedOverSocket string
+		activatedSocket  string
+		staleSocketFile  bool
+		stored           bool
+		storeErr         bool
+
+		wantStored       bool
+		wantStoreRemoved bool
+		wantSocketKept   bool
+	}{
+		"Status socket is stored for the next daemon": {stored: true, wantStored: true, wantSocketKept: true},
+		"Status socket handed over is reused":         {handedOverSocket: "status.sock", wantSocketKept: true},
+		"Status socket handed over on another path is replaced": {
+			handedOverSocket: "other.sock", stored: true, wantStored: true, wantStoreRemoved: true, wantSocketKept: true},
+		"Stale status socket not handed over is replaced":  {staleSocketFile: true, stored: true, wantStored: true, wantSocketKept: true},
+		"Status socket is removed when not run by systemd": {wantStored: true},
+		"Status socket is removed when it can't be stored": {storeErr: true, wantStored: true},
+
+		// Socket activation
+		"Status socket activated by systemd overrides the configured one": {activatedSocket: "activated.sock", wantSocketKept: true},
+		"Status socket activated by systemd replaces the handed over one": {
+			activatedSocket: "activated.sock", handedOverSocket: "other.sock", wantStoreRemoved: true, wantSocketKept: true},
+	}
+
+	for name, tc := range tests {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			dir := t.TempDir()
+			statusSocket := filepath.Join(dir, "status.sock")
+
+			listeners := make(map[string][]net.Listener)
+			if tc.handedOverSocket != "" {
+				l, err := net.Listen("unix", filepath.Join(dir, tc.handedOverSocket))
+				require.NoError(t, err, "Setup: couldn't create handed over status socket")
+				defer l.Close()
+				// Listeners passed by systemd are not removed when closed.
+				l.(*net.UnixListener).SetUnlinkOnClose(false)
+				listeners["status"] = []net.Listener{l}
+			}
+			if tc.activatedSocket != "" {
+				l, err := net.Listen("unix", filepath.Join(dir, tc.activatedSocket))
+				require.NoError(t, err, "Setup: couldn't create activated status socket")
+				defer l.Close()
+				l.(*net.UnixListener).SetUnlinkOnClose(false)
+				listeners["adsysd-status.socket"] = []net.Listener{l}
+			}
+			if tc.staleSocketFile {
+				l, err := net.Listen("unix", statusSocket)
+				require.NoError(t, err, "Setup: couldn't create stale status socket")
+				l.(*net.UnixListener).SetUnlinkOnClose(false)
+				l.Close()
+			}
+
+			var mu sync.Mutex
+			var stored []string
+			var notified []string
+			grpcRegister := &grpcServiceRegister{}
+			statusRegister := &grpcServiceRegister{}
+			d, err := daemon.New(grpcRegister.registerGRPCServer, filepath.Join(dir, "test.sock"),
+				daemon.WithStatusSocket(statusSocket, "", statusRegister.registerGRPCServer),
+				daemon.WithSystemdActivationListenersWithNames(func() (map[string][]net.Listener, error) { return listeners, nil }),
+				daemon.WithSystemdSdNotifier(func(unsetEnvironment bool, state string) (bool, error) {
+					mu.Lock()
+					defer mu.Unlock()
+					notified = append(notified, state)
+					return true, nil
+				}),
+				daemon.WithSystemdFDStorer(func(name string, c syscall.RawConn) (bool, error) {
+					mu.Lock()
+					defer mu.Unlock()
+					stored = append(stored, name)
+					if tc.storeErr {
+						return false, errors.New("store error")
+					}
+					return tc.stored, nil
+				}))
+			require.NoError(t, err, "New should return the daemon handler")
+
+			if tc.wantStored {
+				require.Equal(t, []string{"status"}, stored, "Status socket should be stored")
+			} else {
+				require.Empty(t, stored, "Status socket handed over should not be stored again")
+			}
+			if tc.handedOverSocket != "" && tc.handedOverSocket != "status.sock" {
+				require.NoFileExists(t, filepath.Join(dir, tc.handedOverSocket), "Previous status socket should be removed")
+			}
+
+			servedSocket := statusSocket
+			if tc.activatedSocket != "" {
+				servedSocket = filepath.Join(dir, tc.activatedSocket)
+				require.NoFileExists(t, statusSocket, "Configured status socket should not be created when socket activated")
+			}
+			conn, err := net.Dial("unix", servedSocket)
+			require.NoError(t, err, "Status socket should accept connections")
+			conn.Close()
+
+			go func() {
+				// make sure Serve() is called. Even std golang grpc has this timeout in tests
+				time.Sleep(time.Millisecond * 10)
+				d.Quit(false)
+			}()
+			err = d.Listen()
+			require.NoError(t, err, "Listen should return no error when stopped normally")
+
+			mu.Lock()
+			defer mu.Unlock()
+			if tc.wantStoreRemoved {
+				require.Contains(t, notified, "FDSTOREREMOVE=1\nFDNAME=status", "Previous status socket should be removed from the store")
+			} else {
+				require.NotContains(t, notified, "FDSTOREREMOVE=1\nFDNAME=status", "Status socket should not be removed from the store")
+			}
+			if tc.wantSocketKept {
+				require.FileExists(t, servedSocket, "Status socket should be kept for the next daemon")
+			} else {
+				require.NoFileExists(t, servedSocket, "Status socket should be removed once the daemon quits")
+			}
+		})
+	}
+}
+
 func TestSocketActivation(t *testing.T) {
 	t.Parallel()
 
@@ -3,15 +3,36 @@ package daemon
 import (
 	"errors"
 	"net"
+	"syscall"
 )
 
 func WithSystemdActivationListener(f func() ([]net.Listener, error)) func(o *options) error {
+	return func(o *options) error {
+		o.systemdActivationListener = func() (map[string][]net.Listener, error) {
+			l, err := f()
+			if err != nil {
+				return nil, err
+			}
+			return map[string][]net.Listener{"adsysd.socket": l}, nil
+		}
+		return nil
+	}
+}
+
+func WithSystemdActivationListenersWithNames(f func() (map[string][]net.Listener, error)) func(o *options) error {
 	return func(o *options) error {
 		o.systemdActivationListener = f
 		return nil
 	}
 }
 
+func WithSystemdFDStorer(f func(name string, c syscall.RawConn) (bool, error)) func(o *options) error {
+	return func(o *options) error {
+		o.systemdFDStorer = f
+		return nil
+	}
+}
+
 func WithSystemdSdNotifier(f func(unsetEnvironment bool, state string) (bool, error)) func(o *options) error {
 	return func(o *options) error {
 		o.systemdSdNotifier = f
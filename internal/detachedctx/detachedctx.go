@@ -0,0 +1,22 @@
+// Package detachedctx provides contexts outliving the requests they are created from.
+//
+// It is context.WithoutCancel, which we can use once our go directive is 1.21 or later.
+package detachedctx
+
+import (
+	"context"
+	"time"
+)
+
+// New returns a context keeping the values of parent, like the client log streams, but never canceled.
+func New(parent context.Context) context.Context {
+	return detachedContext{parent}
+}
+
+type detachedContext struct {
+	context.Context
+}
+
+func (detachedContext) Deadline() (deadline time.Time, ok bool) { return time.Time{}, false }
+func (detachedContext) Done() <-chan struct{}                   { return nil }
+func (detachedContext) Err() error                              { return nil }
@@ -0,0 +1,25 @@
+package detachedctx_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/ubuntu/adsys/internal/detachedctx"
+)
+
+type key struct{}
+
+func TestNew(t *testing.T) {
+	t.Parallel()
+
+	parent, cancel := context.WithCancel(context.WithValue(context.Background(), key{}, "value"))
+	ctx := detachedctx.New(parent)
+	cancel()
+
+	require.Equal(t, "value", ctx.Value(key{}), "Detached context should keep the values of its parent")
+	require.NoError(t, ctx.Err(), "Detached context should not be canceled with its parent")
+	require.Nil(t, ctx.Done(), "Detached context should never be done")
+	_, ok := ctx.Deadline()
+	require.False(t, ok, "Detached context should have no deadline")
+}
@@ -0,0 +1,82 @@
+// Package diskspace checks that filesystems have enough free space before writing to them.
+//
+// It allows to fail early with a clear error before modifying the system, instead of running out
+// of space midway and leaving a partial state.
+// It also detects read-only filesystems, like the ones of immutable images, which can't be written to at all.
+package diskspace
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path/filepath"
+
+	log "github.com/ubuntu/adsys/internal/grpc/logstreamer"
+	"github.com/ubuntu/adsys/internal/i18n"
+	"golang.org/x/sys/unix"
+)
+
+// Check returns an error if the filesystem of any of paths has less than needed bytes available.
+// A path which doesn't exist yet is checked on its closest existing parent. Each filesystem is only checked once.
+func Check(ctx context.Context, needed uint64, paths ...string) (err error) {
+	checked := make(map[unix.Fsid]struct{})
+	for _, p := range paths {
+		p, st, err := statfs(p)
+		if err != nil {
+			return fmt.Errorf(i18n.G("can't check available disk space for %s: %v"), p, err)
+		}
+
+		if _, ok := checked[st.Fsid]; ok {
+			continue
+		}
+		checked[st.Fsid] = struct{}{}
+
+		available := st.Bavail * uint64(st.Bsize)
+		log.Debugf(ctx, "%s available on filesystem of %s, %s needed", format(available), p, format(needed))
+		if available < needed {
+			return fmt.Errorf(i18n.G("not enough disk space on the filesystem of %s: %s available, %s needed"), p, format(available), format(needed))
+		}
+	}
+
+	return nil
+}
+
+// ReadOnly returns if p, or its closest existing parent, is on a read-only filesystem.
+func ReadOnly(p string) (bool, error) {
+	p, st, err := statfs(p)
+	if err != nil {
+		return false, fmt.Errorf(i18n.G("can't check filesystem of %s: %v"), p, err)
+	}
+	return st.Flags&unix.ST_RDONLY != 0, nil
+}
+
+// statfs returns the filesystem statistics of p, or of its closest existing parent.
+func statfs(p string) (string, unix.Statfs_t, error) {
+	var st unix.Statfs_t
+	for {
+		err := unix.Statfs(p, &st)
+		if err == nil {
+			return p, st, nil
+		}
+		parent := filepath.Dir(p)
+		if !errors.Is(err, fs.ErrNotExist) || parent == p {
+			return p, st, err
+		}
+		p = parent
+	}
+}
+
+// format returns a human readable size.
+func format(size uint64) string {
+	const unit = 1024
+	if size < unit {
+		return fmt.Sprintf("%d B", size)
+	}
+	div, exp := uint64(unit), 0
+	for n := size / unit; n >= unit && exp < 4; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(size)/float64(div), "KMGTP"[exp])
+}
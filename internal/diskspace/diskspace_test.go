@@ -0,0 +1,90 @@
+package diskspace_test
+
+import (
+	"context"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/ubuntu/adsys/internal/diskspace"
+)
+
+func TestCheck(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		needed uint64
+		paths  []string
+
+		wantErr bool
+	}{
+		"Enough disk space":                     {paths: []string{"dir"}, needed: 1},
+		"Enough disk space on multiple paths":   {paths: []string{"dir", "otherdir"}, needed: 1},
+		"Missing path is checked on its parent": {paths: []string{"dir/does/not/exist"}, needed: 1},
+		"No path to check":                      {needed: math.MaxUint64},
+
+		// Error cases
+		"Error on not enough disk space":                   {paths: []string{"dir"}, needed: math.MaxUint64, wantErr: true},
+		"Error on not enough disk space on a missing path": {paths: []string{"dir/does/not/exist"}, needed: math.MaxUint64, wantErr: true},
+		"Error on path which can't be checked":             {paths: []string{"file/child"}, wantErr: true},
+	}
+	for name, tc := range tests {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			root := t.TempDir()
+			require.NoError(t, os.Mkdir(filepath.Join(root, "dir"), 0700), "Setup: can't create directory")
+			require.NoError(t, os.Mkdir(filepath.Join(root, "otherdir"), 0700), "Setup: can't create directory")
+			require.NoError(t, os.WriteFile(filepath.Join(root, "file"), nil, 0600), "Setup: can't create file")
+
+			var paths []string
+			for _, p := range tc.paths {
+				paths = append(paths, filepath.Join(root, p))
+			}
+
+			err := diskspace.Check(context.Background(), tc.needed, paths...)
+			if tc.wantErr {
+				require.Error(t, err, "Check should have failed but didn't")
+				return
+			}
+			require.NoError(t, err, "Check should not have failed but did")
+		})
+	}
+}
+
+func TestReadOnly(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		path string
+
+		wantErr bool
+	}{
+		"Writable filesystem":                   {path: "dir"},
+		"Missing path is checked on its parent": {path: "dir/does/not/exist"},
+
+		// Error cases
+		"Error on path which can't be checked": {path: "file/child", wantErr: true},
+	}
+	for name, tc := range tests {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			root := t.TempDir()
+			require.NoError(t, os.Mkdir(filepath.Join(root, "dir"), 0700), "Setup: can't create directory")
+			require.NoError(t, os.WriteFile(filepath.Join(root, "file"), nil, 0600), "Setup: can't create file")
+
+			got, err := diskspace.ReadOnly(filepath.Join(root, tc.path))
+			if tc.wantErr {
+				require.Error(t, err, "ReadOnly should have failed but didn't")
+				return
+			}
+			require.NoError(t, err, "ReadOnly should not have failed but did")
+			require.False(t, got, "ReadOnly should report a writable filesystem")
+		})
+	}
+}
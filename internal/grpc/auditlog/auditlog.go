@@ -0,0 +1,129 @@
+// Package auditlog implements a stream interceptor logging every request with the identity of the caller to a dedicated audit logger.
+//
+// Each request is logged once it is handled, with the called method, the uid and pid of the peer, the authorization
+// decisions taken while handling it, a summary of its arguments and its result. This allows to attribute any privileged
+// action, like a policy update or purge, to a local user.
+package auditlog
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/peer"
+)
+
+type recordKey struct{}
+
+// record is the audit information collected while handling a request.
+type record struct {
+	mu             sync.Mutex
+	arguments      []string
+	authorizations []string
+}
+
+// peerCreds is implemented by the peer credentials attached to the request by the server.
+type peerCreds interface {
+	UID() uint32
+	PID() int32
+}
+
+type auditedServerStream struct {
+	grpc.ServerStream
+	ctx    context.Context
+	record *record
+}
+
+// StreamServerInterceptor logs each handled request to logger, with the identity of the caller.
+func StreamServerInterceptor(logger *logrus.Logger) func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		r := &record{}
+		auditedss := auditedServerStream{
+			ServerStream: ss,
+			ctx:          context.WithValue(ss.Context(), recordKey{}, r),
+			record:       r,
+		}
+
+		err := handler(srv, auditedss)
+
+		fields := logrus.Fields{
+			"uid": "unknown",
+			"pid": "unknown",
+		}
+		if info != nil {
+			fields["method"] = info.FullMethod
+		}
+		if p, ok := peer.FromContext(ss.Context()); ok {
+			if creds, ok := p.AuthInfo.(peerCreds); ok {
+				fields["uid"] = creds.UID()
+				fields["pid"] = creds.PID()
+			}
+		}
+
+		r.mu.Lock()
+		fields["arguments"] = strings.Join(r.arguments, ", ")
+		fields["authorization"] = strings.Join(r.authorizations, ", ")
+		r.mu.Unlock()
+
+		result := "success"
+		if err != nil {
+			result = err.Error()
+		}
+		fields["result"] = result
+
+		logger.WithFields(fields).Info("request handled")
+
+		return err
+	}
+}
+
+// RecordAuthorization attaches the authorization decision for action to the audited request of ctx.
+// It is a no-op if the request is not audited.
+func RecordAuthorization(ctx context.Context, action string, authErr error) {
+	r, ok := ctx.Value(recordKey{}).(*record)
+	if !ok {
+		return
+	}
+
+	decision := "allowed"
+	if authErr != nil {
+		decision = "denied"
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.authorizations = append(r.authorizations, fmt.Sprintf("%s: %s", action, decision))
+}
+
+func (ss auditedServerStream) Context() context.Context {
+	return ss.ctx
+}
+
+func (ss auditedServerStream) RecvMsg(m interface{}) error {
+	if err := ss.ServerStream.RecvMsg(m); err != nil {
+		return err
+	}
+
+	v := reflect.ValueOf(m)
+	if v.Kind() != reflect.Pointer || v.Elem().Kind() != reflect.Struct {
+		return nil
+	}
+	v = v.Elem()
+	t := v.Type()
+
+	ss.record.mu.Lock()
+	defer ss.record.mu.Unlock()
+	for i := 0; i < t.NumField(); i++ {
+		// Only record exported fields
+		if !t.Field(i).IsExported() {
+			continue
+		}
+		ss.record.arguments = append(ss.record.arguments, fmt.Sprintf("%s: %v", t.Field(i).Name, v.Field(i)))
+	}
+
+	return nil
+}
@@ -0,0 +1,130 @@
+package auditlog_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"flag"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+	"github.com/ubuntu/adsys/internal/grpc/auditlog"
+	"github.com/ubuntu/adsys/internal/testutils"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+)
+
+type myStream struct {
+	grpc.ServerStream
+	ctx          context.Context
+	recvMsgError bool
+}
+
+func (ss myStream) RecvMsg(m interface{}) error {
+	if ss.recvMsgError {
+		return errors.New("RecvMsg error")
+	}
+	if r, ok := m.(*request); ok {
+		r.Target = "user@example.com"
+		r.IsComputer = false
+		r.private = "private"
+	}
+	return nil
+}
+
+func (ss myStream) Context() context.Context {
+	return ss.ctx
+}
+
+type request struct {
+	Target     string
+	IsComputer bool
+	private    string
+}
+
+type testPeerCreds struct {
+	credentials.AuthInfo
+}
+
+func (testPeerCreds) UID() uint32 { return 1000 }
+func (testPeerCreds) PID() int32  { return 4242 }
+
+func TestStreamServerInterceptor(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		authorizations map[string]error
+		noPeerCreds    bool
+		infoIsNil      bool
+		recvMsgError   bool
+		handlerErr     error
+
+		wantErr bool
+	}{
+		"Log request with allowed authorization": {authorizations: map[string]error{"com.ubuntu.adsys.policy.update": nil}},
+		"Log request with denied authorization":  {authorizations: map[string]error{"com.ubuntu.adsys.policy.update": errors.New("polkit denied access")}},
+		"Log request without authorization":      {},
+		"Log request without peer credentials":   {noPeerCreds: true},
+		"Log request without method information": {infoIsNil: true},
+		"Log request failing to receive message": {recvMsgError: true},
+
+		// Error cases
+		"Error from handler is logged and returned": {handlerErr: errors.New("handler error"), wantErr: true},
+	}
+	for name, tc := range tests {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			var out bytes.Buffer
+			logger := logrus.New()
+			logger.SetOutput(&out)
+			logger.SetFormatter(&logrus.JSONFormatter{DisableTimestamp: true})
+
+			ctx := context.Background()
+			if !tc.noPeerCreds {
+				ctx = peer.NewContext(ctx, &peer.Peer{AuthInfo: testPeerCreds{}})
+			}
+			ss := myStream{ctx: ctx, recvMsgError: tc.recvMsgError}
+			info := &grpc.StreamServerInfo{FullMethod: "/service/UpdatePolicy"}
+			if tc.infoIsNil {
+				info = nil
+			}
+
+			handler := func(srv interface{}, stream grpc.ServerStream) error {
+				_ = stream.RecvMsg(&request{})
+				for action, err := range tc.authorizations {
+					auditlog.RecordAuthorization(stream.Context(), action, err)
+				}
+				return tc.handlerErr
+			}
+
+			err := auditlog.StreamServerInterceptor(logger)(nil, ss, info, handler)
+			if tc.wantErr {
+				require.Error(t, err, "Interceptor should return the handler error")
+			} else {
+				require.NoError(t, err, "Interceptor should not return an error")
+			}
+
+			got := out.String()
+			want := testutils.LoadWithUpdateFromGolden(t, got)
+			require.Equal(t, want, got, "Audit log entry should match")
+		})
+	}
+}
+
+func TestRecordAuthorizationOnNonAuditedRequest(t *testing.T) {
+	t.Parallel()
+
+	// This should not panic
+	auditlog.RecordAuthorization(context.Background(), "com.ubuntu.adsys.policy.update", nil)
+}
+
+func TestMain(m *testing.M) {
+	testutils.InstallUpdateFlag()
+	flag.Parse()
+
+	m.Run()
+}
@@ -6,3 +6,15 @@ const (
 	ClientIDKey         = clientIDKey
 	ClientWantCallerKey = clientWantCallerKey
 )
+
+// ResetRepeats forgets the collapsed messages, like when the daemon exits, without summarizing them.
+func ResetRepeats() {
+	repeats.mu.Lock()
+	defer repeats.mu.Unlock()
+
+	for k, r := range repeats.logged {
+		r.timer.Stop()
+		delete(repeats.logged, k)
+	}
+	repeats.path = ""
+}
@@ -191,16 +191,19 @@ func logLocallyMaybeRemote(level logrus.Level, caller, msg string, localLogger *
 	}
 	forwardMsg := localMsg
 
-	localLoggerMu.Lock()
-	callerForLocal := localLogger.ReportCaller
-	localLogger.SetReportCaller(false)
-	if callerForLocal {
-		localMsg = fmt.Sprintf(logFormatWithCaller, caller, localMsg)
+	// Repeated failures are only collapsed in the local logs: clients and forwarders get all of them.
+	if !isRepeated(localLogger, level, msg) {
+		localLoggerMu.Lock()
+		callerForLocal := localLogger.ReportCaller
+		localLogger.SetReportCaller(false)
+		if callerForLocal {
+			localMsg = fmt.Sprintf(logFormatWithCaller, caller, localMsg)
+		}
+		localLogger.Log(level, localMsg)
+		// Reset value for next call
+		localLogger.SetReportCaller(callerForLocal)
+		localLoggerMu.Unlock()
 	}
-	localLogger.Log(level, localMsg)
-	// Reset value for next call
-	localLogger.SetReportCaller(callerForLocal)
-	localLoggerMu.Unlock()
 
 	if sendStream != nil {
 		if err = sendStream(level.String(), caller, msg); err != nil {
@@ -4,10 +4,13 @@ import (
 	"bytes"
 	"context"
 	"errors"
+	"fmt"
 	"io"
 	"os"
+	"path/filepath"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/sirupsen/logrus"
 	"github.com/stretchr/testify/assert"
@@ -223,6 +226,17 @@ func TestLogStreamsAreSeparated(t *testing.T) {
 		[]string{"level=warning msg=", "something stream 2"})
 }
 
+func TestLocalOnlyLogsAreNotSentToClient(t *testing.T) {
+	t.Parallel()
+
+	stream, localLogs, remoteLogs := createLogStream(t, logrus.DebugLevel, false, false, nil)
+
+	log.Warning(log.LocalOnly(stream.Context()), "something")
+
+	requireLog(t, localLogs(), []string{"level=warning msg=", "[[123456:", "something"})
+	requireLog(t, remoteLogs(), []string{"level=debug msg=", "Connecting as [[123456:"})
+}
+
 func TestLogAddHook(t *testing.T) {
 	log.AddHook(&mockLogHook{})
 
@@ -262,3 +276,145 @@ func requireLog(t *testing.T, logs string, want ...[]string) {
 		}
 	}
 }
+
+func TestRepeatedFailuresAreCollapsed(t *testing.T) {
+	tests := map[string]struct {
+		level    logrus.Level
+		interval time.Duration
+		msgs     []string
+
+		wantLocal [][]string
+	}{
+		"Repeated failures are logged once, then summarized": {
+			msgs: []string{"unreachable", "unreachable", "unreachable"},
+			wantLocal: [][]string{
+				{"level=warning msg=", "[[123456:", "unreachable"},
+				{"level=warning msg=", "Previous message repeated since", "(repeat count: 2): unreachable"},
+			},
+		},
+		"Different failures are not collapsed": {
+			msgs: []string{"unreachable", "timeout", "unreachable"},
+			wantLocal: [][]string{
+				{"level=warning msg=", "unreachable"},
+				{"level=warning msg=", "timeout"},
+				{"level=warning msg=", "Previous message repeated since", "(repeat count: 1): unreachable"},
+			},
+		},
+		"Failures are not summarized if they are not repeated": {
+			msgs:      []string{"unreachable"},
+			wantLocal: [][]string{{"level=warning msg=", "unreachable"}},
+		},
+
+		"Failures are not collapsed in verbose mode": {
+			level: logrus.InfoLevel,
+			msgs:  []string{"unreachable", "unreachable"},
+			wantLocal: [][]string{
+				{"level=warning msg=", "unreachable"},
+				{"level=warning msg=", "unreachable"},
+			},
+		},
+		"Failures are not collapsed without interval": {
+			interval: -1,
+			msgs:     []string{"unreachable", "unreachable"},
+			wantLocal: [][]string{
+				{"level=warning msg=", "unreachable"},
+				{"level=warning msg=", "unreachable"},
+			},
+		},
+	}
+	for name, tc := range tests {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			// The repeat interval is global: don't run in parallel.
+			if tc.level == 0 {
+				tc.level = logrus.WarnLevel
+			}
+			if tc.interval == 0 {
+				tc.interval = 100 * time.Millisecond
+			}
+			if tc.interval < 0 {
+				tc.interval = 0
+			}
+			log.SetRepeatInterval(tc.interval)
+			t.Cleanup(func() { log.SetRepeatInterval(0) })
+
+			stream, localLogs, remoteLogs := createLogStream(t, tc.level, false, false, nil)
+
+			for _, msg := range tc.msgs {
+				log.Warning(stream.Context(), msg)
+			}
+			// Wait for the end of the interval.
+			time.Sleep(3 * tc.interval)
+
+			requireLog(t, localLogs(), tc.wantLocal...)
+			// Clients get all failures.
+			want := [][]string{{"level=debug msg=", "Connecting as [[123456:"}}
+			for _, msg := range tc.msgs {
+				want = append(want, []string{"level=warning msg=", msg})
+			}
+			requireLog(t, remoteLogs(), want...)
+		})
+	}
+}
+
+func TestRepeatedFailuresArePersisted(t *testing.T) {
+	// The repeat interval is global: don't run in parallel.
+	const interval = 500 * time.Millisecond
+	log.SetRepeatInterval(interval)
+	t.Cleanup(func() {
+		log.ResetRepeats()
+		log.SetRepeatInterval(0)
+	})
+	path := filepath.Join(t.TempDir(), "log-repeats")
+
+	// First run of the daemon: the failure is logged once, then exits before the end of the interval.
+	require.NoError(t, log.PersistRepeats(logrus.New(), path), "Setup: PersistRepeats should not fail")
+	stream, localLogs, _ := createLogStream(t, logrus.WarnLevel, false, false, nil)
+	log.Warning(stream.Context(), "unreachable")
+	log.Warning(stream.Context(), "unreachable")
+	requireLog(t, localLogs(), []string{"level=warning msg=", "unreachable"})
+	log.ResetRepeats()
+
+	// Next run: the failure is still collapsed, and summarized at the end of the interval started by the first run.
+	summaryLogger := logrus.New()
+	summaryLogs := captureLogs(t, summaryLogger)
+	require.NoError(t, log.PersistRepeats(summaryLogger, path), "PersistRepeats should restore the collapsed failures")
+	stream, localLogs, _ = createLogStream(t, logrus.WarnLevel, false, false, nil)
+	log.Warning(stream.Context(), "unreachable")
+	require.Empty(t, strings.TrimSpace(localLogs()), "Failure collapsed by the previous run should not be logged again")
+
+	time.Sleep(2 * interval)
+	requireLog(t, summaryLogs(), []string{"level=warning msg=", "Previous message repeated since", "(repeat count: 2): unreachable"})
+
+	got, err := os.ReadFile(path)
+	require.NoError(t, err, "Collapsed failures should still be persisted")
+	require.Equal(t, "[]\n", string(got), "Failures should not be persisted once summarized")
+}
+
+func TestPersistRepeatsSummarizesEndedIntervals(t *testing.T) {
+	// The repeat interval is global: don't run in parallel.
+	log.SetRepeatInterval(time.Hour)
+	t.Cleanup(func() {
+		log.ResetRepeats()
+		log.SetRepeatInterval(0)
+	})
+	path := filepath.Join(t.TempDir(), "log-repeats")
+	since := time.Now().Add(-2 * time.Hour).Format(time.RFC3339)
+	require.NoError(t, os.WriteFile(path, []byte(fmt.Sprintf("- level: warning\n  msg: unreachable\n  since: %s\n  count: 3\n", since)), 0600),
+		"Setup: can't write persisted repeats")
+
+	summaryLogger := logrus.New()
+	summaryLogs := captureLogs(t, summaryLogger)
+	require.NoError(t, log.PersistRepeats(summaryLogger, path), "PersistRepeats should restore the collapsed failures")
+
+	time.Sleep(100 * time.Millisecond)
+	requireLog(t, summaryLogs(), []string{"level=warning msg=", "Previous message repeated since " + since, "(repeat count: 3): unreachable"})
+}
+
+func TestPersistRepeatsErrorsOnInvalidFile(t *testing.T) {
+	t.Cleanup(log.ResetRepeats)
+	path := filepath.Join(t.TempDir(), "log-repeats")
+	require.NoError(t, os.WriteFile(path, []byte("invalid: ["), 0600), "Setup: can't write persisted repeats")
+
+	require.Error(t, log.PersistRepeats(logrus.New(), path), "PersistRepeats should fail on invalid persisted repeats")
+}
@@ -0,0 +1,164 @@
+package log
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/ubuntu/adsys/internal/i18n"
+	"gopkg.in/yaml.v3"
+)
+
+// repeats collapses the identical failures logged locally, like the domain controller being unreachable on each
+// refresh of an offline laptop, so that they don't flood the journal.
+var repeats = struct {
+	mu       sync.Mutex
+	interval time.Duration
+	logged   map[repeatKey]*repeat
+	path     string
+}{
+	logged: make(map[repeatKey]*repeat),
+}
+
+type repeatKey struct {
+	level logrus.Level
+	msg   string
+}
+
+// repeat counts the occurrences of a message since it was last logged.
+type repeat struct {
+	since time.Time
+	count int
+	timer *time.Timer
+}
+
+// persistedRepeat is how a repeat is persisted, so that it still collapses the message once the daemon restarts.
+type persistedRepeat struct {
+	Level string    `yaml:"level"`
+	Msg   string    `yaml:"msg"`
+	Since time.Time `yaml:"since"`
+	Count int       `yaml:"count"`
+}
+
+// SetRepeatInterval collapses the identical warnings and errors logged locally: each of them is logged once per
+// interval, followed at the end of the interval by a summary with the number of times it was repeated meanwhile.
+// 0 disables it. Messages are never collapsed when info logs are enabled, nor when they are sent to clients.
+func SetRepeatInterval(interval time.Duration) {
+	repeats.mu.Lock()
+	defer repeats.mu.Unlock()
+
+	repeats.interval = interval
+}
+
+// isRepeated returns true if msg, logged at level on localLogger, was already logged during the current interval.
+// It is then counted instead of being logged again.
+func isRepeated(localLogger *logrus.Logger, level logrus.Level, msg string) bool {
+	repeats.mu.Lock()
+	defer repeats.mu.Unlock()
+
+	if repeats.interval <= 0 || level > logrus.WarnLevel || localLogger.IsLevelEnabled(logrus.InfoLevel) {
+		return false
+	}
+
+	k := repeatKey{level: level, msg: msg}
+	if r, ok := repeats.logged[k]; ok {
+		r.count++
+		saveRepeats()
+		return true
+	}
+	r := &repeat{since: time.Now()}
+	r.timer = time.AfterFunc(repeats.interval, func() { logRepeated(localLogger, k, r) })
+	repeats.logged[k] = r
+	saveRepeats()
+	return false
+}
+
+// PersistRepeats keeps the messages collapsed during their current interval in path, so that the daemon, which exits
+// when idle, still collapses and summarizes them when it starts again. The messages persisted by the previous run are
+// restored, and the ones whose interval ended meanwhile are summarized right away on localLogger.
+// An empty path stops persisting them.
+func PersistRepeats(localLogger *logrus.Logger, path string) error {
+	repeats.mu.Lock()
+	defer repeats.mu.Unlock()
+
+	repeats.path = path
+	if path == "" {
+		return nil
+	}
+
+	d, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	var persisted []persistedRepeat
+	if err := yaml.Unmarshal(d, &persisted); err != nil {
+		return err
+	}
+	for _, p := range persisted {
+		level, err := logrus.ParseLevel(p.Level)
+		if err != nil {
+			continue
+		}
+		k := repeatKey{level: level, msg: p.Msg}
+		if _, ok := repeats.logged[k]; ok {
+			continue
+		}
+		r := &repeat{since: p.Since, count: p.Count}
+		r.timer = time.AfterFunc(time.Until(p.Since.Add(repeats.interval)), func() { logRepeated(localLogger, k, r) })
+		repeats.logged[k] = r
+	}
+	return nil
+}
+
+// saveRepeats persists the messages collapsed during their current interval, if requested.
+// repeats.mu must be held. Failures are ignored, as they can't be logged: the messages are then only collapsed
+// until the daemon exits.
+func saveRepeats() {
+	if repeats.path == "" {
+		return
+	}
+
+	var persisted []persistedRepeat
+	for k, r := range repeats.logged {
+		persisted = append(persisted, persistedRepeat{Level: k.level.String(), Msg: k.msg, Since: r.since, Count: r.count})
+	}
+	d, err := yaml.Marshal(persisted)
+	if err != nil {
+		return
+	}
+	if err := os.MkdirAll(filepath.Dir(repeats.path), 0700); err != nil {
+		return
+	}
+	// Write atomically, so that an interrupted write doesn't lose the messages collapsed before.
+	if err := os.WriteFile(repeats.path+".new", d, 0600); err != nil {
+		return
+	}
+	_ = os.Rename(repeats.path+".new", repeats.path)
+}
+
+// logRepeated ends the interval of the message of k, logging how many times it was repeated if it was.
+// r is the repeat the interval was started for, so that an interval ending late doesn't end a newer one.
+func logRepeated(localLogger *logrus.Logger, k repeatKey, r *repeat) {
+	repeats.mu.Lock()
+	if repeats.logged[k] != r {
+		repeats.mu.Unlock()
+		return
+	}
+	delete(repeats.logged, k)
+	saveRepeats()
+	repeats.mu.Unlock()
+
+	if r.count == 0 {
+		return
+	}
+
+	localLoggerMu.Lock()
+	defer localLoggerMu.Unlock()
+	callerForLocal := localLogger.ReportCaller
+	localLogger.SetReportCaller(false)
+	localLogger.Logf(k.level, i18n.G("Previous message repeated since %s (repeat count: %d): %s"), r.since.Format(time.RFC3339), r.count, k.msg)
+	localLogger.SetReportCaller(callerForLocal)
+}
@@ -57,6 +57,18 @@ func StreamServerInterceptor(localLogger *logrus.Logger) func(srv interface{}, s
 	}
 }
 
+// LocalOnly returns a context logging like ctx, with the ID of its request, but only locally: the logs are not sent
+// to the client anymore. This is for the work outliving the request which started it, whose stream is closed.
+func LocalOnly(ctx context.Context) context.Context {
+	logCtx, ok := ctx.Value(logContextKey).(logContext)
+	if !ok {
+		return ctx
+	}
+	logCtx.sendStream = nil
+	logCtx.withCallerForRemote = false
+	return context.WithValue(ctx, logContextKey, logCtx)
+}
+
 type serverStreamWithLogs struct {
 	grpc.ServerStream
 	ctx context.Context
@@ -0,0 +1,78 @@
+// Package logind provides a wrapper around logind dbus API that allows listing and closing the sessions of users.
+package logind
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/godbus/dbus/v5"
+	"github.com/ubuntu/adsys/internal/consts"
+	"github.com/ubuntu/adsys/internal/i18n"
+	"github.com/ubuntu/decorate"
+)
+
+// userClass is the class of the sessions users log in to, as opposed to greeters or to the user service manager.
+const userClass = "user"
+
+// DefaultCaller is the default implementation of the logind wrapper.
+type DefaultCaller struct {
+	bus *dbus.Conn
+}
+
+// New returns a new logind caller using the given dbus connection.
+func New(bus *dbus.Conn) *DefaultCaller {
+	return &DefaultCaller{bus: bus}
+}
+
+// SessionsStartedBefore returns the IDs of the sessions of the user with uid which started before t.
+func (l DefaultCaller) SessionsStartedBefore(ctx context.Context, uid uint32, t time.Time) (ids []string, err error) {
+	defer decorate.OnError(&err, i18n.G("failed to list sessions of user %d"), uid)
+
+	var sessions []struct {
+		ID   string
+		UID  uint32
+		User string
+		Seat string
+		Path dbus.ObjectPath
+	}
+	if err := l.bus.Object(consts.LogindDbusRegisteredName, consts.LogindDbusObjectPath).CallWithContext(ctx,
+		consts.LogindDbusManagerInterface+".ListSessions", 0).Store(&sessions); err != nil {
+		return nil, err
+	}
+
+	for _, s := range sessions {
+		if s.UID != uid {
+			continue
+		}
+
+		var props map[string]dbus.Variant
+		if err := l.bus.Object(consts.LogindDbusRegisteredName, s.Path).CallWithContext(ctx,
+			"org.freedesktop.DBus.Properties.GetAll", 0, consts.LogindDbusSessionInterface).Store(&props); err != nil {
+			return nil, err
+		}
+		class, ok := props["Class"].Value().(string)
+		if !ok {
+			return nil, fmt.Errorf(i18n.G("invalid class of session %s"), s.ID)
+		}
+		// Timestamp is the session start time, in microseconds since the epoch.
+		timestamp, ok := props["Timestamp"].Value().(uint64)
+		if !ok {
+			return nil, fmt.Errorf(i18n.G("invalid start time of session %s"), s.ID)
+		}
+		if class != userClass || !time.UnixMicro(int64(timestamp)).Before(t) {
+			continue
+		}
+		ids = append(ids, s.ID)
+	}
+
+	return ids, nil
+}
+
+// TerminateSession closes the session with the given ID, ending all its processes.
+func (l DefaultCaller) TerminateSession(ctx context.Context, id string) (err error) {
+	defer decorate.OnError(&err, i18n.G("failed to terminate session %s"), id)
+
+	return l.bus.Object(consts.LogindDbusRegisteredName, consts.LogindDbusObjectPath).CallWithContext(ctx,
+		consts.LogindDbusManagerInterface+".TerminateSession", 0, id).Err
+}
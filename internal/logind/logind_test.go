@@ -0,0 +1,119 @@
+package logind_test
+
+import (
+	"context"
+	"flag"
+	"testing"
+	"time"
+
+	"github.com/godbus/dbus/v5"
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+	"github.com/ubuntu/adsys/internal/consts"
+	"github.com/ubuntu/adsys/internal/logind"
+	"github.com/ubuntu/adsys/internal/testutils"
+)
+
+var (
+	ctx = context.Background()
+	l   logindBus
+)
+
+func TestSessionsStartedBefore(t *testing.T) {
+	t.Parallel()
+
+	bus := testutils.NewDbusConn(t)
+
+	tests := map[string]struct {
+		uid    uint32
+		before time.Time
+
+		want    []string
+		wantErr bool
+	}{
+		"Only user sessions started before are listed": {uid: 1000, want: []string{"1"}},
+		"Every user session started before is listed":  {uid: 1000, before: sessionsStart.Add(3 * time.Hour), want: []string{"1", "2"}},
+		"No session started before":                    {uid: 1000, before: sessionsStart.Add(-4 * time.Hour)},
+		"Sessions of other users are not listed":       {uid: 1001, want: []string{"4"}},
+		"User without sessions":                        {uid: 1005},
+
+		// Error cases
+		"Error on invalid session properties": {uid: 1002, wantErr: true},
+	}
+
+	for name, tc := range tests {
+		tc := tc
+		name := name
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			if tc.before.IsZero() {
+				tc.before = sessionsStart
+			}
+
+			got, err := logind.New(bus).SessionsStartedBefore(ctx, tc.uid, tc.before)
+			if tc.wantErr {
+				require.Error(t, err, "SessionsStartedBefore should have failed but it didn't")
+				return
+			}
+			require.NoError(t, err, "SessionsStartedBefore shouldn't have failed but it did")
+			require.Equal(t, tc.want, got, "SessionsStartedBefore should return the expected sessions")
+		})
+	}
+}
+
+func TestTerminateSession(t *testing.T) {
+	t.Parallel()
+
+	bus := testutils.NewDbusConn(t)
+	caller := logind.New(bus)
+
+	require.NoError(t, caller.TerminateSession(ctx, "1"), "TerminateSession shouldn't have failed but it did")
+	l.mu.Lock()
+	require.Contains(t, l.terminated, "1", "Session should have been terminated")
+	l.mu.Unlock()
+
+	require.Error(t, caller.TerminateSession(ctx, absentSession), "TerminateSession should have failed but it didn't")
+}
+
+func TestMain(m *testing.M) {
+	// export logind structure
+	defer testutils.StartLocalSystemBus()()
+
+	flag.Parse()
+
+	conn, err := dbus.SystemBusPrivate()
+	if err != nil {
+		log.Fatalf("Setup: can't get a private system bus: %v", err)
+	}
+	defer func() {
+		if err = conn.Close(); err != nil {
+			log.Fatalf("Teardown: can't close system dbus connection: %v", err)
+		}
+	}()
+	if err = conn.Auth(nil); err != nil {
+		log.Fatalf("Setup: can't auth on private system bus: %v", err)
+	}
+	if err = conn.Hello(); err != nil {
+		log.Fatalf("Setup: can't send hello message on private system bus: %v", err)
+	}
+
+	// Export methods
+	if err := conn.Export(&l, dbus.ObjectPath(consts.LogindDbusObjectPath), consts.LogindDbusManagerInterface); err != nil {
+		log.Fatalf("Setup: could not export logind object %v", err)
+	}
+	if err := exportSessions(conn); err != nil {
+		log.Fatalf("Setup: could not export logind sessions %v", err)
+	}
+
+	// Request logind name
+	reply, err := conn.RequestName(consts.LogindDbusRegisteredName, dbus.NameFlagDoNotQueue)
+	if err != nil {
+		log.Fatalf("Setup: Failed to acquire logind name on local system bus: %v", err)
+	}
+	if reply != dbus.RequestNameReplyPrimaryOwner {
+		log.Fatalf("Setup: Failed to acquire logind name on local system bus: name is already taken")
+	}
+
+	m.Run()
+}
@@ -0,0 +1,75 @@
+package logind_test
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/godbus/dbus/v5"
+	"github.com/godbus/dbus/v5/prop"
+	"github.com/ubuntu/adsys/internal/consts"
+)
+
+// sessionsStart is the reference start time of the mocked sessions.
+var sessionsStart = time.Date(2023, time.June, 1, 10, 0, 0, 0, time.UTC)
+
+type session struct {
+	ID   string
+	UID  uint32
+	User string
+	Seat string
+	Path dbus.ObjectPath
+
+	class     string
+	timestamp interface{}
+}
+
+// sessions are the sessions served by the logind mock.
+var sessions = []session{
+	{ID: "1", UID: 1000, User: "user", Seat: "seat0", class: "user", timestamp: uint64(sessionsStart.Add(-2 * time.Hour).UnixMicro())},
+	{ID: "2", UID: 1000, User: "user", class: "user", timestamp: uint64(sessionsStart.Add(2 * time.Hour).UnixMicro())},
+	{ID: "3", UID: 1000, User: "user", class: "manager", timestamp: uint64(sessionsStart.Add(-3 * time.Hour).UnixMicro())},
+	{ID: "4", UID: 1001, User: "other", Seat: "seat0", class: "user", timestamp: uint64(sessionsStart.Add(-2 * time.Hour).UnixMicro())},
+	{ID: "5", UID: 1002, User: "invalid", class: "user", timestamp: "not a timestamp"},
+}
+
+type logindBus struct {
+	terminated []string
+	mu         sync.Mutex
+}
+
+const absentSession = "absent"
+
+var errNoSuchSession = dbus.NewError(fmt.Sprintf("%s.NoSuchSession", consts.LogindDbusRegisteredName), []interface{}{"No session 'absent' known"})
+
+func (l *logindBus) ListSessions() ([]session, *dbus.Error) {
+	return sessions, nil
+}
+
+func (l *logindBus) TerminateSession(id string) *dbus.Error {
+	if id == absentSession {
+		return errNoSuchSession
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.terminated = append(l.terminated, id)
+	return nil
+}
+
+// exportSessions exports the properties of the mocked sessions on conn.
+func exportSessions(conn *dbus.Conn) error {
+	for i, s := range sessions {
+		sessions[i].Path = dbus.ObjectPath(fmt.Sprintf("%s/session/_3%s", consts.LogindDbusObjectPath, s.ID))
+		propsSpec := map[string]map[string]*prop.Prop{
+			consts.LogindDbusSessionInterface: {
+				"Class":     {Value: s.class, Emit: prop.EmitFalse},
+				"Timestamp": {Value: s.timestamp, Emit: prop.EmitFalse},
+			},
+		}
+		if _, err := prop.Export(conn, sessions[i].Path, propsSpec); err != nil {
+			return err
+		}
+	}
+	return nil
+}
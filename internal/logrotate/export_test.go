@@ -0,0 +1,4 @@
+package logrotate
+
+// TimeFormat is the suffix format of the rotated logs.
+const TimeFormat = timeFormat
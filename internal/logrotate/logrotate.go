@@ -0,0 +1,288 @@
+// Package logrotate bounds the disk space used by the logs adsys keeps writing, like the audit log.
+//
+// A log is rotated once it grows over its maximum size: it is renamed with the rotation time as suffix and
+// compressed, while a new empty log is written to. Rotated logs older than the maximum age are removed.
+package logrotate
+
+import (
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ubuntu/adsys/internal/consts"
+	log "github.com/ubuntu/adsys/internal/grpc/logstreamer"
+	"github.com/ubuntu/adsys/internal/i18n"
+	"github.com/ubuntu/decorate"
+)
+
+// timeFormat is the suffix of the rotated logs. It sorts in chronological order.
+const timeFormat = "20060102T150405.000000000Z"
+
+// Retention is how much of a log is kept on disk. Zero values use the defaults.
+type Retention struct {
+	// MaxSize is the size in MiB over which the log is rotated.
+	MaxSize int64 `mapstructure:"max_size"`
+	// MaxAge is the number of days rotated logs are kept.
+	MaxAge int `mapstructure:"max_age"`
+	// Compress compresses rotated logs with gzip.
+	Compress bool `mapstructure:"compress"`
+}
+
+// File is a log file rotated and pruned following its retention. It is safe for concurrent use.
+type File struct {
+	path     string
+	maxSize  int64
+	maxAge   time.Duration
+	compress bool
+
+	mu   sync.Mutex
+	f    *os.File
+	size int64
+
+	// pruneMu serializes the prunes, which run without holding mu so that compressing rotated logs doesn't block
+	// the writers.
+	pruneMu sync.Mutex
+}
+
+// Pruned is what was removed from the disk by a prune.
+type Pruned struct {
+	// Files are the paths of the removed rotated logs.
+	Files []string
+	// Size is the disk space freed in bytes, including by compressing rotated logs.
+	Size int64
+}
+
+// Open opens or creates the log at path for appending, and prunes its expired rotated logs.
+func Open(ctx context.Context, path string, r Retention) (f *File, err error) {
+	defer decorate.OnError(&err, i18n.G("can't open log %s"), path)
+
+	if r.MaxSize < 0 || r.MaxAge < 0 {
+		return nil, fmt.Errorf(i18n.G("invalid negative retention: %+v"), r)
+	}
+	if r.MaxSize == 0 {
+		r.MaxSize = consts.DefaultLogMaxSize
+	}
+	if r.MaxAge == 0 {
+		r.MaxAge = consts.DefaultLogMaxAge
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, err
+	}
+	f = &File{
+		path:     path,
+		maxSize:  r.MaxSize << 20,
+		maxAge:   time.Duration(r.MaxAge) * 24 * time.Hour,
+		compress: r.Compress,
+	}
+	if err := f.open(); err != nil {
+		return nil, err
+	}
+
+	if _, err := f.Prune(ctx, false); err != nil {
+		log.Warning(ctx, err)
+	}
+
+	return f, nil
+}
+
+// open opens the log for appending. It must be called with the lock held.
+func (f *File) open() error {
+	// #nosec G304 - the log path is set by the administrator.
+	file, err := os.OpenFile(f.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		_ = file.Close()
+		return err
+	}
+	f.f, f.size = file, info.Size()
+	return nil
+}
+
+// Write appends p to the log, rotating it first if p would make it grow over its maximum size.
+// A log which can't be rotated keeps being written to.
+// The rotated logs are pruned once p is written.
+func (f *File) Write(p []byte) (n int, err error) {
+	ctx := context.Background()
+
+	f.mu.Lock()
+	var rotated bool
+	if f.size > 0 && f.size+int64(len(p)) > f.maxSize {
+		if err := f.rotate(ctx); err != nil {
+			log.Warning(ctx, err)
+		} else {
+			rotated = true
+		}
+	}
+	n, err = f.f.Write(p)
+	f.size += int64(n)
+	f.mu.Unlock()
+
+	if rotated {
+		if _, err := f.prune(ctx, time.Now()); err != nil {
+			log.Warning(ctx, err)
+		}
+	}
+
+	return n, err
+}
+
+// Prune rotates the log if it is over its maximum size, or if force is set and it is not empty, then compresses and
+// removes the expired rotated logs.
+func (f *File) Prune(ctx context.Context, force bool) (pruned Pruned, err error) {
+	f.mu.Lock()
+	if f.size > f.maxSize || (force && f.size > 0) {
+		err = f.rotate(ctx)
+	}
+	f.mu.Unlock()
+	if err != nil {
+		return Pruned{}, err
+	}
+
+	return f.prune(ctx, time.Now())
+}
+
+// Close closes the log.
+func (f *File) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.f.Close()
+}
+
+// rotate renames the log with the current time as suffix and reopens a new one. The rotated logs are not pruned.
+// It must be called with the lock held.
+func (f *File) rotate(ctx context.Context) (err error) {
+	defer decorate.OnError(&err, i18n.G("can't rotate log %s"), f.path)
+
+	rotated := f.path + "." + time.Now().UTC().Format(timeFormat)
+	log.Debugf(ctx, "Rotating log %s to %s", f.path, rotated)
+
+	if err := os.Rename(f.path, rotated); err != nil {
+		return err
+	}
+	old := f.f
+	if err := f.open(); err != nil {
+		// Keep writing to the rotated log.
+		return err
+	}
+	if err := old.Close(); err != nil {
+		log.Warningf(ctx, i18n.G("Can't close rotated log %s: %v"), rotated, err)
+	}
+
+	return nil
+}
+
+// prune compresses the rotated logs if needed, and removes the ones rotated before the maximum age.
+// All rotated logs are handled, even if some of them fail. It doesn't need the lock on the log, as rotated logs are
+// not written to anymore.
+func (f *File) prune(ctx context.Context, now time.Time) (pruned Pruned, err error) {
+	defer decorate.OnError(&err, i18n.G("can't prune rotated logs of %s"), f.path)
+
+	f.pruneMu.Lock()
+	defer f.pruneMu.Unlock()
+
+	rotated, err := filepath.Glob(f.path + ".*")
+	if err != nil {
+		return Pruned{}, err
+	}
+	sort.Strings(rotated)
+
+	var errs []error
+	for _, p := range rotated {
+		suffix := strings.TrimSuffix(strings.TrimPrefix(p, f.path+"."), ".gz")
+		rotatedAt, err := time.Parse(timeFormat, suffix)
+		if err != nil {
+			// Not one of our rotated logs.
+			continue
+		}
+
+		info, err := os.Stat(p)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+
+		if now.Sub(rotatedAt) > f.maxAge {
+			log.Debugf(ctx, "Removing expired log %s", p)
+			if err := os.Remove(p); err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			pruned.Files = append(pruned.Files, p)
+			pruned.Size += info.Size()
+			continue
+		}
+
+		if f.compress && !strings.HasSuffix(p, ".gz") {
+			compressedSize, err := compress(p)
+			if err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			pruned.Size += info.Size() - compressedSize
+		}
+	}
+
+	return pruned, errors.Join(errs...)
+}
+
+// compress replaces the file at p by its gzip compressed version with a .gz extension, and returns its new size.
+func compress(p string) (size int64, err error) {
+	defer decorate.OnError(&err, i18n.G("can't compress %s"), p)
+
+	// #nosec G304 - rotated logs are next to the log set by the administrator.
+	src, err := os.Open(p)
+	if err != nil {
+		return 0, err
+	}
+	defer src.Close()
+
+	dest := p + ".gz"
+	// #nosec G304 - rotated logs are next to the log set by the administrator.
+	out, err := os.OpenFile(dest+".new", os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		if err != nil {
+			_ = out.Close()
+			_ = os.Remove(dest + ".new")
+		}
+	}()
+	w := gzip.NewWriter(out)
+	if _, err := io.Copy(w, src); err != nil {
+		return 0, err
+	}
+	if err := w.Close(); err != nil {
+		return 0, err
+	}
+	info, err := out.Stat()
+	if err != nil {
+		return 0, err
+	}
+	if err := out.Close(); err != nil {
+		return 0, err
+	}
+
+	if err := os.Rename(dest+".new", dest); err != nil {
+		return 0, err
+	}
+	if err := os.Remove(p); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return 0, err
+	}
+
+	return info.Size(), nil
+}
@@ -0,0 +1,261 @@
+package logrotate_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/ubuntu/adsys/internal/logrotate"
+)
+
+func TestOpen(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		retention    logrotate.Retention
+		existingLog  string
+		noParentDir  bool
+		rotatedFiles map[string]time.Duration
+
+		wantRemaining []string
+		wantErr       bool
+	}{
+		"Create log":                       {},
+		"Create log and its directory":     {noParentDir: true},
+		"Append to existing log":           {existingLog: "existing\n"},
+		"Expired rotated logs are removed": {rotatedFiles: map[string]time.Duration{"old": 100 * 24 * time.Hour, "recent": 24 * time.Hour}, wantRemaining: []string{"recent"}},
+		"Maximum age is configurable":      {retention: logrotate.Retention{MaxAge: 2}, rotatedFiles: map[string]time.Duration{"old": 3 * 24 * time.Hour, "recent": 24 * time.Hour}, wantRemaining: []string{"recent"}},
+
+		"Error on negative maximum size": {retention: logrotate.Retention{MaxSize: -1}, wantErr: true},
+		"Error on negative maximum age":  {retention: logrotate.Retention{MaxAge: -1}, wantErr: true},
+	}
+	for name, tc := range tests {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			dir := t.TempDir()
+			if tc.noParentDir {
+				dir = filepath.Join(dir, "log")
+			}
+			p := filepath.Join(dir, "audit.log")
+			if tc.existingLog != "" {
+				require.NoError(t, os.WriteFile(p, []byte(tc.existingLog), 0600), "Setup: can't write existing log")
+			}
+			rotated := writeRotated(t, p, tc.rotatedFiles)
+
+			f, err := logrotate.Open(context.Background(), p, tc.retention)
+			if tc.wantErr {
+				require.Error(t, err, "Open should return an error but didn't")
+				return
+			}
+			require.NoError(t, err, "Open should not return an error")
+			defer f.Close()
+
+			_, err = f.Write([]byte("new\n"))
+			require.NoError(t, err, "Write should not return an error")
+			got, err := os.ReadFile(p)
+			require.NoError(t, err, "Log should exist")
+			require.Equal(t, tc.existingLog+"new\n", string(got), "Log should be appended to")
+
+			requireRemaining(t, rotated, tc.wantRemaining)
+		})
+	}
+}
+
+func TestWrite(t *testing.T) {
+	t.Parallel()
+
+	p := filepath.Join(t.TempDir(), "audit.log")
+	f, err := logrotate.Open(context.Background(), p, logrotate.Retention{MaxSize: 1, Compress: true})
+	require.NoError(t, err, "Setup: Open should not return an error")
+	defer f.Close()
+
+	first := bytes.Repeat([]byte("a"), 1<<20-1)
+	_, err = f.Write(first)
+	require.NoError(t, err, "Write should not return an error")
+	requireRotatedContents(t, p, nil)
+
+	_, err = f.Write([]byte("over\n"))
+	require.NoError(t, err, "Write should not return an error")
+	got, err := os.ReadFile(p)
+	require.NoError(t, err, "Log should exist")
+	require.Equal(t, "over\n", string(got), "Log should have been rotated before going over its maximum size")
+	requireRotatedContents(t, p, [][]byte{first})
+}
+
+func TestConcurrentWrites(t *testing.T) {
+	t.Parallel()
+
+	p := filepath.Join(t.TempDir(), "audit.log")
+	f, err := logrotate.Open(context.Background(), p, logrotate.Retention{MaxSize: 1, Compress: true})
+	require.NoError(t, err, "Setup: Open should not return an error")
+	defer f.Close()
+
+	// Writers keep writing while the rotated logs are compressed.
+	const writers, writes = 8, 16
+	chunk := bytes.Repeat([]byte("a"), 64<<10)
+	var wg sync.WaitGroup
+	for i := 0; i < writers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < writes; j++ {
+				_, err := f.Write(chunk)
+				assert.NoError(t, err, "Write should not return an error")
+			}
+		}()
+	}
+	wg.Wait()
+
+	got, err := os.ReadFile(p)
+	require.NoError(t, err, "Log should exist")
+	total := len(got)
+	rotated, err := filepath.Glob(p + ".*.gz")
+	require.NoError(t, err, "Can't list rotated logs")
+	require.NotEmpty(t, rotated, "Log should have been rotated")
+	for _, rp := range rotated {
+		total += len(readGzip(t, rp))
+	}
+	require.Equal(t, writers*writes*len(chunk), total, "All writes should be in the log or its rotated logs")
+}
+
+func TestPrune(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		retention    logrotate.Retention
+		logContent   string
+		force        bool
+		rotatedFiles map[string]time.Duration
+		otherFiles   []string
+
+		wantRotated   bool
+		wantRemaining []string
+		wantPruned    int
+	}{
+		"Nothing to prune":               {logContent: "content\n"},
+		"Log under maximum size is kept": {logContent: "content\n", rotatedFiles: map[string]time.Duration{"recent": time.Hour}, wantRemaining: []string{"recent"}},
+		"Force rotation":                 {retention: logrotate.Retention{Compress: true}, logContent: "content\n", force: true, wantRotated: true},
+		"Empty log is not rotated":       {force: true},
+		"Other files are ignored":        {logContent: "content\n", otherFiles: []string{"audit.log.other", "audit.log.20200101"}},
+		"Expired rotated logs are removed": {
+			logContent:    "content\n",
+			rotatedFiles:  map[string]time.Duration{"old": 100 * 24 * time.Hour, "older": 200 * 24 * time.Hour, "recent": time.Hour},
+			wantRemaining: []string{"recent"},
+			wantPruned:    2,
+		},
+		"Uncompressed rotated logs are compressed": {
+			retention:     logrotate.Retention{Compress: true},
+			logContent:    "content\n",
+			rotatedFiles:  map[string]time.Duration{"recent": time.Hour},
+			wantRemaining: []string{"recent.gz"},
+		},
+	}
+	for name, tc := range tests {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			p := filepath.Join(t.TempDir(), "audit.log")
+			f, err := logrotate.Open(context.Background(), p, tc.retention)
+			require.NoError(t, err, "Setup: Open should not return an error")
+			defer f.Close()
+			_, err = f.Write([]byte(tc.logContent))
+			require.NoError(t, err, "Setup: Write should not return an error")
+			// Rotated logs are created after opening, so that they are only pruned by the explicit call.
+			rotated := writeRotated(t, p, tc.rotatedFiles)
+			for _, n := range tc.otherFiles {
+				require.NoError(t, os.WriteFile(filepath.Join(filepath.Dir(p), n), nil, 0600), "Setup: can't write other file")
+			}
+
+			pruned, err := f.Prune(context.Background(), tc.force)
+			require.NoError(t, err, "Prune should not return an error")
+			require.Len(t, pruned.Files, tc.wantPruned, "Prune should report the removed rotated logs")
+
+			if tc.wantRotated {
+				got, err := os.ReadFile(p)
+				require.NoError(t, err, "Log should exist")
+				require.Empty(t, got, "Log should have been rotated")
+				requireRotatedContents(t, p, [][]byte{[]byte(tc.logContent)})
+				return
+			}
+			got, err := os.ReadFile(p)
+			require.NoError(t, err, "Log should exist")
+			require.Equal(t, tc.logContent, string(got), "Log should not have been rotated")
+			requireRemaining(t, rotated, tc.wantRemaining)
+			for _, n := range tc.otherFiles {
+				require.FileExists(t, filepath.Join(filepath.Dir(p), n), "Other files should be kept")
+			}
+		})
+	}
+}
+
+// writeRotated creates a rotated log for each name of rotatedFiles, rotated the given duration ago.
+// It returns the paths of the rotated logs indexed by name.
+func writeRotated(t *testing.T, p string, rotatedFiles map[string]time.Duration) map[string]string {
+	t.Helper()
+
+	paths := make(map[string]string)
+	for n, age := range rotatedFiles {
+		rp := p + "." + time.Now().Add(-age).UTC().Format(logrotate.TimeFormat)
+		require.NoError(t, os.WriteFile(rp, []byte(n), 0600), "Setup: can't write rotated log")
+		paths[n] = rp
+	}
+	return paths
+}
+
+// requireRemaining checks that only the rotated logs of wantRemaining are left, ".gz" suffixed names being
+// expected compressed.
+func requireRemaining(t *testing.T, rotated map[string]string, wantRemaining []string) {
+	t.Helper()
+
+	remaining := make(map[string]bool)
+	for _, n := range wantRemaining {
+		remaining[n] = true
+	}
+	for n, rp := range rotated {
+		switch {
+		case remaining[n]:
+			require.FileExists(t, rp, "Rotated log %q should have been kept", n)
+		case remaining[n+".gz"]:
+			require.NoFileExists(t, rp, "Rotated log %q should have been compressed", n)
+			require.Equal(t, []byte(n), readGzip(t, rp+".gz"), "Compressed rotated log %q should have its content", n)
+		default:
+			require.NoFileExists(t, rp, "Rotated log %q should have been removed", n)
+		}
+	}
+}
+
+// requireRotatedContents checks the contents of the compressed rotated logs of p, in rotation order.
+func requireRotatedContents(t *testing.T, p string, want [][]byte) {
+	t.Helper()
+
+	rotated, err := filepath.Glob(p + ".*.gz")
+	require.NoError(t, err, "Can't list rotated logs")
+	require.Len(t, rotated, len(want), "Unexpected number of rotated logs")
+	for i, rp := range rotated {
+		require.Equal(t, want[i], readGzip(t, rp), "Rotated log should have the log content")
+	}
+}
+
+func readGzip(t *testing.T, p string) []byte {
+	t.Helper()
+
+	f, err := os.Open(p)
+	require.NoError(t, err, "Can't open compressed file")
+	defer f.Close()
+	r, err := gzip.NewReader(f)
+	require.NoError(t, err, "Can't read compressed file")
+	d, err := io.ReadAll(r)
+	require.NoError(t, err, "Can't decompress file")
+	return d
+}
@@ -7,6 +7,11 @@
 // - no entries: a warning is logged and the manager returns without error
 // - entries: the manager returns an error if apparmor_parser is not found
 //
+// Only the machine profiles whose content, or the content of the files they
+// include, changed since the previous apply are reloaded, relying on the
+// apparmor_parser cache. All of them are reloaded if any of their policies is
+// not loaded anymore, like after a reboot.
+//
 // Next, if we found no entries to apply (either to them not existing or being
 // disabled), we attempt to unload all rules managed by ADSys.
 //
@@ -22,6 +27,7 @@ import (
 	"bufio"
 	"bytes"
 	"context"
+	"crypto/sha256"
 	"errors"
 	"fmt"
 	"io"
@@ -29,6 +35,7 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
+	"regexp"
 	"strings"
 	"sync"
 	"time"
@@ -49,6 +56,13 @@ func WithApparmorParserCmd(cmd []string) Option {
 	}
 }
 
+// WithApparmorCacheDir specifies a personalized directory for the apparmor_parser cache.
+func WithApparmorCacheDir(path string) Option {
+	return func(o *options) {
+		o.apparmorCacheDir = path
+	}
+}
+
 // WithApparmorFsDir specifies a personalized directory for the apparmor
 // security filesystem.
 func WithApparmorFsDir(path string) Option {
@@ -69,6 +83,7 @@ type Manager struct {
 
 type options struct {
 	apparmorParserCmd []string
+	apparmorCacheDir  string
 	apparmorFsDir     string
 }
 
@@ -80,6 +95,7 @@ func New(apparmorDir string, opts ...Option) *Manager {
 	// defaults
 	args := options{
 		apparmorParserCmd: []string{"apparmor_parser"},
+		apparmorCacheDir:  filepath.Join(consts.DefaultCacheDir, "apparmor"),
 		apparmorFsDir:     "/sys/kernel/security/apparmor",
 	}
 	// applied options
@@ -90,12 +106,17 @@ func New(apparmorDir string, opts ...Option) *Manager {
 	return &Manager{
 		mu:                 sync.Mutex{},
 		apparmorDir:        apparmorDir,
-		apparmorCacheDir:   filepath.Join(consts.DefaultCacheDir, "apparmor"),
+		apparmorCacheDir:   args.apparmorCacheDir,
 		apparmorParserCmd:  args.apparmorParserCmd,
 		loadedPoliciesFile: filepath.Join(args.apparmorFsDir, "profiles"),
 	}
 }
 
+// Destinations returns the directory of the profiles managed by the apparmor manager.
+func (m *Manager) Destinations() []string {
+	return []string{m.apparmorDir}
+}
+
 // AssetsDumper is a function which uncompress policies assets to a directory.
 type AssetsDumper func(ctx context.Context, relSrc, dest string, uid int, gid int) (err error)
 
@@ -107,7 +128,8 @@ type AssetsDumper func(ctx context.Context, relSrc, dest string, uid int, gid in
 // 3b. Move /etc/apparmor.d/adsys/<object>.new to /etc/apparmor.d/adsys/<object>
 // 4.  Get the new list of apparmor policies
 // 5.  Compute difference between old and new list of policies, unloading the removed ones if needed
-// 6.  Run apparmor_parser -r -W -L /var/cache/adsys/apparmor on all files in /etc/apparmor.d/adsys/<object>
+// 6.  Run apparmor_parser -r -W -L /var/cache/adsys/apparmor on the files in /etc/apparmor.d/adsys/<object> which
+// changed compared to /etc/apparmor.d/adsys/<object>.old, or on all of them if some of the new policies are not loaded
 // 7a. If apparmor_parser fails, move /etc/apparmor.d/adsys/<object>.old to /etc/apparmor.d/adsys/<object>
 // 7b. If apparmor_parser succeeds, remove /etc/apparmor.d/adsys/<object>.old.
 func (m *Manager) ApplyPolicy(ctx context.Context, objectName string, isComputer bool, entries []entry.Entry, assetsDumper AssetsDumper) (err error) {
@@ -232,10 +254,21 @@ func (m *Manager) applyMachinePolicy(ctx context.Context, e entry.Entry, apparmo
 		return err
 	}
 
-	if len(filesToLoad) > 0 && os.Getenv("ADSYS_SKIP_ROOT_CALLS") == "" {
+	// Only reload changed profiles if everything else is still loaded
+	filesToReload := filesToLoad
+	if len(difference(newPolicies, prevLoadedPolicies)) == 0 {
+		if filesToReload, err = changedFiles(filesToLoad, apparmorPath, oldApparmorPath, m.apparmorDir); err != nil {
+			return err
+		}
+		if len(filesToLoad) > 0 && len(filesToReload) == 0 {
+			log.Debug(ctx, i18n.G("Apparmor machine profiles are unchanged and loaded, skipping reload"))
+		}
+	}
+
+	if len(filesToReload) > 0 && os.Getenv("ADSYS_SKIP_ROOT_CALLS") == "" {
 		// Run apparmor_parser on the files to load, relying on apparmor's caching mechanism
 		apparmorParserCmd := append(m.apparmorParserCmd, []string{"-r", "-W", "-L", m.apparmorCacheDir}...)
-		apparmorParserCmd = append(apparmorParserCmd, filesToLoad...)
+		apparmorParserCmd = append(apparmorParserCmd, filesToReload...)
 
 		// #nosec G204 - We are in control of the arguments
 		cmd := exec.CommandContext(ctx, apparmorParserCmd[0], apparmorParserCmd[1:]...)
@@ -586,6 +619,183 @@ func removeUnusedAssets(apparmorPath string, filesToKeep []string) (e error) {
 	})
 }
 
+// includeRule matches the include rules of apparmor profiles, like include <tunables/global> or
+// #include if exists "local/usr.bin.foo", capturing the delimiter and the included path.
+var includeRule = regexp.MustCompile(`^\s*#?include\s+(?:if\s+exists\s+)?([<"])([^>"]+)[>"]`)
+
+// changedFiles returns the files, under newDir, which content, or the content of a file they include, differs from
+// the file at the same relative path under oldDir. Files which don't exist in oldDir are considered changed.
+// Included files outside of newDir, like the system abstractions, are considered changed if they were modified since
+// the previous profiles were written.
+// workDir is the directory apparmor_parser runs in, whose parent is the apparmor search path.
+func changedFiles(files []string, newDir, oldDir, workDir string) (changed []string, err error) {
+	defer decorate.OnError(&err, i18n.G("can't compare apparmor profiles with previous ones"))
+
+	c := profilesComparison{newDir: newDir, oldDir: oldDir, workDir: workDir, changed: make(map[string]bool)}
+	for _, f := range files {
+		fileChanged, err := c.pathChanged(f)
+		if err != nil {
+			return nil, err
+		}
+		if fileChanged {
+			changed = append(changed, f)
+		}
+	}
+	return changed, nil
+}
+
+// profilesComparison compares the profiles under newDir with the previous ones under oldDir.
+type profilesComparison struct {
+	newDir  string
+	oldDir  string
+	workDir string
+
+	// changed is the result for each path already compared. Paths being compared are recorded as unchanged, so that
+	// include loops end.
+	changed map[string]bool
+}
+
+// pathChanged returns true if the file or directory at path, under newDir, differs from the previous one.
+func (c *profilesComparison) pathChanged(path string) (bool, error) {
+	if changed, ok := c.changed[path]; ok {
+		return changed, nil
+	}
+	c.changed[path] = false
+
+	changed, err := c.compare(path)
+	if err != nil {
+		return false, err
+	}
+	c.changed[path] = changed
+	return changed, nil
+}
+
+// compare returns true if path was added, removed, or if its content or the one of its includes changed.
+func (c *profilesComparison) compare(path string) (bool, error) {
+	rel, err := filepath.Rel(c.newDir, path)
+	if err != nil {
+		return false, err
+	}
+	oldPath := filepath.Join(c.oldDir, rel)
+
+	info, err := os.Stat(path)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return false, err
+	}
+	oldInfo, errOld := os.Stat(oldPath)
+	if errOld != nil && !errors.Is(errOld, fs.ErrNotExist) {
+		return false, errOld
+	}
+	switch {
+	case err != nil && errOld != nil:
+		return false, nil
+	case err != nil || errOld != nil:
+		return true, nil
+	case info.IsDir() != oldInfo.IsDir():
+		return true, nil
+	case info.IsDir():
+		return c.dirChanged(path, oldPath)
+	}
+
+	h, err := contentHash(path)
+	if err != nil {
+		return false, err
+	}
+	oldH, err := contentHash(oldPath)
+	if err != nil {
+		return false, err
+	}
+	if h != oldH {
+		return true, nil
+	}
+	return c.includesChanged(path, oldInfo.ModTime())
+}
+
+// dirChanged returns true if a file was added to or removed from the directory at path, or if one of them changed.
+func (c *profilesComparison) dirChanged(path, oldPath string) (bool, error) {
+	entries, err := os.ReadDir(path)
+	if err != nil {
+		return false, err
+	}
+	oldEntries, err := os.ReadDir(oldPath)
+	if err != nil {
+		return false, err
+	}
+	if len(entries) != len(oldEntries) {
+		return true, nil
+	}
+	for _, e := range entries {
+		if changed, err := c.pathChanged(filepath.Join(path, e.Name())); err != nil || changed {
+			return changed, err
+		}
+	}
+	return false, nil
+}
+
+// includesChanged returns true if a file included by the profile at path changed. The previous profile was written
+// at since.
+func (c *profilesComparison) includesChanged(path string, since time.Time) (bool, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		m := includeRule.FindStringSubmatch(scanner.Text())
+		if m == nil {
+			continue
+		}
+		// Relative quoted includes are resolved from the directory of the profile, or from the working directory
+		// on older apparmor versions.
+		candidates := []string{filepath.Join(filepath.Dir(c.workDir), m[2])}
+		if m[1] == `"` && filepath.IsAbs(m[2]) {
+			candidates = []string{m[2]}
+		} else if m[1] == `"` {
+			candidates = []string{filepath.Join(filepath.Dir(path), m[2]), filepath.Join(c.workDir, m[2])}
+		}
+		for _, include := range candidates {
+			if changed, err := c.includeChanged(include, since); err != nil || changed {
+				return changed, err
+			}
+		}
+	}
+	return false, scanner.Err()
+}
+
+// includeChanged returns true if the included path changed. Paths outside of newDir can't be compared with their
+// previous version: they are changed if they were modified after since.
+func (c *profilesComparison) includeChanged(include string, since time.Time) (bool, error) {
+	if rel, err := filepath.Rel(c.newDir, include); err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return c.pathChanged(include)
+	}
+
+	info, err := os.Stat(include)
+	if errors.Is(err, fs.ErrNotExist) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	return info.ModTime().After(since), nil
+}
+
+// contentHash returns the sha256 sum of the file content.
+func contentHash(path string) (h [sha256.Size]byte, err error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return h, err
+	}
+	defer f.Close()
+
+	hasher := sha256.New()
+	if _, err := io.Copy(hasher, f); err != nil {
+		return h, err
+	}
+	copy(h[:], hasher.Sum(nil))
+	return h, nil
+}
+
 // filesInDir returns the list of files in the given directory.
 func filesInDir(path string) ([]string, error) {
 	var files []string
@@ -55,6 +55,13 @@ func TestApplyPolicy(t *testing.T) {
 		"Existing .new directory is removed":       {destsAlreadyExist: map[string]string{"only-machine": "machine.new"}},
 		"Existing .old directory is removed":       {destsAlreadyExist: map[string]string{"only-machine": "machine.old"}},
 
+		// incremental reload cases
+		"Computer, only changed profiles are reloaded":                    {destsAlreadyExist: map[string]string{"modified-machine": "machine"}, entries: []entry.Entry{{Key: "apparmor-machine", Value: "usr.bin.foo\nusr.bin.bar\nnested/usr.bin.baz"}}, existingLoadedPolicies: []string{"/usr/bin/foo", "/usr/bin/bar", "/usr/bin/baz"}},
+		"Computer, unchanged loaded profiles are not reloaded":            {destsAlreadyExist: map[string]string{"only-machine": "machine"}, entries: []entry.Entry{{Key: "apparmor-machine", Value: "usr.bin.foo\nusr.bin.bar\nnested/usr.bin.baz"}}, existingLoadedPolicies: []string{"/usr/bin/foo", "/usr/bin/bar", "/usr/bin/baz"}},
+		"Computer, unchanged profiles are reloaded if not loaded anymore": {destsAlreadyExist: map[string]string{"only-machine": "machine"}, existingLoadedPolicies: []string{"/usr/bin/bar"}},
+		"Computer, profiles including a changed file are reloaded":        {destsAlreadyExist: map[string]string{"including-modified-machine": "machine"}, entries: []entry.Entry{{Key: "apparmor-machine", Value: "usr.bin.qux\nnested/usr.bin.baz"}}, existingLoadedPolicies: []string{"/usr/bin/qux", "/usr/bin/baz"}},
+		"Computer, profiles including unchanged files are not reloaded":   {destsAlreadyExist: map[string]string{"including-machine": "machine"}, entries: []entry.Entry{{Key: "apparmor-machine", Value: "usr.bin.qux\nnested/usr.bin.baz"}}, existingLoadedPolicies: []string{"/usr/bin/qux", "/usr/bin/baz"}},
+
 		// shared cases
 		"No profiles, existing rules are removed": {entries: []entry.Entry{}, destsAlreadyExist: map[string]string{"only-machine": "machine"}, existingLoadedPolicies: []string{"/usr/bin/foo", "/usr/bin/bar", "/usr/bin/baz"}},
 		"No profiles, apparmor directory absent":  {entries: []entry.Entry{}, noParserOutput: true},
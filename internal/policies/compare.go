@@ -0,0 +1,152 @@
+package policies
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	log "github.com/ubuntu/adsys/internal/grpc/logstreamer"
+	"github.com/ubuntu/adsys/internal/i18n"
+	"github.com/ubuntu/decorate"
+	"gopkg.in/yaml.v3"
+)
+
+// enforcedEntry is the value enforced on the system for a key, with the GPOs providing it.
+// Entries of keys with the append strategy are merged, in the order of their GPOs.
+type enforcedEntry struct {
+	values []string
+	gpos   []string
+}
+
+// ComparePolicies displays the differences between the policies enforced on objectName and on otherName, with the
+// GPOs responsible for each differing key. The machine policies are compared too if computerOnly is false.
+func (m *Manager) ComparePolicies(ctx context.Context, objectName, otherName string, computerOnly bool) (msg string, err error) {
+	defer decorate.OnError(&err, i18n.G("failed to compare policies of %q and %q"), objectName, otherName)
+
+	log.Infof(ctx, "Comparing policies of %s and %s", objectName, otherName)
+
+	gpos, err := m.appliedGPOs(ctx, objectName, computerOnly)
+	if err != nil {
+		return "", err
+	}
+	otherGPOs, err := m.appliedGPOs(ctx, otherName, computerOnly)
+	if err != nil {
+		return "", err
+	}
+
+	return policiesDiff(objectName, gpos, otherName, otherGPOs), nil
+}
+
+// ComparePoliciesWithExport displays the differences between the policies enforced on objectName and the ones of
+// export, with the GPOs responsible for each differing key. The machine policies are compared too if computerOnly is
+// false.
+// export is the json or yaml output of DumpPoliciesStructured, for instance of the same user on another machine.
+func (m *Manager) ComparePoliciesWithExport(ctx context.Context, objectName string, computerOnly bool, export []byte) (msg string, err error) {
+	defer decorate.OnError(&err, i18n.G("failed to compare policies of %q with the export"), objectName)
+
+	log.Infof(ctx, "Comparing policies of %s with an export", objectName)
+
+	gpos, err := m.appliedGPOs(ctx, objectName, computerOnly)
+	if err != nil {
+		return "", err
+	}
+
+	// JSON is a subset of YAML: this parses both export formats.
+	var exported []AppliedGPO
+	if err := yaml.Unmarshal(export, &exported); err != nil {
+		return "", fmt.Errorf(i18n.G("invalid export: %v"), err)
+	}
+
+	return policiesDiff(objectName, gpos, i18n.G("the export"), exported), nil
+}
+
+// enforcedEntries returns the entries enforced by gpos, indexed by scope and policy manager, then by key.
+// Overridden and report-only entries are not enforced.
+func enforcedEntries(gpos []AppliedGPO) map[string]map[string]*enforcedEntry {
+	r := make(map[string]map[string]*enforcedEntry)
+	for _, g := range gpos {
+		for _, e := range g.Entries {
+			if e.Overridden || e.ReportOnly {
+				continue
+			}
+			section := fmt.Sprintf("%s %s", g.Scope, e.Manager)
+			if r[section] == nil {
+				r[section] = make(map[string]*enforcedEntry)
+			}
+			enforced := r[section][e.Key]
+			if enforced == nil {
+				enforced = &enforcedEntry{}
+				r[section][e.Key] = enforced
+			}
+			v := strings.ReplaceAll(strings.TrimSpace(e.Value), "\n", `\n`)
+			if e.Disabled {
+				v = i18n.G("(disabled)")
+			}
+			enforced.values = append(enforced.values, v)
+			enforced.gpos = append(enforced.gpos, g.Name)
+		}
+	}
+	return r
+}
+
+// String returns the enforced value, followed by the GPOs providing it, on a single line.
+func (e enforcedEntry) String() string {
+	return fmt.Sprintf("%s [%s]", strings.Join(e.values, `\n`), strings.Join(e.gpos, ", "))
+}
+
+// policiesDiff returns the keys enforced with a different value by gpos, applied to name, and by otherGPOs, applied
+// to otherName, grouped by scope and policy manager.
+func policiesDiff(name string, gpos []AppliedGPO, otherName string, otherGPOs []AppliedGPO) string {
+	entries, otherEntries := enforcedEntries(gpos), enforcedEntries(otherGPOs)
+
+	sections := make(map[string]struct{})
+	for s := range entries {
+		sections[s] = struct{}{}
+	}
+	for s := range otherEntries {
+		sections[s] = struct{}{}
+	}
+	var sortedSections []string
+	for s := range sections {
+		sortedSections = append(sortedSections, s)
+	}
+	sort.Strings(sortedSections)
+
+	var out strings.Builder
+	for _, s := range sortedSections {
+		var keys []string
+		for k := range entries[s] {
+			keys = append(keys, k)
+		}
+		for k := range otherEntries[s] {
+			if _, ok := entries[s][k]; !ok {
+				keys = append(keys, k)
+			}
+		}
+		sort.Strings(keys)
+
+		var changes []string
+		for _, k := range keys {
+			e, ok := entries[s][k]
+			otherE, otherOk := otherEntries[s][k]
+			switch {
+			case !otherOk:
+				changes = append(changes, fmt.Sprintf("  - %s: %s", k, e))
+			case !ok:
+				changes = append(changes, fmt.Sprintf("  + %s: %s", k, otherE))
+			case strings.Join(e.values, "\n") != strings.Join(otherE.values, "\n"):
+				changes = append(changes, fmt.Sprintf("  ~ %s: %s -> %s", k, e, otherE))
+			}
+		}
+		if len(changes) == 0 {
+			continue
+		}
+		fmt.Fprintf(&out, "* %s:\n%s\n", s, strings.Join(changes, "\n"))
+	}
+
+	if out.Len() == 0 {
+		return fmt.Sprintf(i18n.G("No policy difference between %s and %s.\n"), name, otherName)
+	}
+	return fmt.Sprintf(i18n.G("Policy differences between %s (-) and %s (+):\n%s"), name, otherName, out.String())
+}
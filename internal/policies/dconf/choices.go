@@ -0,0 +1,141 @@
+package dconf
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	log "github.com/ubuntu/adsys/internal/grpc/logstreamer"
+	"github.com/ubuntu/adsys/internal/i18n"
+)
+
+// InvalidChoiceError is returned when the value of an enum or choices key is not one of the values allowed by its
+// gsettings schema. GNOME silently ignores such values.
+type InvalidChoiceError struct {
+	Key     string
+	Value   string
+	Choices []string
+}
+
+func (e InvalidChoiceError) Error() string {
+	return fmt.Sprintf(i18n.G("%q is not one of the values allowed by the schema: %s"), e.Value, strings.Join(e.Choices, ", "))
+}
+
+// gschema is the subset of a gsettings schema file needed to know the allowed values of keys.
+type gschema struct {
+	Enum []struct {
+		ID    string `xml:"id,attr"`
+		Value []struct {
+			Nick string `xml:"nick,attr"`
+		} `xml:"value"`
+	} `xml:"enum"`
+	Schema []struct {
+		Path string `xml:"path,attr"`
+		Key  []struct {
+			Name    string `xml:"name,attr"`
+			Enum    string `xml:"enum,attr"`
+			Choices []struct {
+				Value string `xml:"value,attr"`
+			} `xml:"choices>choice"`
+		} `xml:"key"`
+	} `xml:"schema"`
+}
+
+// keyChoices returns the allowed values of the enum and choices keys of the installed gsettings schemas, indexed by
+// dconf key. They are only reloaded when the schemas directory changed.
+// Relocatable schemas are ignored, as their keys can't be matched without their path.
+func (m *Manager) keyChoices(ctx context.Context) map[string][]string {
+	m.choicesMu.Lock()
+	defer m.choicesMu.Unlock()
+
+	info, err := os.Stat(m.schemasDir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		log.Warningf(ctx, i18n.G("Can't check allowed values of dconf keys: %v"), err)
+		return nil
+	}
+	if m.choices != nil && info.ModTime().Equal(m.choicesModTime) {
+		return m.choices
+	}
+
+	log.Debugf(ctx, "Loading allowed values of dconf keys from %s", m.schemasDir)
+	// Like glib-compile-schemas, enums are also read from the generated enums files.
+	var schemas []string
+	for _, pattern := range []string{"*.gschema.xml", "*.enums.xml"} {
+		matches, err := filepath.Glob(filepath.Join(m.schemasDir, pattern))
+		if err != nil {
+			log.Warningf(ctx, i18n.G("Can't check allowed values of dconf keys: %v"), err)
+			return nil
+		}
+		schemas = append(schemas, matches...)
+	}
+
+	choices := make(map[string][]string)
+	enums := make(map[string][]string)
+	enumKeys := make(map[string]string)
+	for _, p := range schemas {
+		d, err := os.ReadFile(filepath.Clean(p))
+		if err != nil {
+			log.Warningf(ctx, i18n.G("Can't read gsettings schema %s: %v"), p, err)
+			continue
+		}
+		var s gschema
+		if err := xml.Unmarshal(d, &s); err != nil {
+			log.Warningf(ctx, i18n.G("Ignoring invalid gsettings schema %s: %v"), p, err)
+			continue
+		}
+
+		for _, e := range s.Enum {
+			for _, v := range e.Value {
+				enums[e.ID] = append(enums[e.ID], v.Nick)
+			}
+		}
+		for _, schema := range s.Schema {
+			if schema.Path == "" {
+				continue
+			}
+			for _, k := range schema.Key {
+				key := strings.Trim(schema.Path, "/") + "/" + k.Name
+				if k.Enum != "" {
+					// Enums can be defined in another schema file.
+					enumKeys[key] = k.Enum
+					continue
+				}
+				for _, c := range k.Choices {
+					choices[key] = append(choices[key], c.Value)
+				}
+			}
+		}
+	}
+	for key, id := range enumKeys {
+		if nicks, ok := enums[id]; ok {
+			choices[key] = nicks
+		}
+	}
+
+	m.choices, m.choicesModTime = choices, info.ModTime()
+	return choices
+}
+
+// checkChoice returns the quoted string value if it is one of choices. A value only differing by its case, like one
+// typed from the translated label of the choice, is corrected to the choice as gsettings is case-sensitive.
+func checkChoice(key, value string, choices []string) (string, error) {
+	v := strings.TrimSuffix(strings.TrimPrefix(value, "'"), "'")
+	for _, c := range choices {
+		if v == c {
+			return value, nil
+		}
+	}
+	for _, c := range choices {
+		if strings.EqualFold(v, c) {
+			return quoteValue(c), nil
+		}
+	}
+	return "", InvalidChoiceError{Key: key, Value: v, Choices: choices}
+}
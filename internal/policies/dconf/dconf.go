@@ -9,8 +9,10 @@
 //
 // The manager will parse the values and try to fix some formatting problems, but if something goes
 // wrong when applying the profile or updating dconf, an error is returned.
-// However, ADSys will not check for the correctness of the values being assigned and it's up to the
-// admin to ensure that the requested value is assignable to the key it is being assigned to.
+// Values of keys with a list of allowed values in the installed gsettings schemas, like enums, are checked against it
+// and a value which is not allowed fails the policy. Otherwise, ADSys will not check for the correctness of the values
+// being assigned and it's up to the admin to ensure that the requested value is assignable to the key it is being
+// assigned to.
 //
 // Notes or common keys between user and machine:
 //
@@ -23,6 +25,30 @@
 // -> the lock will "stick" the desired value to the layer of current value of Machine. As machine doesn’t have any
 // value and is the lowest in the stack (the first one to be processed), this will thus enforce the default system
 // configuration for that setting.
+//
+// Preferences:
+//
+// Entries with an action come from Group Policy Preferences. They are written without any lock in a separate
+// adsys-preferences key file of the same database, so that they are only defaults that users can change.
+// The key file is also the state of applied preferences and the action decides how to handle an existing value:
+//   - create only sets the value if the preference was not set yet;
+//   - replace always sets the value;
+//   - update sets the value, unless the new value is empty;
+//   - delete removes the preference.
+//
+// Preferences flagged as "apply once" are never updated once set, until they are removed from the GPO.
+// A key which is enforced by a policy ignores any preference for it.
+//
+// User shards:
+//
+// By default, user databases are next to the machine one and refreshing a user compiles the whole dconf
+// databases directory. With user shards, user databases are instead in a dedicated adsys-users directory
+// (system-db:adsys-users/<username>), which is the only one compiled on a user refresh. This avoids touching the
+// machine database when refreshing users on terminal servers with many of them.
+//
+// Applying policies for many users can be batched between BeginBatch and EndBatch: dconf update then runs
+// only once, at the end of the batch. Only the policies applied with the context of the batch are deferred, so that
+// concurrent refreshes, like at login, are compiled right away.
 package dconf
 
 import (
@@ -38,6 +64,7 @@ import (
 	"sort"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/godbus/dbus/v5"
 	"github.com/ubuntu/adsys/internal/consts"
@@ -46,6 +73,7 @@ import (
 	"github.com/ubuntu/adsys/internal/policies/entry"
 	"github.com/ubuntu/adsys/internal/smbsafe"
 	"github.com/ubuntu/decorate"
+	"golang.org/x/exp/slices"
 )
 
 // Manager prevents running multiple dconf update process in parallel while parsing policy in ApplyPolicy.
@@ -55,13 +83,145 @@ type Manager struct {
 	dconfMu sync.RWMutex
 	// dconfUpdateMu prevents running multiple dconf update processes in parallel.
 	dconfUpdateMu sync.Mutex
+	// usersUpdateMu prevents running multiple dconf update processes in parallel on the user shards.
+	usersUpdateMu sync.Mutex
+
+	dconfDir   string
+	userShards bool
+
+	// choicesMu protects the cache of allowed values of keys below.
+	choicesMu sync.Mutex
+	// choices are the allowed values of keys, loaded from schemasDir when it was last modified at choicesModTime.
+	choices        map[string][]string
+	choicesModTime time.Time
+	schemasDir     string
+}
+
+type options struct {
+	userShards bool
+	schemasDir string
+}
 
-	dconfDir string
+// Option reprents an optional function to change the dconf manager.
+type Option func(*options)
+
+// WithUserShards stores the user databases in their own dconf databases directory.
+func WithUserShards(enabled bool) Option {
+	return func(o *options) {
+		o.userShards = enabled
+	}
+}
+
+// WithSchemasDir specifies a personalized directory of gsettings schemas to check allowed values of keys from.
+func WithSchemasDir(dir string) Option {
+	return func(o *options) {
+		o.schemasDir = dir
+	}
 }
 
+// usersShardDir is the directory, under the dconf databases one, containing the sharded user databases.
+const usersShardDir = "adsys-users"
+
 // NewWithDconfDir creates a manager with a specific dconf directory.
-func NewWithDconfDir(dir string) *Manager {
-	return &Manager{dconfDir: dir}
+func NewWithDconfDir(dir string, opts ...Option) *Manager {
+	// defaults
+	args := options{
+		schemasDir: consts.DefaultGSettingsSchemasDir,
+	}
+	// applied options
+	for _, o := range opts {
+		o(&args)
+	}
+
+	return &Manager{
+		dconfDir:   dir,
+		userShards: args.userShards,
+		schemasDir: args.schemasDir,
+	}
+}
+
+// batchKey is the context key of the batch of user policies being applied.
+type batchKey struct{}
+
+// batch collects the databases directories to update at the end of a batch of user policies.
+type batch struct {
+	mu   sync.Mutex
+	dirs map[string]struct{}
+}
+
+// BeginBatch returns a context starting a batch: user databases are not compiled anymore after applying a user
+// policy with this context, until EndBatch is called with it.
+func (m *Manager) BeginBatch(ctx context.Context) context.Context {
+	return context.WithValue(ctx, batchKey{}, &batch{dirs: make(map[string]struct{})})
+}
+
+// EndBatch ends the batch started by BeginBatch in ctx: the databases changed in the meantime are compiled with a
+// single dconf update.
+func (m *Manager) EndBatch(ctx context.Context) {
+	b, ok := ctx.Value(batchKey{}).(*batch)
+	if !ok {
+		return
+	}
+	b.mu.Lock()
+	dirs := make([]string, 0, len(b.dirs))
+	for dir := range b.dirs {
+		dirs = append(dirs, dir)
+	}
+	b.dirs = make(map[string]struct{})
+	b.mu.Unlock()
+
+	sort.Strings(dirs)
+	for _, dir := range dirs {
+		log.Debugf(ctx, "Updating dconf databases in %s for the batch of user policies", dir)
+		m.update(ctx, dir)
+	}
+}
+
+// deferUpdate records dir to be updated at the end of the batch of ctx.
+// It returns false if ctx is not in a batch.
+func deferUpdate(ctx context.Context, dir string) bool {
+	b, ok := ctx.Value(batchKey{}).(*batch)
+	if !ok {
+		return false
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.dirs[dir] = struct{}{}
+	return true
+}
+
+// update runs dconf update on the databases directory dir.
+// Sharded user databases and the main databases directory are updated independently.
+func (m *Manager) update(ctx context.Context, dir string) {
+	mu := &m.dconfUpdateMu
+	if filepath.Base(dir) == usersShardDir {
+		mu = &m.usersUpdateMu
+	}
+
+	smbsafe.WaitExec()
+	mu.Lock()
+	// #nosec G204 - we control the input
+	out, err := exec.Command("dconf", "update", dir).CombinedOutput()
+	mu.Unlock()
+	smbsafe.DoneExec()
+	if err != nil {
+		log.Warningf(ctx, i18n.G("dconf update failed: %v"), string(out))
+	}
+}
+
+// Destinations returns the profiles and databases directories managed by the dconf manager.
+// User databases outside of the users shard are not included, as they are only known once applied.
+func (m *Manager) Destinations() []string {
+	dconfDir := m.dconfDir
+	if dconfDir == "" {
+		dconfDir = consts.DefaultDconfDir
+	}
+	return []string{
+		filepath.Join(dconfDir, "profile"),
+		filepath.Join(dconfDir, "db", "machine.d"),
+		filepath.Join(dconfDir, "db", usersShardDir),
+	}
 }
 
 // ApplyPolicy generates a dconf computer or user policy based on a list of entries.
@@ -93,7 +253,16 @@ func (m *Manager) ApplyPolicy(ctx context.Context, objectName string, isComputer
 	}
 	profilesPath := filepath.Join(dconfDir, "profile")
 	dbsPath := filepath.Join(dconfDir, "db")
-	dbPath := filepath.Join(dbsPath, objectName+".d")
+	usersShardPath := filepath.Join(dbsPath, usersShardDir)
+
+	// User databases are either in the main databases directory or in their shard.
+	objectDBsPath, otherDBsPath := dbsPath, usersShardPath
+	dbName := objectName
+	if !isComputer && m.userShards {
+		objectDBsPath, otherDBsPath = usersShardPath, dbsPath
+		dbName = filepath.Join(usersShardDir, objectName)
+	}
+	dbPath := filepath.Join(objectDBsPath, objectName+".d")
 
 	if !isComputer && len(entries) > 0 {
 		if _, err := os.Stat(filepath.Join(dbsPath, "machine.d", "locks", "adsys")); err != nil {
@@ -104,11 +273,10 @@ func (m *Manager) ApplyPolicy(ctx context.Context, objectName string, isComputer
 	// Only clean up user databases/profiles if there are no entries to apply.
 	// We don't clean up the machine database because we don't know if there's any user GPO depending on it.
 	if !isComputer && len(entries) == 0 {
-		if err := os.RemoveAll(dbPath); err != nil {
-			return fmt.Errorf(i18n.G("can't remove user dconf database directory: %v"), err)
-		}
-		if er := os.RemoveAll(filepath.Join(dbsPath, objectName)); er != nil {
-			return fmt.Errorf(i18n.G("can't remove user dconf binary database: %v"), er)
+		for _, p := range []string{objectDBsPath, otherDBsPath} {
+			if err := removeUserDB(p, objectName); err != nil {
+				return err
+			}
 		}
 		if err := os.RemoveAll(filepath.Join(profilesPath, objectName)); err != nil {
 			return fmt.Errorf(i18n.G("can't remove user dconf profile: %v"), err)
@@ -123,16 +291,31 @@ func (m *Manager) ApplyPolicy(ctx context.Context, objectName string, isComputer
 		if err := os.MkdirAll(profilesPath, 0755); err != nil {
 			return err
 		}
-		if err := writeProfile(ctx, objectName, profilesPath); err != nil {
+		if err := writeProfile(ctx, objectName, dbName, profilesPath); err != nil {
 			return err
 		}
+		// Remove the user database from its previous location when user shards were switched.
+		if err := removeUserDB(otherDBsPath, objectName); err != nil {
+			return err
+		}
+	}
+
+	// Preferences are handled separately as they are not enforced
+	var policyEntries, prefEntries []entry.Entry
+	for _, e := range entries {
+		if e.Action != "" {
+			prefEntries = append(prefEntries, e)
+			continue
+		}
+		policyEntries = append(policyEntries, e)
 	}
 
 	// Generate defaults and locks content from policy
+	choices := m.keyChoices(ctx)
 	dataWithGroups := make(map[string][]string)
 	var locks []string
-	var errMsgs []string
-	for _, e := range entries {
+	var errs []error
+	for _, e := range policyEntries {
 		log.Debugf(ctx, "Analyzing entry %+v", e)
 
 		if !e.Disabled {
@@ -141,9 +324,17 @@ func (m *Manager) ApplyPolicy(ctx context.Context, objectName string, isComputer
 			// normalize common user error cases and check gsettings schema signature match.
 			e.Value = normalizeValue(e.Meta, e.Value)
 			if err := checkSignature(e.Meta, e.Value); err != nil {
-				errMsgs = append(errMsgs, fmt.Sprintf(i18n.G("- error on %s: %v"), e.Key, err))
+				errs = append(errs, fmt.Errorf(i18n.G("- error on %s: %w"), e.Key, err))
 				continue
 			}
+			if c, ok := choices[e.Key]; ok && e.Meta == "s" {
+				v, err := checkChoice(e.Key, e.Value, c)
+				if err != nil {
+					errs = append(errs, fmt.Errorf(i18n.G("- error on %s: %w"), e.Key, err))
+					continue
+				}
+				e.Value = v
+			}
 
 			l := fmt.Sprintf("%s=%s", filepath.Base(e.Key), e.Value)
 			dataWithGroups[section] = append(dataWithGroups[section], l)
@@ -151,9 +342,13 @@ func (m *Manager) ApplyPolicy(ctx context.Context, objectName string, isComputer
 		locks = append(locks, "/"+e.Key)
 	}
 
+	prefsPath := filepath.Join(dbPath, "adsys-preferences")
+	prefs, errPrefs := preferencesContent(ctx, prefsPath, prefEntries, locks, choices)
+	errs = append(errs, errPrefs...)
+
 	// Stop on any error
-	if errMsgs != nil {
-		return errors.New(strings.Join(errMsgs, "\n"))
+	if errs != nil {
+		return errors.Join(errs...)
 	}
 
 	// Prepare file contents
@@ -192,30 +387,177 @@ func (m *Manager) ApplyPolicy(ctx context.Context, objectName string, isComputer
 	}
 	needsRefresh = needsRefresh || changed
 
-	// update if any profile changed, or if any compiled db is missing
-	needsRefresh = needsRefresh || dconfNeedsUpdate(filepath.Join(dbsPath, "machine"))
+	if prefs != "" {
+		changed, err = writeIfChanged(prefsPath, prefs)
+		if err != nil {
+			return err
+		}
+		needsRefresh = needsRefresh || changed
+	} else if err := os.Remove(prefsPath); err == nil {
+		needsRefresh = true
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf(i18n.G("can't remove dconf preferences: %v"), err)
+	}
+
+	// update if any profile changed, or if any compiled db is missing.
+	// Sharded user databases don't compile the machine one.
+	if objectDBsPath == dbsPath {
+		needsRefresh = needsRefresh || dconfNeedsUpdate(filepath.Join(dbsPath, "machine"))
+	}
 	if !isComputer {
-		needsRefresh = needsRefresh || dconfNeedsUpdate(filepath.Join(dbsPath, objectName))
+		needsRefresh = needsRefresh || dconfNeedsUpdate(filepath.Join(objectDBsPath, objectName))
 	}
 	if !needsRefresh {
 		return nil
 	}
 
+	if !isComputer && deferUpdate(ctx, objectDBsPath) {
+		log.Debugf(ctx, "Deferring dconf update for %s to the end of the batch", objectName)
+		return nil
+	}
+
 	// request an update now that we released the read lock
 	// we will call update multiple times.
-	smbsafe.WaitExec()
-	m.dconfUpdateMu.Lock()
-	// #nosec G204 - we control the input
-	out, errExec := exec.Command("dconf", "update", filepath.Join(dconfDir, "db")).CombinedOutput()
-	m.dconfUpdateMu.Unlock()
-	smbsafe.DoneExec()
-	if errExec != nil {
-		err = fmt.Errorf(i18n.G("dconf update failed: %v"), out)
-	}
+	m.update(ctx, objectDBsPath)
+
+	return nil
+}
 
+// removeUserDB removes the database directory and binary database of user in dbsPath, if any.
+func removeUserDB(dbsPath, user string) error {
+	if err := os.RemoveAll(filepath.Join(dbsPath, user+".d")); err != nil {
+		return fmt.Errorf(i18n.G("can't remove user dconf database directory: %v"), err)
+	}
+	if err := os.RemoveAll(filepath.Join(dbsPath, user)); err != nil {
+		return fmt.Errorf(i18n.G("can't remove user dconf binary database: %v"), err)
+	}
 	return nil
 }
 
+// preference is a value set in the preferences key file.
+type preference struct {
+	value     string
+	applyOnce bool
+}
+
+const applyOnceMarker = "# apply-once"
+
+// preferencesContent returns the new content of the preferences key file at path from the entries.
+// Keys which are enforced by locks are ignored. It returns an empty content if there is no preference to set.
+// Values of keys in choices must be one of their allowed values.
+func preferencesContent(ctx context.Context, path string, entries []entry.Entry, locks []string, choices map[string][]string) (content string, errs []error) {
+	previous := loadPreferences(path)
+
+	enforced := make(map[string]struct{})
+	for _, l := range locks {
+		enforced[strings.TrimPrefix(l, "/")] = struct{}{}
+	}
+
+	prefs := make(map[string]preference)
+	for _, e := range entries {
+		if _, ok := enforced[e.Key]; ok {
+			log.Debugf(ctx, "Ignoring preference %q as it is enforced by a policy", e.Key)
+			continue
+		}
+
+		prev, hasPrev := previous[e.Key]
+		if e.ApplyOnce && hasPrev && prev.applyOnce && e.Action != entry.ActionDelete {
+			prefs[e.Key] = prev
+			continue
+		}
+
+		switch e.Action {
+		case entry.ActionDelete:
+			continue
+		case entry.ActionCreate:
+			if hasPrev {
+				prefs[e.Key] = preference{value: prev.value, applyOnce: e.ApplyOnce}
+				continue
+			}
+		case entry.ActionUpdate:
+			if hasPrev && strings.TrimSpace(e.Value) == "" {
+				prefs[e.Key] = preference{value: prev.value, applyOnce: e.ApplyOnce}
+				continue
+			}
+		case entry.ActionReplace:
+		default:
+			errs = append(errs, fmt.Errorf(i18n.G("- error on %s: unknown preference action %q"), e.Key, e.Action))
+			continue
+		}
+
+		v := normalizeValue(e.Meta, e.Value)
+		if err := checkSignature(e.Meta, v); err != nil {
+			errs = append(errs, fmt.Errorf(i18n.G("- error on %s: %w"), e.Key, err))
+			continue
+		}
+		if c, ok := choices[e.Key]; ok && e.Meta == "s" {
+			var err error
+			if v, err = checkChoice(e.Key, v, c); err != nil {
+				errs = append(errs, fmt.Errorf(i18n.G("- error on %s: %w"), e.Key, err))
+				continue
+			}
+		}
+		prefs[e.Key] = preference{value: v, applyOnce: e.ApplyOnce}
+	}
+
+	if len(prefs) == 0 {
+		return "", errs
+	}
+
+	// Order keys to have a reliable output
+	keys := make([]string, 0, len(prefs))
+	for k := range prefs {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	data := []string{"# Preferences managed by adsys, which can be changed by users."}
+	var currentSection string
+	for _, k := range keys {
+		if section := filepath.Dir(k); section != currentSection {
+			currentSection = section
+			data = append(data, fmt.Sprintf("[%s]", section))
+		}
+		if prefs[k].applyOnce {
+			data = append(data, applyOnceMarker)
+		}
+		data = append(data, fmt.Sprintf("%s=%s", filepath.Base(k), prefs[k].value))
+	}
+
+	return strings.Join(data, "\n") + "\n", errs
+}
+
+// loadPreferences parses the preferences key file we previously wrote at path.
+func loadPreferences(path string) map[string]preference {
+	prefs := make(map[string]preference)
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return prefs
+	}
+
+	var section string
+	var applyOnce bool
+	for _, l := range strings.Split(string(content), "\n") {
+		switch {
+		case l == applyOnceMarker:
+			applyOnce = true
+			continue
+		case strings.HasPrefix(l, "#") || l == "":
+		case strings.HasPrefix(l, "[") && strings.HasSuffix(l, "]"):
+			section = strings.TrimSuffix(strings.TrimPrefix(l, "["), "]")
+		default:
+			k, v, found := strings.Cut(l, "=")
+			if found {
+				prefs[section+"/"+k] = preference{value: v, applyOnce: applyOnce}
+			}
+		}
+		applyOnce = false
+	}
+
+	return prefs
+}
+
 // writeIfChanged will only write to path if content is different from current content.
 func writeIfChanged(path string, content string) (done bool, err error) {
 	defer decorate.OnError(&err, i18n.G("can't save %s"), path)
@@ -235,17 +577,19 @@ func writeIfChanged(path string, content string) (done bool, err error) {
 	return true, nil
 }
 
-// writeProfile creates or updates a dconf profile file.
+// writeProfile creates or updates a dconf profile file, referencing the user database dbName.
 // The adsys system-db should always be the first system-db in the file to enforce their values
 // (upper system-db in the profile wins).
-func writeProfile(ctx context.Context, user, profilesPath string) (err error) {
+func writeProfile(ctx context.Context, user, dbName, profilesPath string) (err error) {
 	defer decorate.OnError(&err, i18n.G("can't update user profile %s"), profilesPath)
 
 	profilePath := filepath.Join(profilesPath, user)
 	log.Debugf(ctx, "Update user profile %s", profilePath)
 
 	adsysMachineDB := "system-db:machine"
-	adsysUserDB := fmt.Sprintf("system-db:%s", user)
+	adsysUserDB := fmt.Sprintf("system-db:%s", dbName)
+	// Both user database layouts are replaced, in case user shards were switched.
+	adsysUserDBs := []string{fmt.Sprintf("system-db:%s", user), fmt.Sprintf("system-db:%s", filepath.Join(usersShardDir, user))}
 
 	// Read existing content and create file if doesn’t exists
 	content, err := os.ReadFile(profilePath)
@@ -261,7 +605,7 @@ func writeProfile(ctx context.Context, user, profilesPath string) (err error) {
 	var out []string
 	for _, d := range bytes.Split(bytes.TrimSpace(content), []byte("\n")) {
 		// Add current line if it’s not an adsys one
-		if string(d) == adsysMachineDB || string(d) == adsysUserDB {
+		if string(d) == adsysMachineDB || slices.Contains(adsysUserDBs, string(d)) {
 			continue
 		}
 		out = append(out, string(d))
@@ -428,6 +772,22 @@ func splitOnNonEscaped(v, sep string) []string {
 	return tokens
 }
 
+// TypedValue returns value, normalized as it is applied, parsed as a variant of type meta, so that it can be
+// reported in its native type: a string, a boolean, a number or a list of them.
+func TypedValue(meta, value string) (typed any, err error) {
+	defer decorate.OnError(&err, i18n.G("can't parse value as %q"), meta)
+
+	sig, err := dbus.ParseSignature(meta)
+	if err != nil {
+		return nil, err
+	}
+	v, err := dbus.ParseVariant(normalizeValue(meta, value), sig)
+	if err != nil {
+		return nil, err
+	}
+	return v.Value(), nil
+}
+
 // checkSignature returns an error if the value doesn't match the expected variant signature.
 func checkSignature(meta, value string) (err error) {
 	defer decorate.OnError(&err, i18n.G("error while checking signature"))
@@ -21,6 +21,9 @@ func TestApplyPolicy(t *testing.T) {
 		isComputer       bool
 		entries          []entry.Entry
 		existingDconfDir string
+		userShards       bool
+		inBatch          bool
+		schemasDir       string
 
 		wantErr bool
 	}{
@@ -78,6 +81,19 @@ func TestApplyPolicy(t *testing.T) {
 			{Key: "com/ubuntu/category/key-as", Value: "['simple-as']", Meta: "as"},
 		}},
 
+		// User shards cases
+		"New user with user shards": {userShards: true},
+		"Existing user is moved to user shards": {entries: []entry.Entry{
+			{Key: "com/ubuntu/category/key-s", Value: "'onekey-s-thirdvalue'", Meta: "s"}},
+			existingDconfDir: "existing-user", userShards: true},
+		"Existing sharded user is moved back without user shards": {entries: []entry.Entry{
+			{Key: "com/ubuntu/category/key-s", Value: "'onekey-s-thirdvalue'", Meta: "s"}},
+			existingDconfDir: "existing-sharded-user"},
+		"Sharded user empty state":         {entries: []entry.Entry{}, existingDconfDir: "existing-sharded-user", userShards: true},
+		"Machine is not sharded":           {isComputer: true, userShards: true},
+		"User in a batch":                  {inBatch: true},
+		"User in a batch with user shards": {inBatch: true, userShards: true},
+
 		// Update edge cases
 		"No update when no change": {entries: []entry.Entry{
 			{Key: "com/ubuntu/category/key-s", Value: "'onekey-s-othervalue'", Meta: "s"}},
@@ -171,7 +187,95 @@ func TestApplyPolicy(t *testing.T) {
 			{Key: "com/ubuntu/category/key-as", Value: `[value1, ] value2]`, Meta: "as"},
 		}},
 
+		// Preferences
+		"Preferences are set without locks": {entries: []entry.Entry{
+			{Key: "com/ubuntu/category/key-s", Value: "onekey-s", Meta: "s", Action: entry.ActionUpdate},
+			{Key: "com/ubuntu/category/key-b", Value: "true", Meta: "b", Action: entry.ActionCreate},
+			{Key: "com/ubuntu/category2/key-i", Value: "42", Meta: "i", Action: entry.ActionReplace},
+			{Key: "com/ubuntu/category2/key-as", Value: "first\nsecond", Meta: "as", Action: entry.ActionUpdate, ApplyOnce: true},
+		}},
+		"Preferences for machine": {entries: []entry.Entry{
+			{Key: "com/ubuntu/category/key-s", Value: "onekey-s", Meta: "s", Action: entry.ActionUpdate},
+		}, isComputer: true},
+		"Preferences only for user": {entries: []entry.Entry{
+			{Key: "com/ubuntu/category/key-s", Value: "onekey-s", Meta: "s", Action: entry.ActionUpdate},
+		}, existingDconfDir: "existing-user"},
+		"Preferences mixed with policies": {entries: []entry.Entry{
+			{Key: "com/ubuntu/category/key-s", Value: "'onekey-s-othervalue'", Meta: "s"},
+			{Key: "com/ubuntu/category/key-b", Value: "true", Meta: "b", Action: entry.ActionUpdate},
+		}},
+		"Preferences are ignored on keys enforced by policies": {entries: []entry.Entry{
+			{Key: "com/ubuntu/category/key-s", Value: "'onekey-s-othervalue'", Meta: "s"},
+			{Key: "com/ubuntu/category/key-b", Disabled: true, Meta: "b"},
+			{Key: "com/ubuntu/category/key-s", Value: "preference", Meta: "s", Action: entry.ActionReplace},
+			{Key: "com/ubuntu/category/key-b", Value: "true", Meta: "b", Action: entry.ActionReplace},
+		}},
+		"Preferences create keeps existing value": {entries: []entry.Entry{
+			{Key: "com/ubuntu/category/key-s", Value: "new-value", Meta: "s", Action: entry.ActionCreate},
+		}, existingDconfDir: "existing-user-with-preferences"},
+		"Preferences replace existing value": {entries: []entry.Entry{
+			{Key: "com/ubuntu/category/key-s", Value: "new-value", Meta: "s", Action: entry.ActionReplace},
+		}, existingDconfDir: "existing-user-with-preferences"},
+		"Preferences update existing value": {entries: []entry.Entry{
+			{Key: "com/ubuntu/category/key-i", Value: "2", Meta: "i", Action: entry.ActionUpdate},
+		}, existingDconfDir: "existing-user-with-preferences"},
+		"Preferences update with empty value keeps existing value": {entries: []entry.Entry{
+			{Key: "com/ubuntu/category/key-i", Value: "", Meta: "i", Action: entry.ActionUpdate},
+		}, existingDconfDir: "existing-user-with-preferences"},
+		"Preferences delete removes existing value": {entries: []entry.Entry{
+			{Key: "com/ubuntu/category/key-s", Meta: "s", Action: entry.ActionDelete},
+			{Key: "com/ubuntu/category/key-b", Value: "false", Meta: "b", Action: entry.ActionUpdate},
+		}, existingDconfDir: "existing-user-with-preferences"},
+		"Preferences applied once are not reapplied": {entries: []entry.Entry{
+			{Key: "com/ubuntu/category/key-once", Value: "second-value", Meta: "s", Action: entry.ActionReplace, ApplyOnce: true},
+		}, existingDconfDir: "existing-user-with-preferences"},
+		"Preferences not applied once anymore are reapplied": {entries: []entry.Entry{
+			{Key: "com/ubuntu/category/key-once", Value: "second-value", Meta: "s", Action: entry.ActionReplace},
+		}, existingDconfDir: "existing-user-with-preferences"},
+		"Preferences become applied once on existing value": {entries: []entry.Entry{
+			{Key: "com/ubuntu/category/key-s", Value: "new-value", Meta: "s", Action: entry.ActionReplace, ApplyOnce: true},
+		}, existingDconfDir: "existing-user-with-preferences"},
+		"Preferences no longer applied are removed": {entries: []entry.Entry{
+			{Key: "com/ubuntu/category/key-s", Value: "'onekey-s-othervalue'", Meta: "s"},
+		}, existingDconfDir: "existing-user-with-preferences"},
+
+		// Keys with allowed values in schemas
+		"Allowed enum value": {entries: []entry.Entry{
+			{Key: "com/ubuntu/category/key-enum", Value: "large", Meta: "s"},
+		}},
+		"Allowed choices value": {entries: []entry.Entry{
+			{Key: "com/ubuntu/category/key-choices", Value: "'right'", Meta: "s"},
+		}},
+		"Allowed value with a different case is corrected": {entries: []entry.Entry{
+			{Key: "com/ubuntu/category/key-enum", Value: "Large", Meta: "s"},
+			{Key: "com/ubuntu/category/key-choices", Value: "RIGHT", Meta: "s", Action: entry.ActionReplace},
+		}},
+		"Keys of relocatable schemas are not checked": {entries: []entry.Entry{
+			{Key: "com/ubuntu/category/key-relocatable", Value: "anything", Meta: "s"},
+		}},
+		"Disabled keys with allowed values are not checked": {entries: []entry.Entry{
+			{Key: "com/ubuntu/category/key-enum", Disabled: true, Meta: "s"},
+		}},
+		"Missing schemas directory does not check values": {entries: []entry.Entry{
+			{Key: "com/ubuntu/category/key-enum", Value: "huge", Meta: "s"},
+		}, schemasDir: "does-not-exist"},
+
 		// Error cases
+		"Error on value not allowed by enum": {entries: []entry.Entry{
+			{Key: "com/ubuntu/category/key-enum", Value: "huge", Meta: "s"},
+		}, wantErr: true},
+		"Error on value not in choices": {entries: []entry.Entry{
+			{Key: "com/ubuntu/category/key-choices", Value: "center", Meta: "s"},
+		}, wantErr: true},
+		"Error on preference value not allowed by enum": {entries: []entry.Entry{
+			{Key: "com/ubuntu/category/key-enum", Value: "huge", Meta: "s", Action: entry.ActionReplace},
+		}, wantErr: true},
+		"Error on invalid preference value": {entries: []entry.Entry{
+			{Key: "com/ubuntu/category/key-i", Value: "NaN", Meta: "i", Action: entry.ActionUpdate},
+		}, wantErr: true},
+		"Error on unknown preference action": {entries: []entry.Entry{
+			{Key: "com/ubuntu/category/key-i", Value: "1", Meta: "i", Action: "unknown"},
+		}, wantErr: true},
 		"Error when machine db does not exist": {entries: []entry.Entry{
 			{Key: "com/ubuntu/category/key-s", Value: "'onekey-s-othervalue'", Meta: "s"},
 		}, existingDconfDir: "-", wantErr: true},
@@ -213,8 +317,19 @@ func TestApplyPolicy(t *testing.T) {
 					"Setup: can't create initial dconf directory")
 			}
 
-			m := dconf.NewWithDconfDir(dconfDir)
-			err := m.ApplyPolicy(context.Background(), "ubuntu", tc.isComputer, tc.entries)
+			if tc.schemasDir == "" {
+				tc.schemasDir = "schemas"
+			}
+
+			m := dconf.NewWithDconfDir(dconfDir, dconf.WithUserShards(tc.userShards), dconf.WithSchemasDir(filepath.Join("testdata", tc.schemasDir)))
+			ctx := context.Background()
+			if tc.inBatch {
+				ctx = m.BeginBatch(ctx)
+			}
+			err := m.ApplyPolicy(ctx, "ubuntu", tc.isComputer, tc.entries)
+			if tc.inBatch {
+				m.EndBatch(ctx)
+			}
 			if tc.wantErr {
 				require.NotNil(t, err, "ApplyPolicy should have failed but didn't")
 				return
@@ -226,6 +341,33 @@ func TestApplyPolicy(t *testing.T) {
 	}
 }
 
+func TestBatchOnlyDefersItsOwnUpdates(t *testing.T) {
+	t.Parallel()
+
+	dconfDir := t.TempDir()
+	require.NoError(t, os.Remove(dconfDir), "Setup: can't delete dconf base directory before recreation")
+	require.NoError(t,
+		shutil.CopyTree(
+			filepath.Join("testdata", "TestApplyPolicy", "dconf", "machine-base"), dconfDir,
+			&shutil.CopyTreeOptions{Symlinks: true, CopyFunction: shutil.Copy}),
+		"Setup: can't create initial dconf directory")
+	entries := []entry.Entry{{Key: "com/ubuntu/category/key-s", Value: "'onekey-s-othervalue'", Meta: "s"}}
+
+	m := dconf.NewWithDconfDir(dconfDir, dconf.WithSchemasDir(filepath.Join("testdata", "schemas")))
+	batchCtx := m.BeginBatch(context.Background())
+
+	err := m.ApplyPolicy(batchCtx, "alice", false, entries)
+	require.NoError(t, err, "ApplyPolicy failed but shouldn't have")
+	require.NoFileExists(t, filepath.Join(dconfDir, "db", "alice"), "ApplyPolicy should defer the update of the users in the batch")
+
+	err = m.ApplyPolicy(context.Background(), "bob", false, entries)
+	require.NoError(t, err, "ApplyPolicy failed but shouldn't have")
+	require.FileExists(t, filepath.Join(dconfDir, "db", "bob"), "ApplyPolicy should update the users outside of the batch right away")
+
+	m.EndBatch(batchCtx)
+	require.FileExists(t, filepath.Join(dconfDir, "db", "alice"), "EndBatch should update the users of the batch")
+}
+
 func TestMain(m *testing.M) {
 	testutils.InstallUpdateFlag()
 	flag.Parse()
@@ -0,0 +1,138 @@
+package policies
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/ubuntu/adsys/internal/i18n"
+	"github.com/ubuntu/adsys/internal/policies/entry"
+)
+
+// writeRulesDiff writes to w the entries added, removed and changed by newRules compared to oldRules,
+// grouped by policy type.
+func writeRulesDiff(w io.Writer, objectName string, isComputer bool, oldRules, newRules map[string][]entry.Entry) {
+	changes := rulesDiff(oldRules, newRules)
+	if changes == "" {
+		fmt.Fprintf(w, i18n.G("No policy change for %s (machine: %v).\n"), objectName, isComputer)
+		return
+	}
+	fmt.Fprintf(w, i18n.G("Policy changes for %s (machine: %v):\n%s"), objectName, isComputer, changes)
+}
+
+// rulesDiff returns the entries added, removed and changed by newRules compared to oldRules, grouped by policy type.
+// It is empty if there is no change.
+func rulesDiff(oldRules, newRules map[string][]entry.Entry) string {
+	types := make(map[string]struct{})
+	for t := range oldRules {
+		types[t] = struct{}{}
+	}
+	for t := range newRules {
+		types[t] = struct{}{}
+	}
+	var sortedTypes []string
+	for t := range types {
+		sortedTypes = append(sortedTypes, t)
+	}
+	sort.Strings(sortedTypes)
+
+	var out strings.Builder
+	for _, t := range sortedTypes {
+		oldEntries := make(map[string]entry.Entry)
+		for _, e := range oldRules[t] {
+			oldEntries[e.Key] = e
+		}
+		newEntries := make(map[string]entry.Entry)
+		for _, e := range newRules[t] {
+			newEntries[e.Key] = e
+		}
+
+		var keys []string
+		for k := range oldEntries {
+			keys = append(keys, k)
+		}
+		for k := range newEntries {
+			if _, ok := oldEntries[k]; !ok {
+				keys = append(keys, k)
+			}
+		}
+		sort.Strings(keys)
+
+		var changes []string
+		for _, k := range keys {
+			oldE, hadOld := oldEntries[k]
+			newE, hasNew := newEntries[k]
+			switch {
+			case !hadOld:
+				changes = append(changes, fmt.Sprintf("  + %s: %s", k, diffValue(newE)))
+			case !hasNew:
+				changes = append(changes, fmt.Sprintf("  - %s: %s", k, diffValue(oldE)))
+			case diffValue(oldE) != diffValue(newE):
+				changes = append(changes, fmt.Sprintf("  ~ %s: %s -> %s", k, diffValue(oldE), diffValue(newE)))
+			}
+		}
+		if len(changes) == 0 {
+			continue
+		}
+		fmt.Fprintf(&out, "* %s:\n%s\n", t, strings.Join(changes, "\n"))
+	}
+
+	return out.String()
+}
+
+// diffValue returns the value of e printed on a single line.
+func diffValue(e entry.Entry) string {
+	if e.Disabled {
+		return i18n.G("(disabled)")
+	}
+	// Trim EOL \n and replace them all with \n in text to keep each value printed in one single line
+	return strings.ReplaceAll(strings.TrimSpace(e.Value), "\n", `\n`)
+}
+
+// changedManagers returns the sorted policy managers with entries added, removed or changed by newRules compared to
+// oldRules.
+func changedManagers(oldRules, newRules map[string][]entry.Entry) []string {
+	entriesByManager := func(rules map[string][]entry.Entry) map[string]map[string]entry.Entry {
+		r := make(map[string]map[string]entry.Entry)
+		for t, entries := range rules {
+			manager := managerForRulesKey(t)
+			if r[manager] == nil {
+				r[manager] = make(map[string]entry.Entry)
+			}
+			for _, e := range entries {
+				r[manager][t+"/"+e.Key] = e
+			}
+		}
+		return r
+	}
+	oldEntries, newEntries := entriesByManager(oldRules), entriesByManager(newRules)
+
+	changed := make(map[string]struct{})
+	for manager, entries := range newEntries {
+		if len(entries) != len(oldEntries[manager]) {
+			changed[manager] = struct{}{}
+			continue
+		}
+		for k, e := range entries {
+			oldE, ok := oldEntries[manager][k]
+			if !ok || oldE.Value != e.Value || oldE.Disabled != e.Disabled || oldE.Meta != e.Meta ||
+				oldE.Strategy != e.Strategy || oldE.Action != e.Action {
+				changed[manager] = struct{}{}
+				break
+			}
+		}
+	}
+	for manager, entries := range oldEntries {
+		if _, ok := newEntries[manager]; !ok && len(entries) > 0 {
+			changed[manager] = struct{}{}
+		}
+	}
+
+	var managers []string
+	for manager := range changed {
+		managers = append(managers, manager)
+	}
+	sort.Strings(managers)
+	return managers
+}
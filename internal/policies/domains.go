@@ -0,0 +1,244 @@
+package policies
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	log "github.com/ubuntu/adsys/internal/grpc/logstreamer"
+	"github.com/ubuntu/adsys/internal/i18n"
+	"github.com/ubuntu/decorate"
+)
+
+// DomainsCacheBaseName is the cache directory where the state of the users of each domain is stored in a directory
+// of its own, so that the domains served by a shared machine are isolated from each other and can be purged
+// independently.
+const DomainsCacheBaseName = "domains"
+
+// domainOf returns the domain of objectName, named user@domain, or an empty string for objects without domain, like
+// the machine. Domains which are not a valid directory name are considered as no domain.
+func domainOf(objectName string) string {
+	i := strings.LastIndex(objectName, "@")
+	if i < 0 {
+		return ""
+	}
+	domain := objectName[i+1:]
+	if domain == "" || domain == "." || domain == ".." || strings.ContainsRune(domain, filepath.Separator) {
+		return ""
+	}
+	return domain
+}
+
+// ObjectCachePath returns the path of the state of kind, like the cached policies or the last apply status, of
+// objectName under cacheDir.
+// The state of users is stored in the directory of their domain, as domains/<domain>/<kind>/<user@domain>, while
+// the state of the machine is stored as <kind>/<machine>.
+func ObjectCachePath(cacheDir, kind, objectName string) string {
+	if domain := domainOf(objectName); domain != "" {
+		return filepath.Join(DomainCachePath(cacheDir, domain), kind, objectName)
+	}
+	return filepath.Join(cacheDir, kind, objectName)
+}
+
+// DomainCachePath returns the directory where the state of the users of domain is stored under cacheDir.
+func DomainCachePath(cacheDir, domain string) string {
+	return filepath.Join(cacheDir, DomainsCacheBaseName, domain)
+}
+
+// CachedObjects returns the sorted objects with a state of kind under cacheDir, across all domains.
+// States being saved are not listed.
+func CachedObjects(cacheDir, kind string) (objects []string, err error) {
+	defer decorate.OnError(&err, i18n.G("can't list objects with a cached %s state"), kind)
+
+	dirs := []string{filepath.Join(cacheDir, kind)}
+	domains, err := os.ReadDir(filepath.Join(cacheDir, DomainsCacheBaseName))
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	for _, d := range domains {
+		if d.IsDir() {
+			dirs = append(dirs, filepath.Join(cacheDir, DomainsCacheBaseName, d.Name(), kind))
+		}
+	}
+
+	for _, dir := range dirs {
+		entries, err := os.ReadDir(dir)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		for _, e := range entries {
+			if strings.HasSuffix(e.Name(), ".new") {
+				continue
+			}
+			objects = append(objects, e.Name())
+		}
+	}
+	sort.Strings(objects)
+	return objects, nil
+}
+
+// MigrateToDomainLayout moves the state of kinds of the users, stored directly in <kind>/<user@domain> by previous
+// versions, to the directory of their domain.
+// A migration interrupted midway is resumed: the state already in the directory of the domain is the most recent one
+// and is kept over the one left behind.
+func MigrateToDomainLayout(ctx context.Context, cacheDir string, kinds ...string) (err error) {
+	defer decorate.OnError(&err, i18n.G("can't move the cache of users to the directory of their domain"))
+
+	for _, kind := range kinds {
+		entries, err := os.ReadDir(filepath.Join(cacheDir, kind))
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		} else if err != nil {
+			return err
+		}
+		for _, e := range entries {
+			objectName := e.Name()
+			if strings.HasSuffix(objectName, ".new") || domainOf(objectName) == "" {
+				continue
+			}
+			dest := ObjectCachePath(cacheDir, kind, objectName)
+			log.Debugf(ctx, "Moving %s state of %s to %s", kind, objectName, dest)
+			if err := os.MkdirAll(filepath.Dir(dest), 0700); err != nil {
+				return err
+			}
+			if err := mergeInto(filepath.Join(cacheDir, kind, objectName), dest); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// mergeInto moves src to dest. The content of directories is merged, and the files already in dest are kept over the
+// ones of src, which are removed.
+func mergeInto(src, dest string) error {
+	destInfo, err := os.Lstat(dest)
+	if errors.Is(err, fs.ErrNotExist) {
+		return os.Rename(src, dest)
+	} else if err != nil {
+		return err
+	}
+
+	srcInfo, err := os.Lstat(src)
+	if err != nil {
+		return err
+	}
+	if srcInfo.IsDir() && destInfo.IsDir() {
+		entries, err := os.ReadDir(src)
+		if err != nil {
+			return err
+		}
+		for _, e := range entries {
+			if err := mergeInto(filepath.Join(src, e.Name()), filepath.Join(dest, e.Name())); err != nil {
+				return err
+			}
+		}
+	}
+	return os.RemoveAll(src)
+}
+
+// objectPath returns the path of the state of kind of objectName in the cache directory.
+func (m *Manager) objectPath(kind, objectName string) string {
+	return ObjectCachePath(m.cacheDir, kind, objectName)
+}
+
+// warnDomainQuota warns if the cache of the domain of objectName uses more than the domain cache quota, so that a
+// single domain filling the cache shared with the other ones is noticed. The policies are still applied: failing
+// would prevent the users of the domain from being purged or reverted too.
+func (m *Manager) warnDomainQuota(ctx context.Context, objectName string) {
+	domain := domainOf(objectName)
+	if m.domainCacheQuota <= 0 || domain == "" {
+		return
+	}
+
+	used, err := m.domainCacheUsage(ctx, domain)
+	if err != nil {
+		log.Warningf(ctx, i18n.G("Can't compute the cache usage of domain %s: %v"), domain, err)
+		return
+	}
+	if used > m.domainCacheQuota {
+		log.Warningf(ctx, i18n.G("Cache of domain %s uses %d bytes, more than its quota of %d bytes: purge some of its users to free space"), domain, used, m.domainCacheQuota)
+	}
+}
+
+// domainCacheUsage returns the size in bytes of the cache of domain: the state of its users, and the GPOs and assets
+// they receive in the sysvol cache. The sysvol cache is shared with the other domains: each GPO is counted once for
+// every domain whose users receive it.
+func (m *Manager) domainCacheUsage(ctx context.Context, domain string) (used int64, err error) {
+	defer decorate.OnError(&err, i18n.G("can't compute the cache usage of domain %s"), domain)
+
+	dirs := []string{DomainCachePath(m.cacheDir, domain)}
+
+	objects, err := CachedObjects(m.cacheDir, PoliciesCacheBaseName)
+	if err != nil {
+		return 0, err
+	}
+	gpos := make(map[string]struct{})
+	var withAssets bool
+	for _, objectName := range objects {
+		if domainOf(objectName) != domain {
+			continue
+		}
+		pols, err := NewFromCache(ctx, m.objectPath(PoliciesCacheBaseName, objectName))
+		if err != nil {
+			log.Debugf(ctx, "Can't list the GPOs of %s to compute the cache usage of its domain: %v", objectName, err)
+			continue
+		}
+		for _, g := range pols.GPOs {
+			gpos[g.ID] = struct{}{}
+		}
+		withAssets = withAssets || pols.assets != nil
+		if err := pols.Close(); err != nil {
+			log.Debugf(ctx, "Can't close policies cache of %s: %v", objectName, err)
+		}
+	}
+	for id := range gpos {
+		dirs = append(dirs, filepath.Join(m.sysvolCacheDir, "Policies", id))
+	}
+	if withAssets {
+		dirs = append(dirs, filepath.Join(m.sysvolCacheDir, "assets"), filepath.Join(m.sysvolCacheDir, "assets.db"))
+	}
+
+	for _, dir := range dirs {
+		err := filepath.WalkDir(dir, func(_ string, d fs.DirEntry, err error) error {
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil
+			} else if err != nil {
+				return err
+			}
+			if !d.Type().IsRegular() {
+				return nil
+			}
+			info, err := d.Info()
+			if err != nil {
+				return err
+			}
+			used += info.Size()
+			return nil
+		})
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	return used, nil
+}
+
+// RemoveDomain removes the cached state of every user of domain, once their policies were purged.
+func (m *Manager) RemoveDomain(ctx context.Context, domain string) (err error) {
+	defer decorate.OnError(&err, i18n.G("can't remove the cache of domain %s"), domain)
+
+	if domainOf("@"+domain) != domain {
+		return fmt.Errorf(i18n.G("invalid domain %q"), domain)
+	}
+
+	log.Infof(ctx, i18n.G("Removing the cache of domain %s"), domain)
+	return os.RemoveAll(DomainCachePath(m.cacheDir, domain))
+}
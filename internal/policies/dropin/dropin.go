@@ -0,0 +1,120 @@
+// Package dropin provides the helpers shared by the policy managers rendering a constrained set of options of a
+// system service, like SSSD or journald, in a drop-in file read after the service configuration.
+//
+// Options are parsed and validated from the entries before anything is written, and the drop-in is only replaced,
+// atomically, when its content changed, so that the service is only restarted when needed.
+package dropin
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	log "github.com/ubuntu/adsys/internal/grpc/logstreamer"
+	"github.com/ubuntu/adsys/internal/i18n"
+	"github.com/ubuntu/adsys/internal/policies/entry"
+)
+
+// Option is a service option set by a policy key.
+type Option struct {
+	Name string
+	// Parse returns the value of the option for a policy value, or an error if the policy value is invalid.
+	Parse func(string) (string, error)
+}
+
+// ParseEntries returns the value of the options set by entries, by option name, for the keys supported by the
+// manager. Unsupported keys are skipped with a warning and disabled entries leave the option to the local
+// configuration.
+func ParseEntries(ctx context.Context, manager string, supportedKeys map[string]Option, entries []entry.Entry) (options map[string]string, err error) {
+	options = make(map[string]string)
+	for _, e := range entries {
+		key := e.Key[strings.LastIndex(e.Key, "/")+1:]
+		opt, ok := supportedKeys[key]
+		if !ok {
+			log.Warningf(ctx, i18n.G("Encountered unsupported key '%s' while parsing %s entries, skipping it"), key, manager)
+			continue
+		}
+		if e.Disabled {
+			continue
+		}
+		v, err := opt.Parse(strings.TrimSpace(e.Value))
+		if err != nil {
+			return nil, fmt.Errorf(i18n.G("invalid value %q for %s: %w"), e.Value, key, err)
+		}
+		options[opt.Name] = v
+	}
+	return options, nil
+}
+
+// Render returns the lines of options, sorted by name, with their name and value separated by sep.
+func Render(options map[string]string, sep string) string {
+	names := make([]string, 0, len(options))
+	for n := range options {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+
+	var out strings.Builder
+	for _, n := range names {
+		fmt.Fprintf(&out, "%s%s%s\n", n, sep, options[n])
+	}
+	return out.String()
+}
+
+// Write replaces the drop-in at path by content, with the given permissions, and returns true if it changed.
+func Write(path string, content []byte, perm fs.FileMode) (changed bool, err error) {
+	if current, err := os.ReadFile(path); err == nil && bytes.Equal(current, content) {
+		return false, nil
+	}
+
+	// #nosec G301 - drop-in directories are standard directories of the services, readable by all.
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return false, err
+	}
+	if err := os.WriteFile(path+".new", content, perm); err != nil {
+		return false, err
+	}
+	if err := os.Rename(path+".new", path); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// Remove removes the drop-in at path, and returns true if it existed.
+func Remove(path string) (changed bool, err error) {
+	if err := os.Remove(path); errors.Is(err, fs.ErrNotExist) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// ParseCount validates a positive number, like a duration in seconds.
+func ParseCount(v string) (string, error) {
+	if _, err := strconv.ParseUint(v, 10, 32); err != nil {
+		return "", errors.New(i18n.G("must be a positive number"))
+	}
+	return v, nil
+}
+
+// ParseBool returns a parser of booleans, rendering them as yes or no like the service expects them.
+func ParseBool(yes, no string) func(string) (string, error) {
+	return func(v string) (string, error) {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return "", errors.New(i18n.G("must be true or false"))
+		}
+		if b {
+			return yes, nil
+		}
+		return no, nil
+	}
+}
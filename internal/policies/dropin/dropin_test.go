@@ -0,0 +1,107 @@
+package dropin_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/ubuntu/adsys/internal/policies/dropin"
+	"github.com/ubuntu/adsys/internal/policies/entry"
+)
+
+func TestParseEntries(t *testing.T) {
+	t.Parallel()
+
+	supportedKeys := map[string]dropin.Option{
+		"count":   {Name: "Count", Parse: dropin.ParseCount},
+		"enabled": {Name: "Enabled", Parse: dropin.ParseBool("yes", "no")},
+	}
+
+	tests := map[string]struct {
+		entries []entry.Entry
+
+		want    map[string]string
+		wantErr bool
+	}{
+		"Options are set by their key": {
+			entries: []entry.Entry{{Key: "service/count", Value: " 42 "}, {Key: "service/enabled", Value: "true"}},
+			want:    map[string]string{"Count": "42", "Enabled": "yes"},
+		},
+		"Disabled entries are left to the local configuration": {
+			entries: []entry.Entry{{Key: "service/count", Value: "42"}, {Key: "service/enabled", Disabled: true}},
+			want:    map[string]string{"Count": "42"},
+		},
+		"Unsupported keys are ignored": {
+			entries: []entry.Entry{{Key: "service/count", Value: "42"}, {Key: "service/unsupported", Value: "1"}},
+			want:    map[string]string{"Count": "42"},
+		},
+
+		// Error cases
+		"Error on negative count":  {entries: []entry.Entry{{Key: "service/count", Value: "-1"}}, wantErr: true},
+		"Error on invalid boolean": {entries: []entry.Entry{{Key: "service/enabled", Value: "maybe"}}, wantErr: true},
+	}
+	for name, tc := range tests {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			got, err := dropin.ParseEntries(context.Background(), "service", supportedKeys, tc.entries)
+			if tc.wantErr {
+				require.Error(t, err, "ParseEntries should have failed but didn't")
+				return
+			}
+			require.NoError(t, err, "ParseEntries failed but shouldn't have")
+			require.Equal(t, tc.want, got, "ParseEntries should return the options set by the entries")
+		})
+	}
+}
+
+func TestRender(t *testing.T) {
+	t.Parallel()
+
+	got := dropin.Render(map[string]string{"b": "2", "a": "1"}, " = ")
+	require.Equal(t, "a = 1\nb = 2\n", got, "Render should sort the options by name")
+}
+
+func TestWriteAndRemove(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "conf.d", "90-adsys.conf")
+
+	changed, err := dropin.Write(path, []byte("a=1\n"), 0600)
+	require.NoError(t, err, "Write should create the drop-in and its directory")
+	require.True(t, changed, "Write should report a new drop-in as changed")
+
+	changed, err = dropin.Write(path, []byte("a=1\n"), 0600)
+	require.NoError(t, err, "Write should not fail on an up to date drop-in")
+	require.False(t, changed, "Write should not report an up to date drop-in as changed")
+
+	changed, err = dropin.Write(path, []byte("a=2\n"), 0600)
+	require.NoError(t, err, "Write should replace an outdated drop-in")
+	require.True(t, changed, "Write should report an outdated drop-in as changed")
+	got, err := os.ReadFile(path)
+	require.NoError(t, err, "Drop-in should exist")
+	require.Equal(t, "a=2\n", string(got), "Write should replace the drop-in content")
+	require.NoFileExists(t, path+".new", "Write should not leave its temporary file")
+
+	changed, err = dropin.Remove(path)
+	require.NoError(t, err, "Remove should remove the drop-in")
+	require.True(t, changed, "Remove should report a removed drop-in as changed")
+	require.NoFileExists(t, path, "Remove should have removed the drop-in")
+
+	changed, err = dropin.Remove(path)
+	require.NoError(t, err, "Remove should not fail on a missing drop-in")
+	require.False(t, changed, "Remove should not report a missing drop-in as changed")
+}
+
+func TestWriteErrorsOnDirectoryBeingAFile(t *testing.T) {
+	t.Parallel()
+
+	dir := filepath.Join(t.TempDir(), "conf.d")
+	require.NoError(t, os.WriteFile(dir, nil, 0600), "Setup: can't create conf.d file")
+
+	_, err := dropin.Write(filepath.Join(dir, "90-adsys.conf"), []byte("a=1\n"), 0600)
+	require.Error(t, err, "Write should fail when the drop-in directory is a file")
+}
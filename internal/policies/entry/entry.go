@@ -6,16 +6,29 @@ package entry
 type Entry struct {
 	// Key is the relative path to setting. Ex: Software/Ubuntu/User/dconf/wallpaper/path outside of GPO, and then
 	// wallpaper/path in "dconf" rule category.
-	Key      string
-	Value    string
-	Disabled bool
-	Meta     string `yaml:",omitempty"`
+	Key      string `json:"key"`
+	Value    string `json:"value"`
+	Disabled bool   `json:"disabled"`
+	Meta     string `yaml:",omitempty" json:"meta,omitempty"`
 	// Strategy are overlay rules for the same keys between multiple GPOs.
 	// Default (empty or unknown value) means "override".
-	Strategy string `yaml:",omitempty"`
+	Strategy string `yaml:",omitempty" json:"strategy,omitempty"`
+	// Action is the Group Policy Preferences action for preference entries (create, replace, update or delete).
+	// It is empty for policy entries, which are always enforced.
+	Action string `yaml:",omitempty" json:"action,omitempty"`
+	// ApplyOnce is set on preference entries which should not be reapplied once they were applied.
+	ApplyOnce bool `yaml:",omitempty" json:"applyonce,omitempty"`
+	// Subnets is the condition on the machine networks for the entry to apply. See ParseSubnets for its format.
+	// It is empty for entries applying on all networks.
+	Subnets string `yaml:",omitempty" json:"subnets,omitempty"`
+	// Expires is the time after which the entry stops applying. See ParseExpiry for its format.
+	// It is empty for entries which never expire.
+	Expires string `yaml:",omitempty" json:"expires,omitempty"`
+	// ReportOnly is set on entries which are only reported, and compared to the enforced ones, but never applied.
+	ReportOnly bool `yaml:",omitempty" json:"reportonly,omitempty"`
 	// Err is set if there was an error parsing the entry. It is ignored if the
 	// underlying key is not supported by adsys.
-	Err error `yaml:"-"`
+	Err error `yaml:"-" json:"-"`
 }
 
 const (
@@ -27,3 +40,14 @@ const (
 	StrategyAppend = "append"
 	// This can be extended to support prepend but it is implemented yet as there is no real world cases.
 )
+
+const (
+	// ActionCreate sets the preference only if it does not exist yet.
+	ActionCreate = "create"
+	// ActionReplace sets the preference, replacing any existing value.
+	ActionReplace = "replace"
+	// ActionUpdate sets the preference, keeping the existing value if the new one is empty.
+	ActionUpdate = "update"
+	// ActionDelete removes the preference.
+	ActionDelete = "delete"
+)
@@ -0,0 +1,43 @@
+package entry
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/ubuntu/adsys/internal/i18n"
+	"github.com/ubuntu/decorate"
+)
+
+// localExpiryLayouts are the formats of expires conditions in the local time of the machine.
+var localExpiryLayouts = []string{"2006-01-02 15:04:05", "2006-01-02 15:04", "2006-01-02T15:04:05", "2006-01-02T15:04"}
+
+// ParseExpiry returns the time of an expires condition.
+// It is in RFC 3339 format, like 2024-06-20T12:00:00+02:00, or in the local time of the machine, like
+// 2024-06-20 12:00.
+func ParseExpiry(condition string) (t time.Time, err error) {
+	defer decorate.OnError(&err, i18n.G("invalid expires condition %q"), condition)
+
+	condition = strings.TrimSpace(condition)
+	if t, err := time.Parse(time.RFC3339, condition); err == nil {
+		return t, nil
+	}
+	for _, layout := range localExpiryLayouts {
+		if t, err := time.ParseInLocation(layout, condition, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, errors.New(i18n.G("expected a date and time like 2024-06-20 12:00 or 2024-06-20T12:00:00+02:00"))
+}
+
+// Expired returns if the entry stopped applying at now. An entry without expires condition never expires.
+func (e Entry) Expired(now time.Time) (bool, error) {
+	if e.Expires == "" {
+		return false, nil
+	}
+	t, err := ParseExpiry(e.Expires)
+	if err != nil {
+		return false, err
+	}
+	return !now.Before(t), nil
+}
@@ -0,0 +1,50 @@
+package entry_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/ubuntu/adsys/internal/policies/entry"
+)
+
+func TestExpired(t *testing.T) {
+	t.Parallel()
+
+	now := time.Date(2024, 6, 20, 10, 0, 0, 0, time.UTC)
+
+	tests := map[string]struct {
+		expires string
+
+		want    bool
+		wantErr bool
+	}{
+		"No condition never expires": {want: false},
+
+		"Expired":                        {expires: "2024-06-20T09:59:59Z", want: true},
+		"Expires exactly now":            {expires: "2024-06-20T10:00:00Z", want: true},
+		"Not expired yet":                {expires: "2024-06-20T10:00:01Z", want: false},
+		"Time zone offset":               {expires: "2024-06-20T11:30:00+02:00", want: true},
+		"Surrounding spaces are ignored": {expires: " 2024-06-20T09:00:00Z\n", want: true},
+		"Local time, long ago":           {expires: "2000-01-01 12:00", want: true},
+		"Local time with seconds":        {expires: "2000-01-01 12:00:30", want: true},
+		"Local time far ahead":           {expires: "2100-01-01T12:00", want: false},
+
+		"Error on date only":     {expires: "2024-06-20", wantErr: true},
+		"Error on invalid value": {expires: "tomorrow", wantErr: true},
+	}
+	for name, tc := range tests {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			got, err := entry.Entry{Key: "key", Expires: tc.expires}.Expired(now)
+			if tc.wantErr {
+				require.Error(t, err, "Expired should return an error but didn't")
+				return
+			}
+			require.NoError(t, err, "Expired should not return an error")
+			require.Equal(t, tc.want, got, "Expired returned an unexpected result")
+		})
+	}
+}
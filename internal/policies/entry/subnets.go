@@ -0,0 +1,75 @@
+package entry
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/ubuntu/adsys/internal/i18n"
+	"github.com/ubuntu/decorate"
+)
+
+// ParseSubnets returns the subnets included and excluded by a subnets condition.
+// Subnets are separated by commas or new lines, in CIDR notation like 10.1.0.0/16 or 2001:db8::/32. A single address
+// is a subnet of its own. A subnet prefixed with "!" is excluded.
+func ParseSubnets(condition string) (include, exclude []*net.IPNet, err error) {
+	defer decorate.OnError(&err, i18n.G("invalid subnets condition %q"), condition)
+
+	for _, s := range strings.FieldsFunc(condition, func(r rune) bool { return r == ',' || r == '\n' }) {
+		s = strings.TrimSpace(s)
+		excluded := strings.HasPrefix(s, "!")
+		s = strings.TrimSpace(strings.TrimPrefix(s, "!"))
+		if s == "" {
+			continue
+		}
+
+		if !strings.Contains(s, "/") {
+			ip := net.ParseIP(s)
+			if ip == nil {
+				return nil, nil, fmt.Errorf(i18n.G("%s is not an IP address nor a subnet"), s)
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				bits = 8 * net.IPv4len
+			}
+			s = fmt.Sprintf("%s/%d", s, bits)
+		}
+		_, subnet, err := net.ParseCIDR(s)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		if excluded {
+			exclude = append(exclude, subnet)
+			continue
+		}
+		include = append(include, subnet)
+	}
+
+	return include, exclude, nil
+}
+
+// InSubnets returns if the entry applies on a machine with the addresses ips: one of them must be in one of the
+// included subnets, if any, and none of them in the excluded ones. An entry without subnets condition always applies.
+func (e Entry) InSubnets(ips []net.IP) (bool, error) {
+	include, exclude, err := ParseSubnets(e.Subnets)
+	if err != nil {
+		return false, err
+	}
+
+	var included bool
+	for _, ip := range ips {
+		for _, subnet := range exclude {
+			if subnet.Contains(ip) {
+				return false, nil
+			}
+		}
+		for _, subnet := range include {
+			if subnet.Contains(ip) {
+				included = true
+			}
+		}
+	}
+
+	return included || len(include) == 0, nil
+}
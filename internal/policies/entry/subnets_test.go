@@ -0,0 +1,61 @@
+package entry_test
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/ubuntu/adsys/internal/policies/entry"
+)
+
+func TestInSubnets(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		subnets string
+		ips     []string
+
+		want    bool
+		wantErr bool
+	}{
+		"No condition applies everywhere":      {ips: []string{"10.1.2.3"}, want: true},
+		"No condition applies without network": {want: true},
+
+		"In subnet":                        {subnets: "10.1.0.0/16", ips: []string{"10.1.2.3"}, want: true},
+		"Not in subnet":                    {subnets: "10.1.0.0/16", ips: []string{"10.2.2.3"}, want: false},
+		"In one of the subnets":            {subnets: "192.168.1.0/24, 10.1.0.0/16", ips: []string{"10.1.2.3"}, want: true},
+		"Subnets separated by new lines":   {subnets: "192.168.1.0/24\n10.1.0.0/16", ips: []string{"10.1.2.3"}, want: true},
+		"One of the addresses in subnet":   {subnets: "10.1.0.0/16", ips: []string{"192.168.1.2", "10.1.2.3"}, want: true},
+		"Single address":                   {subnets: "10.1.2.3", ips: []string{"10.1.2.3"}, want: true},
+		"Other single address":             {subnets: "10.1.2.3", ips: []string{"10.1.2.4"}, want: false},
+		"IPv6 subnet":                      {subnets: "2001:db8::/32", ips: []string{"2001:db8::1"}, want: true},
+		"No address is not in subnet":      {subnets: "10.1.0.0/16", want: false},
+		"Empty subnets are ignored":        {subnets: "10.1.0.0/16,,", ips: []string{"10.1.2.3"}, want: true},
+		"Excluded subnet":                  {subnets: "!10.1.0.0/16", ips: []string{"10.1.2.3"}, want: false},
+		"Outside of excluded subnet":       {subnets: "!10.1.0.0/16", ips: []string{"10.2.2.3"}, want: true},
+		"Exclusion takes precedence":       {subnets: "10.0.0.0/8, !10.1.0.0/16", ips: []string{"10.1.2.3"}, want: false},
+		"Included outside of the excluded": {subnets: "10.0.0.0/8, !10.1.0.0/16", ips: []string{"10.2.2.3"}, want: true},
+
+		"Error on invalid subnet":  {subnets: "10.1.0.0/33", ips: []string{"10.1.2.3"}, wantErr: true},
+		"Error on invalid address": {subnets: "office", ips: []string{"10.1.2.3"}, wantErr: true},
+	}
+	for name, tc := range tests {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			var ips []net.IP
+			for _, ip := range tc.ips {
+				ips = append(ips, net.ParseIP(ip))
+			}
+
+			got, err := entry.Entry{Key: "key", Subnets: tc.subnets}.InSubnets(ips)
+			if tc.wantErr {
+				require.Error(t, err, "InSubnets should return an error but didn't")
+				return
+			}
+			require.NoError(t, err, "InSubnets should not return an error")
+			require.Equal(t, tc.want, got, "InSubnets returned an unexpected result")
+		})
+	}
+}
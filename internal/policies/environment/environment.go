@@ -0,0 +1,146 @@
+// Package environment is the policy manager for user session environment variables.
+//
+// The manager generates a pam_env compatible file in the user run directory, listing the variables
+// requested by the user policy. This file is read by pam_adsys at logon, once the policy is applied:
+// variables are then injected in any PAM session, including non-graphical ones like SSH or console logins.
+//
+// Variable names are validated and an error is returned if any of them is invalid, preventing
+// authentication. Values are not interpreted: it's up to the admin to ensure they are correct
+// for the programs consuming them.
+package environment
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/user"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	log "github.com/ubuntu/adsys/internal/grpc/logstreamer"
+	"github.com/ubuntu/adsys/internal/i18n"
+	"github.com/ubuntu/adsys/internal/policies/entry"
+	"github.com/ubuntu/decorate"
+)
+
+// EnvFileName is the name of the environment file generated in the user run directory.
+const EnvFileName = "environment"
+
+var validName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// Manager prevents running multiple environment update process in parallel while parsing policy in ApplyPolicy.
+type Manager struct {
+	runDir string
+
+	userLookup func(string) (*user.User, error)
+}
+
+type options struct {
+	userLookup func(string) (*user.User, error)
+}
+
+// Option reprents an optional function to change the environment manager.
+type Option func(*options)
+
+// New creates a manager generating environment files in the users subdirectory of runDir.
+func New(runDir string, opts ...Option) *Manager {
+	// defaults
+	args := options{
+		userLookup: user.Lookup,
+	}
+	// applied options
+	for _, o := range opts {
+		o(&args)
+	}
+
+	return &Manager{
+		runDir:     runDir,
+		userLookup: args.userLookup,
+	}
+}
+
+// ApplyPolicy generates the environment file of a user based on a list of entries.
+func (m *Manager) ApplyPolicy(ctx context.Context, objectName string, isComputer bool, entries []entry.Entry) (err error) {
+	defer decorate.OnError(&err, i18n.G("can't apply environment policy to %s"), objectName)
+
+	// Only user sessions are handled.
+	if isComputer {
+		return nil
+	}
+
+	log.Debugf(ctx, "Applying environment policy to %s", objectName)
+
+	vars, err := parseEntries(entries)
+	if err != nil {
+		return err
+	}
+
+	u, err := m.userLookup(objectName)
+	if err != nil {
+		// There is no environment file to clean up for an unknown user.
+		if len(vars) == 0 {
+			return nil
+		}
+		return fmt.Errorf(i18n.G("could not retrieve user for %q: %w"), objectName, err)
+	}
+	envFile := filepath.Join(m.runDir, "users", u.Uid, EnvFileName)
+
+	if len(vars) == 0 {
+		if err := os.Remove(envFile); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+		return nil
+	}
+
+	names := make([]string, 0, len(vars))
+	for n := range vars {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+
+	var content strings.Builder
+	for _, n := range names {
+		// pam_env strips the surrounding quotes of the value.
+		fmt.Fprintf(&content, "%s=\"%s\"\n", n, vars[n])
+	}
+
+	// #nosec G301 - users/ subdirectories are shared with the scripts manager and need to be traversable.
+	if err := os.MkdirAll(filepath.Dir(envFile), 0755); err != nil {
+		return err
+	}
+	// The file is only read by pam_adsys, running as root.
+	if err := os.WriteFile(envFile+".new", []byte(content.String()), 0600); err != nil {
+		return err
+	}
+	return os.Rename(envFile+".new", envFile)
+}
+
+// parseEntries returns the variables requested by entries. Later definitions of a variable win.
+func parseEntries(entries []entry.Entry) (vars map[string]string, err error) {
+	vars = make(map[string]string)
+	for _, e := range entries {
+		if e.Key != "user-environment" || e.Disabled {
+			continue
+		}
+		for _, l := range strings.Split(e.Value, "\n") {
+			l = strings.TrimSpace(l)
+			if l == "" || strings.HasPrefix(l, "#") {
+				continue
+			}
+			n, v, found := strings.Cut(l, "=")
+			n = strings.TrimSpace(strings.TrimPrefix(n, "export "))
+			if !found || !validName.MatchString(n) {
+				return nil, fmt.Errorf(i18n.G("%q is not a valid NAME=value environment variable"), l)
+			}
+			if strings.ContainsAny(v, "\"\x00") {
+				return nil, fmt.Errorf(i18n.G("value of environment variable %q can't contain double quotes"), n)
+			}
+			vars[n] = strings.TrimSpace(v)
+		}
+	}
+	return vars, nil
+}
@@ -0,0 +1,97 @@
+package environment_test
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"os"
+	"os/user"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/termie/go-shutil"
+	"github.com/ubuntu/adsys/internal/policies/entry"
+	"github.com/ubuntu/adsys/internal/policies/environment"
+	"github.com/ubuntu/adsys/internal/testutils"
+)
+
+func TestApplyPolicy(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		entries         []entry.Entry
+		computer        bool
+		userLookupError bool
+		existingRunDir  string
+		makeReadOnly    bool
+
+		wantErr bool
+	}{
+		"One variable":                           {entries: []entry.Entry{{Key: "user-environment", Value: "EDITOR=vim"}}},
+		"Multiple variables are sorted":          {entries: []entry.Entry{{Key: "user-environment", Value: "PAGER=less\nEDITOR=vim\n\nBROWSER=firefox"}}},
+		"Values are kept as is":                  {entries: []entry.Entry{{Key: "user-environment", Value: "PATH=$PATH:/opt/bin\nGREETING=hello world\nEMPTY=\nEQUAL=a=b"}}},
+		"Export prefix and comments are ignored": {entries: []entry.Entry{{Key: "user-environment", Value: "# comment\nexport EDITOR=vim\n  PAGER = less  "}}},
+		"Last definition wins":                   {entries: []entry.Entry{{Key: "user-environment", Value: "EDITOR=nano\nEDITOR=vim"}}},
+		"Disabled entries are ignored":           {entries: []entry.Entry{{Key: "user-environment", Value: "EDITOR=vim", Disabled: true}}},
+		"Unknown keys are ignored":               {entries: []entry.Entry{{Key: "unknown", Value: "EDITOR=vim"}}},
+		"Overwrite existing file":                {entries: []entry.Entry{{Key: "user-environment", Value: "EDITOR=vim"}}, existingRunDir: "existing-env"},
+		"No entries removes existing file":       {existingRunDir: "existing-env"},
+		"No entries and no file is a noop":       {},
+		"Computer does nothing":                  {entries: []entry.Entry{{Key: "user-environment", Value: "EDITOR=vim"}}, computer: true},
+		"User lookup failing with no entries":    {userLookupError: true, existingRunDir: "existing-env"},
+
+		// Error cases
+		"Error on user lookup failing":     {entries: []entry.Entry{{Key: "user-environment", Value: "EDITOR=vim"}}, userLookupError: true, wantErr: true},
+		"Error on missing equal sign":      {entries: []entry.Entry{{Key: "user-environment", Value: "EDITOR"}}, wantErr: true},
+		"Error on invalid variable name":   {entries: []entry.Entry{{Key: "user-environment", Value: "1EDITOR=vim"}}, wantErr: true},
+		"Error on value with double quote": {entries: []entry.Entry{{Key: "user-environment", Value: `EDITOR="vim"`}}, wantErr: true},
+		"Error on read only run directory": {entries: []entry.Entry{{Key: "user-environment", Value: "EDITOR=vim"}}, makeReadOnly: true, wantErr: true},
+	}
+	for name, tc := range tests {
+		tc := tc
+		name := name
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			runDir := t.TempDir()
+			if tc.existingRunDir != "" {
+				require.NoError(t, os.RemoveAll(runDir), "Setup: can't remove run dir before filing it")
+				require.NoError(t,
+					shutil.CopyTree(
+						filepath.Join("testdata", tc.existingRunDir), runDir,
+						&shutil.CopyTreeOptions{Symlinks: true, CopyFunction: shutil.Copy}),
+					"Setup: can't create initial run dir content")
+			}
+			if tc.makeReadOnly {
+				testutils.MakeReadOnly(t, runDir)
+			}
+
+			userLookup := func(string) (*user.User, error) {
+				return &user.User{Uid: "1000", Gid: "1000"}, nil
+			}
+			if tc.userLookupError {
+				userLookup = func(string) (*user.User, error) {
+					return nil, errors.New("User error requested")
+				}
+			}
+
+			m := environment.New(runDir, environment.WithUserLookup(userLookup))
+			err := m.ApplyPolicy(context.Background(), "ubuntu", tc.computer, tc.entries)
+			if tc.wantErr {
+				require.Error(t, err, "ApplyPolicy should have failed but didn't")
+				return
+			}
+			require.NoError(t, err, "ApplyPolicy failed but shouldn't have")
+
+			testutils.CompareTreesWithFiltering(t, runDir, testutils.GoldenPath(t), testutils.Update())
+		})
+	}
+}
+
+func TestMain(m *testing.M) {
+	testutils.InstallUpdateFlag()
+	flag.Parse()
+
+	m.Run()
+}
@@ -0,0 +1,12 @@
+package environment
+
+import (
+	"os/user"
+)
+
+// WithUserLookup allows to mock system user lookup.
+func WithUserLookup(userLookup func(string) (*user.User, error)) Option {
+	return func(o *options) {
+		o.userLookup = userLookup
+	}
+}
@@ -0,0 +1,260 @@
+package policies
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	log "github.com/ubuntu/adsys/internal/grpc/logstreamer"
+	"github.com/ubuntu/adsys/internal/i18n"
+	"github.com/ubuntu/adsys/internal/policies/entry"
+	"github.com/ubuntu/decorate"
+	"golang.org/x/exp/slices"
+)
+
+const (
+	// expiryCacheBaseName is the cache directory where the next expiry of the entries applied to each object is
+	// stored.
+	expiryCacheBaseName = "expiry"
+
+	// expiryTimerUnit is the timer unit, written in the system unit directory, reverting the expired entries at the
+	// next expiry of all objects. It starts the adsys-policy-expiry.service shipped with adsys.
+	expiryTimerUnit = "adsys-policy-expiry.timer"
+)
+
+// unexpired returns a copy of pols without the entries which expired at now. pols is not modified, so that the cache
+// keeps the expired entries and their expiry is evaluated again on each apply.
+func (pols Policies) unexpired(ctx context.Context, now time.Time) Policies {
+	filtered := pols
+	filtered.GPOs = make([]GPO, 0, len(pols.GPOs))
+	for _, g := range pols.GPOs {
+		rules := make(map[string][]entry.Entry, len(g.Rules))
+		for t, entries := range g.Rules {
+			kept := make([]entry.Entry, 0, len(entries))
+			for _, e := range entries {
+				if e.Expires == "" {
+					kept = append(kept, e)
+					continue
+				}
+				expired, err := e.Expired(now)
+				if err != nil {
+					log.Warningf(ctx, i18n.G("Skipping %s entry %s of GPO %q: %v"), t, e.Key, g.Name, err)
+					continue
+				}
+				if expired {
+					log.Debugf(ctx, "Skipping %s entry %s of GPO %q: it expired on %s", t, e.Key, g.Name, e.Expires)
+					continue
+				}
+				kept = append(kept, e)
+			}
+			rules[t] = kept
+		}
+		g.Rules = rules
+		filtered.GPOs = append(filtered.GPOs, g)
+	}
+	return filtered
+}
+
+// nextExpiry returns the earliest expiry after now of the entries of pols, or the zero time if none expires later.
+func (pols Policies) nextExpiry(now time.Time) (next time.Time) {
+	for _, g := range pols.GPOs {
+		for _, entries := range g.Rules {
+			for _, e := range entries {
+				if e.Expires == "" {
+					continue
+				}
+				t, err := entry.ParseExpiry(e.Expires)
+				if err != nil || !t.After(now) {
+					continue
+				}
+				if next.IsZero() || t.Before(next) {
+					next = t
+				}
+			}
+		}
+	}
+	return next
+}
+
+// saveNextExpiry records next as the next expiry of the entries applied to objectName, or removes the record if next
+// is the zero time.
+func (m *Manager) saveNextExpiry(objectName string, next time.Time) error {
+	p := m.objectPath(expiryCacheBaseName, objectName)
+	if next.IsZero() {
+		if err := os.Remove(p); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(p), 0700); err != nil {
+		return err
+	}
+	if err := os.WriteFile(p+".new", []byte(next.UTC().Format(time.RFC3339)), 0600); err != nil {
+		return err
+	}
+	return os.Rename(p+".new", p)
+}
+
+// loadExpiry returns the next expiry recorded for objectName.
+func (m *Manager) loadExpiry(objectName string) (time.Time, error) {
+	d, err := os.ReadFile(m.objectPath(expiryCacheBaseName, objectName))
+	if err != nil {
+		return time.Time{}, err
+	}
+	t, err := time.Parse(time.RFC3339, strings.TrimSpace(string(d)))
+	if err != nil {
+		return time.Time{}, fmt.Errorf(i18n.G("invalid expiry of %s: %w"), objectName, err)
+	}
+	return t, nil
+}
+
+// scheduleExpiryTimer starts the expiry timer unit at the earliest expiry after now of all objects, so that their
+// expired entries are reverted without waiting for the next refresh. The timer is enabled, so that expiries passed
+// while the machine was off are reverted on boot. It is removed once no entry expires anymore.
+func (m *Manager) scheduleExpiryTimer(ctx context.Context) (err error) {
+	defer decorate.OnError(&err, i18n.G("can't schedule the expiry of policy entries"))
+
+	m.expiryMu.Lock()
+	defer m.expiryMu.Unlock()
+
+	objects, err := CachedObjects(m.cacheDir, expiryCacheBaseName)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	var next time.Time
+	for _, objectName := range objects {
+		t, err := m.loadExpiry(objectName)
+		if err != nil {
+			log.Warningf(ctx, i18n.G("Can't load the next expiry of %s: %v"), objectName, err)
+			continue
+		}
+		if !t.After(now) {
+			// Expiries the timer missed are reverted by the next apply of the object.
+			continue
+		}
+		if next.IsZero() || t.Before(next) {
+			next = t
+		}
+	}
+
+	p := filepath.Join(m.systemUnitDir, expiryTimerUnit)
+	previous, err := os.ReadFile(p)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	exists := err == nil
+
+	if next.IsZero() {
+		if !exists {
+			return nil
+		}
+		log.Debug(ctx, "No policy entry expires anymore, removing expiry timer")
+		if err := m.systemdCaller.StopUnit(ctx, expiryTimerUnit); err != nil {
+			log.Warningf(ctx, i18n.G("Can't stop unit %q: %v"), expiryTimerUnit, err)
+		}
+		if err := m.systemdCaller.DisableUnit(ctx, expiryTimerUnit); err != nil {
+			return err
+		}
+		if err := os.Remove(p); err != nil {
+			return err
+		}
+		return m.systemdCaller.DaemonReload(ctx)
+	}
+
+	content := fmt.Sprintf(`[Unit]
+Description=Revert ADSys policy entries at their expiry
+
+[Timer]
+OnCalendar=%s
+AccuracySec=1s
+Persistent=true
+
+[Install]
+WantedBy=timers.target
+`, next.UTC().Format("2006-01-02 15:04:05 UTC"))
+	if exists && string(previous) == content {
+		return nil
+	}
+
+	log.Infof(ctx, "Next policy entry expires on %s", next.Format(time.RFC3339))
+	if err := os.MkdirAll(m.systemUnitDir, 0755); err != nil {
+		return err
+	}
+	// #nosec G306 - unit files are read by systemd and are not sensitive.
+	if err := os.WriteFile(p+".new", []byte(content), 0644); err != nil {
+		return err
+	}
+	if err := os.Rename(p+".new", p); err != nil {
+		return err
+	}
+	if err := m.systemdCaller.DaemonReload(ctx); err != nil {
+		return err
+	}
+	if err := m.systemdCaller.EnableUnit(ctx, expiryTimerUnit); err != nil {
+		return err
+	}
+	return m.systemdCaller.RestartUnit(ctx, expiryTimerUnit)
+}
+
+// ReapplyExpired applies again, from the cached policies, the policies of the objects whose entries expired since
+// their last apply, so that the expired entries are reverted. It is started by the expiry timer unit.
+// Only the machine and the users in activeUsers are applied again: the expired entries of the other users are
+// reverted by the apply at their next login.
+// It returns the objects whose policies were applied again.
+func (m *Manager) ReapplyExpired(ctx context.Context, activeUsers []string) (msg string, err error) {
+	defer decorate.OnError(&err, i18n.G("failed to revert expired policy entries"))
+
+	log.Info(ctx, "Checking policy entries for expiry")
+
+	objects, err := CachedObjects(m.cacheDir, expiryCacheBaseName)
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	var out strings.Builder
+	var errs []error
+	for _, objectName := range objects {
+		t, err := m.loadExpiry(objectName)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if now.Before(t) {
+			continue
+		}
+		if objectName != m.hostname && !slices.Contains(activeUsers, objectName) {
+			log.Debugf(ctx, "Not reverting policy entries of %s expired on %s: user is not logged in", objectName, t.Format(time.RFC3339))
+			continue
+		}
+
+		pols, err := NewFromCache(ctx, m.objectPath(PoliciesCacheBaseName, objectName))
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		log.Infof(ctx, i18n.G("Reverting policy entries of %s expired on %s"), objectName, t.Format(time.RFC3339))
+		if err := m.ApplyPolicies(ctx, objectName, objectName == m.hostname, &pols); err != nil {
+			errs = append(errs, err)
+		} else {
+			fmt.Fprintf(&out, i18n.G("Reverted expired policy entries for %s\n"), objectName)
+		}
+		if err := pols.Close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if err := errors.Join(errs...); err != nil {
+		return "", err
+	}
+
+	if out.Len() == 0 {
+		return i18n.G("No policy entry expired since the last apply.\n"), nil
+	}
+	return out.String(), nil
+}
@@ -1,12 +1,23 @@
 package policies
 
 import (
+	"context"
+	"net"
+
+	"github.com/ubuntu/adsys/internal/policies/entry"
 	"github.com/ubuntu/adsys/internal/policies/gdm"
 )
 
 const (
-	PoliciesAssetsFileName = policiesAssetsFileName
-	PoliciesFileName       = policiesFileName
+	PoliciesAssetsFileName     = policiesAssetsFileName
+	PoliciesFileName           = policiesFileName
+	InflightCacheBaseName      = inflightCacheBaseName
+	StatusCacheBaseName        = statusCacheBaseName
+	ReportOnlyCacheBaseName    = reportOnlyCacheBaseName
+	OwnedCacheBaseName         = ownedCacheBaseName
+	LastKnownGoodCacheBaseName = lastKnownGoodCacheBaseName
+	ExpiryCacheBaseName        = expiryCacheBaseName
+	PendingLogoutCacheBaseName = pendingLogoutCacheBaseName
 )
 
 // WithGDM specifies a personalized gdm manager.
@@ -17,6 +28,45 @@ func WithGDM(m *gdm.Manager) Option {
 	}
 }
 
+// WithMinFreeDiskSpace specifies the disk space which must be left available after applying policies.
+func WithMinFreeDiskSpace(size uint64) Option {
+	return func(o *options) error {
+		o.minFreeDiskSpace = size
+		return nil
+	}
+}
+
+func (m *Manager) DomainCacheUsage(ctx context.Context, domain string) (int64, error) {
+	return m.domainCacheUsage(ctx, domain)
+}
+
+func (m *Manager) WriteMetrics(ctx context.Context) {
+	m.writeMetrics(ctx)
+}
+
 func (pols Policies) HasAssets() bool {
 	return pols.assets != nil
 }
+
+func (pols Policies) GetUniqueRulesWithPrecedence(precedence map[string]string) map[string][]entry.Entry {
+	return pols.getUniqueRules(precedence)
+}
+
+// WithInterfaceAddrs specifies the addresses of the machine used to evaluate the subnets conditions.
+func WithInterfaceAddrs(addrs ...string) Option {
+	return func(o *options) error {
+		o.interfaceAddrs = func() ([]net.Addr, error) {
+			var r []net.Addr
+			for _, a := range addrs {
+				ip, n, err := net.ParseCIDR(a)
+				if err != nil {
+					return nil, err
+				}
+				n.IP = ip
+				r = append(r, n)
+			}
+			return r, nil
+		}
+		return nil
+	}
+}
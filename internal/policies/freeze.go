@@ -0,0 +1,87 @@
+package policies
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+
+	log "github.com/ubuntu/adsys/internal/grpc/logstreamer"
+	"github.com/ubuntu/adsys/internal/i18n"
+	"github.com/ubuntu/decorate"
+)
+
+// freezeBaseName is the file, in the cache directory, persisting the policy freeze.
+const freezeBaseName = "policies.frozen"
+
+// freezeState is the persisted policy freeze. A zero Until freezes policy updates until they are explicitly resumed.
+type freezeState struct {
+	Until time.Time `json:"until,omitempty"`
+}
+
+// Freeze suspends policy updates until the given time, or until Unfreeze is called if until is zero.
+// While frozen, ApplyPolicies leaves the last applied policies in place, except when purging them.
+func (m *Manager) Freeze(until time.Time) (err error) {
+	defer decorate.OnError(&err, i18n.G("can't freeze policy updates"))
+
+	d, err := json.Marshal(freezeState{Until: until})
+	if err != nil {
+		return err
+	}
+	p := filepath.Join(m.cacheDir, freezeBaseName)
+	if err := os.WriteFile(p+".new", d, 0600); err != nil {
+		return err
+	}
+	return os.Rename(p+".new", p)
+}
+
+// Unfreeze resumes policy updates.
+func (m *Manager) Unfreeze() (err error) {
+	defer decorate.OnError(&err, i18n.G("can't resume policy updates"))
+
+	if err := os.Remove(filepath.Join(m.cacheDir, freezeBaseName)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// FrozenUntil returns if policy updates are currently frozen and until when.
+// An expired freeze is removed.
+func (m *Manager) FrozenUntil(ctx context.Context) (until time.Time, frozen bool) {
+	p := filepath.Join(m.cacheDir, freezeBaseName)
+	d, err := os.ReadFile(p)
+	if errors.Is(err, fs.ErrNotExist) {
+		return time.Time{}, false
+	} else if err != nil {
+		log.Warningf(ctx, i18n.G("Can't read policy freeze state, considering policy updates as not frozen: %v"), err)
+		return time.Time{}, false
+	}
+
+	var state freezeState
+	if err := json.Unmarshal(d, &state); err != nil {
+		log.Warningf(ctx, i18n.G("Invalid policy freeze state, considering policy updates as not frozen: %v"), err)
+		return time.Time{}, false
+	}
+
+	if !state.Until.IsZero() && time.Now().After(state.Until) {
+		log.Infof(ctx, i18n.G("Policy freeze expired on %s: resuming policy updates"), state.Until.Format(time.RFC3339))
+		if err := os.Remove(p); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			log.Warningf(ctx, i18n.G("Can't remove expired policy freeze: %v"), err)
+		}
+		return time.Time{}, false
+	}
+
+	return state.Until, true
+}
+
+// DescribeFreeze returns until when a freeze lasts, in a human readable form.
+func DescribeFreeze(until time.Time) string {
+	if until.IsZero() {
+		return i18n.G("until resumed with policy unfreeze")
+	}
+	return fmt.Sprintf(i18n.G("until %s"), until.Format(time.RFC3339))
+}
@@ -6,23 +6,82 @@ import (
 	"path/filepath"
 	"sort"
 	"strings"
+	"time"
 
+	"github.com/ubuntu/adsys/internal/i18n"
 	"github.com/ubuntu/adsys/internal/policies/entry"
+	"golang.org/x/exp/slices"
 )
 
 // GPO is a representation of a GPO with rules we support.
 type GPO struct {
-	ID   string
-	Name string
+	ID   string `json:"id"`
+	Name string `json:"name"`
 	// the string is the domain of rules (dconf, install…)
-	Rules map[string][]entry.Entry
+	Rules map[string][]entry.Entry `json:"rules"`
+
+	// stats are the download statistics of the GPO, only set to display them.
+	stats *gpoStats
+}
+
+// gpoStats are the statistics of the GPO files downloaded from the Active Directory.
+type gpoStats struct {
+	size       int64
+	lastChange time.Time
+}
+
+// DumpFilter restricts the GPOs and entries displayed when dumping policies. Empty fields don't filter anything.
+type DumpFilter struct {
+	// Manager only displays the entries of this policy manager, whatever their rules domain.
+	Manager string
+	// GPO only displays the GPO with this name or ID.
+	GPO string
+	// OnlyOverridden only displays the overridden entries.
+	OnlyOverridden bool
+}
+
+// filtersEntries returns true if the filter hides some entries of the GPOs it displays.
+func (f DumpFilter) filtersEntries() bool {
+	return f.Manager != "" || f.OnlyOverridden
+}
+
+// validate returns an error if the filter is on an unknown policy manager.
+func (f DumpFilter) validate() error {
+	if f.Manager != "" && !slices.Contains(statusManagersOrder, f.Manager) {
+		return fmt.Errorf(i18n.G("unknown policy manager %q: must be one of %s"), f.Manager, strings.Join(statusManagersOrder, ", "))
+	}
+	return nil
+}
+
+// matchManager returns true if the filter displays the entries of the rules domain. A policy manager can handle
+// several domains, like dconf with the dconf preferences.
+func (f DumpFilter) matchManager(domain string) bool {
+	return f.Manager == "" || managerForRulesKey(domain) == f.Manager
+}
+
+// matchGPO returns true if the filter displays g.
+func (f DumpFilter) matchGPO(g GPO) bool {
+	return f.GPO == "" || f.GPO == g.Name || f.GPO == g.ID
 }
 
-// Format write to w a formatted GPO. overridden entries are prepended with -.
+// Format write to w a formatted GPO. overridden entries are prepended with -. Report-only entries are suffixed
+// with "(report only)".
+// With rules, a summary of the GPO entries and download statistics, when known, is prepended with =.
 func (g GPO) Format(w io.Writer, withRules, withOverridden bool, alreadyProcessedRules map[string]struct{}) map[string]struct{} {
-	fmt.Fprintf(w, "* %s (%s)\n", g.Name, g.ID)
+	return g.format(w, withRules, withOverridden, alreadyProcessedRules, nil, DumpFilter{})
+}
+
+// format is Format, with the GPO winning each key resolved with the most restrictive precedence in
+// mostRestrictiveWinners. Those keys are suffixed with "(most restrictive)".
+// Only the GPO and entries matching filter are written, but all entries are processed to detect the overridden ones.
+// A GPO or a manager without entry matching filter is not written at all when filter hides some entries.
+func (g GPO) format(w io.Writer, withRules, withOverridden bool, alreadyProcessedRules map[string]struct{}, mostRestrictiveWinners map[string]string, filter DumpFilter) map[string]struct{} {
+	if !filter.matchGPO(g) {
+		w = io.Discard
+	}
 
 	if !withRules {
+		fmt.Fprintf(w, "* %s (%s)\n", g.Name, g.ID)
 		return nil
 	}
 
@@ -31,39 +90,77 @@ func (g GPO) Format(w io.Writer, withRules, withOverridden bool, alreadyProcesse
 	}
 
 	var domains []string
-	for domain := range g.Rules {
+	var nEntries int
+	for domain, rules := range g.Rules {
 		domains = append(domains, domain)
+		nEntries += len(rules)
 	}
 	sort.Strings(domains)
 
+	var body strings.Builder
+	var nShown int
 	for _, d := range domains {
-		fmt.Fprintf(w, "** %s:\n", d)
+		var domainBody strings.Builder
+		var nDomainShown int
 		for _, r := range g.Rules[d] {
 			k := filepath.Join(d, r.Key)
 			_, overr := alreadyProcessedRules[k]
+			var annotations string
+			if winner, ok := mostRestrictiveWinners[k]; ok && !r.ReportOnly {
+				overr = winner != g.ID
+				annotations = " " + i18n.G("(most restrictive)")
+			}
 			if !withOverridden && overr {
 				continue
 			}
-			prefix := "***"
-			if overr {
-				prefix += "-"
-			}
-			// Trim EOL \n and replace them all with \n in text to keep each value printed in one single line
-			v := strings.ReplaceAll(strings.TrimSpace(r.Value), "\n", `\n`)
-			if r.Disabled {
-				prefix += "+"
-				fmt.Fprintf(w, "%s %s\n", prefix, r.Key)
-			} else {
-				fmt.Fprintf(w, "%s %s: %s\n", prefix, r.Key, v)
+			if filter.matchManager(d) && (!filter.OnlyOverridden || overr) {
+				prefix := "***"
+				if overr {
+					prefix += "-"
+				}
+				// Trim EOL \n and replace them all with \n in text to keep each value printed in one single line
+				v := strings.ReplaceAll(strings.TrimSpace(r.Value), "\n", `\n`)
+				if r.ReportOnly {
+					annotations = " " + i18n.G("(report only)")
+				}
+				if r.Disabled {
+					prefix += "+"
+					fmt.Fprintf(&domainBody, "%s %s%s\n", prefix, r.Key, annotations)
+				} else {
+					fmt.Fprintf(&domainBody, "%s %s: %s%s\n", prefix, r.Key, v, annotations)
+				}
+				nDomainShown++
 			}
 
-			// Do not add non overridable key to the alreadyProcessedRules override detection map.
-			if r.Strategy == "append" {
+			// Do not add non overridable nor report-only keys to the alreadyProcessedRules override detection map.
+			if r.Strategy == "append" || r.ReportOnly {
 				continue
 			}
 			alreadyProcessedRules[k] = struct{}{}
 		}
+
+		if filter.filtersEntries() && nDomainShown == 0 {
+			continue
+		}
+		fmt.Fprintf(&body, "** %s:\n%s", d, domainBody.String())
+		nShown += nDomainShown
+	}
+
+	if filter.filtersEntries() && nShown == 0 {
+		return alreadyProcessedRules
 	}
 
+	managers := strings.Join(domains, ", ")
+	if managers == "" {
+		managers = i18n.G("none")
+	}
+	stats := fmt.Sprintf(i18n.G("entries: %d, managers: %s"), nEntries, managers)
+	if g.stats != nil {
+		stats += fmt.Sprintf(i18n.G(", downloaded: %d bytes, last change: %s"), g.stats.size, g.stats.lastChange.UTC().Format(time.RFC3339))
+	}
+	fmt.Fprintf(w, "* %s (%s)\n", g.Name, g.ID)
+	fmt.Fprintf(w, "*= %s\n", stats)
+	fmt.Fprint(w, body.String())
+
 	return alreadyProcessedRules
 }
@@ -0,0 +1,463 @@
+package gpp
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+
+	"github.com/ubuntu/adsys/internal/i18n"
+)
+
+/*
+ * Line helpers
+ */
+
+// splitLines splits content in lines, returning if there was a final newline.
+func splitLines(content string) (lines []string, finalNewLine bool) {
+	if content == "" {
+		return nil, true
+	}
+	finalNewLine = strings.HasSuffix(content, "\n")
+	return strings.Split(strings.TrimSuffix(content, "\n"), "\n"), finalNewLine
+}
+
+// joinLines is the reverse operation of splitLines.
+func joinLines(lines []string, finalNewLine bool) string {
+	if len(lines) == 0 {
+		return ""
+	}
+	r := strings.Join(lines, "\n")
+	if finalNewLine {
+		r += "\n"
+	}
+	return r
+}
+
+// lineEnsure appends line to content if there is no such line already.
+func lineEnsure(content, line string) string {
+	lines, finalNewLine := splitLines(content)
+	for _, l := range lines {
+		if strings.TrimRight(l, " \t") == line {
+			return content
+		}
+	}
+	return joinLines(append(lines, line), finalNewLine)
+}
+
+// lineRemove removes all occurrences of line in content.
+func lineRemove(content, line string) string {
+	lines, finalNewLine := splitLines(content)
+	var r []string
+	for _, l := range lines {
+		if strings.TrimRight(l, " \t") == line {
+			continue
+		}
+		r = append(r, l)
+	}
+	return joinLines(r, finalNewLine)
+}
+
+/*
+ * Ini helpers
+ */
+
+var iniSectionRe = regexp.MustCompile(`^\s*\[([^\]]*)\]\s*$`)
+
+// iniSectionRange returns the line range [start, end) of section content, without its header.
+// An empty section is the global one, before any section header.
+func iniSectionRange(lines []string, section string) (start, end int, found bool) {
+	if section == "" {
+		for i, l := range lines {
+			if iniSectionRe.MatchString(l) {
+				return 0, i, true
+			}
+		}
+		return 0, len(lines), true
+	}
+
+	start = -1
+	for i, l := range lines {
+		m := iniSectionRe.FindStringSubmatch(l)
+		if m == nil {
+			continue
+		}
+		if start != -1 {
+			return start, i, true
+		}
+		if strings.TrimSpace(m[1]) == section {
+			start = i + 1
+		}
+	}
+	if start == -1 {
+		return 0, 0, false
+	}
+	return start, len(lines), true
+}
+
+// iniKeyLine returns the index of the key in lines[start:end] and the position of its value on the line.
+func iniKeyLine(lines []string, start, end int, key string) (index, valueStart int) {
+	for i := start; i < end; i++ {
+		l := lines[i]
+		trimmed := strings.TrimSpace(l)
+		if strings.HasPrefix(trimmed, "#") || strings.HasPrefix(trimmed, ";") {
+			continue
+		}
+		k, _, found := strings.Cut(l, "=")
+		if !found || strings.TrimSpace(k) != key {
+			continue
+		}
+		valueStart = len(k) + 1
+		// Preserve spacing after the separator.
+		for valueStart < len(l) && (l[valueStart] == ' ' || l[valueStart] == '\t') {
+			valueStart++
+		}
+		return i, valueStart
+	}
+	return -1, 0
+}
+
+// iniGet returns the value of key in section and if it was found.
+func iniGet(content, section, key string) (string, bool) {
+	lines, _ := splitLines(content)
+	start, end, found := iniSectionRange(lines, section)
+	if !found {
+		return "", false
+	}
+	i, valueStart := iniKeyLine(lines, start, end, key)
+	if i == -1 {
+		return "", false
+	}
+	return strings.TrimSpace(lines[i][valueStart:]), true
+}
+
+// iniSet sets key to value in section, creating the section and key if needed.
+func iniSet(content, section, key, value string) string {
+	lines, finalNewLine := splitLines(content)
+	start, end, found := iniSectionRange(lines, section)
+	if !found {
+		if len(lines) > 0 && strings.TrimSpace(lines[len(lines)-1]) != "" {
+			lines = append(lines, "")
+		}
+		lines = append(lines, fmt.Sprintf("[%s]", section), fmt.Sprintf("%s=%s", key, value))
+		return joinLines(lines, finalNewLine)
+	}
+
+	if i, valueStart := iniKeyLine(lines, start, end, key); i != -1 {
+		lines[i] = lines[i][:valueStart] + value
+		return joinLines(lines, finalNewLine)
+	}
+
+	// Insert the key after the last non empty line of the section.
+	insertAt := end
+	for insertAt > start && strings.TrimSpace(lines[insertAt-1]) == "" {
+		insertAt--
+	}
+	lines = append(lines[:insertAt], append([]string{fmt.Sprintf("%s=%s", key, value)}, lines[insertAt:]...)...)
+	return joinLines(lines, finalNewLine)
+}
+
+// iniRemove removes key from section. The section is removed if it has no more keys.
+func iniRemove(content, section, key string) string {
+	lines, finalNewLine := splitLines(content)
+	start, end, found := iniSectionRange(lines, section)
+	if !found {
+		return content
+	}
+	i, _ := iniKeyLine(lines, start, end, key)
+	if i == -1 {
+		return content
+	}
+	lines = append(lines[:i], lines[i+1:]...)
+	end--
+
+	// Remove empty sections (and the blank line we added before them).
+	if section != "" {
+		empty := true
+		for _, l := range lines[start:end] {
+			if strings.TrimSpace(l) != "" {
+				empty = false
+				break
+			}
+		}
+		if empty {
+			headerStart := start - 1
+			if headerStart > 0 && strings.TrimSpace(lines[headerStart-1]) == "" {
+				headerStart--
+			}
+			lines = append(lines[:headerStart], lines[end:]...)
+		}
+	}
+
+	return joinLines(lines, finalNewLine)
+}
+
+/*
+ * XML helpers
+ */
+
+// xmlElement references the position of an element in a document.
+type xmlElement struct {
+	name  string
+	attrs []xml.Attr
+
+	// tagStart and tagEnd delimits the start tag.
+	tagStart, tagEnd int
+	// contentEnd is the offset of the end tag. It is equal to tagEnd for self closing elements.
+	contentEnd int
+	// end is the offset after the end tag.
+	end         int
+	selfClosing bool
+	hasChildren bool
+	text        string
+}
+
+func rawName(n xml.Name) string {
+	if n.Space == "" {
+		return n.Local
+	}
+	return n.Space + ":" + n.Local
+}
+
+// xmlFind returns the first element matching the absolute path elemPath (/root/child) in content.
+func xmlFind(content, elemPath string) (elem xmlElement, found bool, err error) {
+	want := strings.Split(strings.TrimPrefix(elemPath, "/"), "/")
+
+	d := xml.NewDecoder(strings.NewReader(content))
+	var stack []string
+	matchDepth := -1
+	var text strings.Builder
+	for {
+		off := int(d.InputOffset())
+		tok, err := d.RawToken()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return elem, false, fmt.Errorf(i18n.G("invalid xml content: %v"), err)
+		}
+		end := int(d.InputOffset())
+
+		switch t := tok.(type) {
+		case xml.StartElement:
+			stack = append(stack, rawName(t.Name))
+			if matchDepth != -1 {
+				if len(stack) == matchDepth+1 {
+					elem.hasChildren = true
+				}
+				continue
+			}
+			if !pathEqual(stack, want) {
+				continue
+			}
+			elem = xmlElement{
+				name:        rawName(t.Name),
+				attrs:       t.Copy().Attr,
+				tagStart:    off,
+				tagEnd:      end,
+				selfClosing: strings.HasSuffix(content[off:end], "/>"),
+			}
+			matchDepth = len(stack)
+		case xml.EndElement:
+			if matchDepth != -1 && len(stack) == matchDepth {
+				elem.contentEnd, elem.end = off, end
+				if elem.selfClosing {
+					elem.contentEnd, elem.end = elem.tagEnd, elem.tagEnd
+				}
+				elem.text = text.String()
+				return elem, true, nil
+			}
+			if len(stack) > 0 {
+				stack = stack[:len(stack)-1]
+			}
+		case xml.CharData:
+			if matchDepth != -1 && len(stack) == matchDepth {
+				text.Write(t)
+			}
+		}
+	}
+
+	return elem, false, nil
+}
+
+func pathEqual(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func xmlEscape(s string) string {
+	var b bytes.Buffer
+	_ = xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
+
+func xmlAttrRe(attr string) *regexp.Regexp {
+	return regexp.MustCompile(`\s+` + regexp.QuoteMeta(attr) + `\s*=\s*("[^"]*"|'[^']*')`)
+}
+
+// xmlGet returns the value of the attribute (or text content if attr is empty) of the element.
+func xmlGet(content, elemPath, attr string) (string, bool, error) {
+	if strings.TrimSpace(content) == "" {
+		return "", false, nil
+	}
+	elem, found, err := xmlFind(content, elemPath)
+	if err != nil || !found {
+		return "", false, err
+	}
+	if attr == "" {
+		if elem.hasChildren {
+			return "", false, fmt.Errorf(i18n.G("xml element %q has child elements and no text can be set"), elemPath)
+		}
+		return elem.text, true, nil
+	}
+	for _, a := range elem.attrs {
+		if rawName(a.Name) == attr {
+			return a.Value, true, nil
+		}
+	}
+	return "", false, nil
+}
+
+// xmlEnsureElement creates elemPath, and any missing parent, in content.
+func xmlEnsureElement(content, elemPath string) (string, xmlElement, error) {
+	if strings.TrimSpace(content) == "" {
+		content = ""
+	}
+	elem, found, err := xmlFind(content, elemPath)
+	if err != nil {
+		return "", elem, err
+	}
+	if found {
+		return content, elem, nil
+	}
+
+	i := strings.LastIndex(elemPath, "/")
+	parentPath, name := elemPath[:i], elemPath[i+1:]
+	if parentPath == "" {
+		if strings.TrimSpace(content) != "" {
+			return "", elem, fmt.Errorf(i18n.G("xml document already has a different root element than %q"), name)
+		}
+		content = fmt.Sprintf("<%s/>\n", name)
+	} else {
+		var parent xmlElement
+		content, parent, err = xmlEnsureElement(content, parentPath)
+		if err != nil {
+			return "", elem, err
+		}
+		child := fmt.Sprintf("<%s/>", name)
+		if parent.selfClosing {
+			tag := strings.TrimSuffix(strings.TrimRight(strings.TrimSuffix(content[parent.tagStart:parent.tagEnd], "/>"), " \t\n"), "/")
+			content = content[:parent.tagStart] + tag + ">" + child + fmt.Sprintf("</%s>", parent.name) + content[parent.tagEnd:]
+		} else {
+			content = content[:parent.contentEnd] + child + content[parent.contentEnd:]
+		}
+	}
+
+	elem, found, err = xmlFind(content, elemPath)
+	if err != nil {
+		return "", elem, err
+	}
+	if !found {
+		return "", elem, fmt.Errorf(i18n.G("could not create xml element %q"), elemPath)
+	}
+	return content, elem, nil
+}
+
+// xmlSet sets the attribute (or text content if attr is empty) of the element to value.
+func xmlSet(content, elemPath, attr, value string) (string, error) {
+	content, elem, err := xmlEnsureElement(content, elemPath)
+	if err != nil {
+		return "", err
+	}
+
+	tag := content[elem.tagStart:elem.tagEnd]
+	if attr == "" {
+		if elem.hasChildren {
+			return "", fmt.Errorf(i18n.G("xml element %q has child elements and no text can be set"), elemPath)
+		}
+		if elem.selfClosing {
+			tag = strings.TrimRight(strings.TrimSuffix(tag, "/>"), " \t\n") + ">"
+			return content[:elem.tagStart] + tag + xmlEscape(value) + fmt.Sprintf("</%s>", elem.name) + content[elem.tagEnd:], nil
+		}
+		return content[:elem.tagEnd] + xmlEscape(value) + content[elem.contentEnd:], nil
+	}
+
+	newAttr := fmt.Sprintf(` %s="%s"`, attr, xmlEscape(value))
+	re := xmlAttrRe(attr)
+	if re.MatchString(tag) {
+		tag = re.ReplaceAllLiteralString(tag, newAttr)
+	} else {
+		closing := ">"
+		if elem.selfClosing {
+			closing = "/>"
+		}
+		tag = strings.TrimRight(strings.TrimSuffix(tag, closing), " \t\n") + newAttr + closing
+	}
+	return content[:elem.tagStart] + tag + content[elem.tagEnd:], nil
+}
+
+// xmlFirstMissing returns the path of the first element of elemPath missing in content.
+// It returns an empty string if the element exists.
+func xmlFirstMissing(content, elemPath string) (string, error) {
+	if strings.TrimSpace(content) == "" {
+		return "/" + strings.Split(strings.TrimPrefix(elemPath, "/"), "/")[0], nil
+	}
+	var p string
+	for _, name := range strings.Split(strings.TrimPrefix(elemPath, "/"), "/") {
+		p = p + "/" + name
+		_, found, err := xmlFind(content, p)
+		if err != nil {
+			return "", err
+		}
+		if !found {
+			return p, nil
+		}
+	}
+	return "", nil
+}
+
+// xmlRemove removes the attribute of the element, or the whole element if attr is empty.
+// Elements up to createdElement are then removed if they are left empty.
+func xmlRemove(content, elemPath, attr, createdElement string) (string, error) {
+	if strings.TrimSpace(content) == "" {
+		return content, nil
+	}
+	elem, found, err := xmlFind(content, elemPath)
+	if err != nil || !found {
+		return content, err
+	}
+
+	p := elemPath
+	if attr == "" {
+		content = content[:elem.tagStart] + content[elem.end:]
+		p = p[:strings.LastIndex(p, "/")]
+	} else {
+		tag := xmlAttrRe(attr).ReplaceAllLiteralString(content[elem.tagStart:elem.tagEnd], "")
+		content = content[:elem.tagStart] + tag + content[elem.tagEnd:]
+	}
+
+	// Clean up the elements we created and are now empty.
+	for createdElement != "" && strings.HasPrefix(p+"/", createdElement+"/") {
+		elem, found, err := xmlFind(content, p)
+		if err != nil {
+			return "", err
+		}
+		if !found || len(elem.attrs) > 0 || elem.hasChildren || strings.TrimSpace(elem.text) != "" {
+			break
+		}
+		content = content[:elem.tagStart] + content[elem.end:]
+		p = p[:strings.LastIndex(p, "/")]
+	}
+
+	return content, nil
+}
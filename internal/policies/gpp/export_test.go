@@ -0,0 +1,8 @@
+package gpp
+
+// WithReadOnlyCheck defines a custom function reporting if a path is on a read-only filesystem for tests.
+func WithReadOnlyCheck(f func(string) (bool, error)) Option {
+	return func(o *options) {
+		o.isReadOnly = f
+	}
+}
@@ -0,0 +1,594 @@
+// Package gpp is the policy manager for Group Policy Preferences-like file items.
+//
+// This manager ensures that some configuration items exist with a given value in flat configuration
+// files, covering the long tail of applications which are not configured through dconf:
+//   - ini-files: a key in a given section of an ini-like file is set to a value;
+//   - line-in-files: a line is present in a file;
+//   - xml-files: an attribute or the text content of an element of an XML file is set to a value.
+//
+// Every item written by adsys is tracked in a state file in the cache directory, alongside the value
+// it replaced. When an item is no longer requested by any GPO, the previous value is restored (or the
+// key/line is removed if it was created by adsys), so that the file goes back to its original state.
+//
+// Files are edited in place on a temporary copy which is then atomically renamed, keeping the original
+// file permissions. Should the manager fail to parse an item or write a file, an error is returned and
+// authentication will be prevented.
+//
+// Files written by dedicated policy managers, like the sudoers file of the privilege manager, take precedence over
+// items: an item editing one of them is not applied and a conflict is reported instead, in the logs and in the
+// apply status of the machine.
+//
+// On image based systems, some files are on a read-only filesystem, like an immutable /usr. Items editing them are
+// skipped with a warning instead of failing the whole policy.
+package gpp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/ubuntu/adsys/internal/diskspace"
+	log "github.com/ubuntu/adsys/internal/grpc/logstreamer"
+	"github.com/ubuntu/adsys/internal/i18n"
+	"github.com/ubuntu/adsys/internal/policies/entry"
+	"github.com/ubuntu/decorate"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	stateFileName = "gpp-items"
+
+	kindIni  = "ini"
+	kindLine = "line"
+	kindXML  = "xml"
+)
+
+// keyToKind maps the supported entry keys to the kind of item they describe.
+var keyToKind = map[string]string{
+	"ini-files":     kindIni,
+	"line-in-files": kindLine,
+	"xml-files":     kindXML,
+}
+
+// item is a single configuration item managed in a file.
+type item struct {
+	Kind string
+	Path string
+	// Section is the ini section or the XML element path.
+	Section string `yaml:",omitempty"`
+	// Key is the ini key or the XML attribute. It is empty for line items and XML text content.
+	Key   string `yaml:",omitempty"`
+	Value string
+
+	// Previous is the value the item had before adsys managed it. nil means it didn't exist.
+	Previous *string `yaml:",omitempty"`
+	// CreatedFile is true if the file didn't exist before adsys wrote this item.
+	CreatedFile bool `yaml:",omitempty"`
+	// CreatedElement is the path of the topmost XML element created by adsys for this item.
+	CreatedElement string `yaml:",omitempty"`
+}
+
+// id identifies an item in a file, regardless of its value.
+func (i item) id() string {
+	if i.Kind == kindLine {
+		return strings.Join([]string{i.Kind, i.Path, i.Value}, "\x00")
+	}
+	return strings.Join([]string{i.Kind, i.Path, i.Section, i.Key}, "\x00")
+}
+
+// Conflict is an item which is not applied, as it edits a file managed by another policy manager.
+type Conflict struct {
+	// Path is the file edited by the item.
+	Path string `json:"path" yaml:"path"`
+	// Kept is the policy manager managing the file, whose policy is kept.
+	Kept string `json:"kept" yaml:"kept"`
+	// Dropped is the dropped item, with its kind.
+	Dropped string `json:"dropped" yaml:"dropped"`
+}
+
+// Manager prevents running multiple gpp update process in parallel while parsing policy in ApplyPolicy.
+type Manager struct {
+	stateDir string
+	rootDir  string
+	reserved map[string]string
+
+	conflictsMu sync.Mutex
+	conflicts   []Conflict
+
+	isReadOnly func(string) (bool, error)
+}
+
+type options struct {
+	rootDir    string
+	reserved   map[string]string
+	isReadOnly func(string) (bool, error)
+}
+
+// Option reprents an optional function to change the gpp manager.
+type Option func(*options)
+
+// WithRootDir prefixes every managed file path with p.
+func WithRootDir(p string) Option {
+	return func(o *options) {
+		o.rootDir = p
+	}
+}
+
+// WithReservedPaths prevents items to edit the files or directories managed by other policy managers.
+// reserved maps each path to the policy type owning it.
+func WithReservedPaths(reserved map[string]string) Option {
+	return func(o *options) {
+		o.reserved = reserved
+	}
+}
+
+// New creates a manager storing its ownership state in stateDir.
+func New(stateDir string, opts ...Option) *Manager {
+	o := options{
+		rootDir:    "/",
+		isReadOnly: diskspace.ReadOnly,
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	return &Manager{
+		stateDir: stateDir,
+		rootDir:  o.rootDir,
+		reserved: o.reserved,
+
+		isReadOnly: o.isReadOnly,
+	}
+}
+
+// ApplyPolicy ensures the file items described by entries are set, and restores the ones which are not
+// requested anymore.
+func (m *Manager) ApplyPolicy(ctx context.Context, objectName string, isComputer bool, entries []entry.Entry) (err error) {
+	defer decorate.OnError(&err, i18n.G("can't apply gpp policy to %s"), objectName)
+
+	// System files can only be managed by the machine policy.
+	if !isComputer {
+		return nil
+	}
+
+	log.Debugf(ctx, "Applying gpp policy to %s", objectName)
+
+	wanted, err := parseEntries(entries)
+	if err != nil {
+		return err
+	}
+	wanted, conflicts := m.withoutConflicts(ctx, wanted)
+	m.conflictsMu.Lock()
+	m.conflicts = conflicts
+	m.conflictsMu.Unlock()
+	wanted = m.withoutReadOnly(ctx, wanted)
+
+	previous, err := m.loadState()
+	if err != nil {
+		return err
+	}
+
+	wantedIDs := make(map[string]struct{})
+	for _, it := range wanted {
+		wantedIDs[it.id()] = struct{}{}
+	}
+
+	// Restore items we don’t manage anymore, in reverse order of application.
+	// Items on a read-only filesystem can't be restored for now: keep them in our state to restore them later on.
+	var unrestored []item
+	for i := len(previous) - 1; i >= 0; i-- {
+		it := previous[i]
+		if _, ok := wantedIDs[it.id()]; ok {
+			continue
+		}
+		if m.readOnly(ctx, it) {
+			log.Warningf(ctx, i18n.G("Can't restore gpp %s item %q: %s is on a read-only filesystem"), it.Kind, it.Section+it.Key, it.Path)
+			unrestored = append([]item{it}, unrestored...)
+			continue
+		}
+		log.Debugf(ctx, "Restoring %s item %q in %s", it.Kind, it.Section+it.Key, it.Path)
+		if err := m.restore(it); err != nil {
+			return err
+		}
+	}
+
+	previousByID := make(map[string]item)
+	for _, it := range previous {
+		previousByID[it.id()] = it
+	}
+
+	var applied []item
+	for _, it := range wanted {
+		// Keep the original value of items we were already managing.
+		if prev, ok := previousByID[it.id()]; ok {
+			it.Previous = prev.Previous
+			it.CreatedFile = prev.CreatedFile
+			it.CreatedElement = prev.CreatedElement
+		}
+		log.Debugf(ctx, "Setting %s item %q in %s", it.Kind, it.Section+it.Key, it.Path)
+		it, err := m.set(it, previousByID)
+		if err != nil {
+			// Save what we already applied so that we can restore it later on.
+			if errSave := m.saveState(append(append(unrestored, applied...), remaining(previous, applied, wantedIDs)...)); errSave != nil {
+				log.Warningf(ctx, i18n.G("Can't save gpp items state: %v"), errSave)
+			}
+			return err
+		}
+		applied = append(applied, it)
+	}
+
+	return m.saveState(append(unrestored, applied...))
+}
+
+// withoutConflicts returns the items which don’t edit a file managed by another policy manager, and the conflicts
+// of the dropped ones. A warning is logged for each dropped item.
+func (m *Manager) withoutConflicts(ctx context.Context, items []item) (r []item, conflicts []Conflict) {
+	for _, it := range items {
+		owner, reservedPath := m.ownerOf(m.path(it.Path))
+		if owner == "" {
+			r = append(r, it)
+			continue
+		}
+		desc := strings.Trim(strings.Join([]string{it.Section, it.Key, it.Value}, ";"), ";")
+		log.Warningf(ctx, i18n.G("Policy conflict on %s: kept %s policy (%s), dropped gpp %s item %q"),
+			it.Path, owner, reservedPath, it.Kind, desc)
+		conflicts = append(conflicts, Conflict{Path: it.Path, Kept: owner, Dropped: fmt.Sprintf("%s %q", it.Kind, desc)})
+	}
+	return r, conflicts
+}
+
+// Conflicts returns the items which were not applied by the last machine apply, as they edit files managed by other
+// policy managers.
+func (m *Manager) Conflicts() []Conflict {
+	m.conflictsMu.Lock()
+	defer m.conflictsMu.Unlock()
+
+	return m.conflicts
+}
+
+// withoutReadOnly returns the items which don’t edit a file on a read-only filesystem, like the immutable /usr of
+// image based systems. A warning is logged for each dropped item.
+func (m *Manager) withoutReadOnly(ctx context.Context, items []item) (r []item) {
+	for _, it := range items {
+		if !m.readOnly(ctx, it) {
+			r = append(r, it)
+			continue
+		}
+		log.Warningf(ctx, i18n.G("Skipping gpp %s item %q: %s is on a read-only filesystem"),
+			it.Kind, strings.Trim(strings.Join([]string{it.Section, it.Key, it.Value}, ";"), ";"), it.Path)
+	}
+	return r
+}
+
+// readOnly returns if the file of the item is on a read-only filesystem.
+// A filesystem which can't be checked is considered writable: writing to it will report the error.
+func (m *Manager) readOnly(ctx context.Context, it item) bool {
+	ro, err := m.isReadOnly(m.path(it.Path))
+	if err != nil {
+		log.Debugf(ctx, "Can't check if %s is read-only: %v", it.Path, err)
+		return false
+	}
+	return ro
+}
+
+// ownerOf returns the policy type managing p and the reserved path it matched. The longest reserved path wins.
+func (m *Manager) ownerOf(p string) (owner, reservedPath string) {
+	for rp, o := range m.reserved {
+		if p != rp && !strings.HasPrefix(p, rp+string(filepath.Separator)) {
+			continue
+		}
+		if len(rp) > len(reservedPath) {
+			owner, reservedPath = o, rp
+		}
+	}
+	return owner, reservedPath
+}
+
+// remaining returns the previous items which are still wanted but not applied yet.
+func remaining(previous, applied []item, wantedIDs map[string]struct{}) (r []item) {
+	appliedIDs := make(map[string]struct{})
+	for _, it := range applied {
+		appliedIDs[it.id()] = struct{}{}
+	}
+	for _, it := range previous {
+		if _, ok := wantedIDs[it.id()]; !ok {
+			continue
+		}
+		if _, ok := appliedIDs[it.id()]; ok {
+			continue
+		}
+		r = append(r, it)
+	}
+	return r
+}
+
+// set writes the item to its file, recording the previous value if we didn’t manage it before.
+func (m *Manager) set(it item, managed map[string]item) (item, error) {
+	p := m.path(it.Path)
+	content, mode, err := readFile(p)
+	fileExists := true
+	if errors.Is(err, fs.ErrNotExist) {
+		fileExists = false
+	} else if err != nil {
+		return it, err
+	}
+
+	if _, ok := managed[it.id()]; !ok {
+		it.CreatedFile = !fileExists
+		prev, found, err := getValue(it, content)
+		if err != nil {
+			return it, err
+		}
+		if found {
+			it.Previous = &prev
+		}
+		if it.Kind == kindXML {
+			if it.CreatedElement, err = xmlFirstMissing(content, it.Section); err != nil {
+				return it, err
+			}
+		}
+	}
+
+	newContent, err := setValue(it, content)
+	if err != nil {
+		return it, err
+	}
+	if fileExists && newContent == content {
+		return it, nil
+	}
+
+	return it, writeFile(p, newContent, mode)
+}
+
+// restore puts back the previous value of an item, or removes it if it didn’t exist.
+func (m *Manager) restore(it item) error {
+	p := m.path(it.Path)
+	content, mode, err := readFile(p)
+	if errors.Is(err, fs.ErrNotExist) {
+		// Nothing to restore.
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	var newContent string
+	if it.Previous != nil {
+		it.Value = *it.Previous
+		newContent, err = setValue(it, content)
+	} else {
+		newContent, err = removeValue(it, content)
+	}
+	if err != nil {
+		return err
+	}
+
+	if it.CreatedFile && strings.TrimSpace(newContent) == "" {
+		return os.Remove(p)
+	}
+	if newContent == content {
+		return nil
+	}
+	return writeFile(p, newContent, mode)
+}
+
+// path returns the destination path of a file with the root directory prefix.
+func (m *Manager) path(p string) string {
+	return filepath.Join(m.rootDir, p)
+}
+
+func (m *Manager) loadState() (items []item, err error) {
+	defer decorate.OnError(&err, i18n.G("can't load gpp items state"))
+
+	d, err := os.ReadFile(filepath.Join(m.stateDir, stateFileName))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	if err := yaml.Unmarshal(d, &items); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+// ManagedFiles returns the paths of the files edited by the gpp items currently applied.
+func (m *Manager) ManagedFiles() (paths []string, err error) {
+	items, err := m.loadState()
+	if err != nil {
+		return nil, err
+	}
+
+	seen := make(map[string]struct{})
+	for _, it := range items {
+		p := filepath.Join(m.rootDir, it.Path)
+		if _, ok := seen[p]; ok {
+			continue
+		}
+		seen[p] = struct{}{}
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+	return paths, nil
+}
+
+func (m *Manager) saveState(items []item) (err error) {
+	defer decorate.OnError(&err, i18n.G("can't save gpp items state"))
+
+	p := filepath.Join(m.stateDir, stateFileName)
+	if len(items) == 0 {
+		if err := os.Remove(p); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+		return nil
+	}
+
+	if err := os.MkdirAll(m.stateDir, 0700); err != nil {
+		return err
+	}
+	d, err := yaml.Marshal(items)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(p+".new", d, 0600); err != nil {
+		return err
+	}
+	return os.Rename(p+".new", p)
+}
+
+// parseEntries converts entries to the list of items to apply. Items are applied in file and entry order.
+func parseEntries(entries []entry.Entry) (items []item, err error) {
+	defer decorate.OnError(&err, i18n.G("can't parse gpp entries"))
+
+	seen := make(map[string]int)
+	for _, e := range entries {
+		kind, ok := keyToKind[e.Key]
+		if !ok || e.Disabled {
+			continue
+		}
+		for _, l := range strings.Split(e.Value, "\n") {
+			l = strings.TrimSpace(l)
+			if l == "" {
+				continue
+			}
+			it, err := parseItem(kind, l)
+			if err != nil {
+				return nil, err
+			}
+			// Closest definition of the same item wins.
+			if i, exists := seen[it.id()]; exists {
+				items[i] = it
+				continue
+			}
+			seen[it.id()] = len(items)
+			items = append(items, it)
+		}
+	}
+
+	sort.SliceStable(items, func(i, j int) bool { return items[i].Path < items[j].Path })
+	return items, nil
+}
+
+// parseItem parses a single line of the form:
+//   - ini:  <path>;<section>;<key>;<value>
+//   - line: <path>;<line>
+//   - xml:  <path>;<element path>;<attribute>;<value>
+func parseItem(kind, l string) (it item, err error) {
+	nFields := 4
+	if kind == kindLine {
+		nFields = 2
+	}
+	fields := strings.SplitN(l, ";", nFields)
+	if len(fields) != nFields {
+		return it, fmt.Errorf(i18n.G("%s item %q should have %d fields separated by ;"), kind, l, nFields)
+	}
+	for i := range fields[:nFields-1] {
+		fields[i] = strings.TrimSpace(fields[i])
+	}
+
+	it = item{Kind: kind, Path: fields[0]}
+	if !filepath.IsAbs(it.Path) || filepath.Clean(it.Path) != it.Path {
+		return it, fmt.Errorf(i18n.G("%s item %q should reference a clean absolute path"), kind, l)
+	}
+
+	switch kind {
+	case kindLine:
+		it.Value = strings.TrimRight(fields[1], " \t")
+		if it.Value == "" {
+			return it, fmt.Errorf(i18n.G("line item %q has no line to ensure"), l)
+		}
+	case kindIni:
+		it.Section, it.Key, it.Value = fields[1], fields[2], strings.TrimSpace(fields[3])
+		if it.Key == "" {
+			return it, fmt.Errorf(i18n.G("ini item %q has no key"), l)
+		}
+	case kindXML:
+		it.Section, it.Key, it.Value = fields[1], fields[2], fields[3]
+		if !strings.HasPrefix(it.Section, "/") || strings.HasSuffix(it.Section, "/") {
+			return it, fmt.Errorf(i18n.G("xml item %q should have an absolute element path"), l)
+		}
+	}
+
+	return it, nil
+}
+
+// readFile returns the content and mode of the file p.
+func readFile(p string) (string, fs.FileMode, error) {
+	fi, err := os.Stat(p)
+	if err != nil {
+		return "", 0, err
+	}
+	if fi.IsDir() {
+		return "", 0, fmt.Errorf(i18n.G("%s is a directory"), p)
+	}
+	d, err := os.ReadFile(p)
+	if err != nil {
+		return "", 0, err
+	}
+	return string(d), fi.Mode().Perm(), nil
+}
+
+// writeFile atomically replaces p with content, creating the parent directory if needed.
+func writeFile(p, content string, mode fs.FileMode) error {
+	if mode == 0 {
+		mode = 0644
+	}
+	// #nosec G301 - match default system configuration directory permissions
+	if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		return err
+	}
+	if err := os.WriteFile(p+".adsys.new", []byte(content), mode); err != nil {
+		return err
+	}
+	return os.Rename(p+".adsys.new", p)
+}
+
+// getValue returns the current value of the item in content and if it was found.
+func getValue(it item, content string) (string, bool, error) {
+	switch it.Kind {
+	case kindIni:
+		v, found := iniGet(content, it.Section, it.Key)
+		return v, found, nil
+	case kindLine:
+		// A line is either present or not: keep it on restore if it was already there.
+		if lineEnsure(content, it.Value) == content && content != "" {
+			return it.Value, true, nil
+		}
+		return "", false, nil
+	case kindXML:
+		return xmlGet(content, it.Section, it.Key)
+	}
+	return "", false, fmt.Errorf(i18n.G("unknown item kind %q"), it.Kind)
+}
+
+// setValue returns content with the item set to its value.
+func setValue(it item, content string) (string, error) {
+	switch it.Kind {
+	case kindIni:
+		return iniSet(content, it.Section, it.Key, it.Value), nil
+	case kindLine:
+		return lineEnsure(content, it.Value), nil
+	case kindXML:
+		return xmlSet(content, it.Section, it.Key, it.Value)
+	}
+	return "", fmt.Errorf(i18n.G("unknown item kind %q"), it.Kind)
+}
+
+// removeValue returns content without the item.
+func removeValue(it item, content string) (string, error) {
+	switch it.Kind {
+	case kindIni:
+		return iniRemove(content, it.Section, it.Key), nil
+	case kindLine:
+		return lineRemove(content, it.Value), nil
+	case kindXML:
+		return xmlRemove(content, it.Section, it.Key, it.CreatedElement)
+	}
+	return "", fmt.Errorf(i18n.G("unknown item kind %q"), it.Kind)
+}
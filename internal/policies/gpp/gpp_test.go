@@ -0,0 +1,198 @@
+package gpp_test
+
+import (
+	"context"
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/termie/go-shutil"
+	"github.com/ubuntu/adsys/internal/policies/entry"
+	"github.com/ubuntu/adsys/internal/policies/gpp"
+	"github.com/ubuntu/adsys/internal/testutils"
+)
+
+func TestApplyPolicy(t *testing.T) {
+	t.Parallel()
+
+	allItems := []entry.Entry{
+		{Key: "ini-files", Value: `/etc/app/app.ini;General;Enabled;true
+/etc/app/app.ini;;version;3
+/etc/app/app.ini;Network;Port;8080
+/etc/app/app.ini;Security;Level;high`},
+		{Key: "line-in-files", Value: `/etc/app/lines.conf;option=managed
+/etc/app/lines.conf;option=default`},
+		{Key: "xml-files", Value: `/etc/app/app.xml;/config/server;url;https://example.com
+/etc/app/app.xml;/config/server;retries;3
+/etc/app/app.xml;/config/name;;"remote" & co
+/etc/app/app.xml;/config/cache/path;;/var/cache/app`},
+	}
+
+	tests := map[string]struct {
+		entries       []entry.Entry
+		secondEntries []entry.Entry
+		runSecondCall bool
+		existingFiles string
+		notComputer   bool
+		makeReadOnly  string
+		reserved      map[string]string
+
+		wantConflicts []gpp.Conflict
+		readOnlyFS    string
+
+		wantErr       bool
+		wantSecondErr bool
+	}{
+		"Set items in existing files":     {entries: allItems, existingFiles: "existing-files"},
+		"Set items creating files":        {entries: allItems},
+		"Multiple lines in an entry":      {entries: []entry.Entry{{Key: "line-in-files", Value: "/etc/app/lines.conf;first\n\n  /etc/app/lines.conf;second  \n"}}},
+		"Closest item definition wins":    {entries: []entry.Entry{{Key: "ini-files", Value: "/etc/app/app.ini;General;Enabled;first"}, {Key: "ini-files", Value: "/etc/app/app.ini;General;Enabled;second"}}},
+		"Value can contain semicolons":    {entries: []entry.Entry{{Key: "ini-files", Value: "/etc/app/app.ini;General;Command;a;b;c"}}},
+		"Disabled entries are ignored":    {entries: []entry.Entry{{Key: "ini-files", Value: "/etc/app/app.ini;General;Enabled;true", Disabled: true}}, existingFiles: "existing-files"},
+		"Unknown keys are ignored":        {entries: []entry.Entry{{Key: "unknown", Value: "/etc/app/app.ini;General;Enabled;true"}}, existingFiles: "existing-files"},
+		"No entries and no state is noop": {existingFiles: "existing-files"},
+		"Not a computer does nothing":     {entries: allItems, existingFiles: "existing-files", notComputer: true},
+
+		// Conflict cases
+		"Items editing reserved files are not applied": {
+			entries: []entry.Entry{
+				{Key: "ini-files", Value: "/etc/app/app.ini;General;Enabled;true"},
+				{Key: "line-in-files", Value: "/etc/sudoers.d/99-adsys-privilege-enforcement;%admins ALL=(ALL) ALL"},
+			},
+			reserved:      map[string]string{"/etc/sudoers.d/99-adsys-privilege-enforcement": "privilege"},
+			wantConflicts: []gpp.Conflict{{Path: "/etc/sudoers.d/99-adsys-privilege-enforcement", Kept: "privilege", Dropped: `line "%admins ALL=(ALL) ALL"`}},
+		},
+		"Items editing files in reserved directories are not applied": {
+			entries: []entry.Entry{
+				{Key: "ini-files", Value: "/etc/app/app.ini;General;Enabled;true"},
+				{Key: "line-in-files", Value: "/etc/apparmor.d/adsys/machine/profile;deny /tmp/** w,"},
+			},
+			reserved:      map[string]string{"/etc/apparmor.d/adsys": "apparmor"},
+			wantConflicts: []gpp.Conflict{{Path: "/etc/apparmor.d/adsys/machine/profile", Kept: "apparmor", Dropped: `line "deny /tmp/** w,"`}},
+		},
+		"Items editing files next to reserved ones are applied": {
+			entries:  []entry.Entry{{Key: "line-in-files", Value: "/etc/sudoers.d/99-adsys-privilege-enforcement-other;Defaults timestamp_timeout=5"}},
+			reserved: map[string]string{"/etc/sudoers.d/99-adsys-privilege-enforcement": "privilege"},
+		},
+
+		// Read-only filesystem cases
+		"Items on read only filesystems are skipped": {
+			entries: []entry.Entry{
+				{Key: "ini-files", Value: "/etc/app/app.ini;General;Enabled;true"},
+				{Key: "line-in-files", Value: "/usr/share/app/lines.conf;option=managed"},
+			},
+			readOnlyFS: "/usr",
+		},
+		"Items on read only filesystems are kept to be restored later": {
+			entries:       allItems,
+			existingFiles: "existing-files",
+			readOnlyFS:    "/etc/app",
+			runSecondCall: true,
+		},
+
+		// Second call cases
+		"Second call with no entries restores existing files": {entries: allItems, existingFiles: "existing-files", runSecondCall: true},
+		"Second call with no entries removes created files":   {entries: allItems, runSecondCall: true},
+		"Second call with changed value keeps original value to restore": {
+			entries:       allItems,
+			secondEntries: []entry.Entry{{Key: "ini-files", Value: "/etc/app/app.ini;General;Enabled;changed"}},
+			existingFiles: "existing-files",
+			runSecondCall: true,
+		},
+
+		// Error cases
+		"Error on ini item with missing fields":       {entries: []entry.Entry{{Key: "ini-files", Value: "/etc/app/app.ini;General;Enabled"}}, wantErr: true},
+		"Error on ini item with no key":               {entries: []entry.Entry{{Key: "ini-files", Value: "/etc/app/app.ini;General;;true"}}, wantErr: true},
+		"Error on line item with empty line":          {entries: []entry.Entry{{Key: "line-in-files", Value: "/etc/app/lines.conf;  "}}, wantErr: true},
+		"Error on relative path":                      {entries: []entry.Entry{{Key: "line-in-files", Value: "etc/app/lines.conf;line"}}, wantErr: true},
+		"Error on unclean path":                       {entries: []entry.Entry{{Key: "line-in-files", Value: "/etc/app/../lines.conf;line"}}, wantErr: true},
+		"Error on xml item with relative element":     {entries: []entry.Entry{{Key: "xml-files", Value: "/etc/app/app.xml;config;url;value"}}, wantErr: true},
+		"Error on xml item with another root element": {entries: []entry.Entry{{Key: "xml-files", Value: "/etc/app/app.xml;/other;url;value"}}, existingFiles: "existing-files", wantErr: true},
+		"Error on xml item with children text":        {entries: []entry.Entry{{Key: "xml-files", Value: "/etc/app/app.xml;/config/features;;value"}}, existingFiles: "existing-files", wantErr: true},
+		"Error on invalid xml file":                   {entries: []entry.Entry{{Key: "xml-files", Value: "/etc/app/lines.conf;/config;url;value"}}, existingFiles: "existing-files", wantErr: true},
+		"Error on file being a directory":             {entries: []entry.Entry{{Key: "line-in-files", Value: "/etc/app;line"}}, existingFiles: "existing-files", wantErr: true},
+		"Error on read only destination":              {entries: allItems, existingFiles: "existing-files", makeReadOnly: "root/etc/app", wantErr: true},
+		"Error on read only state directory":          {entries: allItems, makeReadOnly: "cache", wantErr: true},
+		"Error on read only destination when restoring": {
+			entries:       allItems,
+			existingFiles: "existing-files",
+			makeReadOnly:  "root/etc/app",
+			runSecondCall: true,
+			wantSecondErr: true,
+		},
+	}
+	for name, tc := range tests {
+		tc := tc
+		name := name
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			tmpDir := t.TempDir()
+			rootDir := filepath.Join(tmpDir, "root")
+			stateDir := filepath.Join(tmpDir, "cache")
+			require.NoError(t, os.MkdirAll(rootDir, 0750), "Setup: can't create root directory")
+			require.NoError(t, os.MkdirAll(stateDir, 0750), "Setup: can't create state directory")
+
+			if tc.existingFiles != "" {
+				require.NoError(t,
+					shutil.CopyTree(
+						filepath.Join("testdata", tc.existingFiles, "etc"), filepath.Join(rootDir, "etc"),
+						&shutil.CopyTreeOptions{Symlinks: true, CopyFunction: shutil.Copy}),
+					"Setup: can't create initial files")
+			}
+
+			reserved := make(map[string]string)
+			for p, owner := range tc.reserved {
+				reserved[filepath.Join(rootDir, p)] = owner
+			}
+			opts := []gpp.Option{gpp.WithRootDir(rootDir), gpp.WithReservedPaths(reserved)}
+			// Without a second call, the filesystem is read-only from the start.
+			readOnly := !tc.runSecondCall
+			if tc.readOnlyFS != "" {
+				readOnlyFS := filepath.Join(rootDir, tc.readOnlyFS)
+				opts = append(opts, gpp.WithReadOnlyCheck(func(p string) (bool, error) {
+					return readOnly && (p == readOnlyFS || strings.HasPrefix(p, readOnlyFS+"/")), nil
+				}))
+			}
+			m := gpp.New(stateDir, opts...)
+
+			if tc.makeReadOnly != "" && !tc.runSecondCall {
+				testutils.MakeReadOnly(t, filepath.Join(tmpDir, tc.makeReadOnly))
+			}
+
+			err := m.ApplyPolicy(context.Background(), "ubuntu", !tc.notComputer, tc.entries)
+			if tc.wantErr {
+				require.Error(t, err, "ApplyPolicy should have failed but didn't")
+				return
+			}
+			require.NoError(t, err, "ApplyPolicy failed but shouldn't have")
+			require.Equal(t, tc.wantConflicts, m.Conflicts(), "ApplyPolicy should report the dropped items editing reserved files")
+
+			if tc.runSecondCall {
+				// The filesystem becomes read-only after applying the first policy.
+				readOnly = true
+				if tc.makeReadOnly != "" {
+					testutils.MakeReadOnly(t, filepath.Join(tmpDir, tc.makeReadOnly))
+				}
+				err = m.ApplyPolicy(context.Background(), "ubuntu", !tc.notComputer, tc.secondEntries)
+				if tc.wantSecondErr {
+					require.Error(t, err, "Second call to ApplyPolicy should have failed but didn't")
+					return
+				}
+				require.NoError(t, err, "Second call to ApplyPolicy failed but shouldn't have")
+			}
+
+			testutils.CompareTreesWithFiltering(t, tmpDir, testutils.GoldenPath(t), testutils.Update())
+		})
+	}
+}
+
+func TestMain(m *testing.M) {
+	testutils.InstallUpdateFlag()
+	flag.Parse()
+
+	m.Run()
+}
@@ -0,0 +1,199 @@
+// Package hooks lets other software react to the policy changes, without polling the last update time.
+//
+// After each successful policy apply, once the new policies are cached, the PolicyUpdated signal is emitted on the system bus with the name of the
+// object whose policies were applied, whether it is the machine, and the policy managers whose entries changed.
+// Then, each executable of the hooks directory is executed in lexical order, with the same information in the
+// ADSYS_TARGET, ADSYS_IS_COMPUTER and ADSYS_CHANGED_MANAGERS environment variables. The changed policy managers are
+// separated by spaces.
+//
+// Hooks run in the background and don't delay the policy apply. Hooks failing or timing out are reported, but don't
+// fail the policy apply. A missing hooks directory means there is no hook installed.
+package hooks
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/godbus/dbus/v5"
+	log "github.com/ubuntu/adsys/internal/grpc/logstreamer"
+	"github.com/ubuntu/adsys/internal/i18n"
+	"github.com/ubuntu/adsys/internal/smbsafe"
+	"github.com/ubuntu/decorate"
+)
+
+const (
+	// DbusObjectPath is the object path the signals are emitted from.
+	DbusObjectPath = "/com/ubuntu/AdSys"
+	// DbusInterface is the interface of the signals.
+	DbusInterface = "com.ubuntu.AdSys"
+	// PolicyUpdatedSignal is the name of the signal emitted after each successful policy apply.
+	PolicyUpdatedSignal = DbusInterface + ".PolicyUpdated"
+
+	// MachineProperty is the name of the property with the update state of the machine.
+	MachineProperty = "Machine"
+	// UsersProperty is the name of the property with the update state of each user.
+	UsersProperty = "Users"
+)
+
+// DefaultTimeout is the maximum duration of a hook execution.
+const DefaultTimeout = time.Minute
+
+// NotifyTimeout is the maximum duration of a whole notification, running all the hooks.
+const NotifyTimeout = 5 * time.Minute
+
+// Notifier notifies other software of the policy changes.
+type Notifier struct {
+	bus      *dbus.Conn
+	hooksDir string
+	timeout  time.Duration
+}
+
+type options struct {
+	timeout time.Duration
+}
+
+// Option reprents an optional function to change the notifier.
+type Option func(*options)
+
+// WithTimeout overrides the maximum duration of a hook execution.
+func WithTimeout(timeout time.Duration) Option {
+	return func(o *options) {
+		o.timeout = timeout
+	}
+}
+
+// New creates a notifier emitting signals on bus and running the hooks of hooksDir.
+func New(bus *dbus.Conn, hooksDir string, opts ...Option) *Notifier {
+	// defaults
+	args := options{
+		timeout: DefaultTimeout,
+	}
+	// applied options
+	for _, o := range opts {
+		o(&args)
+	}
+
+	return &Notifier{
+		bus:      bus,
+		hooksDir: hooksDir,
+		timeout:  args.timeout,
+	}
+}
+
+// PolicyUpdated emits the PolicyUpdated signal and runs all hooks, to notify that the policies of objectName were
+// applied, with changedManagers being the policy managers whose entries changed.
+// All hooks are executed, even if the signal can't be emitted or if some of them fail.
+func (n Notifier) PolicyUpdated(ctx context.Context, objectName string, isComputer bool, changedManagers []string) (err error) {
+	defer decorate.OnError(&err, i18n.G("can't notify policy update of %s"), objectName)
+
+	if changedManagers == nil {
+		changedManagers = []string{}
+	}
+
+	var errs []error
+	if err := n.bus.Emit(DbusObjectPath, PolicyUpdatedSignal, objectName, isComputer, changedManagers); err != nil {
+		errs = append(errs, fmt.Errorf(i18n.G("can't emit D-Bus signal: %v"), err))
+	}
+
+	names, err := n.hooks(ctx)
+	if err != nil {
+		return errors.Join(append(errs, err)...)
+	}
+	env := append(os.Environ(),
+		"ADSYS_TARGET="+objectName,
+		"ADSYS_IS_COMPUTER="+strconv.FormatBool(isComputer),
+		"ADSYS_CHANGED_MANAGERS="+strings.Join(changedManagers, " "))
+	for _, name := range names {
+		if err := n.run(ctx, name, env); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// StateChanged emits the PropertiesChanged signal invalidating the update state property of the machine or of the
+// users, after a policy apply, whether it succeeded or not.
+func (n Notifier) StateChanged(ctx context.Context, isComputer bool) {
+	property := UsersProperty
+	if isComputer {
+		property = MachineProperty
+	}
+	if err := n.bus.Emit(DbusObjectPath, "org.freedesktop.DBus.Properties.PropertiesChanged",
+		DbusInterface, map[string]dbus.Variant{}, []string{property}); err != nil {
+		log.Warningf(ctx, i18n.G("Can't emit D-Bus signal of the update state change: %v"), err)
+	}
+}
+
+// hooks returns the sorted list of hooks to execute.
+func (n Notifier) hooks(ctx context.Context) (names []string, err error) {
+	dirEntries, err := os.ReadDir(n.hooksDir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	for _, de := range dirEntries {
+		name := de.Name()
+		if strings.HasPrefix(name, ".") {
+			continue
+		}
+		info, err := os.Stat(filepath.Join(n.hooksDir, name))
+		if err != nil {
+			log.Warningf(ctx, i18n.G("Ignoring hook %q: %v"), name, err)
+			continue
+		}
+		if !info.Mode().IsRegular() || info.Mode().Perm()&0111 == 0 {
+			log.Debugf(ctx, "Ignoring %q in hooks directory: not an executable file", name)
+			continue
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names, nil
+}
+
+// run executes a hook with env.
+func (n Notifier) run(ctx context.Context, name string, env []string) (err error) {
+	defer decorate.OnError(&err, i18n.G("hook %q failed"), name)
+
+	log.Debugf(ctx, "Running hook %q", name)
+
+	cmdCtx, cancel := context.WithTimeout(ctx, n.timeout)
+	defer cancel()
+	// #nosec G204 - hooks are installed by the administrator in a root owned directory.
+	cmd := exec.CommandContext(cmdCtx, filepath.Join(n.hooksDir, name))
+	cmd.Env = env
+	// Don't wait for children of a killed hook still holding its output.
+	cmd.WaitDelay = time.Second
+	var out bytes.Buffer
+	cmd.Stdout = &out
+	cmd.Stderr = &out
+
+	smbsafe.WaitExec()
+	errExec := cmd.Run()
+	smbsafe.DoneExec()
+
+	if out.Len() > 0 {
+		log.Debugf(ctx, "Hook %q output: %s", name, strings.TrimSpace(out.String()))
+	}
+	if errExec != nil {
+		if cmd.ProcessState == nil {
+			return errExec
+		}
+		return fmt.Errorf(i18n.G("exited with %d: %v\n%s"), cmd.ProcessState.ExitCode(), errExec, out.String())
+	}
+
+	return nil
+}
@@ -0,0 +1,172 @@
+package hooks_test
+
+import (
+	"context"
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/godbus/dbus/v5"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+	"github.com/ubuntu/adsys/internal/policies/hooks"
+	"github.com/ubuntu/adsys/internal/testutils"
+)
+
+func TestPolicyUpdated(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		isComputer      bool
+		changedManagers []string
+		hooks           map[string]string
+		noHooksDir      bool
+		timeout         time.Duration
+
+		wantHooksOutput []string
+		wantErr         bool
+	}{
+		"Emit signal without hooks":             {changedManagers: []string{"dconf"}},
+		"Emit signal without hooks directory":   {changedManagers: []string{"dconf"}, noHooksDir: true},
+		"Emit signal without changed managers":  {},
+		"Emit signal for computer":              {isComputer: true, changedManagers: []string{"apparmor", "scripts"}},
+		"Hook receives the policy update":       {changedManagers: []string{"dconf", "privilege"}, hooks: map[string]string{"01-hook": "ok"}, wantHooksOutput: []string{"01-hook: user false dconf privilege"}},
+		"Hooks are executed in lexical order":   {hooks: map[string]string{"02-second": "ok", "01-first": "ok"}, wantHooksOutput: []string{"01-first: user false ", "02-second: user false "}},
+		"Dot files and non executables ignored": {hooks: map[string]string{"01-hook": "ok", ".hidden": "ok", "02-not-executable": "not-executable"}, wantHooksOutput: []string{"01-hook: user false "}},
+
+		// Error cases
+		"Error on failing hook still runs other hooks": {hooks: map[string]string{"01-fail": "fail", "02-hook": "ok"}, wantHooksOutput: []string{"01-fail: user false ", "02-hook: user false "}, wantErr: true},
+		"Error on hook timing out":                     {hooks: map[string]string{"01-hang": "hang"}, wantHooksOutput: []string{"01-hang: user false "}, timeout: 100 * time.Millisecond, wantErr: true},
+	}
+	for name, tc := range tests {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			hooksDir := filepath.Join(t.TempDir(), "hooks.d")
+			output := filepath.Join(t.TempDir(), "output")
+			if !tc.noHooksDir {
+				require.NoError(t, os.MkdirAll(hooksDir, 0750), "Setup: can't create hooks directory")
+			}
+			for n, kind := range tc.hooks {
+				writeHook(t, filepath.Join(hooksDir, n), output, kind)
+			}
+
+			bus := testutils.NewDbusConn(t)
+			listener := testutils.NewDbusConn(t)
+			require.NoError(t, listener.AddMatchSignal(
+				dbus.WithMatchObjectPath(hooks.DbusObjectPath),
+				dbus.WithMatchInterface(hooks.DbusInterface),
+				dbus.WithMatchSender(bus.Names()[0])), "Setup: can't subscribe to signal")
+			signals := make(chan *dbus.Signal, 10)
+			listener.Signal(signals)
+
+			var opts []hooks.Option
+			if tc.timeout != 0 {
+				opts = append(opts, hooks.WithTimeout(tc.timeout))
+			}
+			n := hooks.New(bus, hooksDir, opts...)
+
+			target := "user"
+			if tc.isComputer {
+				target = "computer"
+			}
+			err := n.PolicyUpdated(context.Background(), target, tc.isComputer, tc.changedManagers)
+			if tc.wantErr {
+				require.Error(t, err, "PolicyUpdated should return an error but didn't")
+			} else {
+				require.NoError(t, err, "PolicyUpdated should not return an error")
+			}
+
+			wantManagers := tc.changedManagers
+			if wantManagers == nil {
+				wantManagers = []string{}
+			}
+			select {
+			case s := <-signals:
+				require.Equal(t, hooks.PolicyUpdatedSignal, s.Name, "Signal name should be PolicyUpdated")
+				require.Equal(t, []interface{}{target, tc.isComputer, wantManagers}, s.Body, "Signal should carry the policy update")
+			case <-time.After(5 * time.Second):
+				t.Fatal("PolicyUpdated signal should have been emitted")
+			}
+
+			var got []string
+			if d, err := os.ReadFile(output); err == nil {
+				got = strings.Split(strings.TrimSuffix(string(d), "\n"), "\n")
+			}
+			require.Equal(t, tc.wantHooksOutput, got, "Hooks should have been executed in order with the policy update")
+		})
+	}
+}
+
+func TestStateChanged(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		isComputer bool
+
+		wantInvalidated string
+	}{
+		"Invalidate users state":   {wantInvalidated: hooks.UsersProperty},
+		"Invalidate machine state": {isComputer: true, wantInvalidated: hooks.MachineProperty},
+	}
+	for name, tc := range tests {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			bus := testutils.NewDbusConn(t)
+			listener := testutils.NewDbusConn(t)
+			require.NoError(t, listener.AddMatchSignal(
+				dbus.WithMatchObjectPath(hooks.DbusObjectPath),
+				dbus.WithMatchInterface("org.freedesktop.DBus.Properties"),
+				dbus.WithMatchSender(bus.Names()[0])), "Setup: can't subscribe to signal")
+			signals := make(chan *dbus.Signal, 10)
+			listener.Signal(signals)
+
+			hooks.New(bus, t.TempDir()).StateChanged(context.Background(), tc.isComputer)
+
+			select {
+			case s := <-signals:
+				require.Equal(t, "org.freedesktop.DBus.Properties.PropertiesChanged", s.Name, "Signal name should be PropertiesChanged")
+				require.Equal(t, []interface{}{hooks.DbusInterface, map[string]dbus.Variant{}, []string{tc.wantInvalidated}}, s.Body,
+					"Signal should invalidate the update state")
+			case <-time.After(5 * time.Second):
+				t.Fatal("PropertiesChanged signal should have been emitted")
+			}
+		})
+	}
+}
+
+// writeHook creates a hook script appending its environment to output.
+func writeHook(t *testing.T, path, output, kind string) {
+	t.Helper()
+
+	script := "#!/bin/sh\n" +
+		`echo "$(basename $0): ${ADSYS_TARGET} ${ADSYS_IS_COMPUTER} ${ADSYS_CHANGED_MANAGERS}" >> ` + output + "\n"
+	perm := os.FileMode(0700)
+	switch kind {
+	case "fail":
+		script += "exit 1\n"
+	case "hang":
+		script += "exec sleep 30\n"
+	case "not-executable":
+		perm = 0600
+	}
+	require.NoError(t, os.WriteFile(path, []byte(script), perm), "Setup: can't write hook")
+}
+
+func TestMain(m *testing.M) {
+	debug := flag.Bool("verbose", false, "Print debug log level information within the test")
+	flag.Parse()
+	if *debug {
+		logrus.StandardLogger().SetLevel(logrus.DebugLevel)
+	}
+
+	defer testutils.StartLocalSystemBus()()
+
+	m.Run()
+}
@@ -0,0 +1,3 @@
+package journald
+
+const JournaldUnit = journaldUnit
@@ -0,0 +1,146 @@
+// Package journald is the policy manager bounding the disk usage and the retention of the system journal from the
+// machine policies, so that the logs needed to audit a machine are kept without filling its disk.
+//
+// The storage mode, the disk usage and file size limits, the retention time, the rate limits of the services and the
+// forwarding to syslog are set in the [Journal] section of a drop-in of journald.conf.d, which journald reads after
+// journald.conf. Sizes take the K, M, G, T, P and E suffixes of journald, and times are in seconds.
+//
+// systemd-journald is restarted to load a changed drop-in, keeping the entries already written. A restart failure is
+// only logged as a warning: the drop-in is loaded on next boot anyway.
+package journald
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+
+	log "github.com/ubuntu/adsys/internal/grpc/logstreamer"
+	"github.com/ubuntu/adsys/internal/i18n"
+	"github.com/ubuntu/adsys/internal/policies/dropin"
+	"github.com/ubuntu/adsys/internal/policies/entry"
+	"github.com/ubuntu/decorate"
+)
+
+// ConfFileName is the name of the drop-in generated in the journald.conf.d directory.
+const ConfFileName = "90-adsys.conf"
+
+// journaldUnit is the systemd unit restarted to apply the new configuration.
+const journaldUnit = "systemd-journald.service"
+
+// sizeRe matches a size in bytes, with an optional base 1024 unit suffix, as journald expects it.
+var sizeRe = regexp.MustCompile(`^[0-9]+[KMGTPE]?$`)
+
+// supportedKeys are the entry keys supported by the journald manager, with the journald option they set.
+var supportedKeys = map[string]dropin.Option{
+	"storage":              {Name: "Storage", Parse: parseStorage},
+	"system-max-use":       {Name: "SystemMaxUse", Parse: parseSize},
+	"system-keep-free":     {Name: "SystemKeepFree", Parse: parseSize},
+	"system-max-file-size": {Name: "SystemMaxFileSize", Parse: parseSize},
+	"max-retention":        {Name: "MaxRetentionSec", Parse: parseSeconds},
+	"rate-limit-interval":  {Name: "RateLimitIntervalSec", Parse: parseSeconds},
+	"rate-limit-burst":     {Name: "RateLimitBurst", Parse: dropin.ParseCount},
+	"forward-to-syslog":    {Name: "ForwardToSyslog", Parse: dropin.ParseBool("yes", "no")},
+}
+
+type systemdCaller interface {
+	RestartUnit(context.Context, string) error
+}
+
+// Manager writes the journald drop-in of the machine, one policy apply at a time.
+type Manager struct {
+	confFile      string
+	systemdCaller systemdCaller
+
+	mu sync.Mutex
+}
+
+// New returns a manager writing its drop-in in confDir, restarting journald with systemdCaller.
+func New(confDir string, systemdCaller systemdCaller) *Manager {
+	return &Manager{
+		confFile:      filepath.Join(confDir, ConfFileName),
+		systemdCaller: systemdCaller,
+	}
+}
+
+// Destinations returns the drop-in written by the manager.
+func (m *Manager) Destinations() []string {
+	return []string{m.confFile}
+}
+
+// ApplyPolicy generates the journald drop-in of the machine based on a list of entries, and restarts journald if it
+// changed.
+func (m *Manager) ApplyPolicy(ctx context.Context, objectName string, isComputer bool, entries []entry.Entry) (err error) {
+	defer decorate.OnError(&err, i18n.G("can't apply journald policy"))
+
+	// journald configuration is machine wide.
+	if !isComputer {
+		return nil
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	log.Debugf(ctx, "Applying journald policy to %s", objectName)
+
+	options, err := dropin.ParseEntries(ctx, "journald", supportedKeys, entries)
+	if err != nil {
+		return err
+	}
+
+	var changed bool
+	if len(options) == 0 {
+		changed, err = dropin.Remove(m.confFile)
+	} else {
+		content := "# This file is managed by adsys from the machine policies. Do not edit it.\n\n[Journal]\n" + dropin.Render(options, "=")
+		// #nosec G306 - journald configuration is readable by all, like journald.conf.
+		changed, err = dropin.Write(m.confFile, []byte(content), 0644)
+	}
+	if err != nil {
+		return err
+	}
+	if !changed {
+		log.Debugf(ctx, "journald configuration is already up to date")
+		return nil
+	}
+
+	m.restart(ctx)
+	return nil
+}
+
+// restart restarts journald to load the new configuration. A failure only warns, as the configuration itself is
+// applied.
+func (m *Manager) restart(ctx context.Context) {
+	if err := m.systemdCaller.RestartUnit(ctx, journaldUnit); err != nil {
+		log.Warningf(ctx, i18n.G("Failed to restart journald to apply its new configuration: %v"), err)
+	}
+}
+
+// parseStorage validates a journal storage mode.
+func parseStorage(v string) (string, error) {
+	v = strings.ToLower(v)
+	switch v {
+	case "volatile", "persistent", "auto", "none":
+		return v, nil
+	}
+	return "", errors.New(i18n.G("must be volatile, persistent, auto or none"))
+}
+
+// parseSize validates a size in bytes, with an optional K, M, G, T, P or E suffix.
+func parseSize(v string) (string, error) {
+	v = strings.ToUpper(v)
+	if !sizeRe.MatchString(v) {
+		return "", errors.New(i18n.G("must be a size in bytes, optionally followed by K, M, G, T, P or E"))
+	}
+	return v, nil
+}
+
+// parseSeconds returns a duration in seconds as a journald time span.
+func parseSeconds(v string) (string, error) {
+	if _, err := dropin.ParseCount(v); err != nil {
+		return "", errors.New(i18n.G("must be a positive number of seconds"))
+	}
+	return v + "s", nil
+}
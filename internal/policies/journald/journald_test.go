@@ -0,0 +1,152 @@
+package journald_test
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/ubuntu/adsys/internal/policies/entry"
+	"github.com/ubuntu/adsys/internal/policies/journald"
+	"github.com/ubuntu/adsys/internal/testutils"
+)
+
+func TestApplyPolicy(t *testing.T) {
+	t.Parallel()
+
+	allOptions := []entry.Entry{
+		{Key: "journald/storage", Value: "persistent"},
+		{Key: "journald/system-max-use", Value: "500M"},
+		{Key: "journald/system-keep-free", Value: "1G"},
+		{Key: "journald/system-max-file-size", Value: "50M"},
+		{Key: "journald/max-retention", Value: "2592000"},
+		{Key: "journald/rate-limit-interval", Value: "30"},
+		{Key: "journald/rate-limit-burst", Value: "10000"},
+		{Key: "journald/forward-to-syslog", Value: "true"},
+	}
+
+	tests := map[string]struct {
+		entries      []entry.Entry
+		isUser       bool
+		existingConf string
+		confDirFile  bool
+		restartError bool
+
+		wantRestart bool
+		wantErr     bool
+	}{
+		"All supported options": {entries: allOptions, wantRestart: true},
+		"Values are normalized": {
+			entries: []entry.Entry{
+				{Key: "journald/storage", Value: " Auto "},
+				{Key: "journald/system-max-use", Value: "2g"},
+				{Key: "journald/forward-to-syslog", Value: "0"},
+			},
+			wantRestart: true,
+		},
+		"Disabled entries are left to the local configuration": {
+			entries: []entry.Entry{
+				{Key: "journald/storage", Value: "persistent"},
+				{Key: "journald/system-max-use", Disabled: true},
+			},
+			wantRestart: true,
+		},
+		"Unsupported keys are ignored": {
+			entries: []entry.Entry{
+				{Key: "journald/storage", Value: "persistent"},
+				{Key: "journald/compress", Value: "yes"},
+			},
+			wantRestart: true,
+		},
+		"Outdated drop-in is replaced": {
+			entries:      []entry.Entry{{Key: "journald/storage", Value: "persistent"}},
+			existingConf: "outdated.conf",
+			wantRestart:  true,
+		},
+		"Up to date drop-in does not restart journald": {
+			entries:      []entry.Entry{{Key: "journald/storage", Value: "persistent"}},
+			existingConf: "up-to-date.conf",
+		},
+		"No entries removes existing drop-in": {existingConf: "outdated.conf", wantRestart: true},
+		"Only disabled entries removes existing drop-in": {
+			entries:      []entry.Entry{{Key: "journald/storage", Disabled: true}},
+			existingConf: "outdated.conf",
+			wantRestart:  true,
+		},
+		"No entries and no drop-in is a noop":    {},
+		"Failing to restart journald only warns": {entries: allOptions, restartError: true, wantRestart: true},
+		"User does nothing":                      {entries: allOptions, isUser: true},
+
+		// Error cases
+		"Error on invalid storage":          {entries: []entry.Entry{{Key: "journald/storage", Value: "disk"}}, wantErr: true},
+		"Error on invalid size unit":        {entries: []entry.Entry{{Key: "journald/system-max-use", Value: "500MB"}}, wantErr: true},
+		"Error on negative size":            {entries: []entry.Entry{{Key: "journald/system-max-use", Value: "-1"}}, wantErr: true},
+		"Error on non numeric interval":     {entries: []entry.Entry{{Key: "journald/rate-limit-interval", Value: "30s"}}, wantErr: true},
+		"Error on non numeric burst":        {entries: []entry.Entry{{Key: "journald/rate-limit-burst", Value: "many"}}, wantErr: true},
+		"Error on invalid boolean":          {entries: []entry.Entry{{Key: "journald/forward-to-syslog", Value: "maybe"}}, wantErr: true},
+		"Error on conf dir not a directory": {entries: allOptions, confDirFile: true, wantErr: true},
+	}
+	for name, tc := range tests {
+		tc := tc
+		name := name
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			root := t.TempDir()
+			confDir := filepath.Join(root, "journald.conf.d")
+			if tc.existingConf != "" {
+				require.NoError(t, os.MkdirAll(confDir, 0755), "Setup: can't create journald.conf.d directory")
+				testutils.Copy(t, filepath.Join("testdata", "existing-confs", tc.existingConf), filepath.Join(confDir, journald.ConfFileName))
+			}
+			if tc.confDirFile {
+				require.NoError(t, os.WriteFile(confDir, nil, 0600), "Setup: can't create journald.conf.d file")
+			}
+
+			systemd := &mockSystemdCaller{restartError: tc.restartError}
+			m := journald.New(confDir, systemd)
+			err := m.ApplyPolicy(context.Background(), "ubuntu", !tc.isUser, tc.entries)
+			if tc.wantErr {
+				require.Error(t, err, "ApplyPolicy should have failed but didn't")
+				return
+			}
+			require.NoError(t, err, "ApplyPolicy failed but shouldn't have")
+
+			require.Equal(t, tc.wantRestart, systemd.restarted, "journald should be restarted only when its configuration changed")
+
+			testutils.CompareTreesWithFiltering(t, root, testutils.GoldenPath(t), testutils.Update())
+		})
+	}
+}
+
+type mockSystemdCaller struct {
+	testutils.MockSystemdCaller
+
+	restartError bool
+	restarted    bool
+	mu           sync.Mutex
+}
+
+func (s *mockSystemdCaller) RestartUnit(_ context.Context, unit string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if unit != journald.JournaldUnit {
+		return errors.New("unexpected unit restarted")
+	}
+	s.restarted = true
+	if s.restartError {
+		return errors.New("restart error requested")
+	}
+	return nil
+}
+
+func TestMain(m *testing.M) {
+	testutils.InstallUpdateFlag()
+	flag.Parse()
+
+	m.Run()
+}
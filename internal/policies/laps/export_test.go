@@ -0,0 +1,17 @@
+package laps
+
+import "time"
+
+// WithNow overrides the current time, to have stable rotation times in tests.
+func WithNow(now func() time.Time) Option {
+	return func(o *options) {
+		o.now = now
+	}
+}
+
+// WithPasswdFile overrides the file listing the local accounts.
+func WithPasswdFile(p string) Option {
+	return func(o *options) {
+		o.passwdFile = p
+	}
+}
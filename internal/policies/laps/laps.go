@@ -0,0 +1,403 @@
+// Package laps is the policy manager rotating the password of a local administrator account of the machine, the way
+// Windows LAPS does.
+//
+// The account to manage is set by the machine policy. It must be a regular account of /etc/passwd, so that the
+// directory can't have adsys rotate the password of a system account or of an account of the directory itself.
+// Its password is replaced with a random one when it is first managed, when the account or the directory schema
+// changes, when the password is older than the maximum password age, and when the expiration time in the directory is
+// earlier than the local one, like when domain administrators request an immediate rotation. Each new password is
+// stored first in the computer object of the machine in the directory, then set on the local account, so that a
+// password is never in use without the directory knowing it. The rotation is recorded as pending before that, and
+// retried on the next apply if it is interrupted.
+//
+// The password is written in clear text to the attributes of the legacy Microsoft LAPS schema: it is not encrypted,
+// only readable by the principals the domain administrators granted access to them. The Windows LAPS schema is
+// refused, as its encrypted attribute relies on DPAPI-NG, which is not available.
+//
+// The state of the rotation, without the password, is kept in the cache directory to report it in the policy status.
+package laps
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/ubuntu/adsys/internal/grpc/logstreamer"
+	"github.com/ubuntu/adsys/internal/i18n"
+	"github.com/ubuntu/adsys/internal/policies/entry"
+	"github.com/ubuntu/adsys/internal/policies/secrets"
+	"github.com/ubuntu/adsys/internal/smbsafe"
+	"github.com/ubuntu/decorate"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	defaultPasswordLength = 20
+	defaultPasswordAge    = 30
+	maxPasswordAge        = 365
+
+	// minUID and maxUID are the bounds of the regular accounts whose password can be managed.
+	minUID = 1000
+	maxUID = 65533
+)
+
+// schemas are the attributes of the computer object storing the password and its expiration time, by directory
+// schema.
+var schemas = map[string]struct {
+	password   string
+	expiration string
+}{
+	"legacy": {password: "ms-Mcs-AdmPwd", expiration: "ms-Mcs-AdmPwdExpirationTime"},
+}
+
+// state is the last rotation of the password of the managed account.
+type state struct {
+	Account    string    `yaml:"account"`
+	Directory  string    `yaml:"directory"`
+	Rotated    time.Time `yaml:"rotated"`
+	Expiration time.Time `yaml:"expiration"`
+	// Pending is set while the new password may be stored in the directory without being set on the account.
+	Pending bool `yaml:"pending,omitempty"`
+}
+
+// policy is the local account password management set by the machine policy.
+type policy struct {
+	account        string
+	passwordLength int
+	passwordAge    time.Duration
+	schema         string
+}
+
+// Manager prevents running multiple password rotations in parallel while parsing policy in ApplyPolicy.
+type Manager struct {
+	stateFile   string
+	escrow      secrets.Escrow
+	passwdFile  string
+	chpasswdCmd []string
+	now         func() time.Time
+
+	mu sync.Mutex
+}
+
+type options struct {
+	readEscrowed secrets.ReadEscrowedFunc
+	passwdFile   string
+	chpasswdCmd  []string
+	now          func() time.Time
+}
+
+// Option reprents an optional function to change the laps manager.
+type Option func(*options)
+
+// WithReadEscrowed reads the password expiration time back from the directory with f, to rotate the password when
+// domain administrators expire it. Without it, only the local expiration time is used.
+func WithReadEscrowed(f secrets.ReadEscrowedFunc) Option {
+	return func(o *options) {
+		o.readEscrowed = f
+	}
+}
+
+// WithChpasswdCmd overrides the default chpasswd command.
+func WithChpasswdCmd(cmd []string) Option {
+	return func(o *options) {
+		o.chpasswdCmd = cmd
+	}
+}
+
+// New returns a manager keeping its state in stateDir, storing the passwords in the directory with escrow.
+func New(stateDir string, escrow secrets.EscrowFunc, opts ...Option) *Manager {
+	// defaults
+	args := options{
+		passwdFile:  "/etc/passwd",
+		chpasswdCmd: []string{"chpasswd"},
+		now:         time.Now,
+	}
+	for _, o := range opts {
+		o(&args)
+	}
+
+	// Only the attributes of the schemas can be accessed.
+	var attributes []string
+	for _, s := range schemas {
+		attributes = append(attributes, s.password, s.expiration)
+	}
+
+	return &Manager{
+		stateFile:   filepath.Join(stateDir, "state"),
+		escrow:      secrets.NewEscrow(escrow, args.readEscrowed, attributes),
+		passwdFile:  args.passwdFile,
+		chpasswdCmd: args.chpasswdCmd,
+		now:         args.now,
+	}
+}
+
+// ApplyPolicy rotates the password of the local account set by entries if it was never rotated, if it expired or if
+// its last rotation was interrupted.
+func (m *Manager) ApplyPolicy(ctx context.Context, objectName string, isComputer bool, entries []entry.Entry) (err error) {
+	defer decorate.OnError(&err, i18n.G("can't apply local password policy"))
+
+	// Local accounts are machine wide.
+	if !isComputer {
+		return nil
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	log.Debugf(ctx, "Applying local password policy to %s", objectName)
+
+	p, err := parseEntries(ctx, entries)
+	if err != nil {
+		return err
+	}
+
+	previous, err := m.loadState()
+	if err != nil {
+		return err
+	}
+
+	if p.account == "" {
+		if previous == nil {
+			return nil
+		}
+		log.Infof(ctx, "Password of local account %q is no longer managed", previous.Account)
+		return os.Remove(m.stateFile)
+	}
+
+	if err := checkLocalAccount(m.passwdFile, p.account); err != nil {
+		return err
+	}
+	if !m.escrow.CanWrite() {
+		return errors.New(i18n.G("passwords can't be stored in the directory"))
+	}
+
+	now := m.now()
+	if previous != nil && previous.Pending {
+		log.Warningf(ctx, i18n.G("Last password rotation of local account %q was interrupted: rotating it again"), previous.Account)
+	}
+	if previous != nil && !previous.Pending && previous.Account == p.account && previous.Directory == p.schema {
+		// The maximum password age may have changed since the last rotation.
+		expiration := previous.Rotated.Add(p.passwordAge)
+		if now.Before(expiration) && !m.expiredInDirectory(ctx, p.schema, *previous) {
+			if expiration.Equal(previous.Expiration) {
+				return nil
+			}
+			if err := m.escrow.Write(ctx, schemas[p.schema].expiration, fileTime(expiration)); err != nil {
+				return fmt.Errorf(i18n.G("can't update password expiration time in the directory: %w"), err)
+			}
+			previous.Expiration = expiration
+			return m.saveState(*previous)
+		}
+	}
+
+	return m.rotate(ctx, p, now)
+}
+
+// rotate sets a new random password on the local account of p, after storing it in the directory.
+func (m *Manager) rotate(ctx context.Context, p policy, now time.Time) error {
+	password, err := secrets.GeneratePassword(p.passwordLength)
+	if err != nil {
+		return err
+	}
+	expiration := now.Add(p.passwordAge)
+	rotation := state{Account: p.account, Directory: p.schema, Rotated: now, Expiration: expiration, Pending: true}
+
+	// The directory may store the new password even if the escrow fails, and setting it on the account may fail
+	// after: record the rotation first, so that it is retried until the account and the directory agree.
+	if err := m.saveState(rotation); err != nil {
+		return err
+	}
+
+	schema := schemas[p.schema]
+	if err := m.escrow.Write(ctx, schema.password, password); err != nil {
+		return fmt.Errorf(i18n.G("can't store new password of local account %q in the directory, keeping the current one: %w"), p.account, err)
+	}
+	// The password is in the directory: the expiration time is informative only.
+	if err := m.escrow.Write(ctx, schema.expiration, fileTime(expiration)); err != nil {
+		log.Warningf(ctx, i18n.G("Can't store password expiration time of local account %q in the directory: %v"), p.account, err)
+	}
+
+	// #nosec G204 - We are in control of the arguments
+	cmd := exec.CommandContext(ctx, m.chpasswdCmd[0], m.chpasswdCmd[1:]...)
+	cmd.Stdin = strings.NewReader(fmt.Sprintf("%s:%s\n", p.account, password))
+	smbsafe.WaitExec()
+	out, err := cmd.CombinedOutput()
+	smbsafe.DoneExec()
+	if err != nil {
+		return fmt.Errorf(i18n.G("can't set new password of local account %q, which may already be stored in the directory, it is rotated again on the next refresh: %w\n%s"), p.account, err, string(out))
+	}
+
+	log.Infof(ctx, "Rotated password of local account %q, expiring on %s", p.account, expiration.Format(time.RFC3339))
+	rotation.Pending = false
+	return m.saveState(rotation)
+}
+
+// expiredInDirectory returns true if the password expiration time stored in the directory is earlier than the one of
+// the last rotation s, like when domain administrators request an immediate rotation.
+// The local expiration time is used if the directory can't be read.
+func (m *Manager) expiredInDirectory(ctx context.Context, schema string, s state) bool {
+	if !m.escrow.CanRead() {
+		return false
+	}
+	v, err := m.escrow.Read(ctx, schemas[schema].expiration)
+	if err != nil {
+		log.Warningf(ctx, i18n.G("Can't read password expiration time of local account %q from the directory, using the local one: %v"), s.Account, err)
+		return false
+	}
+	if v = strings.TrimSpace(v); v == "" {
+		return false
+	}
+	expiration, err := strconv.ParseInt(v, 10, 64)
+	if err != nil {
+		log.Warningf(ctx, i18n.G("Invalid password expiration time %q of local account %q in the directory, using the local one"), v, s.Account)
+		return false
+	}
+	if expiration >= fileTimeValue(s.Expiration) {
+		return false
+	}
+	log.Infof(ctx, "Password of local account %q was expired in the directory", s.Account)
+	return true
+}
+
+// checkLocalAccount returns an error if account is not a regular account of passwdFile. Accounts of the directory,
+// resolved by NSS, and system accounts can't be managed.
+func checkLocalAccount(passwdFile, account string) (err error) {
+	defer decorate.OnError(&err, i18n.G("can't manage password of local account %q"), account)
+
+	d, err := os.ReadFile(passwdFile)
+	if err != nil {
+		return err
+	}
+	for _, l := range strings.Split(string(d), "\n") {
+		fields := strings.Split(l, ":")
+		if len(fields) < 3 || fields[0] != account {
+			continue
+		}
+		uid, err := strconv.Atoi(fields[2])
+		if err != nil {
+			return fmt.Errorf(i18n.G("invalid uid %q in %s"), fields[2], passwdFile)
+		}
+		if uid < minUID || uid > maxUID {
+			return fmt.Errorf(i18n.G("uid %d is not the one of a regular account, between %d and %d"), uid, minUID, maxUID)
+		}
+		return nil
+	}
+	return fmt.Errorf(i18n.G("account not found in %s"), passwdFile)
+}
+
+// Status returns the last rotation of the managed local account password, or an empty string if no account is
+// managed.
+func (m *Manager) Status() (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	s, err := m.loadState()
+	if err != nil || s == nil {
+		return "", err
+	}
+	if s.Pending {
+		return fmt.Sprintf(i18n.G("Password rotation of local account %s started on %s was interrupted, it will be retried\n"), s.Account,
+			s.Rotated.Format(time.RFC3339)), nil
+	}
+	return fmt.Sprintf(i18n.G("Password of local account %s rotated on %s, expires on %s\n"), s.Account,
+		s.Rotated.Format(time.RFC3339), s.Expiration.Format(time.RFC3339)), nil
+}
+
+// loadState returns the last rotation, or nil if the password of no account was rotated.
+func (m *Manager) loadState() (*state, error) {
+	d, err := os.ReadFile(m.stateFile)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var s state
+	if err := yaml.Unmarshal(d, &s); err != nil {
+		return nil, fmt.Errorf(i18n.G("invalid local password state: %w"), err)
+	}
+	return &s, nil
+}
+
+// saveState writes the last rotation s.
+func (m *Manager) saveState(s state) error {
+	d, err := yaml.Marshal(s)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(m.stateFile), 0700); err != nil {
+		return err
+	}
+	if err := os.WriteFile(m.stateFile+".new", d, 0600); err != nil {
+		return err
+	}
+	return os.Rename(m.stateFile+".new", m.stateFile)
+}
+
+// parseEntries returns the policy set by entries. Disabled entries use the default values, and a disabled account
+// stops managing its password.
+func parseEntries(ctx context.Context, entries []entry.Entry) (p policy, err error) {
+	p = policy{
+		passwordLength: defaultPasswordLength,
+		passwordAge:    defaultPasswordAge * 24 * time.Hour,
+		schema:         "legacy",
+	}
+	for _, e := range entries {
+		key := e.Key[strings.LastIndex(e.Key, "/")+1:]
+		if e.Disabled {
+			continue
+		}
+		v := strings.TrimSpace(e.Value)
+		switch key {
+		case "account":
+			p.account = v
+		case "password-length":
+			l, err := strconv.Atoi(v)
+			if err != nil {
+				return p, fmt.Errorf(i18n.G("invalid password length %q: must be a number"), e.Value)
+			}
+			p.passwordLength = l
+		case "password-age":
+			days, err := strconv.Atoi(v)
+			if err != nil || days < 1 || days > maxPasswordAge {
+				return p, fmt.Errorf(i18n.G("invalid password age %q: must be between 1 and %d days"), e.Value, maxPasswordAge)
+			}
+			p.passwordAge = time.Duration(days) * 24 * time.Hour
+		case "directory":
+			v = strings.ToLower(v)
+			if v == "windows" {
+				return p, errors.New(i18n.G("the Windows LAPS directory schema is not supported, as its passwords can't be encrypted: use the legacy schema"))
+			}
+			if _, ok := schemas[v]; !ok {
+				return p, fmt.Errorf(i18n.G("invalid directory schema %q: must be legacy"), e.Value)
+			}
+			p.schema = v
+		default:
+			log.Warningf(ctx, i18n.G("Encountered unsupported key '%s' while parsing local password entries, skipping it"), key)
+		}
+	}
+	if p.account != "" {
+		// Validate the length before rotating anything.
+		if _, err := secrets.GeneratePassword(p.passwordLength); err != nil {
+			return p, err
+		}
+	}
+	return p, nil
+}
+
+// fileTimeValue returns t as a Windows FILETIME: the number of 100 nanoseconds intervals since January 1, 1601 UTC.
+func fileTimeValue(t time.Time) int64 {
+	return t.UnixNano()/100 + 116444736000000000
+}
+
+// fileTime returns t as a Windows FILETIME, in the decimal form of Active Directory large integer attributes.
+func fileTime(t time.Time) string {
+	return strconv.FormatInt(fileTimeValue(t), 10)
+}
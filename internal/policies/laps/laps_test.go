@@ -0,0 +1,370 @@
+package laps_test
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/ubuntu/adsys/internal/policies/entry"
+	"github.com/ubuntu/adsys/internal/policies/laps"
+	"github.com/ubuntu/adsys/internal/testutils"
+)
+
+// now is the fixed time of the policy applies.
+var now = time.Date(2023, time.June, 1, 10, 0, 0, 0, time.UTC)
+
+func TestApplyPolicy(t *testing.T) {
+	t.Parallel()
+
+	account := entry.Entry{Key: "laps/account", Value: "localadmin"}
+	legacy := []string{"ms-Mcs-AdmPwd", "ms-Mcs-AdmPwdExpirationTime"}
+
+	tests := map[string]struct {
+		entries             []entry.Entry
+		isUser              bool
+		existingState       string
+		noEscrow            bool
+		escrowFailOn        string
+		directoryExpiration string
+		readEscrowedFails   bool
+		chpasswdFails       bool
+
+		wantEscrowed   []string
+		wantLength     int
+		wantRotated    bool
+		wantExpiration time.Time
+		wantErr        bool
+	}{
+		"First rotation": {
+			entries:      []entry.Entry{account},
+			wantEscrowed: legacy,
+			wantRotated:  true,
+		},
+		"Legacy directory schema": {
+			entries:      []entry.Entry{account, {Key: "laps/directory", Value: "Legacy"}},
+			wantEscrowed: legacy,
+			wantRotated:  true,
+		},
+		"Password length and age are set by policy": {
+			entries: []entry.Entry{
+				account,
+				{Key: "laps/password-length", Value: "32"},
+				{Key: "laps/password-age", Value: "7"},
+			},
+			wantEscrowed:   legacy,
+			wantLength:     32,
+			wantRotated:    true,
+			wantExpiration: now.Add(7 * 24 * time.Hour),
+		},
+		"Disabled entries use default values": {
+			entries: []entry.Entry{
+				account,
+				{Key: "laps/password-length", Disabled: true},
+				{Key: "laps/directory", Disabled: true},
+			},
+			wantEscrowed: legacy,
+			wantRotated:  true,
+		},
+		"Unsupported keys are ignored": {
+			entries:      []entry.Entry{account, {Key: "laps/encryption", Value: "true"}},
+			wantEscrowed: legacy,
+			wantRotated:  true,
+		},
+		"Recent password is not rotated": {entries: []entry.Entry{account}, existingState: "recent"},
+		"Recent password with the same expiration in the directory is not rotated": {
+			entries:             []entry.Entry{account},
+			existingState:       "recent",
+			directoryExpiration: fmt.Sprint(fileTime(time.Date(2023, time.June, 19, 10, 0, 0, 0, time.UTC))),
+		},
+		"Recent password with a later expiration in the directory is not rotated": {
+			entries:             []entry.Entry{account},
+			existingState:       "recent",
+			directoryExpiration: fmt.Sprint(fileTime(time.Date(2023, time.July, 19, 10, 0, 0, 0, time.UTC))),
+		},
+		"Recent password is not rotated when the directory can't be read": {
+			entries:           []entry.Entry{account},
+			existingState:     "recent",
+			readEscrowedFails: true,
+		},
+		"Recent password is not rotated when the expiration in the directory is invalid": {
+			entries:             []entry.Entry{account},
+			existingState:       "recent",
+			directoryExpiration: "soon",
+		},
+		"Password expired in the directory is rotated": {
+			entries:             []entry.Entry{account},
+			existingState:       "recent",
+			directoryExpiration: "0",
+			wantEscrowed:        legacy,
+			wantRotated:         true,
+		},
+		"Expired password is rotated": {
+			entries:       []entry.Entry{account},
+			existingState: "expired",
+			wantEscrowed:  legacy,
+			wantRotated:   true,
+		},
+		"Interrupted rotation is retried": {
+			entries:       []entry.Entry{account},
+			existingState: "pending",
+			wantEscrowed:  legacy,
+			wantRotated:   true,
+		},
+		"Password of a new account is rotated": {
+			entries:       []entry.Entry{account},
+			existingState: "other-account",
+			wantEscrowed:  legacy,
+			wantRotated:   true,
+		},
+		"Password stored with another directory schema is rotated": {
+			entries:       []entry.Entry{account},
+			existingState: "windows-schema",
+			wantEscrowed:  legacy,
+			wantRotated:   true,
+		},
+		"Password is rotated when shorter password age expires it": {
+			entries:        []entry.Entry{account, {Key: "laps/password-age", Value: "7"}},
+			existingState:  "recent",
+			wantEscrowed:   legacy,
+			wantRotated:    true,
+			wantExpiration: now.Add(7 * 24 * time.Hour),
+		},
+		"Changed password age updates expiration time": {
+			entries:       []entry.Entry{account, {Key: "laps/password-age", Value: "20"}},
+			existingState: "recent",
+			wantEscrowed:  []string{"ms-Mcs-AdmPwdExpirationTime"},
+		},
+		"Failing to store expiration time only warns": {
+			entries:      []entry.Entry{account},
+			escrowFailOn: "ms-Mcs-AdmPwdExpirationTime",
+			wantEscrowed: []string{"ms-Mcs-AdmPwd"},
+			wantRotated:  true,
+		},
+		"Disabled account stops managing its password": {
+			entries:       []entry.Entry{{Key: "laps/account", Disabled: true}},
+			existingState: "recent",
+		},
+		"No entries and no state is a noop": {},
+		"User does nothing":                 {entries: []entry.Entry{account}, isUser: true},
+
+		// Error cases
+		"Error on unknown account":                          {entries: []entry.Entry{{Key: "laps/account", Value: "adsys-unknown-account"}}, wantErr: true},
+		"Error on system account":                           {entries: []entry.Entry{{Key: "laps/account", Value: "root"}}, wantErr: true},
+		"Error on nobody account":                           {entries: []entry.Entry{{Key: "laps/account", Value: "nobody"}}, wantErr: true},
+		"Error on account with invalid uid":                 {entries: []entry.Entry{{Key: "laps/account", Value: "baduid"}}, wantErr: true},
+		"Error on non numeric password length":              {entries: []entry.Entry{account, {Key: "laps/password-length", Value: "long"}}, wantErr: true},
+		"Error on too short password":                       {entries: []entry.Entry{account, {Key: "laps/password-length", Value: "4"}}, wantErr: true},
+		"Error on invalid password age":                     {entries: []entry.Entry{account, {Key: "laps/password-age", Value: "0"}}, wantErr: true},
+		"Error on Windows LAPS directory schema":            {entries: []entry.Entry{account, {Key: "laps/directory", Value: "windows"}}, wantErr: true},
+		"Error on invalid directory schema":                 {entries: []entry.Entry{account, {Key: "laps/directory", Value: "openldap"}}, wantErr: true},
+		"Error on invalid state":                            {entries: []entry.Entry{account}, existingState: "invalid", wantErr: true},
+		"Error when passwords can't be escrowed":            {entries: []entry.Entry{account}, noEscrow: true, wantErr: true},
+		"Error when chpasswd fails after escrow is retried": {entries: []entry.Entry{account}, chpasswdFails: true, wantEscrowed: legacy, wantErr: true},
+		"Error when password can't be stored keeps current one and is retried": {
+			entries:       []entry.Entry{account},
+			existingState: "expired",
+			escrowFailOn:  "ms-Mcs-AdmPwd",
+			wantErr:       true,
+		},
+		"Error when expiration time can't be updated": {
+			entries:       []entry.Entry{account, {Key: "laps/password-age", Value: "20"}},
+			existingState: "recent",
+			escrowFailOn:  "ms-Mcs-AdmPwdExpirationTime",
+			wantErr:       true,
+		},
+	}
+	for name, tc := range tests {
+		tc := tc
+		name := name
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			root := t.TempDir()
+			stateDir := filepath.Join(root, "laps")
+			if tc.existingState != "" {
+				require.NoError(t, os.MkdirAll(stateDir, 0700), "Setup: can't create state directory")
+				testutils.Copy(t, filepath.Join("testdata", "states", tc.existingState), filepath.Join(stateDir, "state"))
+			}
+
+			chpasswdInput := filepath.Join(t.TempDir(), "chpasswd")
+			escrow := &mockEscrow{
+				failOn:    tc.escrowFailOn,
+				readFails: tc.readEscrowedFails,
+				escrowed:  make(map[string]string),
+				directory: map[string]string{"ms-Mcs-AdmPwdExpirationTime": tc.directoryExpiration},
+			}
+			escrowFunc := escrow.escrow
+			if tc.noEscrow {
+				escrowFunc = nil
+			}
+
+			m := laps.New(stateDir, escrowFunc,
+				laps.WithReadEscrowed(escrow.read),
+				laps.WithPasswdFile(filepath.Join("testdata", "passwd")),
+				laps.WithChpasswdCmd(mockChpasswdCmd(t, chpasswdInput, tc.chpasswdFails)),
+				laps.WithNow(func() time.Time { return now }))
+			err := m.ApplyPolicy(context.Background(), "ubuntu", !tc.isUser, tc.entries)
+
+			var escrowed []string
+			for attribute := range escrow.escrowed {
+				escrowed = append(escrowed, attribute)
+			}
+			sort.Strings(escrowed)
+			require.Equal(t, tc.wantEscrowed, escrowed, "ApplyPolicy should have stored the expected attributes in the directory")
+
+			if tc.wantErr {
+				require.Error(t, err, "ApplyPolicy should have failed but didn't")
+				// Interrupted rotations are recorded as pending.
+				testutils.CompareTreesWithFiltering(t, root, testutils.GoldenPath(t), testutils.Update())
+				return
+			}
+			require.NoError(t, err, "ApplyPolicy failed but shouldn't have")
+
+			if !tc.wantRotated {
+				require.NoFileExists(t, chpasswdInput, "chpasswd should not have been called")
+				testutils.CompareTreesWithFiltering(t, root, testutils.GoldenPath(t), testutils.Update())
+				return
+			}
+
+			input, err := os.ReadFile(chpasswdInput)
+			require.NoError(t, err, "chpasswd should have been called")
+			password, ok := strings.CutPrefix(strings.TrimSuffix(string(input), "\n"), "localadmin:")
+			require.True(t, ok, "chpasswd should set the password of the managed account, got %q", input)
+			if tc.wantLength == 0 {
+				tc.wantLength = 20
+			}
+			require.Len(t, password, tc.wantLength, "Password should have the expected length")
+			require.Equal(t, password, escrow.escrowed["ms-Mcs-AdmPwd"], "Password should be stored as is")
+
+			if tc.wantExpiration.IsZero() {
+				tc.wantExpiration = now.Add(30 * 24 * time.Hour)
+			}
+			if v, ok := escrow.escrowed["ms-Mcs-AdmPwdExpirationTime"]; ok {
+				require.Equal(t, strconv.FormatInt(fileTime(tc.wantExpiration), 10), v, "Expiration time should be stored as a FILETIME")
+			}
+
+			testutils.CompareTreesWithFiltering(t, root, testutils.GoldenPath(t), testutils.Update())
+		})
+	}
+}
+
+func TestStatus(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		existingState string
+
+		want    string
+		wantErr bool
+	}{
+		"Last rotation":        {existingState: "recent", want: "Password of local account localadmin rotated on 2023-05-20T10:00:00Z, expires on 2023-06-19T10:00:00Z\n"},
+		"Interrupted rotation": {existingState: "pending", want: "Password rotation of local account localadmin started on 2023-05-31T10:00:00Z was interrupted, it will be retried\n"},
+		"No managed account":   {},
+
+		"Error on invalid state": {existingState: "invalid", wantErr: true},
+	}
+	for name, tc := range tests {
+		tc := tc
+		name := name
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			stateDir := t.TempDir()
+			if tc.existingState != "" {
+				testutils.Copy(t, filepath.Join("testdata", "states", tc.existingState), filepath.Join(stateDir, "state"))
+			}
+
+			m := laps.New(stateDir, nil)
+			got, err := m.Status()
+			if tc.wantErr {
+				require.Error(t, err, "Status should have failed but didn't")
+				return
+			}
+			require.NoError(t, err, "Status failed but shouldn't have")
+			require.Equal(t, tc.want, got, "Status should report the last rotation")
+		})
+	}
+}
+
+// fileTime returns t as a Windows FILETIME.
+func fileTime(t time.Time) int64 {
+	return t.UnixNano()/100 + 116444736000000000
+}
+
+type mockEscrow struct {
+	failOn    string
+	readFails bool
+	escrowed  map[string]string
+	directory map[string]string
+	mu        sync.Mutex
+}
+
+func (e *mockEscrow) escrow(_ context.Context, attribute, value string) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if attribute == e.failOn {
+		return errors.New("escrow error requested")
+	}
+	e.escrowed[attribute] = value
+	return nil
+}
+
+func (e *mockEscrow) read(_ context.Context, attribute string) (string, error) {
+	if e.readFails {
+		return "", errors.New("read error requested")
+	}
+	return e.directory[attribute], nil
+}
+
+func mockChpasswdCmd(t *testing.T, inputFile string, fail bool) []string {
+	t.Helper()
+
+	return []string{"env", "GO_WANT_HELPER_PROCESS=1", os.Args[0], "-test.run=TestMockChpasswd", "--", inputFile, strconv.FormatBool(fail)}
+}
+
+func TestMockChpasswd(t *testing.T) {
+	if os.Getenv("GO_WANT_HELPER_PROCESS") != "1" {
+		return
+	}
+	defer os.Exit(0)
+
+	args := os.Args
+	for len(args) > 0 && args[0] != "--" {
+		args = args[1:]
+	}
+	inputFile, fail := args[1], args[2]
+
+	input, err := io.ReadAll(os.Stdin)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "can't read input: %v", err)
+		os.Exit(2)
+	}
+	if err := os.WriteFile(inputFile, input, 0600); err != nil {
+		fmt.Fprintf(os.Stderr, "can't write input file: %v", err)
+		os.Exit(2)
+	}
+
+	if fail == "true" {
+		fmt.Fprint(os.Stderr, "chpasswd failure requested")
+		os.Exit(1)
+	}
+}
+
+func TestMain(m *testing.M) {
+	testutils.InstallUpdateFlag()
+	flag.Parse()
+
+	m.Run()
+}
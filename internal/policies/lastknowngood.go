@@ -0,0 +1,154 @@
+package policies
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"reflect"
+
+	log "github.com/ubuntu/adsys/internal/grpc/logstreamer"
+	"github.com/ubuntu/adsys/internal/i18n"
+	"github.com/ubuntu/adsys/internal/policies/entry"
+	"github.com/ubuntu/decorate"
+	"golang.org/x/exp/maps"
+	"golang.org/x/exp/slices"
+	"gopkg.in/yaml.v3"
+)
+
+// lastKnownGoodCacheBaseName is the cache directory where the entries each policy manager last applied successfully
+// to each object are stored.
+const lastKnownGoodCacheBaseName = "last-known-good"
+
+// noLastKnownGoodManagers are the policy managers which never re-activate their last known good policy, as the assets
+// it relies on are not kept once the GPOs change.
+var noLastKnownGoodManagers = []string{"scripts", "apparmor"}
+
+// lastKnownGood are the rules, with unresolved secrets, each policy manager last applied successfully.
+type lastKnownGood map[string]map[string][]entry.Entry
+
+// managerRules returns the rules of rules handled by manager. Rules keys without entries are omitted, so that the
+// rules of a manager can be compared between applies.
+func managerRules(manager string, rules map[string][]entry.Entry) map[string][]entry.Entry {
+	r := make(map[string][]entry.Entry)
+	for key, entries := range rules {
+		if len(entries) == 0 || managerForRulesKey(key) != manager {
+			continue
+		}
+		r[key] = entries
+	}
+	return r
+}
+
+// update records r, the rules manager applied, as its last known good policy. It returns true if it changed.
+func (lkg lastKnownGood) update(manager string, r map[string][]entry.Entry) bool {
+	if previous, ok := lkg[manager]; ok && reflect.DeepEqual(previous, r) {
+		return false
+	}
+	lkg[manager] = r
+	return true
+}
+
+// applyLastKnownGood applies again with their last known good policy the policy managers of appliers which failed
+// lastKnownGoodAfter times in a row for objectName, so that the object degrades to its previous policy instead of a
+// broken or partial one. The policy managers which succeeded record the rules they applied, from appliedRules, as
+// their last known good policy.
+// The status of the policy managers which re-activated their last known good policy stays failed, as the latest
+// policy is not applied.
+func (m *Manager) applyLastKnownGood(ctx context.Context, objectName string, status *applyStatus, appliers map[string]func(map[string][]entry.Entry) error, appliedRules map[string]map[string][]entry.Entry, resolved map[string][]entry.Entry) (err error) {
+	defer decorate.OnError(&err, i18n.G("can't apply last known good policies"))
+
+	lkg, err := m.loadLastKnownGood(objectName)
+	if err != nil {
+		return err
+	}
+
+	var changed bool
+	// The machine dconf database is re-activated before the gdm policy relying on it.
+	for _, manager := range statusManagersOrder {
+		apply, ok := appliers[manager]
+		if !ok || slices.Contains(noLastKnownGoodManagers, manager) {
+			continue
+		}
+		if !status.failed(manager) {
+			if lkg.update(manager, appliedRules[manager]) {
+				changed = true
+			}
+			continue
+		}
+
+		failures := status.failures(manager)
+		if failures < m.lastKnownGoodAfter {
+			continue
+		}
+		previous, ok := lkg[manager]
+		if !ok {
+			log.Warningf(ctx, i18n.G("Policy manager %s failed %d times in a row for %s, without a last known good policy to re-activate"), manager, failures, objectName)
+			continue
+		}
+		resolvedPrevious, secretErrs := m.secrets.Resolve(ctx, previous)
+		if len(secretErrs) > 0 {
+			log.Warningf(ctx, i18n.G("Can't re-activate last known good policy of %s for %s: %v"), manager, objectName, errors.Join(maps.Values(secretErrs)...))
+			continue
+		}
+		r := make(map[string][]entry.Entry)
+		for key, entries := range resolved {
+			if managerForRulesKey(key) != manager {
+				r[key] = entries
+			}
+		}
+		for key, entries := range resolvedPrevious {
+			r[key] = entries
+		}
+		if err := apply(r); err != nil {
+			log.Warningf(ctx, i18n.G("Can't re-activate last known good policy of %s for %s: %v"), manager, objectName, err)
+			continue
+		}
+		log.Warningf(ctx, i18n.G("Policy manager %s failed %d times in a row for %s: its last known good policy is re-activated"), manager, failures, objectName)
+		status.revert(manager)
+	}
+
+	if !changed {
+		return nil
+	}
+	return m.saveLastKnownGood(objectName, lkg)
+}
+
+// loadLastKnownGood returns the last known good policy of each policy manager for objectName. It is empty if none was
+// recorded yet.
+func (m *Manager) loadLastKnownGood(objectName string) (lastKnownGood, error) {
+	lkg := make(lastKnownGood)
+	d, err := os.ReadFile(m.objectPath(lastKnownGoodCacheBaseName, objectName))
+	if errors.Is(err, fs.ErrNotExist) {
+		return lkg, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if err := yaml.Unmarshal(d, &lkg); err != nil {
+		return nil, fmt.Errorf(i18n.G("invalid last known good policy: %w"), err)
+	}
+	for manager, rules := range lkg {
+		if rules == nil {
+			lkg[manager] = make(map[string][]entry.Entry)
+		}
+	}
+	return lkg, nil
+}
+
+// saveLastKnownGood records lkg as the last known good policy of each policy manager for objectName.
+func (m *Manager) saveLastKnownGood(objectName string, lkg lastKnownGood) error {
+	d, err := yaml.Marshal(lkg)
+	if err != nil {
+		return err
+	}
+	p := m.objectPath(lastKnownGoodCacheBaseName, objectName)
+	if err := os.MkdirAll(filepath.Dir(p), 0700); err != nil {
+		return err
+	}
+	if err := os.WriteFile(p+".new", d, 0600); err != nil {
+		return err
+	}
+	return os.Rename(p+".new", p)
+}
@@ -0,0 +1,246 @@
+package policies
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/ubuntu/adsys/internal/grpc/logstreamer"
+	"github.com/ubuntu/adsys/internal/i18n"
+	"github.com/ubuntu/adsys/internal/policies/entry"
+	"github.com/ubuntu/decorate"
+	"golang.org/x/exp/slices"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	// sessionRulesKey is the type of the user policy keys setting how the sessions opened before a change of the
+	// policies only applying to new sessions are closed.
+	sessionRulesKey = "session"
+
+	// pendingLogoutCacheBaseName is the cache directory recording, for each user, that the sessions opened before a
+	// change of their policies are to be closed. It is only writable by root, so that users can't escape the closing.
+	pendingLogoutCacheBaseName = "pending-logout"
+
+	maxGracePeriod = 720
+	maxDeferrals   = 10
+)
+
+// newSessionManagers are the policy managers whose user policies only apply to the sessions opened after them, like
+// the environment variables set at login.
+var newSessionManagers = []string{"environment", "mount", "apparmor"}
+
+// userSessions lists and closes the sessions of users.
+type userSessions interface {
+	SessionsStartedBefore(ctx context.Context, uid uint32, t time.Time) ([]string, error)
+	TerminateSession(ctx context.Context, id string) error
+}
+
+// pendingLogout is the closing of the sessions of a user opened before a change of their policies.
+type pendingLogout struct {
+	// Changed is the time of the last change: the sessions opened since then already use the new policies.
+	Changed  time.Time `yaml:"changed"`
+	Deadline time.Time `yaml:"deadline"`
+	// GracePeriod is the number of hours each deferral postpones the deadline by.
+	GracePeriod  int `yaml:"grace-period"`
+	Deferrals    int `yaml:"deferrals"`
+	MaxDeferrals int `yaml:"max-deferrals"`
+}
+
+// logoutPolicy returns the grace period in hours and the maximum number of deferrals set by the session entries.
+// A zero grace period means that the sessions are never closed.
+func logoutPolicy(entries []entry.Entry) (gracePeriod, deferrals int, err error) {
+	for _, e := range entries {
+		if e.Disabled {
+			continue
+		}
+		v := strings.TrimSpace(e.Value)
+		switch e.Key[strings.LastIndex(e.Key, "/")+1:] {
+		case "grace-period":
+			gracePeriod, err = strconv.Atoi(v)
+			if err != nil || gracePeriod < 1 || gracePeriod > maxGracePeriod {
+				return 0, 0, fmt.Errorf(i18n.G("invalid grace period %q: must be between 1 and %d hours"), e.Value, maxGracePeriod)
+			}
+		case "max-deferrals":
+			deferrals, err = strconv.Atoi(v)
+			if err != nil || deferrals < 0 || deferrals > maxDeferrals {
+				return 0, 0, fmt.Errorf(i18n.G("invalid maximum number of deferrals %q: must be between 0 and %d"), e.Value, maxDeferrals)
+			}
+		}
+	}
+	return gracePeriod, deferrals, nil
+}
+
+// closeOutdatedSessions plans the closing of the sessions of objectName opened before rules changed the policies only
+// applying to new sessions, compared to previousRules, and notifies the user. The sessions are closed by the first
+// apply after the grace period, unless the user logged out meanwhile.
+// The closing is only planned if changed is true, as the session opening on login starts with the new policies.
+func (m *Manager) closeOutdatedSessions(ctx context.Context, objectName string, changed bool, previousRules, rules map[string][]entry.Entry) (err error) {
+	defer decorate.OnError(&err, i18n.G("can't close sessions of %s opened before their policies changed"), objectName)
+
+	u, err := user.Lookup(objectName)
+	if err != nil {
+		// Users who can't be resolved have no session to close.
+		log.Debugf(ctx, "Not closing sessions of %s: %v", objectName, err)
+		return nil
+	}
+	uid, err := strconv.ParseUint(u.Uid, 10, 32)
+	if err != nil {
+		return fmt.Errorf(i18n.G("invalid uid %q: %w"), u.Uid, err)
+	}
+	path := m.objectPath(pendingLogoutCacheBaseName, objectName)
+
+	gracePeriod, maxDeferrals, err := logoutPolicy(rules[sessionRulesKey])
+	if err != nil {
+		return err
+	}
+	if gracePeriod == 0 {
+		// The new policies apply on the next login.
+		if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+		return nil
+	}
+
+	pending, err := loadPendingLogout(path)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	var planned bool
+	if changed && previousRules != nil {
+		var managers []string
+		for _, manager := range changedManagers(previousRules, rules) {
+			if slices.Contains(newSessionManagers, manager) {
+				managers = append(managers, manager)
+			}
+		}
+		if len(managers) > 0 {
+			if pending == nil {
+				pending = &pendingLogout{Deadline: now.Add(time.Duration(gracePeriod) * time.Hour)}
+				planned = true
+			}
+			pending.Changed = now
+			log.Infof(ctx, "Policies of %s only applying to new sessions changed: %s", objectName, strings.Join(managers, ", "))
+		}
+	}
+	if pending == nil {
+		return nil
+	}
+	pending.GracePeriod, pending.MaxDeferrals = gracePeriod, maxDeferrals
+
+	outdated, err := m.userSessions.SessionsStartedBefore(ctx, uint32(uid), pending.Changed)
+	if err != nil {
+		return err
+	}
+	if len(outdated) == 0 {
+		log.Infof(ctx, "No session of %s was opened before their policies changed", objectName)
+		return os.Remove(path)
+	}
+
+	if now.Before(pending.Deadline) {
+		if err := savePendingLogout(path, *pending); err != nil {
+			return err
+		}
+		if !planned {
+			return nil
+		}
+		log.Infof(ctx, "Closing sessions of %s on %s", objectName, pending.Deadline.Format(time.RFC3339))
+		lines := []string{
+			i18n.G("Some of your new settings only apply when you log in again."),
+			fmt.Sprintf(i18n.G("Your session will be closed on %s."), pending.Deadline.Format(time.RFC1123)),
+		}
+		if maxDeferrals > 0 {
+			lines = append(lines, fmt.Sprintf(i18n.G("You can postpone it %d times with: adsysctl policy defer"), maxDeferrals))
+		}
+		return m.writeNotification(objectName, i18n.G("Log out to use your new settings"), lines)
+	}
+
+	log.Warningf(ctx, i18n.G("Closing %d sessions of %s opened before their policies changed: the grace period expired"), len(outdated), objectName)
+	var errs []error
+	for _, id := range outdated {
+		errs = append(errs, m.userSessions.TerminateSession(ctx, id))
+	}
+	if err := errors.Join(errs...); err != nil {
+		return err
+	}
+	return os.Remove(path)
+}
+
+// DeferLogout postpones by the grace period the closing of the sessions of objectName opened before their policies
+// changed, if they did not defer it more than allowed by their policies yet.
+func (m *Manager) DeferLogout(ctx context.Context, objectName string) (msg string, err error) {
+	defer decorate.OnError(&err, i18n.G("can't defer closing sessions of %s"), objectName)
+
+	log.Infof(ctx, "Deferring closing sessions of %s", objectName)
+
+	path := m.objectPath(pendingLogoutCacheBaseName, objectName)
+
+	// Prevent an apply from closing the sessions meanwhile.
+	m.muMu.Lock()
+	if _, ok := m.objectMu[objectName]; !ok {
+		m.objectMu[objectName] = &sync.Mutex{}
+	}
+	m.objectMu[objectName].Lock()
+	defer m.objectMu[objectName].Unlock()
+	m.muMu.Unlock()
+
+	pending, err := loadPendingLogout(path)
+	if err != nil {
+		return "", err
+	}
+	if pending == nil {
+		return "", errors.New(i18n.G("no session is to be closed"))
+	}
+	if pending.Deferrals >= pending.MaxDeferrals {
+		return "", fmt.Errorf(i18n.G("closing the sessions can't be deferred anymore: your sessions will be closed on %s"), pending.Deadline.Format(time.RFC1123))
+	}
+
+	pending.Deferrals++
+	pending.Deadline = pending.Deadline.Add(time.Duration(pending.GracePeriod) * time.Hour)
+	if err := savePendingLogout(path, *pending); err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf(i18n.G("Your sessions will be closed on %s, %d deferrals left.\n"),
+		pending.Deadline.Format(time.RFC1123), pending.MaxDeferrals-pending.Deferrals), nil
+}
+
+// loadPendingLogout returns the closing of sessions recorded in path, or nil if none is pending.
+func loadPendingLogout(path string) (*pendingLogout, error) {
+	d, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var p pendingLogout
+	if err := yaml.Unmarshal(d, &p); err != nil {
+		return nil, fmt.Errorf(i18n.G("invalid pending closing of sessions: %w"), err)
+	}
+	return &p, nil
+}
+
+// savePendingLogout records the closing of sessions p in path.
+func savePendingLogout(path string, p pendingLogout) error {
+	d, err := yaml.Marshal(p)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	if err := os.WriteFile(path+".new", d, 0600); err != nil {
+		return err
+	}
+	return os.Rename(path+".new", path)
+}
@@ -25,39 +25,119 @@ package policies
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"io/fs"
+	"net"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"sync"
+	"text/tabwriter"
 	"time"
 
+	"github.com/coreos/go-systemd/v22/daemon"
 	"github.com/godbus/dbus/v5"
 	"github.com/ubuntu/adsys/internal/consts"
+	"github.com/ubuntu/adsys/internal/detachedctx"
+	"github.com/ubuntu/adsys/internal/diskspace"
 	log "github.com/ubuntu/adsys/internal/grpc/logstreamer"
 	"github.com/ubuntu/adsys/internal/i18n"
+	"github.com/ubuntu/adsys/internal/logind"
 	"github.com/ubuntu/adsys/internal/policies/apparmor"
 	"github.com/ubuntu/adsys/internal/policies/dconf"
 	"github.com/ubuntu/adsys/internal/policies/entry"
+	"github.com/ubuntu/adsys/internal/policies/environment"
 	"github.com/ubuntu/adsys/internal/policies/gdm"
+	"github.com/ubuntu/adsys/internal/policies/gpp"
+	"github.com/ubuntu/adsys/internal/policies/hooks"
+	"github.com/ubuntu/adsys/internal/policies/journald"
+	"github.com/ubuntu/adsys/internal/policies/laps"
 	"github.com/ubuntu/adsys/internal/policies/mount"
+	"github.com/ubuntu/adsys/internal/policies/netplan"
+	"github.com/ubuntu/adsys/internal/policies/plugins"
 	"github.com/ubuntu/adsys/internal/policies/privilege"
 	"github.com/ubuntu/adsys/internal/policies/proxy"
 	"github.com/ubuntu/adsys/internal/policies/scripts"
+	"github.com/ubuntu/adsys/internal/policies/secrets"
+	"github.com/ubuntu/adsys/internal/policies/sssd"
+	"github.com/ubuntu/adsys/internal/policies/transform"
 	"github.com/ubuntu/adsys/internal/systemd"
 	"github.com/ubuntu/decorate"
+	"golang.org/x/exp/maps"
 	"golang.org/x/exp/slices"
-	"golang.org/x/sync/errgroup"
+	"gopkg.in/yaml.v3"
 )
 
 // ProOnlyRules are the rules that are only available for Pro subscribers. They
 // will be filtered otherwise.
-var ProOnlyRules = []string{"privilege", "scripts", "mount", "apparmor", "proxy"}
+var ProOnlyRules = []string{"privilege", "scripts", "mount", "apparmor", "proxy", "gpp", "environment", "sssd", "netplan", "journald", "laps"}
+
+// builtinRules are the rules handled by adsys policy managers. They can't be handled by plugins.
+var builtinRules = []string{"dconf", "dconf-preferences", "privilege", "scripts", "mount", "gdm", "apparmor", "proxy", "gpp", "environment", "sssd", "netplan", "journald", "laps", "session"}
+
+// inflightCacheBaseName is the cache directory where objects with a policy apply in progress are checkpointed.
+const inflightCacheBaseName = "inflight"
+
+// statusCacheBaseName is the cache directory where the status of the last policy apply of each object is stored.
+const statusCacheBaseName = "status"
+
+const (
+	// lapsCacheBaseName is the cache directory of the rotation state of the local administrator password.
+	lapsCacheBaseName = "laps"
+	// generatedCacheBaseName is the cache directory of the values generated for the policies.
+	generatedCacheBaseName = "generated"
+	// netplanCacheBaseName is the cache directory of the network configuration to revert to while a new one is applied.
+	netplanCacheBaseName = "netplan"
+)
+
+// machineIdentityManagers are the policy managers whose policies are specific to each machine, like the password of
+// its local administrator. They are not applied when provisioning an image cloned into many machines.
+var machineIdentityManagers = []string{"laps"}
 
 // Manager handles all managers for various policy handlers.
 type Manager struct {
-	policiesCacheDir string
-	hostname         string
+	cacheDir        string
+	sysvolCacheDir  string
+	policyReadyFlag string
+	transformsDir   string
+	runDir          string
+	hostname        string
+
+	// ownedRoots are the directories policy managers write files to, by name.
+	ownedRoots map[string]string
+	// destinationDirs are where the policy managers and the cache write when applying policies.
+	destinationDirs  []string
+	minFreeDiskSpace uint64
+	// domainCacheQuota is the maximum size in bytes of the cache of each domain. 0 is unlimited.
+	domainCacheQuota int64
+	// precedence is the precedence strategy of the entries of each policy manager, LSDOU if not set.
+	precedence map[string]string
+
+	// disabledManagers are the policy managers not supported on this system, which are skipped.
+	disabledManagers map[string]struct{}
+	// userNotifications notifies users of the restrictions newly enforced by a refresh.
+	userNotifications bool
+	// lastKnownGoodAfter is the number of consecutive failures after which a policy manager re-activates its last
+	// known good policy. 0 never re-activates it.
+	lastKnownGoodAfter int
+	// hooks notifies other software of the policies applied.
+	hooks *hooks.Notifier
+	// unitStatus reports the result of the last refreshes in the daemon unit status.
+	unitStatus *unitStatus
+	// metricsFile is where the metrics of the last apply of each object are written. Empty disables them.
+	metricsFile string
+	// metricsMu prevents writing the metrics concurrently.
+	metricsMu *sync.Mutex
+	// interfaceAddrs returns the addresses of the machine, to evaluate the subnets conditions of entries.
+	interfaceAddrs func() ([]net.Addr, error)
+	// secrets resolves the secrets referenced by the entries when applying them.
+	secrets secrets.Resolvers
+	// unhandled stores the entries handled by no policy manager. It is nil if they are not stored.
+	unhandled *unhandledEntries
 
 	dconf     *dconf.Manager
 	privilege *privilege.Manager
@@ -66,6 +146,21 @@ type Manager struct {
 	gdm       *gdm.Manager
 	apparmor  *apparmor.Manager
 	proxy     *proxy.Manager
+	gpp       *gpp.Manager
+	env       *environment.Manager
+	sssd      *sssd.Manager
+	netplan   *netplan.Manager
+	journald  *journald.Manager
+	laps      *laps.Manager
+	plugins   *plugins.Manager
+
+	userSessions userSessions
+
+	// systemUnitDir is where the expiry timer unit is written, started by systemdCaller.
+	systemUnitDir string
+	systemdCaller systemdCaller
+	// expiryMu prevents scheduling the expiry timer concurrently.
+	expiryMu *sync.Mutex
 
 	subscriptionDbus dbus.BusObject
 
@@ -73,12 +168,17 @@ type Manager struct {
 	muMu *sync.Mutex
 	// objectMu prevents applying multiple policies concurrently for the same object.
 	objectMu map[string]*sync.Mutex
+
+	// applies tracks the policy applies in progress.
+	applies *sync.WaitGroup
 }
 
 // systemdCaller is the interface to interact with systemd.
 type systemdCaller interface {
 	StartUnit(context.Context, string) error
 	StopUnit(context.Context, string) error
+	RestartUnit(context.Context, string) error
+	StartTransientScope(context.Context, string, []uint32, time.Duration) error
 
 	EnableUnit(context.Context, string) error
 	DisableUnit(context.Context, string) error
@@ -95,11 +195,37 @@ type options struct {
 	apparmorDir   string
 	apparmorFsDir string
 	systemUnitDir string
+	mountsDir     string
+	gppRootDir    string
+	pluginsDir    string
+	hooksDir      string
+	metricsFile   string
+	transformsDir string
+	dconfShards   bool
+	schemasDir    string
+	sssdConf      string
+	netplanDir    string
+	netplanCmd    []string
+	journaldDir   string
+	serverURL     func(context.Context) (string, error)
 	proxyApplier  proxy.Caller
 	systemdCaller systemdCaller
+	userSessions  userSessions
+	sdNotifier    sdNotifier
 	gdm           *gdm.Manager
 
-	apparmorParserCmd []string
+	apparmorParserCmd  []string
+	minFreeDiskSpace   uint64
+	domainCacheQuota   int64
+	precedence         map[string]string
+	interfaceAddrs     func() ([]net.Addr, error)
+	disabledManagers   []string
+	userNotifications  bool
+	unhandledEntries   bool
+	lastKnownGoodAfter int
+	secretsOptions     []secrets.Option
+	escrow             secrets.EscrowFunc
+	readEscrowed       secrets.ReadEscrowedFunc
 }
 
 // Option reprents an optional function to change Policies behavior.
@@ -178,6 +304,112 @@ func WithSystemUnitDir(p string) Option {
 	}
 }
 
+// WithMountsDir specifies a personalized directory under which system shared locations are mounted.
+func WithMountsDir(p string) Option {
+	return func(o *options) error {
+		o.mountsDir = p
+		return nil
+	}
+}
+
+// WithGPPRootDir specifies a personalized root directory for files managed by gpp items.
+func WithGPPRootDir(p string) Option {
+	return func(o *options) error {
+		o.gppRootDir = p
+		return nil
+	}
+}
+
+// WithPluginsDir specifies a personalized directory for policy manager plugins.
+func WithPluginsDir(p string) Option {
+	return func(o *options) error {
+		o.pluginsDir = p
+		return nil
+	}
+}
+
+// WithHooksDir specifies a personalized directory of executables run after each policy apply.
+func WithHooksDir(p string) Option {
+	return func(o *options) error {
+		o.hooksDir = p
+		return nil
+	}
+}
+
+// WithMetricsFile writes the metrics of the last policy apply of each object to p, in the Prometheus text format.
+func WithMetricsFile(p string) Option {
+	return func(o *options) error {
+		o.metricsFile = p
+		return nil
+	}
+}
+
+// WithTransformsDir specifies a personalized directory for entry transformation rules.
+func WithTransformsDir(p string) Option {
+	return func(o *options) error {
+		o.transformsDir = p
+		return nil
+	}
+}
+
+// WithDconfUserShards stores dconf user databases in their own directory, so that refreshing a user doesn't
+// update the machine database.
+func WithDconfUserShards(enabled bool) Option {
+	return func(o *options) error {
+		o.dconfShards = enabled
+		return nil
+	}
+}
+
+// WithGSettingsSchemasDir specifies a personalized directory of gsettings schemas to check dconf values against.
+func WithGSettingsSchemasDir(p string) Option {
+	return func(o *options) error {
+		o.schemasDir = p
+		return nil
+	}
+}
+
+// WithSSSDConf specifies a personalized sssd.conf, next to which the sssd policy manager writes its conf.d snippet.
+func WithSSSDConf(p string) Option {
+	return func(o *options) error {
+		o.sssdConf = p
+		return nil
+	}
+}
+
+// WithNetplanDir specifies a personalized netplan directory.
+func WithNetplanDir(p string) Option {
+	return func(o *options) error {
+		o.netplanDir = p
+		return nil
+	}
+}
+
+// WithNetplanCmd overrides the default netplan command.
+func WithNetplanCmd(cmd []string) Option {
+	return func(o *options) error {
+		o.netplanCmd = cmd
+		return nil
+	}
+}
+
+// WithJournaldConfDir specifies a personalized journald.conf.d directory.
+func WithJournaldConfDir(p string) Option {
+	return func(o *options) error {
+		o.journaldDir = p
+		return nil
+	}
+}
+
+// WithServerURL specifies how to get the URL of the domain controller, whose connectivity is checked after applying
+// a new network configuration.
+func WithServerURL(f func(context.Context) (string, error)) Option {
+	return func(o *options) error {
+		o.serverURL = f
+		return nil
+	}
+}
+
 // WithProxyApplier specifies a personalized proxy applier for the proxy policy manager.
 func WithProxyApplier(p proxy.Caller) Option {
 	return func(o *options) error {
@@ -194,6 +426,113 @@ func WithSystemdCaller(p systemdCaller) Option {
 	}
 }
 
+// WithUserSessions specifies a personalized lister of the user sessions, closing the ones opened before their policies
+// changed.
+func WithUserSessions(s userSessions) Option {
+	return func(o *options) error {
+		o.userSessions = s
+		return nil
+	}
+}
+
+// WithSdNotifier specifies a personalized systemd notifier to report the result of the refreshes in the unit status.
+func WithSdNotifier(f func(unsetEnvironment bool, state string) (bool, error)) Option {
+	return func(o *options) error {
+		o.sdNotifier = f
+		return nil
+	}
+}
+
+// WithDisabledManagers skips the given policy managers when applying policies, as they are not supported on this
+// system.
+func WithDisabledManagers(managers []string) Option {
+	return func(o *options) error {
+		o.disabledManagers = managers
+		return nil
+	}
+}
+
+// WithLastKnownGoodAfter re-activates the last policy a policy manager applied successfully once it fails this number
+// of times in a row. 0 never re-activates it.
+func WithLastKnownGoodAfter(failures int) Option {
+	return func(o *options) error {
+		o.lastKnownGoodAfter = failures
+		return nil
+	}
+}
+
+// WithUserNotifications notifies users in their session of the restrictions newly enforced by a refresh of their
+// policies.
+func WithUserNotifications(enabled bool) Option {
+	return func(o *options) error {
+		o.userNotifications = enabled
+		return nil
+	}
+}
+
+// WithDomainCacheQuota specifies the maximum size in bytes of the cache of each domain, past which the policies of
+// its users are not applied anymore. 0 is unlimited.
+func WithDomainCacheQuota(quota int64) Option {
+	return func(o *options) error {
+		if quota < 0 {
+			return fmt.Errorf(i18n.G("invalid negative domain cache quota: %d"), quota)
+		}
+		o.domainCacheQuota = quota
+		return nil
+	}
+}
+
+// WithSecretResolver resolves the secrets referenced by entries with URI scheme using r.
+func WithSecretResolver(scheme string, r secrets.Resolver) Option {
+	return func(o *options) error {
+		o.secretsOptions = append(o.secretsOptions, secrets.WithResolver(scheme, r))
+		return nil
+	}
+}
+
+// WithSecretEscrow writes the values generated for the machine with an escrow option, and the rotated local
+// account passwords, to the directory with f.
+func WithSecretEscrow(f secrets.EscrowFunc) Option {
+	return func(o *options) error {
+		o.secretsOptions = append(o.secretsOptions, secrets.WithEscrow(f))
+		o.escrow = f
+		return nil
+	}
+}
+
+// WithSecretEscrowReader reads the escrowed values back from the directory with f, like the expiration time of the
+// rotated local account passwords which domain administrators can change.
+func WithSecretEscrowReader(f secrets.ReadEscrowedFunc) Option {
+	return func(o *options) error {
+		o.readEscrowed = f
+		return nil
+	}
+}
+
+// WithVaultTokenFile specifies a personalized file containing the token to read secrets from Vault servers.
+func WithVaultTokenFile(p string) Option {
+	return func(o *options) error {
+		o.secretsOptions = append(o.secretsOptions, secrets.WithVaultTokenFile(p))
+		return nil
+	}
+}
+
+// WithEscrowAttributes specifies the attributes of the computer object the generated values can be escrowed to.
+func WithEscrowAttributes(attributes []string) Option {
+	return func(o *options) error {
+		o.secretsOptions = append(o.secretsOptions, secrets.WithEscrowAttributes(attributes))
+		return nil
+	}
+}
+
+// WithVaultServers specifies the hosts of the Vault servers secrets can be read from.
+func WithVaultServers(hosts []string) Option {
+	return func(o *options) error {
+		o.secretsOptions = append(o.secretsOptions, secrets.WithVaultServers(hosts))
+		return nil
+	}
+}
+
 // NewManager returns a new manager with all default policy handlers.
 func NewManager(bus *dbus.Conn, hostname string, opts ...Option) (m *Manager, err error) {
 	defer decorate.OnError(&err, i18n.G("can't create a new policy handlers manager"))
@@ -209,8 +548,19 @@ func NewManager(bus *dbus.Conn, hostname string, opts ...Option) (m *Manager, er
 		runDir:        consts.DefaultRunDir,
 		apparmorDir:   consts.DefaultApparmorDir,
 		systemUnitDir: consts.DefaultSystemUnitDir,
+		pluginsDir:    consts.DefaultPluginsDir,
+		hooksDir:      consts.DefaultHooksDir,
+		transformsDir: consts.DefaultTransformsDir,
+		sssdConf:      consts.DefaultSSSConf,
+		netplanDir:    consts.DefaultNetplanDir,
+		journaldDir:   consts.DefaultJournaldConfDir,
 		systemdCaller: defaultSystemdCaller,
+		userSessions:  logind.New(bus),
+		sdNotifier:    daemon.SdNotify,
 		gdm:           nil,
+
+		minFreeDiskSpace: consts.MinFreeDiskSpace,
+		interfaceAddrs:   net.InterfaceAddrs,
 	}
 	// applied options (including dconf manager used by gdm)
 	for _, o := range opts {
@@ -219,10 +569,11 @@ func NewManager(bus *dbus.Conn, hostname string, opts ...Option) (m *Manager, er
 		}
 	}
 	// dconf manager
-	dconfManager := &dconf.Manager{}
-	if args.dconfDir != "" {
-		dconfManager = dconf.NewWithDconfDir(args.dconfDir)
+	dconfOptions := []dconf.Option{dconf.WithUserShards(args.dconfShards)}
+	if args.schemasDir != "" {
+		dconfOptions = append(dconfOptions, dconf.WithSchemasDir(args.schemasDir))
 	}
+	dconfManager := dconf.NewWithDconfDir(args.dconfDir, dconfOptions...)
 
 	// privilege manager
 	privilegeManager := privilege.NewWithDirs(args.sudoersDir, args.policyKitDir)
@@ -234,13 +585,17 @@ func NewManager(bus *dbus.Conn, hostname string, opts ...Option) (m *Manager, er
 	}
 
 	// mount manager
-	mountManager, err := mount.New(args.runDir, args.systemUnitDir, args.systemdCaller)
+	var mountOpts []mount.Option
+	if args.mountsDir != "" {
+		mountOpts = append(mountOpts, mount.WithMountsDir(args.mountsDir))
+	}
+	mountManager, err := mount.New(args.runDir, args.systemUnitDir, args.systemdCaller, mountOpts...)
 	if err != nil {
 		return nil, err
 	}
 
 	// apparmor manager
-	var apparmorOptions []apparmor.Option
+	apparmorOptions := []apparmor.Option{apparmor.WithApparmorCacheDir(filepath.Join(args.cacheDir, "apparmor"))}
 	if args.apparmorParserCmd != nil {
 		apparmorOptions = append(apparmorOptions, apparmor.WithApparmorParserCmd(args.apparmorParserCmd))
 	}
@@ -256,6 +611,56 @@ func NewManager(bus *dbus.Conn, hostname string, opts ...Option) (m *Manager, er
 	}
 	proxyManager := proxy.New(bus, proxyOptions...)
 
+	// sssd manager
+	sssdManager := sssd.New(args.sssdConf, args.systemdCaller)
+
+	// netplan manager
+	var netplanOptions []netplan.Option
+	if args.netplanCmd != nil {
+		netplanOptions = append(netplanOptions, netplan.WithNetplanCmd(args.netplanCmd))
+	}
+	if args.serverURL != nil {
+		netplanOptions = append(netplanOptions, netplan.WithServerURL(args.serverURL))
+	}
+	netplanManager := netplan.New(args.netplanDir, filepath.Join(args.cacheDir, netplanCacheBaseName), netplanOptions...)
+
+	// journald manager
+	journaldManager := journald.New(args.journaldDir, args.systemdCaller)
+
+	// laps manager
+	lapsManager := laps.New(filepath.Join(args.cacheDir, lapsCacheBaseName), args.escrow, laps.WithReadEscrowed(args.readEscrowed))
+
+	// gpp manager
+	var gppOptions []gpp.Option
+	if args.gppRootDir != "" {
+		gppOptions = append(gppOptions, gpp.WithRootDir(args.gppRootDir))
+	}
+	// Files written by dedicated managers take precedence over gpp items editing them.
+	reserved := make(map[string]string)
+	for policyType, paths := range map[string][]string{
+		"privilege": privilegeManager.Destinations(),
+		"dconf":     dconfManager.Destinations(),
+		"apparmor":  apparmorManager.Destinations(),
+		"sssd":      sssdManager.Destinations(),
+		"netplan":   netplanManager.Destinations(),
+		"journald":  journaldManager.Destinations(),
+	} {
+		for _, p := range paths {
+			if p == "" {
+				continue
+			}
+			reserved[p] = policyType
+		}
+	}
+	gppOptions = append(gppOptions, gpp.WithReservedPaths(reserved))
+	gppManager := gpp.New(filepath.Join(args.cacheDir, "gpp"), gppOptions...)
+
+	// environment manager
+	envManager := environment.New(args.runDir)
+
+	// plugins manager
+	pluginsManager := plugins.New(args.pluginsDir, builtinRules)
+
 	// inject applied dconf mangager if we need to build a gdm manager
 	if args.gdm == nil {
 		if args.gdm, err = gdm.New(gdm.WithDconf(dconfManager)); err != nil {
@@ -263,37 +668,182 @@ func NewManager(bus *dbus.Conn, hostname string, opts ...Option) (m *Manager, er
 		}
 	}
 
-	policiesCacheDir := filepath.Join(args.cacheDir, PoliciesCacheBaseName)
-	if err := os.MkdirAll(policiesCacheDir, 0700); err != nil {
+	for _, kind := range []string{PoliciesCacheBaseName, inflightCacheBaseName, statusCacheBaseName} {
+		if err := os.MkdirAll(filepath.Join(args.cacheDir, kind), 0700); err != nil {
+			return nil, err
+		}
+	}
+	// Previous versions stored the state of all users together.
+	if err := MigrateToDomainLayout(context.Background(), args.cacheDir, PoliciesCacheBaseName, inflightCacheBaseName,
+		statusCacheBaseName, reportOnlyCacheBaseName, ownedCacheBaseName); err != nil {
 		return nil, err
 	}
 
 	subscriptionDbus := bus.Object(consts.SubscriptionDbusRegisteredName,
 		dbus.ObjectPath(consts.SubscriptionDbusObjectPath))
 
-	return &Manager{
-		policiesCacheDir: policiesCacheDir,
-		hostname:         hostname,
-		dconf:            dconfManager,
-		privilege:        privilegeManager,
-		scripts:          scriptsManager,
-		mount:            mountManager,
-		apparmor:         apparmorManager,
-		proxy:            proxyManager,
-		gdm:              args.gdm,
+	dirOrDefault := func(dir, defaultDir string) string {
+		if dir == "" {
+			return defaultDir
+		}
+		return dir
+	}
+	dconfDir := dirOrDefault(args.dconfDir, consts.DefaultDconfDir)
+	sudoersDir := dirOrDefault(args.sudoersDir, consts.DefaultSudoersDir)
+	policyKitDir := dirOrDefault(args.policyKitDir, consts.DefaultPolicyKitDir)
+	sssdConfDir := filepath.Dir(sssdManager.Destinations()[0])
+	destinationDirs := []string{args.cacheDir, args.runDir, args.apparmorDir, args.systemUnitDir, dconfDir, sudoersDir, policyKitDir, sssdConfDir, args.netplanDir, args.journaldDir}
+
+	// Managed files are recorded relative to the directory they are written to.
+	ownedRoots := map[string]string{
+		"run":      args.runDir,
+		"apparmor": args.apparmorDir,
+		"systemd":  args.systemUnitDir,
+		"dconf":    dconfDir,
+		"sudoers":  sudoersDir,
+		"polkit":   policyKitDir,
+		"sssd":     sssdConfDir,
+		"netplan":  args.netplanDir,
+		"journald": args.journaldDir,
+		"gpp":      dirOrDefault(args.gppRootDir, "/"),
+	}
+
+	var unhandled *unhandledEntries
+	if args.unhandledEntries {
+		unhandled = &unhandledEntries{path: filepath.Join(args.runDir, UnhandledEntriesFileName)}
+	}
+
+	disabledManagers := make(map[string]struct{})
+	for _, name := range args.disabledManagers {
+		disabledManagers[name] = struct{}{}
+	}
+
+	m = &Manager{
+		cacheDir:           args.cacheDir,
+		sysvolCacheDir:     filepath.Join(args.cacheDir, SysvolCacheBaseName),
+		ownedRoots:         ownedRoots,
+		policyReadyFlag:    filepath.Join(args.runDir, consts.PolicyReadyFlagName),
+		transformsDir:      args.transformsDir,
+		runDir:             args.runDir,
+		hostname:           hostname,
+		destinationDirs:    destinationDirs,
+		minFreeDiskSpace:   args.minFreeDiskSpace,
+		domainCacheQuota:   args.domainCacheQuota,
+		precedence:         args.precedence,
+		disabledManagers:   disabledManagers,
+		userNotifications:  args.userNotifications,
+		lastKnownGoodAfter: args.lastKnownGoodAfter,
+		hooks:              hooks.New(bus, args.hooksDir),
+		unitStatus:         newUnitStatus(args.sdNotifier),
+		metricsFile:        args.metricsFile,
+		metricsMu:          &sync.Mutex{},
+		interfaceAddrs:     args.interfaceAddrs,
+		secrets:            secrets.New(append([]secrets.Option{secrets.WithGeneratedDir(filepath.Join(args.cacheDir, generatedCacheBaseName))}, args.secretsOptions...)...),
+		unhandled:          unhandled,
+		dconf:              dconfManager,
+		privilege:          privilegeManager,
+		scripts:            scriptsManager,
+		mount:              mountManager,
+		apparmor:           apparmorManager,
+		proxy:              proxyManager,
+		gpp:                gppManager,
+		env:                envManager,
+		sssd:               sssdManager,
+		netplan:            netplanManager,
+		journald:           journaldManager,
+		laps:               lapsManager,
+		plugins:            pluginsManager,
+		gdm:                args.gdm,
+
+		userSessions: args.userSessions,
+
+		systemUnitDir: args.systemUnitDir,
+		systemdCaller: args.systemdCaller,
+		expiryMu:      &sync.Mutex{},
 
 		subscriptionDbus: subscriptionDbus,
 
 		muMu:     &sync.Mutex{},
 		objectMu: make(map[string]*sync.Mutex),
-	}, nil
+		applies:  &sync.WaitGroup{},
+	}
+	m.restoreUnitStatus(context.Background())
+	if err := m.netplan.RevertPending(context.Background()); err != nil {
+		log.Warningf(context.Background(), i18n.G("Can't restore the previous network configuration: %v"), err)
+	}
+
+	return m, nil
+}
+
+// BatchUserUpdates calls apply, which applies policies to many users with the context it gets, and compiles the
+// dconf databases changed by these policies only once apply returned. Policies applied meanwhile with other
+// contexts are not delayed.
+func (m *Manager) BatchUserUpdates(ctx context.Context, apply func(ctx context.Context) error) error {
+	ctx = m.dconf.BeginBatch(ctx)
+	defer m.dconf.EndBatch(ctx)
+
+	return apply(ctx)
+}
+
+type applyOptions struct {
+	dryRun io.Writer
+	purge  bool
+	// rollback restores the last applied policies, even while policy updates are frozen.
+	rollback      bool
+	completedLate func() bool
+	atLogin       bool
+	provisioning  bool
+	// managers, if not nil, are the only policy managers applied. The others keep the status of their last apply.
+	managers []string
+}
+
+// ApplyOption reprents an optional function to change ApplyPolicies behavior.
+type ApplyOption func(*applyOptions)
+
+// WithDryRun doesn't apply the policies, but writes to w the entries that applying them would add, remove or
+// change compared to the last applied policies. Nothing is modified on the system.
+func WithDryRun(w io.Writer) ApplyOption {
+	return func(o *applyOptions) {
+		o.dryRun = w
+	}
+}
+
+// WithCompletionNotification notifies the user once their policies are applied if completedLate returns true at the
+// end of the apply, as their session started meanwhile with the previously applied policies.
+func WithCompletionNotification(completedLate func() bool) ApplyOption {
+	return func(o *applyOptions) {
+		o.completedLate = completedLate
+	}
+}
+
+// AtLogin tells that the user is logging in. Their new session starts with the applied policies, so that closing their
+// sessions is not planned, unless the apply completes after the session started.
+func AtLogin() ApplyOption {
+	return func(o *applyOptions) {
+		o.atLogin = true
+	}
+}
+
+// WhenProvisioning tells that the policies are applied to an image cloned into many machines. The policy managers
+// specific to each machine are not applied: they are applied on each clone once it refreshes its policies.
+func WhenProvisioning() ApplyOption {
+	return func(o *applyOptions) {
+		o.provisioning = true
+	}
 }
 
 // ApplyPolicies generates a computer or user policy based on a list of entries
 // retrieved from a directory service.
-func (m *Manager) ApplyPolicies(ctx context.Context, objectName string, isComputer bool, pols *Policies) (err error) {
+// A failing policy manager doesn't stop the others: all of them are applied and the status of each one is reported
+// by LastApplyStatus.
+func (m *Manager) ApplyPolicies(ctx context.Context, objectName string, isComputer bool, pols *Policies, opts ...ApplyOption) (err error) {
 	defer decorate.OnError(&err, i18n.G("failed to apply policy to %q"), objectName)
 
+	var args applyOptions
+	for _, o := range opts {
+		o(&args)
+	}
+
 	// We have a lock per objectName to prevent multiple instances of ApplyPolicies for the same object.
 	m.muMu.Lock()
 	if _, ok := m.objectMu[objectName]; !ok {
@@ -303,85 +853,990 @@ func (m *Manager) ApplyPolicies(ctx context.Context, objectName string, isComput
 	defer m.objectMu[objectName].Unlock()
 	m.muMu.Unlock()
 
-	rules := pols.GetUniqueRules()
+	// Subnets and expiry conditions are evaluated on each apply, so that entries follow the machine moving between
+	// networks and are reverted once expired, even when applying cached policies.
+	ips, err := m.machineIPs()
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	applicable := pols.inSubnets(ctx, ips).unexpired(ctx, now)
+	rules := applicable.enforced().getUniqueRules(m.precedence)
+
+	// Site-local transformation rules are reloaded on each apply, so that mitigations are effective immediately.
+	transforms, err := transform.Load(m.transformsDir)
+	if err != nil {
+		return err
+	}
+	transforms.Apply(ctx, objectName, rules)
+
+	// Report-only entries are resolved as if they were applied, to report how they differ from the enforced rules.
+	var reportedRules map[string][]entry.Entry
+	if applicable.hasReportOnly() {
+		reportedRules = applicable.getUniqueRules(m.precedence)
+		transforms.Apply(ctx, objectName, reportedRules)
+	}
+
+	if args.dryRun != nil {
+		return m.dryRun(ctx, args.dryRun, objectName, isComputer, rules, reportedRules, transforms)
+	}
+	// Purging is still allowed while frozen, to remove a bad policy during an incident.
+	if !args.purge && !args.rollback {
+		if until, frozen := m.FrozenUntil(ctx); frozen {
+			log.Warningf(ctx, i18n.G("Policy updates are frozen %s: skipping update of %q"), DescribeFreeze(until), objectName)
+			return nil
+		}
+	}
+	// Compare before filtering the rules which require Ubuntu Pro.
+	if err := m.saveReportOnly(objectName, rules, reportedRules); err != nil {
+		log.Warningf(ctx, i18n.G("Can't save report-only entries compliance for %s: %v"), objectName, err)
+	}
+
+	// Keep the previous rules to report the policy managers with changes, and to notify users of the new restrictions
+	// once applied.
+	previousRules, errPrevious := m.lastAppliedRules(ctx, objectName, transforms)
+	if errPrevious != nil {
+		log.Warningf(ctx, i18n.G("Can't load previous policies of %s to compute changes: %v"), objectName, errPrevious)
+	}
+
 	action := i18n.G("Applying")
 	if len(rules) == 0 {
 		action = i18n.G("Unloading")
 	}
 	log.Infof(ctx, i18n.G("%s policies for %s (machine: %v)"), action, objectName, isComputer)
 
-	var g errgroup.Group
+	// Unloading policies only frees disk space.
+	if len(rules) > 0 {
+		if err := diskspace.Check(ctx, estimatedDiskUsage(rules, pols)+m.minFreeDiskSpace, m.destinationDirs...); err != nil {
+			return err
+		}
+		m.warnDomainQuota(ctx, objectName)
+	}
+
+	// Secrets are only resolved for the policy managers: the cache, the status and the reports keep the references.
+	// A secret which can't be resolved only fails the policy manager of its entry.
+	resolved, secretErrs := m.secrets.Resolve(ctx, rules)
+
+	// From now on, the machine state is modified. Checkpoint the object so that an apply interrupted by the daemon
+	// being killed is rolled back on next start, and don't let the client going away stop the policy managers
+	// midway.
+	m.applies.Add(1)
+	defer m.applies.Done()
+	checkpoint := m.objectPath(inflightCacheBaseName, objectName)
+	if err := os.MkdirAll(filepath.Dir(checkpoint), 0700); err != nil {
+		return err
+	}
+	if err := os.WriteFile(checkpoint, []byte(strconv.FormatBool(isComputer)), 0600); err != nil {
+		return err
+	}
+	defer func() {
+		if err := os.Remove(checkpoint); err != nil {
+			log.Warningf(ctx, i18n.G("Can't remove policy apply checkpoint for %s: %v"), objectName, err)
+		}
+	}()
+	ctx = detachedctx.New(ctx)
+
+	// Each policy manager is applied independently: one failing doesn't prevent the others from applying their
+	// policies, and the status of each of them is saved to report which ones succeeded.
+	var status applyStatus
+	var wg sync.WaitGroup
+	statusPath := m.objectPath(statusCacheBaseName, objectName)
+	previousStatus, errPreviousStatus := loadStatus(statusPath)
+	// appliers are the policy managers applied this time, to apply them again with their last known good policy.
+	appliers := make(map[string]func(map[string][]entry.Entry) error)
+	// appliedRules are the rules, with unresolved secrets, each policy manager is applied with once filtered, to
+	// record them as its last known good policy.
+	appliedRules := make(map[string]map[string][]entry.Entry)
+	apply := func(manager string, f func(resolved map[string][]entry.Entry) error) {
+		if _, disabled := m.disabledManagers[manager]; disabled {
+			log.Debugf(ctx, "Skipping %s policy manager: not supported on this system", manager)
+			status.skip(manager)
+			return
+		}
+		if args.managers != nil && !slices.Contains(args.managers, manager) {
+			status.keep(manager, previousStatus)
+			return
+		}
+		if args.provisioning && slices.Contains(machineIdentityManagers, manager) {
+			log.Infof(ctx, i18n.G("Skipping %s policy manager while provisioning: it applies on each machine cloned from the image"), manager)
+			status.keep(manager, previousStatus)
+			return
+		}
+		var errs []error
+		for key, err := range secretErrs {
+			if managerForRulesKey(key) == manager {
+				errs = append(errs, err)
+			}
+		}
+		if len(errs) > 0 {
+			status.set(manager, errors.Join(errs...))
+			return
+		}
+		appliers[manager] = f
+		appliedRules[manager] = managerRules(manager, rules)
+		// The Pro filtering modifies the rules while the first policy managers are applied.
+		r := maps.Clone(resolved)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			status.set(manager, f(r))
+		}()
+	}
+
 	// Applying dconf policies take a while to complete, so it's better to start applying them before
 	// querying dbus for the Pro subscription state, as it does not rely on that.
-	g.Go(func() error {
-		return m.dconf.ApplyPolicy(ctx, objectName, isComputer, rules["dconf"])
+	apply("dconf", func(resolved map[string][]entry.Entry) error {
+		// Don’t append to the dconf entries directly, as their backing array is shared with the other users of the rules.
+		entries := make([]entry.Entry, 0, len(resolved["dconf"])+len(resolved["dconf-preferences"]))
+		entries = append(entries, resolved["dconf"]...)
+		entries = append(entries, resolved["dconf-preferences"]...)
+		err := m.dconf.ApplyPolicy(ctx, objectName, isComputer, entries)
+		logInvalidChoices(ctx, pols, err)
+		return err
 	})
 	if !m.GetSubscriptionState(ctx) {
 		if filteredRules := filterRules(ctx, rules); len(filteredRules) > 0 {
 			log.Warningf(ctx, i18n.G("Rules from the following policy types will be filtered out as the machine is not enrolled to Ubuntu Pro: %s"), strings.Join(filteredRules, ", "))
 		}
+		filterRules(ctx, resolved)
+		if previousRules != nil {
+			filterRules(ctx, previousRules)
+		}
 	}
 
-	g.Go(func() error {
-		return m.privilege.ApplyPolicy(ctx, objectName, isComputer, rules["privilege"])
+	apply("privilege", func(resolved map[string][]entry.Entry) error {
+		return m.privilege.ApplyPolicy(ctx, objectName, isComputer, resolved["privilege"])
 	})
-	g.Go(func() error {
-		return m.scripts.ApplyPolicy(ctx, objectName, isComputer, rules["scripts"], pols.SaveAssetsTo)
+	apply("scripts", func(resolved map[string][]entry.Entry) error {
+		return m.scripts.ApplyPolicy(ctx, objectName, isComputer, resolved["scripts"], pols.SaveAssetsTo)
 	})
-	g.Go(func() error {
-		return m.mount.ApplyPolicy(ctx, objectName, isComputer, rules["mount"])
+	apply("mount", func(resolved map[string][]entry.Entry) error {
+		return m.mount.ApplyPolicy(ctx, objectName, isComputer, resolved["mount"])
 	})
-	g.Go(func() error {
-		return m.apparmor.ApplyPolicy(ctx, objectName, isComputer, rules["apparmor"], pols.SaveAssetsTo)
+	apply("apparmor", func(resolved map[string][]entry.Entry) error {
+		return m.apparmor.ApplyPolicy(ctx, objectName, isComputer, resolved["apparmor"], pols.SaveAssetsTo)
 	})
-	g.Go(func() error {
-		return m.proxy.ApplyPolicy(ctx, objectName, isComputer, rules["proxy"])
+	apply("proxy", func(resolved map[string][]entry.Entry) error {
+		return m.proxy.ApplyPolicy(ctx, objectName, isComputer, resolved["proxy"])
 	})
-	if err := g.Wait(); err != nil {
+	apply("gpp", func(resolved map[string][]entry.Entry) error {
+		err := m.gpp.ApplyPolicy(ctx, objectName, isComputer, resolved["gpp"])
+		if isComputer {
+			status.conflict("gpp", m.gpp.Conflicts())
+		}
 		return err
-	}
+	})
+	apply("environment", func(resolved map[string][]entry.Entry) error {
+		return m.env.ApplyPolicy(ctx, objectName, isComputer, resolved["environment"])
+	})
+	apply("sssd", func(resolved map[string][]entry.Entry) error {
+		return m.sssd.ApplyPolicy(ctx, objectName, isComputer, resolved["sssd"])
+	})
+	apply("netplan", func(resolved map[string][]entry.Entry) error {
+		return m.netplan.ApplyPolicy(ctx, objectName, isComputer, resolved["netplan"])
+	})
+	apply("journald", func(resolved map[string][]entry.Entry) error {
+		return m.journald.ApplyPolicy(ctx, objectName, isComputer, resolved["journald"])
+	})
+	apply("laps", func(resolved map[string][]entry.Entry) error {
+		return m.laps.ApplyPolicy(ctx, objectName, isComputer, resolved["laps"])
+	})
+	apply("plugins", func(resolved map[string][]entry.Entry) error {
+		return m.plugins.ApplyPolicy(ctx, objectName, isComputer, resolved)
+	})
+	wg.Wait()
 
 	if isComputer {
 		// Apply GDM policy only now as we need dconf machine database to be ready first
-		if err := m.gdm.ApplyPolicy(ctx, rules["gdm"]); err != nil {
+		apply("gdm", func(resolved map[string][]entry.Entry) error {
+			if status.failed("dconf") && !status.reverted("dconf") {
+				return errors.New(i18n.G("not applied as the dconf policy failed"))
+			}
+			return m.gdm.ApplyPolicy(ctx, resolved["gdm"])
+		})
+		wg.Wait()
+	} else {
+		// Outdated sessions are only closed once the policies are cached, but an invalid session policy is reported
+		// with the other policy managers.
+		apply("session", func(resolved map[string][]entry.Entry) error {
+			_, _, err := logoutPolicy(resolved[sessionRulesKey])
+			return err
+		})
+		wg.Wait()
+	}
+
+	status.countFailures(previousStatus)
+	if m.lastKnownGoodAfter > 0 {
+		if err := m.applyLastKnownGood(ctx, objectName, &status, appliers, appliedRules, resolved); err != nil {
+			log.Warningf(ctx, i18n.G("Can't apply last known good policies of %s: %v"), objectName, err)
+		}
+	}
+	// SSSD is only restarted now, so that the lookups of the other policy managers don't fail while it restarts.
+	m.sssd.RestartIfChanged(ctx)
+
+	// A drastic change of the number of entries or of files is an early sign of a GPO deleting or flooding entries.
+	status.count(rules)
+	files, errFiles := m.managedFiles(objectName, isComputer)
+	if errFiles != nil {
+		log.Warningf(ctx, i18n.G("Can't list the files managed for %s: %v"), objectName, errFiles)
+	} else {
+		status.countFiles(files)
+	}
+	if errPreviousStatus == nil {
+		status.warnDrasticChanges(ctx, objectName, previousStatus)
+	}
+	if err := status.save(statusPath); err != nil {
+		log.Warningf(ctx, i18n.G("Can't save policy apply status for %s: %v"), objectName, err)
+	}
+	m.writeMetrics(ctx)
+	// Secrets are never stored: the entries keep their references.
+	if m.unhandled != nil {
+		if err := m.saveUnhandled(ctx, objectName, rules); err != nil {
+			log.Warningf(ctx, i18n.G("Can't save entries not handled by any policy manager: %v"), err)
+		}
+	}
+	m.clearRetrievalFailure(ctx, objectName)
+	m.unitStatus.update(ctx, objectName, isComputer, status.failedManagers(), time.Now())
+	// Once the policies are cached, D-Bus clients fetch the new update state.
+	defer m.hooks.StateChanged(ctx, isComputer)
+	if err := status.err(); err != nil {
+		return err
+	}
+
+	if args.purge {
+		if err := m.removeObjectState(ctx, objectName, isComputer); err != nil {
 			return err
 		}
+		m.writeMetrics(ctx)
+		m.notifyPolicyUpdated(objectName, isComputer, changedManagers(previousRules, rules))
+		return nil
+	}
+
+	if errFiles == nil {
+		if err := m.saveOwnedFiles(objectName, files, applicable.enforced().GPOs); err != nil {
+			log.Warningf(ctx, i18n.G("Can't record the files managed for %s: %v"), objectName, err)
+		}
 	}
 
 	// Write cache Policies
-	return pols.Save(filepath.Join(m.policiesCacheDir, objectName))
+	if err := pols.Save(m.objectPath(PoliciesCacheBaseName, objectName)); err != nil {
+		return err
+	}
+	if err := m.saveNextExpiry(objectName, pols.nextExpiry(now)); err != nil {
+		log.Warningf(ctx, i18n.G("Can't save the next expiry of the entries of %s: %v"), objectName, err)
+	} else if err := m.scheduleExpiryTimer(ctx); err != nil {
+		log.Warningf(ctx, i18n.G("Can't revert the entries of %s at their expiry: %v"), objectName, err)
+	}
+	// Hooks only run once the new policies are cached, so that they see the same state as the clients.
+	m.notifyPolicyUpdated(objectName, isComputer, changedManagers(previousRules, rules))
+
+	if !isComputer {
+		completedLate := args.completedLate != nil && args.completedLate()
+		if completedLate {
+			if err := m.notifyCompletion(ctx, objectName, previousRules, rules); err != nil {
+				log.Warningf(ctx, i18n.G("Can't notify %s of the completed refresh: %v"), objectName, err)
+			}
+		} else if m.userNotifications && previousRules != nil {
+			// Users are only notified of changes compared to policies applied before.
+			if err := m.notifyNewRestrictions(ctx, objectName, previousRules, rules); err != nil {
+				log.Warningf(ctx, i18n.G("Can't notify %s of new restrictions: %v"), objectName, err)
+			}
+		}
+		// The closing of the sessions is notified last, as it matters more than new restrictions.
+		if err := m.closeOutdatedSessions(ctx, objectName, !args.atLogin || completedLate, previousRules, rules); err != nil {
+			log.Warningf(ctx, i18n.G("Can't close outdated sessions: %v"), err)
+		}
+		return nil
+	}
+	if m.userNotifications && previousRules != nil {
+		if err := m.notifyRevokedPrivileges(ctx, previousRules, rules); err != nil {
+			log.Warningf(ctx, i18n.G("Can't notify users of their revoked privileges: %v"), err)
+		}
+	}
+	// Signal the display manager waiting on boot that machine policies, like dconf locks, are now enforced.
+	return os.WriteFile(m.policyReadyFlag, nil, 0600)
+}
+
+// Purge unloads the policies of objectName from every policy manager, like applying policies without any rule, and
+// removes the state adsys keeps for it: its cached policies, which are also its last update time, and its last apply
+// status. The directory service is not contacted, so that leftovers can be removed from a machine which left the
+// domain.
+func (m *Manager) Purge(ctx context.Context, objectName string, isComputer bool) error {
+	return m.ApplyPolicies(ctx, objectName, isComputer, &Policies{}, func(o *applyOptions) { o.purge = true })
+}
+
+// LimitUserScript confines the script process pid of the user uid to the runtime allowed for the user scripts.
+func (m *Manager) LimitUserScript(ctx context.Context, uid, pid uint32) error {
+	return m.scripts.LimitUserScript(ctx, uid, pid)
+}
+
+// ResetMachineIdentity removes the state specific to the machine, like the rotation of its local administrator
+// password and the values generated for its policies, so that the machines cloned from a provisioned image don't share
+// them but rotate and generate their own on their first apply.
+func (m *Manager) ResetMachineIdentity(ctx context.Context) (err error) {
+	defer decorate.OnError(&err, i18n.G("can't reset machine identity state"))
+
+	log.Info(ctx, i18n.G("Removing the state specific to this machine"))
+	for _, p := range []string{filepath.Join(m.cacheDir, lapsCacheBaseName), filepath.Join(m.cacheDir, generatedCacheBaseName)} {
+		if err := os.RemoveAll(p); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// removeObjectState removes the cached policies, the last apply status, the record of managed files, the next expiry,
+// the pending closing of sessions and, for users, the pending new restrictions notification of objectName.
+func (m *Manager) removeObjectState(ctx context.Context, objectName string, isComputer bool) error {
+	log.Infof(ctx, i18n.G("Removing policies state of %s"), objectName)
+
+	for _, p := range []string{m.objectPath(PoliciesCacheBaseName, objectName), m.objectPath(statusCacheBaseName, objectName), m.objectPath(reportOnlyCacheBaseName, objectName), m.objectPath(ownedCacheBaseName, objectName), m.objectPath(lastKnownGoodCacheBaseName, objectName), m.objectPath(expiryCacheBaseName, objectName), m.objectPath(pendingLogoutCacheBaseName, objectName), m.objectPath(retrievalFailedCacheBaseName, objectName)} {
+		if err := os.RemoveAll(p); err != nil {
+			return err
+		}
+	}
+	if err := m.scheduleExpiryTimer(ctx); err != nil {
+		log.Warningf(ctx, i18n.G("Can't reschedule the expiry of policy entries: %v"), err)
+	}
+
+	if isComputer {
+		return nil
+	}
+	return m.removeNotification(ctx, objectName)
+}
+
+// dryRun writes to w the differences between rules and the last applied rules of objectName, then the changes the
+// report-only entries would make compared to rules, if there is any.
+// They are compared after transformations and Ubuntu Pro filtering, as they would be applied.
+func (m *Manager) dryRun(ctx context.Context, w io.Writer, objectName string, isComputer bool, rules, reportedRules map[string][]entry.Entry, transforms transform.Rules) error {
+	log.Infof(ctx, i18n.G("Computing policy changes for %s (machine: %v)"), objectName, isComputer)
+
+	// Policies never applied are compared to an empty state.
+	appliedRules, err := m.lastAppliedRules(ctx, objectName, transforms)
+	if err != nil {
+		return err
+	}
+
+	if !m.GetSubscriptionState(ctx) {
+		if filteredRules := filterRules(ctx, rules); len(filteredRules) > 0 {
+			log.Warningf(ctx, i18n.G("Rules from the following policy types will be filtered out as the machine is not enrolled to Ubuntu Pro: %s"), strings.Join(filteredRules, ", "))
+		}
+		if appliedRules != nil {
+			filterRules(ctx, appliedRules)
+		}
+		if reportedRules != nil {
+			filterRules(ctx, reportedRules)
+		}
+	}
+
+	writeRulesDiff(w, objectName, isComputer, appliedRules, rules)
+	if reportedRules == nil {
+		return nil
+	}
+	if changes := rulesDiff(rules, reportedRules); changes != "" {
+		fmt.Fprintf(w, i18n.G("Report-only changes, not applied:\n%s"), changes)
+	} else {
+		fmt.Fprint(w, i18n.G("No report-only change.\n"))
+	}
+	return nil
+}
+
+// lastAppliedRules returns the rules of the policies last applied to objectName, after transformations.
+// It returns nil if no policy was applied yet.
+func (m *Manager) lastAppliedRules(ctx context.Context, objectName string, transforms transform.Rules) (rules map[string][]entry.Entry, err error) {
+	applied, err := NewFromCache(ctx, m.objectPath(PoliciesCacheBaseName, objectName))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer func() { _ = applied.Close() }()
+
+	rules = applied.enforced().getUniqueRules(m.precedence)
+	transforms.Apply(ctx, objectName, rules)
+	return rules, nil
+}
+
+// machineIPs returns the current addresses of the machine, except the loopback and link-local ones which don't tell
+// which network it is connected to.
+func (m *Manager) machineIPs() (ips []net.IP, err error) {
+	defer decorate.OnError(&err, i18n.G("can't list the addresses of the machine"))
+
+	addrs, err := m.interfaceAddrs()
+	if err != nil {
+		return nil, err
+	}
+	for _, a := range addrs {
+		n, ok := a.(*net.IPNet)
+		if !ok || n.IP.IsLoopback() || n.IP.IsLinkLocalUnicast() {
+			continue
+		}
+		ips = append(ips, n.IP)
+	}
+	return ips, nil
+}
+
+// estimatedDiskUsage returns an estimate of the disk space needed to apply rules: the content rendered by the
+// policy managers, its copy in the cache, and the assets, both uncompressed and in the cache.
+func estimatedDiskUsage(rules map[string][]entry.Entry, pols *Policies) (size uint64) {
+	for _, entries := range rules {
+		for _, e := range entries {
+			size += 2 * uint64(len(e.Key)+len(e.Value))
+		}
+	}
+	return size + pols.assetsDiskUsage()
+}
+
+// logInvalidChoices logs the GPO setting each dconf value rejected by err as not allowed by its schema, so that the
+// administrator knows which GPO to fix.
+func logInvalidChoices(ctx context.Context, pols *Policies, err error) {
+	var invalid []dconf.InvalidChoiceError
+	var walk func(error)
+	walk = func(err error) {
+		switch e := err.(type) {
+		case nil:
+		case dconf.InvalidChoiceError:
+			invalid = append(invalid, e)
+		case interface{ Unwrap() []error }:
+			for _, err := range e.Unwrap() {
+				walk(err)
+			}
+		default:
+			walk(errors.Unwrap(err))
+		}
+	}
+	walk(err)
+
+	for _, e := range invalid {
+		gpo := i18n.G("unknown GPO")
+		// The closest GPO setting the key wins.
+	gpos:
+		for _, g := range pols.GPOs {
+			for _, t := range []string{"dconf", "dconf-preferences"} {
+				for _, r := range g.Rules[t] {
+					if r.Key == e.Key && !r.Disabled {
+						gpo = fmt.Sprintf("%s (%s)", g.Name, g.ID)
+						break gpos
+					}
+				}
+			}
+		}
+		log.Warningf(ctx, i18n.G("Value %q of dconf key %s set by %s is not allowed: it must be one of %s"), e.Value, e.Key, gpo, strings.Join(e.Choices, ", "))
+	}
+}
+
+// notifyPolicyUpdated emits the PolicyUpdated signal and runs the hooks in the background, so that slow hooks don't
+// delay the policy apply. They are detached from the request, which may end before them, but bounded by a timeout
+// and tracked with the policy applies in progress.
+func (m *Manager) notifyPolicyUpdated(objectName string, isComputer bool, changed []string) {
+	m.applies.Add(1)
+	go func() {
+		defer m.applies.Done()
+
+		ctx, cancel := context.WithTimeout(context.Background(), hooks.NotifyTimeout)
+		defer cancel()
+		if err := m.hooks.PolicyUpdated(ctx, objectName, isComputer, changed); err != nil {
+			log.Warningf(ctx, i18n.G("Policy update hooks failed: %v"), err)
+		}
+	}()
+}
+
+// Wait blocks until all the policy applies in progress, and the hooks they started, are done.
+func (m *Manager) Wait() {
+	m.applies.Wait()
+}
+
+// ResumeInterruptedApplies applies again the last successfully applied policies of the objects whose policy apply
+// was interrupted, like when the daemon was killed during a refresh. Objects without any cached policies have all
+// their policies unloaded.
+func (m *Manager) ResumeInterruptedApplies(ctx context.Context) (err error) {
+	defer decorate.OnError(&err, i18n.G("can't resume interrupted policy applies"))
+
+	checkpoints, err := CachedObjects(m.cacheDir, inflightCacheBaseName)
+	if err != nil {
+		return err
+	}
+
+	var errs []error
+	for _, objectName := range checkpoints {
+		d, err := os.ReadFile(m.objectPath(inflightCacheBaseName, objectName))
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		isComputer, err := strconv.ParseBool(string(d))
+		if err != nil {
+			errs = append(errs, fmt.Errorf(i18n.G("invalid checkpoint for %s: %v"), objectName, err))
+			if err := os.Remove(m.objectPath(inflightCacheBaseName, objectName)); err != nil {
+				errs = append(errs, err)
+			}
+			continue
+		}
+
+		log.Warningf(ctx, i18n.G("Policy apply for %s was interrupted, rolling back to the last applied policies"), objectName)
+		pols, err := NewFromCache(ctx, m.objectPath(PoliciesCacheBaseName, objectName))
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			errs = append(errs, err)
+			continue
+		}
+		if err := m.ApplyPolicies(ctx, objectName, isComputer, &pols, func(o *applyOptions) { o.rollback = true }); err != nil {
+			errs = append(errs, err)
+		}
+		if err := pols.Close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return errors.Join(errs...)
 }
 
 // DumpPolicies displays the currently applied policies and rules (since last update) for objectName.
-// It can in addition show the rules and overridden content.
-func (m *Manager) DumpPolicies(ctx context.Context, objectName string, computerOnly, withRules, withOverridden bool) (msg string, err error) {
+// It can in addition show the rules and overridden content, and only display the GPOs and rules matching filter.
+// Filtering the rules shows them, and only showing the overridden ones shows the overridden content.
+func (m *Manager) DumpPolicies(ctx context.Context, objectName string, computerOnly, withRules, withOverridden bool, filter DumpFilter) (msg string, err error) {
 	defer decorate.OnError(&err, i18n.G("failed to dump policies for %q"), objectName)
 
 	log.Infof(ctx, "Dumping policies for %s", objectName)
 
+	if err := filter.validate(); err != nil {
+		return "", err
+	}
+	if filter.filtersEntries() {
+		withRules = true
+	}
+	if filter.OnlyOverridden {
+		withOverridden = true
+	}
+
 	var out strings.Builder
+	var gpoFound bool
 
 	var alreadyProcessedRules map[string]struct{}
 	if !computerOnly {
 		fmt.Fprintln(&out, i18n.G("Policies from machine configuration:"))
-		policiesHost, err := NewFromCache(ctx, filepath.Join(m.policiesCacheDir, m.hostname))
+		policiesHost, err := NewFromCache(ctx, m.objectPath(PoliciesCacheBaseName, m.hostname))
 		if err != nil {
 			return "", fmt.Errorf(i18n.G("no policy applied for %q: %v"), m.hostname, err)
 		}
+		// Expired entries are reverted on the system: they are not displayed anymore.
+		policiesHost = policiesHost.unexpired(ctx, time.Now())
+		winners := mostRestrictiveWinners(policiesHost.GPOs, m.precedence)
 		for _, g := range policiesHost.GPOs {
-			alreadyProcessedRules = g.Format(&out, withRules, withOverridden, alreadyProcessedRules)
+			if withRules {
+				g.stats = m.gpoStats(ctx, g.ID)
+			}
+			gpoFound = gpoFound || filter.matchGPO(g)
+			alreadyProcessedRules = g.format(&out, withRules, withOverridden, alreadyProcessedRules, winners, filter)
 		}
 		fmt.Fprintln(&out, i18n.G("Policies from user configuration:"))
 	}
 
 	// Load target policies
-	policiesTarget, err := NewFromCache(ctx, filepath.Join(m.policiesCacheDir, objectName))
+	policiesTarget, err := NewFromCache(ctx, m.objectPath(PoliciesCacheBaseName, objectName))
 	if err != nil {
 		log.Infof(ctx, i18n.G("User %q not found on cache."), objectName)
 		return "", fmt.Errorf(i18n.G("no policy applied for %q: %v"), objectName, err)
 	}
+	policiesTarget = policiesTarget.unexpired(ctx, time.Now())
+	winners := mostRestrictiveWinners(policiesTarget.GPOs, m.precedence)
+	// Machine entries override the user ones, whatever their precedence.
+	for k := range winners {
+		if _, ok := alreadyProcessedRules[k]; ok {
+			delete(winners, k)
+		}
+	}
 	for _, g := range policiesTarget.GPOs {
-		alreadyProcessedRules = g.Format(&out, withRules, withOverridden, alreadyProcessedRules)
+		if withRules {
+			g.stats = m.gpoStats(ctx, g.ID)
+		}
+		gpoFound = gpoFound || filter.matchGPO(g)
+		alreadyProcessedRules = g.format(&out, withRules, withOverridden, alreadyProcessedRules, winners, filter)
+	}
+	if filter.GPO != "" && !gpoFound {
+		return "", fmt.Errorf(i18n.G("no GPO %q is applied to %q"), filter.GPO, objectName)
+	}
+
+	return out.String(), nil
+}
+
+// SimulatePolicies displays the policies which would be applied to objectName if the GPO g was linked to it with
+// the highest precedence, merged with its currently applied policies. An applied version of g is replaced by it.
+// It can in addition show the overridden content.
+func (m *Manager) SimulatePolicies(ctx context.Context, objectName string, g GPO, withOverridden bool) (msg string, err error) {
+	defer decorate.OnError(&err, i18n.G("failed to simulate policies for %q"), objectName)
+
+	log.Infof(ctx, "Simulating policies for %s with GPO %s", objectName, g.Name)
+
+	applied, err := NewFromCache(ctx, m.objectPath(PoliciesCacheBaseName, objectName))
+	if err != nil {
+		return "", fmt.Errorf(i18n.G("no policy applied for %q: %v"), objectName, err)
+	}
+	defer func() { _ = applied.Close() }()
+
+	gpos := []GPO{g}
+	for _, a := range applied.GPOs {
+		if a.ID == g.ID {
+			continue
+		}
+		gpos = append(gpos, a)
+	}
+
+	var out strings.Builder
+	fmt.Fprintf(&out, i18n.G("Policies for %s with %s linked with the highest precedence:\n"), objectName, g.Name)
+	var alreadyProcessedRules map[string]struct{}
+	winners := mostRestrictiveWinners(gpos, m.precedence)
+	for _, g := range gpos {
+		alreadyProcessedRules = g.format(&out, true, withOverridden, alreadyProcessedRules, winners, DumpFilter{})
+	}
+
+	return out.String(), nil
+}
+
+// Supported structured formats to dump policies.
+const (
+	FormatJSON = "json"
+	FormatYAML = "yaml"
+)
+
+// AppliedGPO is the structured representation of a GPO applied to an object.
+type AppliedGPO struct {
+	Name    string         `json:"name" yaml:"name"`
+	ID      string         `json:"id" yaml:"id"`
+	Object  string         `json:"object" yaml:"object"`
+	Scope   string         `json:"scope" yaml:"scope"`
+	Entries []AppliedEntry `json:"entries" yaml:"entries"`
+}
+
+// AppliedEntry is the structured representation of an entry of an applied GPO.
+// WinningGPO is the ID of the GPO whose entry is enforced on the system, which differs from the GPO defining it
+// when the entry is overridden. Report-only entries are never enforced, and don't override other entries.
+// OverrideChain lists the IDs of all the GPOs defining the key, from the winning one to the lowest priority one, when
+// there are more than one. Precedence is set to the precedence strategy which picked the winning GPO when it is not
+// the LSDOU one.
+// Type is the variant type of values with one, like dconf ones, and TypedValue the value parsed in that type.
+type AppliedEntry struct {
+	Manager       string   `json:"manager" yaml:"manager"`
+	Key           string   `json:"key" yaml:"key"`
+	Value         string   `json:"value" yaml:"value"`
+	Type          string   `json:"type,omitempty" yaml:"type,omitempty"`
+	TypedValue    any      `json:"typed_value,omitempty" yaml:"typed_value,omitempty"`
+	Disabled      bool     `json:"disabled" yaml:"disabled"`
+	Overridden    bool     `json:"overridden" yaml:"overridden"`
+	WinningGPO    string   `json:"winning_gpo" yaml:"winning_gpo"`
+	Precedence    string   `json:"precedence,omitempty" yaml:"precedence,omitempty"`
+	OverrideChain []string `json:"override_chain,omitempty" yaml:"override_chain,omitempty"`
+	ReportOnly    bool     `json:"report_only,omitempty" yaml:"report_only,omitempty"`
+}
+
+// DumpPoliciesStructured displays the policies applied to objectName, and the machine ones if computerOnly is
+// false, in the given machine readable format. Every entry matching filter is listed, including the overridden ones.
+func (m *Manager) DumpPoliciesStructured(ctx context.Context, objectName string, computerOnly bool, format string, filter DumpFilter) (msg string, err error) {
+	defer decorate.OnError(&err, i18n.G("failed to dump policies for %q"), objectName)
+
+	log.Infof(ctx, "Dumping policies for %s in %s format", objectName, format)
+
+	if format != FormatJSON && format != FormatYAML {
+		return "", fmt.Errorf(i18n.G("unsupported format %q"), format)
+	}
+	if err := filter.validate(); err != nil {
+		return "", err
+	}
+
+	gpos, err := m.appliedGPOs(ctx, objectName, computerOnly)
+	if err != nil {
+		return "", err
+	}
+	gpos, gpoFound := filterAppliedGPOs(gpos, filter)
+	if filter.GPO != "" && !gpoFound {
+		return "", fmt.Errorf(i18n.G("no GPO %q is applied to %q"), filter.GPO, objectName)
+	}
+
+	var d []byte
+	switch format {
+	case FormatJSON:
+		d, err = json.MarshalIndent(gpos, "", "  ")
+		d = append(d, '\n')
+	case FormatYAML:
+		d, err = yaml.Marshal(gpos)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	return string(d), nil
+}
+
+// filterAppliedGPOs returns the GPOs and entries of gpos matching filter, and if a GPO matched it. GPOs without entry
+// matching filter are removed when filter hides some entries.
+func filterAppliedGPOs(gpos []AppliedGPO, filter DumpFilter) (filtered []AppliedGPO, gpoFound bool) {
+	filtered = []AppliedGPO{}
+	for _, g := range gpos {
+		if !filter.matchGPO(GPO{Name: g.Name, ID: g.ID}) {
+			continue
+		}
+		gpoFound = true
+		if !filter.filtersEntries() {
+			filtered = append(filtered, g)
+			continue
+		}
+		entries := []AppliedEntry{}
+		for _, e := range g.Entries {
+			if !filter.matchManager(e.Manager) || (filter.OnlyOverridden && !e.Overridden) {
+				continue
+			}
+			entries = append(entries, e)
+		}
+		if len(entries) == 0 {
+			continue
+		}
+		g.Entries = entries
+		filtered = append(filtered, g)
+	}
+	return filtered, gpoFound
+}
+
+// appliedGPOs returns the GPOs applied to objectName, and the machine ones if computerOnly is false, with all their
+// entries, including the overridden ones.
+func (m *Manager) appliedGPOs(ctx context.Context, objectName string, computerOnly bool) ([]AppliedGPO, error) {
+	type object struct {
+		name, scope string
+	}
+	objects := []object{{m.hostname, "machine"}, {objectName, "user"}}
+	if computerOnly {
+		objects = []object{{objectName, "machine"}}
+	}
+
+	gpos := []AppliedGPO{}
+	// Track all the GPOs defining each entry.
+	overrideChains := make(map[string][]string)
+	resolver := newOverrideResolver(m.precedence)
+	for _, o := range objects {
+		pols, err := NewFromCache(ctx, m.objectPath(PoliciesCacheBaseName, o.name))
+		if err != nil {
+			return nil, fmt.Errorf(i18n.G("no policy applied for %q: %v"), o.name, err)
+		}
+		for _, g := range resolver.resolve(ctx, pols) {
+			applied := AppliedGPO{Name: g.Name, ID: g.ID, Object: o.name, Scope: o.scope, Entries: []AppliedEntry{}}
+			for _, r := range g.entries {
+				e := AppliedEntry{Manager: r.manager, Key: r.Key, Value: r.Value, Disabled: r.Disabled, Overridden: r.overridden, WinningGPO: r.winner, ReportOnly: r.ReportOnly}
+				if r.Meta != "" && !r.Disabled {
+					e.Type = r.Meta
+					// Values which don't parse are only reported as strings, as they fail when applied.
+					if v, err := dconf.TypedValue(r.Meta, r.Value); err == nil {
+						e.TypedValue = v
+					}
+				}
+				if r.restrictive {
+					e.Precedence = PrecedenceMostRestrictive
+				}
+				if !r.ReportOnly && r.Strategy != entry.StrategyAppend {
+					k := filepath.Join(r.manager, r.Key)
+					overrideChains[k] = append(overrideChains[k], g.ID)
+				}
+				applied.Entries = append(applied.Entries, e)
+			}
+			gpos = append(gpos, applied)
+		}
+	}
+
+	for _, g := range gpos {
+		for i, e := range g.Entries {
+			if e.ReportOnly {
+				continue
+			}
+			chain := overrideChains[filepath.Join(e.Manager, e.Key)]
+			if len(chain) < 2 {
+				continue
+			}
+			// The winning GPO is not the first one when a further entry is more restrictive.
+			winnerFirst := []string{e.WinningGPO}
+			for j, id := range chain {
+				if id == e.WinningGPO {
+					winnerFirst = append(winnerFirst, chain[j+1:]...)
+					break
+				}
+				winnerFirst = append(winnerFirst, id)
+			}
+			g.Entries[i].OverrideChain = winnerFirst
+		}
+	}
+
+	return gpos, nil
+}
+
+// gpoStats returns the statistics of the GPO downloaded in the sysvol cache.
+// The GPO directory is replaced on each new download, so its modification time is the last time the GPO changed.
+// It returns nil if the GPO is not in the cache.
+func (m *Manager) gpoStats(ctx context.Context, gpoID string) *gpoStats {
+	p := filepath.Join(m.sysvolCacheDir, "Policies", gpoID)
+	info, err := os.Stat(p)
+	if err != nil {
+		return nil
+	}
+
+	stats := gpoStats{lastChange: info.ModTime()}
+	err = filepath.WalkDir(p, func(_ string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		stats.size += info.Size()
+		return nil
+	})
+	if err != nil {
+		log.Warningf(ctx, i18n.G("Can't compute download size of GPO %s: %v"), gpoID, err)
+		return nil
+	}
+
+	return &stats
+}
+
+// SearchPolicies returns the applied entries for objectName, and the machine ones if computerOnly is false,
+// whose key or value contains query, case insensitively.
+// Overridden entries are not returned, as they are not enforced on the system. Report-only entries are listed
+// separately, after the enforced ones, as they never override them.
+func (m *Manager) SearchPolicies(ctx context.Context, objectName string, computerOnly bool, query string) (msg string, err error) {
+	defer decorate.OnError(&err, i18n.G("failed to search policies for %q"), objectName)
+
+	log.Infof(ctx, "Searching policies for %s matching %q", objectName, query)
+
+	objects := []string{objectName}
+	if !computerOnly {
+		objects = []string{m.hostname, objectName}
+	}
+
+	var out strings.Builder
+	w := tabwriter.NewWriter(&out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, i18n.G("OBJECT\tGPO\tMANAGER\tKEY\tVALUE"))
+	var reportOnlyOut strings.Builder
+	reportOnlyW := tabwriter.NewWriter(&reportOnlyOut, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(reportOnlyW, i18n.G("OBJECT\tGPO\tMANAGER\tKEY\tVALUE"))
+
+	lowerQuery := strings.ToLower(query)
+	// matchValue returns the value of r to print if its key or value matches the query.
+	matchValue := func(r entry.Entry) (string, bool) {
+		// Trim EOL \n and replace them all with \n in text to keep each value printed in one single line
+		v := strings.ReplaceAll(strings.TrimSpace(r.Value), "\n", `\n`)
+		if !strings.Contains(strings.ToLower(r.Key), lowerQuery) && (r.Disabled || !strings.Contains(strings.ToLower(v), lowerQuery)) {
+			return "", false
+		}
+		if r.Disabled {
+			v = i18n.G("(disabled)")
+		}
+		return v, true
+	}
+
+	var found, reportOnlyFound bool
+	resolver := newOverrideResolver(m.precedence)
+	for _, object := range objects {
+		pols, err := NewFromCache(ctx, m.objectPath(PoliciesCacheBaseName, object))
+		if err != nil {
+			return "", fmt.Errorf(i18n.G("no policy applied for %q: %v"), object, err)
+		}
+		// Report-only entries are never applied: the entries of lower priority GPOs are enforced instead.
+		for _, g := range resolver.resolve(ctx, pols) {
+			for _, e := range g.entries {
+				if e.overridden && !e.ReportOnly {
+					continue
+				}
+				v, ok := matchValue(e.Entry)
+				if !ok {
+					continue
+				}
+				if e.ReportOnly {
+					reportOnlyFound = true
+					fmt.Fprintf(reportOnlyW, "%s\t%s\t%s\t%s\t%s\n", object, g.Name, e.manager, e.Key, v)
+					continue
+				}
+				found = true
+				fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", object, g.Name, e.manager, e.Key, v)
+			}
+		}
+	}
+	if !found && !reportOnlyFound {
+		return fmt.Sprintf(i18n.G("No applied policy entry matches %q.\n"), query), nil
+	}
+	if err := w.Flush(); err != nil {
+		return "", err
+	}
+	if err := reportOnlyW.Flush(); err != nil {
+		return "", err
+	}
+
+	msg = out.String()
+	if !found {
+		msg = fmt.Sprintf(i18n.G("No applied policy entry matches %q.\n"), query)
+	}
+	if reportOnlyFound {
+		msg += "\n" + i18n.G("Report-only entries, not applied:") + "\n" + reportOnlyOut.String()
+	}
+	return msg, nil
+}
+
+// WhoHas lists the users with cached policies receiving key, with value if not empty. key is the key of the entry,
+// optionally prefixed by its policy manager, like dconf/org/gnome/desktop/background/picture-uri.
+// Only the entries applied to each user are considered, not the ones overridden by a higher GPO.
+func (m *Manager) WhoHas(ctx context.Context, key, value string) (msg string, err error) {
+	defer decorate.OnError(&err, i18n.G("failed to list users receiving %q"), key)
+
+	log.Infof(ctx, "Listing users receiving %q", key)
+
+	objects, err := CachedObjects(m.cacheDir, PoliciesCacheBaseName)
+	if err != nil {
+		return "", err
+	}
+
+	var out strings.Builder
+	w := tabwriter.NewWriter(&out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, i18n.G("USER\tGPO\tMANAGER\tVALUE"))
+
+	var found bool
+	for _, object := range objects {
+		if object == m.hostname {
+			continue
+		}
+		pols, err := NewFromCache(ctx, m.objectPath(PoliciesCacheBaseName, object))
+		if err != nil {
+			log.Warningf(ctx, i18n.G("Skipping invalid policies cache of %q: %v"), object, err)
+			continue
+		}
+
+		// Report-only entries are not received by anyone.
+		for _, g := range newOverrideResolver(m.precedence).resolve(ctx, pols.enforced()) {
+			for _, e := range g.entries {
+				if e.overridden {
+					continue
+				}
+				if e.Key != key && filepath.Join(e.manager, e.Key) != key {
+					continue
+				}
+				// Trim EOL \n and replace them all with \n in text to keep each value printed in one single line
+				v := strings.ReplaceAll(strings.TrimSpace(e.Value), "\n", `\n`)
+				if e.Disabled {
+					v = i18n.G("(disabled)")
+				}
+				if value != "" && (e.Disabled || strings.TrimSpace(e.Value) != value) {
+					continue
+				}
+				found = true
+				fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", object, g.Name, e.manager, v)
+			}
+		}
+		if err := pols.Close(); err != nil {
+			log.Warningf(ctx, i18n.G("Can't close policies cache of %q: %v"), object, err)
+		}
+	}
+	if !found {
+		return fmt.Sprintf(i18n.G("No user receives %q.\n"), key), nil
+	}
+	if err := w.Flush(); err != nil {
+		return "", err
 	}
 
 	return out.String(), nil
@@ -397,7 +1852,7 @@ func (m *Manager) LastUpdateFor(ctx context.Context, objectName string, isMachin
 		objectName = m.hostname
 	}
 
-	info, err := os.Stat(filepath.Join(m.policiesCacheDir, objectName))
+	info, err := os.Stat(m.objectPath(PoliciesCacheBaseName, objectName))
 	if err != nil {
 		return time.Time{}, fmt.Errorf(i18n.G("policies were not applied for %q: %v"), objectName, err)
 	}
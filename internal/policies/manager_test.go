@@ -6,8 +6,11 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"net/url"
 	"os"
+	"os/user"
 	"path/filepath"
+	"regexp"
 	"strings"
 	"testing"
 	"time"
@@ -17,8 +20,11 @@ import (
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"github.com/termie/go-shutil"
+	"github.com/ubuntu/adsys/adsystest"
 	"github.com/ubuntu/adsys/internal/consts"
 	"github.com/ubuntu/adsys/internal/policies"
+	"github.com/ubuntu/adsys/internal/policies/entry"
+	"github.com/ubuntu/adsys/internal/policies/secrets"
 	"github.com/ubuntu/adsys/internal/testutils"
 )
 
@@ -30,9 +36,6 @@ func TestApplyPolicies(t *testing.T) {
 
 	bus := testutils.NewDbusConn(t)
 
-	subscriptionDbus := bus.Object(consts.SubscriptionDbusRegisteredName,
-		dbus.ObjectPath(consts.SubscriptionDbusObjectPath))
-
 	tests := map[string]struct {
 		policiesDir                     string
 		secondCallWithNoRules           bool
@@ -40,13 +43,45 @@ func TestApplyPolicies(t *testing.T) {
 		makeDirReadOnly                 string
 		isNotSubscribed                 bool
 		secondCallWithNoSubscription    bool
+		secondCallPurge                 bool
 		noUbuntuProxyManager            bool
-
-		wantErr bool
+		partialFailure                  bool
+		disabledManagers                []string
+		transformsDir                   string
+		cancelRequest                   bool
+		minFreeDiskSpace                uint64
+		previousStatus                  string
+		withHook                        bool
+		interfaceAddrs                  []string
+		withSecretResolver              bool
+		storeUnhandled                  bool
+		provisioning                    bool
+
+		wantUnhandledCount       int
+		wantDrasticChangeWarning bool
+		wantInvalidChoiceWarning bool
+		wantErr                  bool
 	}{
 		"Succeed": {policiesDir: "all_entry_types"},
+		"Policies are fully applied even if the request is canceled":             {policiesDir: "all_entry_types", cancelRequest: true},
 		"Second call with no rules deletes everything":                           {policiesDir: "all_entry_types", secondCallWithNoRules: true, scriptSessionEndedForSecondCall: true},
 		"Second call with no rules don't remove scripts if session hasn’t ended": {policiesDir: "all_entry_types", secondCallWithNoRules: true, scriptSessionEndedForSecondCall: false},
+		"Second call purging deletes everything and the policies state":          {policiesDir: "all_entry_types", secondCallPurge: true, scriptSessionEndedForSecondCall: true},
+
+		"Transformation rules are applied before the policy managers":    {policiesDir: "all_entry_types", transformsDir: "drop_privilege"},
+		"Disabled policy managers are skipped":                           {policiesDir: "all_entry_types", disabledManagers: []string{"apparmor", "mount", "scripts"}},
+		"Machine identity policy managers are skipped when provisioning": {policiesDir: "all_entry_types", provisioning: true},
+		"Warn on drastic change of the number of entries":                {policiesDir: "all_entry_types", previousStatus: "succeeded", wantDrasticChangeWarning: true},
+		"Hooks are run with the changed policy managers":                 {policiesDir: "all_entry_types", withHook: true, secondCallWithNoRules: true, scriptSessionEndedForSecondCall: true},
+		"Entries are filtered by their subnets condition":                {policiesDir: "dconf_subnets", interfaceAddrs: []string{"127.0.0.1/8", "10.1.2.3/24"}},
+		"Entries outside of any subnets condition are filtered":          {policiesDir: "dconf_subnets", interfaceAddrs: []string{"172.16.0.2/12"}},
+		"Link-local addresses are ignored by subnets conditions":         {policiesDir: "dconf_subnets", interfaceAddrs: []string{"169.254.1.2/16", "fe80::1/64"}},
+		"Expired entries are not applied":                                {policiesDir: "dconf_expiry"},
+		"Report-only entries are not applied":                            {policiesDir: "dconf_report_only"},
+		"Secrets are resolved only for the policy managers":              {policiesDir: "secrets", withSecretResolver: true},
+		"Unhandled entries are stored when enabled":                      {policiesDir: "dconf_unhandled", storeUnhandled: true, wantUnhandledCount: 2},
+		"Unhandled entries are not stored by default":                    {policiesDir: "dconf_unhandled"},
+		"Second call with no rules removes unhandled entries":            {policiesDir: "dconf_unhandled", storeUnhandled: true, secondCallWithNoRules: true},
 
 		// no subscription filterings
 		"No subscription is only dconf content":                                         {policiesDir: "all_entry_types", isNotSubscribed: true},
@@ -54,12 +89,20 @@ func TestApplyPolicies(t *testing.T) {
 		"Second call with no subscription don't remove scripts if session hasn’t ended": {policiesDir: "all_entry_types", secondCallWithNoSubscription: true, scriptSessionEndedForSecondCall: false},
 
 		// Error cases
-		"Error when applying dconf policy":     {policiesDir: "dconf_failing", wantErr: true},
-		"Error when applying privilege policy": {makeDirReadOnly: "etc/sudoers.d", policiesDir: "all_entry_types", wantErr: true},
-		"Error when applying scripts policy":   {makeDirReadOnly: "run/adsys/machine", policiesDir: "all_entry_types", wantErr: true},
-		"Error when applying apparmor policy":  {makeDirReadOnly: "etc/apparmor.d/adsys", policiesDir: "all_entry_types", wantErr: true},
-		"Error when applying mount policy":     {makeDirReadOnly: "etc/systemd/system", policiesDir: "all_entry_types", wantErr: true},
-		"Error when applying proxy policy":     {noUbuntuProxyManager: true, policiesDir: "all_entry_types", wantErr: true},
+		"Error when applying dconf policy":                               {policiesDir: "dconf_failing", wantErr: true},
+		"Error on dconf value not allowed by its schema reports the GPO": {policiesDir: "dconf_invalid_choice", wantInvalidChoiceWarning: true, wantErr: true},
+		"Error when applying privilege policy":                           {makeDirReadOnly: "etc/sudoers.d", policiesDir: "all_entry_types", wantErr: true},
+		"Error when applying scripts policy":                             {makeDirReadOnly: "run/adsys/machine", policiesDir: "all_entry_types", wantErr: true},
+		"Error when applying apparmor policy":                            {makeDirReadOnly: "etc/apparmor.d/adsys", policiesDir: "all_entry_types", wantErr: true},
+		"Error when applying mount policy":                               {makeDirReadOnly: "etc/systemd/system", policiesDir: "all_entry_types", wantErr: true},
+		"Error when applying proxy policy":                               {noUbuntuProxyManager: true, policiesDir: "all_entry_types", wantErr: true},
+		"Error on invalid transformation rules":                          {transformsDir: "invalid", policiesDir: "all_entry_types", wantErr: true},
+		"Error on not enough disk space":                                 {minFreeDiskSpace: 1 << 62, policiesDir: "all_entry_types", wantErr: true},
+
+		// Partial failure cases
+		"Other policy managers are applied when one fails":             {noUbuntuProxyManager: true, policiesDir: "all_entry_types", partialFailure: true, wantErr: true},
+		"Error on unresolvable secret only fails its policy manager":   {policiesDir: "secrets", partialFailure: true, wantErr: true},
+		"Error on secret referenced by dconf entries only fails dconf": {policiesDir: "dconf_secrets", withSecretResolver: true, partialFailure: true, wantErr: true},
 	}
 	for name, tc := range tests {
 		tc := tc
@@ -72,14 +115,8 @@ func TestApplyPolicies(t *testing.T) {
 			require.NoError(t, err, "Setup: can not load policies list")
 			defer pols.Close()
 
-			fakeRootDir := t.TempDir()
-			cacheDir := filepath.Join(fakeRootDir, "var", "cache", "adsys")
-			runDir := filepath.Join(fakeRootDir, "run", "adsys")
-			dconfDir := filepath.Join(fakeRootDir, "etc", "dconf")
-			policyKitDir := filepath.Join(fakeRootDir, "etc", "polkit-1")
-			sudoersDir := filepath.Join(fakeRootDir, "etc", "sudoers.d")
-			apparmorDir := filepath.Join(fakeRootDir, "etc", "apparmor.d", "adsys")
-			systemUnitDir := filepath.Join(fakeRootDir, "etc", "systemd", "system")
+			root := adsystest.NewFakeRoot(t)
+			fakeRootDir, cacheDir, runDir, hooksDir := root.Dir, root.CacheDir, root.RunDir, root.HooksDir
 			loadedPoliciesFile := filepath.Join(fakeRootDir, "sys", "kernel", "security", "apparmor", "profiles")
 
 			err = os.MkdirAll(filepath.Dir(loadedPoliciesFile), 0700)
@@ -87,34 +124,65 @@ func TestApplyPolicies(t *testing.T) {
 			err = os.WriteFile(loadedPoliciesFile, []byte("someprofile (enforce)\n"), 0600)
 			require.NoError(t, err, "Setup: can not create loadedPoliciesFile")
 
-			status := true
-			if tc.isNotSubscribed {
-				status = false
+			if tc.withHook {
+				require.NoError(t, os.MkdirAll(hooksDir, 0750), "Setup: can not create hooks directory")
+				// The hook records each call in a dot file, which is not executed as a hook.
+				hook := "#!/bin/sh\n" + `echo "${ADSYS_TARGET} ${ADSYS_IS_COMPUTER}: ${ADSYS_CHANGED_MANAGERS}" >> "$(dirname "$0")/.calls"` + "\n"
+				require.NoError(t, os.WriteFile(filepath.Join(hooksDir, "record"), []byte(hook), 0700), "Setup: can not create hook")
 			}
-			require.NoError(t, subscriptionDbus.SetProperty(consts.SubscriptionDbusInterface+".Attached", status), "Setup: can not set subscription status to %q", status)
-			defer func() {
-				require.NoError(t, subscriptionDbus.SetProperty(consts.SubscriptionDbusInterface+".Attached", false), "Teardown: can not restore subscription status")
-			}()
 
-			m, err := policies.NewManager(bus,
-				hostname,
+			adsystest.SetSubscriptionAttached(t, bus, !tc.isNotSubscribed)
+
+			opts := []policies.Option{
 				policies.WithCacheDir(cacheDir),
 				policies.WithRunDir(runDir),
-				policies.WithDconfDir(dconfDir),
-				policies.WithPolicyKitDir(policyKitDir),
-				policies.WithSudoersDir(sudoersDir),
-				policies.WithApparmorDir(apparmorDir),
+				policies.WithDconfDir(root.DconfDir),
+				policies.WithPolicyKitDir(root.PolicyKitDir),
+				policies.WithSudoersDir(root.SudoersDir),
+				policies.WithApparmorDir(root.ApparmorDir),
 				policies.WithApparmorFsDir(filepath.Dir(loadedPoliciesFile)),
 				policies.WithApparmorParserCmd([]string{"/bin/true"}),
-				policies.WithSystemUnitDir(systemUnitDir),
+				policies.WithSystemUnitDir(root.SystemUnitDir),
+				policies.WithGPPRootDir(fakeRootDir),
+				policies.WithSSSDConf(root.SSSDConf),
+				policies.WithNetplanDir(root.NetplanDir),
+				policies.WithJournaldConfDir(root.JournaldDir),
+				policies.WithNetplanCmd([]string{"/bin/true"}),
+				policies.WithPluginsDir(root.PluginsDir),
+				policies.WithHooksDir(hooksDir),
+				policies.WithGSettingsSchemasDir(filepath.Join("testdata", "schemas")),
+				policies.WithTransformsDir(filepath.Join("testdata", "transforms", tc.transformsDir)),
 				policies.WithProxyApplier(&mockProxyApplier{wantApplyError: tc.noUbuntuProxyManager}),
 				policies.WithSystemdCaller(&testutils.MockSystemdCaller{}),
-			)
+			}
+			if tc.minFreeDiskSpace != 0 {
+				opts = append(opts, policies.WithMinFreeDiskSpace(tc.minFreeDiskSpace))
+			}
+			if tc.disabledManagers != nil {
+				opts = append(opts, policies.WithDisabledManagers(tc.disabledManagers))
+			}
+			if tc.interfaceAddrs != nil {
+				opts = append(opts, policies.WithInterfaceAddrs(tc.interfaceAddrs...))
+			}
+			if tc.withSecretResolver {
+				opts = append(opts, policies.WithSecretResolver("test", secrets.ResolverFunc(func(_ context.Context, u *url.URL) (string, error) {
+					return "secret-" + u.Host, nil
+				})))
+			}
+			if tc.storeUnhandled {
+				opts = append(opts, policies.WithUnhandledEntries(true))
+			}
+			m, err := policies.NewManager(bus, hostname, opts...)
 			require.NoError(t, err, "Setup: couldn’t get a new policy manager")
 
 			err = os.MkdirAll(filepath.Join(cacheDir, policies.PoliciesCacheBaseName), 0750)
 			require.NoError(t, err, "Setup: cannot create policies cache directory")
 
+			if tc.previousStatus != "" {
+				err := shutil.CopyFile(filepath.Join("testdata", "cache", "status", tc.previousStatus), filepath.Join(cacheDir, policies.StatusCacheBaseName, "hostname"), false)
+				require.NoError(t, err, "Setup: couldn’t copy previous status")
+			}
+
 			if tc.makeDirReadOnly != "" {
 				require.NoError(t, os.MkdirAll(filepath.Join(fakeRootDir, tc.makeDirReadOnly), 0750), "Setup: can not create directory")
 				testutils.MakeReadOnly(t, filepath.Join(fakeRootDir, tc.makeDirReadOnly))
@@ -126,7 +194,18 @@ func TestApplyPolicies(t *testing.T) {
 			orig := logrus.StandardLogger().Out
 			logrus.StandardLogger().SetOutput(w)
 
-			err = m.ApplyPolicies(context.Background(), "hostname", true, &pols)
+			ctx, cancel := context.WithCancel(context.Background())
+			if tc.cancelRequest {
+				cancel()
+			}
+			var applyOpts []policies.ApplyOption
+			if tc.provisioning {
+				applyOpts = append(applyOpts, policies.WhenProvisioning())
+			}
+			err = m.ApplyPolicies(ctx, "hostname", true, &pols, applyOpts...)
+			cancel()
+			// Wait for the hooks running in the background.
+			m.Wait()
 
 			logrus.StandardLogger().SetOutput(orig)
 			w.Close()
@@ -139,16 +218,28 @@ func TestApplyPolicies(t *testing.T) {
 				want := fmt.Sprintf("Rules from the following policy types will be filtered out as the machine is not enrolled to Ubuntu Pro: %s", strings.Join(policies.ProOnlyRules, ", "))
 				require.Contains(t, out.String(), want, "ApplyPolicy should have logged the filtered rules")
 			}
+			if tc.wantDrasticChangeWarning {
+				require.Contains(t, out.String(), "Policy manager dconf now handles 2 entries for hostname, compared to 12 on the previous apply", "ApplyPolicy should have warned about the drastic change")
+			} else {
+				require.NotContains(t, out.String(), "on the previous apply", "ApplyPolicy should not have warned about a drastic change")
+			}
+
+			if tc.wantInvalidChoiceWarning {
+				require.Contains(t, out.String(), `Value \"huge\" of dconf key path/to/key-enum set by GPOName ({GPOId}) is not allowed: it must be one of small, large`, "ApplyPolicy should have reported the GPO setting the invalid value")
+			}
 
 			if tc.wantErr {
 				require.Error(t, err, "ApplyPolicy should return an error but got none")
+				if tc.partialFailure {
+					testutils.CompareTreesWithFiltering(t, fakeRootDir, testutils.GoldenPath(t), testutils.Update())
+				}
 				return
 			}
 			require.NoError(t, err, "ApplyPolicy should return no error but got one")
 
 			// Fake starting scripts session when we ran scripts
 			runningFlag := filepath.Join(runDir, "machine", "scripts", ".running")
-			if !tc.isNotSubscribed && tc.policiesDir != "dconf_failing" {
+			if !tc.isNotSubscribed && tc.policiesDir == "all_entry_types" && tc.disabledManagers == nil {
 				require.NoError(t, os.WriteFile(runningFlag, nil, 0600), "Setup: can't mimick session in progress")
 			}
 
@@ -163,275 +254,2549 @@ func TestApplyPolicies(t *testing.T) {
 				require.NoError(t, err, "Setup: can not empty policies before second call")
 			} else if tc.secondCallWithNoSubscription {
 				runSecondCall = true
-				require.NoError(t, subscriptionDbus.SetProperty(consts.SubscriptionDbusInterface+".Attached", false), "Setup: can not set subscription status for second call to disabled")
+				adsystest.SetSubscriptionAttached(t, bus, false)
 			}
 			if runSecondCall {
 				err = m.ApplyPolicies(context.Background(), "hostname", true, &pols)
 				require.NoError(t, err, "ApplyPolicy should return no error but got one")
 			}
+			if tc.secondCallPurge {
+				err = m.Purge(context.Background(), "hostname", true)
+				require.NoError(t, err, "Purge should return no error but got one")
+			}
+
+			n, err := m.UnhandledEntriesCount("hostname", false)
+			require.NoError(t, err, "UnhandledEntriesCount should return no error but got one")
+			require.Equal(t, tc.wantUnhandledCount, n, "UnhandledEntriesCount should return the number of unhandled entries")
 
+			m.Wait()
 			testutils.CompareTreesWithFiltering(t, fakeRootDir, testutils.GoldenPath(t), testutils.Update())
 		})
 	}
 }
 
-func TestDumpPolicies(t *testing.T) {
-	t.Parallel()
+func TestApplyPoliciesDryRun(t *testing.T) {
+	// We change the dbus returned values to simulate a subscription
+	//t.Parallel()
 
 	bus := testutils.NewDbusConn(t)
 
-	hostname, err := os.Hostname()
-	require.NoError(t, err, "Setup: failed to get hostname")
-
 	tests := map[string]struct {
-		cachePoliciesUser  string
-		cachePolicyMachine string
-		target             string
-		computerOnly       bool
-		withRules          bool
-		withOverridden     bool
+		appliedPolicies string
+		policiesDir     string
+		transformsDir   string
+		isNotSubscribed bool
 
 		wantErr bool
 	}{
-		"One GPO User": {
-			cachePoliciesUser: "one_gpo",
-		},
-		"One GPO Machine": {
-			cachePolicyMachine: "one_gpo",
-			target:             hostname,
-			computerOnly:       true,
-		},
-		"One GPO User + Machine": {
-			cachePoliciesUser:  "one_gpo",
-			cachePolicyMachine: "one_gpo_other",
-		},
-		"Multiple GPOs": {
-			cachePoliciesUser: "two_gpos_no_override",
-		},
-
-		// Show rules
-		"One GPO with rules": {
-			cachePoliciesUser: "one_gpo",
-			withRules:         true,
-		},
-		"Machine only GPO with rules": {
-			cachePolicyMachine: "one_gpo",
-			target:             hostname,
-			computerOnly:       true,
-			withRules:          true,
-		},
-		"Multiple GPOs with rules, no override": {
-			cachePoliciesUser: "two_gpos_no_override",
-			withRules:         true,
-		},
-		"Multiple GPOs with rules, override hidden": {
-			cachePoliciesUser: "two_gpos_with_overrides",
-			withRules:         true,
-		},
-		"Multiple GPOs with rules, override, shown": {
-			cachePoliciesUser: "two_gpos_with_overrides",
-			withRules:         true,
-			withOverridden:    true,
-		},
-
-		// machine and user GPO with overrides between machine and user
-		"Overrides between machine and user GPOs, hidden": {
-			cachePoliciesUser:  "one_gpo",
-			cachePolicyMachine: "two_gpos_override_one_gpo",
-			withRules:          true,
-		},
-		"Overrides between machine and user GPOs, shown": {
-			cachePoliciesUser:  "one_gpo",
-			cachePolicyMachine: "two_gpos_override_one_gpo",
-			withRules:          true,
-			withOverridden:     true,
-		},
-
-		// Edge cases
-		"Same GPO Machine and User": {
-			cachePoliciesUser:  "one_gpo",
-			cachePolicyMachine: "one_gpo",
-		},
-		"Same GPO Machine and User with rules": {
-			cachePoliciesUser:  "one_gpo",
-			cachePolicyMachine: "one_gpo",
-			withRules:          true,
-		},
-		"Same GPO Machine and User with rules and overrides": {
-			cachePoliciesUser:  "one_gpo",
-			cachePolicyMachine: "one_gpo",
-			withRules:          true,
-			withOverridden:     true,
+		"Policies never applied are all added":              {policiesDir: "one_gpo"},
+		"No change":                                         {appliedPolicies: "one_gpo", policiesDir: "one_gpo"},
+		"Added and removed entries":                         {appliedPolicies: "one_gpo", policiesDir: "one_gpo_other"},
+		"Changed entries":                                   {appliedPolicies: "one_gpo", policiesDir: "simple"},
+		"Purge lists all entries as removed":                {appliedPolicies: "one_gpo"},
+		"Pro only entries are filtered when not subscribed": {policiesDir: "one_gpo_other", isNotSubscribed: true},
+		"Report-only changes are listed separately":         {appliedPolicies: "one_gpo", policiesDir: "dconf_report_only"},
+		"Transformation rules are applied to applied and new policies": {
+			appliedPolicies: "one_gpo",
+			policiesDir:     "simple",
+			transformsDir:   "set_dconf_key1",
 		},
 
 		// Error cases
-		"Error on missing target cache": {
-			wantErr: true,
-		},
-		"Error on missing machine cache when targeting user": {
-			cachePoliciesUser:  "one_gpo",
-			cachePolicyMachine: "-",
-			wantErr:            true,
-		},
+		"Error on invalid transformation rules": {policiesDir: "one_gpo", transformsDir: "invalid", wantErr: true},
+		"Error on invalid applied policies":     {appliedPolicies: "invalid_policies_cache", policiesDir: "one_gpo", wantErr: true},
 	}
-
 	for name, tc := range tests {
 		tc := tc
 		name := name
 		t.Run(name, func(t *testing.T) {
-			t.Parallel()
-
-			cacheDir, runDir := t.TempDir(), t.TempDir()
-			m, err := policies.NewManager(bus, hostname, policies.WithCacheDir(cacheDir), policies.WithRunDir(runDir))
-			require.NoError(t, err, "Setup: couldn’t get a new policy manager")
+			pols, err := policies.New(context.Background(), nil, "")
+			require.NoError(t, err, "Setup: can not create empty policies")
+			if tc.policiesDir != "" {
+				pols, err = policies.NewFromCache(context.Background(), filepath.Join("testdata", "cache", "policies", tc.policiesDir))
+				require.NoError(t, err, "Setup: can not load policies list")
+				defer pols.Close()
+			}
 
+			fakeRootDir := t.TempDir()
+			cacheDir := filepath.Join(fakeRootDir, "var", "cache", "adsys")
+			appliedCacheDir := filepath.Join(cacheDir, policies.PoliciesCacheBaseName, "hostname")
 			err = os.MkdirAll(filepath.Join(cacheDir, policies.PoliciesCacheBaseName), 0750)
-			require.NoError(t, err, "Setup: cant not create policies cache directory")
-
-			if tc.cachePoliciesUser != "" {
-				err := shutil.CopyTree(filepath.Join("testdata", "cache", "policies", tc.cachePoliciesUser), filepath.Join(cacheDir, policies.PoliciesCacheBaseName, "user"), nil)
-				require.NoError(t, err, "Setup: couldn’t copy user policies cache")
-			}
-			if tc.cachePolicyMachine == "" {
-				machinePolicyCache := filepath.Join(cacheDir, policies.PoliciesCacheBaseName, hostname)
-				err = os.MkdirAll(machinePolicyCache, 0750)
-				require.NoError(t, err, "Setup: cant not create machine policies cache directory")
-				f, err := os.Create(filepath.Join(machinePolicyCache, "policies"))
-				require.NoError(t, err, "Setup: failed to create empty machine policies cache")
-				f.Close()
-			} else if tc.cachePolicyMachine != "-" {
-				err := shutil.CopyTree(filepath.Join("testdata", "cache", "policies", tc.cachePolicyMachine), filepath.Join(cacheDir, policies.PoliciesCacheBaseName, hostname), nil)
-				require.NoError(t, err, "Setup: couldn’t copy machine policies cache")
+			require.NoError(t, err, "Setup: cannot create policies cache directory")
+			if tc.appliedPolicies != "" {
+				err := shutil.CopyTree(filepath.Join("testdata", "cache", "policies", tc.appliedPolicies), appliedCacheDir, nil)
+				require.NoError(t, err, "Setup: couldn’t copy applied policies cache")
 			}
 
-			if tc.target == "" {
-				tc.target = "user"
-			}
-			got, err := m.DumpPolicies(context.Background(), tc.target, tc.computerOnly, tc.withRules, tc.withOverridden)
+			adsystest.SetSubscriptionAttached(t, bus, !tc.isNotSubscribed)
+
+			m, err := policies.NewManager(bus, "hostname",
+				policies.WithCacheDir(cacheDir),
+				policies.WithRunDir(filepath.Join(fakeRootDir, "run", "adsys")),
+				policies.WithDconfDir(filepath.Join(fakeRootDir, "etc", "dconf")),
+				policies.WithPolicyKitDir(filepath.Join(fakeRootDir, "etc", "polkit-1")),
+				policies.WithSudoersDir(filepath.Join(fakeRootDir, "etc", "sudoers.d")),
+				policies.WithApparmorDir(filepath.Join(fakeRootDir, "etc", "apparmor.d", "adsys")),
+				policies.WithSystemUnitDir(filepath.Join(fakeRootDir, "etc", "systemd", "system")),
+				policies.WithGPPRootDir(fakeRootDir),
+				policies.WithSSSDConf(filepath.Join(fakeRootDir, "etc", "sssd", "sssd.conf")),
+				policies.WithNetplanDir(filepath.Join(fakeRootDir, "etc", "netplan")),
+				policies.WithJournaldConfDir(filepath.Join(fakeRootDir, "etc", "systemd", "journald.conf.d")),
+				policies.WithNetplanCmd([]string{"/bin/true"}),
+				policies.WithTransformsDir(filepath.Join("testdata", "transforms", tc.transformsDir)),
+				policies.WithSystemdCaller(&testutils.MockSystemdCaller{}),
+			)
+			require.NoError(t, err, "Setup: couldn’t get a new policy manager")
+
+			var out strings.Builder
+			err = m.ApplyPolicies(context.Background(), "hostname", true, &pols, policies.WithDryRun(&out))
 			if tc.wantErr {
-				require.Error(t, err, "DumpPolicies should return an error but got none")
+				require.Error(t, err, "ApplyPolicies should return an error but got none")
 				return
 			}
-			require.NoError(t, err, "DumpPolicies should return no error but got one")
+			require.NoError(t, err, "ApplyPolicies should return no error but got one")
 
+			got := out.String()
 			want := testutils.LoadWithUpdateFromGolden(t, got)
-			require.Equal(t, want, got, "DumpPolicies returned expected output")
+			require.Equal(t, want, got, "ApplyPolicies should print the expected policy changes")
+
+			// Nothing is applied: only the policy manager directories, created empty, exist and the cache is unchanged.
+			require.NoDirExists(t, filepath.Join(fakeRootDir, "etc", "dconf", "db"), "Dry run should not apply dconf policies")
+			require.NoFileExists(t, filepath.Join(fakeRootDir, "run", "adsys", consts.PolicyReadyFlagName), "Dry run should not signal applied policies")
+			if tc.appliedPolicies == "" {
+				require.NoDirExists(t, appliedCacheDir, "Dry run should not cache the policies")
+				return
+			}
+			wantCache, err := os.ReadFile(filepath.Join("testdata", "cache", "policies", tc.appliedPolicies, "policies"))
+			require.NoError(t, err, "Setup: can't read applied policies cache")
+			gotCache, err := os.ReadFile(filepath.Join(appliedCacheDir, "policies"))
+			require.NoError(t, err, "Applied policies cache should still exist")
+			require.Equal(t, string(wantCache), string(gotCache), "Dry run should not modify the policies cache")
 		})
 	}
 }
 
-func TestLastUpdateFor(t *testing.T) {
-	t.Parallel()
+func TestApplyPoliciesUserNotifications(t *testing.T) {
+	//t.Parallel()
 
 	bus := testutils.NewDbusConn(t)
 
-	hostname, err := os.Hostname()
-	require.NoError(t, err, "Setup: failed to get hostname")
+	u, err := user.Current()
+	require.NoError(t, err, "Setup: can't get current user")
+
+	key1 := entry.Entry{Key: "path/to/key1", Value: "ValueOfKey1", Meta: "s"}
+	key2 := entry.Entry{Key: "path/to/key2", Value: "ValueOfKey2", Meta: "s"}
+	key2Changed := entry.Entry{Key: "path/to/key2", Value: "OtherValueOfKey2", Meta: "s"}
+	key3 := entry.Entry{Key: "path/to/key3", Value: "ValueOfKey3", Meta: "s"}
 
 	tests := map[string]struct {
-		target    string
-		isMachine bool
+		applied             []entry.Entry
+		entries             []entry.Entry
+		noUserNotifications bool
+		completedLate       bool
 
-		wantErr bool
+		wantNotification bool
 	}{
-		"Returns user's last update time":       {target: "user"},
-		"Returns machine's last update time":    {target: hostname, isMachine: true},
-		"Target is ignored for machine request": {target: "does_not_exit", isMachine: true},
-
-		// Error cases
-		"Error when target does not exist": {target: "does_not_exit", wantErr: true},
+		"New dconf locks are notified":            {applied: []entry.Entry{key1}, entries: []entry.Entry{key1, key2, key3}, wantNotification: true},
+		"dconf locks to a new value are notified": {applied: []entry.Entry{key1, key2}, entries: []entry.Entry{key1, key2Changed}, wantNotification: true},
+		"Only new restrictions are notified":      {applied: []entry.Entry{key1, key2}, entries: []entry.Entry{key2, key3}, wantNotification: true},
+
+		"No notification without new restriction": {applied: []entry.Entry{key1, key2}, entries: []entry.Entry{key1, key2}},
+		"No notification when removing locks":     {applied: []entry.Entry{key1, key2}, entries: []entry.Entry{key1}},
+		"No notification on first apply":          {entries: []entry.Entry{key1, key2}},
+		"No notification when disabled":           {applied: []entry.Entry{key1}, entries: []entry.Entry{key1, key2}, noUserNotifications: true},
+
+		"Refresh completed after the session started lists new restrictions": {applied: []entry.Entry{key1}, entries: []entry.Entry{key1, key2}, completedLate: true, wantNotification: true},
+		"Refresh completed after the session started is always notified":     {applied: []entry.Entry{key1}, entries: []entry.Entry{key1}, completedLate: true, wantNotification: true},
+		"Refresh completed after the session started without notifications":  {applied: []entry.Entry{key1}, entries: []entry.Entry{key1, key2}, noUserNotifications: true, completedLate: true, wantNotification: true},
 	}
-
 	for name, tc := range tests {
 		tc := tc
-		name := name
 		t.Run(name, func(t *testing.T) {
-			t.Parallel()
+			fakeRootDir := t.TempDir()
+			cacheDir := filepath.Join(fakeRootDir, "var", "cache", "adsys")
+			runDir := filepath.Join(fakeRootDir, "run", "adsys")
+			err := os.MkdirAll(filepath.Join(cacheDir, policies.PoliciesCacheBaseName), 0750)
+			require.NoError(t, err, "Setup: cannot create policies cache directory")
+			if tc.applied != nil {
+				applied, err := policies.New(context.Background(), []policies.GPO{{ID: "{GPOId}", Name: "GPOName", Rules: map[string][]entry.Entry{"dconf": tc.applied}}}, "")
+				require.NoError(t, err, "Setup: can not create applied policies")
+				err = applied.Save(filepath.Join(cacheDir, policies.PoliciesCacheBaseName, u.Username))
+				require.NoError(t, err, "Setup: can not save applied policies")
+			}
 
-			cacheDir, runDir := t.TempDir(), t.TempDir()
-			m, err := policies.NewManager(bus, hostname, policies.WithCacheDir(cacheDir), policies.WithRunDir(runDir))
+			m, err := policies.NewManager(bus, "hostname",
+				policies.WithCacheDir(cacheDir),
+				policies.WithRunDir(runDir),
+				policies.WithDconfDir(filepath.Join(fakeRootDir, "etc", "dconf")),
+				policies.WithPolicyKitDir(filepath.Join(fakeRootDir, "etc", "polkit-1")),
+				policies.WithSudoersDir(filepath.Join(fakeRootDir, "etc", "sudoers.d")),
+				policies.WithApparmorDir(filepath.Join(fakeRootDir, "etc", "apparmor.d", "adsys")),
+				policies.WithSystemUnitDir(filepath.Join(fakeRootDir, "etc", "systemd", "system")),
+				policies.WithGPPRootDir(fakeRootDir),
+				policies.WithSSSDConf(filepath.Join(fakeRootDir, "etc", "sssd", "sssd.conf")),
+				policies.WithNetplanDir(filepath.Join(fakeRootDir, "etc", "netplan")),
+				policies.WithJournaldConfDir(filepath.Join(fakeRootDir, "etc", "systemd", "journald.conf.d")),
+				policies.WithNetplanCmd([]string{"/bin/true"}),
+				policies.WithSystemdCaller(&testutils.MockSystemdCaller{}),
+				policies.WithUserNotifications(!tc.noUserNotifications),
+			)
 			require.NoError(t, err, "Setup: couldn’t get a new policy manager")
 
-			err = os.MkdirAll(filepath.Join(cacheDir, policies.PoliciesCacheBaseName), 0750)
-			require.NoError(t, err, "Setup: cant not create policies cache directory")
-
-			start := time.Now()
-			// Starts and ends are monotic, while os.Stat is wall clock, we have to wait for measuring difference…
-			time.Sleep(100 * time.Millisecond)
-			f, err := os.Create(filepath.Join(cacheDir, policies.PoliciesCacheBaseName, "user"))
-			require.NoError(t, err, "Setup: couldn’t copy user cache")
-			f.Close()
-			f, err = os.Create(filepath.Join(cacheDir, policies.PoliciesCacheBaseName, hostname))
-			require.NoError(t, err, "Setup: couldn’t copy user cache")
-			f.Close()
-
-			got, err := m.LastUpdateFor(context.Background(), tc.target, tc.isMachine)
-			if tc.wantErr {
-				require.Error(t, err, "LastUpdateFor should return an error but got none")
+			// The machine dconf database is needed to apply user policies.
+			machinePols, err := policies.New(context.Background(), nil, "")
+			require.NoError(t, err, "Setup: can not create machine policies")
+			err = m.ApplyPolicies(context.Background(), "hostname", true, &machinePols)
+			require.NoError(t, err, "Setup: can't apply machine policies")
+
+			pols, err := policies.New(context.Background(), []policies.GPO{{ID: "{GPOId}", Name: "GPOName", Rules: map[string][]entry.Entry{"dconf": tc.entries}}}, "")
+			require.NoError(t, err, "Setup: can not create policies")
+			err = m.ApplyPolicies(context.Background(), u.Username, false, &pols,
+				policies.WithCompletionNotification(func() bool { return tc.completedLate }))
+			require.NoError(t, err, "ApplyPolicies should return no error but got one")
+
+			notificationPath := filepath.Join(runDir, "notifications", u.Uid)
+			if !tc.wantNotification {
+				require.NoFileExists(t, notificationPath, "ApplyPolicies should not notify the user")
 				return
 			}
-			require.NoError(t, err, "LastUpdateFor should return no error but got one")
-			end := time.Now()
-
-			assert.True(t, got.After(start), "expected got after start")
-			assert.True(t, got.Before(end), "expected got before end")
+			got, err := os.ReadFile(notificationPath)
+			require.NoError(t, err, "ApplyPolicies should have written a notification")
+			want := testutils.LoadWithUpdateFromGolden(t, string(got))
+			require.Equal(t, want, string(got), "ApplyPolicies should notify the new restrictions")
 		})
 	}
 }
 
-func TestGetSubscriptionState(t *testing.T) {
+func TestApplyPoliciesRevokedPrivileges(t *testing.T) {
 	//t.Parallel()
 
 	bus := testutils.NewDbusConn(t)
-	subscriptionDbus := bus.Object(consts.SubscriptionDbusRegisteredName,
-		dbus.ObjectPath(consts.SubscriptionDbusObjectPath))
+	// Privileges are only applied with Ubuntu Pro.
+	adsystest.SetSubscriptionAttached(t, bus, true)
 
-	hostname, err := os.Hostname()
-	require.NoError(t, err, "Setup: failed to get hostname for tests.")
+	u, err := user.Current()
+	require.NoError(t, err, "Setup: can't get current user")
+
+	noLocalAdmins := entry.Entry{Key: "allow-local-admins", Disabled: true}
+	clientAdmin := entry.Entry{Key: "client-admins", Value: u.Username}
 
 	tests := map[string]struct {
-		status bool
+		applied             []entry.Entry
+		entries             []entry.Entry
+		noUserCache         bool
+		noUserNotifications bool
 
-		want bool
+		wantNotification bool
 	}{
-		"Returns enablement status (enabled)":  {status: true, want: true},
-		"Returns enablement status (disabled)": {status: false, want: false},
-	}
+		"Revoked administrator privileges are notified": {applied: []entry.Entry{noLocalAdmins, clientAdmin}, entries: []entry.Entry{noLocalAdmins}, wantNotification: true},
 
+		"No notification when privileges are kept":    {applied: []entry.Entry{noLocalAdmins, clientAdmin}, entries: []entry.Entry{noLocalAdmins, clientAdmin}},
+		"No notification when privileges are granted": {applied: []entry.Entry{noLocalAdmins}, entries: []entry.Entry{noLocalAdmins, clientAdmin}},
+		"No notification on first apply":              {entries: []entry.Entry{noLocalAdmins}},
+		"No notification for users without policies":  {applied: []entry.Entry{noLocalAdmins, clientAdmin}, entries: []entry.Entry{noLocalAdmins}, noUserCache: true},
+		"No notification when disabled":               {applied: []entry.Entry{noLocalAdmins, clientAdmin}, entries: []entry.Entry{noLocalAdmins}, noUserNotifications: true},
+	}
 	for name, tc := range tests {
 		tc := tc
-		name := name
 		t.Run(name, func(t *testing.T) {
-			// We change the dbus returned values to simulate a subscription
-			//t.Parallel()
-
-			require.NoError(t, subscriptionDbus.SetProperty(consts.SubscriptionDbusInterface+".Attached", tc.status), "Setup: can not set subscription status to %q", tc.status)
-			defer func() {
-				require.NoError(t, subscriptionDbus.SetProperty(consts.SubscriptionDbusInterface+".Attached", false), "Teardown: can not restore subscription status")
-			}()
+			fakeRootDir := t.TempDir()
+			cacheDir := filepath.Join(fakeRootDir, "var", "cache", "adsys")
+			runDir := filepath.Join(fakeRootDir, "run", "adsys")
+			err := os.MkdirAll(filepath.Join(cacheDir, policies.PoliciesCacheBaseName), 0750)
+			require.NoError(t, err, "Setup: cannot create policies cache directory")
+			if tc.applied != nil {
+				applied, err := policies.New(context.Background(), []policies.GPO{{ID: "{GPOId}", Name: "GPOName", Rules: map[string][]entry.Entry{"privilege": tc.applied}}}, "")
+				require.NoError(t, err, "Setup: can not create applied policies")
+				err = applied.Save(filepath.Join(cacheDir, policies.PoliciesCacheBaseName, "hostname"))
+				require.NoError(t, err, "Setup: can not save applied policies")
+			}
+			if !tc.noUserCache {
+				userPols, err := policies.New(context.Background(), nil, "")
+				require.NoError(t, err, "Setup: can not create user policies")
+				err = userPols.Save(filepath.Join(cacheDir, policies.PoliciesCacheBaseName, u.Username))
+				require.NoError(t, err, "Setup: can not save user policies")
+			}
 
-			cacheDir, runDir := t.TempDir(), t.TempDir()
-			m, err := policies.NewManager(bus, hostname, policies.WithCacheDir(cacheDir), policies.WithRunDir(runDir))
+			m, err := policies.NewManager(bus, "hostname",
+				policies.WithCacheDir(cacheDir),
+				policies.WithRunDir(runDir),
+				policies.WithDconfDir(filepath.Join(fakeRootDir, "etc", "dconf")),
+				policies.WithPolicyKitDir(filepath.Join(fakeRootDir, "etc", "polkit-1")),
+				policies.WithSudoersDir(filepath.Join(fakeRootDir, "etc", "sudoers.d")),
+				policies.WithApparmorDir(filepath.Join(fakeRootDir, "etc", "apparmor.d", "adsys")),
+				policies.WithSystemUnitDir(filepath.Join(fakeRootDir, "etc", "systemd", "system")),
+				policies.WithGPPRootDir(fakeRootDir),
+				policies.WithSSSDConf(filepath.Join(fakeRootDir, "etc", "sssd", "sssd.conf")),
+				policies.WithNetplanDir(filepath.Join(fakeRootDir, "etc", "netplan")),
+				policies.WithJournaldConfDir(filepath.Join(fakeRootDir, "etc", "systemd", "journald.conf.d")),
+				policies.WithNetplanCmd([]string{"/bin/true"}),
+				policies.WithSystemdCaller(&testutils.MockSystemdCaller{}),
+				policies.WithUserNotifications(!tc.noUserNotifications),
+			)
 			require.NoError(t, err, "Setup: couldn’t get a new policy manager")
 
-			got := m.GetSubscriptionState(context.Background())
-			assert.Equal(t, tc.want, got, "GetStatus should return %q but got %q", tc.want, got)
+			pols, err := policies.New(context.Background(), []policies.GPO{{ID: "{GPOId}", Name: "GPOName", Rules: map[string][]entry.Entry{"privilege": tc.entries}}}, "")
+			require.NoError(t, err, "Setup: can not create policies")
+			err = m.ApplyPolicies(context.Background(), "hostname", true, &pols)
+			require.NoError(t, err, "ApplyPolicies should return no error but got one")
+
+			notificationPath := filepath.Join(runDir, "notifications", u.Uid)
+			if !tc.wantNotification {
+				require.NoFileExists(t, notificationPath, "ApplyPolicies should not notify the user")
+				return
+			}
+			got, err := os.ReadFile(notificationPath)
+			require.NoError(t, err, "ApplyPolicies should have written a notification")
+			require.Contains(t, string(got), "Your administrator privileges were removed", "ApplyPolicies should notify the revoked privileges")
 		})
 	}
 }
 
-// mockProxyApplier is a mock for the proxy apply object.
-type mockProxyApplier struct {
-	wantApplyError bool
-}
+func TestApplyPoliciesLogout(t *testing.T) {
+	//t.Parallel()
 
-// Call mocks the proxy apply call.
-func (d *mockProxyApplier) Call(_ string, _ dbus.Flags, _ ...interface{}) *dbus.Call {
-	var errApply error
+	bus := testutils.NewDbusConn(t)
+	adsystest.SetSubscriptionAttached(t, bus, true)
 
-	if d.wantApplyError {
-		errApply = errors.New("proxy apply error")
-	}
+	u, err := user.Current()
+	require.NoError(t, err, "Setup: can't get current user")
 
-	return &dbus.Call{Err: errApply}
+	env1 := entry.Entry{Key: "user-environment", Value: "EDITOR=vim"}
+	env2 := entry.Entry{Key: "user-environment", Value: "EDITOR=nano"}
+	lock := entry.Entry{Key: "path/to/key1", Value: "ValueOfKey1", Meta: "s"}
+	gracePeriod := entry.Entry{Key: "session/grace-period", Value: "8"}
+	deferrals := entry.Entry{Key: "session/max-deferrals", Value: "2"}
+
+	hourAgo := time.Now().Add(-time.Hour)
+	pendingFutureDeadline := fmt.Sprintf("changed: %s\ndeadline: %s\ngrace-period: 8\ndeferrals: 0\nmax-deferrals: 2\n",
+		hourAgo.Format(time.RFC3339), hourAgo.Add(8*time.Hour).Format(time.RFC3339))
+	pendingPastDeadline := fmt.Sprintf("changed: %s\ndeadline: %s\ngrace-period: 8\ndeferrals: 2\nmax-deferrals: 2\n",
+		hourAgo.Add(-24*time.Hour).Format(time.RFC3339), hourAgo.Format(time.RFC3339))
+
+	tests := map[string]struct {
+		applied       map[string][]entry.Entry
+		rules         map[string][]entry.Entry
+		pending       string
+		atLogin       bool
+		completedLate bool
+		noSessions    bool
+
+		wantPending      bool
+		wantNotification bool
+		wantTerminated   bool
+		wantErr          bool
+	}{
+		"Changed environment plans closing the sessions": {
+			applied:          map[string][]entry.Entry{"environment": {env1}, "session": {gracePeriod, deferrals}},
+			rules:            map[string][]entry.Entry{"environment": {env2}, "session": {gracePeriod, deferrals}},
+			wantPending:      true,
+			wantNotification: true,
+		},
+		"Grace period set with the change plans closing the sessions": {
+			applied:          map[string][]entry.Entry{"environment": {env1}},
+			rules:            map[string][]entry.Entry{"environment": {env2}, "session": {gracePeriod}},
+			wantPending:      true,
+			wantNotification: true,
+		},
+		"Refresh completed after the session started plans closing the sessions": {
+			applied:          map[string][]entry.Entry{"environment": {env1}, "session": {gracePeriod}},
+			rules:            map[string][]entry.Entry{"environment": {env2}, "session": {gracePeriod}},
+			atLogin:          true,
+			completedLate:    true,
+			wantPending:      true,
+			wantNotification: true,
+		},
+		"Pending closing before the deadline is kept": {
+			applied:     map[string][]entry.Entry{"environment": {env2}, "session": {gracePeriod, deferrals}},
+			rules:       map[string][]entry.Entry{"environment": {env2}, "session": {gracePeriod, deferrals}},
+			pending:     pendingFutureDeadline,
+			wantPending: true,
+		},
+		"Pending closing past the deadline closes the sessions": {
+			applied:        map[string][]entry.Entry{"environment": {env2}, "session": {gracePeriod, deferrals}},
+			rules:          map[string][]entry.Entry{"environment": {env2}, "session": {gracePeriod, deferrals}},
+			pending:        pendingPastDeadline,
+			wantTerminated: true,
+		},
+
+		"No closing planned at login": {
+			applied: map[string][]entry.Entry{"environment": {env1}, "session": {gracePeriod}},
+			rules:   map[string][]entry.Entry{"environment": {env2}, "session": {gracePeriod}},
+			atLogin: true,
+		},
+		"No closing planned without grace period": {
+			applied: map[string][]entry.Entry{"environment": {env1}},
+			rules:   map[string][]entry.Entry{"environment": {env2}},
+		},
+		"No closing planned on first apply": {
+			rules: map[string][]entry.Entry{"environment": {env2}, "session": {gracePeriod}},
+		},
+		"No closing planned when policies applying to opened sessions change": {
+			applied: map[string][]entry.Entry{"dconf": {}, "session": {gracePeriod}},
+			rules:   map[string][]entry.Entry{"dconf": {lock}, "session": {gracePeriod}},
+		},
+		"No closing planned without session opened before the change": {
+			applied:    map[string][]entry.Entry{"environment": {env1}, "session": {gracePeriod}},
+			rules:      map[string][]entry.Entry{"environment": {env2}, "session": {gracePeriod}},
+			noSessions: true,
+		},
+		"No closing planned and error with invalid grace period": {
+			applied: map[string][]entry.Entry{"environment": {env1}},
+			rules:   map[string][]entry.Entry{"environment": {env2}, "session": {{Key: "session/grace-period", Value: "0"}}},
+			wantErr: true,
+		},
+		"Pending closing is removed once the sessions are closed": {
+			applied:    map[string][]entry.Entry{"environment": {env2}, "session": {gracePeriod}},
+			rules:      map[string][]entry.Entry{"environment": {env2}, "session": {gracePeriod}},
+			pending:    pendingPastDeadline,
+			noSessions: true,
+		},
+		"Pending closing is removed when the grace period is unset": {
+			applied: map[string][]entry.Entry{"environment": {env2}, "session": {gracePeriod}},
+			rules:   map[string][]entry.Entry{"environment": {env2}},
+			pending: pendingPastDeadline,
+		},
+	}
+	for name, tc := range tests {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			fakeRootDir := t.TempDir()
+			cacheDir := filepath.Join(fakeRootDir, "var", "cache", "adsys")
+			runDir := filepath.Join(fakeRootDir, "run", "adsys")
+			err := os.MkdirAll(filepath.Join(cacheDir, policies.PoliciesCacheBaseName), 0750)
+			require.NoError(t, err, "Setup: cannot create policies cache directory")
+			if tc.applied != nil {
+				applied, err := policies.New(context.Background(), []policies.GPO{{ID: "{GPOId}", Name: "GPOName", Rules: tc.applied}}, "")
+				require.NoError(t, err, "Setup: can not create applied policies")
+				err = applied.Save(filepath.Join(cacheDir, policies.PoliciesCacheBaseName, u.Username))
+				require.NoError(t, err, "Setup: can not save applied policies")
+			}
+			pendingPath := filepath.Join(cacheDir, policies.PendingLogoutCacheBaseName, u.Username)
+			if tc.pending != "" {
+				err := os.MkdirAll(filepath.Dir(pendingPath), 0750)
+				require.NoError(t, err, "Setup: cannot create pending closing of sessions directory")
+				err = os.WriteFile(pendingPath, []byte(tc.pending), 0600)
+				require.NoError(t, err, "Setup: cannot write pending closing of sessions")
+			}
+
+			sessions := &mockUserSessions{sessions: []string{"1", "2"}}
+			if tc.noSessions {
+				sessions.sessions = nil
+			}
+			m, err := policies.NewManager(bus, "hostname",
+				policies.WithCacheDir(cacheDir),
+				policies.WithRunDir(runDir),
+				policies.WithDconfDir(filepath.Join(fakeRootDir, "etc", "dconf")),
+				policies.WithPolicyKitDir(filepath.Join(fakeRootDir, "etc", "polkit-1")),
+				policies.WithSudoersDir(filepath.Join(fakeRootDir, "etc", "sudoers.d")),
+				policies.WithApparmorDir(filepath.Join(fakeRootDir, "etc", "apparmor.d", "adsys")),
+				policies.WithSystemUnitDir(filepath.Join(fakeRootDir, "etc", "systemd", "system")),
+				policies.WithGPPRootDir(fakeRootDir),
+				policies.WithSSSDConf(filepath.Join(fakeRootDir, "etc", "sssd", "sssd.conf")),
+				policies.WithNetplanDir(filepath.Join(fakeRootDir, "etc", "netplan")),
+				policies.WithJournaldConfDir(filepath.Join(fakeRootDir, "etc", "systemd", "journald.conf.d")),
+				policies.WithNetplanCmd([]string{"/bin/true"}),
+				policies.WithSystemdCaller(&testutils.MockSystemdCaller{}),
+				policies.WithUserSessions(sessions),
+			)
+			require.NoError(t, err, "Setup: couldn’t get a new policy manager")
+
+			// The machine dconf database is needed to apply user policies.
+			machinePols, err := policies.New(context.Background(), nil, "")
+			require.NoError(t, err, "Setup: can not create machine policies")
+			err = m.ApplyPolicies(context.Background(), "hostname", true, &machinePols)
+			require.NoError(t, err, "Setup: can't apply machine policies")
+
+			opts := []policies.ApplyOption{policies.WithCompletionNotification(func() bool { return tc.completedLate })}
+			if tc.atLogin {
+				opts = append(opts, policies.AtLogin())
+			}
+			pols, err := policies.New(context.Background(), []policies.GPO{{ID: "{GPOId}", Name: "GPOName", Rules: tc.rules}}, "")
+			require.NoError(t, err, "Setup: can not create policies")
+			err = m.ApplyPolicies(context.Background(), u.Username, false, &pols, opts...)
+			if tc.wantErr {
+				require.Error(t, err, "ApplyPolicies should report the invalid session policy")
+			} else {
+				require.NoError(t, err, "ApplyPolicies should return no error but got one")
+			}
+
+			if tc.wantTerminated {
+				require.Equal(t, []string{"1", "2"}, sessions.terminated, "ApplyPolicies should close the sessions opened before the change")
+			} else {
+				require.Empty(t, sessions.terminated, "ApplyPolicies should not close any session")
+			}
+
+			if !tc.wantPending {
+				require.NoFileExists(t, pendingPath, "ApplyPolicies should not plan closing the sessions")
+			} else {
+				require.FileExists(t, pendingPath, "ApplyPolicies should plan closing the sessions")
+			}
+			if tc.pending == pendingFutureDeadline && tc.wantPending {
+				got, err := os.ReadFile(pendingPath)
+				require.NoError(t, err, "Setup: can't read pending closing of sessions")
+				require.Equal(t, tc.pending, string(got), "ApplyPolicies should keep the deadline of the pending closing")
+			}
+
+			notificationPath := filepath.Join(runDir, "notifications", u.Uid)
+			if !tc.wantNotification {
+				require.NoFileExists(t, notificationPath, "ApplyPolicies should not notify the user")
+				return
+			}
+			got, err := os.ReadFile(notificationPath)
+			require.NoError(t, err, "ApplyPolicies should have written a notification")
+			require.Contains(t, string(got), "Log out to use your new settings", "ApplyPolicies should notify the closing of the sessions")
+		})
+	}
+}
+
+func TestDeferLogout(t *testing.T) {
+	t.Parallel()
+
+	bus := testutils.NewDbusConn(t)
+
+	u, err := user.Current()
+	require.NoError(t, err, "Setup: can't get current user")
+
+	deadline := time.Date(2030, 6, 1, 10, 0, 0, 0, time.UTC)
+
+	tests := map[string]struct {
+		deferrals       int
+		maxDeferrals    int
+		noPending       bool
+		notExistingUser bool
+
+		wantDeadline time.Time
+		wantErr      bool
+	}{
+		"Defer closing the sessions":               {maxDeferrals: 2, wantDeadline: deadline.Add(8 * time.Hour)},
+		"Defer closing the sessions a second time": {deferrals: 1, maxDeferrals: 2, wantDeadline: deadline.Add(8 * time.Hour)},
+
+		"Error when deferred too many times":  {deferrals: 2, maxDeferrals: 2, wantErr: true},
+		"Error when deferring is not allowed": {wantErr: true},
+		"Error when no closing is pending":    {noPending: true, wantErr: true},
+		"Error when user does not exist":      {notExistingUser: true, wantErr: true},
+	}
+	for name, tc := range tests {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			cacheDir := t.TempDir()
+			pendingPath := filepath.Join(cacheDir, policies.PendingLogoutCacheBaseName, u.Username)
+			if !tc.noPending {
+				err := os.MkdirAll(filepath.Dir(pendingPath), 0750)
+				require.NoError(t, err, "Setup: cannot create pending closing of sessions directory")
+				err = os.WriteFile(pendingPath, []byte(fmt.Sprintf("changed: 2030-05-31T10:00:00Z\ndeadline: %s\ngrace-period: 8\ndeferrals: %d\nmax-deferrals: %d\n",
+					deadline.Format(time.RFC3339), tc.deferrals, tc.maxDeferrals)), 0600)
+				require.NoError(t, err, "Setup: cannot write pending closing of sessions")
+			}
+
+			m, err := policies.NewManager(bus, "hostname",
+				policies.WithCacheDir(cacheDir),
+				policies.WithRunDir(t.TempDir()),
+				policies.WithUserSessions(&mockUserSessions{}),
+			)
+			require.NoError(t, err, "Setup: couldn’t get a new policy manager")
+
+			target := u.Username
+			if tc.notExistingUser {
+				target = "doesnotexist"
+			}
+			msg, err := m.DeferLogout(context.Background(), target)
+			if tc.wantErr {
+				require.Error(t, err, "DeferLogout should return an error but got none")
+				return
+			}
+			require.NoError(t, err, "DeferLogout should return no error but got one")
+			require.Contains(t, msg, tc.wantDeadline.Format(time.RFC1123), "DeferLogout should report the new deadline")
+
+			got, err := os.ReadFile(pendingPath)
+			require.NoError(t, err, "DeferLogout should keep the pending closing of sessions")
+			require.Contains(t, string(got), fmt.Sprintf("deferrals: %d\n", tc.deferrals+1), "DeferLogout should count the deferral")
+		})
+	}
+}
+
+func TestApplyPoliciesLastKnownGood(t *testing.T) {
+	//t.Parallel()
+
+	bus := testutils.NewDbusConn(t)
+
+	tests := map[string]struct {
+		storages           []string
+		lastKnownGoodAfter int
+		isNotSubscribed    bool
+
+		wantLastKnownGood string
+	}{
+		"Re-activate last known good policy after failing repeatedly": {storages: []string{"persistent", "invalid", "invalid"}, lastKnownGoodAfter: 2, wantLastKnownGood: "persistent"},
+		"Re-activate last known good policy on first failure":         {storages: []string{"persistent", "invalid"}, lastKnownGoodAfter: 1, wantLastKnownGood: "persistent"},
+		"Last known good policy follows successful applies":           {storages: []string{"persistent", "volatile", "invalid", "invalid"}, lastKnownGoodAfter: 2, wantLastKnownGood: "volatile"},
+		"Succeeding again resets the failures":                        {storages: []string{"persistent", "invalid", "invalid", "auto"}, lastKnownGoodAfter: 2, wantLastKnownGood: "auto"},
+
+		"Failures below the limit keep the failing policy":  {storages: []string{"persistent", "invalid", "invalid"}, lastKnownGoodAfter: 3, wantLastKnownGood: "persistent"},
+		"No last known good policy to re-activate":          {storages: []string{"invalid", "invalid"}, lastKnownGoodAfter: 1},
+		"Last known good policy is not recorded when unset": {storages: []string{"persistent", "invalid", "invalid"}},
+
+		"Policy filtered out without Ubuntu Pro is not recorded": {storages: []string{"persistent"}, lastKnownGoodAfter: 1, isNotSubscribed: true},
+	}
+	for name, tc := range tests {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			adsystest.SetSubscriptionAttached(t, bus, !tc.isNotSubscribed)
+
+			fakeRootDir := t.TempDir()
+			cacheDir := filepath.Join(fakeRootDir, "var", "cache", "adsys")
+
+			m, err := policies.NewManager(bus, "hostname",
+				policies.WithCacheDir(cacheDir),
+				policies.WithRunDir(filepath.Join(fakeRootDir, "run", "adsys")),
+				policies.WithDconfDir(filepath.Join(fakeRootDir, "etc", "dconf")),
+				policies.WithPolicyKitDir(filepath.Join(fakeRootDir, "etc", "polkit-1")),
+				policies.WithSudoersDir(filepath.Join(fakeRootDir, "etc", "sudoers.d")),
+				policies.WithApparmorDir(filepath.Join(fakeRootDir, "etc", "apparmor.d", "adsys")),
+				policies.WithSystemUnitDir(filepath.Join(fakeRootDir, "etc", "systemd", "system")),
+				policies.WithGPPRootDir(fakeRootDir),
+				policies.WithSSSDConf(filepath.Join(fakeRootDir, "etc", "sssd", "sssd.conf")),
+				policies.WithNetplanDir(filepath.Join(fakeRootDir, "etc", "netplan")),
+				policies.WithJournaldConfDir(filepath.Join(fakeRootDir, "etc", "systemd", "journald.conf.d")),
+				policies.WithNetplanCmd([]string{"/bin/true"}),
+				policies.WithSystemdCaller(&testutils.MockSystemdCaller{}),
+				policies.WithLastKnownGoodAfter(tc.lastKnownGoodAfter),
+			)
+			require.NoError(t, err, "Setup: couldn’t get a new policy manager")
+
+			for i, storage := range tc.storages {
+				pols, err := policies.New(context.Background(), []policies.GPO{{ID: "{GPOId}", Name: "GPOName", Rules: map[string][]entry.Entry{
+					"journald": {{Key: "journald/storage", Value: storage}},
+				}}}, "")
+				require.NoError(t, err, "Setup: can not create policies")
+				err = m.ApplyPolicies(context.Background(), "hostname", true, &pols)
+				if storage == "invalid" {
+					require.Error(t, err, "ApplyPolicies should fail with an invalid policy, even when re-activating the last known good one")
+					continue
+				}
+				require.NoError(t, err, "ApplyPolicies should succeed on apply %d", i)
+			}
+
+			got, err := os.ReadFile(filepath.Join(cacheDir, policies.StatusCacheBaseName, "hostname"))
+			require.NoError(t, err, "ApplyPolicies should have saved the status")
+			want := testutils.LoadWithUpdateFromGolden(t, string(got))
+			require.Equal(t, want, string(got), "ApplyPolicies should report the failures and the re-activated policy managers")
+
+			lastKnownGood := filepath.Join(cacheDir, policies.LastKnownGoodCacheBaseName, "hostname")
+			if tc.lastKnownGoodAfter == 0 {
+				require.NoFileExists(t, lastKnownGood, "ApplyPolicies should not record last known good policies")
+				return
+			}
+			got, err = os.ReadFile(lastKnownGood)
+			require.NoError(t, err, "ApplyPolicies should have recorded the last known good policies")
+			if tc.wantLastKnownGood == "" {
+				require.NotContains(t, string(got), "journald/storage", "ApplyPolicies should not record a failed or filtered policy as last known good")
+				return
+			}
+			require.Contains(t, string(got), fmt.Sprintf("value: %s\n", tc.wantLastKnownGood), "ApplyPolicies should record the last successful policy")
+		})
+	}
+}
+
+func TestApplyPoliciesWhileFrozen(t *testing.T) {
+	//t.Parallel()
+
+	bus := testutils.NewDbusConn(t)
+	adsystest.SetSubscriptionAttached(t, bus, true)
+
+	tests := map[string]struct {
+		frozenFor time.Duration
+		unfreeze  bool
+		purge     bool
+
+		wantApplied bool
+	}{
+		"Policies are not applied while frozen":          {},
+		"Policies are not applied while frozen for long": {frozenFor: time.Hour},
+		"Policies are applied once the freeze expired":   {frozenFor: -time.Hour, wantApplied: true},
+		"Policies are applied once unfrozen":             {unfreeze: true, wantApplied: true},
+		"Policies are purged while frozen":               {purge: true},
+	}
+	for name, tc := range tests {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			root := adsystest.NewFakeRoot(t)
+
+			m, err := policies.NewManager(bus, "hostname",
+				policies.WithCacheDir(root.CacheDir),
+				policies.WithRunDir(root.RunDir),
+				policies.WithDconfDir(root.DconfDir),
+				policies.WithPolicyKitDir(root.PolicyKitDir),
+				policies.WithSudoersDir(root.SudoersDir),
+				policies.WithApparmorDir(root.ApparmorDir),
+				policies.WithSystemUnitDir(root.SystemUnitDir),
+				policies.WithGPPRootDir(root.Dir),
+				policies.WithSSSDConf(root.SSSDConf),
+				policies.WithNetplanDir(root.NetplanDir),
+				policies.WithJournaldConfDir(root.JournaldDir),
+				policies.WithSystemdCaller(&testutils.MockSystemdCaller{}),
+			)
+			require.NoError(t, err, "Setup: couldn’t get a new policy manager")
+
+			pols, err := policies.New(context.Background(), []policies.GPO{{ID: "{GPOId}", Name: "GPOName", Rules: map[string][]entry.Entry{
+				"journald": {{Key: "journald/storage", Value: "persistent"}},
+			}}}, "")
+			require.NoError(t, err, "Setup: can not create policies")
+			if tc.purge {
+				require.NoError(t, m.ApplyPolicies(context.Background(), "hostname", true, &pols), "Setup: ApplyPolicies should succeed before freezing")
+			}
+
+			var until time.Time
+			if tc.frozenFor != 0 {
+				until = time.Now().Add(tc.frozenFor)
+			}
+			require.NoError(t, m.Freeze(until), "Setup: Freeze should succeed")
+			if tc.unfreeze {
+				require.NoError(t, m.Unfreeze(), "Setup: Unfreeze should succeed")
+			}
+
+			if tc.purge {
+				err = m.Purge(context.Background(), "hostname", true)
+			} else {
+				err = m.ApplyPolicies(context.Background(), "hostname", true, &pols)
+			}
+			require.NoError(t, err, "Applying policies should not fail while frozen")
+
+			cachedPolicies := filepath.Join(root.CacheDir, policies.PoliciesCacheBaseName, "hostname")
+			if !tc.wantApplied {
+				require.NoDirExists(t, cachedPolicies, "Policies should not be applied while frozen")
+				return
+			}
+			require.DirExists(t, cachedPolicies, "Policies should be applied when not frozen")
+			_, frozen := m.FrozenUntil(context.Background())
+			require.False(t, frozen, "Policy updates should not be frozen anymore")
+		})
+	}
+}
+
+func TestUnitStatus(t *testing.T) {
+	//t.Parallel()
+
+	bus := testutils.NewDbusConn(t)
+
+	u, err := user.Current()
+	require.NoError(t, err, "Setup: can't get current user")
+
+	type refresh struct {
+		isComputer       bool
+		failing          bool
+		retrievalFailing bool
+		// restart starts a new daemon instead of refreshing.
+		restart bool
+	}
+	machineOK, machineFailing := refresh{isComputer: true}, refresh{isComputer: true, failing: true}
+	userOK, userFailing := refresh{}, refresh{failing: true}
+	machineRetrievalFailing, userRetrievalFailing := refresh{isComputer: true, retrievalFailing: true}, refresh{retrievalFailing: true}
+	restart := refresh{restart: true}
+
+	tests := map[string]struct {
+		refreshes []refresh
+
+		want string
+	}{
+		"Machine refresh OK": {refreshes: []refresh{machineOK}, want: "Last refresh OK at <time>"},
+		"Failing policy managers of the machine are listed": {refreshes: []refresh{machineFailing}, want: "Last refresh failed at <time>: dconf, gdm failed"},
+		"Machine refresh OK clears its failure":             {refreshes: []refresh{machineFailing, machineOK}, want: "Last refresh OK at <time>"},
+		"User refresh OK keeps the machine status":          {refreshes: []refresh{machineOK, userOK}, want: "Last refresh OK at <time>"},
+		"Failing users are listed after the machine status": {
+			refreshes: []refresh{machineOK, userFailing},
+			want:      "Last refresh OK at <time>; last refresh failed for <user> (dconf)",
+		},
+		"User refresh OK clears its failure": {refreshes: []refresh{machineOK, userFailing, userOK}, want: "Last refresh OK at <time>"},
+		"Machine refresh keeps the failing users": {
+			refreshes: []refresh{machineOK, userFailing, machineFailing},
+			want:      "Last refresh failed at <time>: dconf, gdm failed; last refresh failed for <user> (dconf)",
+		},
+		"Policy retrieval failure of the machine is reported": {
+			refreshes: []refresh{machineOK, machineRetrievalFailing},
+			want:      "Last refresh failed at <time>: policy retrieval failed",
+		},
+		"Policy retrieval failure of users is reported": {
+			refreshes: []refresh{machineOK, userRetrievalFailing},
+			want:      "Last refresh OK at <time>; last refresh failed for <user> (policy retrieval)",
+		},
+		"Status is reported again by a new daemon": {
+			refreshes: []refresh{machineFailing, userFailing, restart},
+			want:      "Last refresh failed at <time>: dconf, gdm failed; last refresh failed for <user> (dconf)",
+		},
+		"New daemon updates the previous status": {
+			refreshes: []refresh{machineFailing, userFailing, restart, userOK},
+			want:      "Last refresh failed at <time>: dconf, gdm failed",
+		},
+	}
+	for name, tc := range tests {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			root := adsystest.NewFakeRoot(t)
+
+			var got string
+			newManager := func() (*policies.Manager, error) {
+				return policies.NewManager(bus, "hostname",
+					policies.WithCacheDir(root.CacheDir),
+					policies.WithRunDir(root.RunDir),
+					policies.WithDconfDir(root.DconfDir),
+					policies.WithPolicyKitDir(root.PolicyKitDir),
+					policies.WithSudoersDir(root.SudoersDir),
+					policies.WithApparmorDir(root.ApparmorDir),
+					policies.WithSystemUnitDir(root.SystemUnitDir),
+					policies.WithGPPRootDir(root.Dir),
+					policies.WithSSSDConf(root.SSSDConf),
+					policies.WithNetplanDir(root.NetplanDir),
+					policies.WithJournaldConfDir(root.JournaldDir),
+					policies.WithNetplanCmd([]string{"/bin/true"}),
+					policies.WithSystemdCaller(&testutils.MockSystemdCaller{}),
+					policies.WithSdNotifier(func(_ bool, state string) (bool, error) {
+						got = state
+						return true, nil
+					}),
+				)
+			}
+			m, err := newManager()
+			require.NoError(t, err, "Setup: couldn’t get a new policy manager")
+
+			for _, r := range tc.refreshes {
+				if r.restart {
+					got = ""
+					m, err = newManager()
+					require.NoError(t, err, "Setup: couldn’t get a new policy manager")
+					continue
+				}
+				objectName := u.Username
+				if r.isComputer {
+					objectName = "hostname"
+				}
+				if r.retrievalFailing {
+					m.ReportRetrievalFailure(context.Background(), objectName, r.isComputer)
+					continue
+				}
+				pols, err := policies.New(context.Background(), nil, "")
+				require.NoError(t, err, "Setup: can not create empty policies")
+				if r.failing {
+					pols, err = policies.NewFromCache(context.Background(), filepath.Join("testdata", "cache", "policies", "dconf_failing"))
+					require.NoError(t, err, "Setup: can not load policies list")
+					defer pols.Close()
+				}
+				err = m.ApplyPolicies(context.Background(), objectName, r.isComputer, &pols)
+				if r.failing {
+					require.Error(t, err, "ApplyPolicies should return an error but got none")
+					continue
+				}
+				require.NoError(t, err, "ApplyPolicies should return no error but got one")
+			}
+
+			got = regexp.MustCompile(`\d{4}-\d{2}-\d{2}T\d{2}:\d{2}:\d{2}(Z|[+-]\d{2}:\d{2})`).ReplaceAllString(got, "<time>")
+			got = strings.ReplaceAll(got, u.Username+" (", "<user> (")
+			require.Equal(t, "STATUS="+tc.want, got, "ApplyPolicies should report the result of the refreshes in the unit status")
+		})
+	}
+}
+
+func TestResumeInterruptedApplies(t *testing.T) {
+	//t.Parallel()
+
+	bus := testutils.NewDbusConn(t)
+
+	// We change the dbus returned values to simulate a subscription
+	adsystest.SetSubscriptionAttached(t, bus, true)
+
+	tests := map[string]struct {
+		checkpoints    map[string]string
+		cachedPolicies map[string]string
+
+		wantErr bool
+	}{
+		"No interrupted apply is a no-op":                                {},
+		"Interrupted apply is rolled back to the last applied policies":  {checkpoints: map[string]string{"hostname": "true"}, cachedPolicies: map[string]string{"hostname": "all_entry_types"}},
+		"Interrupted apply without cached policies unloads the policies": {checkpoints: map[string]string{"hostname": "true"}},
+
+		// Error cases
+		"Error on invalid checkpoint removes it":            {checkpoints: map[string]string{"hostname": "not a boolean"}, wantErr: true},
+		"Error on invalid cached policies keeps checkpoint": {checkpoints: map[string]string{"hostname": "true"}, cachedPolicies: map[string]string{"hostname": "invalid_policies_cache"}, wantErr: true},
+	}
+	for name, tc := range tests {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+
+			fakeRootDir := t.TempDir()
+			cacheDir := filepath.Join(fakeRootDir, "var", "cache", "adsys")
+			runDir := filepath.Join(fakeRootDir, "run", "adsys")
+			dconfDir := filepath.Join(fakeRootDir, "etc", "dconf")
+			loadedPoliciesFile := filepath.Join(fakeRootDir, "sys", "kernel", "security", "apparmor", "profiles")
+
+			err := os.MkdirAll(filepath.Dir(loadedPoliciesFile), 0700)
+			require.NoError(t, err, "Setup: can not create loadedPoliciesFile dir")
+			err = os.WriteFile(loadedPoliciesFile, []byte("someprofile (enforce)\n"), 0600)
+			require.NoError(t, err, "Setup: can not create loadedPoliciesFile")
+
+			m, err := policies.NewManager(bus,
+				"hostname",
+				policies.WithCacheDir(cacheDir),
+				policies.WithRunDir(runDir),
+				policies.WithDconfDir(dconfDir),
+				policies.WithPolicyKitDir(filepath.Join(fakeRootDir, "etc", "polkit-1")),
+				policies.WithSudoersDir(filepath.Join(fakeRootDir, "etc", "sudoers.d")),
+				policies.WithApparmorDir(filepath.Join(fakeRootDir, "etc", "apparmor.d", "adsys")),
+				policies.WithApparmorFsDir(filepath.Dir(loadedPoliciesFile)),
+				policies.WithApparmorParserCmd([]string{"/bin/true"}),
+				policies.WithSystemUnitDir(filepath.Join(fakeRootDir, "etc", "systemd", "system")),
+				policies.WithGPPRootDir(fakeRootDir),
+				policies.WithSSSDConf(filepath.Join(fakeRootDir, "etc", "sssd", "sssd.conf")),
+				policies.WithNetplanDir(filepath.Join(fakeRootDir, "etc", "netplan")),
+				policies.WithJournaldConfDir(filepath.Join(fakeRootDir, "etc", "systemd", "journald.conf.d")),
+				policies.WithNetplanCmd([]string{"/bin/true"}),
+				policies.WithPluginsDir(filepath.Join(fakeRootDir, "usr", "lib", "adsys", "plugins")),
+				policies.WithTransformsDir(filepath.Join(fakeRootDir, "etc", "adsys", "transforms.d")),
+				policies.WithProxyApplier(&mockProxyApplier{}),
+				policies.WithSystemdCaller(&testutils.MockSystemdCaller{}),
+			)
+			require.NoError(t, err, "Setup: couldn’t get a new policy manager")
+
+			for objectName, src := range tc.cachedPolicies {
+				dest := filepath.Join(cacheDir, policies.PoliciesCacheBaseName, objectName)
+				require.NoError(t, shutil.CopyTree(filepath.Join("testdata", "cache", "policies", src), dest, nil), "Setup: can't copy cached policies")
+			}
+			for objectName, content := range tc.checkpoints {
+				require.NoError(t, os.WriteFile(filepath.Join(cacheDir, policies.InflightCacheBaseName, objectName), []byte(content), 0600), "Setup: can't create checkpoint")
+			}
+
+			err = m.ResumeInterruptedApplies(context.Background())
+			if tc.wantErr {
+				require.Error(t, err, "ResumeInterruptedApplies should return an error but got none")
+			} else {
+				require.NoError(t, err, "ResumeInterruptedApplies should return no error but got one")
+			}
+
+			testutils.CompareTreesWithFiltering(t, fakeRootDir, testutils.GoldenPath(t), testutils.Update())
+		})
+	}
+}
+
+func TestDumpPolicies(t *testing.T) {
+	t.Parallel()
+
+	bus := testutils.NewDbusConn(t)
+
+	hostname, err := os.Hostname()
+	require.NoError(t, err, "Setup: failed to get hostname")
+
+	tests := map[string]struct {
+		cachePoliciesUser  string
+		cachePolicyMachine string
+		target             string
+		computerOnly       bool
+		withRules          bool
+		withOverridden     bool
+		filter             policies.DumpFilter
+		downloadedGPOs     []string
+		precedence         map[string]string
+
+		wantErr bool
+	}{
+		"One GPO User": {
+			cachePoliciesUser: "one_gpo",
+		},
+		"One GPO Machine": {
+			cachePolicyMachine: "one_gpo",
+			target:             hostname,
+			computerOnly:       true,
+		},
+		"One GPO User + Machine": {
+			cachePoliciesUser:  "one_gpo",
+			cachePolicyMachine: "one_gpo_other",
+		},
+		"Multiple GPOs": {
+			cachePoliciesUser: "two_gpos_no_override",
+		},
+
+		// Show rules
+		"One GPO with rules": {
+			cachePoliciesUser: "one_gpo",
+			withRules:         true,
+		},
+		"Expired entries are not displayed": {
+			cachePoliciesUser: "dconf_expiry",
+			withRules:         true,
+			withOverridden:    true,
+		},
+		"Machine only GPO with rules": {
+			cachePolicyMachine: "one_gpo",
+			target:             hostname,
+			computerOnly:       true,
+			withRules:          true,
+		},
+		"Multiple GPOs with rules, no override": {
+			cachePoliciesUser: "two_gpos_no_override",
+			withRules:         true,
+		},
+		"Typed values with rules": {
+			cachePoliciesUser: "typed_values",
+			withRules:         true,
+		},
+		"Multiple GPOs with rules, override hidden": {
+			cachePoliciesUser: "two_gpos_with_overrides",
+			withRules:         true,
+		},
+		"Multiple GPOs with rules, override, shown": {
+			cachePoliciesUser: "two_gpos_with_overrides",
+			withRules:         true,
+			withOverridden:    true,
+		},
+		"Report-only entries are marked and do not override": {
+			cachePoliciesUser: "dconf_report_only",
+			withRules:         true,
+			withOverridden:    true,
+		},
+		"Most restrictive entries are marked, override hidden": {
+			cachePoliciesUser: "dconf_most_restrictive",
+			precedence:        map[string]string{"dconf": policies.PrecedenceMostRestrictive},
+			withRules:         true,
+		},
+		"Most restrictive entries are marked, override shown": {
+			cachePoliciesUser: "dconf_most_restrictive",
+			precedence:        map[string]string{"dconf": policies.PrecedenceMostRestrictive},
+			withRules:         true,
+			withOverridden:    true,
+		},
+
+		// Download statistics
+		"GPO with rules and download statistics": {
+			cachePoliciesUser: "one_gpo",
+			downloadedGPOs:    []string{"{GPOId}"},
+			withRules:         true,
+		},
+		"Download statistics only for downloaded GPOs": {
+			cachePoliciesUser:  "one_gpo",
+			cachePolicyMachine: "one_gpo_other",
+			downloadedGPOs:     []string{"{GPOIdOther}"},
+			withRules:          true,
+		},
+		"No download statistics without rules": {
+			cachePoliciesUser: "one_gpo",
+			downloadedGPOs:    []string{"{GPOId}"},
+		},
+
+		// machine and user GPO with overrides between machine and user
+		"Overrides between machine and user GPOs, hidden": {
+			cachePoliciesUser:  "one_gpo",
+			cachePolicyMachine: "two_gpos_override_one_gpo",
+			withRules:          true,
+		},
+		"Overrides between machine and user GPOs, shown": {
+			cachePoliciesUser:  "one_gpo",
+			cachePolicyMachine: "two_gpos_override_one_gpo",
+			withRules:          true,
+			withOverridden:     true,
+		},
+
+		// Filters
+		"Filter on a policy manager shows its rules": {
+			cachePoliciesUser: "two_gpos_with_overrides",
+			filter:            policies.DumpFilter{Manager: "scripts"},
+		},
+		"Filter on a policy manager with override shown": {
+			cachePoliciesUser: "two_gpos_with_overrides",
+			withOverridden:    true,
+			filter:            policies.DumpFilter{Manager: "dconf"},
+		},
+		"Filter on a policy manager without rules shows nothing": {
+			cachePoliciesUser: "two_gpos_with_overrides",
+			filter:            policies.DumpFilter{Manager: "privilege"},
+		},
+		"Filter on GPO name": {
+			cachePoliciesUser: "two_gpos_with_overrides",
+			withRules:         true,
+			filter:            policies.DumpFilter{GPO: "GPOName2"},
+		},
+		"Filter on GPO ID without rules": {
+			cachePoliciesUser: "two_gpos_with_overrides",
+			filter:            policies.DumpFilter{GPO: "{GPOId}"},
+		},
+		"Filter on overridden rules": {
+			cachePoliciesUser: "two_gpos_with_overrides",
+			filter:            policies.DumpFilter{OnlyOverridden: true},
+		},
+		"Filter on overridden rules between machine and user GPOs": {
+			cachePoliciesUser:  "one_gpo",
+			cachePolicyMachine: "two_gpos_override_one_gpo",
+			filter:             policies.DumpFilter{OnlyOverridden: true},
+		},
+		"Filter on overridden most restrictive rules": {
+			cachePoliciesUser: "dconf_most_restrictive",
+			precedence:        map[string]string{"dconf": policies.PrecedenceMostRestrictive},
+			filter:            policies.DumpFilter{OnlyOverridden: true},
+		},
+		"Filters are combined": {
+			cachePoliciesUser: "two_gpos_with_overrides",
+			filter:            policies.DumpFilter{Manager: "dconf", GPO: "GPOName2", OnlyOverridden: true},
+		},
+		"Filter on a policy manager shows the rules of all its domains": {
+			cachePoliciesUser: "dconf_preferences",
+			filter:            policies.DumpFilter{Manager: "dconf"},
+		},
+
+		// Edge cases
+		"Same GPO Machine and User": {
+			cachePoliciesUser:  "one_gpo",
+			cachePolicyMachine: "one_gpo",
+		},
+		"Same GPO Machine and User with rules": {
+			cachePoliciesUser:  "one_gpo",
+			cachePolicyMachine: "one_gpo",
+			withRules:          true,
+		},
+		"Same GPO Machine and User with rules and overrides": {
+			cachePoliciesUser:  "one_gpo",
+			cachePolicyMachine: "one_gpo",
+			withRules:          true,
+			withOverridden:     true,
+		},
+
+		// Error cases
+		"Error on missing target cache": {
+			wantErr: true,
+		},
+		"Error on missing machine cache when targeting user": {
+			cachePoliciesUser:  "one_gpo",
+			cachePolicyMachine: "-",
+			wantErr:            true,
+		},
+		"Error on filter on a GPO not applied": {
+			cachePoliciesUser: "two_gpos_with_overrides",
+			filter:            policies.DumpFilter{GPO: "UnknownGPO"},
+			wantErr:           true,
+		},
+		"Error on filter on an unknown policy manager": {
+			cachePoliciesUser: "dconf_preferences",
+			filter:            policies.DumpFilter{Manager: "dconf-preferences"},
+			wantErr:           true,
+		},
+	}
+
+	for name, tc := range tests {
+		tc := tc
+		name := name
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			cacheDir, runDir := t.TempDir(), t.TempDir()
+			m, err := policies.NewManager(bus, hostname, policies.WithCacheDir(cacheDir), policies.WithRunDir(runDir), policies.WithPrecedence(tc.precedence))
+			require.NoError(t, err, "Setup: couldn’t get a new policy manager")
+
+			err = os.MkdirAll(filepath.Join(cacheDir, policies.PoliciesCacheBaseName), 0750)
+			require.NoError(t, err, "Setup: cant not create policies cache directory")
+
+			if tc.cachePoliciesUser != "" {
+				err := shutil.CopyTree(filepath.Join("testdata", "cache", "policies", tc.cachePoliciesUser), filepath.Join(cacheDir, policies.PoliciesCacheBaseName, "user"), nil)
+				require.NoError(t, err, "Setup: couldn’t copy user policies cache")
+			}
+			if tc.cachePolicyMachine == "" {
+				machinePolicyCache := filepath.Join(cacheDir, policies.PoliciesCacheBaseName, hostname)
+				err = os.MkdirAll(machinePolicyCache, 0750)
+				require.NoError(t, err, "Setup: cant not create machine policies cache directory")
+				f, err := os.Create(filepath.Join(machinePolicyCache, "policies"))
+				require.NoError(t, err, "Setup: failed to create empty machine policies cache")
+				f.Close()
+			} else if tc.cachePolicyMachine != "-" {
+				err := shutil.CopyTree(filepath.Join("testdata", "cache", "policies", tc.cachePolicyMachine), filepath.Join(cacheDir, policies.PoliciesCacheBaseName, hostname), nil)
+				require.NoError(t, err, "Setup: couldn’t copy machine policies cache")
+			}
+
+			for _, id := range tc.downloadedGPOs {
+				gpoDir := filepath.Join(cacheDir, policies.SysvolCacheBaseName, "Policies", id)
+				err := os.MkdirAll(filepath.Join(gpoDir, "User"), 0750)
+				require.NoError(t, err, "Setup: can't create downloaded GPO directory")
+				err = os.WriteFile(filepath.Join(gpoDir, "GPT.INI"), []byte("[General]\nVersion=3\n"), 0600)
+				require.NoError(t, err, "Setup: can't create downloaded GPO GPT.INI")
+				err = os.WriteFile(filepath.Join(gpoDir, "User", "Registry.pol"), make([]byte, 1000), 0600)
+				require.NoError(t, err, "Setup: can't create downloaded GPO Registry.pol")
+				lastChange := time.Date(2023, 7, 11, 12, 0, 0, 0, time.UTC)
+				err = os.Chtimes(gpoDir, lastChange, lastChange)
+				require.NoError(t, err, "Setup: can't set downloaded GPO modification time")
+			}
+
+			if tc.target == "" {
+				tc.target = "user"
+			}
+			got, err := m.DumpPolicies(context.Background(), tc.target, tc.computerOnly, tc.withRules, tc.withOverridden, tc.filter)
+			if tc.wantErr {
+				require.Error(t, err, "DumpPolicies should return an error but got none")
+				return
+			}
+			require.NoError(t, err, "DumpPolicies should return no error but got one")
+
+			want := testutils.LoadWithUpdateFromGolden(t, got)
+			require.Equal(t, want, got, "DumpPolicies returned expected output")
+		})
+	}
+}
+
+func TestDumpPoliciesStructured(t *testing.T) {
+	t.Parallel()
+
+	bus := testutils.NewDbusConn(t)
+
+	// Fixed machine name to keep the output stable
+	hostname := "machine"
+
+	tests := map[string]struct {
+		cachePoliciesUser  string
+		cachePolicyMachine string
+		target             string
+		computerOnly       bool
+		format             string
+		filter             policies.DumpFilter
+		precedence         map[string]string
+
+		wantManagerErr bool
+		wantErr        bool
+	}{
+		"JSON User":    {cachePoliciesUser: "two_gpos_no_override"},
+		"YAML User":    {cachePoliciesUser: "two_gpos_no_override", format: policies.FormatYAML},
+		"JSON Machine": {cachePolicyMachine: "one_gpo", target: hostname, computerOnly: true},
+		"YAML Machine": {cachePolicyMachine: "one_gpo", target: hostname, computerOnly: true, format: policies.FormatYAML},
+		"Overridden and disabled entries are listed with their winning GPO": {cachePoliciesUser: "two_gpos_with_overrides"},
+		"Report-only entries are listed without winning GPO":                {cachePoliciesUser: "dconf_report_only"},
+		"Values are listed with their type":                                 {cachePoliciesUser: "typed_values"},
+		"Values are listed with their type in YAML":                         {cachePoliciesUser: "typed_values", format: policies.FormatYAML},
+		"Most restrictive entries are listed with their precedence": {
+			cachePoliciesUser: "dconf_most_restrictive",
+			precedence:        map[string]string{"dconf": policies.PrecedenceMostRestrictive},
+		},
+		"LSDOU precedence is not listed": {
+			cachePoliciesUser: "dconf_most_restrictive",
+			precedence:        map[string]string{"dconf": policies.PrecedenceLSDOU},
+		},
+		"Overrides between machine and user GPOs": {
+			cachePoliciesUser:  "one_gpo",
+			cachePolicyMachine: "two_gpos_override_one_gpo",
+		},
+		"Object without GPO": {cachePoliciesUser: "-"},
+
+		// Filters
+		"Filter on a policy manager":                          {cachePoliciesUser: "two_gpos_with_overrides", filter: policies.DumpFilter{Manager: "scripts"}},
+		"Filter on GPO name":                                  {cachePoliciesUser: "two_gpos_with_overrides", filter: policies.DumpFilter{GPO: "GPOName2"}},
+		"Filter on GPO ID":                                    {cachePoliciesUser: "two_gpos_with_overrides", filter: policies.DumpFilter{GPO: "{GPOId2}"}},
+		"Filter on overridden entries":                        {cachePoliciesUser: "two_gpos_with_overrides", filter: policies.DumpFilter{OnlyOverridden: true}},
+		"Filter on a policy manager without entries is empty": {cachePoliciesUser: "two_gpos_with_overrides", filter: policies.DumpFilter{Manager: "privilege"}},
+		"Filter on overridden entries between machine and user GPOs": {
+			cachePoliciesUser:  "one_gpo",
+			cachePolicyMachine: "two_gpos_override_one_gpo",
+			filter:             policies.DumpFilter{OnlyOverridden: true},
+		},
+		"Filter on a policy manager includes all its domains": {cachePoliciesUser: "dconf_preferences", filter: policies.DumpFilter{Manager: "dconf"}},
+
+		// Error cases
+		"Error on unsupported format":                  {cachePoliciesUser: "one_gpo", format: "xml", wantErr: true},
+		"Error on filter on a GPO not applied":         {cachePoliciesUser: "two_gpos_with_overrides", filter: policies.DumpFilter{GPO: "UnknownGPO"}, wantErr: true},
+		"Error on filter on an unknown policy manager": {cachePoliciesUser: "dconf_preferences", filter: policies.DumpFilter{Manager: "dconf-preferences"}, wantErr: true},
+		"Error on invalid precedence": {
+			cachePoliciesUser: "dconf_most_restrictive",
+			precedence:        map[string]string{"dconf": "lowest"},
+			wantManagerErr:    true,
+		},
+		"Error on missing target cache": {wantErr: true},
+		"Error on missing machine cache when targeting user": {
+			cachePoliciesUser:  "one_gpo",
+			cachePolicyMachine: "-",
+			wantErr:            true,
+		},
+	}
+
+	for name, tc := range tests {
+		tc := tc
+		name := name
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			cacheDir, runDir := t.TempDir(), t.TempDir()
+			m, err := policies.NewManager(bus, hostname, policies.WithCacheDir(cacheDir), policies.WithRunDir(runDir), policies.WithPrecedence(tc.precedence))
+			if tc.wantManagerErr {
+				require.Error(t, err, "NewManager should return an error but got none")
+				return
+			}
+			require.NoError(t, err, "Setup: couldn’t get a new policy manager")
+
+			err = os.MkdirAll(filepath.Join(cacheDir, policies.PoliciesCacheBaseName), 0750)
+			require.NoError(t, err, "Setup: cant not create policies cache directory")
+
+			createEmptyCache := func(object string) {
+				p := filepath.Join(cacheDir, policies.PoliciesCacheBaseName, object)
+				err = os.MkdirAll(p, 0750)
+				require.NoError(t, err, "Setup: cant not create policies cache directory")
+				f, err := os.Create(filepath.Join(p, "policies"))
+				require.NoError(t, err, "Setup: failed to create empty policies cache")
+				f.Close()
+			}
+
+			if tc.cachePoliciesUser == "-" {
+				createEmptyCache("user")
+			} else if tc.cachePoliciesUser != "" {
+				err := shutil.CopyTree(filepath.Join("testdata", "cache", "policies", tc.cachePoliciesUser), filepath.Join(cacheDir, policies.PoliciesCacheBaseName, "user"), nil)
+				require.NoError(t, err, "Setup: couldn’t copy user policies cache")
+			}
+			if tc.cachePolicyMachine == "" {
+				createEmptyCache(hostname)
+			} else if tc.cachePolicyMachine != "-" {
+				err := shutil.CopyTree(filepath.Join("testdata", "cache", "policies", tc.cachePolicyMachine), filepath.Join(cacheDir, policies.PoliciesCacheBaseName, hostname), nil)
+				require.NoError(t, err, "Setup: couldn’t copy machine policies cache")
+			}
+
+			if tc.target == "" {
+				tc.target = "user"
+			}
+			if tc.format == "" {
+				tc.format = policies.FormatJSON
+			}
+			got, err := m.DumpPoliciesStructured(context.Background(), tc.target, tc.computerOnly, tc.format, tc.filter)
+			if tc.wantErr {
+				require.Error(t, err, "DumpPoliciesStructured should return an error but got none")
+				return
+			}
+			require.NoError(t, err, "DumpPoliciesStructured should return no error but got one")
+
+			want := testutils.LoadWithUpdateFromGolden(t, got)
+			require.Equal(t, want, got, "DumpPoliciesStructured returned expected output")
+		})
+	}
+}
+
+func TestComparePolicies(t *testing.T) {
+	t.Parallel()
+
+	bus := testutils.NewDbusConn(t)
+
+	// Fixed machine name to keep the output stable
+	hostname := "machine"
+
+	tests := map[string]struct {
+		cachePoliciesUser  string
+		cachePoliciesOther string
+		cachePolicyMachine string
+		target             string
+		other              string
+		computerOnly       bool
+		exportFormat       string
+		invalidExport      bool
+
+		wantErr bool
+	}{
+		"Same policies":                      {cachePoliciesUser: "one_gpo", cachePoliciesOther: "one_gpo"},
+		"Different policies":                 {cachePoliciesUser: "one_gpo", cachePoliciesOther: "one_gpo_other"},
+		"Only enforced entries are compared": {cachePoliciesUser: "two_gpos_with_overrides", cachePoliciesOther: "two_gpos_no_override"},
+		"Report-only entries are not compared": {
+			cachePoliciesUser:  "dconf_report_only",
+			cachePoliciesOther: "-",
+		},
+		"Machines":         {cachePolicyMachine: "one_gpo", cachePoliciesOther: "one_gpo_other", target: hostname, computerOnly: true},
+		"JSON export":      {cachePoliciesUser: "one_gpo", cachePoliciesOther: "one_gpo_other", exportFormat: policies.FormatJSON},
+		"YAML export":      {cachePoliciesUser: "one_gpo", cachePoliciesOther: "one_gpo_other", exportFormat: policies.FormatYAML},
+		"Identical export": {cachePoliciesUser: "one_gpo", cachePoliciesOther: "one_gpo", exportFormat: policies.FormatYAML},
+
+		// Error cases
+		"Error on missing target cache":            {cachePoliciesOther: "one_gpo", wantErr: true},
+		"Error on missing other target cache":      {cachePoliciesUser: "one_gpo", wantErr: true},
+		"Error on missing target cache for export": {cachePoliciesOther: "one_gpo", exportFormat: policies.FormatJSON, wantErr: true},
+		"Error on invalid export":                  {cachePoliciesUser: "one_gpo", invalidExport: true, wantErr: true},
+	}
+
+	for name, tc := range tests {
+		tc := tc
+		name := name
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			cacheDir, runDir := t.TempDir(), t.TempDir()
+			m, err := policies.NewManager(bus, hostname, policies.WithCacheDir(cacheDir), policies.WithRunDir(runDir))
+			require.NoError(t, err, "Setup: couldn’t get a new policy manager")
+
+			err = os.MkdirAll(filepath.Join(cacheDir, policies.PoliciesCacheBaseName), 0750)
+			require.NoError(t, err, "Setup: cant not create policies cache directory")
+
+			setupCache := func(object, cache string) {
+				dest := filepath.Join(cacheDir, policies.PoliciesCacheBaseName, object)
+				switch cache {
+				case "":
+				case "-":
+					err = os.MkdirAll(dest, 0750)
+					require.NoError(t, err, "Setup: cant not create policies cache directory")
+					f, err := os.Create(filepath.Join(dest, "policies"))
+					require.NoError(t, err, "Setup: failed to create empty policies cache")
+					f.Close()
+				default:
+					err := shutil.CopyTree(filepath.Join("testdata", "cache", "policies", cache), dest, nil)
+					require.NoError(t, err, "Setup: couldn’t copy policies cache")
+				}
+			}
+			if tc.cachePolicyMachine == "" {
+				tc.cachePolicyMachine = "-"
+			}
+			setupCache(hostname, tc.cachePolicyMachine)
+			setupCache("user", tc.cachePoliciesUser)
+			setupCache("other", tc.cachePoliciesOther)
+
+			if tc.target == "" {
+				tc.target = "user"
+			}
+			if tc.other == "" {
+				tc.other = "other"
+			}
+
+			var got string
+			switch {
+			case tc.invalidExport:
+				got, err = m.ComparePoliciesWithExport(context.Background(), tc.target, tc.computerOnly, []byte("this is not an export"))
+			case tc.exportFormat != "":
+				export, exportErr := m.DumpPoliciesStructured(context.Background(), tc.other, tc.computerOnly, tc.exportFormat, policies.DumpFilter{})
+				require.NoError(t, exportErr, "Setup: couldn’t export policies")
+				got, err = m.ComparePoliciesWithExport(context.Background(), tc.target, tc.computerOnly, []byte(export))
+			default:
+				got, err = m.ComparePolicies(context.Background(), tc.target, tc.other, tc.computerOnly)
+			}
+			if tc.wantErr {
+				require.Error(t, err, "ComparePolicies should return an error but got none")
+				return
+			}
+			require.NoError(t, err, "ComparePolicies should return no error but got one")
+
+			want := testutils.LoadWithUpdateFromGolden(t, got)
+			require.Equal(t, want, got, "ComparePolicies returned expected output")
+		})
+	}
+}
+
+func TestSearchPolicies(t *testing.T) {
+	t.Parallel()
+
+	bus := testutils.NewDbusConn(t)
+
+	// Fixed machine name to keep the output alignment stable
+	hostname := "machine"
+
+	tests := map[string]struct {
+		cachePoliciesUser  string
+		cachePolicyMachine string
+		target             string
+		computerOnly       bool
+		query              string
+		precedence         map[string]string
+
+		wantErr bool
+	}{
+		"Match on key":                   {cachePoliciesUser: "two_gpos_no_override", query: "Gpo2key1"},
+		"Match on value":                 {cachePoliciesUser: "two_gpos_no_override", query: "ValueOfGpo1Key2"},
+		"Match is case insensitive":      {cachePoliciesUser: "two_gpos_no_override", query: "gpo1KEY"},
+		"Match disabled entries":         {cachePoliciesUser: "two_gpos_with_overrides", query: "Gpo1key3"},
+		"Overridden entries are omitted": {cachePoliciesUser: "two_gpos_with_overrides", query: "Gpo1key1"},
+		"Match on machine and user entries": {
+			cachePoliciesUser:  "one_gpo",
+			cachePolicyMachine: "one_gpo_other",
+			query:              "key",
+		},
+		"Machine only": {
+			cachePolicyMachine: "one_gpo",
+			target:             hostname,
+			computerOnly:       true,
+			query:              "key",
+		},
+		"Most restrictive entries win": {
+			cachePoliciesUser: "dconf_most_restrictive",
+			query:             "delay",
+			precedence:        map[string]string{"dconf": policies.PrecedenceMostRestrictive},
+		},
+		"Report-only entries are listed separately": {cachePoliciesUser: "dconf_report_only", query: "key"},
+		"Only report-only entries match":            {cachePoliciesUser: "dconf_report_only", query: "NewReported"},
+		"No match":                                  {cachePoliciesUser: "two_gpos_no_override", query: "doesnotmatch"},
+
+		// Error cases
+		"Error on missing target cache": {query: "key", wantErr: true},
+		"Error on missing machine cache when targeting user": {
+			cachePoliciesUser:  "one_gpo",
+			cachePolicyMachine: "-",
+			query:              "key",
+			wantErr:            true,
+		},
+	}
+
+	for name, tc := range tests {
+		tc := tc
+		name := name
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			cacheDir, runDir := t.TempDir(), t.TempDir()
+			m, err := policies.NewManager(bus, hostname, policies.WithCacheDir(cacheDir), policies.WithRunDir(runDir), policies.WithPrecedence(tc.precedence))
+			require.NoError(t, err, "Setup: couldn’t get a new policy manager")
+
+			err = os.MkdirAll(filepath.Join(cacheDir, policies.PoliciesCacheBaseName), 0750)
+			require.NoError(t, err, "Setup: cant not create policies cache directory")
+
+			if tc.cachePoliciesUser != "" {
+				err := shutil.CopyTree(filepath.Join("testdata", "cache", "policies", tc.cachePoliciesUser), filepath.Join(cacheDir, policies.PoliciesCacheBaseName, "user"), nil)
+				require.NoError(t, err, "Setup: couldn’t copy user policies cache")
+			}
+			if tc.cachePolicyMachine == "" {
+				machinePolicyCache := filepath.Join(cacheDir, policies.PoliciesCacheBaseName, hostname)
+				err = os.MkdirAll(machinePolicyCache, 0750)
+				require.NoError(t, err, "Setup: cant not create machine policies cache directory")
+				f, err := os.Create(filepath.Join(machinePolicyCache, "policies"))
+				require.NoError(t, err, "Setup: failed to create empty machine policies cache")
+				f.Close()
+			} else if tc.cachePolicyMachine != "-" {
+				err := shutil.CopyTree(filepath.Join("testdata", "cache", "policies", tc.cachePolicyMachine), filepath.Join(cacheDir, policies.PoliciesCacheBaseName, hostname), nil)
+				require.NoError(t, err, "Setup: couldn’t copy machine policies cache")
+			}
+
+			if tc.target == "" {
+				tc.target = "user"
+			}
+			got, err := m.SearchPolicies(context.Background(), tc.target, tc.computerOnly, tc.query)
+			if tc.wantErr {
+				require.Error(t, err, "SearchPolicies should return an error but got none")
+				return
+			}
+			require.NoError(t, err, "SearchPolicies should return no error but got one")
+
+			want := testutils.LoadWithUpdateFromGolden(t, got)
+			require.Equal(t, want, got, "SearchPolicies returned expected output")
+		})
+	}
+}
+
+func TestSimulatePolicies(t *testing.T) {
+	t.Parallel()
+
+	bus := testutils.NewDbusConn(t)
+
+	simulated := policies.GPO{
+		ID:   "{SimulatedGPOId}",
+		Name: "SimulatedGPOName",
+		Rules: map[string][]entry.Entry{
+			"dconf": {
+				{Key: "path/to/key1", Value: "SimulatedValueOfKey1", Meta: "s"},
+				{Key: "path/to/simulated", Value: "ValueOfSimulated", Meta: "s"},
+			},
+		},
+	}
+
+	tests := map[string]struct {
+		cachedPolicies string
+		gpo            *policies.GPO
+		withOverridden bool
+		noCache        bool
+
+		wantErr bool
+	}{
+		"Simulated GPO has the highest precedence":           {cachedPolicies: "one_gpo"},
+		"Simulated GPO with overridden entries":              {cachedPolicies: "one_gpo", withOverridden: true},
+		"Simulated GPO replaces its applied version":         {cachedPolicies: "one_gpo", gpo: &policies.GPO{ID: "{GPOId}", Name: "GPONewName", Rules: simulated.Rules}},
+		"Simulated GPO without rules keeps applied policies": {cachedPolicies: "two_gpos_with_overrides", gpo: &policies.GPO{ID: "{SimulatedGPOId}", Name: "SimulatedGPOName"}},
+		"Simulated GPO merged with several applied GPOs":     {cachedPolicies: "two_gpos_with_overrides", withOverridden: true},
+
+		// Error cases
+		"Error on no cached policies": {noCache: true, wantErr: true},
+	}
+
+	for name, tc := range tests {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			cacheDir, runDir := t.TempDir(), t.TempDir()
+			m, err := policies.NewManager(bus, "machine", policies.WithCacheDir(cacheDir), policies.WithRunDir(runDir))
+			require.NoError(t, err, "Setup: couldn’t get a new policy manager")
+
+			if !tc.noCache {
+				err := shutil.CopyTree(filepath.Join("testdata", "cache", "policies", tc.cachedPolicies), filepath.Join(cacheDir, policies.PoliciesCacheBaseName, "user"), nil)
+				require.NoError(t, err, "Setup: couldn’t copy user policies cache")
+			}
+
+			g := simulated
+			if tc.gpo != nil {
+				g = *tc.gpo
+			}
+
+			got, err := m.SimulatePolicies(context.Background(), "user", g, tc.withOverridden)
+			if tc.wantErr {
+				require.Error(t, err, "SimulatePolicies should return an error but got none")
+				return
+			}
+			require.NoError(t, err, "SimulatePolicies should return no error but got one")
+
+			want := testutils.LoadWithUpdateFromGolden(t, got)
+			require.Equal(t, want, got, "SimulatePolicies returned expected output")
+		})
+	}
+}
+
+func TestWhoHas(t *testing.T) {
+	t.Parallel()
+
+	bus := testutils.NewDbusConn(t)
+
+	hostname := "machine"
+	users := map[string]string{
+		"alice": "one_gpo",
+		"bob":   "simple",
+		"carol": "two_gpos_with_overrides",
+		"erin":  "dconf_most_restrictive",
+	}
+
+	tests := map[string]struct {
+		key             string
+		value           string
+		invalidCache    bool
+		noCache         bool
+		unreadableCache bool
+		precedence      map[string]string
+
+		wantErr bool
+	}{
+		"Users receiving key":                       {key: "path/to/key1"},
+		"Key can be prefixed by policy manager":     {key: "dconf/path/to/key2"},
+		"Users receiving key with value":            {key: "path/to/key2", value: "ValueOfKey2"},
+		"Users receiving key with multilines value": {key: "path/to/key2", value: "ValueOfKey2\nOn\nMultilines"},
+		"Overridden entries are not listed":         {key: "path/to/Gpo1key1"},
+		"Overridden values are not matched":         {key: "path/to/Gpo1key1", value: "OverriddenValueOfKey1"},
+		"Disabled entries are listed":               {key: "path/to/key3"},
+		"Disabled entries don't match a value":      {key: "path/to/key3", value: "ValueOfKey3"},
+		"Machine policies are not listed":           {key: "path/to/key1", value: "ValueOfKey1"},
+		"Most restrictive entries are listed": {key: "org/gnome/desktop/session/idle-delay",
+			precedence: map[string]string{"dconf": policies.PrecedenceMostRestrictive}},
+		"Invalid user cache is skipped": {key: "path/to/key1", invalidCache: true},
+		"No user receives key":          {key: "path/to/doesnotexist"},
+		"No user policies cached":       {key: "path/to/key1", noCache: true},
+
+		// Error cases
+		"Error on unreadable policies cache": {key: "path/to/key1", unreadableCache: true, wantErr: true},
+	}
+
+	for name, tc := range tests {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			cacheDir, runDir := t.TempDir(), t.TempDir()
+			m, err := policies.NewManager(bus, hostname, policies.WithCacheDir(cacheDir), policies.WithRunDir(runDir), policies.WithPrecedence(tc.precedence))
+			require.NoError(t, err, "Setup: couldn’t get a new policy manager")
+
+			if !tc.noCache {
+				for u, p := range users {
+					err := shutil.CopyTree(filepath.Join("testdata", "cache", "policies", p), filepath.Join(cacheDir, policies.PoliciesCacheBaseName, u), nil)
+					require.NoError(t, err, "Setup: couldn’t copy user policies cache")
+				}
+				err := shutil.CopyTree(filepath.Join("testdata", "cache", "policies", "one_gpo"), filepath.Join(cacheDir, policies.PoliciesCacheBaseName, hostname), nil)
+				require.NoError(t, err, "Setup: couldn’t copy machine policies cache")
+				if tc.invalidCache {
+					err := shutil.CopyTree(filepath.Join("testdata", "cache", "policies", "invalid_policies_cache"), filepath.Join(cacheDir, policies.PoliciesCacheBaseName, "dave"), nil)
+					require.NoError(t, err, "Setup: couldn’t copy invalid user policies cache")
+				}
+			}
+
+			if tc.unreadableCache {
+				require.NoError(t, os.RemoveAll(filepath.Join(cacheDir, policies.PoliciesCacheBaseName)), "Setup: can't remove policies cache")
+				require.NoError(t, os.WriteFile(filepath.Join(cacheDir, policies.PoliciesCacheBaseName), nil, 0600), "Setup: can't replace policies cache with a file")
+			}
+
+			got, err := m.WhoHas(context.Background(), tc.key, tc.value)
+			if tc.wantErr {
+				require.Error(t, err, "WhoHas should return an error but got none")
+				return
+			}
+			require.NoError(t, err, "WhoHas should return no error but got one")
+
+			want := testutils.LoadWithUpdateFromGolden(t, got)
+			require.Equal(t, want, got, "WhoHas returned expected output")
+		})
+	}
+}
+
+func TestOwns(t *testing.T) {
+	// We change the dbus returned values to simulate a subscription
+	//t.Parallel()
+
+	bus := testutils.NewDbusConn(t)
+
+	tests := map[string]struct {
+		path          string
+		manager       string
+		format        string
+		modify        string
+		remove        string
+		invalidRecord bool
+		noApply       bool
+		unreadable    bool
+
+		wantErr bool
+	}{
+		"List managed files":                       {},
+		"List managed files with their state":      {modify: "etc/sudoers.d/99-adsys-privilege-enforcement", remove: "etc/apparmor.d/adsys/machine/usr.bin.foo"},
+		"List managed files of a policy manager":   {manager: "privilege"},
+		"Path managed by another policy manager":   {path: "/etc/sudoers.d/99-adsys-privilege-enforcement", manager: "apparmor"},
+		"Invalid record is skipped":                {invalidRecord: true},
+		"No managed files when nothing applied":    {noApply: true},
+		"Path managed by a policy manager":         {path: "/etc/sudoers.d/99-adsys-privilege-enforcement"},
+		"Path edited by gpp items":                 {path: "/etc/adsys-tests/app.ini"},
+		"Path is cleaned before lookup":            {path: "/etc/sudoers.d/../sudoers.d/99-adsys-privilege-enforcement"},
+		"Modified path is reported":                {path: "/etc/sudoers.d/99-adsys-privilege-enforcement", modify: "etc/sudoers.d/99-adsys-privilege-enforcement"},
+		"Missing path is reported":                 {path: "/etc/apparmor.d/adsys/machine/usr.bin.foo", remove: "etc/apparmor.d/adsys/machine/usr.bin.foo"},
+		"Path not managed by adsys":                {path: "/etc/hostname"},
+		"Directories are not managed files":        {path: "/etc/sudoers.d"},
+		"Flag files are not managed files":         {path: "/run/adsys/machine/scripts/.ready"},
+		"Path not managed when nothing is applied": {path: "/etc/sudoers.d/99-adsys-privilege-enforcement", noApply: true},
+
+		// Structured formats
+		"List managed files by policy manager in json":                 {format: "json"},
+		"List managed files by policy manager in yaml":                 {format: "yaml"},
+		"List managed files with their state in json":                  {format: "json", modify: "etc/sudoers.d/99-adsys-privilege-enforcement", remove: "etc/apparmor.d/adsys/machine/usr.bin.foo"},
+		"List managed files of a policy manager in json":               {format: "json", manager: "privilege"},
+		"List managed files of a policy manager without files in json": {format: "json", manager: "environment"},
+		"No managed files when nothing applied in json":                {format: "json", noApply: true},
+
+		// Error cases
+		"Error on unreadable record":              {unreadable: true, wantErr: true},
+		"Error on unknown policy manager":         {manager: "unknown", wantErr: true},
+		"Error on unknown policy manager in json": {format: "json", manager: "unknown", wantErr: true},
+		"Error on unsupported format":             {format: "xml", wantErr: true},
+		"Error on unreadable record in json":      {format: "json", unreadable: true, wantErr: true},
+	}
+	for name, tc := range tests {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			pols, err := policies.NewFromCache(context.Background(), filepath.Join("testdata", "cache", "policies", "all_entry_types"))
+			require.NoError(t, err, "Setup: can not load policies list")
+			defer pols.Close()
+
+			root := adsystest.NewFakeRoot(t)
+			loadedPoliciesFile := filepath.Join(root.Dir, "sys", "kernel", "security", "apparmor", "profiles")
+			require.NoError(t, os.MkdirAll(filepath.Dir(loadedPoliciesFile), 0700), "Setup: can not create loadedPoliciesFile dir")
+			require.NoError(t, os.WriteFile(loadedPoliciesFile, []byte("someprofile (enforce)\n"), 0600), "Setup: can not create loadedPoliciesFile")
+
+			adsystest.SetSubscriptionAttached(t, bus, true)
+
+			m, err := policies.NewManager(bus, "hostname",
+				policies.WithCacheDir(root.CacheDir),
+				policies.WithRunDir(root.RunDir),
+				policies.WithDconfDir(root.DconfDir),
+				policies.WithPolicyKitDir(root.PolicyKitDir),
+				policies.WithSudoersDir(root.SudoersDir),
+				policies.WithApparmorDir(root.ApparmorDir),
+				policies.WithApparmorFsDir(filepath.Dir(loadedPoliciesFile)),
+				policies.WithApparmorParserCmd([]string{"/bin/true"}),
+				policies.WithSystemUnitDir(root.SystemUnitDir),
+				policies.WithGPPRootDir(root.Dir),
+				policies.WithSSSDConf(root.SSSDConf),
+				policies.WithNetplanDir(root.NetplanDir),
+				policies.WithJournaldConfDir(root.JournaldDir),
+				policies.WithNetplanCmd([]string{"/bin/true"}),
+				policies.WithPluginsDir(root.PluginsDir),
+				policies.WithHooksDir(root.HooksDir),
+				policies.WithProxyApplier(&mockProxyApplier{}),
+				policies.WithSystemdCaller(&testutils.MockSystemdCaller{}),
+			)
+			require.NoError(t, err, "Setup: couldn’t get a new policy manager")
+
+			if !tc.noApply {
+				err = m.ApplyPolicies(context.Background(), "hostname", true, &pols)
+				require.NoError(t, err, "Setup: ApplyPolicies should return no error but got one")
+			}
+
+			if tc.modify != "" {
+				require.NoError(t, os.WriteFile(filepath.Join(root.Dir, tc.modify), []byte("modified content\n"), 0600), "Setup: can't modify managed file")
+			}
+			if tc.remove != "" {
+				require.NoError(t, os.Remove(filepath.Join(root.Dir, tc.remove)), "Setup: can't remove managed file")
+			}
+			ownedDir := filepath.Join(root.CacheDir, policies.OwnedCacheBaseName)
+			if tc.invalidRecord {
+				require.NoError(t, os.WriteFile(filepath.Join(ownedDir, "alice"), []byte("invalid: ["), 0600), "Setup: can't write invalid record")
+			}
+			if tc.unreadable {
+				require.NoError(t, os.Mkdir(filepath.Join(ownedDir, "alice"), 0700), "Setup: can't replace record with a directory")
+			}
+
+			path := tc.path
+			if path != "" {
+				path = root.Dir + path
+			}
+			var got string
+			if tc.format == "" {
+				got, err = m.Owns(context.Background(), path, tc.manager)
+			} else {
+				got, err = m.OwnsStructured(context.Background(), tc.manager, tc.format)
+			}
+			if tc.wantErr {
+				require.Error(t, err, "Owns should return an error but got none")
+				return
+			}
+			require.NoError(t, err, "Owns should return no error but got one")
+
+			// Managed files are reported in the fake root.
+			got = strings.ReplaceAll(got, root.Dir, "")
+			want := testutils.LoadWithUpdateFromGolden(t, got)
+			require.Equal(t, want, got, "Owns returned expected output")
+		})
+	}
+}
+
+func TestReapplyModified(t *testing.T) {
+	// We change the dbus returned values to simulate a subscription
+	//t.Parallel()
+
+	bus := testutils.NewDbusConn(t)
+
+	tests := map[string]struct {
+		modify            []string
+		remove            []string
+		noCache           bool
+		unreadable        bool
+		proxyFailedBefore bool
+		proxyFailsNow     bool
+
+		wantErr bool
+	}{
+		"Nothing applied again when files are unchanged": {},
+		"Modified file is restored":                      {modify: []string{"etc/sudoers.d/99-adsys-privilege-enforcement"}},
+		"Removed file is restored":                       {remove: []string{"etc/apparmor.d/adsys/machine/usr.bin.foo"}},
+		"Policy managers of all modified files are applied again": {
+			modify: []string{"etc/sudoers.d/99-adsys-privilege-enforcement", "etc/polkit-1/localauthority.conf.d/99-adsys-privilege-enforcement.conf"},
+			remove: []string{"etc/apparmor.d/adsys/machine/usr.bin.foo"},
+		},
+		"Only the policy managers of modified files are applied again": {modify: []string{"etc/sudoers.d/99-adsys-privilege-enforcement"}, proxyFailsNow: true},
+		"Policy managers whose last apply failed are applied again":    {modify: []string{"etc/sudoers.d/99-adsys-privilege-enforcement"}, proxyFailedBefore: true},
+
+		// Error cases
+		"Error on unreadable record":               {unreadable: true, wantErr: true},
+		"Error on modified files without policies": {modify: []string{"etc/sudoers.d/99-adsys-privilege-enforcement"}, noCache: true, wantErr: true},
+	}
+	for name, tc := range tests {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			pols, err := policies.NewFromCache(context.Background(), filepath.Join("testdata", "cache", "policies", "all_entry_types"))
+			require.NoError(t, err, "Setup: can not load policies list")
+			defer pols.Close()
+
+			root := adsystest.NewFakeRoot(t)
+			loadedPoliciesFile := filepath.Join(root.Dir, "sys", "kernel", "security", "apparmor", "profiles")
+			require.NoError(t, os.MkdirAll(filepath.Dir(loadedPoliciesFile), 0700), "Setup: can not create loadedPoliciesFile dir")
+			require.NoError(t, os.WriteFile(loadedPoliciesFile, []byte("someprofile (enforce)\n"), 0600), "Setup: can not create loadedPoliciesFile")
+
+			adsystest.SetSubscriptionAttached(t, bus, true)
+
+			proxyApplier := &mockProxyApplier{}
+			m, err := policies.NewManager(bus, "hostname",
+				policies.WithCacheDir(root.CacheDir),
+				policies.WithRunDir(root.RunDir),
+				policies.WithDconfDir(root.DconfDir),
+				policies.WithPolicyKitDir(root.PolicyKitDir),
+				policies.WithSudoersDir(root.SudoersDir),
+				policies.WithApparmorDir(root.ApparmorDir),
+				policies.WithApparmorFsDir(filepath.Dir(loadedPoliciesFile)),
+				policies.WithApparmorParserCmd([]string{"/bin/true"}),
+				policies.WithSystemUnitDir(root.SystemUnitDir),
+				policies.WithGPPRootDir(root.Dir),
+				policies.WithSSSDConf(root.SSSDConf),
+				policies.WithNetplanDir(root.NetplanDir),
+				policies.WithJournaldConfDir(root.JournaldDir),
+				policies.WithNetplanCmd([]string{"/bin/true"}),
+				policies.WithPluginsDir(root.PluginsDir),
+				policies.WithHooksDir(root.HooksDir),
+				policies.WithProxyApplier(proxyApplier),
+				policies.WithSystemdCaller(&testutils.MockSystemdCaller{}),
+			)
+			require.NoError(t, err, "Setup: couldn’t get a new policy manager")
+
+			err = m.ApplyPolicies(context.Background(), "hostname", true, &pols)
+			require.NoError(t, err, "Setup: ApplyPolicies should return no error but got one")
+			statusPath := filepath.Join(root.CacheDir, policies.StatusCacheBaseName, "hostname")
+			wantStatus, err := os.ReadFile(statusPath)
+			require.NoError(t, err, "Setup: ApplyPolicies should have saved the status")
+			if tc.proxyFailedBefore {
+				proxyApplier.wantApplyError = true
+				err = m.ApplyPolicies(context.Background(), "hostname", true, &pols)
+				require.Error(t, err, "Setup: ApplyPolicies should fail with the proxy policy manager failing")
+			}
+			// Policy managers without modified files would now fail if they were applied again.
+			proxyApplier.wantApplyError = tc.proxyFailsNow
+
+			contents := make(map[string][]byte)
+			for _, p := range append(tc.modify, tc.remove...) {
+				d, err := os.ReadFile(filepath.Join(root.Dir, p))
+				require.NoError(t, err, "Setup: can't read managed file")
+				contents[p] = d
+			}
+			for _, p := range tc.modify {
+				require.NoError(t, os.WriteFile(filepath.Join(root.Dir, p), []byte("modified content\n"), 0600), "Setup: can't modify managed file")
+			}
+			for _, p := range tc.remove {
+				require.NoError(t, os.Remove(filepath.Join(root.Dir, p)), "Setup: can't remove managed file")
+			}
+			if tc.noCache {
+				require.NoError(t, os.RemoveAll(filepath.Join(root.CacheDir, policies.PoliciesCacheBaseName, "hostname")), "Setup: can't remove cached policies")
+			}
+			if tc.unreadable {
+				require.NoError(t, os.Mkdir(filepath.Join(root.CacheDir, policies.OwnedCacheBaseName, "alice"), 0700), "Setup: can't replace record with a directory")
+			}
+
+			got, err := m.ReapplyModified(context.Background())
+			if tc.wantErr {
+				require.Error(t, err, "ReapplyModified should return an error but got none")
+				return
+			}
+			require.NoError(t, err, "ReapplyModified should return no error but got one")
+
+			for p, want := range contents {
+				d, err := os.ReadFile(filepath.Join(root.Dir, p))
+				require.NoError(t, err, "Managed file should have been restored")
+				require.Equal(t, string(want), string(d), "Managed file should have the content of the last apply")
+			}
+
+			states, err := m.OwnsStructured(context.Background(), "", policies.FormatYAML)
+			require.NoError(t, err, "OwnsStructured should return no error but got one")
+			require.NotRegexp(t, "state: (modified|missing)", states, "All managed files should match the last apply after applying policies again")
+
+			gotStatus, err := os.ReadFile(statusPath)
+			require.NoError(t, err, "ReapplyModified should have kept the status")
+			require.Equal(t, string(wantStatus), string(gotStatus), "ReapplyModified should report the status of the last successful apply")
+
+			want := testutils.LoadWithUpdateFromGolden(t, got)
+			require.Equal(t, want, got, "ReapplyModified returned expected output")
+		})
+	}
+}
+
+func TestReapplyExpired(t *testing.T) {
+	// We change the dbus returned values to simulate a subscription
+	//t.Parallel()
+
+	bus := testutils.NewDbusConn(t)
+
+	u, err := user.Current()
+	require.NoError(t, err, "Setup: can't get current user")
+
+	tests := map[string]struct {
+		expiry    string
+		noCache   bool
+		user      bool
+		loggedOut bool
+
+		wantTimer bool
+		wantErr   bool
+	}{
+		"Nothing applied again before the next expiry":                       {expiry: "2099-06-30T06:30:00Z", wantTimer: true},
+		"Policies with expired entries are applied again":                    {expiry: "2000-01-01T12:00:00Z", wantTimer: true},
+		"Nothing applied again without expiring entries":                     {},
+		"Policies of logged in users with expired entries are applied again": {expiry: "2000-01-01T12:00:00Z", user: true, wantTimer: true},
+		"Policies of users not logged in are not applied again":              {expiry: "2000-01-01T12:00:00Z", user: true, loggedOut: true, wantTimer: true},
+
+		// Error cases
+		"Error on invalid expiry record":            {expiry: "tomorrow", wantErr: true},
+		"Error on expired entries without policies": {expiry: "2000-01-01T12:00:00Z", noCache: true, wantErr: true},
+	}
+	for name, tc := range tests {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			pols, err := policies.NewFromCache(context.Background(), filepath.Join("testdata", "cache", "policies", "dconf_expiry"))
+			require.NoError(t, err, "Setup: can not load policies list")
+			defer pols.Close()
+
+			root := adsystest.NewFakeRoot(t)
+			adsystest.SetSubscriptionAttached(t, bus, true)
+
+			m, err := policies.NewManager(bus, "hostname",
+				policies.WithCacheDir(root.CacheDir),
+				policies.WithRunDir(root.RunDir),
+				policies.WithDconfDir(root.DconfDir),
+				policies.WithPolicyKitDir(root.PolicyKitDir),
+				policies.WithSudoersDir(root.SudoersDir),
+				policies.WithApparmorDir(root.ApparmorDir),
+				policies.WithSystemUnitDir(root.SystemUnitDir),
+				policies.WithGPPRootDir(root.Dir),
+				policies.WithSSSDConf(root.SSSDConf),
+				policies.WithNetplanDir(root.NetplanDir),
+				policies.WithJournaldConfDir(root.JournaldDir),
+				policies.WithPluginsDir(root.PluginsDir),
+				policies.WithHooksDir(root.HooksDir),
+				policies.WithProxyApplier(&mockProxyApplier{}),
+				policies.WithSystemdCaller(&testutils.MockSystemdCaller{}),
+			)
+			require.NoError(t, err, "Setup: couldn’t get a new policy manager")
+
+			objectName := "hostname"
+			if tc.user {
+				objectName = u.Username
+			}
+			var activeUsers []string
+			if tc.user && !tc.loggedOut {
+				activeUsers = []string{u.Username}
+			}
+
+			if tc.expiry != "" {
+				err = m.ApplyPolicies(context.Background(), "hostname", true, &pols)
+				require.NoError(t, err, "Setup: ApplyPolicies should return no error but got one")
+				if tc.user {
+					err = m.ApplyPolicies(context.Background(), objectName, false, &pols)
+					require.NoError(t, err, "Setup: ApplyPolicies should return no error but got one")
+				}
+				// Mimic the entries expiring since the last apply.
+				require.NoError(t, os.WriteFile(filepath.Join(root.CacheDir, policies.ExpiryCacheBaseName, objectName), []byte(tc.expiry), 0600), "Setup: can't write expiry record")
+			}
+			if tc.noCache {
+				require.NoError(t, os.RemoveAll(filepath.Join(root.CacheDir, policies.PoliciesCacheBaseName, "hostname")), "Setup: can't remove cached policies")
+			}
+
+			got, err := m.ReapplyExpired(context.Background(), activeUsers)
+			if tc.wantErr {
+				require.Error(t, err, "ReapplyExpired should return an error but got none")
+				return
+			}
+			require.NoError(t, err, "ReapplyExpired should return no error but got one")
+
+			if tc.wantTimer {
+				d, err := os.ReadFile(filepath.Join(root.CacheDir, policies.ExpiryCacheBaseName, "hostname"))
+				require.NoError(t, err, "Next expiry should be recorded")
+				require.Equal(t, "2099-06-30T06:30:00Z", string(d), "Next expiry should be the earliest one not passed yet")
+				require.FileExists(t, filepath.Join(root.SystemUnitDir, "adsys-policy-expiry.timer"), "Expiry timer should be scheduled")
+			} else {
+				require.NoFileExists(t, filepath.Join(root.SystemUnitDir, "adsys-policy-expiry.timer"), "Expiry timer should not be scheduled")
+			}
+
+			if tc.user {
+				d, err := os.ReadFile(filepath.Join(root.CacheDir, policies.ExpiryCacheBaseName, objectName))
+				require.NoError(t, err, "Expiry of the user should be recorded")
+				if tc.loggedOut {
+					require.Equal(t, tc.expiry, string(d), "Expired entries of users not logged in should be reverted at their next login")
+				} else {
+					require.Equal(t, "2099-06-30T06:30:00Z", string(d), "Next expiry of the user should be the earliest one not passed yet")
+				}
+				got = strings.ReplaceAll(got, u.Username, "USER")
+			}
+
+			want := testutils.LoadWithUpdateFromGolden(t, got)
+			require.Equal(t, want, got, "ReapplyExpired returned expected output")
+		})
+	}
+}
+
+func TestLastApplyStatus(t *testing.T) {
+	t.Parallel()
+
+	bus := testutils.NewDbusConn(t)
+
+	// Fixed machine name to keep the output alignment stable
+	hostname := "machine"
+	applyTime := time.Date(2023, time.June, 1, 10, 0, 0, 0, time.UTC)
+
+	tests := map[string]struct {
+		statusUser     string
+		statusMachine  string
+		reportOnlyUser string
+		rotationState  string
+		target         string
+		computerOnly   bool
+		format         string
+
+		wantErr bool
+	}{
+		"All managers succeeded":                       {statusUser: "succeeded"},
+		"Some managers failed":                         {statusUser: "failed"},
+		"Machine failure is reported with user status": {statusUser: "succeeded", statusMachine: "failed"},
+		"Compliant report-only entries":                {statusUser: "succeeded", reportOnlyUser: "compliant"},
+		"Not compliant report-only entries": {
+			statusUser:     "succeeded",
+			reportOnlyUser: "* dconf:\n  ~ path/to/key1: EnforcedValue -> ReportedValue\n",
+		},
+		"Machine only": {
+			statusMachine: "failed",
+			target:        hostname,
+			computerOnly:  true,
+		},
+		"Managers failing repeatedly are reported": {statusUser: "failed_repeatedly"},
+		"Machine reports policy conflicts":         {statusUser: "succeeded", statusMachine: "conflicts"},
+		"Machine reports local password rotation": {
+			statusUser:    "succeeded",
+			rotationState: "account: root\ndirectory: windows\nrotated: 2023-05-20T10:00:00Z\nexpiration: 2023-06-19T10:00:00Z\n",
+		},
+		"Json format":              {statusUser: "failed", statusMachine: "conflicts", format: policies.FormatJSON},
+		"Yaml format":              {statusUser: "failed", statusMachine: "conflicts", format: policies.FormatYAML},
+		"Json format machine only": {statusMachine: "failed", target: hostname, computerOnly: true, format: policies.FormatJSON},
+
+		// Error cases
+		"Error on missing target status":                      {wantErr: true},
+		"Error on missing machine status when targeting user": {statusUser: "succeeded", statusMachine: "-", wantErr: true},
+		"Error on invalid status":                             {statusUser: "invalid", wantErr: true},
+		"Error on invalid status in json format":              {statusUser: "invalid", format: policies.FormatJSON, wantErr: true},
+		"Error on missing target status in json format":       {format: policies.FormatJSON, wantErr: true},
+		"Error on unsupported format":                         {statusUser: "succeeded", format: "xml", wantErr: true},
+	}
+
+	for name, tc := range tests {
+		tc := tc
+		name := name
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			cacheDir, runDir := t.TempDir(), t.TempDir()
+			m, err := policies.NewManager(bus, hostname, policies.WithCacheDir(cacheDir), policies.WithRunDir(runDir))
+			require.NoError(t, err, "Setup: couldn’t get a new policy manager")
+
+			statusDir := filepath.Join(cacheDir, policies.StatusCacheBaseName)
+			if tc.statusMachine == "" {
+				tc.statusMachine = "succeeded"
+			}
+			for object, status := range map[string]string{"user": tc.statusUser, hostname: tc.statusMachine} {
+				if status == "" || status == "-" {
+					continue
+				}
+				dst := filepath.Join(statusDir, object)
+				require.NoError(t, shutil.CopyFile(filepath.Join("testdata", "cache", "status", status), dst, false), "Setup: couldn’t copy status")
+				require.NoError(t, os.Chtimes(dst, applyTime, applyTime), "Setup: couldn’t set status time")
+			}
+
+			if tc.reportOnlyUser != "" {
+				changes := tc.reportOnlyUser
+				if changes == "compliant" {
+					changes = ""
+				}
+				reportOnlyDir := filepath.Join(cacheDir, policies.ReportOnlyCacheBaseName)
+				require.NoError(t, os.MkdirAll(reportOnlyDir, 0700), "Setup: couldn’t create report-only directory")
+				require.NoError(t, os.WriteFile(filepath.Join(reportOnlyDir, "user"), []byte(changes), 0600), "Setup: couldn’t write report-only changes")
+			}
+
+			if tc.rotationState != "" {
+				lapsDir := filepath.Join(cacheDir, "laps")
+				require.NoError(t, os.MkdirAll(lapsDir, 0700), "Setup: couldn’t create local password directory")
+				require.NoError(t, os.WriteFile(filepath.Join(lapsDir, "state"), []byte(tc.rotationState), 0600), "Setup: couldn’t write local password rotation state")
+			}
+
+			if tc.target == "" {
+				tc.target = "user"
+			}
+			var got string
+			if tc.format == "" {
+				got, err = m.LastApplyStatus(context.Background(), tc.target, tc.computerOnly)
+			} else {
+				got, err = m.LastApplyStatusStructured(context.Background(), tc.target, tc.computerOnly, tc.format)
+			}
+			if tc.wantErr {
+				require.Error(t, err, "LastApplyStatus should return an error but got none")
+				return
+			}
+			require.NoError(t, err, "LastApplyStatus should return no error but got one")
+
+			// The time is printed in the local timezone.
+			got = strings.ReplaceAll(got, applyTime.Local().Format(time.RFC3339), "APPLY_TIME")
+			want := testutils.LoadWithUpdateFromGolden(t, got)
+			require.Equal(t, want, got, "LastApplyStatus returned expected output")
+		})
+	}
+}
+
+func TestWriteMetrics(t *testing.T) {
+	t.Parallel()
+
+	bus := testutils.NewDbusConn(t)
+
+	hostname := "machine"
+	applyTime := time.Date(2023, time.June, 1, 10, 0, 0, 0, time.UTC)
+
+	tests := map[string]struct {
+		statuses map[string]string
+	}{
+		"Metrics of the machine and users": {statuses: map[string]string{hostname: "conflicts", "user": "failed_repeatedly"}},
+		"Invalid status has no metrics":    {statuses: map[string]string{hostname: "succeeded", "user": "invalid"}},
+		"No status has no metrics":         {},
+	}
+	for name, tc := range tests {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			cacheDir, runDir := t.TempDir(), t.TempDir()
+			metricsFile := filepath.Join(t.TempDir(), "textfile", "adsys.prom")
+			m, err := policies.NewManager(bus, hostname, policies.WithCacheDir(cacheDir), policies.WithRunDir(runDir), policies.WithMetricsFile(metricsFile))
+			require.NoError(t, err, "Setup: couldn’t get a new policy manager")
+
+			statusDir := filepath.Join(cacheDir, policies.StatusCacheBaseName)
+			for object, status := range tc.statuses {
+				dst := filepath.Join(statusDir, object)
+				require.NoError(t, shutil.CopyFile(filepath.Join("testdata", "cache", "status", status), dst, false), "Setup: couldn’t copy status")
+				require.NoError(t, os.Chtimes(dst, applyTime, applyTime), "Setup: couldn’t set status time")
+			}
+
+			m.WriteMetrics(context.Background())
+
+			got, err := os.ReadFile(metricsFile)
+			require.NoError(t, err, "WriteMetrics should have written the metrics file")
+			require.NoFileExists(t, metricsFile+".new", "WriteMetrics should not leave its temporary file")
+			want := testutils.LoadWithUpdateFromGolden(t, string(got))
+			require.Equal(t, want, string(got), "WriteMetrics should write the metrics of each object")
+		})
+	}
+}
+
+func TestUpdateStates(t *testing.T) {
+	t.Parallel()
+
+	bus := testutils.NewDbusConn(t)
+
+	hostname := "machine"
+	updateTime := time.Date(2023, time.June, 1, 10, 0, 0, 0, time.UTC)
+	applyTime := time.Date(2023, time.June, 2, 10, 0, 0, 0, time.UTC)
+
+	tests := map[string]struct {
+		caches   []string
+		statuses map[string]string
+
+		wantMachine policies.UpdateState
+		wantUsers   map[string]policies.UpdateState
+	}{
+		"Never applied": {wantUsers: map[string]policies.UpdateState{}},
+		"Succeeded applies": {
+			caches:      []string{hostname, "user"},
+			statuses:    map[string]string{hostname: "succeeded", "user": "succeeded"},
+			wantMachine: policies.UpdateState{LastUpdate: updateTime, LastApply: applyTime},
+			wantUsers:   map[string]policies.UpdateState{"user": {LastUpdate: updateTime, LastApply: applyTime}},
+		},
+		"Failed apply keeps last successful update": {
+			caches:      []string{hostname},
+			statuses:    map[string]string{hostname: "failed"},
+			wantMachine: policies.UpdateState{LastUpdate: updateTime, LastApply: applyTime, Failed: true},
+			wantUsers:   map[string]policies.UpdateState{},
+		},
+		"User without successful update": {
+			statuses:  map[string]string{"user": "failed"},
+			wantUsers: map[string]policies.UpdateState{"user": {LastApply: applyTime, Failed: true}},
+		},
+		"User without apply status": {
+			caches:    []string{"user"},
+			wantUsers: map[string]policies.UpdateState{"user": {LastUpdate: updateTime}},
+		},
+		"Invalid status is reported as failed": {
+			statuses:  map[string]string{"user": "invalid"},
+			wantUsers: map[string]policies.UpdateState{"user": {LastApply: applyTime, Failed: true}},
+		},
+	}
+
+	for name, tc := range tests {
+		tc := tc
+		name := name
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			cacheDir, runDir := t.TempDir(), t.TempDir()
+			m, err := policies.NewManager(bus, hostname, policies.WithCacheDir(cacheDir), policies.WithRunDir(runDir))
+			require.NoError(t, err, "Setup: couldn’t get a new policy manager")
+
+			for _, object := range tc.caches {
+				dst := filepath.Join(cacheDir, policies.PoliciesCacheBaseName, object)
+				require.NoError(t, os.MkdirAll(dst, 0700), "Setup: couldn’t create policies cache")
+				require.NoError(t, os.Chtimes(dst, updateTime, updateTime), "Setup: couldn’t set policies cache time")
+			}
+			for object, status := range tc.statuses {
+				dst := filepath.Join(cacheDir, policies.StatusCacheBaseName, object)
+				require.NoError(t, shutil.CopyFile(filepath.Join("testdata", "cache", "status", status), dst, false), "Setup: couldn’t copy status")
+				require.NoError(t, os.Chtimes(dst, applyTime, applyTime), "Setup: couldn’t set status time")
+			}
+
+			machine, users, err := m.UpdateStates(context.Background())
+			require.NoError(t, err, "UpdateStates should return no error but got one")
+
+			requireUpdateStateEqual(t, tc.wantMachine, machine, "machine")
+			require.Len(t, users, len(tc.wantUsers), "UpdateStates returned unexpected users")
+			for u, want := range tc.wantUsers {
+				got, ok := users[u]
+				require.True(t, ok, "UpdateStates should return the state of %q", u)
+				requireUpdateStateEqual(t, want, got, u)
+			}
+		})
+	}
+}
+
+// requireUpdateStateEqual compares update states, with times in any location.
+func requireUpdateStateEqual(t *testing.T, want, got policies.UpdateState, object string) {
+	t.Helper()
+
+	require.True(t, want.LastUpdate.Equal(got.LastUpdate), "Unexpected last update of %q: %v", object, got.LastUpdate)
+	require.True(t, want.LastApply.Equal(got.LastApply), "Unexpected last apply of %q: %v", object, got.LastApply)
+	require.Equal(t, want.Failed, got.Failed, "Unexpected last apply result of %q", object)
+}
+
+func TestLastUpdateFor(t *testing.T) {
+	t.Parallel()
+
+	bus := testutils.NewDbusConn(t)
+
+	hostname, err := os.Hostname()
+	require.NoError(t, err, "Setup: failed to get hostname")
+
+	tests := map[string]struct {
+		target    string
+		isMachine bool
+
+		wantErr bool
+	}{
+		"Returns user's last update time":       {target: "user"},
+		"Returns machine's last update time":    {target: hostname, isMachine: true},
+		"Target is ignored for machine request": {target: "does_not_exit", isMachine: true},
+
+		// Error cases
+		"Error when target does not exist": {target: "does_not_exit", wantErr: true},
+	}
+
+	for name, tc := range tests {
+		tc := tc
+		name := name
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			cacheDir, runDir := t.TempDir(), t.TempDir()
+			m, err := policies.NewManager(bus, hostname, policies.WithCacheDir(cacheDir), policies.WithRunDir(runDir))
+			require.NoError(t, err, "Setup: couldn’t get a new policy manager")
+
+			err = os.MkdirAll(filepath.Join(cacheDir, policies.PoliciesCacheBaseName), 0750)
+			require.NoError(t, err, "Setup: cant not create policies cache directory")
+
+			start := time.Now()
+			// Starts and ends are monotic, while os.Stat is wall clock, we have to wait for measuring difference…
+			time.Sleep(100 * time.Millisecond)
+			f, err := os.Create(filepath.Join(cacheDir, policies.PoliciesCacheBaseName, "user"))
+			require.NoError(t, err, "Setup: couldn’t copy user cache")
+			f.Close()
+			f, err = os.Create(filepath.Join(cacheDir, policies.PoliciesCacheBaseName, hostname))
+			require.NoError(t, err, "Setup: couldn’t copy user cache")
+			f.Close()
+
+			got, err := m.LastUpdateFor(context.Background(), tc.target, tc.isMachine)
+			if tc.wantErr {
+				require.Error(t, err, "LastUpdateFor should return an error but got none")
+				return
+			}
+			require.NoError(t, err, "LastUpdateFor should return no error but got one")
+			end := time.Now()
+
+			assert.True(t, got.After(start), "expected got after start")
+			assert.True(t, got.Before(end), "expected got before end")
+		})
+	}
+}
+
+func TestDomainCache(t *testing.T) {
+	t.Parallel()
+
+	bus := testutils.NewDbusConn(t)
+
+	tests := map[string]struct {
+		legacyLayout     bool
+		partiallyMoved   bool
+		domainCacheQuota int64
+		removeDomain     string
+
+		wantErr bool
+	}{
+		"Users are cached in the directory of their domain":     {},
+		"Users cached by previous versions are moved":           {legacyLayout: true},
+		"Users partially moved by a previous start are merged":  {legacyLayout: true, partiallyMoved: true},
+		"Removing a domain only removes the cache of its users": {removeDomain: "EXAMPLE.COM"},
+		"Removing a domain without cache does nothing":          {removeDomain: "UNKNOWN.COM"},
+
+		// Error cases
+		"Error on negative domain quota":      {domainCacheQuota: -1, wantErr: true},
+		"Error on removing an invalid domain": {removeDomain: "..", wantErr: true},
+	}
+	for name, tc := range tests {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			cacheDir, runDir := t.TempDir(), t.TempDir()
+
+			// EXAMPLE.COM has 1 KiB of cache, OTHER.COM a few bytes.
+			for objectName, size := range map[string]int{"hostname": 1024, "alice@EXAMPLE.COM": 1024, "bob@EXAMPLE.COM": 0, "carol@OTHER.COM": 10} {
+				for _, kind := range []string{policies.PoliciesCacheBaseName, policies.StatusCacheBaseName} {
+					p := policies.ObjectCachePath(cacheDir, kind, objectName)
+					paths := []string{p}
+					if tc.legacyLayout {
+						paths = []string{filepath.Join(cacheDir, kind, objectName)}
+					}
+					// A previous start moved some of the users before being interrupted.
+					if tc.partiallyMoved && objectName == "alice@EXAMPLE.COM" {
+						paths = append(paths, p)
+					}
+					for _, p := range paths {
+						if kind == policies.PoliciesCacheBaseName {
+							p = filepath.Join(p, "policies")
+						}
+						require.NoError(t, os.MkdirAll(filepath.Dir(p), 0750), "Setup: cannot create cache directory")
+						require.NoError(t, os.WriteFile(p, make([]byte, size/2), 0600), "Setup: cannot create cache file")
+					}
+				}
+			}
+
+			m, err := policies.NewManager(bus, "hostname",
+				policies.WithCacheDir(cacheDir),
+				policies.WithRunDir(runDir),
+				policies.WithDomainCacheQuota(tc.domainCacheQuota),
+			)
+			if tc.domainCacheQuota < 0 {
+				require.Error(t, err, "NewManager should return an error but got none")
+				return
+			}
+			require.NoError(t, err, "Setup: couldn’t get a new policy manager")
+
+			if tc.removeDomain != "" {
+				err = m.RemoveDomain(context.Background(), tc.removeDomain)
+				if tc.wantErr {
+					require.Error(t, err, "RemoveDomain should return an error but got none")
+					return
+				}
+				require.NoError(t, err, "RemoveDomain should return no error but got one")
+			}
+
+			got := make(map[string][]string)
+			for _, kind := range []string{policies.PoliciesCacheBaseName, policies.StatusCacheBaseName} {
+				got[kind], err = policies.CachedObjects(cacheDir, kind)
+				require.NoError(t, err, "CachedObjects should return no error but got one")
+			}
+			testutils.CompareTreesWithFiltering(t, cacheDir, testutils.GoldenPath(t), testutils.Update())
+			want := testutils.LoadWithUpdateFromGoldenYAML(t, got, testutils.WithGoldenPath(testutils.GoldenPath(t)+".objects"))
+			require.Equal(t, want, got, "CachedObjects should list the objects of all domains")
+		})
+	}
+}
+
+func TestDomainCacheUsage(t *testing.T) {
+	t.Parallel()
+
+	bus := testutils.NewDbusConn(t)
+
+	cacheDir := t.TempDir()
+	// alice and bob of EXAMPLE.COM receive {GPOId}, carol of OTHER.COM receives {GPOId} and {GPOId2}.
+	for objectName, cache := range map[string]string{"hostname": "two_gpos_no_override", "alice@EXAMPLE.COM": "one_gpo", "bob@EXAMPLE.COM": "one_gpo", "carol@OTHER.COM": "two_gpos_no_override"} {
+		err := shutil.CopyTree(filepath.Join("testdata", "cache", "policies", cache), policies.ObjectCachePath(cacheDir, policies.PoliciesCacheBaseName, objectName), nil)
+		require.NoError(t, err, "Setup: couldn’t copy policies cache")
+	}
+	for id, size := range map[string]int{"{GPOId}": 100, "{GPOId2}": 1000} {
+		p := filepath.Join(cacheDir, policies.SysvolCacheBaseName, "Policies", id, "GPT.INI")
+		require.NoError(t, os.MkdirAll(filepath.Dir(p), 0750), "Setup: cannot create sysvol cache directory")
+		require.NoError(t, os.WriteFile(p, make([]byte, size), 0600), "Setup: cannot create sysvol cache file")
+	}
+
+	m, err := policies.NewManager(bus, "hostname", policies.WithCacheDir(cacheDir), policies.WithRunDir(t.TempDir()))
+	require.NoError(t, err, "Setup: couldn’t get a new policy manager")
+
+	stateSize := func(objectNames ...string) (size int64) {
+		for _, objectName := range objectNames {
+			info, err := os.Stat(filepath.Join(policies.ObjectCachePath(cacheDir, policies.PoliciesCacheBaseName, objectName), policies.PoliciesFileName))
+			require.NoError(t, err, "Setup: cannot stat policies cache")
+			size += info.Size()
+		}
+		return size
+	}
+
+	for domain, want := range map[string]int64{
+		"EXAMPLE.COM": stateSize("alice@EXAMPLE.COM", "bob@EXAMPLE.COM") + 100,
+		"OTHER.COM":   stateSize("carol@OTHER.COM") + 100 + 1000,
+		"UNKNOWN.COM": 0,
+	} {
+		got, err := m.DomainCacheUsage(context.Background(), domain)
+		require.NoError(t, err, "DomainCacheUsage should return no error but got one")
+		require.Equal(t, want, got, "DomainCacheUsage should count the state of the domain and the GPOs its users receive")
+	}
+}
+
+func TestResetMachineIdentity(t *testing.T) {
+	t.Parallel()
+
+	bus := testutils.NewDbusConn(t)
+
+	cacheDir := t.TempDir()
+	for _, p := range []string{"laps/state", "generated/hostname/password", "policies/hostname/policies"} {
+		require.NoError(t, os.MkdirAll(filepath.Dir(filepath.Join(cacheDir, p)), 0700), "Setup: cannot create cache directory")
+		require.NoError(t, os.WriteFile(filepath.Join(cacheDir, p), []byte("state"), 0600), "Setup: cannot create cache file")
+	}
+
+	m, err := policies.NewManager(bus, "hostname", policies.WithCacheDir(cacheDir), policies.WithRunDir(t.TempDir()))
+	require.NoError(t, err, "Setup: couldn’t get a new policy manager")
+
+	err = m.ResetMachineIdentity(context.Background())
+	require.NoError(t, err, "ResetMachineIdentity should return no error but got one")
+
+	require.NoDirExists(t, filepath.Join(cacheDir, "laps"), "ResetMachineIdentity should remove the local password rotation state")
+	require.NoDirExists(t, filepath.Join(cacheDir, "generated"), "ResetMachineIdentity should remove the generated values")
+	require.FileExists(t, filepath.Join(cacheDir, "policies", "hostname", "policies"), "ResetMachineIdentity should keep the cached policies")
+}
+
+func TestGetSubscriptionState(t *testing.T) {
+	//t.Parallel()
+
+	bus := testutils.NewDbusConn(t)
+
+	hostname, err := os.Hostname()
+	require.NoError(t, err, "Setup: failed to get hostname for tests.")
+
+	tests := map[string]struct {
+		status bool
+
+		want bool
+	}{
+		"Returns enablement status (enabled)":  {status: true, want: true},
+		"Returns enablement status (disabled)": {status: false, want: false},
+	}
+
+	for name, tc := range tests {
+		tc := tc
+		name := name
+		t.Run(name, func(t *testing.T) {
+			// We change the dbus returned values to simulate a subscription
+			//t.Parallel()
+
+			adsystest.SetSubscriptionAttached(t, bus, tc.status)
+
+			cacheDir, runDir := t.TempDir(), t.TempDir()
+			m, err := policies.NewManager(bus, hostname, policies.WithCacheDir(cacheDir), policies.WithRunDir(runDir))
+			require.NoError(t, err, "Setup: couldn’t get a new policy manager")
+
+			got := m.GetSubscriptionState(context.Background())
+			assert.Equal(t, tc.want, got, "GetStatus should return %q but got %q", tc.want, got)
+		})
+	}
+}
+
+// mockProxyApplier is a mock for the proxy apply object.
+type mockProxyApplier struct {
+	wantApplyError bool
+}
+
+// Call mocks the proxy apply call.
+func (d *mockProxyApplier) Call(_ string, _ dbus.Flags, _ ...interface{}) *dbus.Call {
+	var errApply error
+
+	if d.wantApplyError {
+		errApply = errors.New("proxy apply error")
+	}
+
+	return &dbus.Call{Err: errApply}
+}
+
+// mockUserSessions lists the same sessions for every user and records the ones closed.
+type mockUserSessions struct {
+	sessions   []string
+	terminated []string
+}
+
+func (s *mockUserSessions) SessionsStartedBefore(_ context.Context, _ uint32, _ time.Time) ([]string, error) {
+	return s.sessions, nil
+}
+
+func (s *mockUserSessions) TerminateSession(_ context.Context, id string) error {
+	s.terminated = append(s.terminated, id)
+	return nil
 }
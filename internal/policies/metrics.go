@@ -0,0 +1,104 @@
+package policies
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	log "github.com/ubuntu/adsys/internal/grpc/logstreamer"
+	"github.com/ubuntu/adsys/internal/i18n"
+)
+
+// metric is a gauge of the status of each policy manager, written in the metrics file.
+type metric struct {
+	name  string
+	help  string
+	value func(managerStatus) float64
+}
+
+// managerMetrics are the metrics written for each policy manager of each object.
+var managerMetrics = []metric{
+	{"adsys_policy_manager_entries", "Number of entries handled by the policy manager on the last apply.", func(st managerStatus) float64 { return float64(st.Entries) }},
+	{"adsys_policy_manager_entries_bytes", "Total size of the keys and values of the entries handled by the policy manager on the last apply.", func(st managerStatus) float64 { return float64(st.Size) }},
+	{"adsys_policy_manager_files", "Number of files written by the policy manager on the last apply.", func(st managerStatus) float64 { return float64(st.Files) }},
+	{"adsys_policy_manager_files_bytes", "Total size of the files written by the policy manager on the last apply.", func(st managerStatus) float64 { return float64(st.FilesSize) }},
+	{"adsys_policy_manager_failures", "Number of consecutive failed applies of the policy manager.", func(st managerStatus) float64 { return float64(st.Failures) }},
+}
+
+// labelEscaper escapes label values in the Prometheus text format.
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// writeMetrics writes the status of each policy manager on the last apply of every object to the metrics file, in the
+// Prometheus text format read by the textfile collector of the node exporter.
+// Metrics are only informative: failing to write them is logged but doesn't fail the apply.
+func (m *Manager) writeMetrics(ctx context.Context) {
+	if m.metricsFile == "" {
+		return
+	}
+
+	m.metricsMu.Lock()
+	defer m.metricsMu.Unlock()
+
+	if err := m.saveMetrics(); err != nil {
+		log.Warningf(ctx, i18n.G("Can't write policy metrics to %s: %v"), m.metricsFile, err)
+	}
+}
+
+// saveMetrics atomically replaces the metrics file with the status of the last apply of every object.
+func (m *Manager) saveMetrics() error {
+	objects, err := CachedObjects(m.cacheDir, statusCacheBaseName)
+	if err != nil {
+		return err
+	}
+
+	statuses := make(map[string][]managerStatus)
+	lastApplies := make(map[string]int64)
+	for _, object := range objects {
+		p := m.objectPath(statusCacheBaseName, object)
+		info, err := os.Stat(p)
+		if err != nil {
+			// The object was purged meanwhile.
+			continue
+		}
+		managers, err := loadStatus(p)
+		if err != nil {
+			// An invalid status has no metrics, and is reported with the status.
+			continue
+		}
+		statuses[object] = managers
+		lastApplies[object] = info.ModTime().Unix()
+	}
+
+	var out strings.Builder
+	fmt.Fprintln(&out, "# HELP adsys_policy_last_apply_timestamp_seconds Time of the last policy apply of the object.")
+	fmt.Fprintln(&out, "# TYPE adsys_policy_last_apply_timestamp_seconds gauge")
+	for _, object := range objects {
+		if _, ok := statuses[object]; !ok {
+			continue
+		}
+		fmt.Fprintf(&out, "adsys_policy_last_apply_timestamp_seconds{object=\"%s\"} %d\n", labelEscaper.Replace(object), lastApplies[object])
+	}
+	for _, mt := range managerMetrics {
+		fmt.Fprintf(&out, "# HELP %s %s\n", mt.name, mt.help)
+		fmt.Fprintf(&out, "# TYPE %s gauge\n", mt.name)
+		for _, object := range objects {
+			for _, st := range statuses[object] {
+				if st.Skipped {
+					continue
+				}
+				fmt.Fprintf(&out, "%s{object=\"%s\",manager=\"%s\"} %v\n", mt.name, labelEscaper.Replace(object), st.Manager, mt.value(st))
+			}
+		}
+	}
+
+	if err := os.MkdirAll(filepath.Dir(m.metricsFile), 0755); err != nil {
+		return err
+	}
+	// The node exporter only reads files ending with .prom: the temporary file is ignored until it is renamed.
+	if err := os.WriteFile(m.metricsFile+".new", []byte(out.String()), 0644); err != nil {
+		return err
+	}
+	return os.Rename(m.metricsFile+".new", m.metricsFile)
+}
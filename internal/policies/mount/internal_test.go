@@ -135,11 +135,13 @@ func TestCreateUnits(t *testing.T) {
 	t.Parallel()
 
 	tests := map[string]struct {
-		entry string
+		entry     string
+		mountsDir string
 	}{
-		"Write single unit":      {entry: "entry with one value"},
-		"Write multiple units":   {entry: "entry with multiple values"},
-		"Write krb5 tagged unit": {entry: "entry with kerberos auth tag"},
+		"Write single unit":                         {entry: "entry with one value"},
+		"Write multiple units":                      {entry: "entry with multiple values"},
+		"Write krb5 tagged unit":                    {entry: "entry with kerberos auth tag"},
+		"Write units under custom mounts directory": {entry: "entry with multiple values", mountsDir: "/var/lib/adsys/mounts"},
 	}
 	for name, tc := range tests {
 		tc := tc
@@ -149,8 +151,12 @@ func TestCreateUnits(t *testing.T) {
 			parsedValues, err := parseEntryValues(context.Background(), EntriesForTests[tc.entry])
 			require.NoError(t, err, "Setup: failed to parse entries for TestCreateUnits.")
 
+			if tc.mountsDir == "" {
+				tc.mountsDir = defaultMountsDir
+			}
+
 			unitPath := t.TempDir()
-			units := createUnits(parsedValues)
+			units := createUnits(tc.mountsDir, parsedValues)
 
 			for name, content := range units {
 				err := os.WriteFile(filepath.Join(unitPath, name), []byte(content), 0600)
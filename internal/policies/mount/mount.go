@@ -21,6 +21,7 @@ import (
 	"os"
 	"os/user"
 	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
 
@@ -35,21 +36,36 @@ import (
 type options struct {
 	userLookup    func(string) (*user.User, error)
 	systemUnitDir string
+	mountsDir     string
 }
 
 // Option represents an optional function that is able to alter a default behavior used in mount.
 type Option func(*options)
 
+// WithMountsDir specifies the directory under which the system shared locations are mounted.
+// This allows to mount them in a writable location on systems with a read-only root filesystem.
+func WithMountsDir(p string) Option {
+	return func(o *options) {
+		o.mountsDir = p
+	}
+}
+
 //go:embed adsys-mount-template.mount
 var systemdUnitTemplate string
 
 const krbTag string = "[krb5]"
 const defaultMountTimeoutSec int = 30
+const defaultMountsDir string = "/adsys"
+
+// userMountsMaxCountKey is the machine key limiting the number of mounts of each user, so that a single user can't
+// monopolize a shared machine. It is kept in the run directory for the next user applies.
+const userMountsMaxCountKey string = "user-mounts-max-count"
 
 // Manager holds information needed for handling the mount policies.
 type Manager struct {
 	runDir        string
 	systemUnitDir string
+	mountsDir     string
 	systemdCaller systemdCaller
 
 	userLookup func(string) (*user.User, error)
@@ -70,6 +86,7 @@ func New(runDir string, systemUnitDir string, systemdCaller systemdCaller, opts
 	o := options{
 		userLookup:    user.Lookup,
 		systemUnitDir: systemUnitDir,
+		mountsDir:     defaultMountsDir,
 	}
 
 	for _, opt := range opts {
@@ -94,6 +111,7 @@ func New(runDir string, systemUnitDir string, systemdCaller systemdCaller, opts
 	return &Manager{
 		runDir:        runDir,
 		systemUnitDir: systemUnitDir,
+		mountsDir:     filepath.Clean(o.mountsDir),
 		systemdCaller: systemdCaller,
 
 		userLookup: o.userLookup,
@@ -106,6 +124,12 @@ func (m *Manager) ApplyPolicy(ctx context.Context, objectName string, isComputer
 
 	log.Debugf(ctx, "Applying mount policy to %s", objectName)
 
+	if isComputer {
+		if err := m.saveUserMountsMaxCount(entries); err != nil {
+			return err
+		}
+	}
+
 	if len(entries) == 0 {
 		return m.cleanup(ctx, objectName, isComputer)
 	}
@@ -165,6 +189,16 @@ func (m *Manager) applyUserMountsPolicy(ctx context.Context, username string, en
 		return err
 	}
 
+	maxCount, err := m.userMountsMaxCount()
+	if err != nil {
+		return err
+	}
+	if maxCount > 0 && len(parsedValues) > maxCount {
+		log.Warningf(ctx, i18n.G("Skipping mounts %s for %s: the maximum number of mounts per user, %d, is reached"),
+			strings.Join(parsedValues[maxCount:], ", "), username, maxCount)
+		parsedValues = parsedValues[:maxCount]
+	}
+
 	s := strings.Join(parsedValues, "\n")
 	if s == "" {
 		if err = m.cleanupMountsFile(ctx, u.Uid); err != nil {
@@ -189,7 +223,7 @@ func (m *Manager) applySystemMountsPolicy(ctx context.Context, machineName strin
 	if err != nil {
 		return err
 	}
-	newUnits := createUnits(parsedValues)
+	newUnits := createUnits(m.mountsDir, parsedValues)
 
 	// Marks shares to write as new units and removes from map units that shouldn't change
 	needsReload := false
@@ -244,6 +278,44 @@ func (m *Manager) applySystemMountsPolicy(ctx context.Context, machineName strin
 	return nil
 }
 
+// saveUserMountsMaxCount keeps the limit of mounts per user set in the machine entries, or removes it if none is set.
+func (m *Manager) saveUserMountsMaxCount(entries []entry.Entry) (err error) {
+	defer decorate.OnError(&err, i18n.G("can't save the maximum number of mounts per user"))
+
+	p := filepath.Join(m.runDir, userMountsMaxCountKey)
+
+	var n int
+	for _, e := range entries {
+		if e.Key != userMountsMaxCountKey || e.Disabled {
+			continue
+		}
+		if n, err = strconv.Atoi(strings.TrimSpace(e.Value)); err != nil || n < 0 {
+			return fmt.Errorf(i18n.G("invalid value %q for %s: must be a positive number"), e.Value, userMountsMaxCountKey)
+		}
+	}
+
+	if n == 0 {
+		if err := os.Remove(p); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		return nil
+	}
+	return os.WriteFile(p, []byte(strconv.Itoa(n)), 0600)
+}
+
+// userMountsMaxCount returns the limit of mounts per user saved by the machine apply, or 0 if it is not limited.
+func (m *Manager) userMountsMaxCount() (n int, err error) {
+	defer decorate.OnError(&err, i18n.G("can't read the maximum number of mounts per user"))
+
+	d, err := os.ReadFile(filepath.Join(m.runDir, userMountsMaxCountKey))
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, nil
+	} else if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(strings.TrimSpace(string(d)))
+}
+
 // mountInfo stores relevant information about a mount.
 type mountInfo struct {
 	hostname   string
@@ -252,15 +324,15 @@ type mountInfo struct {
 	options    []string
 }
 
-// createUnits formats the adsys-.mount template with the specified paths.
-func createUnits(mountPaths []string) map[string]string {
+// createUnits formats the adsys-.mount template with the specified paths, mounted under mountsDir.
+func createUnits(mountsDir string, mountPaths []string) map[string]string {
 	units := make(map[string]string)
 
 	for _, mp := range mountPaths {
 		mi := parseMountPath(mp)
 
 		what := whatStringFromInfo(mi)
-		where := filepath.Join("/", "adsys", mi.protocol, mi.hostname, mi.sharedPath)
+		where := filepath.Join(mountsDir, mi.protocol, mi.hostname, mi.sharedPath)
 
 		opts := "defaults"
 		if mi.options != nil {
@@ -276,7 +348,7 @@ func createUnits(mountPaths []string) map[string]string {
 			defaultMountTimeoutSec, // TimeoutSec
 		)
 
-		n := fmt.Sprintf("%s.mount", unit.UnitNameEscape(where[1:]))
+		n := fmt.Sprintf("%s.mount", unit.UnitNamePathEscape(where))
 		units[n] = content
 	}
 
@@ -522,12 +594,32 @@ func (m *Manager) cleanupMountUnits(ctx context.Context, units []string) (err er
 	return nil
 }
 
+// SystemUnits returns the paths of the mount units generated by adsys for the machine.
+func (m *Manager) SystemUnits() []string {
+	var paths []string
+	for u := range m.currentSystemMountUnits() {
+		paths = append(paths, filepath.Join(m.systemUnitDir, u))
+	}
+	sort.Strings(paths)
+	return paths
+}
+
 // currentSystemMountUnits reads the unit directory and returns a map containing the adsys mount units found.
+// Units mounting under a previous mounts directory are recognized by the header of our template, so that they are
+// cleaned up too.
 func (m *Manager) currentSystemMountUnits() map[string]struct{} {
-	paths, _ := filepath.Glob(filepath.Join(m.systemUnitDir, "adsys-*.mount"))
+	prefix := unit.UnitNamePathEscape(m.mountsDir) + "-"
+	paths, _ := filepath.Glob(filepath.Join(m.systemUnitDir, "*.mount"))
 
+	header, _, _ := strings.Cut(systemdUnitTemplate, "\n")
 	units := make(map[string]struct{})
 	for _, path := range paths {
+		if !strings.HasPrefix(filepath.Base(path), prefix) {
+			content, err := os.ReadFile(path)
+			if err != nil || !strings.HasPrefix(string(content), header+"\n") {
+				continue
+			}
+		}
 		units[filepath.Base(path)] = struct{}{}
 	}
 
@@ -75,6 +75,9 @@ func TestApplyPolicy(t *testing.T) {
 		secondCall           []string
 		isDisabledSecondCall bool
 
+		// Set by a previous machine apply
+		userMountsMaxCount string
+
 		// User specific
 		readOnlyUsersDir  bool
 		userReturnedUID   string
@@ -85,6 +88,8 @@ func TestApplyPolicy(t *testing.T) {
 		firstMockSystemdCaller      mockSystemdCaller
 		secondMockSystemdCaller     mockSystemdCaller
 		pathAlreadyExistsSecondCall bool
+		secondMountsDir             string
+		otherMountUnit              bool
 
 		wantErr           bool
 		wantErrSecondCall bool
@@ -114,6 +119,10 @@ func TestApplyPolicy(t *testing.T) {
 		"User, mount file is removed on refreshing policy with a disabled entry":              {secondCall: []string{"entry with one value"}, isDisabledSecondCall: true},
 		"User, mount file is updated on refreshing policy with an entry with multiple values": {secondCall: []string{"entry with multiple values"}},
 
+		// Limits set by the machine.
+		"User, mounts above the maximum number of mounts per user are skipped": {entries: []string{"entry with multiple values"}, userMountsMaxCount: "2"},
+		"User, maximum number of mounts per user set to 0 restricts nothing":   {entries: []string{"entry with multiple values"}, userMountsMaxCount: "0"},
+
 		/**************************** SYSTEM ***************************/
 		// Success cases.
 		"System, successfully apply policy for entry with one value":              {isComputer: true},
@@ -141,6 +150,8 @@ func TestApplyPolicy(t *testing.T) {
 		"System, mount units are removed on refreshing policy with no entries":                    {secondCall: []string{"no entries"}, isComputer: true},
 		"System, mount units are removed on refreshing policy with an empty entry":                {secondCall: []string{"entry with no value"}, isComputer: true},
 		"System, mount units are removed on refreshing policy with disabled entry":                {secondCall: []string{"entry with one value"}, isDisabledSecondCall: true},
+		"System, mount units are moved on refreshing policy with another mounts directory":        {secondCall: []string{"entry with multiple values"}, secondMountsDir: "/var/lib/adsys/mounts", isComputer: true},
+		"System, mount units not generated by adsys are kept on refreshing policy":                {secondCall: []string{"no entries"}, otherMountUnit: true, isComputer: true},
 
 		/**************************** GENERIC **************************/
 		// Special cases.
@@ -159,6 +170,8 @@ func TestApplyPolicy(t *testing.T) {
 		"Error when cleaning up user policy with no entries and path already exists as a directory":  {entries: []string{"no entries"}, pathAlreadyExists: true, wantErr: true},
 		"Error when cleaning up user policy with empty entry and path already exists as a directory": {entries: []string{"entry with no value"}, pathAlreadyExists: true, wantErr: true},
 		"Error when applying policy with entry containing badly formatted value":                     {entries: []string{"entry with badly formatted value"}, wantErr: true},
+		"Error when machine sets an invalid maximum number of mounts per user":                       {userMountsMaxCount: "many", wantErr: true},
+		"Error when machine sets a negative maximum number of mounts per user":                       {userMountsMaxCount: "-1", wantErr: true},
 
 		/**************************** SYSTEM ***************************/
 		// Error cases.
@@ -231,9 +244,25 @@ func TestApplyPolicy(t *testing.T) {
 				testutils.CreatePath(t, filepath.Join(p, "not_empty"))
 			}
 
+			if tc.otherMountUnit {
+				err := os.MkdirAll(systemUnitDir, 0750)
+				require.NoError(t, err, "Setup: Expected no error when creating system unit dir for tests.")
+				err = os.WriteFile(filepath.Join(systemUnitDir, "media-other.mount"), []byte("[Mount]\nWhere=/media/other\n"), 0600)
+				require.NoError(t, err, "Setup: Expected no error when writing other mount unit for tests.")
+			}
+
 			m, err := mount.New(runDir, systemUnitDir, &tc.firstMockSystemdCaller, opts...)
 			require.NoError(t, err, "Setup: Failed to create manager for the tests.")
 
+			if tc.userMountsMaxCount != "" {
+				err = m.ApplyPolicy(context.Background(), "machine", true, []entry.Entry{{Key: "user-mounts-max-count", Value: tc.userMountsMaxCount}})
+				if tc.wantErr {
+					require.Error(t, err, "ApplyPolicy on the machine should have returned an error but did not")
+					return
+				}
+				require.NoError(t, err, "Setup: ApplyPolicy on the machine should not have returned an error but did")
+			}
+
 			err = m.ApplyPolicy(context.Background(), tc.objectName, tc.isComputer, entries)
 			if tc.wantErr {
 				require.Error(t, err, "ApplyPolicy should have returned an error but did not")
@@ -253,6 +282,10 @@ func TestApplyPolicy(t *testing.T) {
 					secondEntries = append(secondEntries, e)
 				}
 				m.SetSystemdCaller(&tc.secondMockSystemdCaller)
+				if tc.secondMountsDir != "" {
+					m, err = mount.New(runDir, systemUnitDir, &tc.secondMockSystemdCaller, append(opts, mount.WithMountsDir(tc.secondMountsDir))...)
+					require.NoError(t, err, "Setup: Failed to create manager with another mounts directory for the tests.")
+				}
 
 				if tc.pathAlreadyExistsSecondCall {
 					p := filepath.Join(systemUnitDir, "adsys-protocol-domain.com-mountpath.mount")
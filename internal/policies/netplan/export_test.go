@@ -0,0 +1,11 @@
+package netplan
+
+import "time"
+
+// WithConnectivityTimeout overrides the time the domain controller has to be reachable again after applying a new
+// configuration.
+func WithConnectivityTimeout(d time.Duration) Option {
+	return func(o *options) {
+		o.connectivityTimeout = d
+	}
+}
@@ -0,0 +1,356 @@
+// Package netplan is the policy manager rendering a static network configuration of the machine with netplan.
+//
+// The network-configuration policy is a netplan YAML document, like the static addressing of a lab segment or the
+// bridges of a virtualization host. It is written as 90-adsys.yaml in the netplan directory, where netplan merges it
+// with the local configuration, and applied the way netplan try does:
+//  1. the document is validated: only the version, renderer, ethernets, bridges and vlans sections are supported;
+//  2. netplan generate validates the merged configuration. If it fails, the previous document is restored;
+//  3. netplan apply enables the new configuration;
+//  4. if the domain controller was reachable before applying it, it must be reachable again within the connectivity
+//     timeout. Otherwise, the previous document is restored and applied again, and an error is returned.
+//
+// The previous document is saved in the cache directory of the manager until the new one is confirmed. If the daemon
+// stops meanwhile, it is restored on the next start or apply, as the new document was never confirmed.
+//
+// Removing the policy removes the document, following the same steps.
+package netplan
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/ubuntu/adsys/internal/grpc/logstreamer"
+	"github.com/ubuntu/adsys/internal/i18n"
+	"github.com/ubuntu/adsys/internal/policies/entry"
+	"github.com/ubuntu/adsys/internal/smbsafe"
+	"github.com/ubuntu/decorate"
+	"gopkg.in/yaml.v3"
+)
+
+// ConfFileName is the name of the document generated in the netplan directory.
+const ConfFileName = "90-adsys.yaml"
+
+// pendingRevertFileName is the file, in the cache directory, holding the previous document while a new one is
+// applied. It is empty if there was no previous document.
+const pendingRevertFileName = "pending-revert"
+
+// networkConfigurationKey is the entry key of the netplan document.
+const networkConfigurationKey = "network-configuration"
+
+// supportedSections are the sections of the network configuration which can be set by policies.
+var supportedSections = []string{"version", "renderer", "ethernets", "bridges", "vlans"}
+
+// Manager prevents running multiple netplan update processes in parallel while parsing policy in ApplyPolicy.
+type Manager struct {
+	confFile            string
+	pendingRevert       string
+	netplanCmd          []string
+	serverURL           func(context.Context) (string, error)
+	connectivityTimeout time.Duration
+
+	mu sync.Mutex
+}
+
+type options struct {
+	netplanCmd          []string
+	serverURL           func(context.Context) (string, error)
+	connectivityTimeout time.Duration
+}
+
+// Option reprents an optional function to change the netplan manager.
+type Option func(*options)
+
+// WithNetplanCmd overrides the default netplan command.
+func WithNetplanCmd(cmd []string) Option {
+	return func(o *options) {
+		o.netplanCmd = cmd
+	}
+}
+
+// WithServerURL specifies how to get the URL of the domain controller whose connectivity is checked after applying
+// a new configuration. Without it, the connectivity is not checked.
+func WithServerURL(f func(context.Context) (string, error)) Option {
+	return func(o *options) {
+		o.serverURL = f
+	}
+}
+
+// New creates a manager writing its netplan document in netplanDir and saving the document to revert to in cacheDir.
+func New(netplanDir, cacheDir string, opts ...Option) *Manager {
+	// defaults
+	args := options{
+		netplanCmd:          []string{"netplan"},
+		connectivityTimeout: 2 * time.Minute,
+	}
+	// applied options
+	for _, o := range opts {
+		o(&args)
+	}
+
+	return &Manager{
+		confFile:            filepath.Join(netplanDir, ConfFileName),
+		pendingRevert:       filepath.Join(cacheDir, pendingRevertFileName),
+		netplanCmd:          args.netplanCmd,
+		serverURL:           args.serverURL,
+		connectivityTimeout: args.connectivityTimeout,
+	}
+}
+
+// Destinations returns the document written by the manager.
+func (m *Manager) Destinations() []string {
+	return []string{m.confFile}
+}
+
+// ApplyPolicy writes and applies the netplan document of the machine based on a list of entries. The previous
+// document is restored if netplan rejects the new one, or if the domain controller is not reachable anymore.
+func (m *Manager) ApplyPolicy(ctx context.Context, objectName string, isComputer bool, entries []entry.Entry) (err error) {
+	defer decorate.OnError(&err, i18n.G("can't apply netplan policy"))
+
+	// The network configuration is machine wide.
+	if !isComputer {
+		return nil
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	log.Debugf(ctx, "Applying netplan policy to %s", objectName)
+
+	if err := m.revertPending(ctx); err != nil {
+		return err
+	}
+
+	var content []byte
+	for _, e := range entries {
+		if e.Key != networkConfigurationKey || e.Disabled {
+			continue
+		}
+		if err := validate(e.Value); err != nil {
+			return err
+		}
+		content = []byte(strings.TrimSpace(e.Value) + "\n")
+	}
+
+	previous, err := os.ReadFile(m.confFile)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	if bytes.Equal(previous, content) {
+		log.Debugf(ctx, "Network configuration is already up to date")
+		return nil
+	}
+
+	// The connectivity can only be lost if the domain controller was reachable.
+	var checkConnectivity bool
+	if m.serverURL != nil {
+		if err := m.dcReachable(ctx, 0); err != nil {
+			log.Warningf(ctx, i18n.G("The connectivity won't be checked after applying the network configuration, as the domain controller is not reachable: %v"), err)
+		} else {
+			checkConnectivity = true
+		}
+	}
+
+	// Save the previous document first, so that it is restored if we stop before the new one is confirmed.
+	if err := m.savePendingRevert(previous); err != nil {
+		return err
+	}
+	if err := m.write(content); err != nil {
+		return errors.Join(err, m.clearPendingRevert())
+	}
+	if err := m.netplan(ctx, "generate"); err != nil {
+		err = fmt.Errorf(i18n.G("invalid network configuration: %w"), err)
+		if errRestore := m.write(previous); errRestore != nil {
+			return errors.Join(err, errRestore)
+		}
+		return errors.Join(err, m.clearPendingRevert())
+	}
+	if err := m.netplan(ctx, "apply"); err != nil {
+		return errors.Join(err, m.revert(ctx, previous))
+	}
+	if checkConnectivity {
+		if err := m.dcReachable(ctx, m.connectivityTimeout); err != nil {
+			log.Warningf(ctx, i18n.G("Reverting the network configuration: %v"), err)
+			return errors.Join(fmt.Errorf(i18n.G("lost connectivity to the domain controller: %w"), err), m.revert(ctx, previous))
+		}
+	}
+	return m.clearPendingRevert()
+}
+
+// RevertPending restores the previous document if the daemon stopped while applying a new one.
+func (m *Manager) RevertPending(ctx context.Context) (err error) {
+	defer decorate.OnError(&err, i18n.G("can't apply netplan policy"))
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.revertPending(ctx)
+}
+
+// revertPending restores and applies the saved previous document, if any.
+func (m *Manager) revertPending(ctx context.Context) error {
+	previous, err := os.ReadFile(m.pendingRevert)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if len(previous) == 0 {
+		previous = nil
+	}
+
+	log.Warning(ctx, i18n.G("Restoring the previous network configuration, as the daemon stopped while applying a new one"))
+	return m.revert(ctx, previous)
+}
+
+// savePendingRevert saves previous as the document to restore until the new one is confirmed.
+func (m *Manager) savePendingRevert(previous []byte) error {
+	if err := os.MkdirAll(filepath.Dir(m.pendingRevert), 0700); err != nil {
+		return err
+	}
+	if err := os.WriteFile(m.pendingRevert+".new", previous, 0600); err != nil {
+		return err
+	}
+	return os.Rename(m.pendingRevert+".new", m.pendingRevert)
+}
+
+// clearPendingRevert removes the saved previous document, once the new one is confirmed or the previous one restored.
+func (m *Manager) clearPendingRevert() error {
+	if err := os.Remove(m.pendingRevert); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// validate returns an error if v is not a netplan document with only supported sections.
+func validate(v string) error {
+	var doc map[string]map[string]interface{}
+	if err := yaml.Unmarshal([]byte(v), &doc); err != nil {
+		return fmt.Errorf(i18n.G("network configuration is not a valid netplan document: %v"), err)
+	}
+	network, ok := doc["network"]
+	if len(doc) != 1 || !ok {
+		return errors.New(i18n.G("network configuration must only have a network section"))
+	}
+	for section, value := range network {
+		switch section {
+		case "version":
+			if version, ok := value.(int); !ok || version != 2 {
+				return fmt.Errorf(i18n.G("unsupported netplan version %v: only version 2 is supported"), value)
+			}
+		case "renderer":
+			if value != "networkd" && value != "NetworkManager" {
+				return fmt.Errorf(i18n.G("unsupported renderer %v: must be networkd or NetworkManager"), value)
+			}
+		case "ethernets", "bridges", "vlans":
+			if _, ok := value.(map[string]interface{}); !ok {
+				return fmt.Errorf(i18n.G("%s must be a mapping of interfaces"), section)
+			}
+		default:
+			return fmt.Errorf(i18n.G("unsupported network section %q: must be one of %s"), section, strings.Join(supportedSections, ", "))
+		}
+	}
+	return nil
+}
+
+// write writes content as the netplan document, or removes it if content is nil.
+func (m *Manager) write(content []byte) error {
+	if content == nil {
+		if err := os.Remove(m.confFile); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+		return nil
+	}
+
+	// #nosec G301 - /etc/netplan is the standard netplan directory, readable by all.
+	if err := os.MkdirAll(filepath.Dir(m.confFile), 0755); err != nil {
+		return err
+	}
+	// netplan warns about documents readable by other users, as they can contain secrets.
+	if err := os.WriteFile(m.confFile+".new", content, 0600); err != nil {
+		return err
+	}
+	return os.Rename(m.confFile+".new", m.confFile)
+}
+
+// revert restores the previous document and applies it again. The saved previous document is kept if it fails, so
+// that it is tried again on the next start or apply.
+func (m *Manager) revert(ctx context.Context, previous []byte) (err error) {
+	defer decorate.OnError(&err, i18n.G("can't revert to the previous network configuration"))
+
+	if err := m.write(previous); err != nil {
+		return err
+	}
+	if err := m.netplan(ctx, "apply"); err != nil {
+		return err
+	}
+	return m.clearPendingRevert()
+}
+
+// netplan runs the netplan subcommand.
+func (m *Manager) netplan(ctx context.Context, subcommand string) error {
+	args := append(append([]string{}, m.netplanCmd[1:]...), subcommand)
+	// #nosec G204 - We are in control of the arguments
+	cmd := exec.CommandContext(ctx, m.netplanCmd[0], args...)
+	smbsafe.WaitExec()
+	out, err := cmd.CombinedOutput()
+	smbsafe.DoneExec()
+	if err != nil {
+		return fmt.Errorf(i18n.G("netplan %s failed: %w\n%s"), subcommand, err, string(out))
+	}
+	return nil
+}
+
+// dcReachable returns an error if the domain controller doesn't accept connections on its LDAP port within timeout.
+// It is retried every second until then.
+func (m *Manager) dcReachable(ctx context.Context, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		err := m.dialDC(ctx)
+		if err == nil || !time.Now().Before(deadline) {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(time.Second):
+		}
+	}
+}
+
+// dialDC connects to the LDAP port of the current domain controller.
+func (m *Manager) dialDC(ctx context.Context) error {
+	// The active domain controller can change with the network configuration.
+	serverURL, err := m.serverURL(ctx)
+	if err != nil {
+		return err
+	}
+	u, err := url.Parse(serverURL)
+	if err != nil {
+		return err
+	}
+	port := u.Port()
+	if port == "" {
+		port = "389"
+		if u.Scheme == "ldaps" {
+			port = "636"
+		}
+	}
+
+	d := net.Dialer{Timeout: 5 * time.Second}
+	conn, err := d.DialContext(ctx, "tcp", net.JoinHostPort(u.Hostname(), port))
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
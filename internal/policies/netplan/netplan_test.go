@@ -0,0 +1,249 @@
+package netplan_test
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/ubuntu/adsys/internal/policies/entry"
+	"github.com/ubuntu/adsys/internal/policies/netplan"
+	"github.com/ubuntu/adsys/internal/testutils"
+)
+
+const staticConfiguration = `network:
+  version: 2
+  renderer: networkd
+  ethernets:
+    eth0:
+      addresses: [192.168.10.5/24]
+      routes:
+        - to: default
+          via: 192.168.10.1
+      nameservers:
+        addresses: [192.168.10.2]
+  bridges:
+    br0:
+      interfaces: [eth1]
+      dhcp4: true
+`
+
+func TestApplyPolicy(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		entries  []entry.Entry
+		isUser   bool
+		existing bool
+		// pendingRevert is the previous document saved by a daemon which stopped while applying the policy one.
+		pendingRevert string
+		failOn        string
+		dc            string
+
+		wantErr bool
+	}{
+		"Write and apply configuration": {entries: []entry.Entry{{Key: "network-configuration", Value: staticConfiguration}}},
+		"Replace existing configuration": {
+			entries:  []entry.Entry{{Key: "network-configuration", Value: staticConfiguration}},
+			existing: true,
+		},
+		"Unchanged configuration is not applied again": {
+			entries:  []entry.Entry{{Key: "network-configuration", Value: "network:\n  version: 2\n  ethernets:\n    eth0:\n      dhcp4: true\n"}},
+			existing: true,
+		},
+		"No entries removes existing configuration":       {existing: true},
+		"Disabled entry removes existing configuration":   {entries: []entry.Entry{{Key: "network-configuration", Disabled: true}}, existing: true},
+		"No entries and no configuration is a noop":       {},
+		"Unknown keys are ignored":                        {entries: []entry.Entry{{Key: "unknown", Value: staticConfiguration}}},
+		"User does nothing":                               {entries: []entry.Entry{{Key: "network-configuration", Value: staticConfiguration}}, isUser: true},
+		"Connectivity to the domain controller is kept":   {entries: []entry.Entry{{Key: "network-configuration", Value: staticConfiguration}}, dc: "reachable"},
+		"Connectivity is not checked if it was not there": {entries: []entry.Entry{{Key: "network-configuration", Value: staticConfiguration}}, dc: "unreachable"},
+		"Pending revert is restored before applying":      {entries: []entry.Entry{{Key: "network-configuration", Value: staticConfiguration}}, pendingRevert: "existing"},
+		"Pending revert of no configuration removes it":   {pendingRevert: "none"},
+
+		// Error cases
+		"Error on invalid YAML":                                  {entries: []entry.Entry{{Key: "network-configuration", Value: "network: ["}}, existing: true, wantErr: true},
+		"Error on missing network root":                          {entries: []entry.Entry{{Key: "network-configuration", Value: "ethernets: {}"}}, existing: true, wantErr: true},
+		"Error on unsupported version":                           {entries: []entry.Entry{{Key: "network-configuration", Value: "network:\n  version: 1"}}, existing: true, wantErr: true},
+		"Error on unsupported renderer":                          {entries: []entry.Entry{{Key: "network-configuration", Value: "network:\n  renderer: systemd"}}, existing: true, wantErr: true},
+		"Error on unsupported section":                           {entries: []entry.Entry{{Key: "network-configuration", Value: "network:\n  wifis:\n    wlan0: {}"}}, existing: true, wantErr: true},
+		"Error on section not a mapping":                         {entries: []entry.Entry{{Key: "network-configuration", Value: "network:\n  ethernets: [eth0]"}}, existing: true, wantErr: true},
+		"Error on generate restores":                             {entries: []entry.Entry{{Key: "network-configuration", Value: staticConfiguration}}, existing: true, failOn: "generate", wantErr: true},
+		"Error on apply reverts":                                 {entries: []entry.Entry{{Key: "network-configuration", Value: staticConfiguration}}, existing: true, failOn: "apply", wantErr: true},
+		"Error on lost connectivity":                             {entries: []entry.Entry{{Key: "network-configuration", Value: staticConfiguration}}, existing: true, dc: "lost", wantErr: true},
+		"Error on lost connectivity when removing configuration": {existing: true, dc: "lost", wantErr: true},
+		"Error on pending revert failing keeps it":               {entries: []entry.Entry{{Key: "network-configuration", Value: staticConfiguration}}, pendingRevert: "existing", failOn: "apply", wantErr: true},
+	}
+	for name, tc := range tests {
+		tc := tc
+		name := name
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			root := t.TempDir()
+			netplanDir := filepath.Join(root, "netplan")
+			cacheDir := filepath.Join(root, "cache")
+			if tc.existing {
+				require.NoError(t, os.MkdirAll(netplanDir, 0755), "Setup: can't create netplan directory")
+				testutils.Copy(t, filepath.Join("testdata", "existing", netplan.ConfFileName), filepath.Join(netplanDir, netplan.ConfFileName))
+			}
+			if tc.pendingRevert != "" {
+				setupPendingRevert(t, netplanDir, cacheDir, tc.pendingRevert)
+			}
+
+			opts := []netplan.Option{
+				netplan.WithNetplanCmd(mockNetplanCmd(t, filepath.Join(root, "netplan-calls"), tc.failOn)),
+				netplan.WithConnectivityTimeout(0),
+			}
+			if tc.dc != "" {
+				opts = append(opts, netplan.WithServerURL(mockServerURL(t, tc.dc)))
+			}
+
+			m := netplan.New(netplanDir, cacheDir, opts...)
+			err := m.ApplyPolicy(context.Background(), "ubuntu", !tc.isUser, tc.entries)
+			if tc.wantErr {
+				require.Error(t, err, "ApplyPolicy should have failed but didn't")
+			} else {
+				require.NoError(t, err, "ApplyPolicy failed but shouldn't have")
+			}
+
+			testutils.CompareTreesWithFiltering(t, root, testutils.GoldenPath(t), testutils.Update())
+		})
+	}
+}
+
+func TestRevertPending(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		pendingRevert string
+		failOn        string
+
+		wantErr bool
+	}{
+		"Restore previous configuration":    {pendingRevert: "existing"},
+		"Remove configuration if none":      {pendingRevert: "none"},
+		"No pending revert does not revert": {},
+
+		// Error cases
+		"Error on apply keeps pending revert": {pendingRevert: "existing", failOn: "apply", wantErr: true},
+	}
+	for name, tc := range tests {
+		tc := tc
+		name := name
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			root := t.TempDir()
+			netplanDir := filepath.Join(root, "netplan")
+			cacheDir := filepath.Join(root, "cache")
+			if tc.pendingRevert != "" {
+				setupPendingRevert(t, netplanDir, cacheDir, tc.pendingRevert)
+			}
+
+			m := netplan.New(netplanDir, cacheDir, netplan.WithNetplanCmd(mockNetplanCmd(t, filepath.Join(root, "netplan-calls"), tc.failOn)))
+			err := m.RevertPending(context.Background())
+			if tc.wantErr {
+				require.Error(t, err, "RevertPending should have failed but didn't")
+			} else {
+				require.NoError(t, err, "RevertPending failed but shouldn't have")
+			}
+
+			testutils.CompareTreesWithFiltering(t, root, testutils.GoldenPath(t), testutils.Update())
+		})
+	}
+}
+
+// setupPendingRevert simulates a daemon which stopped while applying the policy document: it is written in netplanDir
+// and the previous one, the existing document or none, is saved in cacheDir.
+func setupPendingRevert(t *testing.T, netplanDir, cacheDir, previous string) {
+	t.Helper()
+
+	require.NoError(t, os.MkdirAll(netplanDir, 0755), "Setup: can't create netplan directory")
+	require.NoError(t, os.WriteFile(filepath.Join(netplanDir, netplan.ConfFileName), []byte(staticConfiguration), 0600), "Setup: can't write netplan document")
+
+	require.NoError(t, os.MkdirAll(cacheDir, 0700), "Setup: can't create cache directory")
+	var content []byte
+	if previous == "existing" {
+		var err error
+		content, err = os.ReadFile(filepath.Join("testdata", "existing", netplan.ConfFileName))
+		require.NoError(t, err, "Setup: can't read existing netplan document")
+	}
+	require.NoError(t, os.WriteFile(filepath.Join(cacheDir, "pending-revert"), content, 0600), "Setup: can't write pending revert")
+}
+
+// mockServerURL returns the URL of a domain controller which is reachable, unreachable, or only reachable on the
+// first call if state is "lost".
+func mockServerURL(t *testing.T, state string) func(context.Context) (string, error) {
+	t.Helper()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err, "Setup: can't listen for domain controller connections")
+	t.Cleanup(func() { l.Close() })
+	reachable := fmt.Sprintf("ldap://%s", l.Addr())
+
+	closed, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err, "Setup: can't listen for domain controller connections")
+	unreachable := fmt.Sprintf("ldap://%s", closed.Addr())
+	require.NoError(t, closed.Close(), "Setup: can't close listener")
+
+	var mu sync.Mutex
+	var calls int
+	return func(context.Context) (string, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		calls++
+
+		switch {
+		case state == "reachable", state == "lost" && calls == 1:
+			return reachable, nil
+		default:
+			return unreachable, nil
+		}
+	}
+}
+
+func mockNetplanCmd(t *testing.T, callsFile, failOn string) []string {
+	t.Helper()
+
+	return []string{"env", "GO_WANT_HELPER_PROCESS=1", os.Args[0], "-test.run=TestMockNetplan", "--", callsFile, failOn}
+}
+
+func TestMockNetplan(t *testing.T) {
+	if os.Getenv("GO_WANT_HELPER_PROCESS") != "1" {
+		return
+	}
+	defer os.Exit(0)
+
+	args := os.Args
+	for len(args) > 0 && args[0] != "--" {
+		args = args[1:]
+	}
+	callsFile, failOn, subcommand := args[1], args[2], args[3]
+
+	f, err := os.OpenFile(callsFile, os.O_APPEND|os.O_WRONLY|os.O_CREATE, 0600)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "can't open calls file: %v", err)
+		os.Exit(2)
+	}
+	defer f.Close()
+	fmt.Fprintf(f, "netplan %s\n", subcommand)
+
+	if subcommand == failOn {
+		fmt.Fprintf(os.Stderr, "netplan %s failure requested", subcommand)
+		f.Close()
+		os.Exit(1)
+	}
+}
+
+func TestMain(m *testing.M) {
+	testutils.InstallUpdateFlag()
+	flag.Parse()
+
+	m.Run()
+}
@@ -0,0 +1,120 @@
+// Package notification tells users about the restrictions newly enforced on them by a policy refresh.
+//
+// After a user refresh, the daemon writes a summary of the new restrictions in the notifications directory of the run
+// directory, in a file named after the uid of the user.
+// The adsys-user-notify user units then show it as a desktop notification in the session of the user, once per
+// summary: when the session starts after a login refresh, or as soon as it changes during a periodic refresh.
+// The same notification tells users when the refresh of their policies completed after their session started.
+package notification
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/godbus/dbus/v5"
+	log "github.com/ubuntu/adsys/internal/grpc/logstreamer"
+	"github.com/ubuntu/adsys/internal/i18n"
+	"github.com/ubuntu/decorate"
+)
+
+// DirName is the name of the directory of the notifications in the run directory. It is owned by root, so that users
+// can't make the daemon write anywhere else.
+const DirName = "notifications"
+
+// maxLines is the maximum number of lines of the notification body.
+const maxLines = 10
+
+// Path returns the path of the notification of the user with uid in dir.
+func Path(dir, uid string) string {
+	return filepath.Join(dir, uid)
+}
+
+// Write writes the notification with summary and the lines of its body in dir, to be shown to the user with uid.
+// The summary is stored on the first line of the file.
+func Write(dir string, uid int, summary string, lines []string) (err error) {
+	defer decorate.OnError(&err, i18n.G("can't write notification"))
+
+	if len(lines) > maxLines {
+		more := len(lines) - maxLines
+		lines = append(lines[:maxLines:maxLines], fmt.Sprintf(i18n.G("and %d more"), more))
+	}
+
+	// #nosec G301 - The directory is only writable by root and needs to be traversable by the users.
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	path := Path(dir, strconv.Itoa(uid))
+
+	// A leftover of an interrupted write is removed, never followed nor reused.
+	if err := os.Remove(path + ".new"); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	f, err := os.OpenFile(path+".new", os.O_WRONLY|os.O_CREATE|os.O_EXCL|syscall.O_NOFOLLOW, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	// The file is given to the user on the opened file, so that no other file can be substituted meanwhile.
+	if err := f.Chown(uid, -1); err != nil {
+		return err
+	}
+	if _, err := f.WriteString(strings.Join(append([]string{summary}, lines...), "\n") + "\n"); err != nil {
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(path+".new", path)
+}
+
+// Show shows the notification in path with notify, if it was not shown yet.
+// The modification time of the last shown notification is stored in statePath.
+func Show(ctx context.Context, path, statePath string, notify func(summary, body string) error) (err error) {
+	defer decorate.OnError(&err, i18n.G("can't show notification"))
+
+	info, err := os.Stat(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		log.Debug(ctx, "No notification")
+		return nil
+	} else if err != nil {
+		return err
+	}
+	version := strconv.FormatInt(info.ModTime().UnixNano(), 10)
+
+	shown, err := os.ReadFile(statePath)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	if string(shown) == version {
+		log.Debug(ctx, "Notification already shown")
+		return nil
+	}
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	summary, body, _ := strings.Cut(string(content), "\n")
+	if err := notify(summary, body); err != nil {
+		return err
+	}
+
+	return os.WriteFile(statePath, []byte(version), 0600)
+}
+
+// DesktopNotify returns a function showing a desktop notification in the session of bus.
+func DesktopNotify(bus *dbus.Conn) func(summary, body string) error {
+	return func(summary, body string) error {
+		obj := bus.Object("org.freedesktop.Notifications", "/org/freedesktop/Notifications")
+		call := obj.Call("org.freedesktop.Notifications.Notify", 0,
+			"ADSys", uint32(0), "dialog-information", summary, body, []string{}, map[string]dbus.Variant{}, int32(-1))
+		return call.Err
+	}
+}
@@ -0,0 +1,141 @@
+package notification_test
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/ubuntu/adsys/internal/policies/notification"
+	"github.com/ubuntu/adsys/internal/testutils"
+)
+
+func TestWrite(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		restrictions int
+		existing     bool
+		leftoverLink bool
+	}{
+		"One restriction":                             {restrictions: 1},
+		"Multiple restrictions":                       {restrictions: 3},
+		"Restrictions over the maximum are summed up": {restrictions: 15},
+		"Replace previous notification":               {restrictions: 2, existing: true},
+		"Leftover temporary file is not followed":     {restrictions: 1, leftoverLink: true},
+	}
+	for name, tc := range tests {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			dir := filepath.Join(t.TempDir(), notification.DirName)
+			path := notification.Path(dir, strconv.Itoa(os.Getuid()))
+			if tc.existing {
+				require.NoError(t, os.MkdirAll(dir, 0750), "Setup: can't create notifications directory")
+				require.NoError(t, os.WriteFile(path, []byte("previous notification\n"), 0600), "Setup: can't create previous notification")
+			}
+			target := filepath.Join(t.TempDir(), "target")
+			if tc.leftoverLink {
+				require.NoError(t, os.MkdirAll(dir, 0750), "Setup: can't create notifications directory")
+				require.NoError(t, os.WriteFile(target, []byte("target content\n"), 0600), "Setup: can't create link target")
+				require.NoError(t, os.Symlink(target, path+".new"), "Setup: can't create leftover link")
+			}
+
+			var restrictions []string
+			for i := 0; i < tc.restrictions; i++ {
+				restrictions = append(restrictions, fmt.Sprintf("path/to/key%02d is now enforced", i))
+			}
+
+			err := notification.Write(dir, os.Getuid(), "New restrictions", restrictions)
+			require.NoError(t, err, "Write should not fail")
+
+			if tc.leftoverLink {
+				got, err := os.ReadFile(target)
+				require.NoError(t, err, "Link target should still exist")
+				require.Equal(t, "target content\n", string(got), "Write should not write through a leftover link")
+			}
+
+			got, err := os.ReadFile(path)
+			require.NoError(t, err, "Notification should be written")
+			want := testutils.LoadWithUpdateFromGolden(t, string(got))
+			require.Equal(t, want, string(got), "Write should write the expected notification")
+		})
+	}
+}
+
+func TestShow(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		noNotification bool
+		alreadyShown   bool
+		changedSince   bool
+		notifyErr      bool
+
+		wantShown bool
+		wantErr   bool
+	}{
+		"Show new notification":                      {wantShown: true},
+		"Show notification changed since last shown": {alreadyShown: true, changedSince: true, wantShown: true},
+
+		"Don't show notification already shown": {alreadyShown: true},
+		"No notification to show":               {noNotification: true},
+
+		// Error cases
+		"Error when notification can't be shown": {notifyErr: true, wantErr: true},
+	}
+	for name, tc := range tests {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			dir := t.TempDir()
+			path := notification.Path(dir, "1000")
+			statePath := filepath.Join(dir, "state")
+			if !tc.noNotification {
+				require.NoError(t, os.WriteFile(path, []byte("New restrictions\npath/to/key1 is now enforced\n"), 0600), "Setup: can't create notification")
+			}
+			notify := func(summary, body string) error {
+				if tc.notifyErr {
+					return errors.New("notification error")
+				}
+				return nil
+			}
+			if tc.alreadyShown {
+				require.NoError(t, notification.Show(context.Background(), path, statePath, notify), "Setup: can't show notification")
+			}
+			if tc.changedSince {
+				later := time.Now().Add(time.Minute)
+				require.NoError(t, os.Chtimes(path, later, later), "Setup: can't change notification")
+			}
+
+			var shown bool
+			err := notification.Show(context.Background(), path, statePath, func(summary, body string) error {
+				shown = true
+				require.Equal(t, "New restrictions", summary, "Show should show the notification summary")
+				require.Equal(t, "path/to/key1 is now enforced\n", body, "Show should show the notification content")
+				return notify(summary, body)
+			})
+			if tc.wantErr {
+				require.Error(t, err, "Show should return an error but got none")
+				return
+			}
+			require.NoError(t, err, "Show should not fail")
+			require.Equal(t, tc.wantShown, shown, "Show should show the notification only if not shown yet")
+		})
+	}
+}
+
+func TestMain(m *testing.M) {
+	testutils.InstallUpdateFlag()
+	flag.Parse()
+
+	m.Run()
+}
@@ -0,0 +1,141 @@
+package policies
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/user"
+	"path/filepath"
+	"sort"
+	"strconv"
+
+	log "github.com/ubuntu/adsys/internal/grpc/logstreamer"
+	"github.com/ubuntu/adsys/internal/i18n"
+	"github.com/ubuntu/adsys/internal/policies/entry"
+	"github.com/ubuntu/adsys/internal/policies/notification"
+	"github.com/ubuntu/adsys/internal/policies/privilege"
+	"golang.org/x/exp/slices"
+)
+
+// notifyNewRestrictions writes for objectName the summary of the restrictions enforced by rules which were not by
+// previousRules. Nothing is written when there is no new restriction, so that the last summary is not shown again.
+func (m *Manager) notifyNewRestrictions(ctx context.Context, objectName string, previousRules, rules map[string][]entry.Entry) error {
+	restrictions := newRestrictions(previousRules["dconf"], rules["dconf"])
+	if len(restrictions) == 0 {
+		return nil
+	}
+	log.Infof(ctx, "Notifying %s of %d new restrictions", objectName, len(restrictions))
+
+	return m.writeNotification(objectName, i18n.G("New restrictions from your administrator"), restrictions)
+}
+
+// notifyCompletion tells objectName that their policies finished applying after their session started with the
+// previously applied ones. The restrictions newly enforced by rules are listed if users are notified of them.
+func (m *Manager) notifyCompletion(ctx context.Context, objectName string, previousRules, rules map[string][]entry.Entry) error {
+	var lines []string
+	if m.userNotifications && previousRules != nil {
+		lines = newRestrictions(previousRules["dconf"], rules["dconf"])
+	}
+	if len(lines) == 0 {
+		lines = []string{i18n.G("Your session now uses the latest settings from your administrator.")}
+	}
+	log.Infof(ctx, "Notifying %s of the completed refresh", objectName)
+
+	return m.writeNotification(objectName, i18n.G("Your settings finished updating"), lines)
+}
+
+// notifyRevokedPrivileges notifies the users with cached policies who were administrators of the machine with
+// previousRules and are not anymore with rules.
+func (m *Manager) notifyRevokedPrivileges(ctx context.Context, previousRules, rules map[string][]entry.Entry) error {
+	previousAdmins := privilege.Admins(ctx, previousRules["privilege"])
+	admins := privilege.Admins(ctx, rules["privilege"])
+	if slices.Equal(previousAdmins, admins) {
+		return nil
+	}
+
+	objects, err := CachedObjects(m.cacheDir, PoliciesCacheBaseName)
+	if err != nil {
+		return err
+	}
+	var errs []error
+	for _, objectName := range objects {
+		if objectName == m.hostname {
+			continue
+		}
+		u, err := user.Lookup(objectName)
+		if err != nil {
+			// Users who can't be resolved have lost no privilege on this machine.
+			log.Debugf(ctx, "Not checking the privileges of %s: %v", objectName, err)
+			continue
+		}
+		identities := []string{u.Username}
+		gids, err := u.GroupIds()
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		for _, gid := range gids {
+			g, err := user.LookupGroupId(gid)
+			if err != nil {
+				continue
+			}
+			identities = append(identities, "%"+g.Name)
+		}
+		if !privilege.IsAdmin(previousAdmins, identities) || privilege.IsAdmin(admins, identities) {
+			continue
+		}
+
+		log.Infof(ctx, "Notifying %s of their revoked administrator privileges", objectName)
+		errs = append(errs, m.writeNotification(objectName, i18n.G("Your administrator privileges were removed"),
+			[]string{i18n.G("You can't run commands as administrator on this machine anymore.")}))
+	}
+	return errors.Join(errs...)
+}
+
+// writeNotification writes the notification with summary and lines for objectName, to be shown in their session.
+func (m *Manager) writeNotification(objectName, summary string, lines []string) error {
+	u, err := user.Lookup(objectName)
+	if err != nil {
+		return fmt.Errorf(i18n.G("could not retrieve user for %q: %w"), objectName, err)
+	}
+	uid, err := strconv.Atoi(u.Uid)
+	if err != nil {
+		return fmt.Errorf(i18n.G("invalid uid %q for %q: %w"), u.Uid, objectName, err)
+	}
+
+	return notification.Write(filepath.Join(m.runDir, notification.DirName), uid, summary, lines)
+}
+
+// removeNotification removes the new restrictions notification of objectName not shown yet.
+func (m *Manager) removeNotification(ctx context.Context, objectName string) error {
+	u, err := user.Lookup(objectName)
+	if err != nil {
+		// Users who can't be resolved anymore have no session to show the notification in.
+		log.Debugf(ctx, "Not removing new restrictions notification of %s: %v", objectName, err)
+		return nil
+	}
+	return os.RemoveAll(notification.Path(filepath.Join(m.runDir, notification.DirName), u.Uid))
+}
+
+// newRestrictions returns the description of the dconf settings locked by entries which were not locked by
+// previous entries, or which are locked to a different value.
+func newRestrictions(previous, entries []entry.Entry) (restrictions []string) {
+	locked := make(map[string]string)
+	for _, e := range previous {
+		locked[e.Key] = diffValue(e)
+	}
+
+	for _, e := range entries {
+		v, ok := locked[e.Key]
+		switch {
+		case !ok:
+			restrictions = append(restrictions, fmt.Sprintf(i18n.G("%s is now enforced"), e.Key))
+		case v != diffValue(e):
+			restrictions = append(restrictions, fmt.Sprintf(i18n.G("%s is enforced to a new value"), e.Key))
+		}
+	}
+	sort.Strings(restrictions)
+
+	return restrictions
+}
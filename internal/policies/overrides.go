@@ -0,0 +1,97 @@
+package policies
+
+import (
+	"context"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/ubuntu/adsys/internal/policies/entry"
+)
+
+// resolvedGPO is a GPO with its unexpired entries, sorted by policy manager, resolved against the entries of the same
+// key of the other GPOs.
+type resolvedGPO struct {
+	GPO
+	entries []resolvedEntry
+}
+
+// resolvedEntry is an entry of a GPO handled by manager, with the GPO whose entry is enforced for its key.
+type resolvedEntry struct {
+	entry.Entry
+	manager string
+	// winner is the GPO whose entry is enforced for the key. It is empty for the report-only entries which are not
+	// overridden, as they never are.
+	winner string
+	// overridden is true when the entry of another GPO is enforced instead.
+	overridden bool
+	// restrictive is true when the key is resolved with the most restrictive precedence.
+	restrictive bool
+}
+
+// overrideResolver resolves the entries of the GPOs applied to successive objects. The machine GPOs must be resolved
+// first, as their entries override the user ones, whatever their precedence.
+type overrideResolver struct {
+	precedence map[string]string
+	// winningGPOs is the GPO enforcing each key already resolved.
+	winningGPOs map[string]string
+}
+
+// newOverrideResolver returns a resolver applying the precedence strategy of each policy manager.
+func newOverrideResolver(precedence map[string]string) *overrideResolver {
+	return &overrideResolver{
+		precedence:  precedence,
+		winningGPOs: make(map[string]string),
+	}
+}
+
+// resolve returns the GPOs of pols with their entries which did not expire, as those are reverted on the system.
+// Entries of keys enforced by previously resolved GPOs are overridden. Report-only entries never override others.
+func (o *overrideResolver) resolve(ctx context.Context, pols Policies) []resolvedGPO {
+	pols = pols.unexpired(ctx, time.Now())
+	restrictiveWinners := mostRestrictiveWinners(pols.GPOs, o.precedence)
+	// Keys enforced by previous objects are not compared anymore.
+	for k := range restrictiveWinners {
+		if _, ok := o.winningGPOs[k]; ok {
+			delete(restrictiveWinners, k)
+		}
+	}
+
+	gpos := make([]resolvedGPO, 0, len(pols.GPOs))
+	for _, g := range pols.GPOs {
+		var domains []string
+		for domain := range g.Rules {
+			domains = append(domains, domain)
+		}
+		sort.Strings(domains)
+
+		resolved := resolvedGPO{GPO: g}
+		for _, d := range domains {
+			for _, r := range g.Rules[d] {
+				e := resolvedEntry{Entry: r, manager: d, winner: g.ID}
+
+				k := filepath.Join(d, r.Key)
+				restrictiveWinner, restrictive := restrictiveWinners[k]
+				if winner, overr := o.winningGPOs[k]; overr {
+					e.overridden = true
+					e.winner = winner
+				} else if r.ReportOnly {
+					e.winner = ""
+				} else if restrictive && restrictiveWinner != g.ID {
+					// A further GPO wins with a more restrictive entry.
+					e.overridden = true
+					e.winner = restrictiveWinner
+				} else if r.Strategy != entry.StrategyAppend {
+					// Non overridable keys can't win over other entries.
+					o.winningGPOs[k] = g.ID
+				}
+				e.restrictive = restrictive && !r.ReportOnly
+
+				resolved.entries = append(resolved.entries, e)
+			}
+		}
+		gpos = append(gpos, resolved)
+	}
+
+	return gpos
+}
@@ -0,0 +1,474 @@
+package policies
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"os/user"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	log "github.com/ubuntu/adsys/internal/grpc/logstreamer"
+	"github.com/ubuntu/adsys/internal/i18n"
+	"github.com/ubuntu/decorate"
+	"golang.org/x/exp/slices"
+	"gopkg.in/yaml.v3"
+)
+
+// ownedCacheBaseName is the cache directory where the files written by the policy managers on the last apply of
+// each object are recorded.
+const ownedCacheBaseName = "owned"
+
+// ownedFile is a file written by a policy manager.
+// Its path is relative to one of the managed root directories, so that the record doesn't depend on where they are
+// configured.
+type ownedFile struct {
+	Manager string `yaml:"manager"`
+	Root    string `yaml:"root"`
+	Path    string `yaml:"path"`
+	// Hash is the hexadecimal SHA-256 of the content written by the policy manager.
+	Hash string `yaml:"sha256"`
+	// GPOs are the GPOs providing the entries of the policy manager, by order of precedence.
+	GPOs []string `yaml:"gpos,omitempty"`
+}
+
+// File states, compared to the content written on the last apply.
+const (
+	ownedFileOK       = "ok"
+	ownedFileModified = "modified"
+	ownedFileMissing  = "missing"
+)
+
+// managedFiles returns the files currently written for objectName by each policy manager.
+// Flag files, starting with a dot, are not configuration and are not listed.
+func (m *Manager) managedFiles(objectName string, isComputer bool) (files map[string][]string, err error) {
+	dconfDests := m.dconf.Destinations()
+	profilesDir, machineDBDir, usersShardDir := dconfDests[0], dconfDests[1], dconfDests[2]
+	dbsDir := filepath.Dir(machineDBDir)
+	apparmorDir := m.apparmor.Destinations()[0]
+
+	files = make(map[string][]string)
+	add := func(manager string, paths ...string) error {
+		for _, p := range paths {
+			found, err := filesIn(p)
+			if err != nil {
+				return err
+			}
+			files[manager] = append(files[manager], found...)
+		}
+		return nil
+	}
+
+	if isComputer {
+		gppFiles, err := m.gpp.ManagedFiles()
+		if err != nil {
+			return nil, err
+		}
+		for manager, paths := range map[string][]string{
+			"dconf":     {machineDBDir},
+			"gdm":       {filepath.Join(profilesDir, "gdm"), filepath.Join(dbsDir, "gdm.d")},
+			"privilege": m.privilege.Destinations(),
+			"apparmor":  {filepath.Join(apparmorDir, "machine")},
+			"scripts":   {filepath.Join(m.runDir, "machine", "scripts")},
+			"mount":     m.mount.SystemUnits(),
+			"gpp":       gppFiles,
+			"sssd":      m.sssd.Destinations(),
+			"netplan":   m.netplan.Destinations(),
+			"journald":  m.journald.Destinations(),
+		} {
+			if err := add(manager, paths...); err != nil {
+				return nil, err
+			}
+		}
+		return files, nil
+	}
+
+	for manager, paths := range map[string][]string{
+		"dconf": {
+			filepath.Join(profilesDir, objectName),
+			filepath.Join(dbsDir, objectName+".d"),
+			filepath.Join(usersShardDir, objectName+".d"),
+		},
+		"apparmor": {filepath.Join(apparmorDir, "users", objectName)},
+	} {
+		if err := add(manager, paths...); err != nil {
+			return nil, err
+		}
+	}
+
+	// The run directory of users is named after their uid: a user unknown to the system has none.
+	u, err := user.Lookup(objectName)
+	if err != nil {
+		return files, nil
+	}
+	userRunDir := filepath.Join(m.runDir, "users", u.Uid)
+	for manager, paths := range map[string][]string{
+		"scripts":     {filepath.Join(userRunDir, "scripts")},
+		"mount":       {filepath.Join(userRunDir, "mounts")},
+		"environment": {filepath.Join(userRunDir, "environment")},
+	} {
+		if err := add(manager, paths...); err != nil {
+			return nil, err
+		}
+	}
+	return files, nil
+}
+
+// filesIn returns p if it is a file, or the files under p if it is a directory. A missing p has no file.
+func filesIn(p string) (files []string, err error) {
+	err = filepath.WalkDir(p, func(path string, d fs.DirEntry, err error) error {
+		if errors.Is(err, fs.ErrNotExist) && path == p {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if strings.HasPrefix(d.Name(), ".") && path != p {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if d.Type().IsRegular() {
+			files = append(files, path)
+		}
+		return nil
+	})
+	return files, err
+}
+
+// saveOwnedFiles records files, the files written for objectName by each policy manager, with their hash and the
+// GPOs providing the entries of their policy manager.
+// The record is removed once no file is written for objectName anymore.
+func (m *Manager) saveOwnedFiles(objectName string, files map[string][]string, gpos []GPO) error {
+	gposByManager := make(map[string][]string)
+	for _, g := range gpos {
+		seen := make(map[string]struct{})
+		for key, entries := range g.Rules {
+			manager := managerForRulesKey(key)
+			if _, ok := seen[manager]; ok || len(entries) == 0 {
+				continue
+			}
+			seen[manager] = struct{}{}
+			gposByManager[manager] = append(gposByManager[manager], g.Name)
+		}
+	}
+
+	var owned []ownedFile
+	for manager, paths := range files {
+		for _, p := range paths {
+			h, err := fileHash(p)
+			if err != nil {
+				return err
+			}
+			root, rel := m.relToOwnedRoot(p)
+			owned = append(owned, ownedFile{
+				Manager: manager,
+				Root:    root,
+				Path:    rel,
+				Hash:    h,
+				GPOs:    gposByManager[manager],
+			})
+		}
+	}
+	sort.Slice(owned, func(i, j int) bool {
+		if owned[i].Root != owned[j].Root {
+			return owned[i].Root < owned[j].Root
+		}
+		return owned[i].Path < owned[j].Path
+	})
+
+	p := m.objectPath(ownedCacheBaseName, objectName)
+	if len(owned) == 0 {
+		if err := os.Remove(p); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+		return nil
+	}
+
+	// The directory is only created once there are files to record.
+	if err := os.MkdirAll(filepath.Dir(p), 0700); err != nil {
+		return err
+	}
+	d, err := yaml.Marshal(owned)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(p+".new", d, 0600); err != nil {
+		return err
+	}
+	return os.Rename(p+".new", p)
+}
+
+// relToOwnedRoot returns the name of the managed root directory containing p, the most specific one, and the path of
+// p relative to it. p is returned unchanged with an empty root name if no root contains it.
+func (m *Manager) relToOwnedRoot(p string) (root, rel string) {
+	rel = p
+	var rootDir string
+	for name, dir := range m.ownedRoots {
+		r, err := filepath.Rel(dir, p)
+		if err != nil || r == ".." || strings.HasPrefix(r, "../") {
+			continue
+		}
+		if len(dir) > len(rootDir) {
+			root, rootDir, rel = name, dir, r
+		}
+	}
+	return root, rel
+}
+
+// absPath returns the absolute path of the recorded file, in the current managed root directories.
+func (m *Manager) absPath(f ownedFile) string {
+	if f.Root == "" {
+		return f.Path
+	}
+	return filepath.Join(m.ownedRoots[f.Root], f.Path)
+}
+
+// fileHash returns the hexadecimal SHA-256 of the content of p.
+func fileHash(p string) (string, error) {
+	f, err := os.Open(p)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// fileState returns if p still has the content hashed as expected on the last apply.
+func fileState(p, expected string) string {
+	h, err := fileHash(p)
+	if err != nil {
+		return ownedFileMissing
+	}
+	if h != expected {
+		return ownedFileModified
+	}
+	return ownedFileOK
+}
+
+// objectFile is a file recorded for an object, with its absolute path in the current managed root directories.
+type objectFile struct {
+	ownedFile
+	object string
+	path   string
+}
+
+// ownedFiles returns the files written by the policy managers on the last apply of each object, sorted by path.
+// If manager is not empty, only the files of this policy manager are returned.
+func (m *Manager) ownedFiles(ctx context.Context, manager string) (files []objectFile, err error) {
+	if manager != "" && !slices.Contains(builtinRules, manager) {
+		return nil, fmt.Errorf(i18n.G("unknown policy manager %q"), manager)
+	}
+
+	records, err := CachedObjects(m.cacheDir, ownedCacheBaseName)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, object := range records {
+		d, err := os.ReadFile(m.objectPath(ownedCacheBaseName, object))
+		if err != nil {
+			return nil, err
+		}
+		var owned []ownedFile
+		if err := yaml.Unmarshal(d, &owned); err != nil {
+			log.Warningf(ctx, i18n.G("Skipping invalid managed files record of %q: %v"), object, err)
+			continue
+		}
+		for _, f := range owned {
+			if manager != "" && f.Manager != manager {
+				continue
+			}
+			files = append(files, objectFile{ownedFile: f, object: object, path: m.absPath(f)})
+		}
+	}
+	sort.SliceStable(files, func(i, j int) bool { return files[i].path < files[j].path })
+
+	return files, nil
+}
+
+// Owns lists the files written by the policy managers on the last apply of each object, with the GPOs providing the
+// entries of their policy manager and their expected SHA-256 hash, as tab separated values.
+// If path is not empty, it reports instead whether adsys manages path and where it comes from.
+// If manager is not empty, only the files of this policy manager are considered.
+func (m *Manager) Owns(ctx context.Context, path, manager string) (msg string, err error) {
+	defer decorate.OnError(&err, i18n.G("failed to list files managed by adsys"))
+
+	log.Info(ctx, "Listing files managed by adsys")
+
+	files, err := m.ownedFiles(ctx, manager)
+	if err != nil {
+		return "", err
+	}
+
+	var out strings.Builder
+	if path == "" {
+		fmt.Fprintln(&out, "PATH\tMANAGER\tOBJECT\tSTATE\tSHA256\tGPOS")
+		for _, f := range files {
+			fmt.Fprintf(&out, "%s\t%s\t%s\t%s\t%s\t%s\n", f.path, f.Manager, f.object, fileState(f.path, f.Hash), f.Hash, strings.Join(f.GPOs, ","))
+		}
+		return out.String(), nil
+	}
+
+	path = filepath.Clean(path)
+	for _, f := range files {
+		if f.path != path {
+			continue
+		}
+		state := i18n.G("matches the last apply")
+		switch fileState(f.path, f.Hash) {
+		case ownedFileModified:
+			state = i18n.G("modified since the last apply")
+		case ownedFileMissing:
+			state = i18n.G("missing")
+		}
+		gpos := strings.Join(f.GPOs, ", ")
+		if gpos == "" {
+			gpos = i18n.G("none")
+		}
+		fmt.Fprintf(&out, i18n.G(`%s is managed by adsys:
+  Manager: %s
+  Object: %s
+  GPOs: %s
+  Expected SHA-256: %s
+  Current content: %s
+`), path, f.Manager, f.object, gpos, f.Hash, state)
+	}
+	if out.Len() == 0 {
+		return fmt.Sprintf(i18n.G("%s is not managed by adsys.\n"), path), nil
+	}
+	return out.String(), nil
+}
+
+// ManagedFile is the structured representation of a file written by a policy manager on the last apply of an object.
+// SHA256 is the hash of the content written by adsys, and State whether the file still has this content.
+type ManagedFile struct {
+	Path   string   `json:"path" yaml:"path"`
+	Object string   `json:"object" yaml:"object"`
+	SHA256 string   `json:"sha256" yaml:"sha256"`
+	State  string   `json:"state" yaml:"state"`
+	GPOs   []string `json:"gpos,omitempty" yaml:"gpos,omitempty"`
+}
+
+// OwnsStructured lists the files written by the policy managers on the last apply of each object, by policy manager,
+// in the given machine readable format. It is meant for other configuration management tools to exclude those files.
+// If manager is not empty, only the files of this policy manager are listed.
+func (m *Manager) OwnsStructured(ctx context.Context, manager, format string) (msg string, err error) {
+	defer decorate.OnError(&err, i18n.G("failed to list files managed by adsys"))
+
+	log.Infof(ctx, "Listing files managed by adsys in %s format", format)
+
+	if format != FormatJSON && format != FormatYAML {
+		return "", fmt.Errorf(i18n.G("unsupported format %q"), format)
+	}
+
+	files, err := m.ownedFiles(ctx, manager)
+	if err != nil {
+		return "", err
+	}
+
+	byManager := make(map[string][]ManagedFile)
+	for _, f := range files {
+		byManager[f.Manager] = append(byManager[f.Manager], ManagedFile{
+			Path:   f.path,
+			Object: f.object,
+			SHA256: f.Hash,
+			State:  fileState(f.path, f.Hash),
+			GPOs:   f.GPOs,
+		})
+	}
+
+	var d []byte
+	switch format {
+	case FormatJSON:
+		d, err = json.MarshalIndent(byManager, "", "  ")
+		d = append(d, '\n')
+	case FormatYAML:
+		d, err = yaml.Marshal(byManager)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	return string(d), nil
+}
+
+// ReapplyModified applies again, from the cached policies, the policy managers whose files were modified or removed
+// since the last apply of each object, like by a package upgrade replacing them. The policy managers whose last apply
+// failed are applied again too, so that their previous error is not kept. The other policy managers are left
+// untouched.
+// It returns the policy managers applied again for each object.
+func (m *Manager) ReapplyModified(ctx context.Context) (msg string, err error) {
+	defer decorate.OnError(&err, i18n.G("failed to apply again the policies of modified files"))
+
+	log.Info(ctx, "Checking files managed by adsys for modifications")
+
+	files, err := m.ownedFiles(ctx, "")
+	if err != nil {
+		return "", err
+	}
+
+	modified := make(map[string][]string)
+	for _, f := range files {
+		if fileState(f.path, f.Hash) == ownedFileOK || slices.Contains(modified[f.object], f.Manager) {
+			continue
+		}
+		log.Infof(ctx, i18n.G("%s, managed by the %s policy manager for %s, was modified since the last apply"), f.path, f.Manager, f.object)
+		modified[f.object] = append(modified[f.object], f.Manager)
+	}
+
+	objects := make([]string, 0, len(modified))
+	for object := range modified {
+		objects = append(objects, object)
+	}
+	sort.Strings(objects)
+
+	var out strings.Builder
+	var errs []error
+	for _, object := range objects {
+		managers := modified[object]
+		if previous, err := loadStatus(m.objectPath(statusCacheBaseName, object)); err == nil {
+			for _, st := range previous {
+				if st.Error != "" && !slices.Contains(managers, st.Manager) {
+					managers = append(managers, st.Manager)
+				}
+			}
+		}
+		sort.Strings(managers)
+
+		pols, err := NewFromCache(ctx, m.objectPath(PoliciesCacheBaseName, object))
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		log.Infof(ctx, i18n.G("Applying again %s policies for %s"), strings.Join(managers, ", "), object)
+		if err := m.ApplyPolicies(ctx, object, object == m.hostname, &pols, func(o *applyOptions) { o.managers = managers }); err != nil {
+			errs = append(errs, err)
+		} else {
+			fmt.Fprintf(&out, i18n.G("Applied again %s policies for %s\n"), strings.Join(managers, ", "), object)
+		}
+		if err := pols.Close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if err := errors.Join(errs...); err != nil {
+		return "", err
+	}
+
+	if out.Len() == 0 {
+		return i18n.G("All files managed by adsys match their last apply.\n"), nil
+	}
+	return out.String(), nil
+}
@@ -0,0 +1,197 @@
+// Package plugins is the policy manager running out-of-tree policy managers.
+//
+// Each executable in the plugins directory handles the policy type named after it. Plugins are executed
+// on every policy update, with the resolved entries of their type sent on their standard input, following
+// the protocol defined in the github.com/ubuntu/adsys/plugin package.
+//
+// Plugins named after a policy type handled by adsys are ignored. A missing plugins directory means
+// there is no plugin installed.
+package plugins
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	log "github.com/ubuntu/adsys/internal/grpc/logstreamer"
+	"github.com/ubuntu/adsys/internal/i18n"
+	"github.com/ubuntu/adsys/internal/policies/entry"
+	"github.com/ubuntu/adsys/internal/smbsafe"
+	"github.com/ubuntu/adsys/plugin"
+	"github.com/ubuntu/decorate"
+	"golang.org/x/exp/slices"
+)
+
+// DefaultTimeout is the maximum duration of a plugin execution.
+const DefaultTimeout = time.Minute
+
+// Manager runs the plugins found in its directory.
+type Manager struct {
+	pluginsDir string
+	reserved   []string
+	timeout    time.Duration
+}
+
+type options struct {
+	timeout time.Duration
+}
+
+// Option reprents an optional function to change the plugins manager.
+type Option func(*options)
+
+// WithTimeout overrides the maximum duration of a plugin execution.
+func WithTimeout(timeout time.Duration) Option {
+	return func(o *options) {
+		o.timeout = timeout
+	}
+}
+
+// New creates a manager running plugins from pluginsDir. Plugins named after one of the reserved
+// policy types are not executed.
+func New(pluginsDir string, reserved []string, opts ...Option) *Manager {
+	// defaults
+	args := options{
+		timeout: DefaultTimeout,
+	}
+	// applied options
+	for _, o := range opts {
+		o(&args)
+	}
+
+	return &Manager{
+		pluginsDir: pluginsDir,
+		reserved:   reserved,
+		timeout:    args.timeout,
+	}
+}
+
+// ApplyPolicy executes all plugins with the rules of their policy type for objectName.
+// All plugins are executed, even if some of them fail.
+func (m *Manager) ApplyPolicy(ctx context.Context, objectName string, isComputer bool, rules map[string][]entry.Entry) (err error) {
+	defer decorate.OnError(&err, i18n.G("can't apply plugins policies to %s"), objectName)
+
+	names, err := m.plugins(ctx)
+	if err != nil {
+		return err
+	}
+
+	var errs []error
+	for _, name := range names {
+		if err := m.run(ctx, name, objectName, isComputer, rules[name]); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Names returns the sorted policy types handled by the installed plugins.
+func (m *Manager) Names(ctx context.Context) (names []string, err error) {
+	defer decorate.OnError(&err, i18n.G("can't list plugins"))
+
+	return m.plugins(ctx)
+}
+
+// plugins returns the sorted list of plugins to execute.
+func (m *Manager) plugins(ctx context.Context) (names []string, err error) {
+	dirEntries, err := os.ReadDir(m.pluginsDir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	for _, de := range dirEntries {
+		name := de.Name()
+		if strings.HasPrefix(name, ".") {
+			continue
+		}
+		info, err := os.Stat(filepath.Join(m.pluginsDir, name))
+		if err != nil {
+			log.Warningf(ctx, i18n.G("Ignoring plugin %q: %v"), name, err)
+			continue
+		}
+		if !info.Mode().IsRegular() || info.Mode().Perm()&0111 == 0 {
+			log.Debugf(ctx, "Ignoring %q in plugins directory: not an executable file", name)
+			continue
+		}
+		if slices.Contains(m.reserved, name) {
+			log.Warningf(ctx, i18n.G("Ignoring plugin %q: this policy type is handled by adsys"), name)
+			continue
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names, nil
+}
+
+// run executes a plugin and returns the error it reported.
+func (m *Manager) run(ctx context.Context, name, objectName string, isComputer bool, entries []entry.Entry) (err error) {
+	defer decorate.OnError(&err, i18n.G("plugin %q failed"), name)
+
+	log.Debugf(ctx, "Running plugin %q for %s", name, objectName)
+
+	req := plugin.Request{
+		Version:    plugin.ProtocolVersion,
+		ObjectName: objectName,
+		IsComputer: isComputer,
+		Entries:    make([]plugin.Entry, 0, len(entries)),
+	}
+	for _, e := range entries {
+		req.Entries = append(req.Entries, plugin.Entry{
+			Key:      e.Key,
+			Value:    e.Value,
+			Disabled: e.Disabled,
+			Meta:     e.Meta,
+		})
+	}
+	payload, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+
+	cmdCtx, cancel := context.WithTimeout(ctx, m.timeout)
+	defer cancel()
+	// #nosec G204 - plugins are installed by the administrator in a root owned directory.
+	cmd := exec.CommandContext(cmdCtx, filepath.Join(m.pluginsDir, name))
+	cmd.Stdin = bytes.NewReader(payload)
+	// Don't wait for children of a killed plugin still holding its output.
+	cmd.WaitDelay = time.Second
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	smbsafe.WaitExec()
+	errExec := cmd.Run()
+	smbsafe.DoneExec()
+
+	if stderr.Len() > 0 {
+		log.Debugf(ctx, "Plugin %q output: %s", name, strings.TrimSpace(stderr.String()))
+	}
+
+	var resp plugin.Response
+	errResp := json.Unmarshal(stdout.Bytes(), &resp)
+	if errResp == nil && resp.Error != "" {
+		return errors.New(resp.Error)
+	}
+	if errExec != nil {
+		if cmd.ProcessState == nil {
+			return errExec
+		}
+		return fmt.Errorf(i18n.G("exited with %d: %v\n%s"), cmd.ProcessState.ExitCode(), errExec, stderr.String())
+	}
+	if errResp != nil {
+		return fmt.Errorf(i18n.G("invalid response %q: %v"), stdout.String(), errResp)
+	}
+
+	return nil
+}
@@ -0,0 +1,104 @@
+package plugins_test
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/ubuntu/adsys/internal/policies/entry"
+	"github.com/ubuntu/adsys/internal/policies/plugins"
+	"github.com/ubuntu/adsys/internal/testutils"
+)
+
+// pluginBehaviors are the scripts body of the test plugins. They save the request they received in the output directory.
+var pluginBehaviors = map[string]string{
+	"success":          `echo '{"version":1}'`,
+	"reports error":    `echo '{"version":1,"error":"requested error"}'`,
+	"exits non zero":   `echo 'requested failure' >&2; exit 1`,
+	"invalid response": `echo 'not json'`,
+	"too slow":         `sleep 5; echo '{"version":1}'`,
+}
+
+func TestApplyPolicy(t *testing.T) {
+	t.Parallel()
+
+	rules := map[string][]entry.Entry{
+		"firewall": {
+			{Key: "default-policy", Value: "deny"},
+			{Key: "allowed-ports", Value: "22\n443", Meta: "as"},
+			{Key: "logging", Disabled: true},
+		},
+		"backup": {{Key: "destination", Value: "/srv/backup"}},
+		"dconf":  {{Key: "org/gnome/desktop/interface/clock-format", Value: "24h"}},
+	}
+
+	tests := map[string]struct {
+		plugins        map[string]string
+		nonExecutables []string
+		noPluginsDir   bool
+		isComputer     bool
+
+		wantErr bool
+	}{
+		"Plugin receives the entries of its policy type": {plugins: map[string]string{"firewall": "success"}},
+		"Computer policies are sent to plugins":          {plugins: map[string]string{"firewall": "success"}, isComputer: true},
+		"Plugin is run with no entries":                  {plugins: map[string]string{"nothing-set": "success"}},
+		"Multiple plugins":                               {plugins: map[string]string{"firewall": "success", "backup": "success"}},
+		"Plugin for a builtin policy type is ignored":    {plugins: map[string]string{"dconf": "success", "backup": "success"}},
+		"Non executable files are ignored":               {plugins: map[string]string{"backup": "success"}, nonExecutables: []string{"firewall", ".hidden"}},
+		"Missing plugins directory is a noop":            {noPluginsDir: true},
+
+		// Error cases
+		"Error on plugin reporting an error":          {plugins: map[string]string{"firewall": "reports error"}, wantErr: true},
+		"Error on plugin exiting with non zero code":  {plugins: map[string]string{"firewall": "exits non zero"}, wantErr: true},
+		"Error on plugin sending an invalid response": {plugins: map[string]string{"firewall": "invalid response"}, wantErr: true},
+		"Error on plugin timing out":                  {plugins: map[string]string{"firewall": "too slow"}, wantErr: true},
+		"Error on one plugin still runs the others":   {plugins: map[string]string{"firewall": "reports error", "backup": "success"}, wantErr: true},
+	}
+	for name, tc := range tests {
+		tc := tc
+		name := name
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			tmpDir := t.TempDir()
+			pluginsDir := filepath.Join(tmpDir, "plugins")
+			outputDir := filepath.Join(tmpDir, "output")
+			require.NoError(t, os.MkdirAll(outputDir, 0750), "Setup: can't create output directory")
+			if !tc.noPluginsDir {
+				require.NoError(t, os.MkdirAll(pluginsDir, 0750), "Setup: can't create plugins directory")
+			}
+
+			for pluginName, behavior := range tc.plugins {
+				script := fmt.Sprintf("#!/bin/sh\ncat > %q\n%s\n", filepath.Join(outputDir, pluginName+".json"), pluginBehaviors[behavior])
+				// #nosec G306 - plugins need to be executable.
+				require.NoError(t, os.WriteFile(filepath.Join(pluginsDir, pluginName), []byte(script), 0700), "Setup: can't create plugin")
+			}
+			for _, f := range tc.nonExecutables {
+				require.NoError(t, os.WriteFile(filepath.Join(pluginsDir, f), []byte("#!/bin/sh\nexit 1\n"), 0600), "Setup: can't create non executable file")
+			}
+
+			m := plugins.New(pluginsDir, []string{"dconf"}, plugins.WithTimeout(time.Second))
+			err := m.ApplyPolicy(context.Background(), "ubuntu", tc.isComputer, rules)
+			if tc.wantErr {
+				require.Error(t, err, "ApplyPolicy should have failed but didn't")
+			} else {
+				require.NoError(t, err, "ApplyPolicy failed but shouldn't have")
+			}
+
+			testutils.CompareTreesWithFiltering(t, outputDir, testutils.GoldenPath(t), testutils.Update())
+		})
+	}
+}
+
+func TestMain(m *testing.M) {
+	testutils.InstallUpdateFlag()
+	flag.Parse()
+
+	m.Run()
+}
@@ -6,11 +6,15 @@ package policies
 
 import (
 	"archive/zip"
+	"bytes"
+	"compress/gzip"
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"io/fs"
+	"net"
 	"os"
 	"path/filepath"
 	"sort"
@@ -26,11 +30,19 @@ import (
 
 const (
 	// PoliciesCacheBaseName is the base directory where we want to cache policies.
-	PoliciesCacheBaseName  = "policies"
+	PoliciesCacheBaseName = "policies"
+	// SysvolCacheBaseName is the base directory where we download GPOs and assets from the sysvol share.
+	SysvolCacheBaseName    = "sysvol"
 	policiesFileName       = "policies"
 	policiesAssetsFileName = "assets.db"
+
+	// policiesCacheVersion is the version of the policies cache encoding. It is bumped on any incompatible change.
+	policiesCacheVersion = 1
 )
 
+// policiesCacheMagic prefixes the policies cache encoding. Caches without it are from older versions and in YAML.
+var policiesCacheMagic = []byte("ADSYSPOL")
+
 type assetsFromMMAP struct {
 	*zip.Reader
 	filemmap   *mmap.ReaderAt
@@ -39,7 +51,7 @@ type assetsFromMMAP struct {
 
 // Policies is the list of GPOs applied to a particular object, with the global data cache.
 type Policies struct {
-	GPOs   []GPO
+	GPOs   []GPO           `json:"gpos"`
 	assets *assetsFromMMAP `yaml:"-"`
 }
 
@@ -76,7 +88,7 @@ func NewFromCache(ctx context.Context, p string) (pols Policies, err error) {
 		return pols, err
 	}
 
-	if err := yaml.Unmarshal(d, &pols); err != nil {
+	if err := decodeCache(d, &pols); err != nil {
 		return pols, err
 	}
 
@@ -127,7 +139,7 @@ func (pols *Policies) Save(p string) (err error) {
 	}
 
 	// GPOs policies
-	d, err := yaml.Marshal(pols)
+	d, err := encodeCache(pols)
 	if err != nil {
 		return err
 	}
@@ -185,6 +197,55 @@ func (pols *Policies) Save(p string) (err error) {
 	return nil
 }
 
+// encodeCache returns the compressed and versioned encoding of the GPOs of pols.
+func encodeCache(pols *Policies) ([]byte, error) {
+	var b bytes.Buffer
+	b.Write(policiesCacheMagic)
+	b.WriteByte(policiesCacheVersion)
+
+	w := gzip.NewWriter(&b)
+	if err := json.NewEncoder(w).Encode(pols); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+
+	return b.Bytes(), nil
+}
+
+// decodeCache decodes in pols the GPOs from d. It reads both the current encoding and older YAML caches.
+func decodeCache(d []byte, pols *Policies) error {
+	if !bytes.HasPrefix(d, policiesCacheMagic) {
+		return yaml.Unmarshal(d, pols)
+	}
+	d = d[len(policiesCacheMagic):]
+
+	if len(d) == 0 || d[0] != policiesCacheVersion {
+		return errors.New(i18n.G("unsupported policies cache version"))
+	}
+
+	r, err := gzip.NewReader(bytes.NewReader(d[1:]))
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	return json.NewDecoder(r).Decode(pols)
+}
+
+// assetsDiskUsage returns the disk space used by the assets once uncompressed, in addition to their compressed
+// database.
+func (pols *Policies) assetsDiskUsage() (size uint64) {
+	if pols.assets == nil {
+		return 0
+	}
+	for _, f := range pols.assets.File {
+		size += f.UncompressedSize64
+	}
+	return size + uint64(pols.assets.filemmap.Len())
+}
+
 // Close closes underlying mmaped file.
 func (pols *Policies) Close() (err error) {
 	if pols.assets == nil {
@@ -363,9 +424,85 @@ func CompressAssets(ctx context.Context, p string) (err error) {
 	return err
 }
 
+// inSubnets returns a copy of pols without the entries whose subnets condition is not satisfied by the machine
+// addresses ips. pols is not modified, so that the conditions are evaluated again on the next apply.
+func (pols Policies) inSubnets(ctx context.Context, ips []net.IP) Policies {
+	filtered := pols
+	filtered.GPOs = make([]GPO, 0, len(pols.GPOs))
+	for _, g := range pols.GPOs {
+		rules := make(map[string][]entry.Entry, len(g.Rules))
+		for t, entries := range g.Rules {
+			kept := make([]entry.Entry, 0, len(entries))
+			for _, e := range entries {
+				if e.Subnets == "" {
+					kept = append(kept, e)
+					continue
+				}
+				in, err := e.InSubnets(ips)
+				if err != nil {
+					log.Warningf(ctx, i18n.G("Skipping %s entry %s of GPO %q: %v"), t, e.Key, g.Name, err)
+					continue
+				}
+				if !in {
+					log.Debugf(ctx, "Skipping %s entry %s of GPO %q: the machine is not in subnets %q", t, e.Key, g.Name, e.Subnets)
+					continue
+				}
+				kept = append(kept, e)
+			}
+			rules[t] = kept
+		}
+		g.Rules = rules
+		filtered.GPOs = append(filtered.GPOs, g)
+	}
+	return filtered
+}
+
+// enforced returns the policies without their report-only entries, which are never applied. The entries of lower
+// priority GPOs are applied instead.
+func (pols Policies) enforced() Policies {
+	filtered := pols
+	filtered.GPOs = make([]GPO, 0, len(pols.GPOs))
+	for _, g := range pols.GPOs {
+		rules := make(map[string][]entry.Entry, len(g.Rules))
+		for t, entries := range g.Rules {
+			kept := make([]entry.Entry, 0, len(entries))
+			for _, e := range entries {
+				if e.ReportOnly {
+					continue
+				}
+				kept = append(kept, e)
+			}
+			rules[t] = kept
+		}
+		g.Rules = rules
+		filtered.GPOs = append(filtered.GPOs, g)
+	}
+	return filtered
+}
+
+// hasReportOnly returns if any entry of the policies is report-only.
+func (pols Policies) hasReportOnly() bool {
+	for _, g := range pols.GPOs {
+		for _, entries := range g.Rules {
+			for _, e := range entries {
+				if e.ReportOnly {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
 // GetUniqueRules return order rules, with one entry per key for a given type.
 // Returned file is a map of type to its entries.
 func (pols Policies) GetUniqueRules() map[string][]entry.Entry {
+	return pols.getUniqueRules(nil)
+}
+
+// getUniqueRules is GetUniqueRules, with the precedence strategy of each type of entries. Types without strategy use
+// the LSDOU precedence.
+func (pols Policies) getUniqueRules(precedence map[string]string) map[string][]entry.Entry {
 	r := make(map[string][]entry.Entry)
 	keys := make(map[string][]string)
 
@@ -404,6 +541,12 @@ func (pols Policies) GetUniqueRules() map[string][]entry.Entry {
 				default:
 					// override case
 					if _, exists := seen[t+e.Key]; exists {
+						// A further entry can only win if it is more restrictive than the closest one.
+						d, restrictive := restrictiveKey(precedence, t, e.Key)
+						if current := dedup[t][e.Key]; restrictive &&
+							current.Strategy != entry.StrategyAppend && moreRestrictive(d, e, current) {
+							dedup[t][e.Key] = e
+						}
 						continue
 					}
 					dedup[t][e.Key] = e
@@ -4,7 +4,6 @@ import (
 	"context"
 	"errors"
 	"flag"
-	"fmt"
 	"io/fs"
 	"log"
 	"os"
@@ -14,11 +13,9 @@ import (
 	"time"
 
 	"github.com/godbus/dbus/v5"
-	"github.com/godbus/dbus/v5/introspect"
-	"github.com/godbus/dbus/v5/prop"
 	"github.com/stretchr/testify/require"
 	"github.com/termie/go-shutil"
-	"github.com/ubuntu/adsys/internal/consts"
+	"github.com/ubuntu/adsys/adsystest"
 	"github.com/ubuntu/adsys/internal/policies"
 	"github.com/ubuntu/adsys/internal/policies/entry"
 	"github.com/ubuntu/adsys/internal/testutils"
@@ -100,12 +97,23 @@ func TestNewFromCache(t *testing.T) {
 		"With assets": {
 			cacheDir: "with_assets",
 		},
+		"Current compressed cache format": {
+			cacheDir: "current_format",
+		},
 
 		// Error cases
 		"Error on invalid policies cache": {
 			cacheDir: "invalid_policies_cache",
 			wantErr:  true,
 		},
+		"Error on unsupported cache format version": {
+			cacheDir: "unsupported_cache_version",
+			wantErr:  true,
+		},
+		"Error on corrupted compressed cache": {
+			cacheDir: "corrupted_compressed_cache",
+			wantErr:  true,
+		},
 		"Error on invalid assets db": {
 			cacheDir: "invalid_assets_db",
 			wantErr:  true,
@@ -539,7 +547,8 @@ func TestGetUniqueRules(t *testing.T) {
 		}}}
 
 	tests := map[string]struct {
-		gpos []policies.GPO
+		gpos       []policies.GPO
+		precedence map[string]string
 
 		want map[string][]entry.Entry
 	}{
@@ -880,6 +889,68 @@ func TestGetUniqueRules(t *testing.T) {
 					{Key: "A", Value: "closest value", Strategy: entry.StrategyAppend},
 				},
 			}},
+
+		// Most restrictive precedence cases
+		"Most restrictive, further lower value wins": {
+			gpos:       restrictiveGPOs(idleDelay, "300", "60"),
+			precedence: map[string]string{"dconf": policies.PrecedenceMostRestrictive},
+			want: map[string][]entry.Entry{
+				"dconf": {{Key: idleDelay, Value: "60"}},
+			}},
+		"Most restrictive, closest lower value wins": {
+			gpos:       restrictiveGPOs(idleDelay, "60", "300"),
+			precedence: map[string]string{"dconf": policies.PrecedenceMostRestrictive},
+			want: map[string][]entry.Entry{
+				"dconf": {{Key: idleDelay, Value: "60"}},
+			}},
+		"Most restrictive, quoted and typed values are compared": {
+			gpos:       restrictiveGPOs(idleDelay, "uint32 300", "'60'"),
+			precedence: map[string]string{"dconf": policies.PrecedenceMostRestrictive},
+			want: map[string][]entry.Entry{
+				"dconf": {{Key: idleDelay, Value: "'60'"}},
+			}},
+		"Most restrictive, non numeric values keep closest value": {
+			gpos:       restrictiveGPOs(idleDelay, "closest", "60"),
+			precedence: map[string]string{"dconf": policies.PrecedenceMostRestrictive},
+			want: map[string][]entry.Entry{
+				"dconf": {{Key: idleDelay, Value: "closest"}},
+			}},
+		"Most restrictive, only applies to its policy manager": {
+			gpos:       restrictiveGPOs(idleDelay, "300", "60"),
+			precedence: map[string]string{"privilege": policies.PrecedenceMostRestrictive},
+			want: map[string][]entry.Entry{
+				"dconf": {{Key: idleDelay, Value: "300"}},
+			}},
+		"Most restrictive, 0 disabling the feature is the least restrictive value": {
+			gpos:       restrictiveGPOs(idleDelay, "0", "300"),
+			precedence: map[string]string{"dconf": policies.PrecedenceMostRestrictive},
+			want: map[string][]entry.Entry{
+				"dconf": {{Key: idleDelay, Value: "300"}},
+			}},
+		"Most restrictive, 0 disabling the feature never wins": {
+			gpos:       restrictiveGPOs(idleDelay, "300", "0"),
+			precedence: map[string]string{"dconf": policies.PrecedenceMostRestrictive},
+			want: map[string][]entry.Entry{
+				"dconf": {{Key: idleDelay, Value: "300"}},
+			}},
+		"Most restrictive, 0 wins when it is the lowest value": {
+			gpos:       restrictiveGPOs("org/gnome/login-screen/allowed-failures", "3", "0"),
+			precedence: map[string]string{"dconf": policies.PrecedenceMostRestrictive},
+			want: map[string][]entry.Entry{
+				"dconf": {{Key: "org/gnome/login-screen/allowed-failures", Value: "0"}},
+			}},
+		"Most restrictive, undeclared keys keep closest value": {
+			gpos:       restrictiveGPOs("path/to/key", "300", "60"),
+			precedence: map[string]string{"dconf": policies.PrecedenceMostRestrictive},
+			want: map[string][]entry.Entry{
+				"dconf": {{Key: "path/to/key", Value: "300"}},
+			}},
+		"LSDOU precedence keeps closest value": {
+			gpos:       restrictiveGPOs(idleDelay, "300", "60"),
+			precedence: map[string]string{"dconf": policies.PrecedenceLSDOU},
+			want: map[string][]entry.Entry{
+				"dconf": {{Key: idleDelay, Value: "300"}},
+			}},
 	}
 
 	for name, tc := range tests {
@@ -891,12 +962,25 @@ func TestGetUniqueRules(t *testing.T) {
 			pols := policies.Policies{
 				GPOs: tc.gpos,
 			}
-			got := pols.GetUniqueRules()
+			got := pols.GetUniqueRulesWithPrecedence(tc.precedence)
 			require.Equal(t, tc.want, got, "GetUniqueRules returns expected policy entries with correct overrides")
 		})
 	}
 }
 
+// idleDelay is a dconf key whose lowest value is the most restrictive, except 0 which disables the screen blanking.
+const idleDelay = "org/gnome/desktop/session/idle-delay"
+
+// restrictiveGPOs returns a closest and a furthest GPOs defining the same dconf key with the given values.
+func restrictiveGPOs(key, closest, furthest string) []policies.GPO {
+	return []policies.GPO{
+		{ID: "closest", Name: "closest-name", Rules: map[string][]entry.Entry{
+			"dconf": {{Key: key, Value: closest}}}},
+		{ID: "furthest", Name: "furthest-name", Rules: map[string][]entry.Entry{
+			"dconf": {{Key: key, Value: furthest}}}},
+	}
+}
+
 // equalPoliciesToGolden compares the policies to the given file.
 func equalPoliciesToGolden(t *testing.T, got policies.Policies, golden string, update bool) {
 	t.Helper()
@@ -941,42 +1025,8 @@ func TestMain(m *testing.M) {
 			log.Fatalf("Setup: can’t send hello message on private system bus: %v", err)
 		}
 
-		intro := fmt.Sprintf(`
-		<node>
-			<interface name="%s">
-				<property name='Attached' type='b' access="readwrite"/>
-			</interface>%s%s</node>`, consts.SubscriptionDbusInterface, introspect.IntrospectDataString, prop.IntrospectDataString)
-		ua := struct{}{}
-		if err := conn.Export(ua, consts.SubscriptionDbusObjectPath, consts.SubscriptionDbusInterface); err != nil {
-			log.Fatalf("Setup: could not export subscription object: %v", err)
-		}
-
-		propsSpec := map[string]map[string]*prop.Prop{
-			consts.SubscriptionDbusInterface: {
-				"Attached": {
-					Value:    true,
-					Writable: true,
-					Emit:     prop.EmitTrue,
-					Callback: func(c *prop.Change) *dbus.Error { return nil },
-				},
-			},
-		}
-		_, err = prop.Export(conn, consts.SubscriptionDbusObjectPath, propsSpec)
-		if err != nil {
-			log.Fatalf("Setup: could not export property for subscription object: %v", err)
-		}
-
-		if err := conn.Export(introspect.Introspectable(intro), consts.SubscriptionDbusObjectPath,
-			"org.freedesktop.DBus.Introspectable"); err != nil {
-			log.Fatalf("Setup: could not export introspectable subscription object: %v", err)
-		}
-
-		reply, err := conn.RequestName(consts.SubscriptionDbusRegisteredName, dbus.NameFlagDoNotQueue)
-		if err != nil {
-			log.Fatalf("Setup: Failed to acquire sssd name on local system bus: %v", err)
-		}
-		if reply != dbus.RequestNameReplyPrimaryOwner {
-			log.Fatalf("Setup: Failed to acquire sssd name on local system bus: name is already taken")
+		if err := adsystest.ExportSubscriptionService(conn); err != nil {
+			log.Fatalf("Setup: %v", err)
 		}
 	}
 
@@ -0,0 +1,142 @@
+package policies
+
+import (
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/ubuntu/adsys/internal/i18n"
+	"github.com/ubuntu/adsys/internal/policies/entry"
+)
+
+// Precedence strategies between the entries of the same key defined by multiple GPOs.
+const (
+	// PrecedenceLSDOU is the default Windows precedence: the entry of the GPO linked the closest to the object, or
+	// enforced, wins.
+	PrecedenceLSDOU = "lsdou"
+	// PrecedenceMostRestrictive makes the entry with the most restrictive numeric value win, like the shortest screen
+	// lock delay, whatever the GPO defining it. Only the keys declared in restrictiveKeys are compared: other keys
+	// and entries without a numeric value keep the LSDOU precedence.
+	PrecedenceMostRestrictive = "most-restrictive"
+)
+
+// restrictiveDirection is which numeric values of a key are the most restrictive.
+type restrictiveDirection int
+
+const (
+	// lowestWins makes the lowest value the most restrictive.
+	lowestWins restrictiveDirection = iota
+	// lowestNonZeroWins makes the lowest value the most restrictive, except 0 which disables the feature and is the
+	// least restrictive one.
+	lowestNonZeroWins
+)
+
+// restrictiveKeys declares the direction of the keys, prefixed by their policy manager, whose entries are compared
+// with the most restrictive precedence.
+var restrictiveKeys = map[string]restrictiveDirection{
+	"dconf/org/gnome/desktop/session/idle-delay":                                   lowestNonZeroWins,
+	"dconf/org/gnome/desktop/screensaver/lock-delay":                               lowestNonZeroWins,
+	"dconf/org/gnome/settings-daemon/plugins/power/sleep-inactive-ac-timeout":      lowestNonZeroWins,
+	"dconf/org/gnome/settings-daemon/plugins/power/sleep-inactive-battery-timeout": lowestNonZeroWins,
+	"dconf/org/gnome/login-screen/allowed-failures":                                lowestWins,
+}
+
+// WithPrecedence specifies the precedence strategy of the entries of each policy manager, by name. Policy managers
+// without strategy use the LSDOU precedence.
+func WithPrecedence(precedence map[string]string) Option {
+	return func(o *options) error {
+		for manager, strategy := range precedence {
+			if strategy != PrecedenceLSDOU && strategy != PrecedenceMostRestrictive {
+				return fmt.Errorf(i18n.G("invalid precedence %q for policy manager %s: must be %s or %s"),
+					strategy, manager, PrecedenceLSDOU, PrecedenceMostRestrictive)
+			}
+		}
+		o.precedence = precedence
+		return nil
+	}
+}
+
+// numericValue returns the value of e as a number, if it has one.
+// Values can be quoted or prefixed with their variant type, like uint32 300.
+func numericValue(e entry.Entry) (float64, bool) {
+	if e.Disabled {
+		return 0, false
+	}
+	fields := strings.Fields(strings.Trim(strings.TrimSpace(e.Value), `'"`))
+	if len(fields) == 0 {
+		return 0, false
+	}
+	v, err := strconv.ParseFloat(fields[len(fields)-1], 64)
+	return v, err == nil
+}
+
+// restrictiveKey returns the direction of the key of entries of type t, if its entries are compared with the most
+// restrictive precedence.
+func restrictiveKey(precedence map[string]string, t, key string) (restrictiveDirection, bool) {
+	if precedence[t] != PrecedenceMostRestrictive {
+		return 0, false
+	}
+	d, ok := restrictiveKeys[filepath.Join(t, key)]
+	return d, ok
+}
+
+// moreRestrictive returns if e wins over current with the most restrictive precedence in direction d: both have a
+// numeric value and the one of e is more restrictive.
+func moreRestrictive(d restrictiveDirection, e, current entry.Entry) bool {
+	v, ok := numericValue(e)
+	if !ok {
+		return false
+	}
+	currentV, ok := numericValue(current)
+	if !ok {
+		return false
+	}
+	if d == lowestNonZeroWins && (v == 0 || currentV == 0) {
+		return v != 0 && currentV == 0
+	}
+	return v < currentV
+}
+
+// mostRestrictiveWinners returns the ID of the GPO whose entry wins, indexed by policy manager and key, for the keys
+// declared in restrictiveKeys of the policy managers with the most restrictive precedence whose numeric values were
+// compared between GPOs of gpos.
+// gpos are ordered from the highest to the lowest LSDOU precedence.
+func mostRestrictiveWinners(gpos []GPO, precedence map[string]string) map[string]string {
+	type winner struct {
+		gpoID    string
+		e        entry.Entry
+		compared bool
+	}
+	winners := make(map[string]*winner)
+	for _, g := range gpos {
+		for t, entries := range g.Rules {
+			for _, e := range entries {
+				d, ok := restrictiveKey(precedence, t, e.Key)
+				if !ok || e.ReportOnly || e.Strategy == entry.StrategyAppend {
+					continue
+				}
+				k := filepath.Join(t, e.Key)
+				w, ok := winners[k]
+				if !ok {
+					winners[k] = &winner{gpoID: g.ID, e: e}
+					continue
+				}
+				_, ok = numericValue(e)
+				_, currentOk := numericValue(w.e)
+				w.compared = w.compared || (ok && currentOk)
+				if moreRestrictive(d, e, w.e) {
+					w.gpoID, w.e = g.ID, e
+				}
+			}
+		}
+	}
+
+	r := make(map[string]string)
+	for k, w := range winners {
+		if w.compared {
+			r[k] = w.gpoID
+		}
+	}
+	return r
+}
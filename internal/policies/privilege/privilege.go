@@ -64,6 +64,26 @@ func NewWithDirs(sudoersDir, policyKitDir string) *Manager {
 	}
 }
 
+// Destinations returns the files managed by the privilege manager.
+func (m *Manager) Destinations() []string {
+	sudoersConf, policyKitConf := m.confPaths()
+	return []string{sudoersConf, policyKitConf}
+}
+
+// confPaths returns the sudo and polkit configuration files written by the manager.
+func (m *Manager) confPaths() (sudoersConf, policyKitConf string) {
+	sudoersDir := m.sudoersDir
+	if sudoersDir == "" {
+		sudoersDir = consts.DefaultSudoersDir
+	}
+	policyKitDir := m.policyKitDir
+	if policyKitDir == "" {
+		policyKitDir = consts.DefaultPolicyKitDir
+	}
+	return filepath.Join(sudoersDir, adsysBaseConfName),
+		filepath.Join(policyKitDir, "localauthority.conf.d", adsysBaseConfName+".conf")
+}
+
 // ApplyPolicy generates a privilege policy based on a list of entries.
 func (m *Manager) ApplyPolicy(ctx context.Context, objectName string, isComputer bool, entries []entry.Entry) (err error) {
 	defer decorate.OnError(&err, i18n.G("can't apply privilege policy to %s"), objectName)
@@ -73,16 +93,11 @@ func (m *Manager) ApplyPolicy(ctx context.Context, objectName string, isComputer
 		return nil
 	}
 
-	sudoersDir := m.sudoersDir
-	if sudoersDir == "" {
-		sudoersDir = consts.DefaultSudoersDir
-	}
 	policyKitDir := m.policyKitDir
 	if policyKitDir == "" {
 		policyKitDir = consts.DefaultPolicyKitDir
 	}
-	sudoersConf := filepath.Join(sudoersDir, adsysBaseConfName)
-	policyKitConf := filepath.Join(policyKitDir, "localauthority.conf.d", adsysBaseConfName+".conf")
+	sudoersConf, policyKitConf := m.confPaths()
 
 	log.Debugf(ctx, "Applying privilege policy to %s", objectName)
 
@@ -204,6 +219,41 @@ func (m *Manager) ApplyPolicy(ctx context.Context, objectName string, isComputer
 	return nil
 }
 
+// Admins returns the sorted users and groups, prefixed with %, granted administrator privileges by entries.
+// The local administrators, members of the admin and sudo groups, are listed unless entries deny them the privileges.
+func Admins(ctx context.Context, entries []entry.Entry) (admins []string) {
+	allowLocalAdmins := true
+	for _, e := range entries {
+		switch e.Key {
+		case "allow-local-admins":
+			allowLocalAdmins = !e.Disabled
+		case "client-admins":
+			if e.Disabled {
+				continue
+			}
+			for _, a := range splitAndNormalizeUsersAndGroups(ctx, e.Value) {
+				admins = append(admins, strings.ToLower(a))
+			}
+		}
+	}
+	if allowLocalAdmins {
+		admins = append(admins, "%admin", "%sudo")
+	}
+	sort.Strings(admins)
+	return admins
+}
+
+// IsAdmin returns true if one of identities, a user name or a group name prefixed with %, is in admins.
+func IsAdmin(admins, identities []string) bool {
+	for _, id := range identities {
+		i := sort.SearchStrings(admins, strings.ToLower(id))
+		if i < len(admins) && admins[i] == strings.ToLower(id) {
+			return true
+		}
+	}
+	return false
+}
+
 // splitAndNormalizeUsersAndGroups allow splitting on lines and ,.
 // We remove any invalid characters and empty elements.
 // All will have the form of user@domain.
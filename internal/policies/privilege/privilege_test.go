@@ -135,6 +135,34 @@ func TestApplyPolicy(t *testing.T) {
 	}
 }
 
+func TestAdmins(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		entries    []entry.Entry
+		identities []string
+
+		wantAdmin bool
+	}{
+		"Local admins are admins by default":        {identities: []string{"alice", "%sudo"}, wantAdmin: true},
+		"Client user admins are admins":             {entries: []entry.Entry{{Key: "client-admins", Value: "domain\\Alice"}}, identities: []string{"alice@domain"}, wantAdmin: true},
+		"Members of client group admins are admins": {entries: []entry.Entry{{Key: "client-admins", Value: "%group@domain.com"}}, identities: []string{"bob@domain.com", "%group@domain.com"}, wantAdmin: true},
+
+		"Local admins are not admins when disallowed": {entries: []entry.Entry{{Key: "allow-local-admins", Disabled: true}}, identities: []string{"alice", "%sudo"}},
+		"Disabled client admins are not admins":       {entries: []entry.Entry{{Key: "client-admins", Value: "alice@domain", Disabled: true}}, identities: []string{"alice@domain"}},
+		"Other users are not admins":                  {entries: []entry.Entry{{Key: "client-admins", Value: "alice@domain"}}, identities: []string{"bob@domain", "%users"}},
+	}
+	for name, tc := range tests {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			admins := privilege.Admins(context.Background(), tc.entries)
+			require.Equal(t, tc.wantAdmin, privilege.IsAdmin(admins, tc.identities), "IsAdmin should return if one of the identities is an admin")
+		})
+	}
+}
+
 func TestMain(m *testing.M) {
 	testutils.InstallUpdateFlag()
 	flag.Parse()
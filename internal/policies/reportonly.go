@@ -0,0 +1,54 @@
+package policies
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"github.com/ubuntu/adsys/internal/i18n"
+	"github.com/ubuntu/adsys/internal/policies/entry"
+)
+
+// reportOnlyCacheBaseName is the cache directory where the changes report-only entries would make on each object, if
+// they were applied, are stored.
+const reportOnlyCacheBaseName = "report-only"
+
+// saveReportOnly records the compliance of objectName with its report-only entries: the changes, which can be empty,
+// that reportedRules would make compared to the enforced rules.
+// A nil reportedRules means that there is no report-only entry, and removes the record.
+func (m *Manager) saveReportOnly(objectName string, rules, reportedRules map[string][]entry.Entry) error {
+	p := m.objectPath(reportOnlyCacheBaseName, objectName)
+	if reportedRules == nil {
+		if err := os.Remove(p); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+		return nil
+	}
+
+	// The directory is only created once there are report-only entries.
+	if err := os.MkdirAll(filepath.Dir(p), 0700); err != nil {
+		return err
+	}
+	if err := os.WriteFile(p+".new", []byte(rulesDiff(rules, reportedRules)), 0600); err != nil {
+		return err
+	}
+	return os.Rename(p+".new", p)
+}
+
+// reportOnlyStatus returns the compliance of objectName with its report-only entries, as recorded on its last policy
+// apply. It is empty if there is no report-only entry.
+func (m *Manager) reportOnlyStatus(objectName string) (string, error) {
+	changes, err := os.ReadFile(m.objectPath(reportOnlyCacheBaseName, objectName))
+	if errors.Is(err, fs.ErrNotExist) {
+		return "", nil
+	} else if err != nil {
+		return "", err
+	}
+
+	if len(changes) == 0 {
+		return i18n.G("Report-only entries: compliant, no change if applied\n"), nil
+	}
+	return fmt.Sprintf(i18n.G("Report-only entries: not compliant, changes if applied:\n%s"), changes), nil
+}
@@ -0,0 +1,213 @@
+package scripts
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/ubuntu/adsys/internal/i18n"
+	"github.com/ubuntu/adsys/internal/policies/entry"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	// allowedInterpretersKey is the machine key listing the interpreters user scripts may use.
+	allowedInterpretersKey = "allowed-interpreters"
+	// allowedPathsKey is the machine key listing the SYSVOL scripts/ subdirectories user scripts may come from.
+	allowedPathsKey = "allowed-paths"
+	// maxCountKey is the machine key limiting the number of scripts of each user.
+	maxCountKey = "user-scripts-max-count"
+	// maxSizeKey is the machine key limiting the total size in KiB of the scripts of each user.
+	maxSizeKey = "user-scripts-max-size"
+	// timeoutKey is the machine key limiting the time in seconds each user script can run.
+	timeoutKey = "user-scripts-timeout"
+
+	// allowlistFile is where the machine allowlist is kept, in the machine run directory, for the next user applies.
+	allowlistFile = "user-scripts-allowlist"
+)
+
+// allowlist restricts the user scripts to the interpreters and source paths set by the machine policy, and limits
+// their number, size and runtime, so that a single user can't monopolize a shared machine.
+// An empty list or a 0 limit doesn't restrict anything.
+type allowlist struct {
+	// Interpreters are interpreter names, like bash, or absolute paths, like /usr/bin/bash.
+	Interpreters []string `yaml:"interpreters,omitempty"`
+	// Paths are directories relative to the SYSVOL scripts/ directory.
+	Paths []string `yaml:"paths,omitempty"`
+	// MaxCount is the maximum number of scripts of a user.
+	MaxCount int `yaml:"maxcount,omitempty"`
+	// MaxSize is the maximum total size in bytes of the scripts of a user.
+	MaxSize int64 `yaml:"maxsize,omitempty"`
+	// Timeout is the maximum time in seconds each script of a user can run.
+	Timeout int `yaml:"timeout,omitempty"`
+}
+
+// isAllowlistKey returns if key configures the allowlist instead of listing scripts.
+func isAllowlistKey(key string) bool {
+	switch filepath.Base(key) {
+	case allowedInterpretersKey, allowedPathsKey, maxCountKey, maxSizeKey, timeoutKey:
+		return true
+	}
+	return false
+}
+
+// newAllowlist returns the allowlist configured by the machine entries.
+func newAllowlist(entries []entry.Entry) (a allowlist, err error) {
+	for _, e := range entries {
+		if e.Disabled {
+			continue
+		}
+		switch filepath.Base(e.Key) {
+		case maxCountKey, maxSizeKey, timeoutKey:
+			n, err := strconv.Atoi(strings.TrimSpace(e.Value))
+			if err != nil || n < 0 {
+				return a, fmt.Errorf(i18n.G("invalid value %q for %s: must be a positive number"), e.Value, filepath.Base(e.Key))
+			}
+			switch filepath.Base(e.Key) {
+			case maxCountKey:
+				a.MaxCount = n
+			case maxSizeKey:
+				a.MaxSize = int64(n) << 10
+			case timeoutKey:
+				a.Timeout = n
+			}
+			continue
+		}
+
+		var values []string
+		for _, v := range strings.Split(e.Value, "\n") {
+			v = strings.TrimSpace(v)
+			if v == "" {
+				continue
+			}
+			values = append(values, v)
+		}
+		switch filepath.Base(e.Key) {
+		case allowedInterpretersKey:
+			a.Interpreters = values
+		case allowedPathsKey:
+			for _, p := range values {
+				a.Paths = append(a.Paths, filepath.Clean(strings.Trim(p, "/")))
+			}
+		}
+	}
+	return a, nil
+}
+
+// save writes the allowlist to path, or removes path if nothing is restricted.
+func (a allowlist) save(path string) error {
+	if len(a.Interpreters) == 0 && len(a.Paths) == 0 && a.MaxCount == 0 && a.MaxSize == 0 && a.Timeout == 0 {
+		if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+		return nil
+	}
+
+	d, err := yaml.Marshal(a)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(path+".new", d, 0600); err != nil {
+		return err
+	}
+	return os.Rename(path+".new", path)
+}
+
+// loadAllowlist returns the allowlist saved in path. A missing file doesn't restrict anything.
+func loadAllowlist(path string) (a allowlist, err error) {
+	d, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return a, nil
+	} else if err != nil {
+		return a, err
+	}
+	if err := yaml.Unmarshal(d, &a); err != nil {
+		return a, err
+	}
+	return a, nil
+}
+
+// check returns an error if script, relative to the SYSVOL scripts/ directory and stored in path, is not in an
+// allowed directory or doesn't use an allowed interpreter.
+func (a allowlist) check(script, path string) error {
+	if len(a.Paths) > 0 {
+		var allowed bool
+		for _, p := range a.Paths {
+			if p == "." || strings.HasPrefix(filepath.Clean(script), p+"/") {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			return fmt.Errorf(i18n.G("not in any allowed path: %s"), strings.Join(a.Paths, ", "))
+		}
+	}
+
+	if len(a.Interpreters) == 0 {
+		return nil
+	}
+	interpreter, err := readInterpreter(path)
+	if err != nil {
+		return err
+	}
+	if interpreter == "" {
+		return errors.New(i18n.G("no interpreter set on its first line"))
+	}
+	for _, i := range a.Interpreters {
+		if i == interpreter || (!strings.Contains(i, "/") && i == filepath.Base(interpreter)) {
+			return nil
+		}
+	}
+	return fmt.Errorf(i18n.G("interpreter %q is not allowed"), interpreter)
+}
+
+// checkQuota returns an error if a script of size can't be added to the count scripts of totalSize already set up
+// for a user.
+func (a allowlist) checkQuota(count int, totalSize, size int64) error {
+	if a.MaxCount > 0 && count >= a.MaxCount {
+		return fmt.Errorf(i18n.G("the maximum number of scripts per user, %d, is reached"), a.MaxCount)
+	}
+	if a.MaxSize > 0 && totalSize+size > a.MaxSize {
+		return fmt.Errorf(i18n.G("the maximum size of scripts per user, %d KiB, is reached"), a.MaxSize>>10)
+	}
+	return nil
+}
+
+// readInterpreter returns the interpreter of the shebang line of path, or an empty string if there is none.
+// For scripts started with env, it is the name of the command env looks up.
+func readInterpreter(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	line, err := bufio.NewReader(io.LimitReader(f, 256)).ReadString('\n')
+	if err != nil && !errors.Is(err, io.EOF) {
+		return "", err
+	}
+	if !strings.HasPrefix(line, "#!") {
+		return "", nil
+	}
+	fields := strings.Fields(line[2:])
+	if len(fields) == 0 {
+		return "", nil
+	}
+	if filepath.Base(fields[0]) != "env" {
+		return fields[0], nil
+	}
+	// Skip env options and variables.
+	for _, f := range fields[1:] {
+		if strings.HasPrefix(f, "-") || strings.Contains(f, "=") {
+			continue
+		}
+		return f, nil
+	}
+	return "", nil
+}
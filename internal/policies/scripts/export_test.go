@@ -6,6 +6,7 @@ import (
 
 const (
 	InSessionFlag = inSessionFlag
+	TimeoutSuffix = timeoutSuffix
 )
 
 // WithUserLookup allows to mock system user lookup.
@@ -14,3 +15,10 @@ func WithUserLookup(userLookup func(string) (*user.User, error)) Option {
 		o.userLookup = userLookup
 	}
 }
+
+// WithUserCacheDir allows to mock the cache directory of the user running the scripts.
+func WithUserCacheDir(p string) RunOption {
+	return func(o *runOptions) {
+		o.userCacheDir = func() (string, error) { return p, nil }
+	}
+}
@@ -2,11 +2,18 @@
 //
 // This manager configures the scripts that will be executed in the following steps:
 //   - machine: startup and shutdown;
-//   - user: login and logout;
+//   - user: first login on the machine, login and logout;
 //
 // The manager will download the requested assets and set up the scripts to be executed during the
 // mentioned steps. The machine scripts will be executed by ADSys itself and the user ones will be
 // handled by systemd through user units.
+// First login scripts provision the user environment on the machine, like initial bookmarks or template files
+// copied into their home directory. They are run before the login ones, and only once per user and machine: each
+// script successfully executed is recorded in the user cache directory and is skipped on next logins. A failing
+// script is run again on next login.
+// The machine policy can restrict the user scripts to some interpreters, read from their shebang line, and to some
+// directories of SYSVOL scripts/, and limit their number, total size and runtime to protect shared machines. Scripts
+// not allowed or over the limits are skipped with a warning and never executed.
 // If the manager fail to download and find the required assets, the applying process will fail and
 // authentication will be prevented. ADSys ensures that the scripts will be executed at the correct
 // time and in the correct order, but it does not account for the correctness of the scripts.
@@ -18,12 +25,14 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"io"
 	"os"
 	"os/exec"
 	"os/user"
 	"path/filepath"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/ubuntu/adsys/internal/consts"
 	log "github.com/ubuntu/adsys/internal/grpc/logstreamer"
@@ -36,6 +45,14 @@ const (
 	inSessionFlag = ".running"
 	readyFlag     = ".ready"
 	executableDir = "scripts"
+
+	// timeoutSuffix is appended to the object directory for the file holding the time in seconds each of its scripts
+	// can run, if limited. It is kept outside of the object directory, owned by the user, so that only root can
+	// change it.
+	timeoutSuffix = ".scripts-timeout"
+
+	// firstLogonOrder is the order file of the scripts which are only run once per user and machine.
+	firstLogonOrder = "first-logon"
 )
 
 // Manager prevents running multiple scripts update process in parallel while parsing policy in ApplyPolicy.
@@ -48,6 +65,7 @@ type Manager struct {
 
 type unitStarter interface {
 	StartUnit(context.Context, string) error
+	StartTransientScope(context.Context, string, []uint32, time.Duration) error
 }
 
 type options struct {
@@ -114,6 +132,35 @@ func (m *Manager) ApplyPolicy(ctx context.Context, objectName string, isComputer
 		objectDir = filepath.Join("users", user.Uid)
 	}
 
+	// The allowlist is a machine policy, restricting the user scripts applied afterwards.
+	allowlistPath := filepath.Join(m.runDir, "machine", allowlistFile)
+	var scriptsEntries, allowlistEntries []entry.Entry
+	for _, e := range entries {
+		if isAllowlistKey(e.Key) {
+			allowlistEntries = append(allowlistEntries, e)
+			continue
+		}
+		scriptsEntries = append(scriptsEntries, e)
+	}
+	entries = scriptsEntries
+	var allowed allowlist
+	if isComputer {
+		a, err := newAllowlist(allowlistEntries)
+		if err != nil {
+			return err
+		}
+		if err := a.save(allowlistPath); err != nil {
+			return fmt.Errorf(i18n.G("can't save user scripts allowlist: %v"), err)
+		}
+	} else {
+		if len(allowlistEntries) > 0 {
+			log.Warningf(ctx, i18n.G("Ignoring user scripts allowlist set for %s: it can only be set for the machine"), objectName)
+		}
+		if allowed, err = loadAllowlist(allowlistPath); err != nil {
+			return fmt.Errorf(i18n.G("can't load user scripts allowlist: %v"), err)
+		}
+	}
+
 	objectPath := filepath.Join(m.runDir, objectDir)
 	scriptsPath := filepath.Join(objectPath, executableDir)
 
@@ -129,6 +176,9 @@ func (m *Manager) ApplyPolicy(ctx context.Context, objectName string, isComputer
 	if err := os.RemoveAll(scriptsPath); err != nil {
 		return err
 	}
+	if err := os.Remove(objectPath + timeoutSuffix); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
 
 	if len(entries) == 0 {
 		return nil
@@ -152,6 +202,8 @@ func (m *Manager) ApplyPolicy(ctx context.Context, objectName string, isComputer
 	// create order files, check that the scripts existings in the destination
 	log.Debugf(ctx, "Creating script order file for user %q", objectName)
 	orderFilesContent := make(map[string][]string)
+	var count int
+	var totalSize int64
 	for _, e := range entries {
 		lifecycle := filepath.Base(e.Key)
 		for _, script := range strings.Split(e.Value, "\n") {
@@ -170,6 +222,16 @@ func (m *Manager) ApplyPolicy(ctx context.Context, objectName string, isComputer
 			if info.IsDir() {
 				return fmt.Errorf(i18n.G("script %q is a directory and not a file to execute"), script)
 			}
+			if err := allowed.check(script, scriptFilePath); err != nil {
+				log.Warningf(ctx, i18n.G("Skipping script %q for %s: %v"), script, objectName, err)
+				continue
+			}
+			if err := allowed.checkQuota(count, totalSize, info.Size()); err != nil {
+				log.Warningf(ctx, i18n.G("Skipping script %q for %s: %v"), script, objectName, err)
+				continue
+			}
+			count++
+			totalSize += info.Size()
 			// nolint:gosec // G302 - scripts need rx permissions
 			if err := os.Chmod(scriptFilePath, 0550); err != nil {
 				return fmt.Errorf(i18n.G("can't change mode of script %qto %o: %v"), scriptFilePath, 0550, err)
@@ -204,6 +266,13 @@ func (m *Manager) ApplyPolicy(ctx context.Context, objectName string, isComputer
 		}
 	}
 
+	if allowed.Timeout > 0 {
+		// #nosec G306 - the user reads it to request the runtime limit of its scripts from the daemon.
+		if err := os.WriteFile(objectPath+timeoutSuffix, []byte(strconv.Itoa(allowed.Timeout)), 0644); err != nil {
+			return err
+		}
+	}
+
 	// Create ready flag
 	if err := createFlagFile(ctx, filepath.Join(scriptsPath, readyFlag), uid, gid); err != nil {
 		return err
@@ -222,11 +291,36 @@ func (m *Manager) ApplyPolicy(ctx context.Context, objectName string, isComputer
 	return m.unitStarter.StartUnit(ctx, consts.AdysMachineScriptsServiceName)
 }
 
+type runOptions struct {
+	userCacheDir func() (string, error)
+	limitRuntime func(pid int) error
+}
+
+// RunOption reprents an optional function to change how scripts are run.
+type RunOption func(*runOptions)
+
+// WithRuntimeLimiter confines each script process, before it starts, to the runtime allowed for the scripts.
+func WithRuntimeLimiter(limitRuntime func(pid int) error) RunOption {
+	return func(o *runOptions) {
+		o.limitRuntime = limitRuntime
+	}
+}
+
 // RunScripts executes all scripts in directory if ready and not already executed.
 // allowOrderMissing will not require order to exists if we are ready to execute.
-func RunScripts(ctx context.Context, order string, allowOrderMissing bool) (err error) {
+// First login scripts already run for the current user on this machine are skipped.
+func RunScripts(ctx context.Context, order string, allowOrderMissing bool, opts ...RunOption) (err error) {
 	defer decorate.OnError(&err, i18n.G("can't run scripts listed in %s"), order)
 
+	// defaults
+	args := runOptions{
+		userCacheDir: os.UserCacheDir,
+	}
+	// applied options
+	for _, o := range opts {
+		o(&args)
+	}
+
 	log.Infof(ctx, "Calling RunScripts on %q", order)
 
 	baseDir := filepath.Dir(order)
@@ -276,28 +370,196 @@ func RunScripts(ctx context.Context, order string, allowOrderMissing bool) (err
 		return fmt.Errorf(i18n.G("%q is a directory and not a file"), order)
 	}
 
+	timeout, err := readTimeout(filepath.Dir(baseDir))
+	if err != nil {
+		return err
+	}
+	var limitRuntime func(pid int) error
+	if timeout > 0 {
+		if args.limitRuntime == nil {
+			return fmt.Errorf(i18n.G("scripts can only run for %s, but nothing can limit their runtime"), timeout)
+		}
+		limitRuntime = args.limitRuntime
+	}
+
+	var provisioned *provisionedScripts
+	if filepath.Base(order) == firstLogonOrder {
+		if provisioned, err = loadProvisioned(args.userCacheDir); err != nil {
+			return err
+		}
+	}
+
 	scanner := bufio.NewScanner(f)
 	for scanner.Scan() {
 		scriptPath := strings.TrimSpace(scanner.Text())
 		if scriptPath == "" {
 			continue
 		}
+		if provisioned.has(scriptPath) {
+			log.Debugf(ctx, "%q was already run on first login, skipping", scriptPath)
+			continue
+		}
 		script := filepath.Join(baseDir, scriptPath)
 		log.Debugf(ctx, "Running script %q", script)
-		// #nosec G204 - this variable is coming from concatenation of an order file.
-		// Permissions are restricted to the owner of the order file, which is the one executing
-		// this script.
-		cmd := exec.CommandContext(ctx, script)
-		cmd.Stdout = os.Stdout
-		cmd.Stderr = os.Stderr
-		if err := cmd.Run(); err != nil {
+		start := time.Now()
+		if err := runScript(ctx, script, limitRuntime); err != nil {
+			if timeout > 0 && time.Since(start) >= timeout {
+				err = fmt.Errorf(i18n.G("stopped after running for more than %s: %v"), timeout, err)
+			}
 			log.Warningf(ctx, "%q failed to run\n%v", script, err)
+			continue
+		}
+		if err := provisioned.add(scriptPath); err != nil {
+			return err
 		}
 	}
 
 	return nil
 }
 
+// runScript runs script. With limitRuntime, the script waits for being confined before starting, so that neither
+// it nor the processes it starts can escape its runtime limit.
+func runScript(ctx context.Context, script string, limitRuntime func(pid int) error) error {
+	// #nosec G204 - this variable is coming from concatenation of an order file.
+	// Permissions are restricted to the owner of the order file, which is the one executing
+	// this script.
+	cmd := exec.CommandContext(ctx, script)
+	var release io.WriteCloser
+	if limitRuntime != nil {
+		// #nosec G204 - the script is passed as an argument to the shell, not interpreted by it.
+		cmd = exec.CommandContext(ctx, "/bin/sh", "-c", `read -r _ && exec "$0"`, script)
+		var err error
+		if release, err = cmd.StdinPipe(); err != nil {
+			return err
+		}
+	}
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	if limitRuntime != nil {
+		if err := limitRuntime(cmd.Process.Pid); err != nil {
+			_ = cmd.Process.Kill()
+			_ = cmd.Wait()
+			return fmt.Errorf(i18n.G("can't limit the script runtime: %v"), err)
+		}
+		if _, err := fmt.Fprintln(release); err != nil {
+			_ = cmd.Process.Kill()
+			_ = cmd.Wait()
+			return err
+		}
+		if err := release.Close(); err != nil {
+			return err
+		}
+	}
+	return cmd.Wait()
+}
+
+// readTimeout returns the time each script of objectPath can run, or 0 if it is not limited.
+func readTimeout(objectPath string) (time.Duration, error) {
+	d, err := os.ReadFile(objectPath + timeoutSuffix)
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, nil
+	} else if err != nil {
+		return 0, err
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(string(d)))
+	if err != nil {
+		return 0, fmt.Errorf(i18n.G("invalid scripts timeout %q: %v"), d, err)
+	}
+	return time.Duration(n) * time.Second, nil
+}
+
+// LimitUserScript confines the script process pid of the user uid to a transient scope, which stops it, with the
+// processes it starts, once it runs for more than the time allowed for the user scripts, if limited.
+func (m *Manager) LimitUserScript(ctx context.Context, uid, pid uint32) (err error) {
+	defer decorate.OnError(&err, i18n.G("can't limit the runtime of script process %d"), pid)
+
+	timeout, err := readTimeout(filepath.Join(m.runDir, "users", strconv.FormatUint(uint64(uid), 10)))
+	if err != nil {
+		return err
+	}
+	if timeout == 0 {
+		return nil
+	}
+
+	log.Debugf(ctx, "Limiting script process %d of user %d to %s", pid, uid, timeout)
+	return m.unitStarter.StartTransientScope(ctx, fmt.Sprintf("adsys-user-script-%d-%d.scope", uid, pid), []uint32{pid}, timeout)
+}
+
+// provisionedScripts is the record of the first login scripts already run for the current user on this machine.
+// A nil record tracks nothing.
+type provisionedScripts struct {
+	path    string
+	scripts map[string]struct{}
+}
+
+// loadProvisioned loads the record of the first login scripts of the current user from its cache directory.
+// Each machine has its own record, as home directories can be shared between machines.
+func loadProvisioned(userCacheDir func() (string, error)) (p *provisionedScripts, err error) {
+	defer decorate.OnError(&err, i18n.G("can't load first login scripts already run"))
+
+	cacheDir, err := userCacheDir()
+	if err != nil {
+		return nil, err
+	}
+	hostname, err := os.Hostname()
+	if err != nil {
+		return nil, err
+	}
+
+	p = &provisionedScripts{
+		path:    filepath.Join(cacheDir, "adsys", firstLogonOrder, hostname),
+		scripts: make(map[string]struct{}),
+	}
+	// #nosec G304 - the record is in the cache directory of the user running the scripts.
+	d, err := os.ReadFile(p.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return p, nil
+	} else if err != nil {
+		return nil, err
+	}
+	for _, s := range strings.Split(string(d), "\n") {
+		if s = strings.TrimSpace(s); s != "" {
+			p.scripts[s] = struct{}{}
+		}
+	}
+	return p, nil
+}
+
+// has returns if script was already run.
+func (p *provisionedScripts) has(script string) bool {
+	if p == nil {
+		return false
+	}
+	_, ok := p.scripts[script]
+	return ok
+}
+
+// add records that script was run, so that it is skipped on next logins.
+func (p *provisionedScripts) add(script string) (err error) {
+	if p == nil {
+		return nil
+	}
+	defer decorate.OnError(&err, i18n.G("can't record that %q was run"), script)
+
+	if err := os.MkdirAll(filepath.Dir(p.path), 0700); err != nil {
+		return err
+	}
+	// #nosec G304 - the record is in the cache directory of the user running the scripts.
+	f, err := os.OpenFile(p.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if _, err := f.WriteString(script + "\n"); err != nil {
+		return err
+	}
+	p.scripts[script] = struct{}{}
+	return f.Close()
+}
+
 func mkdirAllWithUIDGid(p string, uid, gid int) error {
 	if err := os.MkdirAll(p, 0750); err != nil {
 		return fmt.Errorf(i18n.G("can't create scripts directory %q: %v"), p, err)
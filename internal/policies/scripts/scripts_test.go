@@ -4,11 +4,17 @@ import (
 	"context"
 	"errors"
 	"flag"
+	"fmt"
 	"io/fs"
 	"os"
+	"os/exec"
 	"os/user"
 	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/require"
 	"github.com/termie/go-shutil"
@@ -64,8 +70,9 @@ func TestApplyPolicy(t *testing.T) {
 	defaultSingleScript := []entry.Entry{{Key: "s", Value: "script1.sh"}}
 
 	tests := map[string]struct {
-		entries  []entry.Entry
-		computer bool
+		entries        []entry.Entry
+		computer       bool
+		machineEntries []entry.Entry
 
 		saveAssetsError     bool
 		userReturnedUID     string
@@ -92,6 +99,42 @@ func TestApplyPolicy(t *testing.T) {
 		"Computer, no systemctl with other directory than startup":       {computer: true, systemctlShouldFail: true, entries: defaultSingleScript},
 		"Startup script for computer runs systemctl (systemctl success)": {computer: true, systemctlShouldFail: false, entries: []entry.Entry{{Key: "startup", Value: "script1.sh"}}},
 
+		// Allowlist cases
+		"Allowlist restricts user scripts to interpreters": {
+			machineEntries: []entry.Entry{{Key: "allowed-interpreters", Value: "bash\npython3"}},
+			entries:        []entry.Entry{{Key: "s", Value: "interpreters/bash.sh\ninterpreters/env-python.py\ninterpreters/perl.pl\ninterpreters/no-shebang"}}},
+		"Allowlist restricts user scripts to interpreter paths": {
+			machineEntries: []entry.Entry{{Key: "allowed-interpreters", Value: "/bin/bash\n/usr/bin/python3"}},
+			entries:        []entry.Entry{{Key: "s", Value: "interpreters/bash.sh\ninterpreters/env-python.py\ninterpreters/perl.pl"}}},
+		"Allowlist restricts user scripts to paths": {
+			machineEntries: []entry.Entry{{Key: "allowed-paths", Value: "/subfolder/\ninterpreters"}},
+			entries:        []entry.Entry{{Key: "s", Value: "script1.sh\nsubfolder/script1.sh\ninterpreters/perl.pl"}}},
+		"Allowlist restricts user scripts to paths and interpreters": {
+			machineEntries: []entry.Entry{{Key: "allowed-paths", Value: "interpreters"}, {Key: "allowed-interpreters", Value: "perl"}},
+			entries:        []entry.Entry{{Key: "s", Value: "script1.sh\ninterpreters/bash.sh\ninterpreters/perl.pl"}}},
+		"Disabled allowlist restricts nothing": {
+			machineEntries: []entry.Entry{{Key: "allowed-paths", Value: "subfolder", Disabled: true}},
+			entries:        []entry.Entry{{Key: "s", Value: "script1.sh\nsubfolder/script1.sh"}}},
+		"Allowlist set for the user is ignored": {
+			entries: []entry.Entry{{Key: "allowed-paths", Value: "subfolder"}, {Key: "s", Value: "script1.sh"}}},
+		"Allowlist does not restrict machine scripts": {
+			computer: true,
+			entries:  []entry.Entry{{Key: "allowed-paths", Value: "subfolder"}, {Key: "startup", Value: "script1.sh"}}},
+
+		// Limits cases
+		"Limits restrict the number of user scripts": {
+			machineEntries: []entry.Entry{{Key: "user-scripts-max-count", Value: "2"}},
+			entries:        []entry.Entry{{Key: "s", Value: "script3.sh\nscript1.sh\nscript2.sh"}}},
+		"Limits restrict the size of user scripts": {
+			machineEntries: []entry.Entry{{Key: "user-scripts-max-size", Value: "1"}},
+			entries:        []entry.Entry{{Key: "s", Value: "script3.sh\nlarge.sh\nscript1.sh"}}},
+		"Limits restrict the runtime of user scripts": {
+			machineEntries: []entry.Entry{{Key: "user-scripts-timeout", Value: "60"}},
+			entries:        defaultSingleScript},
+		"Limits do not restrict machine scripts": {
+			computer: true,
+			entries:  []entry.Entry{{Key: "user-scripts-max-count", Value: "1"}, {Key: "user-scripts-timeout", Value: "60"}, {Key: "startup", Value: "script1.sh\nscript2.sh"}}},
+
 		// Destination already exists. Using computer to be uid independent
 		"Destination is already running, no change":                   {destAlreadyExists: "already running", computer: true, entries: defaultSingleScript},
 		"Destination is already ready but not in session, refreshing": {destAlreadyExists: "already ready", computer: true, entries: defaultSingleScript},
@@ -107,6 +150,8 @@ func TestApplyPolicy(t *testing.T) {
 		"Error on script does not exist":         {entries: []entry.Entry{{Key: "s", Value: "doestnotexists"}}, wantErr: true},
 		"Error on users run directory Read Only": {makeReadOnly: true, entries: defaultSingleScript, wantErr: true},
 		"Error on save assets dumping failing":   {entries: defaultSingleScript, saveAssetsError: true, wantErr: true},
+		"Error on invalid user scripts limit":    {computer: true, entries: []entry.Entry{{Key: "user-scripts-max-count", Value: "many"}}, wantErr: true},
+		"Error on negative user scripts limit":   {computer: true, entries: []entry.Entry{{Key: "user-scripts-timeout", Value: "-1"}}, wantErr: true},
 
 		// User error cases only
 		"Error on invalid UID":         {userReturnedUID: "invalid", entries: defaultSingleScript, wantErr: true},
@@ -160,6 +205,11 @@ func TestApplyPolicy(t *testing.T) {
 				testutils.MakeReadOnly(t, filepath.Join(runDir, "users"))
 			}
 
+			if tc.machineEntries != nil {
+				err = m.ApplyPolicy(context.Background(), "ubuntu", true, tc.machineEntries, mockAssetsDumper.SaveAssetsTo)
+				require.NoError(t, err, "Setup: ApplyPolicy for the machine failed but shouldn't have")
+			}
+
 			err = m.ApplyPolicy(context.Background(), "ubuntu", tc.computer, tc.entries, mockAssetsDumper.SaveAssetsTo)
 			if tc.wantErr {
 				require.NotNil(t, err, "ApplyPolicy should have failed but didn't")
@@ -174,7 +224,8 @@ func TestApplyPolicy(t *testing.T) {
 	}
 }
 
-// makeIndependentOfCurrentUID renames any file or directory which exactly match uid in path and replace it with 4242.
+// makeIndependentOfCurrentUID renames any file or directory which exactly match uid in path, or uid with a suffix
+// starting with a dot, and replace uid with 4242.
 func makeIndependentOfCurrentUID(t *testing.T, path string, uid string) {
 	t.Helper()
 
@@ -185,7 +236,7 @@ func makeIndependentOfCurrentUID(t *testing.T, path string, uid string) {
 		if err != nil {
 			return err
 		}
-		if filepath.Base(path) != uid {
+		if filepath.Base(path) != uid && !strings.HasPrefix(filepath.Base(path), uid+".") {
 			return nil
 		}
 		toRename = append([]string{path}, toRename...)
@@ -194,7 +245,7 @@ func makeIndependentOfCurrentUID(t *testing.T, path string, uid string) {
 	require.NoError(t, err, "Setup: failed walk in generated directory")
 
 	for _, path := range toRename {
-		err := os.Rename(path, filepath.Join(filepath.Dir(path), "4242"))
+		err := os.Rename(path, filepath.Join(filepath.Dir(path), "4242"+strings.TrimPrefix(filepath.Base(path), uid)))
 		require.NoError(t, err, "Setup: failed to generated path independent of current Uid")
 	}
 }
@@ -202,12 +253,21 @@ func makeIndependentOfCurrentUID(t *testing.T, path string, uid string) {
 func TestRunScripts(t *testing.T) {
 	t.Parallel()
 
+	hostname, err := os.Hostname()
+	require.NoError(t, err, "Setup: failed to get hostname")
+
 	tests := map[string]struct {
 		stageDir          string
 		allowOrderMissing bool
 		scriptObjectName  string
+		provisioned       string
+		timeout           string
+		limitFails        bool
+		noRuntimeLimiter  bool
 
 		wantSessionFlagFileRemoved bool
+		wantProvisioned            string
+		wantLimited                int
 		wantErr                    bool
 	}{
 		"one script":                                  {},
@@ -228,13 +288,25 @@ func TestRunScripts(t *testing.T) {
 		"script directory without shutdown order has no session running flag after machine shutdown": {stageDir: "shutdown", scriptObjectName: "machine", wantSessionFlagFileRemoved: true, allowOrderMissing: true},
 		"keeps running flag after non machine shutdown":                                              {stageDir: "shutdown", scriptObjectName: "users", wantSessionFlagFileRemoved: false},
 
+		// first logon cases
+		"first logon scripts are recorded once run":               {stageDir: "first-logon", wantProvisioned: "scripts/script1.sh\nscripts/script2.sh\n"},
+		"first logon scripts already run are skipped":             {stageDir: "first-logon", provisioned: "scripts/script1.sh\n", wantProvisioned: "scripts/script1.sh\nscripts/script2.sh\n"},
+		"failing first logon scripts are run again on next login": {stageDir: "first-logon", wantProvisioned: "scripts/script2.sh\n"},
+
 		"allow order file missing":           {allowOrderMissing: true},
 		"spaces and empty lines are skipped": {},
 
+		// runtime limit cases
+		"limited scripts are run once confined":                   {timeout: "60", wantLimited: 3},
+		"scripts running for too long are stopped":                {timeout: "1", wantLimited: 2},
+		"scripts are skipped when their runtime can't be limited": {timeout: "60", limitFails: true},
+
 		// Error cases
-		"error on order file not existing": {wantErr: true},
-		"error on not ready for execution": {wantErr: true},
-		"error on argument not a file":     {wantErr: true},
+		"error on order file not existing":                 {wantErr: true},
+		"error on not ready for execution":                 {wantErr: true},
+		"error on argument not a file":                     {wantErr: true},
+		"error on invalid scripts timeout":                 {timeout: "many", wantErr: true},
+		"error on limited scripts without runtime limiter": {timeout: "60", noRuntimeLimiter: true, wantErr: true},
 	}
 
 	for name, tc := range tests {
@@ -265,7 +337,40 @@ func TestRunScripts(t *testing.T) {
 					"Setup: can't create script dir")
 			}
 
-			err := scripts.RunScripts(context.Background(), scriptDir, tc.allowOrderMissing)
+			userCacheDir := t.TempDir()
+			provisionedPath := filepath.Join(userCacheDir, "adsys", "first-logon", hostname)
+			if tc.provisioned != "" {
+				require.NoError(t, os.MkdirAll(filepath.Dir(provisionedPath), 0700), "Setup: can't create first logon record directory")
+				require.NoError(t, os.WriteFile(provisionedPath, []byte(tc.provisioned), 0600), "Setup: can't write first logon record")
+			}
+
+			if tc.timeout != "" {
+				require.NoError(t, os.MkdirAll(scriptRootParentDir, 0700), "Setup: can't create user dir")
+				require.NoError(t, os.WriteFile(scriptRootParentDir+scripts.TimeoutSuffix, []byte(tc.timeout), 0600), "Setup: can't write scripts timeout")
+			}
+
+			// The runtime limiter stops the script once it runs for more than the timeout, like its systemd scope.
+			var limited int
+			limiter := func(pid int) error {
+				if tc.limitFails {
+					return errors.New("can't limit runtime")
+				}
+				limited++
+				timeout, err := strconv.Atoi(tc.timeout)
+				require.NoError(t, err, "Setup: invalid timeout")
+				timer := time.AfterFunc(time.Duration(timeout)*time.Second, func() {
+					_ = exec.Command("pkill", "-KILL", "-P", strconv.Itoa(pid)).Run()
+					_ = syscall.Kill(pid, syscall.SIGKILL)
+				})
+				t.Cleanup(func() { timer.Stop() })
+				return nil
+			}
+			opts := []scripts.RunOption{scripts.WithUserCacheDir(userCacheDir)}
+			if !tc.noRuntimeLimiter {
+				opts = append(opts, scripts.WithRuntimeLimiter(limiter))
+			}
+
+			err := scripts.RunScripts(context.Background(), scriptDir, tc.allowOrderMissing, opts...)
 			if tc.wantErr {
 				require.NotNil(t, err, "RunScripts should have failed but didn't")
 				_, err = os.Stat(filepath.Dir(scriptDir))
@@ -281,6 +386,16 @@ func TestRunScripts(t *testing.T) {
 				require.Nil(t, err, "RunScripts should have added in session flag file but didn’t")
 			}
 
+			require.Equal(t, tc.wantLimited, limited, "RunScripts should have confined each script with a runtime limit")
+
+			if tc.wantProvisioned != "" {
+				got, err := os.ReadFile(provisionedPath)
+				require.NoError(t, err, "RunScripts should have recorded the first logon scripts run")
+				require.Equal(t, tc.wantProvisioned, string(got), "RunScripts should have recorded the successful first logon scripts")
+			} else {
+				require.NoFileExists(t, provisionedPath, "RunScripts should not record scripts outside of first logon")
+			}
+
 			// Get and compare oracle file to check order
 			src := filepath.Join(scriptRootParentDir, "golden")
 			testutils.CompareTreesWithFiltering(t, src, testutils.GoldenPath(t), testutils.Update())
@@ -288,10 +403,54 @@ func TestRunScripts(t *testing.T) {
 	}
 }
 
+func TestLimitUserScript(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		timeout          string
+		startScopeFailed bool
+
+		wantScope string
+		wantErr   bool
+	}{
+		"Confine script to a scope stopping it after the timeout": {timeout: "60", wantScope: "adsys-user-script-4242-42.scope 1m0s"},
+		"No scope without timeout":                                {},
+
+		// Error cases
+		"Error on invalid timeout":        {timeout: "many", wantErr: true},
+		"Error on scope failing to start": {timeout: "60", startScopeFailed: true, wantErr: true},
+	}
+	for name, tc := range tests {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			runDir := t.TempDir()
+			unitStarter := &mockUnitStarter{StartScopeFailed: tc.startScopeFailed}
+			m, err := scripts.New(runDir, unitStarter)
+			require.NoError(t, err, "Setup: can't create scripts manager")
+			if tc.timeout != "" {
+				require.NoError(t, os.WriteFile(filepath.Join(runDir, "users", "4242"+scripts.TimeoutSuffix), []byte(tc.timeout), 0600), "Setup: can't write scripts timeout")
+			}
+
+			err = m.LimitUserScript(context.Background(), 4242, 42)
+			if tc.wantErr {
+				require.Error(t, err, "LimitUserScript should have failed but didn't")
+				return
+			}
+			require.NoError(t, err, "LimitUserScript failed but shouldn't have")
+			require.Equal(t, tc.wantScope, unitStarter.scope, "LimitUserScript should have confined the script to the expected scope")
+		})
+	}
+}
+
 type mockUnitStarter struct {
 	testutils.MockSystemdCaller
 
-	StartFailed bool
+	StartFailed      bool
+	StartScopeFailed bool
+
+	scope string
 }
 
 func (s mockUnitStarter) StartUnit(_ context.Context, _ string) error {
@@ -301,6 +460,17 @@ func (s mockUnitStarter) StartUnit(_ context.Context, _ string) error {
 	return nil
 }
 
+func (s *mockUnitStarter) StartTransientScope(_ context.Context, unit string, pids []uint32, runtimeMax time.Duration) error {
+	if s.StartScopeFailed {
+		return errors.New("failed to start scope")
+	}
+	if len(pids) != 1 || pids[0] != 42 {
+		return fmt.Errorf("unexpected pids in scope: %v", pids)
+	}
+	s.scope = fmt.Sprintf("%s %s", unit, runtimeMax)
+	return nil
+}
+
 func TestMain(m *testing.M) {
 	testutils.InstallUpdateFlag()
 	flag.Parse()
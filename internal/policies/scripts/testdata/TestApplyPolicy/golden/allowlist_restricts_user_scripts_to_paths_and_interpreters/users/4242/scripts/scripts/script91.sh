@@ -0,0 +1 @@
+script 91
\ No newline at end of file
@@ -0,0 +1 @@
+script 92
\ No newline at end of file
@@ -0,0 +1 @@
+script 1
\ No newline at end of file
@@ -0,0 +1,21 @@
+#!/bin/sh
+# This line makes the script larger than the limit.
+# This line makes the script larger than the limit.
+# This line makes the script larger than the limit.
+# This line makes the script larger than the limit.
+# This line makes the script larger than the limit.
+# This line makes the script larger than the limit.
+# This line makes the script larger than the limit.
+# This line makes the script larger than the limit.
+# This line makes the script larger than the limit.
+# This line makes the script larger than the limit.
+# This line makes the script larger than the limit.
+# This line makes the script larger than the limit.
+# This line makes the script larger than the limit.
+# This line makes the script larger than the limit.
+# This line makes the script larger than the limit.
+# This line makes the script larger than the limit.
+# This line makes the script larger than the limit.
+# This line makes the script larger than the limit.
+# This line makes the script larger than the limit.
+# This line makes the 
\ No newline at end of file
@@ -0,0 +1,85 @@
+package secrets
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/ubuntu/adsys/internal/i18n"
+	"golang.org/x/exp/slices"
+)
+
+// attributeRe matches the name of an Active Directory attribute.
+var attributeRe = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9-]*$`)
+
+// EscrowFunc writes value to attribute of the computer object of the machine in the directory.
+type EscrowFunc func(ctx context.Context, attribute, value string) error
+
+// ReadEscrowedFunc returns the value of attribute of the computer object of the machine in the directory, or an empty
+// string if it is not set.
+type ReadEscrowedFunc func(ctx context.Context, attribute string) (string, error)
+
+// Escrow stores the values generated on the machine, like passwords, in attributes of its computer object in the
+// directory before they are used, and reads them back.
+// Only the allowed attributes can be accessed, so that the policies can't have the machine overwrite other attributes
+// of its computer object, like the ones of another escrowed value.
+type Escrow struct {
+	write   EscrowFunc
+	read    ReadEscrowedFunc
+	allowed []string
+}
+
+// NewEscrow returns an Escrow accessing the attributes of allowed with write and read. Any of them can be nil if the
+// values can't be written or read back.
+func NewEscrow(write EscrowFunc, read ReadEscrowedFunc, allowed []string) Escrow {
+	e := Escrow{write: write, read: read}
+	for _, a := range allowed {
+		e.allowed = append(e.allowed, strings.ToLower(a))
+	}
+	return e
+}
+
+// CanWrite returns true if values can be written to the directory.
+func (e Escrow) CanWrite() bool {
+	return e.write != nil
+}
+
+// CanRead returns true if values can be read back from the directory.
+func (e Escrow) CanRead() bool {
+	return e.read != nil
+}
+
+// Write writes value to attribute of the computer object of the machine.
+func (e Escrow) Write(ctx context.Context, attribute, value string) error {
+	if err := e.check(attribute); err != nil {
+		return err
+	}
+	if e.write == nil {
+		return errors.New(i18n.G("values can't be escrowed to the directory"))
+	}
+	return e.write(ctx, attribute, value)
+}
+
+// Read returns the value of attribute of the computer object of the machine, or an empty string if it is not set.
+func (e Escrow) Read(ctx context.Context, attribute string) (string, error) {
+	if err := e.check(attribute); err != nil {
+		return "", err
+	}
+	if e.read == nil {
+		return "", errors.New(i18n.G("escrowed values can't be read from the directory"))
+	}
+	return e.read(ctx, attribute)
+}
+
+// check returns an error if attribute is not a valid and allowed attribute name.
+func (e Escrow) check(attribute string) error {
+	if !attributeRe.MatchString(attribute) {
+		return fmt.Errorf(i18n.G("invalid escrow attribute %q"), attribute)
+	}
+	if !slices.Contains(e.allowed, strings.ToLower(attribute)) {
+		return fmt.Errorf(i18n.G("escrow attribute %q is not allowed by the local configuration"), attribute)
+	}
+	return nil
+}
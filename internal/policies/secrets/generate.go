@@ -0,0 +1,178 @@
+package secrets
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io/fs"
+	"math/big"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"time"
+
+	log "github.com/ubuntu/adsys/internal/grpc/logstreamer"
+	"github.com/ubuntu/adsys/internal/i18n"
+)
+
+const (
+	defaultPasswordLength = 20
+	minPasswordLength     = 8
+	maxPasswordLength     = 256
+)
+
+// passwordChars are the characters of the generated passwords. Quotes, backslashes and spaces are excluded, as they
+// need escaping in most configuration files.
+const passwordChars = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789!#%+,-.:=@^_~"
+
+// generatedNameRe matches the name of a generated value, which is a file name.
+var generatedNameRe = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*$`)
+
+// generators returns the values of each kind of generated value.
+var generators = map[string]func(u *url.URL) (string, error){
+	"password": generatePassword,
+	"uuid":     func(*url.URL) (string, error) { return generateUUID() },
+}
+
+// resolveGenerated returns the value generated for the machine referenced by u, in the form
+// generate://KIND/NAME?OPTIONS. The value is generated on first use and stored in dir, so that it is the same on
+// each apply until it expires.
+// If the escrow option is set, a new value is written to this attribute of the computer object with escrow before
+// being used. If this fails, the previous value is kept if any, so that a value is never used without being escrowed.
+// The new value is recorded as pending before, and escrowed again on the next resolution until it succeeds, so that a
+// value written to the directory is never lost.
+func (r Resolvers) resolveGenerated(ctx context.Context, dir string, escrow Escrow, u *url.URL) (string, error) {
+	generate, ok := generators[u.Host]
+	if !ok {
+		return "", fmt.Errorf(i18n.G("unknown kind of generated value %q"), u.Host)
+	}
+	name := u.Path
+	if len(name) > 0 && name[0] == '/' {
+		name = name[1:]
+	}
+	if !generatedNameRe.MatchString(name) {
+		return "", fmt.Errorf(i18n.G("invalid generated value name %q"), name)
+	}
+
+	q := u.Query()
+	var maxAge time.Duration
+	if v := q.Get("max-age"); v != "" {
+		s, err := strconv.ParseUint(v, 10, 32)
+		if err != nil {
+			return "", fmt.Errorf(i18n.G("max-age must be a positive number of seconds, got %q"), v)
+		}
+		maxAge = time.Duration(s) * time.Second
+	}
+	attribute := q.Get("escrow")
+	if attribute != "" {
+		if err := escrow.check(attribute); err != nil {
+			return "", err
+		}
+		if !escrow.CanWrite() {
+			return "", errors.New(i18n.G("generated values can't be escrowed to the directory"))
+		}
+	}
+
+	// Entries of different objects are resolved in parallel and can reference the same value.
+	r.generateMu.Lock()
+	defer r.generateMu.Unlock()
+
+	p := filepath.Join(dir, u.Host, name)
+	// Names can't start with a dot: pending values can't collide with stored ones.
+	pending := filepath.Join(dir, u.Host, ".pending", name)
+	previous, err := os.ReadFile(p)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return "", err
+	}
+
+	v, err := os.ReadFile(pending)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return "", err
+	}
+	if err == nil {
+		log.Warningf(ctx, i18n.G("Escrow of the new %s %q was interrupted: escrowing it again"), u.Host, name)
+	} else {
+		if previous != nil {
+			info, err := os.Stat(p)
+			if err != nil {
+				return "", err
+			}
+			if maxAge == 0 || time.Since(info.ModTime()) < maxAge {
+				return string(previous), nil
+			}
+			log.Infof(ctx, "Generated %s %q expired: generating a new one", u.Host, name)
+		}
+
+		value, err := generate(u)
+		if err != nil {
+			return "", err
+		}
+		v = []byte(value)
+		if err := os.MkdirAll(filepath.Dir(pending), 0700); err != nil {
+			return "", err
+		}
+		if err := os.WriteFile(pending, v, 0600); err != nil {
+			return "", err
+		}
+	}
+
+	if attribute != "" {
+		if err := escrow.Write(ctx, attribute, string(v)); err != nil {
+			if previous != nil {
+				log.Warningf(ctx, i18n.G("Keeping the previous %s %q, as the new one can't be escrowed: %v"), u.Host, name, err)
+				return string(previous), nil
+			}
+			return "", fmt.Errorf(i18n.G("can't escrow generated %s %q: %w"), u.Host, name, err)
+		}
+	}
+
+	if err := os.Rename(pending, p); err != nil {
+		return "", err
+	}
+	return string(v), nil
+}
+
+// generatePassword returns a random password of the length option, or of defaultPasswordLength.
+func generatePassword(u *url.URL) (string, error) {
+	length := defaultPasswordLength
+	if v := u.Query().Get("length"); v != "" {
+		l, err := strconv.Atoi(v)
+		if err != nil {
+			return "", fmt.Errorf(i18n.G("password length must be between %d and %d, got %q"), minPasswordLength, maxPasswordLength, v)
+		}
+		length = l
+	}
+	return GeneratePassword(length)
+}
+
+// GeneratePassword returns a random password of length characters, which must be between 8 and 256.
+func GeneratePassword(length int) (string, error) {
+	if length < minPasswordLength || length > maxPasswordLength {
+		return "", fmt.Errorf(i18n.G("password length must be between %d and %d, got %d"), minPasswordLength, maxPasswordLength, length)
+	}
+
+	password := make([]byte, length)
+	max := big.NewInt(int64(len(passwordChars)))
+	for i := range password {
+		n, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return "", err
+		}
+		password[i] = passwordChars[n.Int64()]
+	}
+	return string(password), nil
+}
+
+// generateUUID returns a random version 4 UUID.
+func generateUUID() (string, error) {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return "", err
+	}
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16]), nil
+}
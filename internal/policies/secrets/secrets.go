@@ -0,0 +1,393 @@
+// Package secrets resolves the secrets referenced by policy values when applying them, so that Wi-Fi keys, proxy
+// credentials or mount passwords are never stored in cleartext in SYSVOL nor in the adsys cache.
+//
+// A value references a secret with {{secret:URI}}, anywhere in the value. For instance:
+//
+//	http://proxyuser:{{secret:file:///etc/adsys/secrets/proxy}}@proxy.example.com:3128
+//
+// The supported URI schemes are:
+//   - file:///etc/adsys/secrets/name: content of a local file of the secrets directory, which must be owned by the
+//     daemon user and not accessible by other users;
+//   - systemd-creds://name: credential passed to the daemon by systemd, with LoadCredential= or
+//     LoadCredentialEncrypted= in its unit;
+//   - vault+https://vault.example.com/v1/secret/data/wifi#psk: field of a HashiCorp Vault secret, read with the
+//     token stored in /etc/adsys/vault-token. Both KV version 1 and 2 secrets engines are supported. The token is only
+//     sent to the Vault servers allowed by the local configuration, never to a server only named by a GPO;
+//   - generate://KIND/NAME: value generated for the machine on first use, then stored, only readable by the daemon
+//     user, and kept until it expires. KIND is password, with an optional length, or uuid. The max-age option is the
+//     number of seconds after which a new value is generated, and the escrow option an attribute of the computer
+//     object, allowed by the local configuration, where each new value is written before being used, like the
+//     password of a local break-glass account:
+//     generate://password/breakglass?length=24&max-age=2592000&escrow=ms-Mcs-AdmPwd
+//
+// Other schemes can be handled by registering a Resolver. A trailing newline is removed from the secrets.
+//
+// Secrets can't be referenced by the entries of the dconf policy managers, as dconf databases are readable by every
+// user. A reference which can't be resolved only fails the rules of its entry type, so that the other policy
+// managers still apply.
+package secrets
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/ubuntu/adsys/internal/consts"
+	"github.com/ubuntu/adsys/internal/i18n"
+	"github.com/ubuntu/adsys/internal/policies/entry"
+	"golang.org/x/exp/slices"
+)
+
+// referenceRe matches a secret reference in a value, capturing its URI.
+var referenceRe = regexp.MustCompile(`\{\{secret:([^{}\s]+)\}\}`)
+
+// publicRules are the entry types whose values are written in files readable by every user.
+var publicRules = []string{"dconf", "dconf-preferences", "gdm"}
+
+// Resolver resolves the secrets of a URI scheme.
+type Resolver interface {
+	// Resolve returns the secret referenced by u.
+	Resolve(ctx context.Context, u *url.URL) (string, error)
+}
+
+// ResolverFunc is a function implementing Resolver.
+type ResolverFunc func(ctx context.Context, u *url.URL) (string, error)
+
+// Resolve calls f(ctx, u).
+func (f ResolverFunc) Resolve(ctx context.Context, u *url.URL) (string, error) {
+	return f(ctx, u)
+}
+
+// Resolvers resolves the secrets referenced by policy values with the resolver of their URI scheme.
+type Resolvers struct {
+	byScheme map[string]Resolver
+
+	generateMu *sync.Mutex
+}
+
+type options struct {
+	resolvers        map[string]Resolver
+	secretsDir       string
+	credentialsDir   string
+	vaultTokenFile   string
+	vaultServers     []string
+	httpClient       *http.Client
+	generatedDir     string
+	escrow           EscrowFunc
+	escrowAttributes []string
+}
+
+// Option reprents an optional function to change the secret resolvers.
+type Option func(*options)
+
+// WithResolver handles the secrets of scheme with r, replacing the built-in resolver of that scheme if any.
+func WithResolver(scheme string, r Resolver) Option {
+	return func(o *options) {
+		o.resolvers[scheme] = r
+	}
+}
+
+// WithSecretsDir specifies a personalized directory of the files secrets can be read from.
+func WithSecretsDir(p string) Option {
+	return func(o *options) {
+		o.secretsDir = p
+	}
+}
+
+// WithCredentialsDir specifies a personalized directory of systemd credentials.
+func WithCredentialsDir(p string) Option {
+	return func(o *options) {
+		o.credentialsDir = p
+	}
+}
+
+// WithVaultTokenFile specifies a personalized file containing the token to authenticate to Vault servers.
+func WithVaultTokenFile(p string) Option {
+	return func(o *options) {
+		o.vaultTokenFile = p
+	}
+}
+
+// WithVaultServers specifies the hosts, with their port if not the default one, of the Vault servers secrets can be
+// read from. Without it, no secret is read from Vault.
+func WithVaultServers(hosts []string) Option {
+	return func(o *options) {
+		o.vaultServers = hosts
+	}
+}
+
+// WithHTTPClient specifies a personalized http client to contact Vault servers.
+func WithHTTPClient(c *http.Client) Option {
+	return func(o *options) {
+		o.httpClient = c
+	}
+}
+
+// WithGeneratedDir specifies a personalized directory where the generated values are stored.
+func WithGeneratedDir(p string) Option {
+	return func(o *options) {
+		o.generatedDir = p
+	}
+}
+
+// WithEscrow writes the generated values with an escrow option to the directory with f.
+// Without it, generated values can't be escrowed.
+func WithEscrow(f EscrowFunc) Option {
+	return func(o *options) {
+		o.escrow = f
+	}
+}
+
+// WithEscrowAttributes specifies the attributes of the computer object the generated values can be escrowed to.
+// Without it, generated values can't be escrowed.
+func WithEscrowAttributes(attributes []string) Option {
+	return func(o *options) {
+		o.escrowAttributes = attributes
+	}
+}
+
+// New returns the built-in secret resolvers, and the ones registered with WithResolver.
+func New(opts ...Option) Resolvers {
+	// defaults
+	args := options{
+		resolvers:      make(map[string]Resolver),
+		secretsDir:     consts.DefaultSecretsDir,
+		credentialsDir: os.Getenv("CREDENTIALS_DIRECTORY"),
+		vaultTokenFile: consts.DefaultVaultTokenFile,
+		httpClient:     &http.Client{Timeout: 30 * time.Second},
+		generatedDir:   filepath.Join(consts.DefaultCacheDir, "generated"),
+	}
+	// applied options
+	for _, o := range opts {
+		o(&args)
+	}
+
+	byScheme := map[string]Resolver{
+		"file": ResolverFunc(func(_ context.Context, u *url.URL) (string, error) {
+			return resolveFile(args.secretsDir, u)
+		}),
+		"systemd-creds": ResolverFunc(func(_ context.Context, u *url.URL) (string, error) {
+			return resolveCredential(args.credentialsDir, u)
+		}),
+		"vault+https": ResolverFunc(func(ctx context.Context, u *url.URL) (string, error) {
+			return resolveVault(ctx, args.httpClient, args.vaultTokenFile, args.vaultServers, u)
+		}),
+	}
+	r := Resolvers{byScheme: byScheme, generateMu: &sync.Mutex{}}
+	byScheme["generate"] = ResolverFunc(func(ctx context.Context, u *url.URL) (string, error) {
+		return r.resolveGenerated(ctx, args.generatedDir, NewEscrow(args.escrow, nil, args.escrowAttributes), u)
+	})
+	for scheme, resolver := range args.resolvers {
+		byScheme[scheme] = resolver
+	}
+
+	return r
+}
+
+// Resolve returns a copy of rules where every secret reference is replaced by the secret it references.
+// rules is not modified, so that the secrets are never cached nor reported. Disabled entries are not resolved.
+// The entry types with a secret which can't be resolved are not returned: their error is returned in failed instead.
+func (r Resolvers) Resolve(ctx context.Context, rules map[string][]entry.Entry) (resolved map[string][]entry.Entry, failed map[string]error) {
+	resolved = make(map[string][]entry.Entry, len(rules))
+	for key, entries := range rules {
+		if entries == nil {
+			resolved[key] = nil
+			continue
+		}
+		resolvedEntries, err := r.resolveEntries(ctx, key, entries)
+		if err != nil {
+			if failed == nil {
+				failed = make(map[string]error)
+			}
+			failed[key] = fmt.Errorf(i18n.G("can't resolve secrets: %w"), err)
+			continue
+		}
+		resolved[key] = resolvedEntries
+	}
+	return resolved, failed
+}
+
+// resolveEntries returns a copy of entries, of type key, with their secret references replaced by their secrets.
+func (r Resolvers) resolveEntries(ctx context.Context, key string, entries []entry.Entry) ([]entry.Entry, error) {
+	resolved := make([]entry.Entry, 0, len(entries))
+	for _, e := range entries {
+		if !e.Disabled {
+			if slices.Contains(publicRules, key) && referenceRe.MatchString(e.Value) {
+				return nil, fmt.Errorf(i18n.G("%s/%s: secrets can't be referenced by %s entries, which are readable by every user"), key, e.Key, key)
+			}
+			v, err := r.resolveValue(ctx, e.Value)
+			if err != nil {
+				// Never report the value: it could contain other secrets.
+				return nil, fmt.Errorf("%s/%s: %w", key, e.Key, err)
+			}
+			e.Value = v
+		}
+		resolved = append(resolved, e)
+	}
+	return resolved, nil
+}
+
+// resolveValue replaces every secret reference of v with the secret it references.
+func (r Resolvers) resolveValue(ctx context.Context, v string) (string, error) {
+	matches := referenceRe.FindAllStringSubmatchIndex(v, -1)
+	if matches == nil {
+		return v, nil
+	}
+
+	var out strings.Builder
+	var last int
+	for _, m := range matches {
+		ref := v[m[2]:m[3]]
+		u, err := url.Parse(ref)
+		if err != nil {
+			return "", fmt.Errorf(i18n.G("invalid secret reference %q: %w"), ref, err)
+		}
+		resolver, ok := r.byScheme[u.Scheme]
+		if !ok {
+			return "", fmt.Errorf(i18n.G("no resolver for secret %q"), ref)
+		}
+		secret, err := resolver.Resolve(ctx, u)
+		if err != nil {
+			return "", fmt.Errorf(i18n.G("can't resolve secret %q: %w"), ref, err)
+		}
+		out.WriteString(v[last:m[0]])
+		out.WriteString(strings.TrimSuffix(secret, "\n"))
+		last = m[1]
+	}
+	out.WriteString(v[last:])
+	return out.String(), nil
+}
+
+// resolveFile returns the content of the file referenced by u, which must be in secretsDir.
+// The file must be owned by the daemon user and not accessible by other users, to not leak the secret.
+func resolveFile(secretsDir string, u *url.URL) (string, error) {
+	if u.Host != "" && u.Host != "localhost" {
+		return "", fmt.Errorf(i18n.G("secret file must be local, not on %q"), u.Host)
+	}
+	if !filepath.IsAbs(u.Path) {
+		return "", errors.New(i18n.G("secret file path must be absolute"))
+	}
+
+	// Symlinks are resolved, so that they can't point outside of the secrets directory.
+	dir, err := filepath.EvalSymlinks(secretsDir)
+	if err != nil {
+		return "", fmt.Errorf(i18n.G("can't read secrets directory: %w"), err)
+	}
+	p, err := filepath.EvalSymlinks(u.Path)
+	if err != nil {
+		return "", err
+	}
+	if rel, err := filepath.Rel(dir, p); err != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, "../") {
+		return "", fmt.Errorf(i18n.G("secret file must be in %s"), secretsDir)
+	}
+
+	info, err := os.Stat(p)
+	if err != nil {
+		return "", err
+	}
+	if info.Mode().Perm()&0077 != 0 {
+		return "", fmt.Errorf(i18n.G("secret file %s must not be accessible by other users, got permissions %#o"), u.Path, info.Mode().Perm())
+	}
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok && int(stat.Uid) != os.Geteuid() {
+		return "", fmt.Errorf(i18n.G("secret file %s must be owned by the daemon user"), u.Path)
+	}
+
+	d, err := os.ReadFile(p)
+	if err != nil {
+		return "", err
+	}
+	return string(d), nil
+}
+
+// resolveCredential returns the systemd credential named after the host of u, in credentialsDir.
+func resolveCredential(credentialsDir string, u *url.URL) (string, error) {
+	if credentialsDir == "" {
+		return "", errors.New(i18n.G("no credential was passed to the daemon by systemd"))
+	}
+	name := u.Host
+	if name == "" || strings.Trim(u.Path, "/") != "" {
+		return "", errors.New(i18n.G("credential name must be the only part of the URI"))
+	}
+
+	d, err := os.ReadFile(filepath.Join(credentialsDir, name))
+	if err != nil {
+		return "", err
+	}
+	return string(d), nil
+}
+
+// resolveVault returns the field, set as the URI fragment, of the Vault secret referenced by u.
+// The host of u must be one of servers, so that the token is never sent to a server a GPO names.
+func resolveVault(ctx context.Context, client *http.Client, tokenFile string, servers []string, u *url.URL) (string, error) {
+	if !slices.Contains(servers, u.Host) {
+		return "", fmt.Errorf(i18n.G("Vault server %q is not allowed by the configuration"), u.Host)
+	}
+	field := u.Fragment
+	if field == "" {
+		return "", errors.New(i18n.G("the secret field must be set as the URI fragment"))
+	}
+
+	token, err := os.ReadFile(tokenFile)
+	if err != nil {
+		return "", fmt.Errorf(i18n.G("can't read Vault token: %w"), err)
+	}
+
+	secretURL := *u
+	secretURL.Scheme = "https"
+	secretURL.Fragment = ""
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, secretURL.String(), nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("X-Vault-Token", strings.TrimSpace(string(token)))
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf(i18n.G("Vault server returned %s"), resp.Status)
+	}
+
+	d, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return "", err
+	}
+	// KV version 2 secrets are nested in a second data object, along with their metadata.
+	var secret struct {
+		Data map[string]json.RawMessage `json:"data"`
+	}
+	if err := json.Unmarshal(d, &secret); err != nil {
+		return "", fmt.Errorf(i18n.G("invalid Vault response: %w"), err)
+	}
+	data := secret.Data
+	if nested, ok := data["data"]; ok {
+		if _, hasMetadata := data["metadata"]; hasMetadata {
+			if err := json.Unmarshal(nested, &data); err != nil {
+				return "", fmt.Errorf(i18n.G("invalid Vault response: %w"), err)
+			}
+		}
+	}
+
+	raw, ok := data[field]
+	if !ok {
+		return "", fmt.Errorf(i18n.G("no field %q in Vault secret"), field)
+	}
+	var v string
+	if err := json.Unmarshal(raw, &v); err != nil {
+		return "", fmt.Errorf(i18n.G("field %q of Vault secret is not a string"), field)
+	}
+	return v, nil
+}
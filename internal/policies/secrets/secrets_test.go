@@ -0,0 +1,312 @@
+package secrets_test
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/ubuntu/adsys/internal/policies/entry"
+	"github.com/ubuntu/adsys/internal/policies/secrets"
+	"github.com/ubuntu/adsys/internal/testutils"
+)
+
+func TestResolve(t *testing.T) {
+	t.Parallel()
+
+	// Values reference secrets with TMP as the secrets directory, OUTSIDE as another directory and VAULT as the
+	// allowed Vault server.
+	tests := map[string]struct {
+		value           string
+		rulesKey        string
+		disabled        bool
+		noCredentials   bool
+		noVaultToken    bool
+		customResolvers map[string]secrets.Resolver
+
+		wantErr bool
+	}{
+		"Value without secret is unchanged":          {value: "file:///etc/not-a-secret"},
+		"Secret from file":                           {value: "{{secret:file://TMP/secret}}"},
+		"Secret embedded in value":                   {value: "http://user:{{secret:file://TMP/secret}}@proxy.example.com:3128"},
+		"Multiple secrets in value":                  {value: "{{secret:file://TMP/secret}}:{{secret:systemd-creds://credential}}"},
+		"Only trailing newline is removed":           {value: "{{secret:file://TMP/multilines}}"},
+		"Secret from systemd credential":             {value: "{{secret:systemd-creds://credential}}"},
+		"Secret from Vault KV version 2":             {value: "{{secret:vault+https://VAULT/v1/secret/data/wifi#psk}}"},
+		"Secret from Vault KV version 1":             {value: "{{secret:vault+https://VAULT/v1/kv/wifi#psk}}"},
+		"Disabled entries are not resolved":          {value: "{{secret:unknown://secret}}", disabled: true},
+		"Secret from registered resolver":            {value: "{{secret:custom://secret}}", customResolvers: map[string]secrets.Resolver{"custom": customResolver}},
+		"Registered resolver overrides builtin ones": {value: "{{secret:file://TMP/secret}}", customResolvers: map[string]secrets.Resolver{"file": customResolver}},
+
+		// Error cases
+		"Error on unknown scheme":                             {value: "{{secret:unknown://secret}}", wantErr: true},
+		"Error on invalid reference":                          {value: "{{secret:%zz}}", wantErr: true},
+		"Error on missing secret file":                        {value: "{{secret:file://TMP/does-not-exist}}", wantErr: true},
+		"Error on secret file accessible by other users":      {value: "{{secret:file://TMP/readable}}", wantErr: true},
+		"Error on remote secret file":                         {value: "{{secret:file://example.com/secret}}", wantErr: true},
+		"Error on secret file outside of secrets directory":   {value: "{{secret:file://OUTSIDE/secret}}", wantErr: true},
+		"Error on secret file linking outside of secrets dir": {value: "{{secret:file://TMP/link}}", wantErr: true},
+		"Error on secrets directory as secret file":           {value: "{{secret:file://TMP}}", wantErr: true},
+		"Error on no systemd credentials":                     {value: "{{secret:systemd-creds://credential}}", noCredentials: true, wantErr: true},
+		"Error on missing systemd credential":                 {value: "{{secret:systemd-creds://does-not-exist}}", wantErr: true},
+		"Error on systemd credential with a path":             {value: "{{secret:systemd-creds://credential/other}}", wantErr: true},
+		"Error on Vault secret without field":                 {value: "{{secret:vault+https://VAULT/v1/secret/data/wifi}}", wantErr: true},
+		"Error on missing Vault secret field":                 {value: "{{secret:vault+https://VAULT/v1/secret/data/wifi#doesnotexist}}", wantErr: true},
+		"Error on Vault secret field not being a string":      {value: "{{secret:vault+https://VAULT/v1/secret/data/wifi#channel}}", wantErr: true},
+		"Error on missing Vault secret":                       {value: "{{secret:vault+https://VAULT/v1/secret/data/doesnotexist#psk}}", wantErr: true},
+		"Error on invalid Vault response":                     {value: "{{secret:vault+https://VAULT/v1/invalid#psk}}", wantErr: true},
+		"Error on missing Vault token":                        {value: "{{secret:vault+https://VAULT/v1/secret/data/wifi#psk}}", noVaultToken: true, wantErr: true},
+		"Error on Vault server not allowed":                   {value: "{{secret:vault+https://vault.example.com/v1/secret/data/wifi#psk}}", wantErr: true},
+		"Error on secret referenced by dconf entry":           {value: "{{secret:file://TMP/secret}}", rulesKey: "dconf", wantErr: true},
+		"Error on secret referenced by gdm entry":             {value: "{{secret:file://TMP/secret}}", rulesKey: "gdm", wantErr: true},
+		"Error on registered resolver failing to resolve":     {value: "{{secret:custom://fail}}", customResolvers: map[string]secrets.Resolver{"custom": customResolver}, wantErr: true},
+		"Error on one secret of the value failing to resolve": {value: "{{secret:file://TMP/secret}}{{secret:file://TMP/does-not-exist}}", wantErr: true},
+	}
+	for name, tc := range tests {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			tmp := t.TempDir()
+			require.NoError(t, os.WriteFile(filepath.Join(tmp, "secret"), []byte("file-secret\n"), 0600), "Setup: can't create secret file")
+			require.NoError(t, os.WriteFile(filepath.Join(tmp, "multilines"), []byte("first line\nsecond line\n\n"), 0600), "Setup: can't create secret file")
+			require.NoError(t, os.WriteFile(filepath.Join(tmp, "readable"), []byte("file-secret\n"), 0644), "Setup: can't create secret file")
+			outside := t.TempDir()
+			require.NoError(t, os.WriteFile(filepath.Join(outside, "secret"), []byte("file-secret\n"), 0600), "Setup: can't create secret file")
+			require.NoError(t, os.Symlink(filepath.Join(outside, "secret"), filepath.Join(tmp, "link")), "Setup: can't create secret link")
+
+			credentialsDir := filepath.Join(tmp, "credentials")
+			require.NoError(t, os.Mkdir(credentialsDir, 0700), "Setup: can't create credentials directory")
+			require.NoError(t, os.WriteFile(filepath.Join(credentialsDir, "credential"), []byte("credential-secret"), 0600), "Setup: can't create credential")
+			if tc.noCredentials {
+				credentialsDir = ""
+			}
+
+			vaultTokenFile := filepath.Join(tmp, "vault-token")
+			if !tc.noVaultToken {
+				require.NoError(t, os.WriteFile(vaultTokenFile, []byte("vault-token\n"), 0600), "Setup: can't create vault token")
+			}
+			vault := httptest.NewTLSServer(http.HandlerFunc(vaultHandler))
+			t.Cleanup(vault.Close)
+
+			vaultHost := strings.TrimPrefix(vault.URL, "https://")
+
+			opts := []secrets.Option{
+				secrets.WithSecretsDir(tmp),
+				secrets.WithCredentialsDir(credentialsDir),
+				secrets.WithVaultTokenFile(vaultTokenFile),
+				secrets.WithVaultServers([]string{vaultHost}),
+				secrets.WithHTTPClient(vault.Client()),
+			}
+			for scheme, r := range tc.customResolvers {
+				opts = append(opts, secrets.WithResolver(scheme, r))
+			}
+			r := secrets.New(opts...)
+
+			if tc.rulesKey == "" {
+				tc.rulesKey = "proxy"
+			}
+			value := strings.NewReplacer("TMP", tmp, "OUTSIDE", outside, "VAULT", vaultHost).Replace(tc.value)
+			rules := map[string][]entry.Entry{
+				tc.rulesKey: {
+					{Key: "path/to/key", Value: value, Disabled: tc.disabled},
+					{Key: "path/to/other", Value: "no secret"},
+				},
+				"other": {{Key: "path/to/key", Value: "no secret"}},
+			}
+
+			got, failed := r.Resolve(context.Background(), rules)
+			if tc.wantErr {
+				require.Error(t, failed[tc.rulesKey], "Resolve should have failed but didn't")
+				require.NotContains(t, failed[tc.rulesKey].Error(), "file-secret", "Resolve should not leak resolved secrets in errors")
+				require.NotContains(t, got, tc.rulesKey, "Resolve should not return the failed entry type")
+				require.Equal(t, rules["other"], got["other"], "Resolve should return the other entry types")
+				return
+			}
+			require.Empty(t, failed, "Resolve failed but shouldn't have")
+
+			require.Equal(t, value, rules[tc.rulesKey][0].Value, "Resolve should not modify the original rules")
+			want := testutils.LoadWithUpdateFromGoldenYAML(t, got)
+			require.Equal(t, want, got, "Resolve returned unexpected entries")
+		})
+	}
+}
+
+func TestGenerate(t *testing.T) {
+	t.Parallel()
+
+	uuidRe := regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`)
+
+	tests := map[string]struct {
+		value     string
+		previous  string
+		pending   string
+		expired   bool
+		noEscrow  bool
+		escrowErr bool
+
+		wantLength      int
+		wantUUID        bool
+		wantPrevious    bool
+		wantPending     bool
+		wantEscrowed    bool
+		wantPendingKept bool
+		wantErr         bool
+	}{
+		"Generate password":                                    {value: "generate://password/breakglass", wantLength: 20},
+		"Generate password of requested length":                {value: "generate://password/breakglass?length=32", wantLength: 32},
+		"Generate UUID":                                        {value: "generate://uuid/machine-id", wantUUID: true},
+		"Previous value is kept":                               {value: "generate://password/breakglass", previous: "previous-password", wantPrevious: true},
+		"Previous value is kept until it expires":              {value: "generate://password/breakglass?max-age=3600", previous: "previous-password", wantPrevious: true},
+		"Expired value is generated again":                     {value: "generate://password/breakglass?max-age=3600", previous: "previous-password", expired: true, wantLength: 20},
+		"Value without max-age never expires":                  {value: "generate://password/breakglass", previous: "previous-password", expired: true, wantPrevious: true},
+		"New value is escrowed":                                {value: "generate://password/breakglass?escrow=ms-Mcs-AdmPwd", wantLength: 20, wantEscrowed: true},
+		"Previous value is not escrowed again":                 {value: "generate://password/breakglass?escrow=ms-Mcs-AdmPwd", previous: "previous-password", wantPrevious: true},
+		"Expired value is escrowed again":                      {value: "generate://password/breakglass?max-age=3600&escrow=ms-Mcs-AdmPwd", previous: "previous-password", expired: true, wantLength: 20, wantEscrowed: true},
+		"Previous value is kept if escrow fails":               {value: "generate://password/breakglass?max-age=3600&escrow=ms-Mcs-AdmPwd", previous: "previous-password", expired: true, escrowErr: true, wantPrevious: true, wantPendingKept: true},
+		"Interrupted escrow is retried with the same value":    {value: "generate://password/breakglass?max-age=3600&escrow=ms-Mcs-AdmPwd", previous: "previous-password", pending: "pending-password", wantPending: true, wantEscrowed: true},
+		"Interrupted escrow without previous value is retried": {value: "generate://password/breakglass?escrow=ms-Mcs-AdmPwd", pending: "pending-password", wantPending: true, wantEscrowed: true},
+		"Generated value is embedded in the entry":             {value: "user:generate://uuid/machine-id", wantUUID: true},
+
+		// Error cases
+		"Error on unknown kind":                          {value: "generate://unknown/name", wantErr: true},
+		"Error on missing name":                          {value: "generate://password", wantErr: true},
+		"Error on name with a path":                      {value: "generate://password/../name", wantErr: true},
+		"Error on password too short":                    {value: "generate://password/breakglass?length=4", wantErr: true},
+		"Error on invalid password length":               {value: "generate://password/breakglass?length=long", wantErr: true},
+		"Error on invalid max-age":                       {value: "generate://password/breakglass?max-age=-1", wantErr: true},
+		"Error on invalid escrow attribute":              {value: "generate://password/breakglass?escrow=ms_Mcs", wantErr: true},
+		"Error on escrow attribute not allowed":          {value: "generate://password/breakglass?escrow=description", wantErr: true},
+		"Error on escrow without directory":              {value: "generate://password/breakglass?escrow=ms-Mcs-AdmPwd", noEscrow: true, wantErr: true},
+		"Error on escrow failing without previous value": {value: "generate://password/breakglass?escrow=ms-Mcs-AdmPwd", escrowErr: true, wantPendingKept: true, wantErr: true},
+	}
+	for name, tc := range tests {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			generatedDir := t.TempDir()
+			u, err := url.Parse(strings.TrimPrefix(tc.value, "user:"))
+			require.NoError(t, err, "Setup: invalid reference")
+			stored := filepath.Join(generatedDir, u.Host, strings.TrimPrefix(u.Path, "/"))
+			pending := filepath.Join(generatedDir, u.Host, ".pending", strings.TrimPrefix(u.Path, "/"))
+			if tc.previous != "" {
+				require.NoError(t, os.MkdirAll(filepath.Dir(stored), 0700), "Setup: can't create generated values directory")
+				require.NoError(t, os.WriteFile(stored, []byte(tc.previous), 0600), "Setup: can't write previous value")
+				if tc.expired {
+					old := time.Now().Add(-2 * time.Hour)
+					require.NoError(t, os.Chtimes(stored, old, old), "Setup: can't expire previous value")
+				}
+			}
+			if tc.pending != "" {
+				require.NoError(t, os.MkdirAll(filepath.Dir(pending), 0700), "Setup: can't create pending values directory")
+				require.NoError(t, os.WriteFile(pending, []byte(tc.pending), 0600), "Setup: can't write pending value")
+			}
+
+			var escrowed []string
+			opts := []secrets.Option{secrets.WithGeneratedDir(generatedDir), secrets.WithEscrowAttributes([]string{"MS-MCS-AdmPwd"})}
+			if !tc.noEscrow {
+				opts = append(opts, secrets.WithEscrow(func(_ context.Context, attribute, value string) error {
+					if tc.escrowErr {
+						return errors.New("escrow error requested")
+					}
+					escrowed = append(escrowed, attribute+"="+value)
+					return nil
+				}))
+			}
+			r := secrets.New(opts...)
+
+			value := "{{secret:" + strings.TrimPrefix(tc.value, "user:") + "}}"
+			prefix := ""
+			if strings.HasPrefix(tc.value, "user:") {
+				prefix = "user:"
+				value = prefix + value
+			}
+			rules := map[string][]entry.Entry{"proxy": {{Key: "path/to/key", Value: value}}}
+
+			got, failed := r.Resolve(context.Background(), rules)
+			if tc.wantPendingKept {
+				require.FileExists(t, pending, "Value which failed to be escrowed should be kept pending")
+			} else {
+				require.NoFileExists(t, pending, "No value should be pending")
+			}
+			if tc.wantErr {
+				require.Error(t, failed["proxy"], "Resolve should have failed but didn't")
+				return
+			}
+			require.Empty(t, failed, "Resolve failed but shouldn't have")
+
+			require.True(t, strings.HasPrefix(got["proxy"][0].Value, prefix), "Generated value should be embedded in the entry")
+			v := strings.TrimPrefix(got["proxy"][0].Value, prefix)
+			switch {
+			case tc.wantPrevious:
+				require.Equal(t, tc.previous, v, "Previous value should be kept")
+			case tc.wantPending:
+				require.Equal(t, tc.pending, v, "Pending value should be used once escrowed")
+			case tc.wantUUID:
+				require.Regexp(t, uuidRe, v, "Generated value should be a UUID")
+			default:
+				require.Len(t, v, tc.wantLength, "Generated password should have the expected length")
+				require.NotEqual(t, tc.previous, v, "A new value should be generated")
+			}
+
+			if tc.wantEscrowed {
+				require.Equal(t, []string{"ms-Mcs-AdmPwd=" + v}, escrowed, "New value should be escrowed")
+			} else {
+				require.Empty(t, escrowed, "Nothing should be escrowed")
+			}
+
+			d, err := os.ReadFile(stored)
+			require.NoError(t, err, "Generated value should be stored")
+			require.Equal(t, v, string(d), "Stored value should be the one used")
+
+			again, failed := r.Resolve(context.Background(), rules)
+			require.Empty(t, failed, "Resolve failed but shouldn't have")
+			require.Equal(t, got, again, "Generated value should be the same on next resolution")
+		})
+	}
+}
+
+// customResolver resolves custom://NAME to custom-NAME, and fails on custom://fail.
+var customResolver = secrets.ResolverFunc(func(_ context.Context, u *url.URL) (string, error) {
+	if u.Host == "fail" {
+		return "", fmt.Errorf("failing on %q", u)
+	}
+	return "custom-" + u.Host, nil
+})
+
+// vaultHandler mocks a Vault server, with a KV version 2 secret under secret/ and a KV version 1 one under kv/.
+func vaultHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("X-Vault-Token") != "vault-token" {
+		http.Error(w, `{"errors":["permission denied"]}`, http.StatusForbidden)
+		return
+	}
+
+	switch r.URL.Path {
+	case "/v1/secret/data/wifi":
+		fmt.Fprint(w, `{"data":{"data":{"psk":"vault-kv2-secret","channel":11},"metadata":{"version":1}}}`)
+	case "/v1/kv/wifi":
+		fmt.Fprint(w, `{"data":{"psk":"vault-kv1-secret"}}`)
+	case "/v1/invalid":
+		fmt.Fprint(w, `not json`)
+	default:
+		http.Error(w, `{"errors":[]}`, http.StatusNotFound)
+	}
+}
+
+func TestMain(m *testing.M) {
+	testutils.InstallUpdateFlag()
+	flag.Parse()
+
+	m.Run()
+}
@@ -0,0 +1,3 @@
+package sssd
+
+const SSSDUnit = sssdUnit
@@ -0,0 +1,167 @@
+// Package sssd is the policy manager configuring SSSD itself from the machine policies.
+//
+// A constrained set of options, like the cache lifetimes, the offline timeouts, the dynamic DNS updates or the GPO
+// access control mode, is rendered for the AD domain of sssd.conf, the first listed one with the ad id provider like
+// adsys uses, in a snippet of the conf.d directory next to it. The other domains are left untouched. SSSD reads the
+// snippets after sssd.conf, so that the policies override the local configuration.
+//
+// Values are validated before anything is written, and an error is returned if any of them is invalid. SSSD is
+// restarted only when the snippet content changed, once all policy managers applied their policies, so that their
+// lookups don't fail while it restarts. A restart failure is only logged as a warning.
+//
+// If sssd.conf does not exist, SSSD is not used on this machine: entries are ignored and a warning is logged.
+package sssd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	log "github.com/ubuntu/adsys/internal/grpc/logstreamer"
+	"github.com/ubuntu/adsys/internal/i18n"
+	"github.com/ubuntu/adsys/internal/policies/dropin"
+	"github.com/ubuntu/adsys/internal/policies/entry"
+	"github.com/ubuntu/decorate"
+	"gopkg.in/ini.v1"
+)
+
+// ConfFileName is the name of the snippet generated in the SSSD conf.d directory.
+const ConfFileName = "90-adsys.conf"
+
+// sssdUnit is the systemd unit restarted to apply the new configuration.
+const sssdUnit = "sssd.service"
+
+// supportedKeys are the entry keys supported by the sssd manager, with the SSSD domain option they set.
+var supportedKeys = map[string]dropin.Option{
+	"entry-cache-timeout":      {Name: "entry_cache_timeout", Parse: dropin.ParseCount},
+	"offline-timeout":          {Name: "offline_timeout", Parse: dropin.ParseCount},
+	"offline-timeout-max":      {Name: "offline_timeout_max", Parse: dropin.ParseCount},
+	"cached-auth-timeout":      {Name: "cached_auth_timeout", Parse: dropin.ParseCount},
+	"account-cache-expiration": {Name: "account_cache_expiration", Parse: dropin.ParseCount},
+	"dyndns-refresh-interval":  {Name: "dyndns_refresh_interval", Parse: dropin.ParseCount},
+	"dyndns-ttl":               {Name: "dyndns_ttl", Parse: dropin.ParseCount},
+	"dyndns-update":            {Name: "dyndns_update", Parse: dropin.ParseBool("true", "false")},
+	"dyndns-update-ptr":        {Name: "dyndns_update_ptr", Parse: dropin.ParseBool("true", "false")},
+	"gpo-access-control":       {Name: "ad_gpo_access_control", Parse: parseGPOAccessControl},
+}
+
+type systemdCaller interface {
+	RestartUnit(context.Context, string) error
+}
+
+// Manager prevents running multiple sssd update processes in parallel while parsing policy in ApplyPolicy.
+type Manager struct {
+	sssdConf      string
+	confFile      string
+	systemdCaller systemdCaller
+
+	// changed is set when the snippet changed since SSSD was last restarted.
+	changed bool
+	mu      sync.Mutex
+}
+
+// New returns a manager configuring the AD domain of sssdConf in the conf.d directory next to it, restarting
+// SSSD with systemdCaller.
+func New(sssdConf string, systemdCaller systemdCaller) *Manager {
+	return &Manager{
+		sssdConf:      sssdConf,
+		confFile:      filepath.Join(filepath.Dir(sssdConf), "conf.d", ConfFileName),
+		systemdCaller: systemdCaller,
+	}
+}
+
+// Destinations returns the snippet written by the manager.
+func (m *Manager) Destinations() []string {
+	return []string{m.confFile}
+}
+
+// ApplyPolicy generates the SSSD configuration snippet of the machine based on a list of entries.
+// RestartIfChanged loads it once all policy managers applied their policies.
+func (m *Manager) ApplyPolicy(ctx context.Context, objectName string, isComputer bool, entries []entry.Entry) (err error) {
+	defer decorate.OnError(&err, i18n.G("can't apply sssd policy"))
+
+	// SSSD configuration is machine wide.
+	if !isComputer {
+		return nil
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	log.Debugf(ctx, "Applying sssd policy to %s", objectName)
+
+	options, err := dropin.ParseEntries(ctx, "sssd", supportedKeys, entries)
+	if err != nil {
+		return err
+	}
+
+	if len(options) == 0 {
+		changed, err := dropin.Remove(m.confFile)
+		if err != nil {
+			return err
+		}
+		m.changed = m.changed || changed
+		return nil
+	}
+
+	cfg, err := ini.Load(m.sssdConf)
+	if errors.Is(err, fs.ErrNotExist) {
+		log.Warningf(ctx, i18n.G("Not applying sssd policy as %s does not exist"), m.sssdConf)
+		return nil
+	} else if err != nil {
+		return err
+	}
+	var domain string
+	for _, d := range strings.Split(cfg.Section("sssd").Key("domains").String(), ",") {
+		d = strings.TrimSpace(d)
+		if d != "" && cfg.Section("domain/"+d).Key("id_provider").String() == "ad" {
+			domain = d
+			break
+		}
+	}
+	if domain == "" {
+		return fmt.Errorf(i18n.G("no AD domain is configured in %s"), m.sssdConf)
+	}
+
+	content := fmt.Sprintf("# This file is managed by adsys from the machine policies. Do not edit it.\n\n[domain/%s]\n%s",
+		domain, dropin.Render(options, " = "))
+	// SSSD refuses to load snippets readable by other users.
+	changed, err := dropin.Write(m.confFile, []byte(content), 0600)
+	if err != nil {
+		return err
+	}
+	if !changed {
+		log.Debugf(ctx, "SSSD configuration is already up to date")
+	}
+	m.changed = m.changed || changed
+	return nil
+}
+
+// RestartIfChanged restarts SSSD to load its new configuration, if ApplyPolicy changed it since the last restart.
+// A failure only warns, as the configuration itself is applied.
+func (m *Manager) RestartIfChanged(ctx context.Context) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if !m.changed {
+		return
+	}
+	m.changed = false
+	if err := m.systemdCaller.RestartUnit(ctx, sssdUnit); err != nil {
+		log.Warningf(ctx, i18n.G("Failed to restart SSSD to apply its new configuration: %v"), err)
+	}
+}
+
+// parseGPOAccessControl validates a GPO access control mode.
+func parseGPOAccessControl(v string) (string, error) {
+	v = strings.ToLower(v)
+	switch v {
+	case "enforcing", "permissive", "disabled":
+		return v, nil
+	}
+	return "", errors.New(i18n.G("must be enforcing, permissive or disabled"))
+}
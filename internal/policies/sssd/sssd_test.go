@@ -0,0 +1,179 @@
+package sssd_test
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/ubuntu/adsys/internal/policies/entry"
+	"github.com/ubuntu/adsys/internal/policies/sssd"
+	"github.com/ubuntu/adsys/internal/testutils"
+)
+
+func TestApplyPolicy(t *testing.T) {
+	t.Parallel()
+
+	allOptions := []entry.Entry{
+		{Key: "sssd/entry-cache-timeout", Value: "600"},
+		{Key: "sssd/offline-timeout", Value: "60"},
+		{Key: "sssd/offline-timeout-max", Value: "3600"},
+		{Key: "sssd/cached-auth-timeout", Value: "86400"},
+		{Key: "sssd/account-cache-expiration", Value: "30"},
+		{Key: "sssd/dyndns-refresh-interval", Value: "43200"},
+		{Key: "sssd/dyndns-ttl", Value: "3600"},
+		{Key: "sssd/dyndns-update", Value: "true"},
+		{Key: "sssd/dyndns-update-ptr", Value: "false"},
+		{Key: "sssd/gpo-access-control", Value: "enforcing"},
+	}
+
+	tests := map[string]struct {
+		entries      []entry.Entry
+		isUser       bool
+		sssdConf     string
+		existingConf string
+		confDirFile  bool
+		restartError bool
+
+		wantRestart bool
+		wantErr     bool
+	}{
+		"All supported options": {entries: allOptions, wantRestart: true},
+		"Options are only set for the AD domain": {
+			entries:     []entry.Entry{{Key: "sssd/entry-cache-timeout", Value: "600"}},
+			sssdConf:    "multiple-domains.conf",
+			wantRestart: true,
+		},
+		"Booleans and modes are normalized": {
+			entries: []entry.Entry{
+				{Key: "sssd/dyndns-update", Value: "1"},
+				{Key: "sssd/dyndns-update-ptr", Value: "0"},
+				{Key: "sssd/gpo-access-control", Value: " Permissive "},
+			},
+			wantRestart: true,
+		},
+		"Disabled entries are left to the local configuration": {
+			entries: []entry.Entry{
+				{Key: "sssd/entry-cache-timeout", Value: "600"},
+				{Key: "sssd/offline-timeout", Disabled: true},
+			},
+			wantRestart: true,
+		},
+		"Unsupported keys are ignored": {
+			entries: []entry.Entry{
+				{Key: "sssd/entry-cache-timeout", Value: "600"},
+				{Key: "sssd/ldap_uri", Value: "ldap://example.com"},
+			},
+			wantRestart: true,
+		},
+		"Outdated snippet is replaced": {
+			entries:      []entry.Entry{{Key: "sssd/entry-cache-timeout", Value: "600"}},
+			existingConf: "outdated.conf",
+			wantRestart:  true,
+		},
+		"Up to date snippet does not restart SSSD": {
+			entries:      []entry.Entry{{Key: "sssd/entry-cache-timeout", Value: "600"}},
+			existingConf: "up-to-date.conf",
+		},
+		"No entries removes existing snippet": {existingConf: "outdated.conf", wantRestart: true},
+		"Only disabled entries removes existing snippet": {
+			entries:      []entry.Entry{{Key: "sssd/offline-timeout", Disabled: true}},
+			existingConf: "outdated.conf",
+			wantRestart:  true,
+		},
+		"No entries and no snippet is a noop": {},
+		"Missing sssd.conf is a noop":         {entries: allOptions, sssdConf: "-"},
+		"Failing to restart SSSD only warns":  {entries: allOptions, restartError: true, wantRestart: true},
+		"User does nothing":                   {entries: allOptions, isUser: true},
+
+		// Error cases
+		"Error on non numeric timeout":       {entries: []entry.Entry{{Key: "sssd/offline-timeout", Value: "1m"}}, wantErr: true},
+		"Error on negative timeout":          {entries: []entry.Entry{{Key: "sssd/offline-timeout", Value: "-1"}}, wantErr: true},
+		"Error on invalid boolean":           {entries: []entry.Entry{{Key: "sssd/dyndns-update", Value: "yes please"}}, wantErr: true},
+		"Error on invalid access control":    {entries: []entry.Entry{{Key: "sssd/gpo-access-control", Value: "strict"}}, wantErr: true},
+		"Error on no domain in sssd.conf":    {entries: allOptions, sssdConf: "no-domain.conf", wantErr: true},
+		"Error on no AD domain in sssd.conf": {entries: allOptions, sssdConf: "no-ad-domain.conf", wantErr: true},
+		"Error on conf.d not being a directory": {
+			entries:     allOptions,
+			confDirFile: true,
+			wantErr:     true,
+		},
+	}
+	for name, tc := range tests {
+		tc := tc
+		name := name
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			if tc.sssdConf == "" {
+				tc.sssdConf = "single-domain.conf"
+			}
+
+			root := t.TempDir()
+			sssdConf := filepath.Join(root, "sssd.conf")
+			if tc.sssdConf != "-" {
+				testutils.Copy(t, filepath.Join("testdata", "sssd-confs", tc.sssdConf), sssdConf)
+			}
+			if tc.existingConf != "" {
+				require.NoError(t, os.MkdirAll(filepath.Join(root, "conf.d"), 0755), "Setup: can't create conf.d directory")
+				testutils.Copy(t, filepath.Join("testdata", "existing-confs", tc.existingConf), filepath.Join(root, "conf.d", sssd.ConfFileName))
+			}
+			if tc.confDirFile {
+				require.NoError(t, os.WriteFile(filepath.Join(root, "conf.d"), nil, 0600), "Setup: can't create conf.d file")
+			}
+
+			systemd := &mockSystemdCaller{restartError: tc.restartError}
+			m := sssd.New(sssdConf, systemd)
+			err := m.ApplyPolicy(context.Background(), "ubuntu", !tc.isUser, tc.entries)
+			if tc.wantErr {
+				require.Error(t, err, "ApplyPolicy should have failed but didn't")
+				return
+			}
+			require.NoError(t, err, "ApplyPolicy failed but shouldn't have")
+			require.False(t, systemd.restarted, "ApplyPolicy should leave the restart of SSSD to RestartIfChanged")
+
+			m.RestartIfChanged(context.Background())
+			require.Equal(t, tc.wantRestart, systemd.restarted, "SSSD should be restarted only when its configuration changed")
+
+			// SSSD is only restarted once per change.
+			systemd.restarted = false
+			m.RestartIfChanged(context.Background())
+			require.False(t, systemd.restarted, "SSSD should not be restarted again without new change")
+
+			testutils.CompareTreesWithFiltering(t, root, testutils.GoldenPath(t), testutils.Update())
+		})
+	}
+}
+
+type mockSystemdCaller struct {
+	testutils.MockSystemdCaller
+
+	restartError bool
+	restarted    bool
+	mu           sync.Mutex
+}
+
+func (s *mockSystemdCaller) RestartUnit(_ context.Context, unit string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if unit != sssd.SSSDUnit {
+		return errors.New("unexpected unit restarted")
+	}
+	s.restarted = true
+	if s.restartError {
+		return errors.New("restart error requested")
+	}
+	return nil
+}
+
+func TestMain(m *testing.M) {
+	testutils.InstallUpdateFlag()
+	flag.Parse()
+
+	m.Run()
+}
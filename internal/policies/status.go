@@ -0,0 +1,531 @@
+package policies
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	log "github.com/ubuntu/adsys/internal/grpc/logstreamer"
+	"github.com/ubuntu/adsys/internal/i18n"
+	"github.com/ubuntu/adsys/internal/policies/entry"
+	"github.com/ubuntu/adsys/internal/policies/gpp"
+	"github.com/ubuntu/decorate"
+	"gopkg.in/yaml.v3"
+)
+
+// managerStatus is the result of applying the policy of one policy manager.
+// Entries is the number of entries handled by the policy manager and Size the total size in bytes of their keys
+// and values. Files is the number of files the policy manager wrote for the object and FilesSize their total size in
+// bytes.
+// Failures is the number of consecutive applies which failed, and LastKnownGood is set when the policy manager
+// re-activated the last policy it applied successfully after failing.
+// Conflicts are the entries which were not applied, as they edit files managed by other policy managers.
+type managerStatus struct {
+	Manager       string         `json:"manager" yaml:"manager"`
+	Error         string         `json:"error,omitempty" yaml:"error,omitempty"`
+	Skipped       bool           `json:"skipped,omitempty" yaml:"skipped,omitempty"`
+	Entries       int            `json:"entries" yaml:"entries,omitempty"`
+	Size          int            `json:"size" yaml:"size,omitempty"`
+	Files         int            `json:"files" yaml:"files,omitempty"`
+	FilesSize     int64          `json:"files_size" yaml:"files-size,omitempty"`
+	Failures      int            `json:"failures,omitempty" yaml:"failures,omitempty"`
+	LastKnownGood bool           `json:"last_known_good,omitempty" yaml:"last-known-good,omitempty"`
+	Conflicts     []gpp.Conflict `json:"conflicts,omitempty" yaml:"conflicts,omitempty"`
+}
+
+// drasticChangeMinEntries is the minimum number of entries, or of files, a policy manager had in the previous apply
+// to warn about a drastic change of their number.
+const drasticChangeMinEntries = 10
+
+// applyStatus collects the result of each policy manager during a policy apply.
+// It is safe for concurrent use.
+type applyStatus struct {
+	mu        sync.Mutex
+	managers  []managerStatus
+	errs      []error
+	conflicts map[string][]gpp.Conflict
+}
+
+// set records the result of applying the policy of manager. A nil err marks it as successful.
+func (s *applyStatus) set(manager string, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	st := managerStatus{Manager: manager, Conflicts: s.conflicts[manager]}
+	if err != nil {
+		st.Error = err.Error()
+		s.errs = append(s.errs, err)
+	}
+	s.managers = append(s.managers, st)
+}
+
+// conflict records the entries of manager which are not applied, as they edit files managed by other policy
+// managers. It must be called before set.
+func (s *applyStatus) conflict(manager string, conflicts []gpp.Conflict) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(conflicts) == 0 {
+		return
+	}
+	if s.conflicts == nil {
+		s.conflicts = make(map[string][]gpp.Conflict)
+	}
+	s.conflicts[manager] = conflicts
+}
+
+// skip records that the policy of manager was not applied, as it is not supported on this system.
+func (s *applyStatus) skip(manager string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.managers = append(s.managers, managerStatus{Manager: manager, Skipped: true})
+}
+
+// keep records for manager, which is not applied this time, the result of its previous apply.
+// Its number of entries and of files are counted again.
+func (s *applyStatus) keep(manager string, previous []managerStatus) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, st := range previous {
+		if st.Manager == manager {
+			s.managers = append(s.managers, managerStatus{Manager: manager, Error: st.Error, Skipped: st.Skipped,
+				Failures: st.Failures, LastKnownGood: st.LastKnownGood, Conflicts: st.Conflicts})
+			return
+		}
+	}
+}
+
+// failed returns if applying the policy of manager failed.
+func (s *applyStatus) failed(manager string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, st := range s.managers {
+		if st.Manager == manager {
+			return st.Error != ""
+		}
+	}
+	return false
+}
+
+// countFailures records for each policy manager which failed its number of consecutive failed applies, including the
+// previous ones.
+func (s *applyStatus) countFailures(previous []managerStatus) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i, st := range s.managers {
+		if st.Error == "" || st.Failures > 0 {
+			continue
+		}
+		s.managers[i].Failures = 1
+		for _, prev := range previous {
+			if prev.Manager == st.Manager && prev.Error != "" {
+				s.managers[i].Failures = prev.Failures + 1
+			}
+		}
+	}
+}
+
+// failures returns the number of consecutive failed applies of manager.
+func (s *applyStatus) failures(manager string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, st := range s.managers {
+		if st.Manager == manager {
+			return st.Failures
+		}
+	}
+	return 0
+}
+
+// revert records that manager re-activated its last known good policy after failing.
+func (s *applyStatus) revert(manager string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i, st := range s.managers {
+		if st.Manager == manager {
+			s.managers[i].LastKnownGood = true
+		}
+	}
+}
+
+// reverted returns if manager re-activated its last known good policy after failing.
+func (s *applyStatus) reverted(manager string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, st := range s.managers {
+		if st.Manager == manager {
+			return st.LastKnownGood
+		}
+	}
+	return false
+}
+
+// failedManagers returns the policy managers which failed, in their order of application.
+func (s *applyStatus) failedManagers() (managers []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, name := range statusManagersOrder {
+		for _, st := range s.managers {
+			if st.Manager == name && st.Error != "" {
+				managers = append(managers, name)
+			}
+		}
+	}
+	return managers
+}
+
+// count records the number of entries of rules handled by each applied policy manager and their size.
+// Plugins handle the entries which are not handled by any built-in policy manager.
+func (s *applyStatus) count(rules map[string][]entry.Entry) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i, st := range s.managers {
+		if st.Skipped {
+			continue
+		}
+		for key, entries := range rules {
+			if managerForRulesKey(key) != st.Manager {
+				continue
+			}
+			s.managers[i].Entries += len(entries)
+			for _, e := range entries {
+				s.managers[i].Size += len(e.Key) + len(e.Value)
+			}
+		}
+	}
+}
+
+// countFiles records the number of files written by each applied policy manager and their total size.
+// Files which can't be read anymore are not counted.
+func (s *applyStatus) countFiles(files map[string][]string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i, st := range s.managers {
+		if st.Skipped {
+			continue
+		}
+		for _, p := range files[st.Manager] {
+			info, err := os.Stat(p)
+			if err != nil {
+				continue
+			}
+			s.managers[i].Files++
+			s.managers[i].FilesSize += info.Size()
+		}
+	}
+}
+
+// managerForRulesKey returns the policy manager handling the entries of the rules key.
+func managerForRulesKey(key string) string {
+	switch key {
+	case "dconf-preferences":
+		return "dconf"
+	case "dconf", "privilege", "scripts", "mount", "apparmor", "proxy", "gpp", "environment", "sssd", "netplan", "journald", "laps", "session", "gdm":
+		return key
+	default:
+		return "plugins"
+	}
+}
+
+// warnDrasticChanges logs a warning for each policy manager of objectName whose number of entries or of files is
+// more than halved or doubled compared to previous, as it is often due to a misconfigured GPO.
+func (s *applyStatus) warnDrasticChanges(ctx context.Context, objectName string, previous []managerStatus) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	drastic := func(n, prev int) bool {
+		return prev >= drasticChangeMinEntries && (n*2 < prev || n > prev*2)
+	}
+	for _, st := range s.managers {
+		for _, prev := range previous {
+			if prev.Manager != st.Manager || prev.Skipped || st.Skipped {
+				continue
+			}
+			if drastic(st.Entries, prev.Entries) {
+				log.Warningf(ctx, i18n.G("Policy manager %s now handles %d entries for %s, compared to %d on the previous apply: check the GPOs for a misconfiguration"),
+					st.Manager, st.Entries, objectName, prev.Entries)
+			}
+			if drastic(st.Files, prev.Files) {
+				log.Warningf(ctx, i18n.G("Policy manager %s now writes %d files for %s, compared to %d on the previous apply: check the GPOs for a misconfiguration"),
+					st.Manager, st.Files, objectName, prev.Files)
+			}
+		}
+	}
+}
+
+// err returns all the errors of the failing policy managers, or nil if all of them succeeded.
+func (s *applyStatus) err() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return errors.Join(s.errs...)
+}
+
+// save writes the status of each policy manager, sorted in their order of application, to path.
+func (s *applyStatus) save(path string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	managers := make([]managerStatus, 0, len(s.managers))
+	for _, name := range statusManagersOrder {
+		for _, st := range s.managers {
+			if st.Manager == name {
+				managers = append(managers, st)
+			}
+		}
+	}
+
+	d, err := yaml.Marshal(managers)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	if err := os.WriteFile(path+".new", d, 0600); err != nil {
+		return err
+	}
+	return os.Rename(path+".new", path)
+}
+
+// statusManagersOrder is the order in which the policy managers status are reported.
+var statusManagersOrder = []string{"dconf", "privilege", "scripts", "mount", "apparmor", "proxy", "gpp", "environment", "sssd", "netplan", "journald", "laps", "session", "plugins", "gdm"}
+
+// LastApplyStatus returns the status of each policy manager during the last policy apply of objectName, and of the
+// machine if computerOnly is false.
+// Policy managers are applied independently, so that the status lists which ones succeeded when the apply failed.
+// The entries not applied as they conflict with other policy managers, and the compliance with the report-only
+// entries, if any, are listed after them, followed for the machine by the last rotation of the managed local account
+// password.
+func (m *Manager) LastApplyStatus(ctx context.Context, objectName string, computerOnly bool) (msg string, err error) {
+	defer decorate.OnError(&err, i18n.G("failed to get last policy apply status for %q"), objectName)
+
+	log.Infof(ctx, "Get last policy apply status for %s", objectName)
+
+	objects := []string{objectName}
+	if !computerOnly {
+		objects = []string{m.hostname, objectName}
+	}
+
+	var out strings.Builder
+	for i, object := range objects {
+		p := m.objectPath(statusCacheBaseName, object)
+		info, err := os.Stat(p)
+		if err != nil {
+			return "", fmt.Errorf(i18n.G("no policy apply status for %q: %v"), object, err)
+		}
+		managers, err := loadStatus(p)
+		if err != nil {
+			return "", fmt.Errorf(i18n.G("invalid policy apply status for %q: %v"), object, err)
+		}
+
+		result := i18n.G("succeeded")
+		for _, st := range managers {
+			if st.Error != "" {
+				result = i18n.G("failed")
+				break
+			}
+		}
+
+		if i > 0 {
+			out.WriteString("\n")
+		}
+		fmt.Fprintf(&out, i18n.G("Last policy apply for %s on %s: %s\n"), object, info.ModTime().Format(time.RFC3339), result)
+		w := tabwriter.NewWriter(&out, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, i18n.G("MANAGER\tENTRIES\tSIZE\tFILES\tFILES SIZE\tSTATUS"))
+		for _, st := range managers {
+			status := i18n.G("ok")
+			if st.Skipped {
+				status = i18n.G("skipped: not supported on this system")
+			} else if st.Error != "" {
+				// Keep errors from managers failing in multiple ways on a single line.
+				status = fmt.Sprintf(i18n.G("failed: %s"), strings.ReplaceAll(st.Error, "\n", "; "))
+				if st.Failures > 1 {
+					status = fmt.Sprintf(i18n.G("failed %d times in a row: %s"), st.Failures, strings.ReplaceAll(st.Error, "\n", "; "))
+				}
+				if st.LastKnownGood {
+					status += i18n.G(" (last known good policy re-activated)")
+				}
+			}
+			fmt.Fprintf(w, "%s\t%d\t%d\t%d\t%d\t%s\n", st.Manager, st.Entries, st.Size, st.Files, st.FilesSize, status)
+		}
+		if err := w.Flush(); err != nil {
+			return "", err
+		}
+
+		var conflicts []string
+		for _, st := range managers {
+			for _, c := range st.Conflicts {
+				conflicts = append(conflicts, fmt.Sprintf(i18n.G("  %s: dropped %s item on %s, managed by the %s policy\n"), st.Manager, c.Dropped, c.Path, c.Kept))
+			}
+		}
+		if len(conflicts) > 0 {
+			out.WriteString(i18n.G("Policy conflicts:\n") + strings.Join(conflicts, ""))
+		}
+
+		reportOnly, err := m.reportOnlyStatus(object)
+		if err != nil {
+			return "", fmt.Errorf(i18n.G("invalid report-only entries compliance for %q: %v"), object, err)
+		}
+		out.WriteString(reportOnly)
+
+		if object == m.hostname {
+			rotation, err := m.laps.Status()
+			if err != nil {
+				return "", err
+			}
+			out.WriteString(rotation)
+		}
+	}
+
+	return out.String(), nil
+}
+
+// objectApplyStatus is the structured status of the last policy apply of an object.
+type objectApplyStatus struct {
+	Object    string          `json:"object" yaml:"object"`
+	Time      time.Time       `json:"time" yaml:"time"`
+	Succeeded bool            `json:"succeeded" yaml:"succeeded"`
+	Managers  []managerStatus `json:"managers" yaml:"managers"`
+}
+
+// LastApplyStatusStructured returns the status of each policy manager during the last policy apply of objectName,
+// and of the machine if computerOnly is false, in the given machine readable format.
+func (m *Manager) LastApplyStatusStructured(ctx context.Context, objectName string, computerOnly bool, format string) (msg string, err error) {
+	defer decorate.OnError(&err, i18n.G("failed to get last policy apply status for %q"), objectName)
+
+	log.Infof(ctx, "Get last policy apply status for %s in %s format", objectName, format)
+
+	if format != FormatJSON && format != FormatYAML {
+		return "", fmt.Errorf(i18n.G("unsupported format %q"), format)
+	}
+
+	objects := []string{objectName}
+	if !computerOnly {
+		objects = []string{m.hostname, objectName}
+	}
+
+	statuses := make([]objectApplyStatus, 0, len(objects))
+	for _, object := range objects {
+		p := m.objectPath(statusCacheBaseName, object)
+		info, err := os.Stat(p)
+		if err != nil {
+			return "", fmt.Errorf(i18n.G("no policy apply status for %q: %v"), object, err)
+		}
+		managers, err := loadStatus(p)
+		if err != nil {
+			return "", fmt.Errorf(i18n.G("invalid policy apply status for %q: %v"), object, err)
+		}
+		st := objectApplyStatus{Object: object, Time: info.ModTime(), Succeeded: true, Managers: managers}
+		for _, ms := range managers {
+			if ms.Error != "" {
+				st.Succeeded = false
+			}
+		}
+		statuses = append(statuses, st)
+	}
+
+	var d []byte
+	switch format {
+	case FormatJSON:
+		d, err = json.MarshalIndent(statuses, "", "  ")
+		d = append(d, '\n')
+	case FormatYAML:
+		d, err = yaml.Marshal(statuses)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	return string(d), nil
+}
+
+// loadStatus returns the status of each policy manager saved in path.
+func loadStatus(path string) (managers []managerStatus, err error) {
+	d, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if err := yaml.Unmarshal(d, &managers); err != nil {
+		return nil, err
+	}
+	return managers, nil
+}
+
+// UpdateState is the state of the policy updates of the machine or of a user.
+type UpdateState struct {
+	// LastUpdate is when the policies were last applied successfully. It is zero if they never were.
+	LastUpdate time.Time
+	// LastApply is when the policies were last applied, whether it succeeded or not. It is zero if they never were.
+	LastApply time.Time
+	// Failed is true if any policy manager failed during the last apply.
+	Failed bool
+}
+
+// UpdateStates returns the update state of the machine and of each user with cached policies or a last apply
+// status, indexed by user name.
+func (m *Manager) UpdateStates(ctx context.Context) (machine UpdateState, users map[string]UpdateState, err error) {
+	defer decorate.OnError(&err, i18n.G("failed to get policy update states"))
+
+	log.Debug(ctx, "Get policy update states")
+
+	users = make(map[string]UpdateState)
+	for _, kind := range []string{PoliciesCacheBaseName, statusCacheBaseName} {
+		objects, err := CachedObjects(m.cacheDir, kind)
+		if err != nil {
+			return UpdateState{}, nil, err
+		}
+		for _, object := range objects {
+			if object == m.hostname {
+				continue
+			}
+			users[object] = m.updateState(ctx, object)
+		}
+	}
+
+	return m.updateState(ctx, m.hostname), users, nil
+}
+
+// updateState returns the update state of objectName. Invalid status files are reported as failed applies.
+func (m *Manager) updateState(ctx context.Context, objectName string) (s UpdateState) {
+	if info, err := os.Stat(m.objectPath(PoliciesCacheBaseName, objectName)); err == nil {
+		s.LastUpdate = info.ModTime()
+	}
+
+	p := m.objectPath(statusCacheBaseName, objectName)
+	info, err := os.Stat(p)
+	if err != nil {
+		return s
+	}
+	s.LastApply = info.ModTime()
+	managers, err := loadStatus(p)
+	if err != nil {
+		log.Warningf(ctx, i18n.G("Invalid policy apply status for %q: %v"), objectName, err)
+		s.Failed = true
+		return s
+	}
+	for _, st := range managers {
+		if st.Error != "" {
+			s.Failed = true
+			break
+		}
+	}
+	return s
+}
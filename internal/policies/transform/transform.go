@@ -0,0 +1,175 @@
+// Package transform rewrites resolved policy entries with site-local rules before policy managers apply them.
+//
+// Transformation rules are read from YAML files in the transforms directory (/etc/adsys/transforms.d by default),
+// in lexical order of the file names. Each file contains a list of rules, applied in order:
+//
+//   - type: dconf                        # policy type to match. Any type if empty.
+//     key: org/gnome/desktop/lockdown/.* # regular expression matching the whole entry key. Any key if empty.
+//     object: .*@example\.com            # regular expression matching the whole computer or user name. Any if empty.
+//     action: set                        # one of drop, set, disable or enable.
+//     value: "false"                     # new value of the entry, only for the set action.
+//
+// Rules only rewrite or drop entries resolved from the GPOs: they never create new entries. This is meant for
+// emergency mitigations on a machine, when a bad GPO can't be fixed centrally fast enough. The GPO cache is not
+// modified and still reflects the content of the Active Directory.
+package transform
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	log "github.com/ubuntu/adsys/internal/grpc/logstreamer"
+	"github.com/ubuntu/adsys/internal/i18n"
+	"github.com/ubuntu/adsys/internal/policies/entry"
+	"github.com/ubuntu/decorate"
+	"gopkg.in/yaml.v3"
+)
+
+// Supported rule actions.
+const (
+	ActionDrop    = "drop"
+	ActionSet     = "set"
+	ActionDisable = "disable"
+	ActionEnable  = "enable"
+)
+
+// Rule is a transformation rule, matching entries of a given policy type.
+type Rule struct {
+	Type   string
+	Key    string
+	Object string
+	Action string
+	Value  *string
+
+	origin   string
+	keyRe    *regexp.Regexp
+	objectRe *regexp.Regexp
+}
+
+// Rules is an ordered list of transformation rules.
+type Rules []Rule
+
+// Load reads all transformation rules from the yaml files in dir. A missing directory has no rule.
+func Load(dir string) (rules Rules, err error) {
+	defer decorate.OnError(&err, i18n.G("can't load transformation rules from %s"), dir)
+
+	files, err := filepath.Glob(filepath.Join(dir, "*.yaml"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(files)
+
+	for _, f := range files {
+		d, err := os.ReadFile(f)
+		if err != nil {
+			return nil, err
+		}
+
+		var fileRules []Rule
+		dec := yaml.NewDecoder(bytes.NewReader(d))
+		// Catch typos in rules, which could otherwise match more entries than expected.
+		dec.KnownFields(true)
+		if err := dec.Decode(&fileRules); err != nil && !errors.Is(err, io.EOF) {
+			return nil, fmt.Errorf(i18n.G("%s: %v"), filepath.Base(f), err)
+		}
+		for i, r := range fileRules {
+			r.origin = fmt.Sprintf("%s:%d", filepath.Base(f), i+1)
+			if err := r.compile(); err != nil {
+				return nil, fmt.Errorf(i18n.G("rule %s: %v"), r.origin, err)
+			}
+			rules = append(rules, r)
+		}
+	}
+
+	return rules, nil
+}
+
+// compile validates the rule and prepares its regular expressions.
+func (r *Rule) compile() (err error) {
+	switch r.Action {
+	case ActionSet:
+		if r.Value == nil {
+			return errors.New(i18n.G("set action requires a value"))
+		}
+	case ActionDrop, ActionDisable, ActionEnable:
+		if r.Value != nil {
+			return fmt.Errorf(i18n.G("%s action can't have a value"), r.Action)
+		}
+	default:
+		return fmt.Errorf(i18n.G("unknown action %q"), r.Action)
+	}
+
+	if r.keyRe, err = compileAnchored(r.Key); err != nil {
+		return err
+	}
+	if r.objectRe, err = compileAnchored(r.Object); err != nil {
+		return err
+	}
+	return nil
+}
+
+// compileAnchored compiles expr to match a whole string. An empty expression matches anything.
+func compileAnchored(expr string) (*regexp.Regexp, error) {
+	if expr == "" {
+		return nil, nil
+	}
+	return regexp.Compile("^(?:" + expr + ")$")
+}
+
+// Apply transforms in place the rules of objectName. Policy types with no entry left are removed.
+func (rules Rules) Apply(ctx context.Context, objectName string, entries map[string][]entry.Entry) {
+	if len(rules) == 0 {
+		return
+	}
+
+	for t, es := range entries {
+		var kept []entry.Entry
+	nextEntry:
+		for _, e := range es {
+			for _, r := range rules {
+				if !r.match(objectName, t, e.Key) {
+					continue
+				}
+				log.Infof(ctx, i18n.G("Transformation rule %s: %s %s entry %q for %s"), r.origin, r.Action, t, e.Key, objectName)
+				switch r.Action {
+				case ActionDrop:
+					continue nextEntry
+				case ActionSet:
+					e.Value = *r.Value
+				case ActionDisable:
+					e.Disabled = true
+				case ActionEnable:
+					e.Disabled = false
+				}
+			}
+			kept = append(kept, e)
+		}
+
+		if len(kept) == 0 {
+			delete(entries, t)
+			continue
+		}
+		entries[t] = kept
+	}
+}
+
+func (r Rule) match(objectName, policyType, key string) bool {
+	if r.Type != "" && !strings.EqualFold(r.Type, policyType) {
+		return false
+	}
+	if r.keyRe != nil && !r.keyRe.MatchString(key) {
+		return false
+	}
+	if r.objectRe != nil && !r.objectRe.MatchString(objectName) {
+		return false
+	}
+	return true
+}
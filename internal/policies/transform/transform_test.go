@@ -0,0 +1,86 @@
+package transform_test
+
+import (
+	"context"
+	"flag"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/ubuntu/adsys/internal/policies/entry"
+	"github.com/ubuntu/adsys/internal/policies/transform"
+	"github.com/ubuntu/adsys/internal/testutils"
+)
+
+func TestApply(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		transformsDir string
+		objectName    string
+
+		wantLoadErr bool
+	}{
+		"Missing directory does not change entries": {transformsDir: "does-not-exist"},
+		"Empty directory does not change entries":   {transformsDir: "empty-dir"},
+		"Empty file does not change entries":        {transformsDir: "empty-file"},
+		"Drop entries":                              {transformsDir: "drop"},
+		"Set entry value":                           {transformsDir: "set"},
+		"Set entry to an empty value":               {transformsDir: "set-empty-value"},
+		"Disable and enable entries":                {transformsDir: "disable-enable"},
+		"Rule without type matches any type":        {transformsDir: "any-type"},
+		"Type match is case insensitive":            {transformsDir: "type-is-case-insensitive"},
+		"Rule for a matching object":                {transformsDir: "matching-object"},
+		"Rule for another object is ignored":        {transformsDir: "non-matching-object"},
+		"Key must match fully":                      {transformsDir: "key-partial-match-does-not-match"},
+		"Dropping all entries of a type removes it": {transformsDir: "drop-all-entries-of-type"},
+		"Files are applied in order":                {transformsDir: "files-in-order"},
+
+		// Error cases
+		"Error on invalid yaml":           {transformsDir: "invalid-yaml", wantLoadErr: true},
+		"Error on unknown field":          {transformsDir: "unknown-field", wantLoadErr: true},
+		"Error on unknown action":         {transformsDir: "unknown-action", wantLoadErr: true},
+		"Error on set without value":      {transformsDir: "set-without-value", wantLoadErr: true},
+		"Error on drop with a value":      {transformsDir: "drop-with-value", wantLoadErr: true},
+		"Error on invalid key regexp":     {transformsDir: "invalid-key-regexp", wantLoadErr: true},
+		"Error on invalid object regexp":  {transformsDir: "invalid-object-regexp", wantLoadErr: true},
+		"Error on unreadable directories": {transformsDir: "[", wantLoadErr: true},
+	}
+	for name, tc := range tests {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			rules, err := transform.Load(filepath.Join("testdata", tc.transformsDir))
+			if tc.wantLoadErr {
+				require.Error(t, err, "Load should have failed but didn't")
+				return
+			}
+			require.NoError(t, err, "Load failed but shouldn't have")
+
+			entries := map[string][]entry.Entry{
+				"dconf": {
+					{Key: "org/gnome/desktop/interface/clock-format", Value: "'24h'", Meta: "s"},
+					{Key: "org/gnome/desktop/screensaver/idle-activation-enabled", Value: "false", Meta: "b"},
+					{Key: "org/gnome/desktop/screensaver/lock-delay", Value: "60", Meta: "u"},
+					{Key: "org/gnome/desktop/background/picture-uri", Disabled: true},
+				},
+				"privilege": {
+					{Key: "allow-local-admins", Value: "", Disabled: false},
+				},
+			}
+
+			rules.Apply(context.Background(), "user@example.com", entries)
+
+			want := testutils.LoadWithUpdateFromGoldenYAML(t, entries)
+			require.Equal(t, want, entries, "Apply returned unexpected entries")
+		})
+	}
+}
+
+func TestMain(m *testing.M) {
+	testutils.InstallUpdateFlag()
+	flag.Parse()
+
+	m.Run()
+}
@@ -0,0 +1,143 @@
+package policies
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+
+	log "github.com/ubuntu/adsys/internal/grpc/logstreamer"
+	"github.com/ubuntu/adsys/internal/i18n"
+	"github.com/ubuntu/adsys/internal/policies/entry"
+	"github.com/ubuntu/decorate"
+	"golang.org/x/exp/slices"
+)
+
+// UnhandledEntriesFileName is the file, in the run directory, where the entries of the policy types handled by
+// neither adsys nor a plugin are stored, when enabled.
+// It is a JSON object indexed by object name, then by policy type, listing the entries as sent to plugins.
+const UnhandledEntriesFileName = "unhandled.json"
+
+// unhandledEntries stores the entries of the policy types handled by no policy manager, so that administrators can see
+// the policies ignored on the client and plugin authors can pick them up.
+type unhandledEntries struct {
+	path string
+	mu   sync.Mutex
+}
+
+// WithUnhandledEntries stores the entries of the policy types handled by neither adsys nor a plugin in
+// UnhandledEntriesFileName, in the run directory.
+func WithUnhandledEntries(enabled bool) Option {
+	return func(o *options) error {
+		o.unhandledEntries = enabled
+		return nil
+	}
+}
+
+// unhandledRules returns the rules of the policy types handled by neither adsys nor the installed plugins.
+func (m *Manager) unhandledRules(ctx context.Context, rules map[string][]entry.Entry) (unhandled map[string][]entry.Entry, err error) {
+	plugins, err := m.plugins.Names(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	unhandled = make(map[string][]entry.Entry)
+	for t, entries := range rules {
+		if len(entries) == 0 || slices.Contains(builtinRules, t) || slices.Contains(plugins, t) {
+			continue
+		}
+		unhandled[t] = entries
+	}
+	return unhandled, nil
+}
+
+// saveUnhandled records the unhandled rules of objectName, replacing the ones of its previous apply.
+func (m *Manager) saveUnhandled(ctx context.Context, objectName string, rules map[string][]entry.Entry) (err error) {
+	defer decorate.OnError(&err, i18n.G("can't save unhandled entries of %s"), objectName)
+
+	unhandled, err := m.unhandledRules(ctx, rules)
+	if err != nil {
+		return err
+	}
+	if len(unhandled) > 0 {
+		var types []string
+		for t := range unhandled {
+			types = append(types, t)
+		}
+		sort.Strings(types)
+		log.Infof(ctx, i18n.G("Entries of %s not handled by any policy manager nor plugin are stored in %s: %s"),
+			objectName, m.unhandled.path, strings.Join(types, ", "))
+	}
+
+	m.unhandled.mu.Lock()
+	defer m.unhandled.mu.Unlock()
+
+	all, err := m.unhandled.load()
+	if err != nil {
+		return err
+	}
+	if len(unhandled) > 0 {
+		all[objectName] = unhandled
+	} else {
+		delete(all, objectName)
+	}
+
+	if len(all) == 0 {
+		if err := os.Remove(m.unhandled.path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+		return nil
+	}
+	d, err := json.MarshalIndent(all, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(m.unhandled.path+".new", d, 0600); err != nil {
+		return err
+	}
+	return os.Rename(m.unhandled.path+".new", m.unhandled.path)
+}
+
+// load returns the stored unhandled rules, indexed by object name. A missing file has no unhandled rules.
+func (u *unhandledEntries) load() (map[string]map[string][]entry.Entry, error) {
+	all := make(map[string]map[string][]entry.Entry)
+	d, err := os.ReadFile(u.path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return all, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(d, &all); err != nil {
+		return nil, err
+	}
+	return all, nil
+}
+
+// UnhandledEntriesCount returns the number of entries of objectName handled by neither adsys nor a plugin during its
+// last apply. It is always 0 if unhandled entries are not stored.
+func (m *Manager) UnhandledEntriesCount(objectName string, isMachine bool) (n int, err error) {
+	defer decorate.OnError(&err, i18n.G("can't count unhandled entries of %s"), objectName)
+
+	if m.unhandled == nil {
+		return 0, nil
+	}
+	if isMachine {
+		objectName = m.hostname
+	}
+
+	m.unhandled.mu.Lock()
+	defer m.unhandled.mu.Unlock()
+
+	all, err := m.unhandled.load()
+	if err != nil {
+		return 0, err
+	}
+	for _, entries := range all[objectName] {
+		n += len(entries)
+	}
+	return n, nil
+}
@@ -0,0 +1,153 @@
+package policies
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/ubuntu/adsys/internal/grpc/logstreamer"
+	"github.com/ubuntu/adsys/internal/i18n"
+)
+
+// retrievalFailedCacheBaseName is the directory, in the cache directory, marking the objects whose policies couldn't
+// be retrieved since their last apply. The time of the failure is the modification time of the marker.
+const retrievalFailedCacheBaseName = "retrieval-failed"
+
+// sdNotifier sends a state notification to systemd, as daemon.SdNotify does.
+type sdNotifier func(unsetEnvironment bool, state string) (bool, error)
+
+// unitStatus reports the result of the last policy refreshes as the status text of the daemon unit, so that
+// systemctl status tells if the machine is compliant.
+// The status is the result of the last machine refresh, followed by the users whose last refresh failed.
+type unitStatus struct {
+	notify sdNotifier
+
+	mu      sync.Mutex
+	machine string
+	// failedUsers are the policy managers which failed on the last refresh of each user.
+	failedUsers map[string][]string
+}
+
+// newUnitStatus returns a unit status reporter sending its status with notify.
+func newUnitStatus(notify sdNotifier) *unitStatus {
+	return &unitStatus{
+		notify:      notify,
+		failedUsers: make(map[string][]string),
+	}
+}
+
+// update records the result of the refresh of objectName, done at t, where the failed policy managers failed, and
+// sends the new status to systemd.
+// The status is only informative: failing to send it is logged but doesn't fail the refresh.
+func (u *unitStatus) update(ctx context.Context, objectName string, isComputer bool, failed []string, t time.Time) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	u.set(objectName, isComputer, failed, t)
+	u.send(ctx)
+}
+
+// set records the result of the refresh of objectName, done at t, where the failed policy managers failed.
+func (u *unitStatus) set(objectName string, isComputer bool, failed []string, t time.Time) {
+	switch {
+	case isComputer && len(failed) == 0:
+		u.machine = fmt.Sprintf(i18n.G("Last refresh OK at %s"), t.Format(time.RFC3339))
+	case isComputer:
+		u.machine = fmt.Sprintf(i18n.G("Last refresh failed at %s: %s failed"), t.Format(time.RFC3339), strings.Join(failed, ", "))
+	case len(failed) == 0:
+		delete(u.failedUsers, objectName)
+	default:
+		u.failedUsers[objectName] = failed
+	}
+}
+
+// send sends the status to systemd.
+func (u *unitStatus) send(ctx context.Context) {
+	var parts []string
+	if u.machine != "" {
+		parts = append(parts, u.machine)
+	}
+	if len(u.failedUsers) > 0 {
+		var users []string
+		for user, managers := range u.failedUsers {
+			users = append(users, fmt.Sprintf("%s (%s)", user, strings.Join(managers, ", ")))
+		}
+		sort.Strings(users)
+		parts = append(parts, fmt.Sprintf(i18n.G("last refresh failed for %s"), strings.Join(users, ", ")))
+	}
+
+	if _, err := u.notify(false, "STATUS="+strings.Join(parts, "; ")); err != nil {
+		log.Warningf(ctx, i18n.G("Couldn't update the unit status: %v"), err)
+	}
+}
+
+// restoreUnitStatus sends again the unit status of the previous daemon, from the last apply status and policy
+// retrieval failure of each object, as the daemon exits when idle.
+func (m *Manager) restoreUnitStatus(ctx context.Context) {
+	var objects []string
+	for _, kind := range []string{statusCacheBaseName, retrievalFailedCacheBaseName} {
+		o, err := CachedObjects(m.cacheDir, kind)
+		if err != nil {
+			log.Warningf(ctx, i18n.G("Can't restore the unit status: %v"), err)
+			return
+		}
+		objects = append(objects, o...)
+	}
+	if len(objects) == 0 {
+		return
+	}
+
+	m.unitStatus.mu.Lock()
+	defer m.unitStatus.mu.Unlock()
+
+	for _, objectName := range objects {
+		var failed []string
+		var t time.Time
+		if info, err := os.Stat(m.objectPath(statusCacheBaseName, objectName)); err == nil {
+			t = info.ModTime()
+			managers, err := loadStatus(m.objectPath(statusCacheBaseName, objectName))
+			if err != nil {
+				log.Warningf(ctx, i18n.G("Invalid policy apply status for %q: %v"), objectName, err)
+				continue
+			}
+			for _, st := range managers {
+				if st.Error != "" {
+					failed = append(failed, st.Manager)
+				}
+			}
+		}
+		if info, err := os.Stat(m.objectPath(retrievalFailedCacheBaseName, objectName)); err == nil && info.ModTime().After(t) {
+			t = info.ModTime()
+			failed = []string{i18n.G("policy retrieval")}
+		}
+		m.unitStatus.set(objectName, objectName == m.hostname, failed, t)
+	}
+	m.unitStatus.send(ctx)
+}
+
+// ReportRetrievalFailure reports in the daemon unit status that the policies of objectName couldn't be retrieved
+// from the directory service, as they are then not applied.
+// The failure is recorded until the next apply, so that a new daemon reports it too.
+func (m *Manager) ReportRetrievalFailure(ctx context.Context, objectName string, isComputer bool) {
+	p := m.objectPath(retrievalFailedCacheBaseName, objectName)
+	if err := os.MkdirAll(filepath.Dir(p), 0700); err != nil {
+		log.Warningf(ctx, i18n.G("Can't record the policy retrieval failure of %s: %v"), objectName, err)
+	} else if err := os.WriteFile(p, nil, 0600); err != nil {
+		log.Warningf(ctx, i18n.G("Can't record the policy retrieval failure of %s: %v"), objectName, err)
+	}
+	m.unitStatus.update(ctx, objectName, isComputer, []string{i18n.G("policy retrieval")}, time.Now())
+}
+
+// clearRetrievalFailure removes the policy retrieval failure of objectName, once its policies are applied.
+func (m *Manager) clearRetrievalFailure(ctx context.Context, objectName string) {
+	if err := os.Remove(m.objectPath(retrievalFailedCacheBaseName, objectName)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		log.Warningf(ctx, i18n.G("Can't remove the policy retrieval failure of %s: %v"), objectName, err)
+	}
+}
@@ -0,0 +1,6 @@
+package refreshtrigger
+
+// Sign returns the hexadecimal signature of payload with key, to forge messages in tests.
+func Sign(key []byte, payload string) string {
+	return sign(key, payload)
+}
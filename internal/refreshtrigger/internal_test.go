@@ -0,0 +1,44 @@
+package refreshtrigger
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestWarningLimiter(t *testing.T) {
+	t.Parallel()
+
+	start := time.Now()
+
+	tests := map[string]struct {
+		warnings []time.Duration
+
+		wantAllowed    []bool
+		wantSuppressed int
+	}{
+		"First warning is logged":                       {warnings: []time.Duration{0}, wantAllowed: []bool{true}},
+		"Warnings within the interval are suppressed":   {warnings: []time.Duration{0, time.Second, 59 * time.Second}, wantAllowed: []bool{true, false, false}},
+		"Warning after the interval is logged":          {warnings: []time.Duration{0, time.Minute}, wantAllowed: []bool{true, true}},
+		"Warning after the interval reports suppressed": {warnings: []time.Duration{0, time.Second, 2 * time.Second, time.Minute}, wantAllowed: []bool{true, false, false, true}, wantSuppressed: 2},
+	}
+	for name, tc := range tests {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			l := warningLimiter{interval: time.Minute}
+			var gotAllowed []bool
+			var gotSuppressed int
+			for _, w := range tc.warnings {
+				suppressed, ok := l.allow(start.Add(w))
+				gotAllowed = append(gotAllowed, ok)
+				gotSuppressed += suppressed
+			}
+
+			require.Equal(t, tc.wantAllowed, gotAllowed, "allow should only allow one warning per interval")
+			require.Equal(t, tc.wantSuppressed, gotSuppressed, "allow should report the warnings suppressed since the last one")
+		})
+	}
+}
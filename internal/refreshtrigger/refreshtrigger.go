@@ -0,0 +1,221 @@
+// Package refreshtrigger handles the signed messages asking a fleet of clients to refresh their policies now.
+//
+// A message is a single UDP datagram made of the "adsys-refresh" prefix, the time it was sent in nanoseconds since
+// the Unix epoch, the maximum random delay in seconds before refreshing and the hexadecimal HMAC-SHA256 of those 3
+// fields, separated by spaces and computed with a key shared by the sender and the clients.
+//
+// A client only accepts messages sent less than 5 minutes away from its own clock, and more recently than the last
+// accepted one, so that a captured message can't be replayed. The maximum delay can't exceed one hour.
+package refreshtrigger
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"math/rand"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/ubuntu/adsys/internal/grpc/logstreamer"
+	"github.com/ubuntu/adsys/internal/i18n"
+	"github.com/ubuntu/decorate"
+)
+
+// MaxDelay is the longest maximum delay before refreshing a message can ask for.
+const MaxDelay = time.Hour
+
+const (
+	// prefix starts every refresh message.
+	prefix = "adsys-refresh"
+	// maxClockSkew is the maximum difference between the sending time of a message and the time it is received.
+	maxClockSkew = 5 * time.Minute
+	// minKeySize is the minimum size in bytes of the shared key.
+	minKeySize = 16
+	// maxMessageSize is larger than any valid message.
+	maxMessageSize = 512
+	// ignoredWarningInterval is the minimum time between two warnings about ignored messages, so that a flood of
+	// invalid datagrams doesn't flood the journal.
+	ignoredWarningInterval = time.Minute
+)
+
+// LoadKey returns the shared key stored in path, without its surrounding whitespaces.
+func LoadKey(path string) (key []byte, err error) {
+	defer decorate.OnError(&err, i18n.G("can't load refresh trigger key"))
+
+	// #nosec G304 - the key path is set by the administrator.
+	d, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	key = []byte(strings.TrimSpace(string(d)))
+	if len(key) < minKeySize {
+		return nil, fmt.Errorf(i18n.G("key is shorter than %d bytes"), minKeySize)
+	}
+	return key, nil
+}
+
+// Message returns the message, signed with key, asking the clients to refresh their policies within maxDelay of
+// receiving it.
+func Message(key []byte, sentAt time.Time, maxDelay time.Duration) []byte {
+	payload := fmt.Sprintf("%s %d %d", prefix, sentAt.UnixNano(), int64(maxDelay/time.Second))
+	return []byte(fmt.Sprintf("%s %s", payload, sign(key, payload)))
+}
+
+// Verify checks that msg is signed with key and was sent around now. It returns the sending time and the maximum
+// delay of the message.
+func Verify(key, msg []byte, now time.Time) (sentAt time.Time, maxDelay time.Duration, err error) {
+	defer decorate.OnError(&err, i18n.G("invalid refresh message"))
+
+	fields := strings.Fields(string(msg))
+	if len(fields) != 4 || fields[0] != prefix {
+		return sentAt, 0, errors.New(i18n.G("unexpected format"))
+	}
+	payload := strings.Join(fields[:3], " ")
+	if !hmac.Equal([]byte(fields[3]), []byte(sign(key, payload))) {
+		return sentAt, 0, errors.New(i18n.G("wrong signature"))
+	}
+
+	nanos, err := strconv.ParseInt(fields[1], 10, 64)
+	if err != nil {
+		return sentAt, 0, fmt.Errorf(i18n.G("invalid sending time: %v"), err)
+	}
+	// The delay is checked in seconds, before its conversion to a duration can overflow.
+	delay, err := strconv.ParseInt(fields[2], 10, 64)
+	if err != nil || delay < 0 || delay > int64(MaxDelay/time.Second) {
+		return sentAt, 0, fmt.Errorf(i18n.G("invalid maximum delay %q: must be between 0 and %d seconds"), fields[2], int64(MaxDelay/time.Second))
+	}
+
+	sentAt = time.Unix(0, nanos)
+	if skew := now.Sub(sentAt); skew > maxClockSkew || skew < -maxClockSkew {
+		return sentAt, 0, fmt.Errorf(i18n.G("sent at %s, too far from current time"), sentAt.Format(time.RFC3339))
+	}
+
+	return sentAt, time.Duration(delay) * time.Second, nil
+}
+
+// sign returns the hexadecimal signature of payload with key.
+func sign(key []byte, payload string) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(payload))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Serve reads the refresh messages received on conn until none is received for idleTimeout.
+// refresh is called, after a random delay up to the maximum delay of the message, for each message signed with key
+// and sent after the last accepted one, whose sending time is stored in statePath.
+// Refreshes wait for their delay in the background, so that messages keep being read, and Serve only returns once
+// they are done. Invalid messages are only logged, at most once per minute.
+func Serve(ctx context.Context, conn net.PacketConn, key []byte, statePath string, idleTimeout time.Duration, refresh func(context.Context) error) (err error) {
+	defer decorate.OnError(&err, i18n.G("can't handle refresh messages"))
+
+	var refreshes sync.WaitGroup
+	defer refreshes.Wait()
+
+	warnings := warningLimiter{interval: ignoredWarningInterval}
+	ignore := func(from net.Addr, reason string) {
+		suppressed, ok := warnings.allow(time.Now())
+		switch {
+		case !ok:
+			log.Debugf(ctx, "Ignoring refresh message from %s: %s", from, reason)
+		case suppressed > 0:
+			log.Warningf(ctx, i18n.G("Ignoring refresh message from %s: %s (%d other messages ignored since the last warning)"), from, reason, suppressed)
+		default:
+			log.Warningf(ctx, i18n.G("Ignoring refresh message from %s: %s"), from, reason)
+		}
+	}
+
+	buf := make([]byte, maxMessageSize)
+	for {
+		if err := conn.SetReadDeadline(time.Now().Add(idleTimeout)); err != nil {
+			return err
+		}
+		n, from, err := conn.ReadFrom(buf)
+		if errors.Is(err, os.ErrDeadlineExceeded) {
+			if warnings.suppressed > 0 {
+				log.Warningf(ctx, i18n.G("%d other refresh messages ignored since the last warning"), warnings.suppressed)
+			}
+			log.Debug(ctx, "No more refresh message")
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		sentAt, maxDelay, err := Verify(key, buf[:n], time.Now())
+		if err != nil {
+			ignore(from, err.Error())
+			continue
+		}
+
+		last, err := lastAccepted(statePath)
+		if err != nil {
+			return err
+		}
+		if !sentAt.After(last) {
+			ignore(from, i18n.G("already received"))
+			continue
+		}
+		if err := os.WriteFile(statePath, []byte(strconv.FormatInt(sentAt.UnixNano(), 10)), 0600); err != nil {
+			return err
+		}
+
+		// #nosec G404 - the delay only spreads the refreshes of the fleet.
+		delay := time.Duration(rand.Int63n(int64(maxDelay) + 1))
+		log.Infof(ctx, i18n.G("Refresh requested by %s: refreshing policies in %s"), from, delay.Round(time.Second))
+		refreshes.Add(1)
+		go func() {
+			defer refreshes.Done()
+			select {
+			case <-time.After(delay):
+			case <-ctx.Done():
+				return
+			}
+			if err := refresh(ctx); err != nil {
+				log.Warningf(ctx, i18n.G("Can't refresh policies: %v"), err)
+			}
+		}()
+	}
+}
+
+// warningLimiter limits how often a warning is logged.
+type warningLimiter struct {
+	interval time.Duration
+	next     time.Time
+	// suppressed is the number of warnings not logged since the last one.
+	suppressed int
+}
+
+// allow returns true if the warning can be logged at now, with the number of warnings suppressed since the last
+// one.
+func (l *warningLimiter) allow(now time.Time) (suppressed int, ok bool) {
+	if now.Before(l.next) {
+		l.suppressed++
+		return 0, false
+	}
+	suppressed, l.suppressed = l.suppressed, 0
+	l.next = now.Add(l.interval)
+	return suppressed, true
+}
+
+// lastAccepted returns the sending time of the last accepted message stored in statePath.
+func lastAccepted(statePath string) (time.Time, error) {
+	d, err := os.ReadFile(statePath)
+	if errors.Is(err, fs.ErrNotExist) {
+		return time.Time{}, nil
+	} else if err != nil {
+		return time.Time{}, err
+	}
+	nanos, err := strconv.ParseInt(strings.TrimSpace(string(d)), 10, 64)
+	if err != nil {
+		// An invalid state is overwritten by the next accepted message.
+		return time.Time{}, nil
+	}
+	return time.Unix(0, nanos), nil
+}
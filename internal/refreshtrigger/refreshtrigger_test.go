@@ -0,0 +1,213 @@
+package refreshtrigger_test
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/ubuntu/adsys/internal/refreshtrigger"
+)
+
+var key = []byte("0123456789abcdef0123456789abcdef")
+
+func TestLoadKey(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		content string
+		noFile  bool
+
+		wantErr bool
+	}{
+		"Load key":                            {content: string(key)},
+		"Surrounding whitespaces are trimmed": {content: "  " + string(key) + "\n"},
+
+		"Error on missing key file": {noFile: true, wantErr: true},
+		"Error on too short key":    {content: "short\n", wantErr: true},
+	}
+	for name, tc := range tests {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			p := filepath.Join(t.TempDir(), "key")
+			if !tc.noFile {
+				require.NoError(t, os.WriteFile(p, []byte(tc.content), 0600), "Setup: can't write key file")
+			}
+
+			got, err := refreshtrigger.LoadKey(p)
+			if tc.wantErr {
+				require.Error(t, err, "LoadKey should return an error but didn't")
+				return
+			}
+			require.NoError(t, err, "LoadKey should not return an error")
+			require.Equal(t, key, got, "LoadKey should return the key without whitespaces")
+		})
+	}
+}
+
+func TestVerify(t *testing.T) {
+	t.Parallel()
+
+	now := time.Now()
+
+	tests := map[string]struct {
+		msg []byte
+
+		wantDelay time.Duration
+		wantErr   bool
+	}{
+		"Valid message":                         {msg: refreshtrigger.Message(key, now, 10*time.Minute), wantDelay: 10 * time.Minute},
+		"Valid message without delay":           {msg: refreshtrigger.Message(key, now, 0)},
+		"Valid message sent recently":           {msg: refreshtrigger.Message(key, now.Add(-4*time.Minute), 0)},
+		"Valid message from slightly fast host": {msg: refreshtrigger.Message(key, now.Add(4*time.Minute), 0)},
+		"Trailing newline is ignored":           {msg: append(refreshtrigger.Message(key, now, 0), '\n')},
+
+		"Error on message signed with another key": {msg: refreshtrigger.Message([]byte("another key of 32 bytes long...."), now, 0), wantErr: true},
+		"Error on message sent too long ago":       {msg: refreshtrigger.Message(key, now.Add(-6*time.Minute), 0), wantErr: true},
+		"Error on message sent in the future":      {msg: refreshtrigger.Message(key, now.Add(6*time.Minute), 0), wantErr: true},
+		"Error on delay above maximum":             {msg: refreshtrigger.Message(key, now, refreshtrigger.MaxDelay+time.Second), wantErr: true},
+		"Error on overflowing delay":               {msg: signedMessage(now, "9223372036854775807"), wantErr: true},
+		"Error on tampered delay":                  {msg: []byte(strings.Replace(string(refreshtrigger.Message(key, now, 60*time.Second)), " 60 ", " 0 ", 1)), wantErr: true},
+		"Error on unexpected prefix":               {msg: []byte(strings.Replace(string(refreshtrigger.Message(key, now, 0)), "adsys-refresh", "other", 1)), wantErr: true},
+		"Error on missing signature":               {msg: []byte("adsys-refresh " + strconv.FormatInt(now.UnixNano(), 10) + " 0"), wantErr: true},
+		"Error on empty message":                   {msg: nil, wantErr: true},
+	}
+	for name, tc := range tests {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			_, gotDelay, err := refreshtrigger.Verify(key, tc.msg, now)
+			if tc.wantErr {
+				require.Error(t, err, "Verify should return an error but didn't")
+				return
+			}
+			require.NoError(t, err, "Verify should not return an error")
+			require.Equal(t, tc.wantDelay, gotDelay, "Verify should return the maximum delay of the message")
+		})
+	}
+}
+
+func TestServe(t *testing.T) {
+	t.Parallel()
+
+	now := time.Now()
+
+	tests := map[string]struct {
+		messages     [][]byte
+		lastAccepted string
+
+		wantRefreshes int
+	}{
+		"Refresh on valid message":                {messages: [][]byte{refreshtrigger.Message(key, now, 0)}, wantRefreshes: 1},
+		"Refresh on each new message":             {messages: [][]byte{refreshtrigger.Message(key, now, 0), refreshtrigger.Message(key, now.Add(time.Second), 0)}, wantRefreshes: 2},
+		"Refresh after the last accepted message": {messages: [][]byte{refreshtrigger.Message(key, now, 0)}, lastAccepted: strconv.FormatInt(now.Add(-time.Second).UnixNano(), 10), wantRefreshes: 1},
+		"Invalid last accepted state is ignored":  {messages: [][]byte{refreshtrigger.Message(key, now, 0)}, lastAccepted: "invalid", wantRefreshes: 1},
+		"Invalid message is ignored":              {messages: [][]byte{[]byte("invalid"), refreshtrigger.Message(key, now, 0)}, wantRefreshes: 1},
+		"Flood of invalid messages is ignored":    {messages: append(invalidMessages(100), refreshtrigger.Message(key, now, 0)), wantRefreshes: 1},
+		"No message does not refresh":             {},
+
+		// Replays
+		"Replayed message is ignored":              {messages: [][]byte{refreshtrigger.Message(key, now, 0), refreshtrigger.Message(key, now, 0)}, wantRefreshes: 1},
+		"Message older than last accepted ignored": {messages: [][]byte{refreshtrigger.Message(key, now, 0)}, lastAccepted: strconv.FormatInt(now.Add(time.Second).UnixNano(), 10)},
+	}
+	for name, tc := range tests {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			statePath := filepath.Join(t.TempDir(), "state")
+			if tc.lastAccepted != "" {
+				require.NoError(t, os.WriteFile(statePath, []byte(tc.lastAccepted), 0600), "Setup: can't write last accepted state")
+			}
+
+			conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+			require.NoError(t, err, "Setup: can't listen on UDP")
+			defer conn.Close()
+
+			sender, err := net.Dial("udp", conn.LocalAddr().String())
+			require.NoError(t, err, "Setup: can't connect to UDP listener")
+			defer sender.Close()
+			for _, m := range tc.messages {
+				_, err := sender.Write(m)
+				require.NoError(t, err, "Setup: can't send message")
+			}
+
+			var mu sync.Mutex
+			var refreshes int
+			err = refreshtrigger.Serve(context.Background(), conn, key, statePath, 200*time.Millisecond, func(context.Context) error {
+				mu.Lock()
+				defer mu.Unlock()
+				refreshes++
+				return nil
+			})
+			require.NoError(t, err, "Serve should not return an error")
+			require.Equal(t, tc.wantRefreshes, refreshes, "Serve should refresh once per accepted message")
+		})
+	}
+}
+
+func TestServeReadsMessagesWhileRefreshing(t *testing.T) {
+	t.Parallel()
+
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err, "Setup: can't listen on UDP")
+	defer conn.Close()
+
+	sender, err := net.Dial("udp", conn.LocalAddr().String())
+	require.NoError(t, err, "Setup: can't connect to UDP listener")
+	defer sender.Close()
+	now := time.Now()
+	for _, m := range [][]byte{refreshtrigger.Message(key, now, 0), refreshtrigger.Message(key, now.Add(time.Second), 0)} {
+		_, err := sender.Write(m)
+		require.NoError(t, err, "Setup: can't send message")
+	}
+
+	// The first refresh only completes once the second one started, which requires reading the second message.
+	var mu sync.Mutex
+	var refreshes int
+	var waitedForSecond bool
+	secondStarted := make(chan struct{})
+	err = refreshtrigger.Serve(context.Background(), conn, key, filepath.Join(t.TempDir(), "state"), 200*time.Millisecond, func(context.Context) error {
+		mu.Lock()
+		refreshes++
+		first := refreshes == 1
+		mu.Unlock()
+		if !first {
+			close(secondStarted)
+			return nil
+		}
+		select {
+		case <-secondStarted:
+			waitedForSecond = true
+			return nil
+		case <-time.After(5 * time.Second):
+			return errors.New("second message not read while refreshing")
+		}
+	})
+	require.NoError(t, err, "Serve should not return an error")
+	require.True(t, waitedForSecond, "Serve should read the next messages while a refresh is pending")
+}
+
+// signedMessage returns a message sent at sentAt with the raw maxDelay field, correctly signed with key.
+func signedMessage(sentAt time.Time, maxDelay string) []byte {
+	payload := "adsys-refresh " + strconv.FormatInt(sentAt.UnixNano(), 10) + " " + maxDelay
+	return []byte(payload + " " + refreshtrigger.Sign(key, payload))
+}
+
+// invalidMessages returns n invalid messages.
+func invalidMessages(n int) (msgs [][]byte) {
+	for i := 0; i < n; i++ {
+		msgs = append(msgs, []byte("invalid"))
+	}
+	return msgs
+}
@@ -0,0 +1,66 @@
+// Package snap detects when adsys runs as a strictly confined snap, like on Ubuntu Core, and provides the
+// locations and restrictions tied to its confinement.
+//
+// Under strict confinement, adsys can only write its own state in the snap writable areas, and read its data
+// from the read-only snap content. System locations, like /etc/dconf or /etc/sudoers.d, are reached through the
+// interfaces declared in the snap. Policy managers which require privileges that a confined snap can't get, like
+// loading AppArmor profiles or managing systemd units, are not supported.
+package snap
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// UnsupportedPolicyManagers are the policy managers which can't be applied under strict confinement.
+var UnsupportedPolicyManagers = []string{"apparmor", "mount", "scripts", "sssd", "netplan", "journald", "laps"}
+
+// Paths are the default locations used by adsys when running as a snap.
+type Paths struct {
+	// Socket is the socket between the daemon and the client.
+	Socket string
+	// CacheDir is where GPOs and policies are cached.
+	CacheDir string
+	// RunDir is where transient information erased on reboot is stored.
+	RunDir string
+	// AuditLog is the file where administrative requests are audited.
+	AuditLog string
+	// ConfigDir is where the configuration file is looked for.
+	ConfigDir string
+	// PluginsDir is where the policy manager plugins shipped with the snap are.
+	PluginsDir string
+	// TransformsDir is where the site-local entry transformation rules are.
+	TransformsDir string
+	// HooksDir is where the executables run after each policy apply are.
+	HooksDir string
+}
+
+// Running returns true if we are running inside a snap.
+func Running() bool {
+	return os.Getenv("SNAP") != "" && os.Getenv("SNAP_NAME") != ""
+}
+
+// DefaultPaths returns the default locations of adsys for the current snap.
+// State kept across revisions is stored in SNAP_COMMON, the configuration in SNAP_DATA and transient
+// information in the snap private runtime directory.
+func DefaultPaths() Paths {
+	snapDir := os.Getenv("SNAP")
+	common := os.Getenv("SNAP_COMMON")
+	data := os.Getenv("SNAP_DATA")
+	instance := os.Getenv("SNAP_INSTANCE_NAME")
+	if instance == "" {
+		instance = os.Getenv("SNAP_NAME")
+	}
+	runDir := filepath.Join("/run", "snap."+instance)
+
+	return Paths{
+		Socket:        filepath.Join(runDir, "adsysd.sock"),
+		CacheDir:      filepath.Join(common, "cache"),
+		RunDir:        filepath.Join(runDir, "adsys"),
+		AuditLog:      filepath.Join(common, "log", "audit.log"),
+		ConfigDir:     data,
+		PluginsDir:    filepath.Join(snapDir, "usr", "lib", "adsys", "plugins"),
+		TransformsDir: filepath.Join(data, "transforms.d"),
+		HooksDir:      filepath.Join(data, "hooks.d"),
+	}
+}
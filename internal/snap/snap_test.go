@@ -0,0 +1,67 @@
+package snap_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/ubuntu/adsys/internal/snap"
+)
+
+func TestRunning(t *testing.T) {
+	tests := map[string]struct {
+		snap     string
+		snapName string
+
+		want bool
+	}{
+		"Running in a snap": {snap: "/snap/adsys/x1", snapName: "adsys", want: true},
+
+		"Not running in a snap": {},
+		"Only SNAP is set":      {snap: "/snap/adsys/x1"},
+		"Only SNAP_NAME is set": {snapName: "adsys"},
+	}
+	for name, tc := range tests {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Setenv("SNAP", tc.snap)
+			t.Setenv("SNAP_NAME", tc.snapName)
+
+			require.Equal(t, tc.want, snap.Running(), "Running returns expected value")
+		})
+	}
+}
+
+func TestDefaultPaths(t *testing.T) {
+	tests := map[string]struct {
+		instanceName string
+
+		wantSocket string
+		wantRunDir string
+	}{
+		"Paths of the snap":                {wantSocket: "/run/snap.adsys/adsysd.sock", wantRunDir: "/run/snap.adsys/adsys"},
+		"Paths of a parallel snap install": {instanceName: "adsys_test", wantSocket: "/run/snap.adsys_test/adsysd.sock", wantRunDir: "/run/snap.adsys_test/adsys"},
+	}
+	for name, tc := range tests {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Setenv("SNAP", "/snap/adsys/x1")
+			t.Setenv("SNAP_NAME", "adsys")
+			t.Setenv("SNAP_INSTANCE_NAME", tc.instanceName)
+			t.Setenv("SNAP_COMMON", "/var/snap/adsys/common")
+			t.Setenv("SNAP_DATA", "/var/snap/adsys/x1")
+
+			got := snap.DefaultPaths()
+
+			require.Equal(t, snap.Paths{
+				Socket:        tc.wantSocket,
+				CacheDir:      "/var/snap/adsys/common/cache",
+				RunDir:        tc.wantRunDir,
+				AuditLog:      "/var/snap/adsys/common/log/audit.log",
+				ConfigDir:     "/var/snap/adsys/x1",
+				PluginsDir:    "/snap/adsys/x1/usr/lib/adsys/plugins",
+				TransformsDir: "/var/snap/adsys/x1/transforms.d",
+				HooksDir:      "/var/snap/adsys/x1/hooks.d",
+			}, got, "DefaultPaths returns expected paths")
+		})
+	}
+}
@@ -1,10 +1,11 @@
 // Package systemd provides a wrapper around systemd dbus API that allows basic
-// service operations (start/stop/enable/disable).
+// service operations (start/stop/restart/enable/disable).
 package systemd
 
 import (
 	"context"
 	"errors"
+	"time"
 
 	systemdDbus "github.com/coreos/go-systemd/v22/dbus"
 	"github.com/godbus/dbus/v5"
@@ -60,6 +61,41 @@ func (s DefaultCaller) StopUnit(ctx context.Context, unit string) (err error) {
 	return nil
 }
 
+// RestartUnit restarts the given unit, or starts it if it is not running.
+func (s DefaultCaller) RestartUnit(ctx context.Context, unit string) (err error) {
+	defer decorate.OnError(&err, i18n.G("failed to restart unit %s"), unit)
+
+	reschan := make(chan string)
+	if _, err = s.conn.RestartUnitContext(ctx, unit, "replace", reschan); err != nil {
+		return err
+	}
+
+	if job := <-reschan; job != jobDone {
+		return errors.New(i18n.G("restart job failed"))
+	}
+	return nil
+}
+
+// StartTransientScope moves the processes pids to the new transient scope unit, which stops them once it runs for
+// more than runtimeMax.
+func (s DefaultCaller) StartTransientScope(ctx context.Context, unit string, pids []uint32, runtimeMax time.Duration) (err error) {
+	defer decorate.OnError(&err, i18n.G("failed to start scope %s"), unit)
+
+	props := []systemdDbus.Property{
+		systemdDbus.PropPids(pids...),
+		{Name: "RuntimeMaxUSec", Value: dbus.MakeVariant(uint64(runtimeMax.Microseconds()))},
+	}
+	reschan := make(chan string)
+	if _, err = s.conn.StartTransientUnitContext(ctx, unit, "fail", props, reschan); err != nil {
+		return err
+	}
+
+	if job := <-reschan; job != jobDone {
+		return errors.New(i18n.G("start job failed"))
+	}
+	return nil
+}
+
 // EnableUnit enables the given unit.
 func (s DefaultCaller) EnableUnit(ctx context.Context, unit string) (err error) {
 	defer decorate.OnError(&err, i18n.G("failed to enable unit %s"), unit)
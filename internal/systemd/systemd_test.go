@@ -5,6 +5,7 @@ import (
 	"flag"
 	"fmt"
 	"testing"
+	"time"
 
 	"github.com/godbus/dbus/v5"
 	"github.com/godbus/dbus/v5/introspect"
@@ -34,8 +35,10 @@ func TestManageUnit(t *testing.T) {
 	}{
 		"Start unit that exists":   {action: "start"},
 		"Stop unit that exists":    {action: "stop"},
+		"Restart unit that exists": {action: "restart"},
 		"Enable unit that exists":  {action: "enable"},
 		"Disable unit that exists": {action: "disable"},
+		"Start scope":              {unitName: "new.scope", action: "start scope"},
 
 		// Error cases
 		"Error when starting unit that doesn't exist": {unitName: absentUnit, action: "start", wantErr: true},
@@ -44,8 +47,13 @@ func TestManageUnit(t *testing.T) {
 		"Error when stopping unit that doesn't exist": {unitName: absentUnit, action: "stop", wantErr: true},
 		"Error when stopping failing unit":            {unitName: failingUnit, action: "stop", wantErr: true},
 
+		"Error when restarting unit that doesn't exist": {unitName: absentUnit, action: "restart", wantErr: true},
+		"Error when restarting failing unit":            {unitName: failingUnit, action: "restart", wantErr: true},
+
 		"Error when enabling unit that doesn't exist":  {unitName: absentUnit, action: "enable", wantErr: true},
 		"Error when disabling unit that doesn't exist": {unitName: absentUnit, action: "disable", wantErr: true},
+
+		"Error when starting failing scope": {unitName: failingUnit, action: "start scope", wantErr: true},
 	}
 
 	for name, tc := range tests {
@@ -64,10 +72,14 @@ func TestManageUnit(t *testing.T) {
 				err = systemdCaller.StartUnit(ctx, tc.unitName)
 			case "stop":
 				err = systemdCaller.StopUnit(ctx, tc.unitName)
+			case "restart":
+				err = systemdCaller.RestartUnit(ctx, tc.unitName)
 			case "enable":
 				err = systemdCaller.EnableUnit(ctx, tc.unitName)
 			case "disable":
 				err = systemdCaller.DisableUnit(ctx, tc.unitName)
+			case "start scope":
+				err = systemdCaller.StartTransientScope(ctx, tc.unitName, []uint32{4242}, time.Minute)
 			default:
 				panic("unknown systemd action")
 			}
@@ -6,6 +6,7 @@ from pwd import getpwuid
 from socket import gethostname
 
 SCOPE_BASE = ""
+FLAG_MOD_REPLACE = 2
 
 def binary_encode(s):
     return s
@@ -73,6 +74,17 @@ accounts = {}
 
 ##############################
 
+class MessageElement:
+    def __init__(self, value, flags, name):
+        self.value = value
+        self.flags = flags
+        self.name = name
+
+
+class Message(dict):
+    dn = None
+
+
 # Only called on user/machine, returns correct account object
 def Dn(samdb, dn):
     return accounts[dn.lower()]
@@ -98,6 +110,9 @@ class OU:
 
         OUs[strdn] = self
 
+    def __str__(self):
+        return self.strdn
+
     def parent(self):
         ppath = path.dirname(self.strdn)
         if ppath == "":
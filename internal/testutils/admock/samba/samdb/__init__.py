@@ -67,6 +67,13 @@ class SamDB:
             return [r]
 
 
+        # Computer attribute
+        elif isinstance(base, str) and base.startswith("hostname"):
+            if "unreadableAttribute" in attrs:
+                raise Exception("insufficient access rights to read unreadableAttribute")
+            values = {"ms-Mcs-AdmPwdExpirationTime": [b"133302096000000000"]}
+            return [{a: values[a] for a in attrs if a in values}]
+
         # GPO Attribute
         gpo = ldb.GPOs[base]
         if gpo.nTSecurityDescriptor[0] == "MISSING":
@@ -74,6 +81,14 @@ class SamDB:
         return [GPOSearch(gpo.name, gpo.display_name, gpo.flags, gpo.nTSecurityDescriptor, gpo.gPCFileSysPath)]
 
 
+    def modify(self, message):
+        for name, element in message.items():
+            # The computer account is not allowed to write this attribute
+            if name == "readOnlyAttribute":
+                raise Exception("insufficient access rights to modify %s" % name)
+            print("Modified %s: %s=%s" % (message.dn, name, element.value))
+
+
     def get_default_basedn(self):
         return ldb.OUs["/example"]
 
@@ -8,6 +8,7 @@ import (
 	"os"
 	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/require"
 	"github.com/termie/go-shutil"
@@ -64,6 +65,11 @@ type MockSystemdCaller struct{}
 
 func (s MockSystemdCaller) StartUnit(_ context.Context, _ string) error   { return nil } //nolint:revive
 func (s MockSystemdCaller) StopUnit(_ context.Context, _ string) error    { return nil } //nolint:revive
+func (s MockSystemdCaller) RestartUnit(_ context.Context, _ string) error { return nil } //nolint:revive
 func (s MockSystemdCaller) EnableUnit(_ context.Context, _ string) error  { return nil } //nolint:revive
 func (s MockSystemdCaller) DisableUnit(_ context.Context, _ string) error { return nil } //nolint:revive
 func (s MockSystemdCaller) DaemonReload(_ context.Context) error          { return nil } //nolint:revive
+
+func (s MockSystemdCaller) StartTransientScope(_ context.Context, _ string, _ []uint32, _ time.Duration) error { //nolint:revive
+	return nil
+}
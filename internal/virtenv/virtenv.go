@@ -0,0 +1,119 @@
+// Package virtenv detects when adsys runs on WSL or in a container, where some policy managers can't work.
+//
+// Those environments share their kernel with the host and generally don't boot like a regular machine: AppArmor
+// profiles can't be loaded, and there is no reliable systemd to run startup scripts or mount network shares.
+// The policies of those managers are skipped, while the others, like dconf, privileges or environment variables,
+// are still applied.
+//
+// The detection can be overridden by the administrator when it is wrong, for instance on a virtual machine exposing
+// container flags, or to skip those managers on an environment which is not detected.
+package virtenv
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/ubuntu/adsys/internal/i18n"
+)
+
+// Kind is the kind of virtualized environment adsys runs in.
+type Kind string
+
+const (
+	// None is a regular machine or virtual machine.
+	None Kind = ""
+	// WSL is the Windows Subsystem for Linux.
+	WSL Kind = "wsl"
+	// Container is a system or application container, like LXD or docker.
+	Container Kind = "container"
+)
+
+// Auto is the setting detecting the environment, and none the one forcing a regular machine.
+const (
+	Auto        = "auto"
+	noneSetting = "none"
+)
+
+// unsupportedPolicyManagers are the policy managers which can't be applied in a virtualized environment.
+var unsupportedPolicyManagers = map[Kind][]string{
+	WSL:       {"apparmor", "mount", "scripts", "netplan"},
+	Container: {"apparmor", "mount", "scripts"},
+}
+
+// containerFlags are the files created by the container managers in the root of the container.
+var containerFlags = []string{
+	"run/systemd/container",
+	"run/.containerenv",
+	".dockerenv",
+}
+
+type options struct {
+	root string
+}
+
+// Option represents an optional function to change detection of the environment.
+type Option func(*options)
+
+// WithRoot specifies a personalized root directory for detection.
+func WithRoot(root string) Option {
+	return func(o *options) {
+		o.root = root
+	}
+}
+
+// Detect returns the kind of environment adsys runs in.
+func Detect(opts ...Option) Kind {
+	args := options{
+		root: "/",
+	}
+	for _, o := range opts {
+		o(&args)
+	}
+
+	if isWSL(args.root) {
+		return WSL
+	}
+
+	for _, f := range containerFlags {
+		if _, err := os.Stat(filepath.Join(args.root, f)); err == nil {
+			return Container
+		}
+	}
+
+	return None
+}
+
+// FromSetting returns the kind of environment set by the administrator: auto, or empty, detects it, none forces a
+// regular machine, and wsl or container force that environment.
+func FromSetting(setting string, opts ...Option) (Kind, error) {
+	switch k := Kind(strings.ToLower(strings.TrimSpace(setting))); k {
+	case "", Auto:
+		return Detect(opts...), nil
+	case noneSetting:
+		return None, nil
+	case WSL, Container:
+		return k, nil
+	default:
+		return None, fmt.Errorf(i18n.G("unknown virtualized environment %q: expected auto, none, wsl or container"), setting)
+	}
+}
+
+// UnsupportedPolicyManagers returns the policy managers which can't be applied in the environment of kind k.
+func UnsupportedPolicyManagers(k Kind) []string {
+	return unsupportedPolicyManagers[k]
+}
+
+// isWSL returns if the kernel is the one of WSL, or if the WSL interoperability is enabled.
+func isWSL(root string) bool {
+	if _, err := os.Stat(filepath.Join(root, "proc/sys/fs/binfmt_misc/WSLInterop")); err == nil {
+		return true
+	}
+
+	d, err := os.ReadFile(filepath.Join(root, "proc/version"))
+	if err != nil {
+		return false
+	}
+	return strings.Contains(strings.ToLower(string(d)), "microsoft")
+}
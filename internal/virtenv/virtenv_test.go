@@ -0,0 +1,95 @@
+package virtenv_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/ubuntu/adsys/internal/virtenv"
+)
+
+func TestDetect(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		files map[string]string
+
+		want virtenv.Kind
+	}{
+		"Regular machine":   {files: map[string]string{"proc/version": "Linux version 6.5.0-generic (buildd@ubuntu)"}, want: virtenv.None},
+		"Nothing to detect": {want: virtenv.None},
+
+		// WSL
+		"WSL kernel":                         {files: map[string]string{"proc/version": "Linux version 5.15.90.1-microsoft-standard-WSL2"}, want: virtenv.WSL},
+		"WSL interoperability":               {files: map[string]string{"proc/sys/fs/binfmt_misc/WSLInterop": "enabled"}, want: virtenv.WSL},
+		"WSL takes precedence on containers": {files: map[string]string{"proc/version": "Linux version 5.15.90.1-microsoft-standard-WSL2", ".dockerenv": ""}, want: virtenv.WSL},
+
+		// Containers
+		"Systemd container": {files: map[string]string{"run/systemd/container": "lxc"}, want: virtenv.Container},
+		"Podman container":  {files: map[string]string{"run/.containerenv": ""}, want: virtenv.Container},
+		"Docker container":  {files: map[string]string{".dockerenv": ""}, want: virtenv.Container},
+	}
+	for name, tc := range tests {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			root := t.TempDir()
+			for p, content := range tc.files {
+				p = filepath.Join(root, p)
+				require.NoError(t, os.MkdirAll(filepath.Dir(p), 0750), "Setup: can't create parent directory")
+				require.NoError(t, os.WriteFile(p, []byte(content), 0600), "Setup: can't create file")
+			}
+
+			got := virtenv.Detect(virtenv.WithRoot(root))
+			require.Equal(t, tc.want, got, "Detect returns expected environment")
+		})
+	}
+}
+
+func TestFromSetting(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		setting string
+
+		want    virtenv.Kind
+		wantErr bool
+	}{
+		"Empty setting detects the environment": {setting: "", want: virtenv.Container},
+		"Auto detects the environment":          {setting: "auto", want: virtenv.Container},
+		"None forces a regular machine":         {setting: "none", want: virtenv.None},
+		"WSL is forced":                         {setting: "wsl", want: virtenv.WSL},
+		"Container is forced":                   {setting: "container", want: virtenv.Container},
+		"Setting is case insensitive":           {setting: " WSL ", want: virtenv.WSL},
+
+		// Error cases
+		"Error on unknown environment": {setting: "vm", wantErr: true},
+	}
+	for name, tc := range tests {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			root := t.TempDir()
+			require.NoError(t, os.WriteFile(filepath.Join(root, ".dockerenv"), nil, 0600), "Setup: can't create container flag")
+
+			got, err := virtenv.FromSetting(tc.setting, virtenv.WithRoot(root))
+			if tc.wantErr {
+				require.Error(t, err, "FromSetting should have failed but didn't")
+				return
+			}
+			require.NoError(t, err, "FromSetting failed but shouldn't have")
+			require.Equal(t, tc.want, got, "FromSetting returns expected environment")
+		})
+	}
+}
+
+func TestUnsupportedPolicyManagers(t *testing.T) {
+	t.Parallel()
+
+	require.Empty(t, virtenv.UnsupportedPolicyManagers(virtenv.None), "All policy managers are supported on a regular machine")
+	require.Contains(t, virtenv.UnsupportedPolicyManagers(virtenv.WSL), "apparmor", "AppArmor is not supported on WSL")
+	require.Contains(t, virtenv.UnsupportedPolicyManagers(virtenv.Container), "apparmor", "AppArmor is not supported in containers")
+}
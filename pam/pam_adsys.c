@@ -1,6 +1,6 @@
 /*
- * This pam module sets DCONF_PROFILE for the user and updates its group
- * policy.
+ * This pam module sets DCONF_PROFILE for the user, updates its group
+ * policy and injects the environment variables requested by it.
  *
  *
  * Copyright (C) 2021 Canonical
@@ -26,6 +26,7 @@
 #define _GNU_SOURCE
 
 #include <errno.h>
+#include <fcntl.h>
 #include <limits.h>
 #include <pwd.h>
 #include <stdio.h>
@@ -46,36 +47,60 @@
 
 #define ADSYS_POLICIES_DIR "/var/cache/adsys/policies/%s"
 #define SSSD_CONF_PATH "/etc/sssd/sssd.conf"
+#define ADSYS_USER_ENV_FILE "/run/adsys/users/%u/environment"
+#define ADSYS_LOCAL_USERS_FILE "/run/adsys/local-users"
+/*
+ * Policies applied more recently than this number of seconds are not refreshed
+ * when switching back to an already opened session.
+ */
+#define DEFAULT_REFRESH_INTERVAL 3600
 
 /*
- * Refresh the group policies of current user
+ * Refresh the group policies of current user.
+ * If if_older_than is not 0, the policies are only refreshed if they were applied more than if_older_than seconds ago,
+ * in the background: the user gets back to their already opened session without waiting for the refresh.
+ * Otherwise, this is a login: the session can start with the cached policies if the refresh exceeds the login timeout.
  */
-static int update_policy(pam_handle_t *pamh, const char *username, const char *krb5ccname, int debug) {
+static int update_policy(pam_handle_t *pamh, const char *username, const char *krb5ccname, int if_older_than,
+                         int debug) {
     int retval;
-    retval = pam_info(pamh, "Applying user settings");
-    if (retval != PAM_SUCCESS) {
-        return retval;
+    if (if_older_than == 0) {
+        retval = pam_info(pamh, "Applying user settings");
+        if (retval != PAM_SUCCESS) {
+            return retval;
+        }
     }
 
-    if (memcmp(krb5ccname, (const char *)"FILE:", 5) == 0) {
+    if (strncmp(krb5ccname, "FILE:", 5) == 0) {
         krb5ccname += 5;
     }
 
+    char if_older_than_arg[sizeof("--if-older-than=") + 3 * sizeof(int)];
+    if (sprintf(if_older_than_arg, "--if-older-than=%d", if_older_than) < 0) {
+        pam_syslog(pamh, LOG_ERR, "Failed to allocate if-older-than argument");
+        return PAM_BUF_ERR;
+    }
+
     char **arggv;
-    arggv = calloc(6, sizeof(char *));
+    arggv = calloc(7, sizeof(char *));
     if (arggv == NULL) {
         return PAM_BUF_ERR;
     }
 
-    arggv[0] = "/sbin/adsysctl";
-    arggv[1] = "update";
-    arggv[2] = (char *)(username);
-    arggv[3] = (char *)(krb5ccname);
-    arggv[4] = NULL;
+    int n = 0;
+    arggv[n++] = "/sbin/adsysctl";
+    arggv[n++] = "update";
+    arggv[n++] = (char *)(username);
+    arggv[n++] = (char *)(krb5ccname);
+    if (if_older_than != 0) {
+        arggv[n++] = if_older_than_arg;
+    } else {
+        arggv[n++] = "--at-login";
+    }
     if (debug) {
-        arggv[4] = "-vv";
-        arggv[5] = NULL;
+        arggv[n++] = "-vv";
     }
+    arggv[n] = NULL;
 
     pid_t pid = fork();
     if (pid == -1) {
@@ -94,6 +119,14 @@ static int update_policy(pam_handle_t *pamh, const char *username, const char *k
             pam_syslog(pamh, LOG_ERR, "waitpid returns with -1: %m");
             free(arggv);
             return PAM_SYSTEM_ERR;
+        } else if (if_older_than != 0) {
+            /* Only the intermediate child was waited for: the refresh result is logged by the daemon. */
+            free(arggv);
+            if (status != 0) {
+                pam_syslog(pamh, LOG_ERR, "Failed to start background refresh of %s", username);
+                return PAM_SYSTEM_ERR;
+            }
+            return PAM_SUCCESS;
         } else if (status != 0) {
             if (WIFEXITED(status)) {
                 pam_syslog(pamh, LOG_ERR, "adsysctl update %s %s failed: exit code %d", username, krb5ccname,
@@ -112,6 +145,31 @@ static int update_policy(pam_handle_t *pamh, const char *username, const char *k
         return PAM_SUCCESS;
 
     } else { /* child */
+        if (if_older_than != 0) {
+            /*
+             * Detach the refresh: the intermediate child exits right away, so that the grandchild running adsysctl
+             * is reparented to init and doesn't hold the authentication.
+             */
+            pid_t bgpid = fork();
+            if (bgpid == -1) {
+                pam_syslog(pamh, LOG_ERR, "Failed to fork background process");
+                _exit(1);
+            }
+            if (bgpid > 0) {
+                _exit(0);
+            }
+            setsid();
+            int fd = open("/dev/null", O_RDWR);
+            if (fd != -1) {
+                dup2(fd, STDIN_FILENO);
+                dup2(fd, STDOUT_FILENO);
+                dup2(fd, STDERR_FILENO);
+                if (fd > STDERR_FILENO) {
+                    close(fd);
+                }
+            }
+        }
+
         if (debug) {
             pam_syslog(pamh, LOG_DEBUG, "Calling %s ...", arggv[0]);
         }
@@ -253,16 +311,50 @@ static char *slash_to_at_username(const char *username) {
     return strdup(username);
 }
 
+/*
+ * Returns 1 if username is a local user receiving the user policies of an account of the directory, by its name or
+ * one of its groups listed with a % prefix in ADSYS_LOCAL_USERS_FILE.
+ */
+static int is_mapped_local_user(pam_handle_t *pamh, const char *username) {
+    FILE *f = fopen(ADSYS_LOCAL_USERS_FILE, "r");
+    if (f == NULL) {
+        // No local user is mapped
+        return 0;
+    }
+
+    int mapped = 0;
+    size_t buffsize = 0;
+    char *buf = NULL;
+    ssize_t n;
+    while (!mapped && (n = getline(&buf, &buffsize, f)) != -1) {
+        if (n > 0 && buf[n - 1] == '\n') {
+            buf[--n] = '\0';
+        }
+        if (n == 0) {
+            continue;
+        }
+        if (buf[0] == '%') {
+            mapped = pam_modutil_user_in_group_nam_nam(pamh, username, buf + 1);
+        } else {
+            mapped = strcasecmp(buf, username) == 0;
+        }
+    }
+    free(buf);
+    fclose(f);
+    return mapped;
+}
+
 /*
  * Set DCONF_PROFILE for current user
+ * The profile of local users is their lowercased name, as they are not in any domain.
  */
-static int set_dconf_profile(pam_handle_t *pamh, const char *username, int debug) {
+static int set_dconf_profile(pam_handle_t *pamh, const char *username, int local_user, int debug) {
     int retval = PAM_SUCCESS;
 
     char *profile_name = slash_to_at_username(username);
 
     // We need to check if the profile name does not already contain the domain.
-    if (strchr(profile_name, '@') == NULL) {
+    if (!local_user && strchr(profile_name, '@') == NULL) {
         char *domain = get_default_sss_domain(pamh);
         if (domain != NULL) {
             free(profile_name);
@@ -292,9 +384,117 @@ static int set_dconf_profile(pam_handle_t *pamh, const char *username, int debug
     return retval;
 }
 
+/*
+ * Set environment variables from the user policy environment file, which is in pam_env envfile format
+ */
+static int set_policy_environment(pam_handle_t *pamh, const char *username, int debug) {
+    struct passwd *pw = pam_modutil_getpwnam(pamh, username);
+    if (pw == NULL) {
+        pam_syslog(pamh, LOG_ERR, "Failed to get user information for %s", username);
+        return PAM_USER_UNKNOWN;
+    }
+
+    char *env_path;
+    if (asprintf(&env_path, ADSYS_USER_ENV_FILE, pw->pw_uid) < 0) {
+        pam_syslog(pamh, LOG_CRIT, "out of memory");
+        return PAM_BUF_ERR;
+    }
+
+    FILE *f = fopen(env_path, "r");
+    if (f == NULL) {
+        // No environment variables requested by the policy
+        free(env_path);
+        return PAM_SUCCESS;
+    }
+
+    int retval = PAM_SUCCESS;
+    size_t buffsize = 0;
+    char *buf = NULL;
+    ssize_t n;
+    while (retval == PAM_SUCCESS && (n = getline(&buf, &buffsize, f)) != -1) {
+        if (n > 0 && buf[n - 1] == '\n') {
+            buf[--n] = '\0';
+        }
+        char *equal = strchr(buf, '=');
+        if (equal == NULL) {
+            continue;
+        }
+        // Strip surrounding quotes from the value
+        char *value = equal + 1;
+        size_t value_len = strlen(value);
+        if (value_len >= 2 && value[0] == '"' && value[value_len - 1] == '"') {
+            value[value_len - 1] = '\0';
+            memmove(value, value + 1, value_len - 1);
+        }
+        if (debug) {
+            pam_syslog(pamh, LOG_DEBUG, "Setting policy environment variable %.*s", (int)(equal - buf), buf);
+        }
+        retval = pam_putenv(pamh, buf);
+    }
+    free(buf);
+    fclose(f);
+    free(env_path);
+    return retval;
+}
+
 PAM_EXTERN int pam_sm_authenticate(pam_handle_t *pamh, int flags, int argc, const char **argv) { return PAM_IGNORE; }
 
-PAM_EXTERN int pam_sm_setcred(pam_handle_t *pamh, int flags, int argc, const char **argv) { return PAM_IGNORE; }
+/*
+ * Credentials are reinitialized when an already logged in user authenticates again, on the lock screen or when
+ * switching back to their session with fast user switching. As no session is opened then, this is where we
+ * re-validate the freshness of the user policies and refresh them if they are stale.
+ */
+PAM_EXTERN int pam_sm_setcred(pam_handle_t *pamh, int flags, int argc, const char **argv) {
+    if (!(flags & (PAM_REINITIALIZE_CRED | PAM_REFRESH_CRED))) {
+        return PAM_IGNORE;
+    }
+
+    int debug = 0;
+    int refresh_interval = DEFAULT_REFRESH_INTERVAL;
+    int optargc;
+
+    for (optargc = 0; optargc < argc; optargc++) {
+        if (strcasecmp(argv[optargc], "debug") == 0) {
+            debug = 1;
+        } else if (strncasecmp(argv[optargc], "refresh_interval=", 17) == 0) {
+            char *end;
+            long v = strtol(argv[optargc] + 17, &end, 10);
+            if (*end != '\0' || v < 0 || v > INT_MAX) {
+                pam_syslog(pamh, LOG_ERR, "Invalid option %s", argv[optargc]);
+                return PAM_IGNORE;
+            }
+            refresh_interval = (int)v;
+        } else {
+            break; /* Unknown option. */
+        }
+    }
+
+    /* A refresh interval of 0 disables the freshness check on session switches. */
+    if (refresh_interval == 0) {
+        return PAM_IGNORE;
+    }
+
+    const char *username;
+    if (pam_get_item(pamh, PAM_USER, (void *)&username) != PAM_SUCCESS) {
+        D(("pam_get_item failed for PAM_USER"));
+        return PAM_SYSTEM_ERR; /* let pam_get_item() log the error */
+    }
+
+    /* As for sessions, KRB5CCNAME is only set by SSSD for remote users. */
+    const char *krb5ccname = pam_getenv(pamh, "KRB5CCNAME");
+    if (krb5ccname == NULL || strcmp(username, "gdm") == 0) {
+        return PAM_IGNORE;
+    }
+
+    /*
+     * The user is already logged in with their previous policies: failing to refresh them
+     * must not prevent them from getting back to their session, nor delay it as the refresh runs in the background.
+     */
+    if (update_policy(pamh, username, krb5ccname, refresh_interval, debug) != PAM_SUCCESS) {
+        pam_syslog(pamh, LOG_WARNING, "Failed to refresh policies of %s, keeping previously applied ones", username);
+    }
+    return PAM_IGNORE;
+}
 
 PAM_EXTERN int pam_sm_open_session(pam_handle_t *pamh, int flags, int argc, const char **argv) {
     int retval = PAM_SUCCESS;
@@ -320,14 +520,20 @@ PAM_EXTERN int pam_sm_open_session(pam_handle_t *pamh, int flags, int argc, cons
      * We consider that KRB5CCNAME is always set by SSSD for remote users
      * We do an exception for GDM which is handled by the machine's GPO
      * and we must set the DCONF_PROFILE environment variable.
+     * Local users mapped to an account of the directory receive its policies without any ticket.
      */
     const char *krb5ccname = pam_getenv(pamh, "KRB5CCNAME");
+    int local_user = 0;
     if (krb5ccname == NULL && strcmp(username, "gdm") != 0) {
-        return PAM_IGNORE;
+        if (!is_mapped_local_user(pamh, username)) {
+            return PAM_IGNORE;
+        }
+        local_user = 1;
+        krb5ccname = "";
     }
 
-    // set dconf profile for AD and gdm user.
-    retval = set_dconf_profile(pamh, username, debug);
+    // set dconf profile for AD, mapped local and gdm users.
+    retval = set_dconf_profile(pamh, username, local_user, debug);
     if (retval != PAM_SUCCESS) {
         return retval;
     };
@@ -361,7 +567,12 @@ PAM_EXTERN int pam_sm_open_session(pam_handle_t *pamh, int flags, int argc, cons
         }
     }
 
-    return update_policy(pamh, username, krb5ccname, debug);
+    retval = update_policy(pamh, username, krb5ccname, 0, debug);
+    if (retval != PAM_SUCCESS) {
+        return retval;
+    }
+
+    return set_policy_environment(pamh, username, debug);
 }
 
 PAM_EXTERN int pam_sm_close_session(pam_handle_t *pamh, int flags, int argc, const char **argv) { return PAM_SUCCESS; }
@@ -0,0 +1,111 @@
+// Package plugin is the SDK to write out-of-tree policy managers for adsys.
+//
+// A plugin is an executable installed in the adsys plugins directory (/usr/lib/adsys/plugins by default).
+// Its file name is the policy type it handles: a plugin named "firewall" receives all the entries under the
+// Software/Policies/Ubuntu/firewall/ registry keys. Policy types handled by adsys itself can't be overridden.
+//
+// On each policy update for a computer or a user, adsys executes every plugin, even when there is no entry
+// for its policy type, so that the plugin can revert any previously applied configuration. The resolved
+// entries are sent as a JSON encoded Request on the plugin standard input. The plugin reports the status
+// of the apply as a JSON encoded Response on its standard output. Anything written on the standard error
+// is logged by adsys.
+//
+// An empty Response error and a zero exit code are a success. Any other outcome is an apply failure and
+// will, as for built-in policy managers, prevent user authentication.
+//
+// Plugins written in Go can use Serve to handle the protocol:
+//
+//	func main() {
+//		plugin.Serve(func(ctx context.Context, req plugin.Request) error {
+//			// Apply req.Entries to req.ObjectName.
+//			return nil
+//		})
+//	}
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// ProtocolVersion is the version of the protocol between adsys and its plugins.
+// It is bumped on any incompatible change of Request or Response.
+const ProtocolVersion = 1
+
+// Entry is a resolved policy entry for the plugin policy type.
+type Entry struct {
+	// Key is the entry key, relative to the policy type, like "default-policy".
+	Key string `json:"key"`
+	// Value is the entry value, after all GPOs are merged.
+	Value string `json:"value"`
+	// Disabled is true when the policy was explicitly disabled.
+	Disabled bool `json:"disabled"`
+	// Meta is the additional metadata attached to the entry by its ADMX definition.
+	Meta string `json:"meta,omitempty"`
+}
+
+// Request is the payload sent by adsys to apply a policy.
+type Request struct {
+	// Version is the protocol version used by adsys.
+	Version int `json:"version"`
+	// ObjectName is the computer or user name the policy applies to.
+	ObjectName string `json:"object_name"`
+	// IsComputer is true when the policy applies to the computer.
+	IsComputer bool `json:"is_computer"`
+	// Entries are the entries to apply, in the order they should be applied. It is empty if
+	// no policy is set anymore.
+	Entries []Entry `json:"entries"`
+}
+
+// Response is the payload sent by the plugin to report the apply status.
+type Response struct {
+	// Version is the protocol version used by the plugin.
+	Version int `json:"version"`
+	// Error is the apply error message. An empty error means the policy was applied successfully.
+	Error string `json:"error,omitempty"`
+}
+
+// ApplyFunc applies the policy request.
+type ApplyFunc func(ctx context.Context, req Request) error
+
+// Run reads a Request from r, calls apply with it and writes the Response to w.
+// The returned error is apply one, or any protocol error.
+func Run(ctx context.Context, r io.Reader, w io.Writer, apply ApplyFunc) error {
+	var req Request
+	if err := json.NewDecoder(r).Decode(&req); err != nil {
+		err = fmt.Errorf("can't decode plugin request: %w", err)
+		return errors.Join(err, writeResponse(w, err))
+	}
+	if req.Version != ProtocolVersion {
+		err := fmt.Errorf("unsupported protocol version %d, expected %d", req.Version, ProtocolVersion)
+		return errors.Join(err, writeResponse(w, err))
+	}
+
+	err := apply(ctx, req)
+	return errors.Join(err, writeResponse(w, err))
+}
+
+// Serve handles the protocol on the standard input and output for apply, then exits.
+// The exit code is non zero if the policy couldn't be applied.
+func Serve(apply ApplyFunc) {
+	if err := Run(context.Background(), os.Stdin, os.Stdout, apply); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	os.Exit(0)
+}
+
+func writeResponse(w io.Writer, applyErr error) error {
+	resp := Response{Version: ProtocolVersion}
+	if applyErr != nil {
+		resp.Error = applyErr.Error()
+	}
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		return fmt.Errorf("can't encode plugin response: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,78 @@
+package plugin_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/ubuntu/adsys/plugin"
+)
+
+func TestRun(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		request  string
+		applyErr error
+
+		wantRequest  plugin.Request
+		wantResponse string
+		wantErr      bool
+	}{
+		"Apply request": {
+			request: `{"version":1,"object_name":"ubuntu","is_computer":true,"entries":[{"key":"default-policy","value":"deny","disabled":false,"meta":"s"}]}`,
+			wantRequest: plugin.Request{Version: 1, ObjectName: "ubuntu", IsComputer: true, Entries: []plugin.Entry{
+				{Key: "default-policy", Value: "deny", Meta: "s"},
+			}},
+			wantResponse: `{"version":1}`,
+		},
+		"Apply request with no entries": {
+			request:      `{"version":1,"object_name":"user@example.com","is_computer":false,"entries":[]}`,
+			wantRequest:  plugin.Request{Version: 1, ObjectName: "user@example.com", Entries: []plugin.Entry{}},
+			wantResponse: `{"version":1}`,
+		},
+
+		// Error cases
+		"Error is reported on apply failing": {
+			request:      `{"version":1,"object_name":"ubuntu","entries":[]}`,
+			applyErr:     errors.New("apply error"),
+			wantRequest:  plugin.Request{Version: 1, ObjectName: "ubuntu", Entries: []plugin.Entry{}},
+			wantResponse: `{"version":1,"error":"apply error"}`,
+			wantErr:      true,
+		},
+		"Error is reported on invalid request": {
+			request:      `not json`,
+			wantResponse: `{"version":1,"error":"can't decode plugin request: invalid character 'o' in literal null (expecting 'u')"}`,
+			wantErr:      true,
+		},
+		"Error is reported on unsupported protocol version": {
+			request:      `{"version":2,"object_name":"ubuntu","entries":[]}`,
+			wantResponse: `{"version":1,"error":"unsupported protocol version 2, expected 1"}`,
+			wantErr:      true,
+		},
+	}
+	for name, tc := range tests {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			var gotRequest plugin.Request
+			var out bytes.Buffer
+			err := plugin.Run(context.Background(), strings.NewReader(tc.request), &out, func(_ context.Context, req plugin.Request) error {
+				gotRequest = req
+				return tc.applyErr
+			})
+			if tc.wantErr {
+				require.Error(t, err, "Run should have failed but didn't")
+			} else {
+				require.NoError(t, err, "Run failed but shouldn't have")
+			}
+
+			require.Equal(t, tc.wantRequest, gotRequest, "Apply should have received the decoded request")
+			require.Equal(t, tc.wantResponse, strings.TrimSpace(out.String()), "Run should have written the expected response")
+		})
+	}
+}